@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 総員退艦
+//
+// 船体がもう持たないときは艦を捨てて脱出できる。助かる見込みは深度 (脱出筒が使えるのは
+// 180 m まで)、海況、味方 (集合地点) までの距離で決まる。結果は戦歴 (campaign.json) に
+// 記録され、ただのゲームオーバーとは別の結末になる。
+
+// 戦歴を保存するファイル名
+const campaignFileName = "campaign.json"
+
+const (
+	// 船体の健全度がこれ以下なら退艦を命じられる
+	abandonHullThreshold = 25.0
+	// 脱出筒で脱出できる最大深度 (m)
+	escapeMaxDepth = 180.0
+	// 退艦の命令を確認するまでの時間
+	abandonConfirmWindow = 5 * time.Second
+)
+
+// 戦歴の1件
+type campaignOutcome struct {
+	At      time.Time `json:"at"`
+	Outcome string    `json:"outcome"`
+	Odds    float64   `json:"odds"`
+	Depth   float64   `json:"depth"`
+	Hull    float64   `json:"hull"`
+	// 沈めた船の名前と、それによる評判の増減 (交戦規則を参照)
+	Target     string  `json:"target,omitempty"`
+	Reputation float64 `json:"reputation,omitempty"`
+}
+
+const (
+	outcomeRescued = "abandoned-rescued"
+	outcomeLost    = "abandoned-lost"
+)
+
+// 艦がもう持たないか
+func (p *Player) doomed() bool {
+	return p.HullIntegrity <= abandonHullThreshold
+}
+
+// 退艦して助かる見込み (0 ~ 1)
+// friendly は味方がいる位置。いなければ救助はほぼ望めない
+func survivalOdds(depth float64, seaState int, distanceToFriendly float64) float64 {
+	odds := 1.0
+	switch {
+	case depth <= periscopeDepth:
+	case depth <= escapeMaxDepth:
+		// 深いほど脱出筒での浮上は危ない
+		odds *= 1 - 0.7*(depth-periscopeDepth)/(escapeMaxDepth-periscopeDepth)
+	default:
+		odds *= 0.02
+	}
+	odds *= math.Max(1-float64(seaState)*0.07, 0.1)
+	if math.IsInf(distanceToFriendly, 1) {
+		odds *= 0.2
+	} else {
+		odds *= math.Exp(-distanceToFriendly / 50000)
+	}
+	return math.Max(math.Min(odds, 1), 0)
+}
+
+type abandonShip struct {
+	events  *eventLog
+	env     *environment
+	beacons *beaconNet
+	rng     *rand.Rand
+	path    string
+	// 画面の表示を変える
+	status func(string)
+
+	mu           sync.Mutex
+	confirmUntil time.Time
+	// 退艦の結果 (outcomeRescued か outcomeLost)。退艦していなければ空
+	outcome string
+}
+
+func newAbandonShip(events *eventLog, env *environment, beacons *beaconNet, rng *rand.Rand, path string, status func(string)) *abandonShip {
+	return &abandonShip{events: events, env: env, beacons: beacons, rng: rng, path: path, status: status}
+}
+
+// 退艦の命令 (orderAbandonShip の処理)
+// 間違えて押さないよう、時間内にもう一度命じたときだけ実行する
+func (a *abandonShip) order(p *Player) error {
+	if !p.doomed() {
+		return orderRefusedError{"the boat is not lost yet"}
+	}
+	now := clock.Now()
+	a.mu.Lock()
+	confirmed := now.Before(a.confirmUntil)
+	a.confirmUntil = now.Add(abandonConfirmWindow)
+	a.mu.Unlock()
+	if !confirmed {
+		return orderRefusedError{"press A again to confirm"}
+	}
+
+	distance := math.Inf(1)
+	for _, pos := range a.beacons.rendezvousPoints() {
+		distance = math.Min(distance, sim.HorizontalDistance(p.Position, pos))
+	}
+	odds := survivalOdds(p.Depth(), a.env.seaState, distance)
+	outcome := campaignOutcome{At: now, Outcome: outcomeLost, Odds: odds, Depth: p.Depth(), Hull: p.HullIntegrity}
+	if a.rng.Float64() < odds {
+		outcome.Outcome = outcomeRescued
+	}
+
+	a.mu.Lock()
+	a.outcome = outcome.Outcome
+	a.mu.Unlock()
+	p.abandoned = true
+	p.Turbine.Order(0)
+	a.events.add(cell.ColorRed, "[ABANDON] All hands abandon ship! Depth %.0f m, sea state %d. Survival odds %.0f%%.", p.Depth(), a.env.seaState, odds*100)
+	if outcome.Outcome == outcomeRescued {
+		a.events.add(cell.ColorGreen, "[ABANDON] The crew has been picked up by friendly forces.")
+		a.status("SHIP ABANDONED - CREW RESCUED - PRESS Q TO QUIT")
+	} else {
+		a.events.add(cell.ColorRed, "[ABANDON] No survivors were found.")
+		a.status("SHIP ABANDONED - LOST WITH ALL HANDS - PRESS Q TO QUIT")
+	}
+	return recordOutcome(a.path, outcome)
+}
+
+// 退艦の結果。退艦していなければ空
+func (a *abandonShip) result() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.outcome
+}
+
+// 戦歴を読む。まだなければ空
+func readCampaign(path string) ([]campaignOutcome, error) {
+	var list []campaignOutcome
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// 戦歴に結果を書き足す
+func recordOutcome(path string, o campaignOutcome) error {
+	list, err := readCampaign(path)
+	if err != nil {
+		return err
+	}
+	list = append(list, o)
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
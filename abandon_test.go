@@ -0,0 +1,108 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 船体が健全なうちは退艦できない
+func TestAbandonShip(t *testing.T) {
+	g := startGame(t)
+	g.advance(time.Second)
+	g.key("a")
+	g.expect("[ORDER] Abandon ship refused: the boat is not lost yet")
+	g.expectNot("[ABANDON]")
+}
+
+func TestSurvivalOdds(t *testing.T) {
+	tests := []struct {
+		depth    float64
+		seaState int
+		distance float64
+		want     float64
+	}{
+		{0, 0, 0, 1},
+		{periscopeDepth, 0, 0, 1},
+		// 脱出筒で浮上する深さの半ばでは 0.35 下がり、限界では 0.7 下がる
+		{(periscopeDepth + escapeMaxDepth) / 2, 0, 0, 0.65},
+		{escapeMaxDepth, 0, 0, 0.3},
+		{escapeMaxDepth + 1, 0, 0, 0.02},
+		{0, 3, 0, 0.79},
+		{0, 20, 0, 0.1},
+		{0, 0, 50000, math.Exp(-1)},
+		{0, 0, math.Inf(1), 0.2},
+		{escapeMaxDepth, 3, math.Inf(1), 0.3 * 0.79 * 0.2},
+	}
+	for _, tt := range tests {
+		if got := survivalOdds(tt.depth, tt.seaState, tt.distance); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("survivalOdds(%v, %d, %v) = %.4f, want %.4f", tt.depth, tt.seaState, tt.distance, got, tt.want)
+		}
+	}
+}
+
+// 退艦は時間内にもう一度命じたときだけ実行し、助かる見込みで結果を決めて戦歴に残す
+func TestAbandonShipOutcome(t *testing.T) {
+	saved := clock
+	t.Cleanup(func() { clock = saved })
+	tests := []struct {
+		name  string
+		depth float64
+		// testSeed の最初の乱数は 0.60
+		want       string
+		wantStatus string
+	}{
+		{"near the surface by the rendezvous", 10, outcomeRescued, "SHIP ABANDONED - CREW RESCUED - PRESS Q TO QUIT"},
+		{"below escape depth", 250, outcomeLost, "SHIP ABANDONED - LOST WITH ALL HANDS - PRESS Q TO QUIT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newFakeClock(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+			clock = fc
+			events, logged := newTestEvents(t)
+			env := defaultEnvironment()
+			rendezvous := &beacon{name: "ALPHA", kind: beaconRendezvous, position: sim.Point3D{X: 100}}
+			beacons := newBeaconNet(events, env, func(sim.Point3D) float64 { return seaAmbientNoise }, []*beacon{rendezvous})
+			path := filepath.Join(t.TempDir(), campaignFileName)
+			var status string
+			a := newAbandonShip(events, env, beacons, rand.New(rand.NewSource(testSeed)), path, func(s string) { status = s })
+
+			p := newTestPlayer()
+			p.Position.Z = -tt.depth
+			if err := a.order(p); err == nil {
+				t.Fatal("abandoned a sound boat")
+			}
+			p.HullIntegrity = abandonHullThreshold
+			for i, wait := range []time.Duration{0, abandonConfirmWindow + time.Second} {
+				fc.Advance(wait)
+				err := a.order(p)
+				if _, ok := err.(orderRefusedError); !ok || err.Error() != "press A again to confirm" {
+					t.Fatalf("order %d: %v, want a request to confirm", i+1, err)
+				}
+			}
+			fc.Advance(abandonConfirmWindow - time.Second)
+			if err := a.order(p); err != nil {
+				t.Fatal(err)
+			}
+
+			if a.result() != tt.want || !p.abandoned || status != tt.wantStatus {
+				t.Errorf("result %q, abandoned %v, status %q", a.result(), p.abandoned, status)
+			}
+			if !logged.contains("[ABANDON] All hands abandon ship!") {
+				t.Errorf("abandon not logged: %q", logged.lines)
+			}
+			list, err := readCampaign(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			odds := survivalOdds(tt.depth, env.seaState, 100)
+			if len(list) != 1 || list[0].Outcome != tt.want || math.Abs(list[0].Odds-odds) > 1e-9 || list[0].Depth != tt.depth || list[0].Hull != abandonHullThreshold {
+				t.Errorf("campaign %+v, want %s with odds %.3f", list, tt.want, odds)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/mum4k/termdash/cell"
+)
+
+// 艦内の空気
+//
+// 潜っている間は乗員が酸素を使い、二酸化炭素を出す。使う量は乗り組んでいる乗員の数で決まる。
+// 空気を入れ替えるには浮上してハッチを開けるか、潜望鏡深度でシュノーケルを揚げる。
+// シュノーケルの吸気の音は大きく、潜望鏡深度より深く潜ると頭部弁が閉じて自動で下ろす。
+// 酸素が足りないか二酸化炭素が多すぎると、乗員は早く疲れる (疲労は事故と見張りに響く)。
+// CO2 スクラバー (machinery.go) を止めると二酸化炭素が早く溜まる。
+
+const (
+	// 外気の酸素と二酸化炭素 (%)
+	atmosphereO2  = 20.9
+	atmosphereCO2 = 0.04
+	// 乗員 1 人が 1 時間に使う酸素と出す二酸化炭素 (艦内の空気に対する %)
+	o2PerPersonHour  = 0.05
+	co2PerPersonHour = 0.0625
+	// 換気で 1 分に外気との差が縮まる割合
+	snorkelVentRate = 0.2
+	hatchVentRate   = 0.5
+	// これより浅ければ浮上していてハッチを開けられる (m)
+	surfacedDepth = 1.0
+	// 換気が要る酸素と二酸化炭素 (%)。警報の既定のしきい値
+	o2Low   = 17.0
+	co2High = 3.0
+	// 乗員の息が苦しくなる酸素と二酸化炭素 (%)
+	o2Hypoxia = 16.0
+	co2Toxic  = 5.0
+	// 息が苦しいときに 1 分で増える疲労 (%)
+	badAirFatigueRate = 2.0
+	// 空気の計器の酸素の目盛りの下端 (%)。これより少ないと乗員は意識を失う
+	o2GaugeFloor = 12.0
+	// 空気の計器の目盛りの数
+	airGaugeWidth = 10
+)
+
+type lifeSupport struct {
+	events *eventLog
+}
+
+func newLifeSupport(events *eventLog) *lifeSupport {
+	return &lifeSupport{events: events}
+}
+
+// 外気を入れられるか。浮上しているかシュノーケルを揚げていれば true
+func (p *Player) ventilating() bool {
+	return p.Depth() <= surfacedDepth || p.snorkel
+}
+
+// 息が苦しい空気か
+func (p *Player) badAir() bool {
+	return p.oxygen < o2Hypoxia || p.co2 >= co2Toxic
+}
+
+// シュノーケルを揚げる・下ろす (orderSnorkel の処理)
+func (l *lifeSupport) setSnorkel(p *Player, raise bool) error {
+	if raise && p.Depth() > periscopeDepth {
+		return orderRefusedError{"too deep to raise the snorkel"}
+	}
+	p.snorkel = raise
+	return nil
+}
+
+// 空気を dt 秒分だけ進める (シミュレーション時間で進める)
+func (l *lifeSupport) step(p *Player, dt float64) {
+	if p.snorkel && p.Depth() > periscopeDepth {
+		p.snorkel = false
+		l.events.add(cell.ColorYellow, "[AIR] Snorkel head valve shut: below periscope depth. Snorkel lowered.")
+	}
+	crew := float64(p.crew.size())
+	p.oxygen = math.Max(p.oxygen-crew*o2PerPersonHour*dt/3600, 0)
+	co2 := crew * co2PerPersonHour * dt / 3600
+	if p.equipmentSecured[equipScrubbers] {
+		co2 *= scrubberlessCO2Factor
+	}
+	p.co2 += co2
+	if p.ventilating() {
+		rate := snorkelVentRate
+		if p.Depth() <= surfacedDepth {
+			rate = hatchVentRate
+		}
+		mix := math.Min(rate*dt/60, 1)
+		p.oxygen += (atmosphereO2 - p.oxygen) * mix
+		p.co2 += (atmosphereCO2 - p.co2) * mix
+	}
+	if p.badAir() {
+		p.crewFatigue = math.Min(p.crewFatigue+badAirFatigueRate*dt/60, 100)
+	}
+}
+
+// 空気の計器の行
+// 例: "O2 19.4% [#######---] CO2 1.9% Snorkel"
+func airLine(p *Player) (string, cell.Color) {
+	filled := int(math.Round(math.Max(math.Min((p.oxygen-o2GaugeFloor)/(atmosphereO2-o2GaugeFloor), 1), 0) * airGaugeWidth))
+	gauge := strings.Repeat(render.glyph("█", "#"), filled) + strings.Repeat(render.glyph("░", "-"), airGaugeWidth-filled)
+	line := fmt.Sprintf("O2 %.1f%% [%s] CO2 %.1f%%", p.oxygen, gauge, p.co2)
+	switch {
+	case p.Depth() <= surfacedDepth:
+		line += " Hatch open"
+	case p.snorkel:
+		line += " Snorkel"
+	}
+	switch {
+	case p.badAir():
+		return line, cell.ColorRed
+	case p.oxygen < o2Low || p.co2 >= co2High:
+		return line, cell.ColorYellow
+	}
+	return line, cell.ColorGreen
+}
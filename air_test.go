@@ -0,0 +1,141 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// 海面ではハッチが開いていて、艦内の空気は外気と同じ
+func TestAir(t *testing.T) {
+	g := startGame(t)
+	g.advance(2 * time.Second)
+	g.expect("O2 20.9%")
+	g.expect("CO2 0.0% Hatch open")
+	// 潜ると換気できず、潜望鏡深度より深いとシュノーケルは揚がらない
+	g.key("d")
+	g.advance(2 * time.Minute)
+	g.expectNot("Hatch open")
+	g.key("^")
+	g.advance(time.Second)
+	g.expect("[ORDER] Raise the snorkel refused: too deep to raise the snorkel")
+}
+
+// 潜っている間は乗員の数に応じて酸素が減り二酸化炭素が溜まる。スクラバーを止めると二酸化炭素が 3 倍で溜まる
+func TestAirSubmerged(t *testing.T) {
+	crew := float64(len(defaultCrew))
+	tests := []struct {
+		name      string
+		hours     float64
+		scrubbers bool
+		wantCO2   float64
+	}{
+		{"one hour", 1, true, atmosphereCO2 + crew*co2PerPersonHour},
+		{"six hours", 6, true, atmosphereCO2 + 6*crew*co2PerPersonHour},
+		{"scrubbers secured", 1, false, atmosphereCO2 + crew*co2PerPersonHour*scrubberlessCO2Factor},
+	}
+	for _, tt := range tests {
+		events, _ := newTestEvents(t)
+		l := newLifeSupport(events)
+		p := newTestPlayer()
+		p.Position.Z = -100
+		p.equipmentSecured[equipScrubbers] = !tt.scrubbers
+		for i := 0; i < int(tt.hours*60); i++ {
+			l.step(p, 60)
+		}
+		if want := atmosphereO2 - tt.hours*crew*o2PerPersonHour; math.Abs(p.oxygen-want) > 1e-9 {
+			t.Errorf("%s: O2 %.3f%%, want %.3f%%", tt.name, p.oxygen, want)
+		}
+		if math.Abs(p.co2-tt.wantCO2) > 1e-9 {
+			t.Errorf("%s: CO2 %.3f%%, want %.3f%%", tt.name, p.co2, tt.wantCO2)
+		}
+	}
+}
+
+// 換気すると外気との差が縮まる。ハッチを開けた方がシュノーケルより早い
+func TestAirVentilation(t *testing.T) {
+	crew := float64(len(defaultCrew))
+	tests := []struct {
+		name    string
+		depth   float64
+		snorkel bool
+		mix     float64
+	}{
+		{"hatch open", 0, false, hatchVentRate},
+		{"snorkel", periscopeDepth, true, snorkelVentRate},
+		{"sealed", periscopeDepth, false, 0},
+	}
+	for _, tt := range tests {
+		events, _ := newTestEvents(t)
+		l := newLifeSupport(events)
+		p := newTestPlayer()
+		p.Position.Z = -tt.depth
+		p.snorkel = tt.snorkel
+		p.oxygen, p.co2 = o2Low, co2High
+		l.step(p, 60)
+
+		o2 := o2Low - crew*o2PerPersonHour/60
+		co2 := co2High + crew*co2PerPersonHour/60
+		o2 += (atmosphereO2 - o2) * tt.mix
+		co2 += (atmosphereCO2 - co2) * tt.mix
+		if math.Abs(p.oxygen-o2) > 1e-9 || math.Abs(p.co2-co2) > 1e-9 {
+			t.Errorf("%s: O2 %.3f%% CO2 %.3f%% after a minute, want %.3f%% %.3f%%", tt.name, p.oxygen, p.co2, o2, co2)
+		}
+	}
+}
+
+// シュノーケルは潜望鏡深度より深いと揚がらず、揚げたまま潜ると頭部弁が閉じて下りる
+func TestSnorkelDepth(t *testing.T) {
+	events, te := newTestEvents(t)
+	l := newLifeSupport(events)
+	p := newTestPlayer()
+	p.Position.Z = -(periscopeDepth + 1)
+	err := l.setSnorkel(p, true)
+	if _, ok := err.(orderRefusedError); !ok || p.snorkel {
+		t.Fatalf("raised below periscope depth: %v", err)
+	}
+
+	p.Position.Z = -periscopeDepth
+	if err := l.setSnorkel(p, true); err != nil || !p.snorkel {
+		t.Fatalf("raising at periscope depth: %v", err)
+	}
+	l.step(p, 1)
+	if !p.snorkel || te.contains("head valve") {
+		t.Fatal("snorkel lowered at periscope depth")
+	}
+	p.Position.Z = -(periscopeDepth + 2)
+	l.step(p, 1)
+	if p.snorkel || p.ventilating() {
+		t.Error("snorkel still up below periscope depth")
+	}
+	if !te.contains("[AIR] Snorkel head valve shut: below periscope depth. Snorkel lowered.") {
+		t.Errorf("head valve not reported: %q", te.lines)
+	}
+}
+
+// 息が苦しい空気では乗員が早く疲れる
+func TestBadAirTiresTheCrew(t *testing.T) {
+	tests := []struct {
+		name        string
+		o2, co2     float64
+		wantFatigue float64
+	}{
+		{"fresh air", atmosphereO2, atmosphereCO2, 0},
+		{"stale but breathable", o2Hypoxia + 0.5, co2Toxic - 0.5, 0},
+		{"hypoxia", o2Hypoxia - 1, atmosphereCO2, 10 * badAirFatigueRate},
+		{"CO2 poisoning", atmosphereO2, co2Toxic + 1, 10 * badAirFatigueRate},
+	}
+	for _, tt := range tests {
+		events, _ := newTestEvents(t)
+		l := newLifeSupport(events)
+		p := newTestPlayer()
+		p.Position.Z = -100
+		p.oxygen, p.co2 = tt.o2, tt.co2
+		for i := 0; i < 10; i++ {
+			l.step(p, 60)
+		}
+		if math.Abs(p.crewFatigue-tt.wantFatigue) > 1e-9 {
+			t.Errorf("%s: fatigue %.1f%% after 10 minutes, want %.1f%%", tt.name, p.crewFatigue, tt.wantFatigue)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 警報の設定
+//
+// 炉心の温度・深度・自艦の雑音・艦内の空気などの警報は、しきい値・有効かどうか・重大度を設定ディレクトリの
+// alarms.json で変えられる。書いた警報の書いた項目だけが既定の設定を置き換える。
+//
+//	{"depth-floor": {"threshold": 250, "severity": "warning"}, "noise-level": {"enabled": false}}
+//
+// 警報はしきい値を超えたときに一度だけ出て、しきい値から hysteresis だけ戻ると解ける。
+
+// 警報の設定を保存するファイル名
+const alarmsFileName = "alarms.json"
+
+type alarmName string
+
+const (
+	alarmReactorTemp alarmName = "reactor-temp"
+	alarmDepthFloor  alarmName = "depth-floor"
+	alarmNoiseLevel  alarmName = "noise-level"
+	alarmFlooding    alarmName = "flooding"
+	alarmOxygenLow   alarmName = "oxygen-low"
+	alarmCO2High     alarmName = "co2-high"
+)
+
+// 重大度。イベントログの色と見出しが変わる
+type alarmSeverity string
+
+const (
+	severityAdvisory alarmSeverity = "advisory"
+	severityCaution  alarmSeverity = "caution"
+	severityWarning  alarmSeverity = "warning"
+)
+
+func (s alarmSeverity) color() cell.Color {
+	switch s {
+	case severityAdvisory:
+		return cell.ColorCyan
+	case severityCaution:
+		return cell.ColorYellow
+	}
+	return cell.ColorRed
+}
+
+type alarmSetting struct {
+	Enabled   bool          `json:"enabled"`
+	Threshold float64       `json:"threshold"`
+	Severity  alarmSeverity `json:"severity"`
+}
+
+// 警報の定義
+type alarmDef struct {
+	name alarmName
+	// 既定の設定と、設定できるしきい値の範囲
+	defaults alarmSetting
+	min, max float64
+	// 警報を解くまでに戻る量
+	hysteresis float64
+	// 値がしきい値を下回ったときに出す (既定は上回ったとき)
+	falling bool
+	// 見張る値
+	value func(p *Player) float64
+	// 警報のメッセージ (値を %.0f などで受ける) と、解けたときのメッセージ
+	raised, cleared string
+}
+
+var alarmDefs = []alarmDef{
+	{
+		name:       alarmReactorTemp,
+		defaults:   alarmSetting{Enabled: true, Threshold: sim.ReactorWarningTemp, Severity: severityCaution},
+		min:        sim.ReactorInletTemp,
+		max:        sim.ReactorScramTemp,
+		hysteresis: 5,
+		value:      func(p *Player) float64 { return p.Reactor.CoreTemp },
+		raised:     "Reactor overtemperature (%.0f K)! Reduce turbine rpm.",
+		cleared:    "[REACTOR] Core temperature back to normal.",
+	},
+	{
+		name:       alarmDepthFloor,
+		defaults:   alarmSetting{Enabled: true, Threshold: testDepth, Severity: severityWarning},
+		min:        10,
+		max:        crushDepth,
+		hysteresis: 5,
+		value:      func(p *Player) float64 { return p.Depth() },
+		raised:     "Below the depth floor (%.0f m)! Come up.",
+		cleared:    "[DEPTH] Back above the depth floor.",
+	},
+	{
+		name:       alarmNoiseLevel,
+		defaults:   alarmSetting{Enabled: true, Threshold: 75, Severity: severityAdvisory},
+		min:        0,
+		max:        120,
+		hysteresis: 3,
+		value:      func(p *Player) float64 { return p.noiseLevel() },
+		raised:     "Own-ship noise %.0f dB! Slow down or rig for quiet.",
+		cleared:    "[SONAR] Own-ship noise back below the alarm level.",
+	},
+	{
+		name:       alarmFlooding,
+		defaults:   alarmSetting{Enabled: true, Threshold: 50, Severity: severityWarning},
+		min:        1,
+		max:        100,
+		hysteresis: 5,
+		value:      func(p *Player) float64 { return worstFlooding(p) },
+		raised:     "Compartment %.0f%% flooded! Blow ballast and keep the pumps running.",
+		cleared:    "[DAMAGE] Flooding back under control.",
+	},
+	{
+		name:       alarmOxygenLow,
+		defaults:   alarmSetting{Enabled: true, Threshold: o2Low, Severity: severityCaution},
+		min:        o2GaugeFloor,
+		max:        atmosphereO2,
+		hysteresis: 0.5,
+		falling:    true,
+		value:      func(p *Player) float64 { return p.oxygen },
+		raised:     "Oxygen down to %.1f%%! Surface or snorkel to ventilate.",
+		cleared:    "[AIR] Oxygen back to normal.",
+	},
+	{
+		name:       alarmCO2High,
+		defaults:   alarmSetting{Enabled: true, Threshold: co2High, Severity: severityCaution},
+		min:        0.5,
+		max:        10,
+		hysteresis: 0.5,
+		value:      func(p *Player) float64 { return p.co2 },
+		raised:     "CO2 up to %.1f%%! Surface or snorkel to ventilate.",
+		cleared:    "[AIR] CO2 back to normal.",
+	},
+}
+
+func findAlarmDef(name alarmName) (alarmDef, bool) {
+	for _, d := range alarmDefs {
+		if d.name == name {
+			return d, true
+		}
+	}
+	return alarmDef{}, false
+}
+
+// alarms.json の 1 項目。書かなかった項目は nil
+type alarmOverride struct {
+	Enabled   *bool          `json:"enabled"`
+	Threshold *float64       `json:"threshold"`
+	Severity  *alarmSeverity `json:"severity"`
+}
+
+type alarmSettings map[alarmName]alarmSetting
+
+// 既定の設定に alarms.json を重ねる。ファイルがなければ既定のまま
+func loadAlarmSettings(path string) (alarmSettings, error) {
+	settings := alarmSettings{}
+	for _, d := range alarmDefs {
+		settings[d.name] = d.defaults
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return settings, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var custom map[alarmName]alarmOverride
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	// エラーが毎回同じになるよう、名前順に処理する
+	names := make([]string, 0, len(custom))
+	for name := range custom {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		d, ok := findAlarmDef(alarmName(name))
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown alarm %q", path, name)
+		}
+		o := custom[alarmName(name)]
+		s := settings[d.name]
+		if o.Enabled != nil {
+			s.Enabled = *o.Enabled
+		}
+		if o.Threshold != nil {
+			if *o.Threshold < d.min || *o.Threshold > d.max {
+				return nil, fmt.Errorf("%s: %s: threshold %g out of range [%g, %g]", path, name, *o.Threshold, d.min, d.max)
+			}
+			s.Threshold = *o.Threshold
+		}
+		if o.Severity != nil {
+			switch *o.Severity {
+			case severityAdvisory, severityCaution, severityWarning:
+			default:
+				return nil, fmt.Errorf("%s: %s: unknown severity %q", path, name, *o.Severity)
+			}
+			s.Severity = *o.Severity
+		}
+		settings[d.name] = s
+	}
+	return settings, nil
+}
+
+// 警報の見張り
+type alarmMonitor struct {
+	events   *eventLog
+	settings alarmSettings
+	raised   map[alarmName]bool
+}
+
+func newAlarmMonitor(events *eventLog, settings alarmSettings) *alarmMonitor {
+	return &alarmMonitor{events: events, settings: settings, raised: map[alarmName]bool{}}
+}
+
+// 自艦の状態を見る (シミュレーション時間で進める)
+func (m *alarmMonitor) step(p *Player) {
+	for _, d := range alarmDefs {
+		s := m.settings[d.name]
+		if !s.Enabled {
+			continue
+		}
+		v := d.value(p)
+		over, back := v >= s.Threshold, v < s.Threshold-d.hysteresis
+		if d.falling {
+			over, back = v <= s.Threshold, v > s.Threshold+d.hysteresis
+		}
+		switch {
+		case !m.raised[d.name] && over:
+			m.raised[d.name] = true
+			m.events.add(s.Severity.color(), "[ALARM] %s - "+d.raised, strings.ToUpper(string(s.Severity)), v)
+		case m.raised[d.name] && back:
+			m.raised[d.name] = false
+			m.events.add(cell.ColorGreen, "%s", d.cleared)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 攻撃の記録と商船の迂回
+//
+// 自艦の魚雷で船を沈めた場所を設定ディレクトリの attacks.json に残し、哨戒をまたいで覚えておく。
+// 商船の航路は起動時と攻撃のたびに、過去の攻撃地点から離れるよう横にずらす。同じ海域で何度も
+// 襲うほど避ける範囲が広がるので、同じ海峡での待ち伏せは続けて効かなくなる。
+// ずらせるのは laneShiftMax までで、それでも避けきれなければ元の航路を使う。
+
+// 攻撃の記録を保存するファイル名
+const attacksFileName = "attacks.json"
+
+const (
+	// 1 回の攻撃で商船が避ける半径 (m) と、その上限
+	attackAvoidRadius    = 4000.0
+	attackAvoidRadiusMax = 12000.0
+	// この距離の中の攻撃は同じ海域とみなして避ける範囲を広げる (m)
+	attackClusterRange = 5000.0
+	// 航路をずらす刻みと最大 (m)
+	laneShiftStep = 1000.0
+	laneShiftMax  = 15000.0
+)
+
+// 攻撃の 1 件
+type attackRecord struct {
+	At     time.Time `json:"at"`
+	X      float64   `json:"x"`
+	Y      float64   `json:"y"`
+	Target string    `json:"target"`
+}
+
+// attacks.json の中身
+type attacksFile struct {
+	Attacks []attackRecord `json:"attacks"`
+}
+
+// 商船が避ける範囲
+type avoidZone struct {
+	center sim.Point3D
+	radius float64
+}
+
+type attackHistory struct {
+	path string
+
+	mu      sync.Mutex
+	records []attackRecord
+}
+
+func newAttackHistory(path string) (*attackHistory, error) {
+	h := &attackHistory{path: path}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		var f attacksFile
+		if err := json.Unmarshal(data, &f); err != nil {
+			return nil, err
+		}
+		h.records = f.Attacks
+	}
+	return h, nil
+}
+
+// 沈めた船 v を記録する
+func (h *attackHistory) record(v vessel) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, attackRecord{At: time.Now(), X: v.position.X, Y: v.position.Y, Target: v.name})
+}
+
+// 商船が避ける範囲。攻撃ごとに、近くの攻撃の数だけ広げる
+func (h *attackHistory) zones() []avoidZone {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var zones []avoidZone
+	for _, r := range h.records {
+		center := sim.Point3D{X: r.X, Y: r.Y}
+		count := 0
+		for _, other := range h.records {
+			if sim.HorizontalDistance(center, sim.Point3D{X: other.X, Y: other.Y}) <= attackClusterRange {
+				count++
+			}
+		}
+		zones = append(zones, avoidZone{center: center, radius: math.Min(attackAvoidRadius*float64(count), attackAvoidRadiusMax)})
+	}
+	return zones
+}
+
+func (h *attackHistory) save() error {
+	h.mu.Lock()
+	data, err := json.MarshalIndent(attacksFile{Attacks: h.records}, "", "  ")
+	h.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(h.path, data, 0644)
+}
+
+// 避ける範囲にかからないよう横にずらした航路と、ずらした距離 (m, 航路の進む向きに右が +)
+// 近い方から左右交互に試し、避けきれなければ元の航路のまま
+func routeAround(l laneConfig, zones []avoidZone) (laneConfig, float64) {
+	dx, dy := l.To[0]-l.From[0], l.To[1]-l.From[1]
+	length := math.Hypot(dx, dy)
+	// 右への単位ベクトル
+	rx, ry := dy/length, -dx/length
+	for shift := 0.0; shift <= laneShiftMax; shift += laneShiftStep {
+		for _, s := range []float64{shift, -shift} {
+			moved := l
+			moved.From = [2]float64{l.From[0] + rx*s, l.From[1] + ry*s}
+			moved.To = [2]float64{l.To[0] + rx*s, l.To[1] + ry*s}
+			if laneClear(moved, zones) {
+				return moved, s
+			}
+		}
+	}
+	return l, 0
+}
+
+// 航路 (幅を含む) が避ける範囲にかからないか
+func laneClear(l laneConfig, zones []avoidZone) bool {
+	for _, z := range zones {
+		if segmentDistance(z.center, l.From, l.To) < z.radius+l.Width/2 {
+			return false
+		}
+	}
+	return true
+}
+
+// p から線分 a-b までの水平距離
+func segmentDistance(p sim.Point3D, a, b [2]float64) float64 {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	t := 0.0
+	if l2 := dx*dx + dy*dy; l2 > 0 {
+		t = math.Max(math.Min(((p.X-a[0])*dx+(p.Y-a[1])*dy)/l2, 1), 0)
+	}
+	return math.Hypot(p.X-(a[0]+dx*t), p.Y-(a[1]+dy*t))
+}
+
+// 航路を避ける範囲に合わせて引き直す。これから出る商船から新しい航路を通る
+func (tr *traffic) reroute(zones []avoidZone) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	for _, lane := range tr.lanes {
+		moved, shift := routeAround(lane.base, zones)
+		if moved.From == lane.From && moved.To == lane.To {
+			continue
+		}
+		lane.laneConfig = moved
+		if shift == 0 {
+			tr.events.add(cell.ColorCyan, "[INTEL] Shipping on the %s lane is back on its usual route.", lane.Name)
+			continue
+		}
+		dx, dy := lane.To[0]-lane.From[0], lane.To[1]-lane.From[1]
+		side := sim.BearingTo(sim.Point3D{}, sim.Point3D{X: dy, Y: -dx})
+		if shift < 0 {
+			side += 180
+		}
+		tr.events.add(cell.ColorCyan, "[INTEL] Shipping on the %s lane is routing %.0f km %s to avoid earlier attacks.", lane.Name, math.Abs(shift)/1000, compassPoint(side))
+	}
+}
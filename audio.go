@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+)
+
+// 音の合図
+//
+// イベントログに出る出来事のうち、気づいてほしいもの (魚雷、警報、新しい航跡など) は端末のベルで知らせる。
+// 合図ごとに鳴らすかどうかと鳴らし方を設定ディレクトリの audio.json で変えられる。書いた合図の書いた項目だけが
+// 既定の設定を置き換える。鳴らし方 (pattern) は短い音 "."、長い音 "-"、間 " " の並びで書く。
+// command を書くと、ベルの代わりにそのコマンドを実行する (本物の音を鳴らしたいとき)。
+//
+//	{"torpedo": {"command": ["paplay", "/usr/share/sounds/torpedo.wav"]}, "contact": {"enabled": false}, "alarm": {"pattern": "- -"}}
+//
+// 合図は順に鳴らし、鳴らしている間に溜まりすぎた合図は捨てる。
+
+// 音の合図の設定を保存するファイル名
+const audioFileName = "audio.json"
+
+const (
+	// 短い音のあとの間、長い音で続けて鳴らす回数と間隔、" " の間
+	cueShortGap    = 150 * time.Millisecond
+	cueLongRings   = 3
+	cueLongSpacing = 60 * time.Millisecond
+	cuePause       = 400 * time.Millisecond
+	// 鳴らすのを待てる合図の数
+	cueQueueLength = 4
+)
+
+type cueName string
+
+const (
+	cueTorpedo      cueName = "torpedo"
+	cueAlarm        cueName = "alarm"
+	cueContact      cueName = "contact"
+	cueHit          cueName = "hit"
+	cueObjective    cueName = "objective"
+	cueOrderRefused cueName = "order-refused"
+	cueGameOver     cueName = "game-over"
+)
+
+type cueSetting struct {
+	Enabled bool     `json:"enabled"`
+	Pattern string   `json:"pattern"`
+	Command []string `json:"command,omitempty"`
+}
+
+// 合図の定義
+type cueDef struct {
+	name     cueName
+	defaults cueSetting
+	// この見出しで始まり、contains を含むイベントで鳴らす
+	prefixes []string
+	contains string
+}
+
+// 上から順に調べ、最初に当てはまった合図だけを鳴らす
+var cueDefs = []cueDef{
+	{
+		name:     cueTorpedo,
+		defaults: cueSetting{Enabled: true, Pattern: "---"},
+		prefixes: []string{"[ALARM]", "[DRILL]"},
+		contains: "Torpedo in the water",
+	},
+	{
+		name:     cueAlarm,
+		defaults: cueSetting{Enabled: true, Pattern: "-"},
+		prefixes: []string{"[ALARM]"},
+	},
+	{
+		name:     cueContact,
+		defaults: cueSetting{Enabled: true, Pattern: "."},
+		prefixes: []string{"[TRACK] New track"},
+	},
+	{
+		name:     cueHit,
+		defaults: cueSetting{Enabled: true, Pattern: ".."},
+		prefixes: []string{"[WEAPONS]"},
+		contains: "torpedo hit",
+	},
+	{
+		name:     cueObjective,
+		defaults: cueSetting{Enabled: true, Pattern: ". ."},
+		prefixes: []string{"[MISSION]", "[OBJECTIVE]", "[SCENARIO] All objectives"},
+	},
+	{
+		name:     cueOrderRefused,
+		defaults: cueSetting{Enabled: false, Pattern: "."},
+		prefixes: []string{"[ORDER]"},
+		contains: " refused: ",
+	},
+	{
+		name:     cueGameOver,
+		defaults: cueSetting{Enabled: true, Pattern: "- - -"},
+		prefixes: []string{"[GAME OVER]"},
+	},
+}
+
+func (d cueDef) matches(msg string) bool {
+	if d.contains != "" && !strings.Contains(msg, d.contains) {
+		return false
+	}
+	for _, p := range d.prefixes {
+		if strings.HasPrefix(msg, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func findCueDef(name cueName) (cueDef, bool) {
+	for _, d := range cueDefs {
+		if d.name == name {
+			return d, true
+		}
+	}
+	return cueDef{}, false
+}
+
+// audio.json の 1 項目。書かなかった項目は nil
+type cueOverride struct {
+	Enabled *bool     `json:"enabled"`
+	Pattern *string   `json:"pattern"`
+	Command *[]string `json:"command"`
+}
+
+type cueSettings map[cueName]cueSetting
+
+// 鳴らし方に使えない文字があればエラー
+func checkCuePattern(pattern string) error {
+	if strings.Trim(pattern, ".- ") != "" {
+		return fmt.Errorf("pattern %q: use '.' (short), '-' (long) and ' ' (pause)", pattern)
+	}
+	return nil
+}
+
+// 既定の設定に audio.json を重ねる。ファイルがなければ既定のまま
+func loadCueSettings(path string) (cueSettings, error) {
+	settings := cueSettings{}
+	for _, d := range cueDefs {
+		settings[d.name] = d.defaults
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return settings, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var custom map[cueName]cueOverride
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	// エラーが毎回同じになるよう、名前順に処理する
+	names := make([]string, 0, len(custom))
+	for name := range custom {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		d, ok := findCueDef(cueName(name))
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown cue %q", path, name)
+		}
+		o := custom[cueName(name)]
+		s := settings[d.name]
+		if o.Enabled != nil {
+			s.Enabled = *o.Enabled
+		}
+		if o.Pattern != nil {
+			if err := checkCuePattern(*o.Pattern); err != nil {
+				return nil, fmt.Errorf("%s: %s: %v", path, name, err)
+			}
+			s.Pattern = *o.Pattern
+		}
+		if o.Command != nil {
+			s.Command = *o.Command
+		}
+		settings[d.name] = s
+	}
+	return settings, nil
+}
+
+// 合図を鳴らす
+type audioCues struct {
+	settings cueSettings
+	// ベルを書き出す先。nil なら鳴らさない (テスト中やブラウザだけのとき)
+	bell  io.Writer
+	queue chan cueSetting
+	// コマンドの失敗を知らせる。nil なら知らせない
+	events *eventLog
+	failed map[string]bool
+}
+
+func newAudioCues(settings cueSettings, bell io.Writer) *audioCues {
+	return &audioCues{settings: settings, bell: bell, queue: make(chan cueSetting, cueQueueLength), failed: map[string]bool{}}
+}
+
+// イベントログの行 msg に合う合図を鳴らす (eventLog.cue に渡す)
+// 待たずに戻り、鳴らしきれない合図は捨てる
+func (a *audioCues) cue(msg string) {
+	for _, d := range cueDefs {
+		if !d.matches(msg) {
+			continue
+		}
+		s := a.settings[d.name]
+		if !s.Enabled || (a.bell == nil && len(s.Command) == 0) {
+			return
+		}
+		select {
+		case a.queue <- s:
+		default:
+		}
+		return
+	}
+}
+
+// 溜まった合図を順に鳴らす
+func (a *audioCues) run(ctx context.Context) {
+	for {
+		select {
+		case s := <-a.queue:
+			if len(s.Command) > 0 {
+				a.command(s.Command)
+			} else {
+				a.ring(s.Pattern)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (a *audioCues) ring(pattern string) {
+	for _, c := range pattern {
+		switch c {
+		case '.':
+			io.WriteString(a.bell, "\a")
+			time.Sleep(cueShortGap)
+		case '-':
+			for i := 0; i < cueLongRings; i++ {
+				io.WriteString(a.bell, "\a")
+				time.Sleep(cueLongSpacing)
+			}
+			time.Sleep(cueShortGap)
+		case ' ':
+			time.Sleep(cuePause)
+		}
+	}
+}
+
+// 外部コマンドで鳴らす。終わるまで次の合図は待たせる
+// 失敗はコマンドごとに一度だけイベントログに出す
+func (a *audioCues) command(args []string) {
+	out, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+	if err == nil || a.failed[args[0]] {
+		return
+	}
+	a.failed[args[0]] = true
+	if msg := strings.TrimSpace(string(out)); msg != "" {
+		err = fmt.Errorf("%v: %s", err, msg)
+	}
+	logs.warnf("audio", "cue command %s failed: %v", args[0], err)
+	if a.events != nil {
+		a.events.add(cell.ColorYellow, "[AUDIO] Cue command %s failed: %v", args[0], err)
+	}
+}
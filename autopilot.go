@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 自動操舵
+//
+// 針路保持と速力保持の 2 つがあり、どちらも簡単な PID 制御で舵角とタービン回転数の設定値を決める。
+//   - 針路保持: 命令された針路 (命令していなければ入れたときの艦首方位) に舵を取る。- / = で針路を変えればそれに従う
+//   - 速力保持: 入れたときの速力を保つよう回転数を決める。行き足がないと入れられない
+//   - 航路: 針路保持の針路を、航海図に置いた次の変針点 (route.go) への方位にする
+//
+// 手で舵を取ると針路保持 (と航路) が、針路を命じると航路が、回転数を命じるか機関を止めると速力保持が切れる。
+// 燃料切れやスクラムで回転数を上げられなくなったときも速力保持は切れる。状態はコンパスに出す。
+
+const (
+	// 針路保持の利き (舵角 / 度、舵角 / (度・秒)、舵角・秒 / 度)
+	headingHoldP = 3.0
+	headingHoldI = 0.05
+	headingHoldD = 3.0
+	// 針路の誤差がこの角度 (度) より大きいあいだは積分しない。大きく回頭したあとの行き過ぎを防ぐ
+	headingHoldBand = 5.0
+	// 速力保持の利き (rpm / ノット、rpm / (ノット・秒))
+	speedHoldP = 0.3
+	speedHoldI = 0.05
+	// 回転数 1 rpm で釣り合う速力 (ノット)。速力保持はこれで決めた回転数に PID の分を足す
+	knotsPerRpm = 1.166
+	// 速力保持を入れられる最低の速力 (ノット)
+	speedHoldMinimum = 1.0
+)
+
+// 簡単な PID 制御
+type pidController struct {
+	kp, ki, kd float64
+	// 出力の上限 (絶対値)。越えているあいだは積分しない
+	limit float64
+	// 誤差がこの幅の中にあるときだけ積分する。0 なら幅を設けない
+	band float64
+
+	integral float64
+	last     float64
+	primed   bool
+}
+
+// 積分と微分をやり直す
+func (c *pidController) reset() {
+	c.integral, c.last, c.primed = 0, 0, false
+}
+
+// 誤差 e を dt 秒ぶん進め、出力を返す
+func (c *pidController) update(e, dt float64) float64 {
+	derivative := 0.0
+	if c.primed && dt > 0 {
+		derivative = (e - c.last) / dt
+	}
+	c.last, c.primed = e, true
+	out := c.kp*e + c.kd*derivative
+	integral := c.integral + e*dt
+	inBand := c.band == 0 || math.Abs(e) < c.band
+	// 出力が上限に張り付いているあいだは、戻す向きにだけ積分する
+	if u := out + c.ki*integral; inBand && (math.Abs(u) < c.limit || math.Signbit(u) != math.Signbit(e)) {
+		c.integral = integral
+	}
+	return out + c.ki*c.integral
+}
+
+type autopilot struct {
+	events *eventLog
+	route  *route
+
+	heading pidController
+	speed   pidController
+}
+
+func newAutopilot(events *eventLog, r *route) *autopilot {
+	return &autopilot{
+		events:  events,
+		route:   r,
+		heading: pidController{kp: headingHoldP, ki: headingHoldI, kd: headingHoldD, limit: 35, band: headingHoldBand},
+		speed:   pidController{kp: speedHoldP, ki: speedHoldI, limit: 200},
+	}
+}
+
+// 針路保持を入れる・切る (orderHeadingHold の処理)
+func (a *autopilot) holdHeading(p *Player, o order) error {
+	p.headingHold = o.Value != 0
+	if !p.headingHold {
+		p.followRoute = false
+	}
+	if p.headingHold && !p.courseOrdered {
+		p.Course.Order(p.Direction)
+		p.courseOrdered = true
+	}
+	a.heading.reset()
+	return nil
+}
+
+// 速力保持を入れる・切る (orderSpeedHold の処理)
+func (a *autopilot) holdSpeed(p *Player, o order) error {
+	if o.Value == 0 {
+		p.speedHold = false
+		return nil
+	}
+	if p.Velocity < speedHoldMinimum {
+		return orderRefusedError{"no way on"}
+	}
+	// 回転数を命じられない状態 (機関の停止・燃料切れ・スクラム) なら入れない
+	if err := (order{Kind: orderTurbineRpm, Value: p.Turbine.Ordered}).apply(p); err != nil {
+		return err
+	}
+	p.speedHold = true
+	p.Speed.Order(math.Round(p.Velocity*10) / 10)
+	a.speed.reset()
+	return nil
+}
+
+// 航路に沿って進む・やめる (orderFollowRoute の処理)。針路保持も入れる
+func (a *autopilot) followRoute(p *Player, o order) error {
+	if o.Value == 0 {
+		p.followRoute = false
+		return nil
+	}
+	if _, ok := a.route.next(); !ok {
+		return orderRefusedError{"no waypoints"}
+	}
+	p.followRoute = true
+	if !p.headingHold {
+		p.headingHold = true
+		a.heading.reset()
+	}
+	return nil
+}
+
+// 手で出した命令と競う保持を切る (命令が出るたびに呼ぶ)
+func (a *autopilot) override(p *Player, o order) {
+	switch o.Kind {
+	case orderRudder:
+		if p.headingHold {
+			p.headingHold, p.followRoute = false, false
+			a.events.add(cell.ColorYellow, "[AUTOPILOT] Heading hold off: manual rudder.")
+		}
+	case orderCourse:
+		if p.followRoute {
+			p.followRoute = false
+			a.events.add(cell.ColorYellow, "[AUTOPILOT] Route off: manual course.")
+		}
+	case orderTurbineRpm, orderSecureMachinery:
+		if p.speedHold {
+			p.speedHold = false
+			a.events.add(cell.ColorYellow, "[AUTOPILOT] Speed hold off: manual %s.", o)
+		}
+	}
+}
+
+// 舵角と回転数の設定値を dt 秒ぶん決め直す (シミュレーション時間で進める)
+func (a *autopilot) step(p *Player, dt float64) {
+	if p.abandoned || p.gameOver {
+		return
+	}
+	if p.followRoute {
+		if w, ok := a.route.next(); ok {
+			p.Course.Order(sim.BearingTo(p.Position, w.position()))
+			p.courseOrdered = true
+		}
+	}
+	if p.headingHold {
+		p.Rudder.Order(a.heading.update(p.Course.Error(), dt))
+	}
+	if p.speedHold {
+		rpm := p.Speed.Ordered/knotsPerRpm + a.speed.update(p.Speed.Error(), dt)
+		if err := (order{Kind: orderTurbineRpm, Value: rpm}).apply(p); err != nil {
+			p.speedHold = false
+			a.events.add(cell.ColorYellow, "[AUTOPILOT] Speed hold off: %v.", err)
+		}
+	}
+}
+
+// コンパスに出す自動操舵の状態。例: "AP  HDG 090 SPD ORD 60.0 kt  ACT 59.6 kt"、航路に沿っていれば "AP  RTE 045 ..."
+func autopilotLine(p *Player) string {
+	if !p.headingHold && !p.speedHold {
+		return "AP  off"
+	}
+	line := "AP "
+	switch {
+	case p.followRoute:
+		line += fmt.Sprintf(" RTE %03.0f", p.orderedCourse())
+	case p.headingHold:
+		line += fmt.Sprintf(" HDG %03.0f", p.orderedCourse())
+	}
+	if p.speedHold {
+		line += " SPD " + p.Speed.Label("%.1f kt")
+	}
+	return line
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 自動操舵は切れている
+func TestAutopilot(t *testing.T) {
+	g := startGame(t)
+	g.advance(time.Second)
+	g.expect("AP  off")
+	// 行き足がなければ速力保持は入らない
+	g.typeText("}")
+	g.advance(time.Second)
+	g.expect("[ORDER] Speed hold on refused: no way on")
+	// 増速して針路 030 を命令し、針路保持を入れると舵を取って針路に乗る
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.advance(30 * time.Second)
+	g.typeText("======")
+	g.typeText("{")
+	g.advance(time.Second)
+	g.expect("[ORDER] Heading hold on")
+	g.expect("AP  HDG 030")
+	g.advance(90 * time.Second)
+	g.expect("CRS ORD 030")
+	g.expect("on course")
+	// 速力保持を入れるとコンパスに保つ速力と今の速力が出る
+	g.typeText("}")
+	g.advance(time.Second)
+	g.expect("[ORDER] Speed hold on")
+	g.expect("SPD ORD")
+	// 手で舵を取る・回転数を命じると切れる
+	g.key("left")
+	g.advance(time.Second)
+	g.expect("[AUTOPILOT] Heading hold off: manual rudder.")
+	g.key("up")
+	g.advance(time.Second)
+	g.expect("[AUTOPILOT] Speed hold off: manual Turbine rpm")
+	g.expect("AP  off")
+}
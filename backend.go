@@ -0,0 +1,22 @@
+package main
+
+import (
+	"github.com/mum4k/termdash/terminal/tcell"
+	"github.com/mum4k/termdash/terminal/termbox"
+	"github.com/mum4k/termdash/terminal/terminalapi"
+)
+
+// 端末の描画に使うライブラリ (-backend)
+// termbox が既定。termbox で色や罫線が崩れる端末では tcell を試す
+const (
+	backendTermbox = "termbox"
+	backendTcell   = "tcell"
+)
+
+// ネイティブ端末を開く
+func newNativeTerminal(backend string) (terminalapi.Terminal, error) {
+	if backend == backendTcell {
+		return tcell.New()
+	}
+	return termbox.New()
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 浮上中はメインバラストタンクが空
+func TestBallast(t *testing.T) {
+	g := startGame(t)
+	g.advance(time.Second)
+	g.expect("Main ballast ORD 0%  ACT 0%")
+	// D で注水すると潜航する
+	g.key("d")
+	g.expect("[ORDER] Flood main ballast")
+	g.advance(30 * time.Second)
+	g.expect("Main ballast ORD 100%  ACT 100%")
+	g.expectNot("Depth 0.0 m")
+	// S でブローすると浮上する
+	g.key("s")
+	g.expect("[ORDER] Blow main ballast")
+	g.advance(90 * time.Second)
+	g.expect("Main ballast ORD 0%  ACT 0%")
+	g.expect("Depth 0.0 m")
+}
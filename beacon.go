@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 音響ビーコン
+//
+// 問い合わせ信号を送ると、届く範囲のビーコンが応答し、その往復時間から距離と方位がわかる。
+// 届くかどうかは伝搬モデルで決まるので、変温層をまたいだり海が荒れたりすると遠くのビーコンは答えない。
+// 集合地点や機雷原の安全航路の目印、切り離した ROV の回収に使う。
+
+const (
+	// 水中の音速 (m/s)
+	soundSpeed = 1500.0
+	// 最初から積んでいる投下用ビーコンの数
+	beaconSpareCount = 4
+)
+
+type beaconKind int
+
+const (
+	// 集合地点
+	beaconRendezvous beaconKind = iota
+	// 機雷原の安全航路
+	beaconSafeLane
+	// 切り離した ROV
+	beaconROV
+	// 自艦が投下したもの
+	beaconDeployed
+)
+
+func (k beaconKind) String() string {
+	switch k {
+	case beaconRendezvous:
+		return "RV"
+	case beaconSafeLane:
+		return "LANE"
+	case beaconROV:
+		return "ROV"
+	}
+	return "DROP"
+}
+
+type beacon struct {
+	id       int
+	name     string
+	kind     beaconKind
+	position sim.Point3D
+}
+
+// ビーコンからの応答
+type beaconReply struct {
+	beacon  *beacon
+	bearing float64
+	rng     float64
+	// 届くシミュレーション上の時刻
+	due time.Duration
+	// 届いた時刻
+	at time.Time
+}
+
+// 海域にあるビーコンと、自艦の問い合わせ結果
+type beaconNet struct {
+	events *eventLog
+	env    *environment
+	// 背景雑音 (dB)
+	noise func(sim.Point3D) float64
+
+	mu      sync.Mutex
+	beacons []*beacon
+	// 届く前の応答
+	pending []beaconReply
+	// 最後に届いた応答 (ビーコンごと)
+	replies map[int]beaconReply
+	spare   int
+	nextID  int
+	// シミュレーション上の時刻
+	now time.Duration
+}
+
+// placed は最初から海域に置かれているビーコン
+// noise は受信位置での背景雑音を返す
+func newBeaconNet(events *eventLog, env *environment, noise func(sim.Point3D) float64, placed []*beacon) *beaconNet {
+	n := &beaconNet{
+		events:  events,
+		env:     env,
+		noise:   noise,
+		replies: map[int]beaconReply{},
+		spare:   beaconSpareCount,
+	}
+	for _, b := range placed {
+		n.add(b)
+	}
+	return n
+}
+
+// 既定で置かれているビーコン
+func defaultBeacons() []*beacon {
+	return []*beacon{
+		{name: "ALPHA", kind: beaconRendezvous, position: sim.Point3D{X: 3000, Y: 4000, Z: -100}},
+		{name: "LANE 1", kind: beaconSafeLane, position: sim.Point3D{X: -6000, Y: 2500, Z: -250}},
+		{name: "LANE 2", kind: beaconSafeLane, position: sim.Point3D{X: -6000, Y: 5500, Z: -250}},
+	}
+}
+
+// n.mu を保持した状態で呼ぶか、共有前に呼ぶ
+func (n *beaconNet) add(b *beacon) {
+	n.nextID++
+	b.id = n.nextID
+	n.beacons = append(n.beacons, b)
+}
+
+// 現在位置にビーコンを投下する
+func (n *beaconNet) deploy(p *Player) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.spare == 0 {
+		return orderRefusedError{"no beacons left"}
+	}
+	n.spare--
+	b := &beacon{
+		name:     fmt.Sprintf("DROP %d", beaconSpareCount-n.spare),
+		kind:     beaconDeployed,
+		position: p.Position,
+	}
+	n.add(b)
+	n.events.add(cell.ColorGreen, "[BEACON] %s deployed. %d left.", b.name, n.spare)
+	return nil
+}
+
+// 集合地点の位置 (味方が待っている場所)
+func (n *beaconNet) rendezvousPoints() []sim.Point3D {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var points []sim.Point3D
+	for _, b := range n.beacons {
+		if b.kind == beaconRendezvous {
+			points = append(points, b.position)
+		}
+	}
+	return points
+}
+
+// 問い合わせ信号を送る
+// 応答は往復の伝搬時間が経ってから届く
+func (n *beaconNet) interrogate(p *Player) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	noise := n.noise(p.Position)
+	reply := sonarTarget{sourceLevel: beaconSourceLevel}
+	for _, b := range n.beacons {
+		if beaconLink.signalExcess(n.env, p.Position, b.position, reply, noise) < 0 {
+			continue
+		}
+		rng := sim.HorizontalDistance(p.Position, b.position)
+		delay := time.Duration(2 * rng / soundSpeed * float64(time.Second))
+		n.pending = append(n.pending, beaconReply{
+			beacon:  b,
+			bearing: sim.BearingTo(p.Position, b.position),
+			rng:     rng,
+			due:     n.now + delay,
+		})
+	}
+	return nil
+}
+
+// シミュレーション上の時刻 now までに届いた応答を取り込む
+func (n *beaconNet) step(now time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.now = now
+	remaining := n.pending[:0]
+	for _, r := range n.pending {
+		if r.due > now {
+			remaining = append(remaining, r)
+			continue
+		}
+		r.at = clock.Now()
+		n.replies[r.beacon.id] = r
+		n.events.add(cell.ColorGreen, "[BEACON] %s %s: bearing %03.0f, range %.1f km", r.beacon.kind, r.beacon.name, r.bearing, r.rng/1000)
+	}
+	n.pending = remaining
+}
+
+// 応答を近い順に返す
+func (n *beaconNet) latestReplies() []beaconReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	list := make([]beaconReply, 0, len(n.replies))
+	for _, r := range n.replies {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].rng < list[j].rng })
+	return list
+}
+
+func (n *beaconNet) spareCount() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.spare
+}
+
+// ビーコンパネルの表示
+// 方位のほかに、艦首からの相対方位 (右が +) を出すので、それが 0 になるよう舵を取ればよい
+func beaconPanel(f *frame, n *beaconNet) panelText {
+	p := &f.player
+	var out panelText
+	out.write(fmt.Sprintf("Spare beacons: %d   [B] drop  [I] interrogate\n", n.spareCount()), cell.ColorDefault)
+	replies := n.latestReplies()
+	if len(replies) == 0 {
+		out.write("No replies.\n", cell.ColorYellow)
+	}
+	for _, r := range replies {
+		relative := sim.NormalizeRelative(r.bearing - p.Direction)
+		line := fmt.Sprintf("%-4s %-7s %03.0f° (%+04.0f) %6.2f km  %s ago\n",
+			r.beacon.kind, r.beacon.name, r.bearing, relative, r.rng/1000, f.now.Sub(r.at).Truncate(time.Second))
+		out.write(line, cell.ColorGreen)
+	}
+	return out
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 問い合わせると伝搬時間の後に応答が返る (ALPHA は 5 km 先なので約 6.7 秒)
+func TestBeacons(t *testing.T) {
+	g := startGame(t)
+	g.expect("No replies.")
+	g.key("i")
+	g.advance(5 * time.Second)
+	g.expectNot("ALPHA")
+	g.advance(3 * time.Second)
+	g.expect("ALPHA")
+	g.expect("037°")
+
+	// 投下したビーコンはすぐ応答する
+	g.key("b")
+	g.expect("DROP 1 deployed. 3 left.")
+	g.key("i")
+	g.advance(time.Second)
+	g.expect("DROP 1")
+}
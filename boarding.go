@@ -0,0 +1,380 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 臨検
+//
+// シナリオの inspections に書いた不審船を止めて調べる。手順は次のとおり。
+//   - 呼びかけ: 浮上して hailRange 以内に近づいて呼びかけると、しばらくして不審船が停船する
+//   - 乗艦: 停船した不審船に boardingRange 以内まで寄せ、行き足を止めて乗艦班を送る
+//   - 捜索: 乗艦班が船倉を調べる。結果はシナリオに書いたとおり (禁制品・待ち伏せ・何もなし)
+//
+// 禁制品が見つかれば拿捕するか放すか、待ち伏せに遭えば戦い続けるか引き揚げるかを艦長が決める。
+// 乗艦班は応急班から出すので、待ち伏せでは応急の配置の乗員が怪我をする。
+// 乗艦班の内火艇は自艦が浮上していないと行き来できない。
+
+const (
+	// 呼びかけの届く距離 (m)
+	hailRange = 2000.0
+	// 乗艦班を送れる距離 (m) と自艦の行き足 (ノット)
+	boardingRange       = 200.0
+	boardingMaxVelocity = 2.0
+	// 呼びかけてから停船するまで、内火艇が行き来する時間、捜索にかかる時間 (シミュレーション時間)
+	heaveToTime        = time.Minute
+	boardingCrossTime  = 2 * time.Minute
+	boardingSearchTime = 5 * time.Minute
+	// 待ち伏せは捜索を始めてこれだけ経つと起きる
+	ambushTime = time.Minute
+	// 待ち伏せで戦い続けたとき、船を押さえるまでの時間
+	boardingFightTime = 3 * time.Minute
+	// 待ち伏せから逃げる不審船の速力 (ノット)
+	suspectFleeSpeed = 18.0
+	// 禁制品を書かなかったときの積み荷
+	defaultContraband = "undeclared weapons"
+)
+
+// 捜索の結果
+const (
+	findingContraband = "contraband"
+	findingAmbush     = "ambush"
+	findingNothing    = "nothing"
+)
+
+// 臨検する不審船
+type inspectionConfig struct {
+	Name   string  `json:"name"`
+	Class  string  `json:"class,omitempty"`
+	Flag   string  `json:"flag,omitempty"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Course float64 `json:"course"`
+	Speed  float64 `json:"speed"`
+	// 捜索の結果 (空は何もなし)
+	Finding string `json:"finding,omitempty"`
+	// 禁制品の中身
+	Cargo string `json:"cargo,omitempty"`
+	// 拿捕するか何もないと確かめたときに達成になる目標
+	Objective string `json:"objective,omitempty"`
+}
+
+// 書き間違いを report で挙げる (scenarioConfig.problems から呼ぶ)
+func (c inspectionConfig) problems(report func(format string, args ...interface{}), onMap func(x, y float64) bool, objectives map[string]bool) {
+	if c.Name == "" {
+		report("inspection: missing name")
+	}
+	if !knownClass(c.Class) {
+		report("inspection %q: unknown class %q", c.Name, c.Class)
+	}
+	if !knownFlag(c.Flag) {
+		report("inspection %q: unknown flag %q", c.Name, c.Flag)
+	}
+	if !onMap(c.X, c.Y) {
+		report("inspection %q: (%.0f, %.0f) is off the map", c.Name, c.X, c.Y)
+	}
+	if c.Speed < 0 {
+		report("inspection %q: negative speed", c.Name)
+	}
+	switch c.Finding {
+	case "", findingContraband, findingAmbush, findingNothing:
+	default:
+		report("inspection %q: unknown finding %q", c.Name, c.Finding)
+	}
+	if c.Objective != "" && !objectives[c.Objective] {
+		report("inspection %q: unknown objective %q", c.Name, c.Objective)
+	}
+}
+
+// 臨検の段階
+type inspectionStage int
+
+const (
+	stageUnhailed inspectionStage = iota
+	// 呼びかけて停船を待っている
+	stageHailed
+	// 停船して乗艦班を待っている
+	stageHoveTo
+	// 乗艦班が不審船へ向かっている
+	stageCrossing
+	// 乗艦班が船倉を調べている
+	stageSearching
+	// 禁制品か待ち伏せで、艦長の判断を待っている
+	stageDecision
+	// 待ち伏せで戦い続けている
+	stageFighting
+	// 乗艦班が自艦へ戻っている
+	stageReturning
+	stageDone
+)
+
+type suspect struct {
+	inspectionConfig
+	// traffic の船の id
+	id    int
+	stage inspectionStage
+	// 今の段階の残り時間 (秒)
+	remaining float64
+	// 捜索を始めてからの時間 (秒)
+	searched float64
+	ambushed bool
+	// 乗艦班が戻ったら臨検をやり直せる (途中で呼び戻したとき)
+	retry bool
+	// 内火艇が行き来できないことを知らせたか
+	warned bool
+}
+
+// 禁制品の中身
+func (s *suspect) cargo() string {
+	if s.Cargo == "" {
+		return defaultContraband
+	}
+	return s.Cargo
+}
+
+type boardingParty struct {
+	events   *eventLog
+	traffic  *traffic
+	crew     *crewRoster
+	complete func(objective string)
+
+	mu       sync.Mutex
+	suspects []*suspect
+	// 臨検している不審船。していなければ nil
+	active *suspect
+}
+
+// 不審船を海域に出す。complete はシナリオの目標を達成にする
+func newBoardingParty(list []inspectionConfig, events *eventLog, tr *traffic, crew *crewRoster, complete func(string)) *boardingParty {
+	b := &boardingParty{events: events, traffic: tr, crew: crew, complete: complete}
+	for _, c := range list {
+		class := c.Class
+		if class == "" {
+			class = "Merchant"
+		}
+		id := tr.spawnAt(c.Name, class, c.Flag, sim.Point3D{X: c.X, Y: c.Y}, c.Course, c.Speed)
+		b.suspects = append(b.suspects, &suspect{inspectionConfig: c, id: id})
+	}
+	return b
+}
+
+// 臨検を次の段階に進める (orderBoard の処理)
+// 呼びかけ、乗艦班の派遣、拿捕 (待ち伏せなら戦い続ける) の順に進む
+func (b *boardingParty) proceed(p *Player) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.active
+	if s == nil {
+		return b.hail(p)
+	}
+	v, ok := b.traffic.vessel(s.id)
+	if !ok {
+		return orderRefusedError{s.Name + " is no longer afloat"}
+	}
+	switch s.stage {
+	case stageHailed:
+		return orderRefusedError{s.Name + " has not hove to yet"}
+	case stageHoveTo:
+		switch {
+		case p.Depth() > surfacedDepth:
+			return orderRefusedError{"the boat must be on the surface to send the boarding party"}
+		case sim.HorizontalDistance(p.Position, v.position) > boardingRange:
+			return orderRefusedError{"not alongside " + s.Name}
+		case p.Velocity > boardingMaxVelocity:
+			return orderRefusedError{"the boat is making way"}
+		}
+		s.stage, s.remaining = stageCrossing, boardingCrossTime.Seconds()
+		b.events.add(cell.ColorCyan, "[BOARDING] Boarding party away to %s.", s.Name)
+	case stageDecision:
+		if s.ambushed {
+			s.stage, s.remaining = stageFighting, boardingFightTime.Seconds()
+			b.events.add(cell.ColorYellow, "[BOARDING] Boarding party fights for control of %s.", s.Name)
+			return nil
+		}
+		b.seize(s)
+	default:
+		return orderRefusedError{"the boarding party is away"}
+	}
+	return nil
+}
+
+// いちばん近い呼びかけていない不審船に停船を命じる (b.mu を保持した状態で呼ぶ)
+func (b *boardingParty) hail(p *Player) error {
+	if p.Depth() > surfacedDepth {
+		return orderRefusedError{"the boat must be on the surface to hail"}
+	}
+	var nearest *suspect
+	dist := math.Inf(1)
+	for _, s := range b.suspects {
+		if s.stage != stageUnhailed {
+			continue
+		}
+		v, ok := b.traffic.vessel(s.id)
+		if !ok {
+			continue
+		}
+		if r := sim.HorizontalDistance(p.Position, v.position); r < dist {
+			nearest, dist = s, r
+		}
+	}
+	if nearest == nil || dist > hailRange {
+		return orderRefusedError{"no suspect vessel within hailing range"}
+	}
+	b.active = nearest
+	nearest.stage, nearest.remaining = stageHailed, heaveToTime.Seconds()
+	b.events.add(cell.ColorCyan, "[BOARDING] Hailing %s: heave to for inspection.", nearest.Name)
+	return nil
+}
+
+// 臨検を取りやめる (orderWithdraw の処理)
+// 乗艦班が出ていれば呼び戻し、禁制品が見つかっていれば船を放し、待ち伏せなら引き揚げる
+func (b *boardingParty) withdraw(p *Player) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.active
+	if s == nil {
+		return orderRefusedError{"no inspection under way"}
+	}
+	switch s.stage {
+	case stageHailed, stageHoveTo:
+		b.release(s)
+		s.stage = stageUnhailed
+		b.active = nil
+		b.events.add(cell.ColorYellow, "[BOARDING] Inspection of %s called off. She resumes her course.", s.Name)
+	case stageCrossing, stageSearching:
+		s.stage, s.remaining, s.retry = stageReturning, boardingCrossTime.Seconds(), true
+		b.events.add(cell.ColorYellow, "[BOARDING] Boarding party recalled from %s.", s.Name)
+	case stageDecision, stageFighting:
+		s.stage, s.remaining = stageReturning, boardingCrossTime.Seconds()
+		if s.ambushed {
+			b.traffic.steer(s.id, s.Course, suspectFleeSpeed, 0, merchantNoise)
+			b.events.add(cell.ColorRed, "[BOARDING] Boarding party withdraws from %s under fire. She is making a run for it.", s.Name)
+			return nil
+		}
+		b.release(s)
+		b.events.add(cell.ColorYellow, "[BOARDING] %s released with her cargo.", s.Name)
+	default:
+		return orderRefusedError{"the boarding party is already returning"}
+	}
+	return nil
+}
+
+// 不審船を元の針路・速力に戻す (b.mu を保持した状態で呼ぶ)
+func (b *boardingParty) release(s *suspect) {
+	b.traffic.steer(s.id, s.Course, s.Speed, 0, merchantNoise)
+}
+
+// 不審船を拿捕して港へ回航させる (b.mu を保持した状態で呼ぶ)
+func (b *boardingParty) seize(s *suspect) {
+	b.traffic.setFlag(s.id, friendlyFlag)
+	b.release(s)
+	s.stage, s.remaining = stageReturning, boardingCrossTime.Seconds()
+	b.events.add(cell.ColorGreen, "[BOARDING] %s seized. A prize crew takes her to port.", s.Name)
+	b.finish(s)
+}
+
+// 臨検を終えた不審船の目標を達成にする (b.mu を保持した状態で呼ぶ)
+func (b *boardingParty) finish(s *suspect) {
+	if s.Objective != "" {
+		b.complete(s.Objective)
+	}
+}
+
+// 臨検を dt 秒分だけ進める (シミュレーション時間で進める)
+func (b *boardingParty) step(p *Player, dt float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.active
+	if s == nil {
+		return
+	}
+	v, ok := b.traffic.vessel(s.id)
+	if !ok {
+		s.stage = stageDone
+		b.active = nil
+		b.events.add(cell.ColorYellow, "[BOARDING] Inspection of %s ended: she is no longer afloat.", s.Name)
+		return
+	}
+
+	// 内火艇は浮上している自艦から出入りする。乗艦するときは横付けしていなければならない
+	switch {
+	case s.stage == stageCrossing && (p.Depth() > surfacedDepth || sim.HorizontalDistance(p.Position, v.position) > boardingRange):
+		b.hold(s, "[BOARDING] The boat's launch can't reach %s. Come alongside on the surface.")
+		return
+	case s.stage == stageReturning && p.Depth() > surfacedDepth:
+		b.hold(s, "[BOARDING] The boarding party from %s can't get back aboard. Surface to recover them.")
+		return
+	}
+	s.warned = false
+
+	switch s.stage {
+	case stageHailed:
+		if s.remaining -= dt; s.remaining <= 0 {
+			b.traffic.steer(s.id, v.course, 0, 0, merchantNoise)
+			s.stage = stageHoveTo
+			b.events.add(cell.ColorCyan, "[BOARDING] %s has hove to. Come alongside and send the boarding party.", s.Name)
+		}
+	case stageCrossing:
+		if s.remaining -= dt; s.remaining <= 0 {
+			s.stage, s.remaining, s.searched = stageSearching, boardingSearchTime.Seconds(), 0
+			b.events.add(cell.ColorCyan, "[BOARDING] Boarding party aboard %s. Searching the holds.", s.Name)
+		}
+	case stageSearching:
+		s.searched += dt
+		if s.Finding == findingAmbush && s.searched >= ambushTime.Seconds() {
+			s.stage, s.ambushed = stageDecision, true
+			b.traffic.setFlag(s.id, hostileFlag)
+			b.crew.injureBoardingParty(injuryLight, "ambushed aboard "+s.Name)
+			b.events.add(cell.ColorRed, "[BOARDING] Ambush aboard %s! The boarding party is under fire. Board to fight on, or withdraw.", s.Name)
+			return
+		}
+		if s.remaining -= dt; s.remaining > 0 {
+			return
+		}
+		if s.Finding == findingContraband {
+			s.stage = stageDecision
+			b.events.add(cell.ColorYellow, "[BOARDING] Contraband found aboard %s: %s. Board to seize her, or withdraw to let her go.", s.Name, s.cargo())
+			return
+		}
+		s.stage, s.remaining = stageReturning, boardingCrossTime.Seconds()
+		b.release(s)
+		b.events.add(cell.ColorGreen, "[BOARDING] Search of %s complete: nothing found. She is free to go.", s.Name)
+		b.finish(s)
+	case stageFighting:
+		if s.remaining -= dt; s.remaining <= 0 {
+			b.crew.injureBoardingParty(injurySerious, "firefight aboard "+s.Name)
+			b.events.add(cell.ColorYellow, "[BOARDING] %s secured after a firefight.", s.Name)
+			b.seize(s)
+		}
+	case stageReturning:
+		if s.remaining -= dt; s.remaining <= 0 {
+			s.stage = stageDone
+			if s.retry {
+				b.release(s)
+				s.stage, s.retry = stageUnhailed, false
+			}
+			b.active = nil
+			b.events.add(cell.ColorCyan, "[BOARDING] Boarding party back aboard.")
+		}
+	}
+}
+
+// 内火艇が行き来できないことを一度だけ知らせる (b.mu を保持した状態で呼ぶ)
+func (b *boardingParty) hold(s *suspect, format string) {
+	if !s.warned {
+		s.warned = true
+		b.events.add(cell.ColorYellow, format, s.Name)
+	}
+}
+
+// 乗艦班の乗員を怪我させる。乗艦班は応急班から出すので、応急の配置の乗員が怪我をする
+func (r *crewRoster) injureBoardingParty(severity injury, cause string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.injure(r.assigned[stationDamageControl], severity, cause)
+}
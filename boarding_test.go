@@ -0,0 +1,229 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 不審船のいない海では、呼びかける相手も取りやめる臨検もない
+func TestBoarding(t *testing.T) {
+	g := startGame(t)
+	g.advance(time.Second)
+	g.key("0")
+	g.expect("[ORDER] Board refused: no suspect vessel within hailing range")
+	g.key(")")
+	g.expect("[ORDER] Withdraw refused: no inspection under way")
+	g.expectNot("[BOARDING]")
+}
+
+// (0, 1000) を東へ 10 ノットで走る不審船 1 隻の臨検
+func newTestBoarding(t *testing.T, c inspectionConfig) (*boardingParty, *traffic, *crewRoster, *[]string, *testEvents) {
+	t.Helper()
+	events, logged := newTestEvents(t)
+	tr := newTraffic(trafficConfig{}, nil, events, defaultEnvironment(), rand.New(rand.NewSource(testSeed)))
+	crew := newCrewRoster(events)
+	var completed []string
+	c.Name, c.Y, c.Course, c.Speed, c.Objective = "MV Suspect", 1000, 90, 10, "inspect"
+	b := newBoardingParty([]inspectionConfig{c}, events, tr, crew, func(o string) { completed = append(completed, o) })
+	return b, tr, crew, &completed, logged
+}
+
+// 臨検を seconds 秒だけ 1 秒ずつ進める
+func runBoarding(b *boardingParty, p *Player, seconds float64) {
+	for s := 0.0; s < seconds; s++ {
+		b.step(p, 1)
+	}
+}
+
+// 呼びかけて停船させ、横付けして乗艦班を送り、船倉を調べ始めるところまで進める
+func boardSuspect(t *testing.T, b *boardingParty, tr *traffic) *Player {
+	t.Helper()
+	p := newTestPlayer()
+	p.Position = sim.Point3D{Y: 100}
+	if err := b.proceed(p); err != nil {
+		t.Fatal(err)
+	}
+	runBoarding(b, p, heaveToTime.Seconds())
+	if v, _ := tr.vessel(1); v.speed != 0 {
+		t.Fatalf("suspect still making %.1f m/s after heaving to", v.speed)
+	}
+	p.Position.Y = 900
+	if err := b.proceed(p); err != nil {
+		t.Fatal(err)
+	}
+	runBoarding(b, p, boardingCrossTime.Seconds())
+	return p
+}
+
+func TestBoardingNothingFound(t *testing.T) {
+	b, tr, crew, completed, logged := newTestBoarding(t, inspectionConfig{Finding: findingNothing})
+	p := boardSuspect(t, b, tr)
+	if !logged.contains("[BOARDING] Boarding party aboard MV Suspect. Searching the holds.") {
+		t.Fatalf("search not started: %q", logged.lines)
+	}
+	runBoarding(b, p, boardingSearchTime.Seconds())
+	if !logged.contains("[BOARDING] Search of MV Suspect complete: nothing found. She is free to go.") {
+		t.Fatalf("search not finished: %q", logged.lines)
+	}
+	if v, _ := tr.vessel(1); v.speed != 10*knot || v.course != 90 {
+		t.Errorf("released suspect at %.0f° %.1f m/s, want her own course and speed", v.course, v.speed)
+	}
+	if len(*completed) != 1 || (*completed)[0] != "inspect" {
+		t.Errorf("completed objectives %v", *completed)
+	}
+	runBoarding(b, p, boardingCrossTime.Seconds())
+	if b.active != nil || !logged.contains("[BOARDING] Boarding party back aboard.") {
+		t.Errorf("boarding party not back aboard: %q", logged.lines)
+	}
+	if len(crew.casualties) != 0 {
+		t.Errorf("casualties %v", crew.casualties)
+	}
+	// 臨検を終えた船にはもう呼びかけない
+	err := b.proceed(p)
+	if _, ok := err.(orderRefusedError); !ok {
+		t.Errorf("hailing again: %v", err)
+	}
+}
+
+// 禁制品が見つかれば、拿捕すると味方の船になり目標を達成する
+func TestBoardingContrabandSeized(t *testing.T) {
+	b, tr, _, completed, logged := newTestBoarding(t, inspectionConfig{Finding: findingContraband, Cargo: "mines"})
+	p := boardSuspect(t, b, tr)
+	runBoarding(b, p, boardingSearchTime.Seconds())
+	if !logged.contains("[BOARDING] Contraband found aboard MV Suspect: mines.") || len(*completed) != 0 {
+		t.Fatalf("contraband not found: %q, completed %v", logged.lines, *completed)
+	}
+	if err := b.proceed(p); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := tr.vessel(1); v.flag != friendlyFlag || v.speed != 10*knot {
+		t.Errorf("seized suspect flies %q at %.1f m/s", v.flag, v.speed)
+	}
+	if len(*completed) != 1 {
+		t.Errorf("completed objectives %v", *completed)
+	}
+}
+
+// 禁制品が見つかっても、取りやめれば積み荷ごと放して目標は達成しない
+func TestBoardingContrabandReleased(t *testing.T) {
+	b, tr, _, completed, logged := newTestBoarding(t, inspectionConfig{Finding: findingContraband})
+	p := boardSuspect(t, b, tr)
+	runBoarding(b, p, boardingSearchTime.Seconds())
+	if err := b.withdraw(p); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := tr.vessel(1); v.flag == friendlyFlag || v.speed != 10*knot {
+		t.Errorf("released suspect flies %q at %.1f m/s", v.flag, v.speed)
+	}
+	if !logged.contains("[BOARDING] MV Suspect released with her cargo.") || len(*completed) != 0 {
+		t.Errorf("events %q, completed %v", logged.lines, *completed)
+	}
+}
+
+// 待ち伏せでは応急の配置の乗員が怪我をし、引き揚げると不審船は逃げる
+func TestBoardingAmbush(t *testing.T) {
+	tests := []struct {
+		name     string
+		fight    bool
+		flag     string
+		speed    float64
+		injury   injury
+		complete int
+	}{
+		{"withdraw", false, hostileFlag, suspectFleeSpeed * knot, injuryLight, 0},
+		{"fight on", true, friendlyFlag, 10 * knot, injurySerious, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, tr, crew, completed, logged := newTestBoarding(t, inspectionConfig{Finding: findingAmbush})
+			p := boardSuspect(t, b, tr)
+			runBoarding(b, p, ambushTime.Seconds())
+			if !logged.contains("[BOARDING] Ambush aboard MV Suspect!") {
+				t.Fatalf("no ambush: %q", logged.lines)
+			}
+			if tt.fight {
+				if err := b.proceed(p); err != nil {
+					t.Fatal(err)
+				}
+				runBoarding(b, p, boardingFightTime.Seconds())
+			} else if err := b.withdraw(p); err != nil {
+				t.Fatal(err)
+			}
+			if v, _ := tr.vessel(1); v.flag != tt.flag || v.speed != tt.speed {
+				t.Errorf("suspect flies %q at %.1f m/s, want %q at %.1f m/s", v.flag, v.speed, tt.flag, tt.speed)
+			}
+			if got := crew.members[crew.assigned[stationDamageControl]].injury; got != tt.injury {
+				t.Errorf("damage control hand is %v, want %v", got, tt.injury)
+			}
+			if len(*completed) != tt.complete {
+				t.Errorf("completed objectives %v", *completed)
+			}
+		})
+	}
+}
+
+// 潜っていると内火艇は出入りできず、浮上するまで臨検は進まない
+func TestBoardingLaunchNeedsTheSurface(t *testing.T) {
+	b, tr, _, _, logged := newTestBoarding(t, inspectionConfig{})
+	p := newTestPlayer()
+	p.Position = sim.Point3D{Y: 900}
+	b.proceed(p)
+	runBoarding(b, p, heaveToTime.Seconds())
+	b.proceed(p)
+	p.Position.Z = -20
+	runBoarding(b, p, 2*boardingCrossTime.Seconds())
+	if b.active.stage != stageCrossing {
+		t.Fatalf("stage %v while submerged, want crossing", b.active.stage)
+	}
+	if strings.Count(strings.Join(logged.lines, "\n"), "The boat's launch can't reach MV Suspect") != 1 {
+		t.Errorf("launch warning not given once: %q", logged.lines)
+	}
+	p.Position.Z = 0
+	runBoarding(b, p, boardingCrossTime.Seconds())
+	if b.active.stage != stageSearching {
+		t.Errorf("stage %v after surfacing, want searching", b.active.stage)
+	}
+	if v, _ := tr.vessel(1); v.speed != 0 {
+		t.Errorf("suspect making %.1f m/s during the inspection", v.speed)
+	}
+}
+
+func TestBoardingRefused(t *testing.T) {
+	tests := []struct {
+		name string
+		// 呼びかけて停船させてから乗艦班を送るか
+		hoveTo   bool
+		pos      sim.Point3D
+		velocity float64
+		reason   string
+	}{
+		{"hail submerged", false, sim.Point3D{Y: 900, Z: -20}, 0, "the boat must be on the surface to hail"},
+		{"hail out of range", false, sim.Point3D{Y: 1000 - hailRange - 100}, 0, "no suspect vessel within hailing range"},
+		{"board submerged", true, sim.Point3D{Y: 900, Z: -20}, 0, "the boat must be on the surface to send the boarding party"},
+		{"board from afar", true, sim.Point3D{Y: 500}, 0, "not alongside MV Suspect"},
+		{"board under way", true, sim.Point3D{Y: 900}, 5, "the boat is making way"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, _, _, _, logged := newTestBoarding(t, inspectionConfig{})
+			p := newTestPlayer()
+			if tt.hoveTo {
+				p.Position = sim.Point3D{Y: 900}
+				b.proceed(p)
+				runBoarding(b, p, heaveToTime.Seconds())
+			}
+			p.Position, p.Velocity = tt.pos, tt.velocity
+			err := b.proceed(p)
+			if _, ok := err.(orderRefusedError); !ok || err.Error() != tt.reason {
+				t.Errorf("proceed = %v, want %q", err, tt.reason)
+			}
+			if logged.contains("[BOARDING] Boarding party away") {
+				t.Error("boarding party sent")
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"math"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 海域の海底の地形
+// 起動時に world.Generate で作った地形に差し替える
+var terrain sim.Seabed = sim.GentleSeabed{}
+
+// シミュレーションで起きた着底・離底などの出来事をイベントログに出す
+// 激しく座礁した場所は海図に書き込む
+func reportSimEvents(events *eventLog, ch *chart, simEvents []sim.Event) {
+	for _, e := range simEvents {
+		switch e.Kind {
+		case sim.EventHardGrounding:
+			events.add(cell.ColorRed, "[ALARM] Hard grounding on %s at %.0f m! Hull integrity %.0f%%", e.Bottom, e.Seabed, e.Hull)
+			ch.markGrounding(e.Position, e.Bottom)
+		case sim.EventSettled:
+			events.add(cell.ColorGreen, "[DEPTH] Settled on the bottom (%s) at %.0f m.", e.Bottom, e.Seabed)
+		case sim.EventClearOfBottom:
+			events.add(cell.ColorGreen, "[DEPTH] Clear of the bottom.")
+		case sim.EventScraping:
+			events.add(cell.ColorRed, "[ALARM] Scraping on %s! Hull integrity %.0f%%", e.Bottom, e.Hull)
+		case sim.EventLiftOffComplete:
+			events.add(cell.ColorGreen, "[DEPTH] Lift-off complete. Trimming back to neutral.")
+		case sim.EventCollision:
+			events.add(cell.ColorRed, "[ALARM] Collision with the seabed (%s slope)! Hull integrity %.0f%%", e.Bottom, e.Hull)
+			ch.markGrounding(e.Position, e.Bottom)
+		case sim.EventFuelExhausted:
+			events.add(cell.ColorRed, "[ALARM] Out of fuel! The turbine is running down and the boat will drift.")
+		case sim.EventReactorScram:
+			events.add(cell.ColorRed, "[ALARM] Reactor SCRAM! Propulsion lost. Restart once the core cools below %.0f K.", sim.ReactorRestartTemp)
+		case sim.EventFloodingStarted:
+			events.add(cell.ColorRed, "[ALARM] Flooding in the %s! Start the bilge pumps.", e.Compartment)
+		case sim.EventCompartmentFlooded:
+			events.add(cell.ColorRed, "[ALARM] The %s is flooded!", e.Compartment)
+		case sim.EventCompartmentDry:
+			events.add(cell.ColorGreen, "[DAMAGE] The %s has been pumped dry.", e.Compartment)
+		}
+		if (e.Kind == sim.EventSettled || e.Kind == sim.EventHardGrounding) && e.Bottom.Hard() {
+			events.add(cell.ColorYellow, "[DEPTH] Hard bottom (%s). Hull will be damaged if the boat moves.", e.Bottom)
+		}
+	}
+}
+
+const (
+	// 海底の見張りで先を見る時間 (秒)。この間に進む距離まで見る
+	groundLookAhead = 60.0
+	// 見張る最短の距離 (m)
+	groundLookMin = 200.0
+	// 見張る距離の刻み (m)
+	groundLookStep = 50.0
+	// 艦底と海底の間にこれだけ空いていなければ警報を出す (m)
+	groundClearance = 10.0
+	// これより遅ければ海底に触れても座礁しないので警報を出さない (ノット)
+	groundWatchMinSpeed = 2.0
+)
+
+// 前方の海底の見張り
+// 今の深度のまま進むと海底にぶつかるなら警報を出す。ゆっくり沈座するときは黙っている
+type groundWatch struct {
+	events *eventLog
+	warned bool
+}
+
+func newGroundWatch(events *eventLog) *groundWatch {
+	return &groundWatch{events: events}
+}
+
+// 自艦の位置と速度で前方を見る (シミュレーション時間で進める)
+func (g *groundWatch) step(p *Player) {
+	if p.Bottomed {
+		// 海底にいる間は見張らない
+		return
+	}
+	distance, seabed, ok := shoalAhead(p)
+	switch {
+	case ok && !g.warned:
+		g.warned = true
+		g.events.add(cell.ColorRed, "[ALARM] Shoaling ahead! Seabed %.0f m at %.0f m. Come up or turn away.", seabed, distance)
+	case !ok && g.warned:
+		g.warned = false
+		g.events.add(cell.ColorGreen, "[DEPTH] Clear of shoal water.")
+	}
+}
+
+// 艦首方向で最初に海底が今の深度まで迫る距離 (m) とそこの水深
+func shoalAhead(p *Player) (distance, seabed float64, ok bool) {
+	if p.Velocity < groundWatchMinSpeed {
+		return 0, 0, false
+	}
+	rng := math.Max(p.Velocity*knot*groundLookAhead, groundLookMin)
+	rad := p.Direction * math.Pi / 180
+	for d := groundLookStep; d <= rng; d += groundLookStep {
+		depth, _ := terrain.SeabedAt(p.Position.X+math.Sin(rad)*d, p.Position.Y+math.Cos(rad)*d)
+		if depth < p.Depth()+groundClearance {
+			return d, depth, true
+		}
+	}
+	return 0, 0, false
+}
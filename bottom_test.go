@@ -0,0 +1,17 @@
+package main
+
+import "testing"
+
+// 海底にいないときは離底できない
+func TestBottom(t *testing.T) {
+	g := startGame(t)
+	g.key("l")
+	g.expect("Lift off the bottom refused: not on the bottom")
+
+	// 機関を停止すると雑音がほぼなくなる
+	g.key("x")
+	g.expect("Machinery SECURED")
+	g.expect("Noise 5 dB")
+	g.key("x")
+	g.expect("Machinery RUNNING")
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// フリート配信 (fleet broadcast)
+//
+// 設定ディレクトリに fleet.json があれば、起動時にコミュニティのサーバーから署名付きの配信を取ってくる。
+// 配信には今日のメッセージ、今日のシナリオ (-daily で遊べる)、ゲームバランスの値の上書きが入る。
+// 署名 (ed25519) を fleet.json の公開鍵で確かめ、署名の合わないものや期限の切れたものは使わない。
+// 取れなければ前回取った配信 (broadcast.json) を期限内なら使い、それもなければ配信なしで遊べる。
+//
+//	{"url": "https://fleet.example.org/broadcast", "publicKey": "<base64>"}
+//
+// サーバーを立てる人は broadcast-keygen で鍵を作り、sign-broadcast で配信に署名する。
+//
+//	explorergame broadcast-keygen private.key
+//	explorergame sign-broadcast private.key broadcast-payload.json > broadcast
+
+const (
+	// 配信を取ってくる先の設定のファイル名
+	fleetConfigFileName = "fleet.json"
+	// 前回取った配信を置いておくファイル名
+	broadcastCacheFileName = "broadcast.json"
+	// サーバーの応答を待つ時間。起動を待たせすぎない
+	broadcastTimeout = 3 * time.Second
+	// 配信の大きさの上限 (byte)
+	broadcastMaxSize = 1 << 20
+)
+
+type fleetConfig struct {
+	URL       string `json:"url"`
+	PublicKey string `json:"publicKey"`
+}
+
+// 署名付きの配信
+type signedBroadcast struct {
+	// fleetBroadcast の JSON を base64 にしたもの
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+// 配信の中身
+type fleetBroadcast struct {
+	Issued  time.Time `json:"issued"`
+	Expires time.Time `json:"expires"`
+	// 今日のメッセージ
+	MOTD string `json:"motd,omitempty"`
+	// 今日のシナリオ
+	Scenario *scenarioConfig `json:"scenario,omitempty"`
+	// ゲームバランスの値の上書き (balanceParameters の名前から値へ)
+	Overrides map[string]float64 `json:"overrides,omitempty"`
+}
+
+// 配信で上書きできる値と、その範囲
+type balanceParameter struct {
+	value    *float64
+	min, max float64
+}
+
+var balanceParameters = map[string]balanceParameter{
+	"datum-initial-radius":     {&datumInitialRadius, 100, 2000},
+	"datum-evasion-speed":      {&datumEvasionSpeed, 5, 30},
+	"counter-detection-offset": {&ownShipSourceOffset, 50, 80},
+	"visual-range":             {&visualRange, 2000, 20000},
+	"diver-clean-rate":         {&diverCleanRate, 1, 20},
+	"tender-clean-rate":        {&tenderCleanRate, 5, 50},
+}
+
+// data が cfg の鍵で署名された、now の時点で有効な配信か確かめる
+func (cfg fleetConfig) verify(data []byte, now time.Time) (*fleetBroadcast, error) {
+	key, err := base64.StdEncoding.DecodeString(cfg.PublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("fleet.json: publicKey is not an ed25519 public key")
+	}
+	var signed signedBroadcast
+	if err := json.Unmarshal(data, &signed); err != nil {
+		return nil, err
+	}
+	payload, err := base64.StdEncoding.DecodeString(signed.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("payload: %v", err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(signed.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("signature: %v", err)
+	}
+	if !ed25519.Verify(ed25519.PublicKey(key), payload, signature) {
+		return nil, errors.New("bad signature")
+	}
+	var b fleetBroadcast
+	if err := json.Unmarshal(payload, &b); err != nil {
+		return nil, err
+	}
+	if now.After(b.Expires) {
+		return nil, fmt.Errorf("expired at %s", b.Expires.Format(time.RFC3339))
+	}
+	if b.Scenario != nil {
+		if err := b.Scenario.validate(); err != nil {
+			return nil, fmt.Errorf("scenario: %v", err)
+		}
+	}
+	return &b, nil
+}
+
+func fetchBroadcast(url string) ([]byte, error) {
+	client := &http.Client{Timeout: broadcastTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, broadcastMaxSize))
+}
+
+// 配信を取ってくる
+// fleet.json がなければ nil を返す。取れなかったときは前回の配信を使い (cached が true)、
+// err に取れなかった理由を入れる。どちらも使えなければ配信なしで遊ぶ
+func loadBroadcast(dir string, now time.Time) (b *fleetBroadcast, cached bool, err error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, fleetConfigFileName))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var cfg fleetConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, false, fmt.Errorf("%s: %v", fleetConfigFileName, err)
+	}
+
+	cachePath := filepath.Join(dir, broadcastCacheFileName)
+	data, err = fetchBroadcast(cfg.URL)
+	if err == nil {
+		if b, err = cfg.verify(data, now); err == nil {
+			return b, false, ioutil.WriteFile(cachePath, data, 0644)
+		}
+	}
+	// 前回の配信にも同じ確かめをする
+	if data, cacheErr := ioutil.ReadFile(cachePath); cacheErr == nil {
+		if b, cacheErr := cfg.verify(data, now); cacheErr == nil {
+			return b, true, err
+		}
+	}
+	return nil, false, err
+}
+
+// 値の上書きを反映する
+// 知らない名前と範囲外の値は使わず、その理由を返す
+func (b *fleetBroadcast) applyOverrides() []string {
+	names := make([]string, 0, len(b.Overrides))
+	for name := range b.Overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var rejected []string
+	for _, name := range names {
+		v := b.Overrides[name]
+		param, ok := balanceParameters[name]
+		switch {
+		case !ok:
+			rejected = append(rejected, fmt.Sprintf("unknown parameter %q", name))
+		case v < param.min || v > param.max:
+			rejected = append(rejected, fmt.Sprintf("%s %v is outside %v..%v", name, v, param.min, param.max))
+		default:
+			*param.value = v
+		}
+	}
+	return rejected
+}
+
+// broadcast-keygen サブコマンド
+// 配信に署名する鍵の組を作り、秘密鍵を path に書いて公開鍵を出力する
+func runBroadcastKeygen(args []string, out io.Writer) int {
+	if len(args) != 1 {
+		fmt.Fprintln(out, "usage: explorergame broadcast-keygen PRIVATE-KEY-FILE")
+		return 2
+	}
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 2
+	}
+	if err := ioutil.WriteFile(args[0], []byte(base64.StdEncoding.EncodeToString(private)+"\n"), 0600); err != nil {
+		fmt.Fprintln(out, err)
+		return 2
+	}
+	fmt.Fprintf(out, "publicKey: %s\n", base64.StdEncoding.EncodeToString(public))
+	return 0
+}
+
+// sign-broadcast サブコマンド
+// base64 の秘密鍵のファイルと、配信の中身の JSON ファイルから、署名付きの配信を出力する
+func runSignBroadcast(args []string, out io.Writer) int {
+	if len(args) != 2 {
+		fmt.Fprintln(out, "usage: explorergame sign-broadcast PRIVATE-KEY-FILE PAYLOAD-FILE")
+		return 2
+	}
+	keyText, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 2
+	}
+	key, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(keyText)))
+	if err != nil || len(key) != ed25519.PrivateKeySize {
+		fmt.Fprintf(out, "%s: not an ed25519 private key\n", args[0])
+		return 2
+	}
+	payload, err := ioutil.ReadFile(args[1])
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 2
+	}
+	// 書き間違いのある配信を出さない
+	var b fleetBroadcast
+	if err := json.Unmarshal(payload, &b); err != nil {
+		fmt.Fprintf(out, "%s: %v\n", args[1], err)
+		return 1
+	}
+	if b.Scenario != nil {
+		if err := b.Scenario.validate(); err != nil {
+			fmt.Fprintf(out, "%s: scenario: %v\n", args[1], err)
+			return 1
+		}
+	}
+	signed := signedBroadcast{
+		Payload:   base64.StdEncoding.EncodeToString(payload),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(ed25519.PrivateKey(key), payload)),
+	}
+	data, err := json.MarshalIndent(signed, "", "  ")
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 2
+	}
+	fmt.Fprintln(out, string(data))
+	return 0
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testBroadcastTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// 決まった種から作る鍵の組
+func newTestBroadcastKey(seed byte) (fleetConfig, ed25519.PrivateKey) {
+	private := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{seed}, ed25519.SeedSize))
+	public := private.Public().(ed25519.PublicKey)
+	return fleetConfig{PublicKey: base64.StdEncoding.EncodeToString(public)}, private
+}
+
+// payload に key で署名した配信
+func signTestBroadcast(t *testing.T, key ed25519.PrivateKey, payload []byte) []byte {
+	t.Helper()
+	data, err := json.Marshal(signedBroadcast{
+		Payload:   base64.StdEncoding.EncodeToString(payload),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, payload)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func testBroadcastPayload(motd string, expires time.Time) []byte {
+	return []byte(`{"issued": "2024-05-01T00:00:00Z", "expires": "` + expires.Format(time.RFC3339) + `", "motd": "` + motd + `"}`)
+}
+
+func TestBroadcastVerify(t *testing.T) {
+	cfg, key := newTestBroadcastKey(1)
+	_, otherKey := newTestBroadcastKey(2)
+	payload := testBroadcastPayload("Fair winds", testBroadcastTime.Add(time.Hour))
+	valid := signTestBroadcast(t, key, payload)
+
+	var tampered signedBroadcast
+	json.Unmarshal(valid, &tampered)
+	tampered.Payload = base64.StdEncoding.EncodeToString(testBroadcastPayload("Foul winds", testBroadcastTime.Add(time.Hour)))
+	tamperedData, _ := json.Marshal(tampered)
+
+	var badSignature signedBroadcast
+	json.Unmarshal(valid, &badSignature)
+	badSignature.Signature = "not base64!"
+	badSignatureData, _ := json.Marshal(badSignature)
+
+	tests := []struct {
+		name string
+		cfg  fleetConfig
+		data []byte
+		// 空なら通る
+		err string
+	}{
+		{"valid", cfg, valid, ""},
+		{"signed by another key", cfg, signTestBroadcast(t, otherKey, payload), "bad signature"},
+		{"payload changed after signing", cfg, tamperedData, "bad signature"},
+		{"signature not base64", cfg, badSignatureData, "signature:"},
+		{"expired", cfg, signTestBroadcast(t, key, testBroadcastPayload("Old news", testBroadcastTime.Add(-time.Second))), "expired at 2024-05-01T11:59:59Z"},
+		{"signed payload not json", cfg, signTestBroadcast(t, key, []byte("motd")), "invalid character"},
+		{"not a signed broadcast", cfg, []byte("<html>"), "invalid character"},
+		{"public key not base64", fleetConfig{PublicKey: "???"}, valid, "publicKey is not an ed25519 public key"},
+		{"public key too short", fleetConfig{PublicKey: base64.StdEncoding.EncodeToString([]byte("short"))}, valid, "publicKey is not an ed25519 public key"},
+	}
+	for _, tt := range tests {
+		b, err := tt.cfg.verify(tt.data, testBroadcastTime)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.err == "" && b.MOTD != "Fair winds":
+			t.Errorf("%s: motd %q", tt.name, b.MOTD)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+		case tt.err != "" && b != nil:
+			t.Errorf("%s: returned a broadcast with the error", tt.name)
+		}
+	}
+}
+
+// 取れた配信は残しておき、取れないときや署名が合わないときは前回の配信を使う
+func TestLoadBroadcastFallsBackToCache(t *testing.T) {
+	cfg, key := newTestBroadcastKey(1)
+	_, otherKey := newTestBroadcastKey(2)
+	serve := signTestBroadcast(t, key, testBroadcastPayload("Fair winds", testBroadcastTime.Add(time.Hour)))
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write(serve)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	cfg.URL = srv.URL
+	data, _ := json.Marshal(cfg)
+	if err := ioutil.WriteFile(filepath.Join(dir, fleetConfigFileName), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b, cached, err := loadBroadcast(dir, testBroadcastTime)
+	if err != nil || cached || b.MOTD != "Fair winds" {
+		t.Fatalf("fresh broadcast: %+v, cached %v, %v", b, cached, err)
+	}
+
+	status = http.StatusServiceUnavailable
+	b, cached, err = loadBroadcast(dir, testBroadcastTime)
+	if err == nil || !cached || b.MOTD != "Fair winds" {
+		t.Errorf("server down: %+v, cached %v, %v", b, cached, err)
+	}
+
+	// 署名の合わない配信は残さず、前回の配信を使う
+	status = http.StatusOK
+	serve = signTestBroadcast(t, otherKey, testBroadcastPayload("Forged", testBroadcastTime.Add(time.Hour)))
+	b, cached, err = loadBroadcast(dir, testBroadcastTime)
+	if err == nil || !cached || b.MOTD != "Fair winds" {
+		t.Errorf("forged broadcast: %+v, cached %v, %v", b, cached, err)
+	}
+
+	// 前回の配信も期限が切れれば使わない
+	b, _, err = loadBroadcast(dir, testBroadcastTime.Add(2*time.Hour))
+	if b != nil || err == nil {
+		t.Errorf("after the cached broadcast expired: %+v, %v", b, err)
+	}
+}
+
+func TestLoadBroadcastWithoutFleetConfig(t *testing.T) {
+	b, cached, err := loadBroadcast(t.TempDir(), testBroadcastTime)
+	if b != nil || cached || err != nil {
+		t.Errorf("without fleet.json: %+v, cached %v, %v", b, cached, err)
+	}
+}
+
+// 知らない名前と範囲外の値は上書きしない
+func TestBroadcastApplyOverrides(t *testing.T) {
+	saved := visualRange
+	t.Cleanup(func() { visualRange = saved })
+
+	tests := []struct {
+		overrides map[string]float64
+		want      float64
+		rejected  []string
+	}{
+		{map[string]float64{"visual-range": 5000}, 5000, nil},
+		{map[string]float64{"visual-range": 2000}, 2000, nil},
+		{map[string]float64{"visual-range": 50000}, saved, []string{"visual-range 50000 is outside 2000..20000"}},
+		{map[string]float64{"torpedo-speed": 90, "visual-range": 3000}, 3000, []string{`unknown parameter "torpedo-speed"`}},
+	}
+	for _, tt := range tests {
+		visualRange = saved
+		b := &fleetBroadcast{Overrides: tt.overrides}
+		rejected := b.applyOverrides()
+		if visualRange != tt.want || strings.Join(rejected, "; ") != strings.Join(tt.rejected, "; ") {
+			t.Errorf("%v: visual range %v, rejected %q; want %v, %q", tt.overrides, visualRange, rejected, tt.want, tt.rejected)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/mum4k/termdash/widgets/text"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 海図の書き込み
+//
+// 危険の目印 (プレイヤーが置く)、進入禁止区域と港の網・防材 (シナリオで決める)、座礁した場所や
+// 機雷を見つけた場所 (自動)、教官のメモ (instructor.go) を海図に書き込む。
+// メモ以外は中に入ると警告が出る。
+// シナリオ以外の書き込みはセーブデータに残る。
+// データム (datum.go) も海図に出すが、時間とともに広がるので別に持ち、保存もしない。
+// 補給港 (logistics.go) は書き込みの後に、在庫と一緒に並べる。
+
+type markKind string
+
+const (
+	markHazard    markKind = "hazard"
+	markExclusion markKind = "no-go"
+	markGrounding markKind = "grounding"
+	markMine      markKind = "mine"
+	markDatum     markKind = "datum"
+	markNote      markKind = "note"
+	markBarrier   markKind = "barrier"
+)
+
+const (
+	// プレイヤーが置く目印と自動の書き込みの半径 (m)
+	markRadius = 200.0
+)
+
+type chartMark struct {
+	Kind   markKind `json:"kind"`
+	Name   string   `json:"name"`
+	X      float64  `json:"x"`
+	Y      float64  `json:"y"`
+	Radius float64  `json:"radius"`
+	// シナリオから書き込んだもの (保存しない)
+	Scenario bool `json:"-"`
+}
+
+func (m chartMark) contains(pos sim.Point3D) bool {
+	return sim.HorizontalDistance(pos, sim.Point3D{X: m.X, Y: m.Y}) <= m.Radius
+}
+
+type chart struct {
+	events *eventLog
+
+	mu     sync.Mutex
+	marks  []chartMark
+	datums []chartMark
+	inside map[int]bool
+	hazard int
+}
+
+func newChart(events *eventLog) *chart {
+	return &chart{events: events, inside: map[int]bool{}}
+}
+
+func (c *chart) add(m chartMark) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.marks = append(c.marks, m)
+}
+
+// 現在位置に危険の目印を置く
+func (c *chart) markHazard(p *Player) error {
+	c.mu.Lock()
+	c.hazard++
+	name := fmt.Sprintf("HAZ %d", c.hazard)
+	c.mu.Unlock()
+	c.add(chartMark{Kind: markHazard, Name: name, X: p.Position.X, Y: p.Position.Y, Radius: markRadius})
+	c.events.add(cell.ColorYellow, "[CHART] Hazard %s marked at the present position.", name)
+	return nil
+}
+
+// データムの円を置き換える
+func (c *chart) setDatums(marks []chartMark) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.datums = marks
+}
+
+// 座礁した場所を書き込む
+// 同じ場所で何度もぶつかったときは最初の書き込みだけ残す
+func (c *chart) markGrounding(pos sim.Point3D, kind sim.BottomType) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, m := range c.marks {
+		if m.Kind == markGrounding && sim.HorizontalDistance(pos, sim.Point3D{X: m.X, Y: m.Y}) <= m.Radius {
+			return
+		}
+	}
+	c.marks = append(c.marks, chartMark{Kind: markGrounding, Name: "Grounded (" + kind.String() + ")", X: pos.X, Y: pos.Y, Radius: markRadius})
+}
+
+// 保存する書き込み (シナリオのものを除く)
+func (c *chart) saved() []chartMark {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var list []chartMark
+	for _, m := range c.marks {
+		if !m.Scenario {
+			list = append(list, m)
+		}
+	}
+	return list
+}
+
+// セーブデータから書き込みを戻す
+func (c *chart) restore(marks []chartMark) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, m := range marks {
+		if m.Kind == markHazard {
+			c.hazard++
+		}
+		c.marks = append(c.marks, m)
+	}
+}
+
+// 自艦が書き込みの範囲に入ったら警告する
+func (c *chart) check(pos sim.Point3D) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, m := range c.marks {
+		if m.Kind == markNote {
+			continue
+		}
+		in := m.contains(pos)
+		if in && !c.inside[i] {
+			color := cell.ColorYellow
+			if m.Kind == markExclusion || m.Kind == markMine {
+				color = cell.ColorRed
+			}
+			c.events.add(color, "[CHART] Entering %s area %s.", m.Kind, m.Name)
+		}
+		c.inside[i] = in
+	}
+}
+
+// 書き込みの一覧 (データムを含む写し)
+func (c *chart) all() []chartMark {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append(append([]chartMark{}, c.marks...), c.datums...)
+}
+
+// 書き込みを pos から近い順に並べ替えて返す
+func nearestMarks(list []chartMark, pos sim.Point3D) []chartMark {
+	list = append([]chartMark{}, list...)
+	sort.Slice(list, func(i, j int) bool {
+		return sim.HorizontalDistance(pos, sim.Point3D{X: list[i].X, Y: list[i].Y})-list[i].Radius <
+			sim.HorizontalDistance(pos, sim.Point3D{X: list[j].X, Y: list[j].Y})-list[j].Radius
+	})
+	return list
+}
+
+// 海図の書き込みと補給港の表示 (写しだけを読む)
+// 書き込みの範囲に入ったかどうかの確認 (check) はゲームループで行う
+func chartPanel(f *frame, t *text.Text) {
+	p := &f.player
+	marks := nearestMarks(f.marks, p.Position)
+
+	t.Reset()
+	if len(marks) == 0 {
+		if err := t.Write("No marks.  [K] mark hazard\n"); err != nil {
+			panic(err)
+		}
+	}
+	for _, m := range marks {
+		center := sim.Point3D{X: m.X, Y: m.Y}
+		color := cell.ColorDefault
+		switch {
+		case m.contains(p.Position):
+			color = cell.ColorRed
+		case m.Kind == markExclusion || m.Kind == markMine || m.Kind == markDatum || m.Kind == markBarrier:
+			color = cell.ColorYellow
+		}
+		line := fmt.Sprintf("%-9s %-12s %03.0f° %5.1f km",
+			m.Kind, m.Name, sim.BearingTo(p.Position, center), sim.HorizontalDistance(p.Position, center)/1000)
+		if m.Kind == markDatum {
+			// 広がっていく円の大きさ
+			line += fmt.Sprintf("  r %.1f km", m.Radius/1000)
+		}
+		line += "\n"
+		if err := t.Write(line, text.WriteCellOpts(cell.FgColor(color))); err != nil {
+			panic(err)
+		}
+	}
+	stocks, blockaded := f.ports, f.blockaded
+	for i, port := range supplyPorts {
+		if i >= len(stocks) {
+			break
+		}
+		color := cell.ColorGreen
+		line := fmt.Sprintf("%-9s %-12s %03.0f° %5.1f km  %s",
+			"port", port.name, sim.BearingTo(p.Position, port.position), sim.HorizontalDistance(p.Position, port.position)/1000, stocks[i])
+		if blockaded[i] {
+			color = cell.ColorYellow
+			line += "  BLOCKADED"
+		}
+		if err := t.Write(line+"\n", text.WriteCellOpts(cell.FgColor(color))); err != nil {
+			panic(err)
+		}
+	}
+}
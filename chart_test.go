@@ -0,0 +1,17 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// K で現在位置に危険の目印を置くと、海図に書き込まれ、その範囲に入った警告が出る
+func TestChart(t *testing.T) {
+	g := startGame(t)
+	g.expect("No marks.")
+	g.key("k")
+	g.expect("[CHART] Hazard HAZ 1 marked at the present position.")
+	g.advance(time.Second)
+	g.expect("hazard    HAZ 1")
+	g.expect("[CHART] Entering hazard area HAZ 1.")
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// チェックポイント
+//
+// 長い哨戒で艦を失っても初めからやり直さずに済むよう、次の節目で艦の状態を覚えておく。
+//   - 出港: 開始地点から checkpointDepartureDistance 離れた
+//   - 初探知: 初めて航跡ができた
+//   - 目標達成: 任務とシナリオの目標を 1 つ達成した (達成するたび)
+//
+// 圧壊・撃沈・燃料切れで哨戒が終わったら、まとめの画面で R を押すと最後のチェックポイントから続けられる。
+// 戻すのは艦、魚雷、乗員の配置と士気と哨戒で進んだ距離だけで、時計・ほかの船・達成した目標・海図はそのまま。
+// 総員退艦は戦歴に残るのでやり直せない。
+
+// 出港とみなす開始地点からの距離 (m)
+const checkpointDepartureDistance = 500.0
+
+// チェックポイントの節目
+type checkpointPhase string
+
+const (
+	phaseDeparture    checkpointPhase = "departure"
+	phaseFirstContact checkpointPhase = "first contact"
+	phaseObjective    checkpointPhase = "objective complete"
+)
+
+type checkpoint struct {
+	phase checkpointPhase
+	// 覚えた時刻 (シミュレーション時間)
+	at   time.Duration
+	save saveData
+	// 覚えたときまでに哨戒で進んだ距離 (m)
+	distance float64
+}
+
+// まとめの画面に出す名前。例: "first contact at 00:12:34"
+func (c checkpoint) String() string {
+	return string(c.phase) + " at " + formatMissionTime(c.at.Seconds())
+}
+
+type checkpoints struct {
+	events *eventLog
+	// 艦の状態に魚雷と乗員を加えたもの
+	save func(p *Player) saveData
+	// 達成した目標の数、航跡ができたか、哨戒で進んだ距離
+	objectives func() (done, total int)
+	contact    func() bool
+	distance   func() float64
+
+	mu       sync.Mutex
+	start    sim.Point3D
+	placed   bool
+	departed bool
+	contacts bool
+	done     int
+	last     *checkpoint
+}
+
+func newCheckpoints(events *eventLog, save func(*Player) saveData, objectives func() (int, int), contact func() bool, distance func() float64) *checkpoints {
+	return &checkpoints{events: events, save: save, objectives: objectives, contact: contact, distance: distance}
+}
+
+// 節目を過ぎたかを調べ、過ぎていれば覚える (ゲームループから呼ぶ)
+func (c *checkpoints) step(p *Player, now time.Duration) {
+	if p.gameOver {
+		return
+	}
+	// ほかの鍵を持つ関数は c.mu を持たずに呼ぶ
+	contact := c.contact()
+	done, _ := c.objectives()
+	distance := c.distance()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.placed {
+		c.start, c.placed = p.Position, true
+	}
+	var phase checkpointPhase
+	if !c.departed && sim.HorizontalDistance(c.start, p.Position) >= checkpointDepartureDistance {
+		c.departed = true
+		phase = phaseDeparture
+	}
+	if !c.contacts && contact {
+		c.contacts = true
+		phase = phaseFirstContact
+	}
+	if done > c.done {
+		c.done = done
+		phase = phaseObjective
+	}
+	if phase == "" {
+		return
+	}
+	c.last = &checkpoint{phase: phase, at: now, save: c.save(p), distance: distance}
+	c.events.add(cell.ColorGreen, "[CHECKPOINT] Saved: %s.", c.last)
+}
+
+// 最後のチェックポイント
+func (c *checkpoints) latest() (checkpoint, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.last == nil {
+		return checkpoint{}, false
+	}
+	return *c.last, true
+}
+
+// まとめの画面に出す最後のチェックポイント。なければ空
+func (c *checkpoints) describe() string {
+	if cp, ok := c.latest(); ok {
+		return cp.String()
+	}
+	return ""
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 開始地点にいるあいだはチェックポイントを覚えない
+func TestCheckpoint(t *testing.T) {
+	g := startGame(t)
+	g.advance(2 * time.Second)
+	g.expectNot("[CHECKPOINT]")
+	// 開始地点から離れると出港のチェックポイントを覚える
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.advance(5 * time.Minute)
+	g.expect("[CHECKPOINT] Saved: departure at")
+	// 艦長が哨戒を終えたときはチェックポイントに戻れない
+	g.key("q")
+	g.expect("PATROL ENDED by the captain")
+	g.expectNot("PRESS R TO RESTART FROM CHECKPOINT")
+	g.expect("PRESS Q TO EXIT")
+}
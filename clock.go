@@ -0,0 +1,160 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// ゲーム内の時計
+// 各ウィジェットの更新ループは time.NewTicker ではなくこの時計からティッカーを作る
+// 通常は実時間で進み、テスト・耐久試験・リプレイでは手で進める偽の時計に差し替える
+type gameClock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) gameTicker
+}
+
+type gameTicker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// 現在使っている時計
+var clock gameClock = realClock{}
+
+// シミュレーションの一時停止
+// シミュレーションを進めるループは止まっている間ティックを読み捨てる。表示の更新と入力はそのまま動く
+type pauseSwitch struct {
+	mu     sync.Mutex
+	paused bool
+}
+
+var simPause = &pauseSwitch{}
+
+// 止まっているか
+func (s *pauseSwitch) on() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused
+}
+
+// 止める・動かすを切り替え、止まったかを返す
+func (s *pauseSwitch) toggle() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = !s.paused
+	return s.paused
+}
+
+// 実時間の時計
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTicker(d time.Duration) gameTicker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (r realTicker) C() <-chan time.Time { return r.t.C }
+func (r realTicker) Stop()               { r.t.Stop() }
+
+// Advance を呼んだ分だけ進む時計
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+func newFakeClock(start time.Time) *fakeClock {
+	return &fakeClock{now: start}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) NewTicker(d time.Duration) gameTicker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := &fakeTicker{
+		clock:    f,
+		interval: d,
+		next:     f.now.Add(d),
+		c:        make(chan time.Time),
+		stop:     make(chan struct{}),
+	}
+	f.tickers = append(f.tickers, t)
+	return t
+}
+
+// 時計を d だけ進め、その間に発火するティックを時刻順にすべて届ける
+// 受け取り側が処理を終えるまで次のティックは送らないので、結果は実行速度に依存しない
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	end := f.now.Add(d)
+	f.mu.Unlock()
+
+	for {
+		f.mu.Lock()
+		var due []*fakeTicker
+		for _, t := range f.tickers {
+			if !t.next.After(end) {
+				due = append(due, t)
+			}
+		}
+		if len(due) == 0 {
+			f.now = end
+			f.mu.Unlock()
+			return
+		}
+		sort.SliceStable(due, func(i, j int) bool { return due[i].next.Before(due[j].next) })
+		t := due[0]
+		f.now = t.next
+		t.next = t.next.Add(t.interval)
+		now := f.now
+		f.mu.Unlock()
+
+		select {
+		case t.c <- now:
+		case <-t.stop:
+		}
+	}
+}
+
+func (f *fakeClock) remove(t *fakeTicker) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, ft := range f.tickers {
+		if ft == t {
+			f.tickers = append(f.tickers[:i], f.tickers[i+1:]...)
+			return
+		}
+	}
+}
+
+type fakeTicker struct {
+	clock    *fakeClock
+	interval time.Duration
+	next     time.Time
+	c        chan time.Time
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.c }
+
+func (t *fakeTicker) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.stop)
+		t.clock.remove(t)
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/mum4k/termdash/widgets/text"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// コンパス
+//
+// 艦首方位を中心にした方位の目盛り (テープ) を出す。中央の ^ が艦首方位で、
+// 命令された針路には v (目盛りの外なら < か >) の印が付く。その下に自動操舵 (autopilot.go) の状態を出す。
+
+const (
+	// 目盛りの幅 (文字)
+	compassTapeWidth = 41
+	// 1文字あたりの角度 (度)
+	compassDegreesPerColumn = 3.0
+)
+
+// 命令された針路 (命令されていなければ今の艦首方位)
+func (p *Player) orderedCourse() float64 {
+	if p.courseOrdered {
+		return p.Course.Ordered
+	}
+	return p.Direction
+}
+
+// コンパスの目盛り
+// 針路の印、方位の略号、目盛り、艦首方位の印の4行を返す
+func compassTape(heading, course float64, hasCourse bool) []string {
+	bug := []rune(strings.Repeat(" ", compassTapeWidth))
+	labels := []rune(strings.Repeat(" ", compassTapeWidth))
+	scale := []rune(strings.Repeat(".", compassTapeWidth))
+	lubber := []rune(strings.Repeat(" ", compassTapeWidth))
+	center := compassTapeWidth / 2
+
+	for i := range scale {
+		b := heading + float64(i-center)*compassDegreesPerColumn
+		// この列に入る 15° ごとの目盛り
+		m := math.Round(b/15) * 15
+		if d := b - m; d < -compassDegreesPerColumn/2 || d >= compassDegreesPerColumn/2 {
+			continue
+		}
+		if math.Mod(sim.NormalizeBearing(m), 45) != 0 {
+			scale[i] = ':'
+			continue
+		}
+		scale[i] = '|'
+		label := compassPoint(m)
+		for j, r := range label {
+			if k := i - len(label)/2 + j; k >= 0 && k < compassTapeWidth {
+				labels[k] = r
+			}
+		}
+	}
+	lubber[center] = '^'
+
+	if hasCourse {
+		offset := sim.NormalizeRelative(course-heading) / compassDegreesPerColumn
+		switch col := center + int(math.Round(offset)); {
+		case col < 0:
+			bug[0] = '<'
+		case col >= compassTapeWidth:
+			bug[compassTapeWidth-1] = '>'
+		default:
+			bug[col] = 'v'
+		}
+	}
+	return []string{string(bug), string(labels), string(scale), string(lubber)}
+}
+
+// コンパスパネルの表示
+func compassPanel(p *Player, t *text.Text) {
+	heading, course, hasCourse := p.Direction, p.orderedCourse(), p.courseOrdered
+
+	t.Reset()
+	for i, line := range compassTape(heading, course, hasCourse) {
+		color := cell.ColorCyan
+		if i == 0 {
+			color = cell.ColorYellow
+		}
+		if err := t.Write(line+"\n", text.WriteCellOpts(cell.FgColor(color))); err != nil {
+			panic(err)
+		}
+	}
+	line := fmt.Sprintf("HDG %03.0f %-2s   ", heading, compassPoint(heading))
+	switch d := p.Course.Error(); {
+	case !hasCourse:
+		line += "CRS ---"
+	case p.Course.Settled():
+		line += fmt.Sprintf("CRS %s  on course", p.Course.Label("%03.0f"))
+	case d > 0:
+		line += fmt.Sprintf("CRS %s  steer right %.0f°", p.Course.Label("%03.0f"), d)
+	default:
+		line += fmt.Sprintf("CRS %s  steer left %.0f°", p.Course.Label("%03.0f"), -d)
+	}
+	if err := t.Write(line + "\n"); err != nil {
+		panic(err)
+	}
+	apColor := cell.ColorDefault
+	if p.headingHold || p.speedHold {
+		apColor = cell.ColorGreen
+	}
+	if err := t.Write(autopilotLine(p)+"\n", text.WriteCellOpts(cell.FgColor(apColor))); err != nil {
+		panic(err)
+	}
+}
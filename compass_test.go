@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 針路を命令するまでは艦首方位だけ
+func TestCompass(t *testing.T) {
+	g := startGame(t)
+	g.advance(time.Second)
+	g.expect("HDG 000 N")
+	g.expect("CRS ---")
+	// 針路を右へ 15°
+	g.typeText("===")
+	g.advance(time.Second)
+	g.expect("[ORDER] Course 015")
+	g.expect("CRS ORD 015  ACT 000  steer right 15°")
+	// 左へ回して北の反対側へ
+	g.typeText("------")
+	g.advance(time.Second)
+	g.expect("CRS ORD 345  ACT 000  steer left 15°")
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+)
+
+// 設定ファイル
+//
+// 難易度・単位・受動測距の道具・命令の遅れ・画面の更新間隔・イベントログの色・キー割り当てを設定ディレクトリの config.toml にまとめて書ける。
+// ファイルがなければ、初めて起動したときに既定の値をコメント付きで書き出す。
+// 読めるのは TOML のうち、[表]、key = 値 (文字列・数・true/false・文字列の配列) と # のコメントだけ。
+// キー割り当ては keys.json があればそちらが優先する (config.toml の [keys] の上に keys.json を重ねる)。
+
+// 設定を保存するファイル名
+const configFileName = "config.toml"
+
+// 初めて起動したときに書き出す設定
+const defaultConfigTOML = `# explorergame の設定
+# 消した項目は既定の値に戻る。
+
+[game]
+# 難易度: "easy", "normal", "hard"
+difficulty = "normal"
+# 深度の単位: "metric" (m), "imperial" (ft)
+units = "metric"
+# TMA パネルにエケルント測距とターンカウントを出す (上級者向け)
+ranging_tools = false
+# 操艦と機関への命令は乗員が復唱してから効く。疲れた乗員は聞き違えることがある
+order_delay = false
+# 魚雷の安全装置 (起爆までの距離・深度の帯・円走の防止)。false にすると外れ、円走した魚雷が自艦に当たることもある
+torpedo_interlocks = true
+# 脅威のないときに 45〜90 分ごとに損傷対処の訓練 (模擬の火災・浸水・潜舵の故障) をする
+training_drills = true
+
+[rates]
+# 画面の再描画の間隔 (ミリ秒)。-low-bandwidth ではこれより短くしない
+redraw_ms = 16
+# パネルの最短の更新間隔 (ミリ秒)。0 なら各パネルの既定のまま
+panel_min_ms = 0
+
+[colors]
+# イベントログの見出しごとの色: default, black, red, green, yellow, blue, magenta, cyan, white
+# ALARM = "red"
+# TRACK = "cyan"
+
+[keys]
+# 操作ごとのキー。例:
+# launch-torpedo = ["j", "enter"]
+# pause = ["space", "*"]
+`
+
+// 難易度
+type difficulty struct {
+	// 自艦の放射雑音の補正 (dB, ownShipSourceOffset)
+	sourceOffset float64
+	// 敵が探し始める範囲の半径 (m, datumInitialRadius)
+	datumRadius float64
+	// 魚雷が当たったときの船体の損傷 (%)
+	torpedoDamage float64
+}
+
+var difficulties = map[string]difficulty{
+	"easy":   {sourceOffset: 58, datumRadius: 1000, torpedoDamage: 25},
+	"normal": {sourceOffset: 65, datumRadius: 500, torpedoDamage: 40},
+	"hard":   {sourceOffset: 72, datumRadius: 250, torpedoDamage: 60},
+}
+
+// ゲームバランスの値に反映する。フリート配信の上書きはこのあとに重ねる
+func (d difficulty) apply() {
+	ownShipSourceOffset = d.sourceOffset
+	datumInitialRadius = d.datumRadius
+	torpedoDamage = d.torpedoDamage
+}
+
+// 単位系
+type unitSystem string
+
+const (
+	unitsMetric   unitSystem = "metric"
+	unitsImperial unitSystem = "imperial"
+)
+
+const metersPerFoot = 0.3048
+
+// 深度の表示の単位。main で config.toml から決める
+var units = unitsMetric
+
+// 深度 (m) を表示の単位で。例: "120 m", "394 ft"
+func (u unitSystem) depth(m float64, decimals int) string {
+	if u == unitsImperial {
+		return fmt.Sprintf("%.*f ft", decimals, m/metersPerFoot)
+	}
+	return fmt.Sprintf("%.*f m", decimals, m)
+}
+
+var colorNames = map[string]cell.Color{
+	"default": cell.ColorDefault,
+	"black":   cell.ColorBlack,
+	"red":     cell.ColorRed,
+	"green":   cell.ColorGreen,
+	"yellow":  cell.ColorYellow,
+	"blue":    cell.ColorBlue,
+	"magenta": cell.ColorMagenta,
+	"cyan":    cell.ColorCyan,
+	"white":   cell.ColorWhite,
+}
+
+type gameConfig struct {
+	difficulty        string
+	units             unitSystem
+	rangingTools      bool
+	orderDelay        bool
+	torpedoInterlocks bool
+	trainingDrills    bool
+	redraw            time.Duration
+	panelMinDelay     time.Duration
+	// イベントログの見出し ("[ALARM]" など) から色へ
+	eventColors map[string]cell.Color
+	// [keys] に書いた割り当て
+	keys map[keyAction][]string
+}
+
+func defaultGameConfig() gameConfig {
+	return gameConfig{
+		difficulty:        "normal",
+		units:             unitsMetric,
+		torpedoInterlocks: true,
+		trainingDrills:    true,
+		redraw:            normalRedrawInterval,
+		eventColors:       map[string]cell.Color{},
+		keys:              map[keyAction][]string{},
+	}
+}
+
+// path の設定を読む。ファイルがなければ既定の設定を書き出して、既定のまま返す
+func loadGameConfig(path string) (gameConfig, error) {
+	cfg := defaultGameConfig()
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		if err := ioutil.WriteFile(path, []byte(defaultConfigTOML), 0644); err != nil {
+			return cfg, err
+		}
+		data = []byte(defaultConfigTOML)
+	} else if err != nil {
+		return cfg, err
+	}
+	tables, err := parseTOML(string(data))
+	if err != nil {
+		return cfg, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := cfg.set(tables); err != nil {
+		return cfg, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// 読んだ値を反映する。エラーが毎回同じになるよう、名前順に処理する
+func (cfg *gameConfig) set(tables map[string]map[string]tomlValue) error {
+	tableNames := make([]string, 0, len(tables))
+	for table := range tables {
+		tableNames = append(tableNames, table)
+	}
+	sort.Strings(tableNames)
+	for _, table := range tableNames {
+		values := tables[table]
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			v := values[key]
+			if err := cfg.setValue(table, key, v); err != nil {
+				return fmt.Errorf("line %d: %s.%s: %v", v.line, table, key, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (cfg *gameConfig) setValue(table, key string, v tomlValue) error {
+	switch table {
+	case "game":
+		switch key {
+		case "difficulty":
+			s, err := v.str()
+			if err != nil {
+				return err
+			}
+			if _, ok := difficulties[s]; !ok {
+				return fmt.Errorf("unknown difficulty %q (easy, normal, hard)", s)
+			}
+			cfg.difficulty = s
+			return nil
+		case "units":
+			s, err := v.str()
+			if err != nil {
+				return err
+			}
+			if u := unitSystem(s); u != unitsMetric && u != unitsImperial {
+				return fmt.Errorf("unknown units %q (metric, imperial)", s)
+			}
+			cfg.units = unitSystem(s)
+			return nil
+		case "ranging_tools":
+			b, err := v.boolean()
+			if err != nil {
+				return err
+			}
+			cfg.rangingTools = b
+			return nil
+		case "order_delay":
+			b, err := v.boolean()
+			if err != nil {
+				return err
+			}
+			cfg.orderDelay = b
+			return nil
+		case "torpedo_interlocks":
+			b, err := v.boolean()
+			if err != nil {
+				return err
+			}
+			cfg.torpedoInterlocks = b
+			return nil
+		case "training_drills":
+			b, err := v.boolean()
+			if err != nil {
+				return err
+			}
+			cfg.trainingDrills = b
+			return nil
+		}
+	case "rates":
+		switch key {
+		case "redraw_ms":
+			ms, err := v.millis(1, 1000)
+			if err != nil {
+				return err
+			}
+			cfg.redraw = ms
+			return nil
+		case "panel_min_ms":
+			ms, err := v.millis(0, 5000)
+			if err != nil {
+				return err
+			}
+			cfg.panelMinDelay = ms
+			return nil
+		}
+	case "colors":
+		s, err := v.str()
+		if err != nil {
+			return err
+		}
+		c, ok := colorNames[strings.ToLower(s)]
+		if !ok {
+			return fmt.Errorf("unknown color %q", s)
+		}
+		cfg.eventColors["["+strings.ToUpper(key)+"]"] = c
+		return nil
+	case "keys":
+		if _, ok := defaultKeyBindings[keyAction(key)]; !ok {
+			return fmt.Errorf("unknown action")
+		}
+		keys, err := v.strings()
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if _, err := parseKey(k); err != nil {
+				return err
+			}
+		}
+		cfg.keys[keyAction(key)] = keys
+		return nil
+	default:
+		return fmt.Errorf("unknown table [%s]", table)
+	}
+	return fmt.Errorf("unknown setting")
+}
+
+// 読んだ設定を反映する
+func (cfg gameConfig) apply() {
+	difficulties[cfg.difficulty].apply()
+	units = cfg.units
+	rangingTools = cfg.rangingTools
+	orderDelay = cfg.orderDelay
+	torpedoInterlocks = cfg.torpedoInterlocks
+	trainingDrills = cfg.trainingDrills
+	render.redraw = cfg.redraw
+	render.minPanelDelay = cfg.panelMinDelay
+}
+
+// TOML の値。文字列・数・真偽値・文字列の配列のどれか
+type tomlValue struct {
+	line  int
+	value interface{}
+}
+
+func (v tomlValue) str() (string, error) {
+	if s, ok := v.value.(string); ok {
+		return s, nil
+	}
+	return "", fmt.Errorf("want a string")
+}
+
+func (v tomlValue) strings() ([]string, error) {
+	if s, ok := v.value.([]string); ok {
+		return s, nil
+	}
+	return nil, fmt.Errorf("want an array of strings")
+}
+
+func (v tomlValue) boolean() (bool, error) {
+	if b, ok := v.value.(bool); ok {
+		return b, nil
+	}
+	return false, fmt.Errorf("want true or false")
+}
+
+// min..max のミリ秒の数
+func (v tomlValue) millis(min, max float64) (time.Duration, error) {
+	n, ok := v.value.(float64)
+	if !ok {
+		return 0, fmt.Errorf("want a number")
+	}
+	if n < min || n > max {
+		return 0, fmt.Errorf("%g out of range [%g, %g]", n, min, max)
+	}
+	return time.Duration(n * float64(time.Millisecond)), nil
+}
+
+// TOML の一部を読む。表の名前から、キーから値への対応を返す
+func parseTOML(src string) (map[string]map[string]tomlValue, error) {
+	tables := map[string]map[string]tomlValue{}
+	table := ""
+	for i, raw := range strings.Split(src, "\n") {
+		line := strings.TrimSpace(stripTOMLComment(raw))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: unterminated table header", i+1)
+			}
+			table = strings.TrimSpace(line[1 : len(line)-1])
+			if table == "" {
+				return nil, fmt.Errorf("line %d: empty table name", i+1)
+			}
+			if _, ok := tables[table]; !ok {
+				tables[table] = map[string]tomlValue{}
+			}
+			continue
+		}
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: want key = value", i+1)
+		}
+		if table == "" {
+			return nil, fmt.Errorf("line %d: setting outside a table", i+1)
+		}
+		key := strings.TrimSpace(line[:eq])
+		if unquoted, err := strconv.Unquote(key); err == nil {
+			key = unquoted
+		}
+		if key == "" {
+			return nil, fmt.Errorf("line %d: empty key", i+1)
+		}
+		if _, ok := tables[table][key]; ok {
+			return nil, fmt.Errorf("line %d: %s.%s set twice", i+1, table, key)
+		}
+		value, err := parseTOMLValue(strings.TrimSpace(line[eq+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %v", i+1, key, err)
+		}
+		tables[table][key] = tomlValue{line: i + 1, value: value}
+	}
+	return tables, nil
+}
+
+// 文字列の外にある # から後ろを捨てる
+func stripTOMLComment(line string) string {
+	quoted := false
+	for i, r := range line {
+		switch {
+		case r == '"' && (i == 0 || line[i-1] != '\\'):
+			quoted = !quoted
+		case r == '#' && !quoted:
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func parseTOMLValue(s string) (interface{}, error) {
+	switch {
+	case s == "true" || s == "false":
+		return s == "true", nil
+	case strings.HasPrefix(s, `"`):
+		return strconv.Unquote(s)
+	case strings.HasPrefix(s, "["):
+		if !strings.HasSuffix(s, "]") {
+			return nil, fmt.Errorf("unterminated array")
+		}
+		var items []string
+		for _, item := range splitTOMLArray(s[1 : len(s)-1]) {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			v, err := strconv.Unquote(item)
+			if err != nil {
+				return nil, fmt.Errorf("array item %s is not a string", item)
+			}
+			items = append(items, v)
+		}
+		return items, nil
+	}
+	n, err := strconv.ParseFloat(strings.Replace(s, "_", "", -1), 64)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read value %s", s)
+	}
+	return n, nil
+}
+
+// 文字列の中のものを除いた , で分ける
+func splitTOMLArray(s string) []string {
+	var items []string
+	quoted, start := false, 0
+	for i, r := range s {
+		switch {
+		case r == '"' && (i == 0 || s[i-1] != '\\'):
+			quoted = !quoted
+		case r == ',' && !quoted:
+			items = append(items, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, s[start:])
+}
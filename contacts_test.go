@@ -0,0 +1,18 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 航跡を選ぶまで TMA パネルは空で、魚雷は艦首方向へ撃つ
+func TestContacts(t *testing.T) {
+	g := startGame(t)
+	g.advance(time.Second)
+	g.expect("No track selected.")
+	g.expectNot("SOLN")
+	// 受動測距の道具は config.toml の ranging_tools で使うまで出ない
+	g.key("<")
+	g.expect("[TMA] Ranging tools are off")
+	g.expectNot("EKELUND")
+}
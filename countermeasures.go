@@ -0,0 +1,138 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 対抗手段 (囮)
+//
+// 敵の魚雷のシーカーは視野の中で最も大きく聞こえるものに向かうので、自艦より大きな音を出す囮で
+// 引き寄せる。
+//   - ノイズメーカー: 出した場所に留まって大きな音を出す。数は多いが、離れると効かなくなる
+//   - 自走式デコイ: 自艦の音をまねて、出したときの針路と深度のまま走る。数は少ない
+//
+// どちらも発射機の再装填 (noisemakerReload、即応態勢で変わる) が終わるまで次を出せない。
+// 囮に向かった魚雷は当たっても自艦を傷めない。
+
+const (
+	// 積んでいる数
+	noisemakerLoad = 8
+	decoyLoad      = 4
+	// 自走式デコイの音の大きさ (dB)、速力 (ノット)、走る時間
+	decoyNoise = 140.0
+	decoySpeed = 10.0
+	decoyLife  = 3 * time.Minute
+)
+
+// 囮。ノイズメーカーは speed が 0
+type noisemaker struct {
+	position sim.Point3D
+	// 音の大きさ (dB)
+	noise float64
+	// 針路 (度) と速力 (ノット)
+	course, speed float64
+	// シミュレーション上の時刻
+	expires time.Duration
+}
+
+type countermeasures struct {
+	events *eventLog
+
+	mu          sync.Mutex
+	noisemakers int
+	decoyCount  int
+	// 時刻はどれもシミュレーション上の時刻
+	now      time.Duration
+	reloaded time.Duration
+	decoys   []noisemaker
+}
+
+func newCountermeasures(events *eventLog) *countermeasures {
+	return &countermeasures{events: events, noisemakers: noisemakerLoad, decoyCount: decoyLoad}
+}
+
+// ノイズメーカーを出す (orderCountermeasure の処理)
+func (c *countermeasures) launchNoisemaker(p *Player) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.noisemakers == 0 {
+		return orderRefusedError{"no noisemakers left"}
+	}
+	if err := c.reload(p); err != nil {
+		return err
+	}
+	c.noisemakers--
+	c.decoys = append(c.decoys, noisemaker{position: p.Position, noise: noisemakerNoise, expires: c.now + noisemakerLife})
+	c.events.add(cell.ColorCyan, "[WEAPONS] Noisemaker away. %d left.", c.noisemakers)
+	return nil
+}
+
+// 自走式デコイを出す (orderLaunchDecoy の処理)
+func (c *countermeasures) launchDecoy(p *Player) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.decoyCount == 0 {
+		return orderRefusedError{"no decoys left"}
+	}
+	if err := c.reload(p); err != nil {
+		return err
+	}
+	c.decoyCount--
+	c.decoys = append(c.decoys, noisemaker{
+		position: p.Position,
+		noise:    decoyNoise,
+		course:   p.Direction,
+		speed:    decoySpeed,
+		expires:  c.now + decoyLife,
+	})
+	c.events.add(cell.ColorCyan, "[WEAPONS] Decoy away, running %03.0f. %d left.", p.Direction, c.decoyCount)
+	return nil
+}
+
+// 発射機が使えれば次の再装填の時刻を決める (c.mu を保持した状態で呼ぶ)
+func (c *countermeasures) reload(p *Player) error {
+	if c.now < c.reloaded {
+		return orderRefusedError{"launcher reloading"}
+	}
+	c.reloaded = c.now + time.Duration(float64(noisemakerReload)*p.reloadFactor())
+	return nil
+}
+
+// シミュレーション上の時刻 now まで、dt 秒分だけ囮を動かす
+func (c *countermeasures) step(now time.Duration, dt float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+	remaining := c.decoys[:0]
+	for _, n := range c.decoys {
+		if now >= n.expires {
+			continue
+		}
+		move := n.speed * knot * dt
+		rad := n.course * math.Pi / 180
+		n.position.X += math.Sin(rad) * move
+		n.position.Y += math.Cos(rad) * move
+		remaining = append(remaining, n)
+	}
+	c.decoys = remaining
+}
+
+// 鳴っている囮 (コピー)
+func (c *countermeasures) active() []noisemaker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]noisemaker{}, c.decoys...)
+}
+
+// 鳴っている囮の位置 (デブリーフィングの記録)
+func (c *countermeasures) weapons() (torpedoes, decoys []sim.Point3D) {
+	for _, n := range c.active() {
+		decoys = append(decoys, n.position)
+	}
+	return nil, decoys
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// ノイズメーカーを出すと残りの数が出る
+func TestCountermeasures(t *testing.T) {
+	g := startGame(t)
+	g.advance(time.Second)
+	g.key("c")
+	g.expect("[WEAPONS] Noisemaker away. 7 left.")
+	// 再装填が終わるまで次は出せない
+	g.key("y")
+	g.expect("Launch decoy refused: launcher reloading")
+	g.advance(11 * time.Second)
+	g.key("y")
+	g.expect("[WEAPONS] Decoy away")
+	g.expect("3 left.")
+}
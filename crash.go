@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	rtdebug "runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/terminal/terminalapi"
+)
+
+// 前回クラッシュしたことを示すマーカーファイル (中身はクラッシュダンプのパス)
+const crashMarkerFileName = "crashed"
+
+// ゴルーチンやコールバック内のパニックを捕まえ、ゲームを安全に止める
+// パニックした場所に関わらず、端末の復元とクラッシュダンプの書き出しは main で行う
+type crashGuard struct {
+	cancel context.CancelFunc
+	state  *playerState
+	// 不具合を再現するための乱数の種
+	seed int64
+
+	mu    sync.Mutex
+	value interface{}
+	stack []byte
+}
+
+func newCrashGuard(cancel context.CancelFunc, state *playerState, seed int64) *crashGuard {
+	return &crashGuard{cancel: cancel, state: state, seed: seed}
+}
+
+// defer g.catch() の形で使う
+func (g *crashGuard) catch() {
+	if r := recover(); r != nil {
+		g.record(r, rtdebug.Stack())
+	}
+}
+
+// 最初に起きたクラッシュだけを記録してゲームループを止める
+func (g *crashGuard) record(v interface{}, stack []byte) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.value != nil {
+		return
+	}
+	g.value = v
+	g.stack = stack
+	logs.errorf("crash", "%v", v)
+	g.cancel()
+}
+
+func (g *crashGuard) crashed() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.value != nil
+}
+
+// パニックを捕まえるゴルーチンを起動する
+func (g *crashGuard) goSafe(fn func()) {
+	go func() {
+		defer g.catch()
+		fn()
+	}()
+}
+
+// ボタンなど termdash から呼ばれるコールバックを保護する
+func (g *crashGuard) wrap(fn func() error) func() error {
+	return func() error {
+		defer g.catch()
+		return fn()
+	}
+}
+
+// termdash.ErrorHandler に渡す
+func (g *crashGuard) handleError(err error) {
+	g.record(err, rtdebug.Stack())
+}
+
+// main の defer で呼ぶ
+// クラッシュしていれば端末を元に戻し、クラッシュダンプを書き出して終了する
+func (g *crashGuard) finish(t terminalapi.Terminal, dir string) {
+	if r := recover(); r != nil {
+		g.record(r, rtdebug.Stack())
+	}
+	if !g.crashed() {
+		return
+	}
+	t.Close()
+	path, err := g.writeDump(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "explorergame crashed and the crash dump could not be written: %v\n", err)
+	} else {
+		fmt.Fprintf(os.Stderr, "explorergame crashed. Crash dump written to %s\n", path)
+		fmt.Fprintf(os.Stderr, "You will be offered to resume from the last autosave on next launch.\n")
+	}
+	os.Exit(2)
+}
+
+// クラッシュダンプを書き出し、次回起動時に再開を提案するためのマーカーを置く
+func (g *crashGuard) writeDump(dir string) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	path := filepath.Join(dir, "crash-"+now.Format("20060102-150405")+".log")
+	// ゲームループが止まりきっていなくても読めるように写しを使う
+	f := g.state.view()
+	state, err := json.MarshalIndent(newSaveData(&f.player), "", "  ")
+	if err != nil {
+		state = []byte(err.Error())
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "ExplorerGame crash dump\n")
+	fmt.Fprintf(&b, "time: %s\n", now.Format(time.RFC3339))
+	fmt.Fprintf(&b, "seed: %d\n", g.seed)
+	fmt.Fprintf(&b, "panic: %v\n\n", g.value)
+	fmt.Fprintf(&b, "--- state ---\n%s\n\n", state)
+	fmt.Fprintf(&b, "--- stack ---\n%s", g.stack)
+	if err := ioutil.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, crashMarkerFileName), []byte(path), 0644); err != nil {
+		return path, err
+	}
+	return path, nil
+}
+
+// 前回のセッションがクラッシュしていれば、オートセーブから再開するか尋ねる
+// 再開する場合はセーブデータを返す
+func offerResume(dir, savePath string, in io.Reader, out io.Writer) *saveData {
+	markerPath := filepath.Join(dir, crashMarkerFileName)
+	dump, err := ioutil.ReadFile(markerPath)
+	if err != nil {
+		return nil
+	}
+	os.Remove(markerPath)
+
+	save, err := readSave(savePath)
+	if os.IsNotExist(err) {
+		fmt.Fprintf(out, "The previous session crashed (%s), but no autosave is available.\n", dump)
+		return nil
+	}
+	if err != nil {
+		fmt.Fprintf(out, "The previous session crashed (%s), but the autosave could not be read: %v\n", dump, err)
+		return nil
+	}
+
+	fmt.Fprintf(out, "The previous session crashed (%s).\n", dump)
+	fmt.Fprintf(out, "Resume from the autosave of %s? [y/N] ", save.SavedAt.Format("2006-01-02 15:04:05"))
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	if answer != "y" && answer != "yes" {
+		return nil
+	}
+	return &save
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 乗員と配置
+//
+// 乗員はそれぞれ名前と専門の配置、腕前 (0 ~ 100) を持つ。操舵、ソーナー、兵装、応急の 4 つの配置に
+// 1 人ずつ就け、配置の性能は就いている乗員の腕前で決まる。専門外の配置では腕前の半分しか出せない。
+// 配置に就いていない乗員は交代要員として休んでいる。腕前 crewStandardSkill が標準の性能になる。
+// 怪我と士気も腕前に響く (medbay.go)。
+//   - 操舵: 操舵機の動作速度 (sim.Player.HelmEfficiency)
+//   - ソーナー: パッシブソーナーの信号余裕 (watchBonus に足す)
+//   - 兵装: 発射管と囮の発射機の装填時間 (reloadFactor に掛ける)
+//   - 応急: ビルジポンプの排水の速さ (sim.Player.DamageControlEfficiency)
+
+type station int
+
+const (
+	stationHelm station = iota
+	stationSonar
+	stationWeapons
+	stationDamageControl
+	stationCount
+)
+
+func (s station) String() string {
+	switch s {
+	case stationSonar:
+		return "Sonar"
+	case stationWeapons:
+		return "Weapons"
+	case stationDamageControl:
+		return "Damage Ctrl"
+	}
+	return "Helm"
+}
+
+const (
+	// 標準の性能になる腕前
+	crewStandardSkill = 70.0
+	// 専門外の配置で出せる腕前の割合
+	offSpecialtyFactor = 0.5
+	// ソーナー員の腕前 1 あたりの信号余裕 (dB)
+	sonarSkillGain = 1.0 / 15
+)
+
+type crewMember struct {
+	name      string
+	specialty station
+	skill     float64
+	// 怪我と治るまでの残り (秒)、医務室にいるか
+	injury   injury
+	recovery float64
+	medbay   bool
+}
+
+// 乗り組んでいる乗員。配置ごとに専門の乗員が 2 人ずついる
+var defaultCrew = []crewMember{
+	{name: "PO1 Okafor", specialty: stationHelm, skill: 80},
+	{name: "SN Ruiz", specialty: stationHelm, skill: 45},
+	{name: "CPO Hale", specialty: stationSonar, skill: 85},
+	{name: "PO2 Vance", specialty: stationSonar, skill: 55},
+	{name: "CPO Lindqvist", specialty: stationWeapons, skill: 75},
+	{name: "PO3 Tanaka", specialty: stationWeapons, skill: 50},
+	{name: "MM1 Brennan", specialty: stationDamageControl, skill: 70},
+	{name: "FN Sato", specialty: stationDamageControl, skill: 40},
+}
+
+type crewRoster struct {
+	events *eventLog
+
+	mu      sync.Mutex
+	members []crewMember
+	// 配置ごとに就いている乗員 (members の添字)
+	assigned [stationCount]int
+	// 配置を変える対象に選んでいる配置
+	selected station
+
+	// 士気 (moraleMin ~ moraleStart)
+	morale float64
+	// シミュレーション上の時刻
+	now time.Duration
+	// 前のティックの区画の耐久値と、怪我に至っていない損傷の累計
+	lastHP  sim.Compartments
+	hpKnown bool
+	pending [sim.CompartmentCount]float64
+	// 疲れによる事故が起きるまでの積算 (1 で起きる)
+	accident   float64
+	casualties []casualty
+}
+
+// 配置ごとに専門の乗員のうち最も腕のよい者を就けた乗員
+func newCrewRoster(events *eventLog) *crewRoster {
+	r := &crewRoster{events: events, members: append([]crewMember{}, defaultCrew...), morale: moraleStart}
+	for s := station(0); s < stationCount; s++ {
+		best := -1
+		for i, m := range r.members {
+			if m.specialty == s && (best < 0 || m.skill > r.members[best].skill) {
+				best = i
+			}
+		}
+		r.assigned[s] = best
+	}
+	return r
+}
+
+// 乗員 m が配置 s で出せる腕前。怪我をしていればその分落ちる
+func (m crewMember) skillAt(s station) float64 {
+	skill := m.skill * m.injury.factor()
+	if m.specialty != s {
+		skill *= offSpecialtyFactor
+	}
+	return skill
+}
+
+// 乗員 i が配置 s で出せる士気を含めた腕前 (r.mu を保持した状態で呼ぶ)
+func (r *crewRoster) skillOf(i int, s station) float64 {
+	return r.members[i].skillAt(s) * moraleFactor(r.morale)
+}
+
+// 配置 s で出せる腕前。乗員の名簿がなければ標準
+func (r *crewRoster) skill(s station) float64 {
+	if r == nil {
+		return crewStandardSkill
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.skillOf(r.assigned[s], s)
+}
+
+// 乗り組んでいる乗員の数。乗員の名簿がなければ既定の乗員
+func (r *crewRoster) size() int {
+	if r == nil {
+		return len(defaultCrew)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.members)
+}
+
+// 腕前による効率。標準の腕前で 1、腕前 1 あたり 1% 変わる
+func skillEfficiency(skill float64) float64 {
+	return 1 + (skill-crewStandardSkill)/100
+}
+
+// ソーナー員の腕前による信号余裕の増減 (dB)
+func (r *crewRoster) sonarBonus() float64 {
+	return (r.skill(stationSonar) - crewStandardSkill) * sonarSkillGain
+}
+
+// 兵装員の腕前による装填時間の倍率
+func (r *crewRoster) reloadFactor() float64 {
+	return 1 / skillEfficiency(r.skill(stationWeapons))
+}
+
+// 操舵員と応急員の腕前を艦に反映する (ティックごとに呼ぶ)
+func (r *crewRoster) apply(p *Player) {
+	p.HelmEfficiency = skillEfficiency(r.skill(stationHelm))
+	p.DamageControlEfficiency = skillEfficiency(r.skill(stationDamageControl))
+}
+
+// 配置を変える対象を次の配置に移す
+func (r *crewRoster) nextStation() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.selected = (r.selected + 1) % stationCount
+}
+
+// 選んでいる配置に名簿で dir だけ先の乗員を就ける。医務室にいる乗員は飛ばす
+// その乗員がほかの配置に就いていれば、今就いている乗員と入れ替える
+func (r *crewRoster) cycle(dir int) {
+	r.mu.Lock()
+	s := r.selected
+	current := r.assigned[s]
+	next := cycleIndex(current, len(r.members), dir)
+	for next != current && r.members[next].medbay {
+		next = cycleIndex(next, len(r.members), dir)
+	}
+	if next == current {
+		r.mu.Unlock()
+		return
+	}
+	swapped := stationCount
+	for other := station(0); other < stationCount; other++ {
+		if other != s && r.assigned[other] == next {
+			r.assigned[other] = current
+			swapped = other
+		}
+	}
+	r.assigned[s] = next
+	m, relieved := r.members[next], r.members[current]
+	skill := r.skillOf(next, s)
+	relievedSkill := 0.0
+	if swapped != stationCount {
+		relievedSkill = r.skillOf(current, swapped)
+	}
+	r.mu.Unlock()
+	r.events.add(cell.ColorCyan, "[CREW] %s takes the %s station (skill %.0f).", m.name, strings.ToLower(s.String()), skill)
+	if swapped != stationCount {
+		r.events.add(cell.ColorCyan, "[CREW] %s moves to the %s station (skill %.0f).", relieved.name, strings.ToLower(swapped.String()), relievedSkill)
+	}
+}
+
+// 配置ごとに就いている乗員の名前 (セーブデータ用)
+func (r *crewRoster) saved() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, stationCount)
+	for s, i := range r.assigned {
+		names[s] = r.members[i].name
+	}
+	return names
+}
+
+// セーブデータから配置を戻す。知らない名前の配置はそのまま
+func (r *crewRoster) restore(names []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for s := 0; s < len(names) && s < int(stationCount); s++ {
+		for i, m := range r.members {
+			if m.name != names[s] {
+				continue
+			}
+			// 別の配置に就いていれば入れ替える
+			for other := range r.assigned {
+				if r.assigned[other] == i {
+					r.assigned[other] = r.assigned[s]
+				}
+			}
+			r.assigned[s] = i
+		}
+	}
+}
+
+// 配置と就いている乗員、交代要員、配置の性能の表示
+func crewPanel(r *crewRoster, tr *training) panelText {
+	r.mu.Lock()
+	members, assigned, selected := append([]crewMember{}, r.members...), r.assigned, r.selected
+	morale := moraleFactor(r.morale)
+	r.mu.Unlock()
+
+	var out panelText
+	onWatch := map[int]bool{}
+	for s, i := range assigned {
+		onWatch[i] = true
+		m := members[i]
+		cursor := " "
+		if station(s) == selected {
+			cursor = ">"
+		}
+		skill := m.skillAt(station(s)) * morale
+		color := cell.ColorGreen
+		note := ""
+		switch {
+		case m.injury == injurySerious:
+			color, note = cell.ColorRed, " (serious injury)"
+		case m.injury == injuryLight:
+			color, note = cell.ColorYellow, " (light injury)"
+		case m.specialty != station(s):
+			color, note = cell.ColorYellow, " (off specialty)"
+		case skill < crewStandardSkill:
+			color = cell.ColorYellow
+		}
+		out.write(fmt.Sprintf("%s%-11s %-13s %3.0f%s\n", cursor, station(s), m.name, skill, note), color)
+	}
+	var off []string
+	for i, m := range members {
+		switch {
+		case onWatch[i] || m.medbay:
+		case m.injury != injuryNone:
+			off = append(off, m.name+" ("+m.injury.String()+")")
+		default:
+			off = append(off, m.name)
+		}
+	}
+	out.write("Off watch: "+strings.Join(off, ", ")+"\n", cell.ColorDefault)
+	effects := fmt.Sprintf("Sonar %+.1f dB  Reload x%.2f  Rudder %.0f%%  Pumps %.0f%%\n",
+		r.sonarBonus(), r.reloadFactor(), skillEfficiency(r.skill(stationHelm))*100, skillEfficiency(r.skill(stationDamageControl))*100)
+	out.write(effects, cell.ColorDefault)
+	medbay, medbayColor := r.medbayLine()
+	out.write(medbay+"\n", medbayColor)
+	drill, drillColor := tr.line()
+	out.write(drill+"\n", drillColor)
+	return out
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 配置ごとに専門の乗員のうち最も腕のよい者が就いている
+func TestCrew(t *testing.T) {
+	g := startGame(t)
+	g.advance(time.Second)
+	g.expect(">Helm        PO1 Okafor     80")
+	g.expect("Sonar       CPO Hale       85")
+	g.expect("Off watch: SN Ruiz, PO2 Vance, PO3 Tanaka, FN Sato")
+	g.expect("Sonar +1.0 dB  Reload x0.95  Rudder 110%  Pumps 100%")
+	// ソーナーの配置を選び、交代要員を就ける
+	g.key("'")
+	g.key("/")
+	g.advance(time.Second)
+	g.expect("[CREW] PO2 Vance takes the sonar station (skill 55).")
+	g.expect(">Sonar       PO2 Vance      55")
+	g.expect("Sonar -1.0 dB")
+	// ほかの配置の乗員を就けると入れ替わり、専門外では腕前が半分になる
+	g.key("/")
+	g.advance(time.Second)
+	g.expect("[CREW] CPO Lindqvist takes the sonar station (skill 38).")
+	g.expect("[CREW] PO2 Vance moves to the weapons station (skill 28).")
+	g.expect("(off specialty)")
+	// 怪我をしていない乗員は医務室に送れない
+	g.key("`")
+	g.advance(time.Second)
+	g.expect("[MEDICAL] CPO Lindqvist at the sonar station is not injured.")
+	g.expect("Medbay 0/2  Morale 100")
+}
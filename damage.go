@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 区画の損傷と浸水
+//
+// 区画ごとの耐久値と、それによる機器の性能の低下、浸水は sim.Player が持つ (sim/damage.go, sim/flooding.go)。
+// ここではソーナーの感度への影響と、Ship Status パネルの表示を扱う。
+
+// 発射管室の耐久値が 0 のときのソーナーの感度の低下 (dB)
+const sonarDamageLoss = 15.0
+
+// 区画の略号 (船首から船尾の順)
+var compartmentCodes = [sim.CompartmentCount]string{"TORP", "CTRL", "RCTR", "ENG"}
+
+// 浸水計の目盛りの数
+const floodGaugeWidth = 10
+
+// 艦首のソーナーが傷んで落ちた感度 (dB)
+// パッシブソーナーの信号余裕から引き、アクティブソーナーでは雑音に足す
+func (p *Player) sonarLoss() float64 {
+	return sonarDamageLoss * (1 - p.Compartments[sim.CompartmentTorpedoRoom]/100)
+}
+
+// 最も浸水した区画の浸水 (%)
+func worstFlooding(p *Player) float64 {
+	worst := 0.0
+	for _, f := range p.Flooding {
+		worst = math.Max(worst, f)
+	}
+	return worst
+}
+
+// 区画 c の耐久値と浸水計の行
+// 例: "TORP  40 hp [####------]  40% BREACH"
+func compartmentGauge(p *Player, c sim.Compartment) (string, cell.Color) {
+	flood := p.Flooding[c]
+	filled := int(math.Round(flood / 100 * floodGaugeWidth))
+	gauge := strings.Repeat(render.glyph("█", "#"), filled) + strings.Repeat(render.glyph("░", "-"), floodGaugeWidth-filled)
+	line := fmt.Sprintf("%-4s %3.0f hp [%s] %3.0f%%", compartmentCodes[c], p.Compartments[c], gauge, flood)
+	if p.Breached(c) {
+		line += " BREACH"
+	}
+	switch {
+	case flood >= 50 || p.Compartments[c] < 40:
+		return line, cell.ColorRed
+	case flood > 0 || p.Compartments[c] < 100:
+		return line, cell.ColorYellow
+	}
+	return line, cell.ColorGreen
+}
+
+// ビルジポンプの行
+func pumpLine(p *Player) (string, cell.Color) {
+	switch {
+	case p.PumpsRunning():
+		return fmt.Sprintf("Bilge pumps ON  Buoyancy lost %.1f", -p.FloodingBuoyancy()), cell.ColorYellow
+	case p.BilgePumps:
+		return "Bilge pumps STOPPED (no power)", cell.ColorRed
+	case p.Flooded():
+		return fmt.Sprintf("Bilge pumps OFF  Buoyancy lost %.1f", -p.FloodingBuoyancy()), cell.ColorRed
+	}
+	return "Bilge pumps OFF", cell.ColorGreen
+}
+
+// 損傷で落ちている性能の行
+func damageEffectLine(p *Player) string {
+	return fmt.Sprintf("Max rpm %.0f  Rudder %.0f%%  Sonar -%.0f dB",
+		p.MaxTurbine(), p.Performance(sim.CompartmentControlRoom)*100, p.sonarLoss())
+}
+
+// 区画の行の色。最も傷んだ区画で決める
+func compartmentColor(p *Player) cell.Color {
+	worst := 100.0
+	for _, hp := range p.Compartments {
+		worst = math.Min(worst, hp)
+	}
+	switch {
+	case worst < 40:
+		return cell.ColorRed
+	case worst < 100:
+		return cell.ColorYellow
+	}
+	return cell.ColorGreen
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 探知された位置 (データム)
+//
+// 敵に探知されると、その位置がデータムになる。敵は自艦がそこから逃げられる範囲 (最遠到達圏) を
+// 捜索するので、データムの円は時間とともに広がっていく。円の外に出れば捜索を逃れたことになる。
+// データムは海図に書き込まれ、円を出たときと、敵が諦めて捜索をやめたときにイベントログに出る。
+
+// これだけ経つと敵は捜索を諦める
+const datumLifetime = 20 * time.Minute
+
+// 難易度で変わり (config.go)、フリート配信で上書きできる (broadcast.go)
+var (
+	// 探知した時点での位置の誤差 (m)
+	datumInitialRadius = 500.0
+	// 敵が見込む自艦の逃走速力 (ノット)
+	datumEvasionSpeed = 15.0
+	// 自艦の雑音レベルを放射雑音 (音源レベル, dB) に直すための差
+	ownShipSourceOffset = 65.0
+)
+
+// 自艦を探す軍艦の艦種
+// 商船や補給艦は聴音しない
+var warshipClasses = map[string]bool{
+	"Frigate":   true,
+	"Destroyer": true,
+	"Corvette":  true,
+	"Submarine": true,
+	// 港の哨戒艇 (harbor.go)
+	patrolBoatClass: true,
+}
+
+type datum struct {
+	name     string
+	position sim.Point3D
+	// 探知されたシミュレーション上の時刻
+	at     time.Duration
+	reason string
+	// 自艦が円の外に出たか
+	cleared bool
+}
+
+// 最遠到達圏の半径 (m)
+func (d datum) radius(now time.Duration) float64 {
+	return datumInitialRadius + datumEvasionSpeed*knot*(now-d.at).Seconds()
+}
+
+type datumPlot struct {
+	events *eventLog
+	chart  *chart
+
+	mu     sync.Mutex
+	datums []*datum
+	next   int
+	// シミュレーション上の時刻
+	now time.Duration
+}
+
+func newDatumPlot(events *eventLog, ch *chart) *datumPlot {
+	return &datumPlot{events: events, chart: ch}
+}
+
+// pos でデータムを作る
+// 自艦がまだ最新のデータムの円の中にいれば、新しく作らずにそのデータムを今の位置と時刻に更新する
+func (dp *datumPlot) raise(pos sim.Point3D, reason string) {
+	dp.mu.Lock()
+	defer dp.mu.Unlock()
+	now := dp.now
+	if n := len(dp.datums); n > 0 {
+		last := dp.datums[n-1]
+		if !last.cleared && sim.HorizontalDistance(pos, last.position) <= last.radius(now) {
+			last.position, last.at, last.reason = pos, now, reason
+			return
+		}
+	}
+	dp.next++
+	d := &datum{name: fmt.Sprintf("DATUM %d", dp.next), position: pos, at: now, reason: reason}
+	dp.datums = append(dp.datums, d)
+	dp.events.add(cell.ColorRed, "[DATUM] %s: %s. Clear the area!", d.name, reason)
+}
+
+// 時刻をシミュレーション上の時刻 now に進める
+func (dp *datumPlot) advance(now time.Duration) {
+	dp.mu.Lock()
+	defer dp.mu.Unlock()
+	dp.now = now
+}
+
+// 捜索が続いているデータムの数と、自艦がまだその円の中にいるデータムの名前 (いなければ空)
+func (dp *datumPlot) status(own sim.Point3D) (active int, inside string) {
+	dp.mu.Lock()
+	defer dp.mu.Unlock()
+	for _, d := range dp.datums {
+		active++
+		if inside == "" && !d.cleared && sim.HorizontalDistance(own, d.position) <= d.radius(dp.now) {
+			inside = d.name
+		}
+	}
+	return active, inside
+}
+
+// 円を出たか、捜索が終わったかを調べ、海図の円を広げる
+func (dp *datumPlot) update(own sim.Point3D) {
+	dp.mu.Lock()
+	defer dp.mu.Unlock()
+	now := dp.now
+	remaining := dp.datums[:0]
+	var marks []chartMark
+	for _, d := range dp.datums {
+		if now-d.at >= datumLifetime {
+			dp.events.add(cell.ColorGreen, "[DATUM] %s has gone cold. The enemy has given up the search.", d.name)
+			continue
+		}
+		r := d.radius(now)
+		if !d.cleared && sim.HorizontalDistance(own, d.position) > r {
+			d.cleared = true
+			dp.events.add(cell.ColorGreen, "[DATUM] Clear of %s.", d.name)
+		}
+		remaining = append(remaining, d)
+		marks = append(marks, chartMark{Kind: markDatum, Name: d.name, X: d.position.X, Y: d.position.Y, Radius: r})
+	}
+	dp.datums = remaining
+	dp.chart.setDatums(marks)
+}
+
+// 軍艦が自艦を聴音・目視で探知したらデータムを作る
+// アクティブソーナーを打ったばかりなら、その送信音も聞かれる
+func counterDetectionSweep(p *Player, env *environment, tr *traffic, dp *datumPlot, pinger *sonarPinger) {
+	if simPause.on() {
+		return
+	}
+	own := p.Position
+	source := sonarTarget{sourceLevel: p.noiseLevel() + ownShipSourceOffset}
+	sector, exposed := pinger.exposed()
+	for _, s := range tr.vessels() {
+		if !warshipClasses[s.class] {
+			continue
+		}
+		sonar := s.sonar()
+		noise := tr.noiseHeardBy(sonar, s.id)
+		if passiveSonar.signalExcess(env, sonar, own, source, noise) >= 0 {
+			dp.raise(own, fmt.Sprintf("counter-detected by %s %s", s.class, s.name))
+			break
+		}
+		if exposed && pingIntercepted(env, own, sonar, sector, noise) {
+			dp.raise(own, fmt.Sprintf("ping intercepted by %s %s", s.class, s.name))
+			break
+		}
+		if !s.submerged() && p.Depth() <= periscopeDepth && sim.HorizontalDistance(own, s.position) <= env.sightingRange() {
+			dp.raise(own, fmt.Sprintf("sighted by %s %s", s.class, s.name))
+			break
+		}
+	}
+	dp.update(p.Position)
+}
@@ -0,0 +1,556 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash"
+	"github.com/mum4k/termdash/cell"
+	"github.com/mum4k/termdash/container"
+	"github.com/mum4k/termdash/keyboard"
+	"github.com/mum4k/termdash/linestyle"
+	"github.com/mum4k/termdash/terminal/termbox"
+	"github.com/mum4k/termdash/terminal/terminalapi"
+	"github.com/mum4k/termdash/widgets/text"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// デブリーフィング
+//
+// 航海中は自艦・ほかの船・乗員の航跡の推定位置・魚雷と囮の位置を一定の間隔で記録し、
+// イベントログの行もシミュレーション上の時刻つきで残す。終了すると設定ディレクトリの
+// debrief.json に書き、debrief サブコマンドで海図の上に再生できる。
+// 乗員の怪我と最後の士気 (medbay.go) も残す。
+
+const (
+	debriefFileName = "debrief.json"
+	// 記録する間隔 (シミュレーション時間)
+	debriefInterval = 5 * time.Second
+	// 再生の図の大きさ (文字)
+	debriefCols = 61
+	debriefRows = 25
+	// ほかの船と魚雷の航跡として残すコマ数
+	debriefTrailFrames = 24
+	// 再生で 1 コマ進める間隔 (実時間)
+	debriefPlayDelay = 100 * time.Millisecond
+	// 上下の矢印で進めるコマ数 (1 分)
+	debriefJump = int(time.Minute / debriefInterval)
+	// 再生画面に出すイベントの行数
+	debriefEventLines = 30
+)
+
+// 記録。時刻はどれもシミュレーション上の秒数
+type debriefRecording struct {
+	Seed   int64          `json:"seed"`
+	Frames []debriefFrame `json:"frames"`
+	Events []debriefEvent `json:"events,omitempty"`
+	// 乗員の怪我と、終了したときの士気
+	Casualties []debriefCasualty `json:"casualties,omitempty"`
+	Morale     float64           `json:"morale,omitempty"`
+}
+
+type debriefFrame struct {
+	T         float64         `json:"t"`
+	Own       debriefPoint    `json:"own"`
+	Vessels   []debriefVessel `json:"vessels,omitempty"`
+	Tracks    []debriefTrack  `json:"tracks,omitempty"`
+	Torpedoes []debriefPoint  `json:"torpedoes,omitempty"`
+	Decoys    []debriefPoint  `json:"decoys,omitempty"`
+}
+
+type debriefPoint struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+}
+
+type debriefVessel struct {
+	ID    int     `json:"id"`
+	Name  string  `json:"name"`
+	Class string  `json:"class"`
+	X     float64 `json:"x"`
+	Y     float64 `json:"y"`
+}
+
+// 乗員の航跡。距離がわかっているものだけを推定位置で残す
+type debriefTrack struct {
+	Name string  `json:"name"`
+	X    float64 `json:"x"`
+	Y    float64 `json:"y"`
+}
+
+type debriefEvent struct {
+	T    float64 `json:"t"`
+	Text string  `json:"text"`
+}
+
+type debriefCasualty struct {
+	T         float64 `json:"t"`
+	Name      string  `json:"name"`
+	Injury    string  `json:"injury"`
+	Cause     string  `json:"cause"`
+	Treated   bool    `json:"treated"`
+	Recovered bool    `json:"recovered"`
+}
+
+func newDebriefPoint(p sim.Point3D) debriefPoint {
+	return debriefPoint{X: p.X, Y: p.Y, Z: p.Z}
+}
+
+type missionRecorder struct {
+	mu      sync.Mutex
+	now     time.Duration
+	last    time.Duration
+	sampled bool
+	// 魚雷と囮の位置を返すもの (敵、訓練、自艦の魚雷)
+	weapons []func() (torpedoes, decoys []sim.Point3D)
+	// 乗員の怪我と士気を返すもの
+	crew func() ([]debriefCasualty, float64)
+	rec  debriefRecording
+}
+
+func newMissionRecorder(seed int64) *missionRecorder {
+	return &missionRecorder{rec: debriefRecording{Seed: seed}}
+}
+
+// イベントログの行を残す (eventLog から呼ばれる)
+func (r *missionRecorder) logEvent(msg string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rec.Events = append(r.rec.Events, debriefEvent{T: r.now.Seconds(), Text: msg})
+}
+
+// 魚雷と囮も記録する
+func (r *missionRecorder) trackWeapons(fn func() (torpedoes, decoys []sim.Point3D)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.weapons = append(r.weapons, fn)
+}
+
+// 乗員の怪我と士気も記録する
+func (r *missionRecorder) trackCrew(fn func() ([]debriefCasualty, float64)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.crew = fn
+}
+
+// ゲームループから呼ぶ。debriefInterval ごとに 1 コマ記録する
+// ほかの系統のロックを取るあいだは r.mu を持たない (それらの系統がイベントログに書くため)
+func (r *missionRecorder) step(now time.Duration, p *Player, tr *traffic, tm *trackManager) {
+	r.mu.Lock()
+	r.now = now
+	due := !r.sampled || now-r.last >= debriefInterval
+	weapons := r.weapons
+	r.mu.Unlock()
+	if !due {
+		return
+	}
+
+	f := debriefFrame{T: now.Seconds(), Own: newDebriefPoint(p.Position)}
+	for _, v := range tr.vessels() {
+		f.Vessels = append(f.Vessels, debriefVessel{ID: v.id, Name: v.name, Class: v.class, X: v.position.X, Y: v.position.Y})
+	}
+	tracks, _ := tm.snapshot(clock.Now())
+	for _, t := range tracks {
+		if t.lost || math.IsNaN(t.rng) {
+			continue
+		}
+		f.Tracks = append(f.Tracks, debriefTrack{Name: t.designation(), X: t.estimate.X, Y: t.estimate.Y})
+	}
+	for _, fn := range weapons {
+		torpedoes, decoys := fn()
+		for _, pos := range torpedoes {
+			f.Torpedoes = append(f.Torpedoes, newDebriefPoint(pos))
+		}
+		for _, pos := range decoys {
+			f.Decoys = append(f.Decoys, newDebriefPoint(pos))
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rec.Frames = append(r.rec.Frames, f)
+	r.last = now
+	r.sampled = true
+}
+
+// 記録をファイルに書く。1 コマもなければ何もしない
+// 乗員の怪我は r.mu を持たずに取る (乗員の名簿がイベントログに書くため)
+func (r *missionRecorder) save(path string) (bool, error) {
+	r.mu.Lock()
+	crew := r.crew
+	r.mu.Unlock()
+	var casualties []debriefCasualty
+	morale := 0.0
+	if crew != nil {
+		casualties, morale = crew()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rec.Casualties, r.rec.Morale = casualties, morale
+	if len(r.rec.Frames) == 0 {
+		return false, nil
+	}
+	data, err := json.Marshal(r.rec)
+	if err != nil {
+		return false, err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return false, err
+	}
+	return true, os.Rename(tmp, path)
+}
+
+func loadDebrief(path string) (debriefRecording, error) {
+	var rec debriefRecording
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return rec, err
+	}
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return rec, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(rec.Frames) == 0 {
+		return rec, fmt.Errorf("%s: no frames recorded", path)
+	}
+	return rec, nil
+}
+
+// 再生の図の縮尺 (1 文字あたりの m) と中心
+// 記録全体の自艦・ほかの船・魚雷が収まるようにし、再生中は動かさない
+func debriefBounds(rec debriefRecording) (scale, cx, cy float64) {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	add := func(x, y float64) {
+		minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+		minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+	}
+	for _, f := range rec.Frames {
+		add(f.Own.X, f.Own.Y)
+		for _, v := range f.Vessels {
+			add(v.X, v.Y)
+		}
+		for _, t := range f.Torpedoes {
+			add(t.X, t.Y)
+		}
+	}
+	// 1 文字は縦が横の 2 倍
+	scale = math.Max((maxX-minX)/float64(debriefCols-2), (maxY-minY)/float64(2*(debriefRows-2)))
+	scale = math.Max(scale, 10)
+	return scale, (minX + maxX) / 2, (minY + maxY) / 2
+}
+
+// frame 番目のコマまでを文字の行にする
+// 自艦の航跡は最初から、ほかの船と魚雷は最近の debriefTrailFrames コマだけを描く
+func renderReplay(rec debriefRecording, frame int, scale, cx, cy float64) []string {
+	grid := make([][]rune, debriefRows)
+	for j := range grid {
+		grid[j] = []rune(strings.Repeat(" ", debriefCols))
+	}
+	plot := func(x, y float64, r rune) {
+		i := int(math.Floor((x-cx)/scale)) + debriefCols/2
+		j := debriefRows/2 - int(math.Floor((y-cy)/(2*scale)))
+		if i >= 0 && i < debriefCols && j >= 0 && j < debriefRows {
+			grid[j][i] = r
+		}
+	}
+	first := frame - debriefTrailFrames
+	if first < 0 {
+		first = 0
+	}
+	for _, f := range rec.Frames[first:frame] {
+		for _, v := range f.Vessels {
+			plot(v.X, v.Y, ',')
+		}
+		for _, t := range f.Torpedoes {
+			plot(t.X, t.Y, '*')
+		}
+	}
+	for _, f := range rec.Frames[:frame] {
+		plot(f.Own.X, f.Own.Y, '.')
+	}
+	cur := rec.Frames[frame]
+	for _, t := range cur.Tracks {
+		plot(t.X, t.Y, 'x')
+	}
+	for _, v := range cur.Vessels {
+		if warshipClasses[v.Class] {
+			plot(v.X, v.Y, 'W')
+		} else {
+			plot(v.X, v.Y, 'm')
+		}
+	}
+	for _, d := range cur.Decoys {
+		plot(d.X, d.Y, 'o')
+	}
+	for _, t := range cur.Torpedoes {
+		plot(t.X, t.Y, 'T')
+	}
+	plot(cur.Own.X, cur.Own.Y, 'O')
+
+	lines := make([]string, len(grid))
+	for j, row := range grid {
+		lines[j] = string(row)
+	}
+	return lines
+}
+
+// 時刻を h:mm:ss にする
+func formatMissionTime(seconds float64) string {
+	s := int(seconds)
+	return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+}
+
+// 再生位置のバー
+func scrubBar(rec debriefRecording, frame, width int) string {
+	last := len(rec.Frames) - 1
+	pos := 0
+	if last > 0 {
+		pos = frame * (width - 1) / last
+	}
+	bar := strings.Repeat("=", pos) + "|" + strings.Repeat("-", width-1-pos)
+	return fmt.Sprintf("[%s] %s / %s", bar, formatMissionTime(rec.Frames[frame].T), formatMissionTime(rec.Frames[last].T))
+}
+
+// 再生位置までのイベントの最後の n 行
+func eventsUntil(rec debriefRecording, t float64, n int) []debriefEvent {
+	end := 0
+	for end < len(rec.Events) && rec.Events[end].T <= t {
+		end++
+	}
+	start := end - n
+	if start < 0 {
+		start = 0
+	}
+	return rec.Events[start:end]
+}
+
+// 再生位置までの怪我の数と、終了したときの士気の行。怪我がなければ false
+// 例: "Casualties 3 (1 serious, 2 treated)  Final morale 82"
+func casualtySummary(rec debriefRecording, t float64) (string, bool) {
+	total, serious, treated := 0, 0, 0
+	for _, c := range rec.Casualties {
+		if c.T > t {
+			continue
+		}
+		total++
+		if c.Injury == injurySerious.String() {
+			serious++
+		}
+		if c.Treated {
+			treated++
+		}
+	}
+	if total == 0 {
+		return "", false
+	}
+	return fmt.Sprintf("Casualties %d (%d serious, %d treated)  Final morale %.0f", total, serious, treated, rec.Morale), true
+}
+
+type debriefViewer struct {
+	rec           debriefRecording
+	scale, cx, cy float64
+	mu            sync.Mutex
+	frame         int
+	playing       bool
+}
+
+func (v *debriefViewer) seek(delta int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.frame += delta
+	if v.frame < 0 {
+		v.frame = 0
+	}
+	if last := len(v.rec.Frames) - 1; v.frame > last {
+		v.frame = last
+	}
+}
+
+func (v *debriefViewer) togglePlay() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.playing = !v.playing
+	// 最後まで再生していれば最初から
+	if v.playing && v.frame == len(v.rec.Frames)-1 {
+		v.frame = 0
+	}
+}
+
+// 再生中ならコマを進める。最後のコマで止まる
+func (v *debriefViewer) advance() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !v.playing {
+		return
+	}
+	if v.frame < len(v.rec.Frames)-1 {
+		v.frame++
+	} else {
+		v.playing = false
+	}
+}
+
+func (v *debriefViewer) draw(chartText, eventText, barText *text.Text) {
+	v.mu.Lock()
+	frame, playing := v.frame, v.playing
+	v.mu.Unlock()
+	f := v.rec.Frames[frame]
+
+	chartText.Reset()
+	for _, line := range renderReplay(v.rec, frame, v.scale, v.cx, v.cy) {
+		if err := chartText.Write(line+"\n", text.WriteCellOpts(cell.FgColor(cell.ColorCyan))); err != nil {
+			panic(err)
+		}
+	}
+	if err := chartText.Write(fmt.Sprintf("1 col = %.0f m  depth %.0f m  %d tracks\n", v.scale, -f.Own.Z, len(f.Tracks))); err != nil {
+		panic(err)
+	}
+	if line, ok := casualtySummary(v.rec, f.T); ok {
+		if err := chartText.Write(line+"\n", text.WriteCellOpts(cell.FgColor(cell.ColorYellow))); err != nil {
+			panic(err)
+		}
+	}
+
+	eventText.Reset()
+	for _, e := range eventsUntil(v.rec, f.T, debriefEventLines) {
+		color := cell.ColorDefault
+		if f.T-e.T < debriefInterval.Seconds() {
+			color = cell.ColorYellow
+		}
+		if err := eventText.Write(fmt.Sprintf("[%s] %s\n", formatMissionTime(e.T), e.Text), text.WriteCellOpts(cell.FgColor(color))); err != nil {
+			panic(err)
+		}
+	}
+
+	barText.Reset()
+	state := "PAUSED"
+	if playing {
+		state = "PLAYING"
+	}
+	if err := barText.Write(fmt.Sprintf("%s %s\n", scrubBar(v.rec, frame, 50), state)); err != nil {
+		panic(err)
+	}
+	if err := barText.Write("left/right: 5 s  up/down: 1 min  space: play/pause  q: quit"); err != nil {
+		panic(err)
+	}
+}
+
+// debrief サブコマンド
+// 引数を省くと設定ディレクトリの最後の記録を再生する
+func runDebrief(args []string, out io.Writer) int {
+	if len(args) > 1 {
+		fmt.Fprintln(out, "usage: explorergame debrief [RECORDING]")
+		return 2
+	}
+	path := filepath.Join(dataDir(), debriefFileName)
+	if len(args) == 1 {
+		path = args[0]
+	}
+	rec, err := loadDebrief(path)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 2
+	}
+	v := &debriefViewer{rec: rec}
+	v.scale, v.cx, v.cy = debriefBounds(rec)
+
+	t, err := termbox.New()
+	if err != nil {
+		panic(err)
+	}
+	defer t.Close()
+
+	chartText, err := text.New()
+	if err != nil {
+		panic(err)
+	}
+	eventText, err := text.New()
+	if err != nil {
+		panic(err)
+	}
+	barText, err := text.New()
+	if err != nil {
+		panic(err)
+	}
+
+	c, err := container.New(
+		t,
+		container.Border(linestyle.Light),
+		container.BorderTitle(fmt.Sprintf("DEBRIEF - SEED %d", rec.Seed)),
+		container.SplitHorizontal(
+			container.Top(
+				container.SplitVertical(
+					container.Left(
+						container.Border(linestyle.Light),
+						container.BorderTitle("Track Reconstruction"),
+						container.PlaceWidget(chartText),
+					),
+					container.Right(
+						container.Border(linestyle.Light),
+						container.BorderTitle("Events"),
+						container.PlaceWidget(eventText),
+					),
+					container.SplitPercent(55),
+				),
+			),
+			container.Bottom(
+				container.Border(linestyle.Light),
+				container.BorderTitle("Timeline"),
+				container.PlaceWidget(barText),
+			),
+			container.SplitPercent(85),
+		),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		ticker := clock.NewTicker(debriefPlayDelay)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C():
+				v.advance()
+				v.draw(chartText, eventText, barText)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	keyHandler := func(k *terminalapi.Keyboard) {
+		switch k.Key {
+		case 'q', 'Q', keyboard.KeyEsc:
+			cancel()
+		case keyboard.KeyArrowLeft:
+			v.seek(-1)
+		case keyboard.KeyArrowRight:
+			v.seek(1)
+		case keyboard.KeyArrowDown:
+			v.seek(-debriefJump)
+		case keyboard.KeyArrowUp:
+			v.seek(debriefJump)
+		case keyboard.KeySpace:
+			v.togglePlay()
+		}
+	}
+	if err := termdash.Run(ctx, t, c, termdash.KeyboardSubscriber(keyHandler), termdash.RedrawInterval(50*time.Millisecond)); err != nil {
+		panic(err)
+	}
+	return 0
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// デモ中にボットが命令を考え直す間隔
+const demoDecisionInterval = 5 * time.Second
+
+// 自動で操艦するボット
+// 画面を持たないので、デモのほかにシミュレーションの耐久試験にも使える
+type bot interface {
+	// 現在の状態を見て出す命令を決める
+	decide(p *Player) []order
+}
+
+// 速度と針路を適当に変えながら哨戒するボット
+type patrolBot struct {
+	rng *rand.Rand
+	leg int
+}
+
+func newPatrolBot(rng *rand.Rand) *patrolBot {
+	return &patrolBot{rng: rng}
+}
+
+func (b *patrolBot) decide(p *Player) []order {
+	b.leg++
+	switch b.leg % 3 {
+	case 0:
+		// 増速して直進
+		return []order{
+			{Kind: orderTurbineRpm, Value: float64(80 + b.rng.Intn(80))},
+			{Kind: orderRudder, Value: 0},
+		}
+	case 1:
+		// 変針
+		return []order{{Kind: orderRudder, Value: float64(b.rng.Intn(41) - 20)}}
+	default:
+		// 減速して聴音
+		return []order{
+			{Kind: orderTurbineRpm, Value: float64(20 + b.rng.Intn(40))},
+			{Kind: orderRudder, Value: 0},
+		}
+	}
+}
+
+// ボットに実際の艦を操艦させる。画面を持たない耐久試験 (soak.go) だけが使う
+type botHelm struct {
+	orders *orderSystem
+	bot    bot
+	// 次に命令を考える時刻 (シミュレーション時間)
+	next time.Duration
+}
+
+// ゲームループのタイマーから呼ぶ
+func (h *botHelm) step(p *Player, now time.Duration) {
+	if now < h.next {
+		return
+	}
+	h.next = now + demoDecisionInterval
+	for _, o := range h.bot.decide(p) {
+		if err := h.orders.issue(o); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// 哨戒の前のタイトル画面のデモ
+//
+// 起動するとまずタイトル画面を出し、哨戒とは別の練習艦と sim.World でボットに操艦させる。
+// パネルには練習艦が出る (playerState.attractMode)。タイトル画面の間は哨戒の世界を進めない (gameLoop.title)。
+// キーが押されたらデモを終えて哨戒を始める。ボットが哨戒中の艦に命令を出すことはなく、
+// 一度哨戒が始まればタイトル画面には戻らない。
+// 画面より先にゲームループが動き出すので、タイトル画面かどうかはループを作るときに決め、ボットと練習艦は画面ができてから start で用意する。
+type demoMode struct {
+	events *eventLog
+	bot    bot
+	rng    *rand.Rand
+	// デモの開始・終了時に呼ばれる
+	onChange func(active bool)
+
+	mu     sync.Mutex
+	active bool
+
+	// 練習艦とその世界、進めた時刻と、知らせた不変条件の違反 (艦の状態の置き場の鍵を持って触る)
+	boat         Player
+	world        *sim.World
+	last         time.Time
+	nextDecision time.Time
+	reported     map[string]bool
+}
+
+// title なら起動したときからタイトル画面を出す
+func newDemoMode(events *eventLog, title bool) *demoMode {
+	return &demoMode{events: events, active: title}
+}
+
+// ボットと練習艦を用意する。rng はボットと練習艦の世界に使い、練習艦は哨戒の艦 p と同じ場所から出る
+// 艦の状態の置き場の鍵を持って呼ぶ。onChange はいまタイトル画面を出しているかを渡してすぐにも呼ぶ
+func (d *demoMode) start(p *Player, b bot, rng *rand.Rand, onChange func(bool)) {
+	d.bot, d.rng, d.onChange = b, rng, onChange
+	active := d.on()
+	if active {
+		now := clock.Now()
+		d.boat = Player{Player: sim.NewPlayer(), oxygen: atmosphereO2, co2: atmosphereCO2}
+		d.boat.Position = sim.Point3D{X: p.Position.X, Y: p.Position.Y}
+		d.world = sim.NewWorld(&d.boat.Player, d.rng)
+		d.last = now
+		d.nextDecision = now
+		d.reported = map[string]bool{}
+		d.events.add(cell.ColorMagenta, "[DEMO] Demo patrol on a practice boat, press any key to start your patrol.")
+	}
+	d.onChange(active)
+}
+
+// 入力があったことを知らせる
+// タイトル画面だった場合はデモを終えて哨戒を始め、true を返す (その入力は操作として扱わない)
+func (d *demoMode) input() bool {
+	d.mu.Lock()
+	wasActive := d.active
+	d.active = false
+	d.mu.Unlock()
+
+	if wasActive {
+		d.events.add(cell.ColorMagenta, "[DEMO] Demo ended. Patrol started.")
+		if d.onChange != nil {
+			d.onChange(false)
+		}
+	}
+	return wasActive
+}
+
+// タイトル画面を出しているか
+func (d *demoMode) on() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.active
+}
+
+// デモ中なら練習艦 (写しに入れる。艦の状態の置き場の鍵を持って呼ぶ)
+func (d *demoMode) shown() *Player {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.active {
+		return nil
+	}
+	return &d.boat
+}
+
+// ゲームループから呼ぶ (gameLoop.title)。タイトル画面の間は練習艦を進めて true を返す
+func (d *demoMode) step(now time.Time) bool {
+	if !d.on() {
+		return false
+	}
+	// 画面ができて start を呼ぶまでは、哨戒を止めておくだけ
+	if d.world == nil {
+		return true
+	}
+
+	d.world.Step(now.Sub(d.last))
+	d.last = now
+	// デモ中は耐久試験も兼ねてシミュレーションの不変条件を確かめる
+	for _, v := range checkInvariants(&d.boat) {
+		if !d.reported[v] {
+			d.reported[v] = true
+			d.events.add(cell.ColorRed, "[DEMO] Invariant violated: %s", v)
+		}
+	}
+	if now.Before(d.nextDecision) {
+		return true
+	}
+	d.nextDecision = now.Add(demoDecisionInterval)
+	for _, o := range d.bot.decide(&d.boat) {
+		// 断られたら (燃料切れなど) 次の判断を待つだけ
+		o.apply(&d.boat)
+	}
+	return true
+}
+
+// シミュレーションが壊れていないか調べ、違反していた内容を返す
+func checkInvariants(p *Player) []string {
+	var violations []string
+	values := []struct {
+		name  string
+		value float64
+	}{
+		{"velocity", p.Velocity},
+		{"acceleration", p.Acceleration},
+		{"turbine rpm", p.Turbine.Actual},
+		{"direction", p.Direction},
+		{"position.x", p.Position.X},
+		{"position.y", p.Position.Y},
+		{"position.z", p.Position.Z},
+		{"fuel", p.Fuel},
+	}
+	for _, v := range values {
+		if math.IsNaN(v.value) || math.IsInf(v.value, 0) {
+			violations = append(violations, v.name+" is not a finite number")
+		}
+	}
+	if p.Fuel < 0 {
+		violations = append(violations, "fuel is negative")
+	}
+	if p.Turbine.Ordered < p.Turbine.Min || p.Turbine.Ordered > p.Turbine.Max {
+		violations = append(violations, "turbine rpm setting out of range")
+	}
+	return violations
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 起動するとタイトル画面のデモが出て、キーを押すと哨戒が始まる
+func TestDemo(t *testing.T) {
+	g := startGameWith(t, gameOptions{title: true})
+	g.expect("DEMO - PRESS ANY KEY TO START PATROL")
+	g.expect("[DEMO] Demo patrol on a practice boat")
+	// タイトル画面の間は哨戒の世界を進めない
+	g.advance(time.Minute)
+	g.key("x")
+	g.expect("Demo ended. Patrol started.")
+	g.expect("PRESS Q TO QUIT")
+	// 哨戒が始まったあとは放置してもタイトル画面には戻らない
+	g.advance(5 * time.Second)
+	g.expect("PRESS Q TO QUIT")
+	g.expectNot("DEMO - PRESS ANY KEY")
+	g.key("q")
+	g.expect("Patrol time        0:00:05")
+}
+
+// タイトル画面では Q で哨戒を始めずに終える
+func TestDemoQuit(t *testing.T) {
+	g := startGameWith(t, gameOptions{title: true})
+	g.expect("DEMO - PRESS ANY KEY TO START PATROL")
+	g.key("q")
+	select {
+	case <-g.ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Q on the title screen did not quit")
+	}
+}
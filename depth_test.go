@@ -0,0 +1,19 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 海面にいる
+func TestDepth(t *testing.T) {
+	g := startGame(t)
+	g.advance(time.Second)
+	g.expect("0.0 m")
+	g.expect("T300 C450")
+	g.expect("0 ██<")
+	// 潜航すると深度が変わる
+	g.key("d")
+	g.advance(30 * time.Second)
+	g.expectNot("0.0 m")
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/mum4k/termdash/widgets/text"
+)
+
+// 深度計
+//
+// 縦の目盛りで深度を出す。潜望鏡深度までは緑、安全潜航深度 (試験深度) までは既定の色、
+// 圧壊深度までは黄、それより深いところは赤で塗り、今の深度の行に < を、真下の海底の行に ~ を付ける。
+
+const (
+	// 安全潜航深度 (試験深度, m)
+	testDepth = 300.0
+	// 圧壊深度 (m)
+	crushDepth = 450.0
+	// 目盛りの行数と1行あたりの深さ (m)
+	depthGaugeRows = 16
+	depthGaugeStep = 30.0
+)
+
+// depth (m) の帯の色
+func depthBandColor(depth float64) cell.Color {
+	switch {
+	case depth <= periscopeDepth:
+		return cell.ColorGreen
+	case depth <= testDepth:
+		return cell.ColorDefault
+	case depth < crushDepth:
+		return cell.ColorYellow
+	}
+	return cell.ColorRed
+}
+
+// 深度計の表示
+func depthGauge(p *Player, t *text.Text) {
+	depth := p.Depth()
+	seabed, _ := terrain.SeabedAt(p.Position.X, p.Position.Y)
+	// 行 i は i*depthGaugeStep から次の行までの深さ
+	row := func(d float64) int {
+		return int(math.Min(math.Floor(d/depthGaugeStep), depthGaugeRows-1))
+	}
+
+	t.Reset()
+	if err := t.Write(fmt.Sprintf("%7s\n", units.depth(depth, 1)), text.WriteCellOpts(cell.FgColor(depthBandColor(depth)))); err != nil {
+		panic(err)
+	}
+	for i := 0; i < depthGaugeRows; i++ {
+		top := float64(i) * depthGaugeStep
+		if err := t.Write(fmt.Sprintf("%3.0f ", top)); err != nil {
+			panic(err)
+		}
+		if err := t.Write(render.glyph("██", "##"), text.WriteCellOpts(cell.FgColor(depthBandColor(top)))); err != nil {
+			panic(err)
+		}
+		marker := " "
+		switch i {
+		case row(depth):
+			marker = "<"
+		case row(seabed):
+			marker = "~"
+		}
+		if err := t.Write(marker + "\n"); err != nil {
+			panic(err)
+		}
+	}
+	if err := t.Write(fmt.Sprintf("T%.0f C%.0f\n", testDepth, crushDepth)); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 魚雷回避訓練
+//
+// 演習魚雷を何本も撃ち込み、回避の技量を採点する。
+//   - 回避できたか (50 点)
+//   - 囮 (ノイズメーカー) を出す時機。魚雷が 1000 ~ 2500 m に来たときが最もよい (20 点)
+//   - 深度の変更。50 m 以上変えれば 15 点、変温層をまたげばさらに 5 点
+//   - 魚雷に艦尾を向けているか (10 点)
+//
+// 演習魚雷は当たっても船体を傷めない。
+
+const (
+	// 撃ち込む本数
+	drillShots = 5
+	// 1本終わってから次を撃つまで
+	drillShotInterval = 20 * time.Second
+	// 発射距離 (m)
+	drillLaunchRange = 5000.0
+	// 訓練で使えるノイズメーカーの数
+	drillNoisemakers = 6
+	// ノイズメーカーの次発装填にかかる時間 (通常航海のとき)
+	noisemakerReload = 10 * time.Second
+)
+
+const (
+	// 魚雷の速力 (ノット)。演習魚雷も敵の魚雷も同じ
+	torpedoSpeed = 45.0
+	// 航走距離 (m)
+	torpedoRunLength = 9000.0
+	// シーカーの探知距離 (m) と視野 (度, 片側)
+	torpedoSeekerRange = 2000.0
+	torpedoSeekerCone  = 45.0
+	// 1秒で変えられる深度 (m)
+	torpedoDepthRate = 5.0
+	// 1秒で変えられる針路 (度)
+	torpedoTurnRate = 20.0
+	// これより近づいたら命中 (m)
+	torpedoHitRange = 30.0
+
+	// ノイズメーカーが鳴っている時間
+	noisemakerLife = 60 * time.Second
+	// ノイズメーカーの音の大きさ (dB)
+	noisemakerNoise = 150.0
+)
+
+type torpedo struct {
+	position sim.Point3D
+	course   float64
+	// 速力 (ノット) と残りの航走距離 (m)
+	speed float64
+	run   float64
+	// 捉えている目標 (nil なら直進)
+	target *sim.Point3D
+	// 囮に引き寄せられているか
+	decoyed bool
+}
+
+// 1本ごとの採点
+type drillShot struct {
+	launchDepth float64
+	// 最初の囮を出したときの魚雷との距離 (出していなければ NaN)
+	decoyRange   float64
+	crossedLayer bool
+	// 魚雷が最も近づいたときの、艦首から見た魚雷の相対方位
+	closestAspect float64
+	closest       float64
+}
+
+func (s drillShot) score(evaded bool, finalDepth float64) int {
+	score := 0
+	if evaded {
+		score += 50
+	}
+	switch {
+	case math.IsNaN(s.decoyRange):
+	case s.decoyRange >= 1000 && s.decoyRange <= 2500:
+		score += 20
+	default:
+		score += 5
+	}
+	if math.Abs(finalDepth-s.launchDepth) >= 50 {
+		score += 15
+	}
+	if s.crossedLayer {
+		score += 5
+	}
+	if math.Abs(s.closestAspect) >= 120 {
+		score += 10
+	}
+	return score
+}
+
+// 訓練の進行
+type torpedoDrill struct {
+	events *eventLog
+	env    *environment
+	rng    *rand.Rand
+	// 画面の表示を変える
+	status func(string)
+
+	mu          sync.Mutex
+	shot        int
+	total       int
+	noisemakers int
+	// 時刻はどれもシミュレーション上の時刻
+	now        time.Duration
+	reloaded   time.Duration
+	decoys     []noisemaker
+	fish       *torpedo
+	current    drillShot
+	started    bool
+	nextLaunch time.Duration
+	finished   bool
+}
+
+func newTorpedoDrill(events *eventLog, env *environment, rng *rand.Rand, status func(string)) *torpedoDrill {
+	return &torpedoDrill{
+		events:      events,
+		env:         env,
+		rng:         rng,
+		status:      status,
+		noisemakers: drillNoisemakers,
+	}
+}
+
+// ノイズメーカーを出す (orderCountermeasure の処理)
+func (d *torpedoDrill) launchNoisemaker(p *Player) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.noisemakers == 0 {
+		return orderRefusedError{"no noisemakers left"}
+	}
+	if d.now < d.reloaded {
+		return orderRefusedError{"launcher reloading"}
+	}
+	d.noisemakers--
+	d.reloaded = d.now + time.Duration(float64(noisemakerReload)*p.reloadFactor())
+	d.decoys = append(d.decoys, noisemaker{position: p.Position, noise: noisemakerNoise, expires: d.now + noisemakerLife})
+	if d.fish != nil && math.IsNaN(d.current.decoyRange) {
+		d.current.decoyRange = distance3D(p.Position, d.fish.position)
+	}
+	d.events.add(cell.ColorCyan, "[DRILL] Noisemaker away. %d left.", d.noisemakers)
+	return nil
+}
+
+func distance3D(a, b sim.Point3D) float64 {
+	return math.Sqrt(math.Pow(a.X-b.X, 2) + math.Pow(a.Y-b.Y, 2) + math.Pow(a.Z-b.Z, 2))
+}
+
+// d.mu を保持した状態で呼ぶ
+func (d *torpedoDrill) launch(p *Player) {
+	d.shot++
+	bearing := d.rng.Float64() * 360
+	rad := bearing * math.Pi / 180
+	pos := sim.Point3D{
+		X: p.Position.X + math.Sin(rad)*drillLaunchRange,
+		Y: p.Position.Y + math.Cos(rad)*drillLaunchRange,
+		Z: math.Min(p.Position.Z+(d.rng.Float64()-0.5)*100, -10),
+	}
+	d.fish = &torpedo{
+		position: pos,
+		course:   sim.BearingTo(pos, p.Position),
+		speed:    torpedoSpeed,
+		run:      torpedoRunLength,
+	}
+	d.current = drillShot{
+		launchDepth:   p.Depth(),
+		decoyRange:    math.NaN(),
+		closest:       math.Inf(1),
+		closestAspect: 0,
+	}
+	d.events.add(cell.ColorRed, "[DRILL] Torpedo in the water! Bearing %03.0f. Shot %d of %d.", bearing, d.shot, drillShots)
+}
+
+// d.mu を保持した状態で呼ぶ
+func (d *torpedoDrill) resolve(p *Player, evaded bool) {
+	score := d.current.score(evaded, p.Depth())
+	d.total += score
+	if evaded {
+		d.events.add(cell.ColorGreen, "[DRILL] Torpedo evaded. Shot score %d.", score)
+	} else {
+		d.events.add(cell.ColorRed, "[DRILL] Practice hit. Shot score %d.", score)
+	}
+	d.fish = nil
+	d.decoys = nil
+	if d.shot >= drillShots {
+		d.finished = true
+		d.events.add(cell.ColorGreen, "[DRILL] Drill complete. Score %d / %d.", d.total, drillShots*100)
+		return
+	}
+	d.nextLaunch = d.now + drillShotInterval
+}
+
+// シミュレーション上の時刻 now まで、dt 秒分だけ訓練を進める
+func (d *torpedoDrill) step(p *Player, now time.Duration, dt float64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.now = now
+	if d.finished {
+		return
+	}
+	if d.fish == nil {
+		if !d.started {
+			d.started = true
+			d.nextLaunch = now + drillShotInterval/2
+			d.events.add(cell.ColorCyan, "[DRILL] Torpedo evasion drill. First shot in %s.", drillShotInterval/2)
+		}
+		if now >= d.nextLaunch {
+			d.launch(p)
+		}
+		d.updateStatus()
+		return
+	}
+
+	remaining := d.decoys[:0]
+	for _, n := range d.decoys {
+		if now < n.expires {
+			remaining = append(remaining, n)
+		}
+	}
+	d.decoys = remaining
+
+	t := d.fish
+	t.target, t.decoyed = seekTarget(d.env, t, p, d.decoys)
+	t.advance(dt)
+
+	if layer := d.env.layerDepthAt(p.Position.X, p.Position.Y); (p.Depth() < layer) != (d.current.launchDepth < layer) {
+		d.current.crossedLayer = true
+	}
+	r := distance3D(p.Position, t.position)
+	if r < d.current.closest {
+		d.current.closest = r
+		d.current.closestAspect = sim.NormalizeRelative(sim.BearingTo(p.Position, t.position) - p.Direction)
+	}
+
+	switch {
+	case r < torpedoHitRange && !t.decoyed:
+		d.resolve(p, false)
+	case t.run <= 0:
+		d.resolve(p, true)
+	}
+	d.updateStatus()
+}
+
+// 目標に向けて変針・変深し、dt 秒分だけ走る
+func (t *torpedo) advance(dt float64) {
+	if t.target != nil {
+		turn := sim.NormalizeRelative(sim.BearingTo(t.position, *t.target) - t.course)
+		t.course = sim.NormalizeBearing(t.course + math.Max(math.Min(turn, torpedoTurnRate*dt), -torpedoTurnRate*dt))
+		dz := t.target.Z - t.position.Z
+		t.position.Z += math.Max(math.Min(dz, torpedoDepthRate*dt), -torpedoDepthRate*dt)
+	}
+	move := t.speed * knot * dt
+	rad := t.course * math.Pi / 180
+	t.position.X += math.Sin(rad) * move
+	t.position.Y += math.Cos(rad) * move
+	t.run -= move
+}
+
+// シーカーが捉えるもの
+// 視野の中で最も大きく聞こえるものに向かう。変温層をまたぐと探知距離が半分になる
+func seekTarget(env *environment, t *torpedo, p *Player, decoys []noisemaker) (*sim.Point3D, bool) {
+	inCone := func(pos sim.Point3D) (float64, bool) {
+		r := distance3D(t.position, pos)
+		limit := torpedoSeekerRange
+		if layer := env.layerDepthAt(t.position.X, t.position.Y); (-t.position.Z < layer) != (-pos.Z < layer) {
+			limit /= 2
+		}
+		off := math.Abs(sim.NormalizeRelative(sim.BearingTo(t.position, pos) - t.course))
+		return r, r <= limit && off <= torpedoSeekerCone
+	}
+
+	var best *sim.Point3D
+	decoyed := false
+	loudest := math.Inf(-1)
+	if r, ok := inCone(p.Position); ok {
+		own := p.Position
+		best = &own
+		loudest = p.noiseLevel() - 20*math.Log10(math.Max(r, 1))
+	}
+	for _, n := range decoys {
+		if r, ok := inCone(n.position); ok {
+			if level := n.noise - 20*math.Log10(math.Max(r, 1)); level > loudest {
+				pos := n.position
+				best, loudest, decoyed = &pos, level, true
+			}
+		}
+	}
+	if best == nil && t.target != nil && !t.decoyed {
+		// 見失ったら最後に捉えていた位置へ向かう
+		return t.target, false
+	}
+	return best, decoyed
+}
+
+// 走っている魚雷と囮の位置
+func (d *torpedoDrill) weapons() (torpedoes, decoys []sim.Point3D) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.fish != nil {
+		torpedoes = append(torpedoes, d.fish.position)
+	}
+	for _, n := range d.decoys {
+		decoys = append(decoys, n.position)
+	}
+	return torpedoes, decoys
+}
+
+// d.mu を保持した状態で呼ぶ
+func (d *torpedoDrill) updateStatus() {
+	msg := fmt.Sprintf("TORPEDO DRILL - SHOT %d/%d - SCORE %d - NOISEMAKERS %d", d.shot, drillShots, d.total, d.noisemakers)
+	if d.fish != nil {
+		msg += " - TORPEDO RUNNING"
+	}
+	if d.finished {
+		msg = fmt.Sprintf("TORPEDO DRILL COMPLETE - SCORE %d/%d - PRESS Q TO QUIT", d.total, drillShots*100)
+	}
+	d.status(msg)
+}
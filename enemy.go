@@ -0,0 +1,365 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+	"github.com/rs0604/explorergame/world"
+)
+
+// 敵の艦艇
+//
+// 地形と一緒に作った哨戒区域 (world.Patrol) ごとに水上艦か潜水艦を 1 隻置く。シナリオの港には哨戒艇も置く (harbor.go)。
+// どれも次の行動を切り替える。
+//   - 哨戒: 哨戒区域の航路を低速で回る
+//   - 捜索: 自艦の音を聞いたところへ向かい、その周りを回って探す
+//   - 攻撃: 自艦をはっきり聞いていれば近づき、射程に入ると魚雷を撃つ
+// 切り替えの加減は艦ごとの性格 (personality.go) で変わる。
+//
+// 敵は商船と同じく traffic の船として動くので、探知・データム・脅威の表示がそのまま反応する。
+// 敵の聴音器も速く走るほど流体雑音でふさがれる。
+
+const (
+	// 行動ごとの速力 (ノット)
+	surfacePatrolSpeed   = 12.0
+	surfaceSearchSpeed   = 18.0
+	surfaceAttackSpeed   = 25.0
+	submarinePatrolSpeed = 5.0
+	submarineSearchSpeed = 8.0
+	submarineAttackSpeed = 12.0
+	// 放射雑音 (dB) = 基準 + 速力 (kt) × enemyNoisePerKnot
+	surfaceCombatantNoise = 125.0
+	submarineNoise        = 95.0
+	enemyNoisePerKnot     = 1.0
+	// 潜水艦の深度 (m)。海底から submarineBottomMargin は離れ、submarineShallowest より浅くはしない
+	submarinePatrolDepth  = 150.0
+	submarineBottomMargin = 40.0
+	submarineShallowest   = 30.0
+	// 航路の点に着いたとみなす距離 (m)
+	waypointArrival = 300.0
+	// 捜索で回る半径 (m) と、最後に聞いてから捜索を続ける時間 (標準の性格)
+	enemySearchRadius = 2000.0
+	enemySearchTime   = 10 * time.Minute
+	// 信号余裕がこれだけあれば攻撃に移る (dB, 標準の性格)
+	enemyAttackMargin = 6.0
+	// 聞こえなくなってから攻撃をやめるまで (標準の性格)
+	enemyAttackTimeout = time.Minute
+	// 魚雷の射程 (m)、1 隻の搭載数、次を撃つまで
+	enemyWeaponRange = 6000.0
+	enemyTorpedoLoad = 4
+	enemyReload      = 90 * time.Second
+	// 港の哨戒艇の搭載数
+	patrolBoatTorpedoLoad = 2
+	// 敵が聴音する間隔 (シミュレーション時間)
+	enemyListenInterval = time.Second
+)
+
+// 命中したときの船体の損傷 (%)。難易度で変わる (config.go)
+var torpedoDamage = 40.0
+
+var (
+	surfaceCombatantClasses = []string{"Frigate", "Destroyer", "Corvette"}
+	enemyNames              = []string{"Vigilant", "Resolute", "Tireless", "Relentless", "Sentinel", "Harrier", "Wolverine", "Barracuda"}
+)
+
+type enemyBehavior int
+
+const (
+	behaviorPatrol enemyBehavior = iota
+	behaviorSearch
+	behaviorAttack
+)
+
+func (b enemyBehavior) String() string {
+	switch b {
+	case behaviorSearch:
+		return "search"
+	case behaviorAttack:
+		return "attack"
+	}
+	return "patrol"
+}
+
+type enemy struct {
+	// traffic の船の id
+	id        int
+	submarine bool
+	route     []sim.Point3D
+	leg       int
+	behavior  enemyBehavior
+	// 自艦がいると思っている位置と、最後に聞いた時刻
+	datum sim.Point3D
+	heard time.Duration
+	// 捜索をやめる時刻
+	searchUntil time.Duration
+	torpedoes   int
+	reloaded    time.Duration
+	personality *enemyPersonality
+}
+
+// 行動ごとの速力 (ノット)
+func (e *enemy) speed() float64 {
+	switch {
+	case e.submarine && e.behavior == behaviorAttack:
+		return submarineAttackSpeed
+	case e.submarine && e.behavior == behaviorSearch:
+		return submarineSearchSpeed
+	case e.submarine:
+		return submarinePatrolSpeed
+	case e.behavior == behaviorAttack:
+		return surfaceAttackSpeed
+	case e.behavior == behaviorSearch:
+		return surfaceSearchSpeed
+	}
+	return surfacePatrolSpeed
+}
+
+// 速力 (ノット) での放射雑音 (dB)
+func (e *enemy) noise(knots float64) float64 {
+	if e.submarine {
+		return submarineNoise + knots*enemyNoisePerKnot
+	}
+	return surfaceCombatantNoise + knots*enemyNoisePerKnot
+}
+
+// 潜水艦が潜る深度 (m)
+func submarineDepth(pos sim.Point3D) float64 {
+	seabed, _ := terrain.SeabedAt(pos.X, pos.Y)
+	return math.Max(math.Min(submarinePatrolDepth, seabed-submarineBottomMargin), submarineShallowest)
+}
+
+type enemyFleet struct {
+	events  *eventLog
+	env     *environment
+	traffic *traffic
+	pinger  *sonarPinger
+	// 自艦が出した囮
+	decoys func() []noisemaker
+	rng    *rand.Rand
+
+	mu sync.Mutex
+	// シミュレーション上の時刻
+	now        time.Duration
+	lastListen time.Duration
+	listened   bool
+	enemies    []*enemy
+	fish       []*torpedo
+}
+
+// 哨戒区域ごとに personality の性格の敵を出す
+func newEnemyFleet(events *eventLog, env *environment, tr *traffic, pinger *sonarPinger, decoys func() []noisemaker, patrols []world.Patrol, personality *enemyPersonality, rng *rand.Rand) *enemyFleet {
+	f := &enemyFleet{events: events, env: env, traffic: tr, pinger: pinger, decoys: decoys, rng: rng}
+	names := rng.Perm(len(enemyNames))
+	for i, patrol := range patrols {
+		class := "Submarine"
+		if !patrol.Submarine {
+			class = surfaceCombatantClasses[rng.Intn(len(surfaceCombatantClasses))]
+		}
+		f.add(enemyNames[names[i%len(names)]], class, patrol.Submarine, patrol.Route, enemyTorpedoLoad, personality)
+	}
+	return f
+}
+
+// route を回る敵を出し、船の id を返す
+func (f *enemyFleet) add(name, class string, submarine bool, route []sim.Point3D, torpedoes int, personality *enemyPersonality) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e := &enemy{submarine: submarine, route: route, torpedoes: torpedoes, personality: personality}
+	e.id = f.traffic.spawnAt(name, class, hostileFlag, route[0], 0, 0)
+	f.enemies = append(f.enemies, e)
+	return e.id
+}
+
+// 港の哨戒艇を出す (harbor.go)。ほかの水上艦と同じく航路を回り、聴音して自艦を探す
+func (f *enemyFleet) addPatrolBoat(name string, route []sim.Point3D, personality *enemyPersonality) int {
+	return f.add(name, patrolBoatClass, false, route, patrolBoatTorpedoLoad, personality)
+}
+
+// 船 ids の敵に自艦が pos にいると知らせ、捜索に向かわせる (港の警報)
+// すでに攻撃している敵はそのまま
+func (f *enemyFleet) alert(ids []int, pos sim.Point3D) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, e := range f.enemies {
+		for _, id := range ids {
+			if e.id != id || e.behavior == behaviorAttack {
+				continue
+			}
+			e.datum, e.heard = pos, f.now
+			e.behavior = behaviorSearch
+			e.searchUntil = f.now + e.personality.searchTime
+		}
+	}
+}
+
+// シミュレーション上の時刻 now まで、dt 秒分だけ敵を動かす
+func (f *enemyFleet) step(p *Player, now time.Duration, dt float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = now
+	listen := !f.listened || now-f.lastListen >= enemyListenInterval
+	if listen {
+		f.listened = true
+		f.lastListen = now
+	}
+	sector, exposed := f.pinger.exposed()
+
+	remaining := f.enemies[:0]
+	for _, e := range f.enemies {
+		v, ok := f.traffic.vessel(e.id)
+		if !ok {
+			continue
+		}
+		remaining = append(remaining, e)
+		if listen {
+			f.listen(e, &v, p, sector, exposed)
+		}
+		course := f.maneuver(e, &v, p.Position)
+		speed := e.speed()
+		depth := 0.0
+		if e.submarine {
+			depth = submarineDepth(v.position)
+		}
+		f.traffic.steer(e.id, course, speed, depth, e.noise(speed))
+	}
+	f.enemies = remaining
+	f.runTorpedoes(p, dt)
+}
+
+// 敵の聴音器で自艦を聞き、行動を決める (f.mu を保持した状態で呼ぶ)
+func (f *enemyFleet) listen(e *enemy, v *vessel, p *Player, sector pingSector, exposed bool) {
+	pers := e.personality
+	sonar := v.sonar()
+	noise := hydrophoneNoise(f.traffic.noiseHeardBy(sonar, v.id), v.speed/knot)
+	source := sonarTarget{sourceLevel: p.noiseLevel() + ownShipSourceOffset}
+	se := passiveSonar.signalExcess(f.env, sonar, p.Position, source, noise)
+	intercepted := exposed && pingIntercepted(f.env, p.Position, sonar, sector, noise)
+	heard := se >= 0 || intercepted
+	// 未熟な乗員は聞こえていても聞き逃すことがある
+	if heard && pers.missRate > 0 && f.rng.Float64() < pers.missRate {
+		heard = false
+	}
+	if !heard {
+		switch {
+		case e.behavior == behaviorAttack && f.now-e.heard > pers.attackTimeout:
+			e.behavior = behaviorSearch
+			e.searchUntil = e.heard + pers.searchTime
+		case e.behavior == behaviorSearch && f.now > e.searchUntil:
+			e.behavior = behaviorPatrol
+		}
+		return
+	}
+
+	// 聞いた方位と距離の誤差の分だけずれたところに自艦がいると思う
+	spread := datumInitialRadius / 2 * pers.datumError
+	e.datum = sim.Point3D{
+		X: p.Position.X + f.rng.NormFloat64()*spread,
+		Y: p.Position.Y + f.rng.NormFloat64()*spread,
+		Z: p.Position.Z,
+	}
+	e.heard = f.now
+	switch {
+	case se >= pers.attackMargin || intercepted && sim.HorizontalDistance(v.position, p.Position) <= enemyWeaponRange:
+		e.behavior = behaviorAttack
+	case e.behavior == behaviorPatrol:
+		e.behavior = behaviorSearch
+	}
+	e.searchUntil = f.now + pers.searchTime
+}
+
+// 行動に合わせた針路。射程に入っていれば魚雷を撃つ (f.mu を保持した状態で呼ぶ)
+func (f *enemyFleet) maneuver(e *enemy, v *vessel, own sim.Point3D) float64 {
+	switch e.behavior {
+	case behaviorAttack:
+		if sim.HorizontalDistance(v.position, e.datum) <= enemyWeaponRange*e.personality.fireRange && e.torpedoes > 0 && f.now >= e.reloaded {
+			f.launch(e, v, own)
+		}
+		return sim.BearingTo(v.position, e.datum)
+	case behaviorSearch:
+		if sim.HorizontalDistance(v.position, e.datum) > e.personality.searchRadiusAfter(f.now-e.heard) {
+			return sim.BearingTo(v.position, e.datum)
+		}
+		// データムの周りを回る
+		return sim.NormalizeBearing(sim.BearingTo(e.datum, v.position) + 90)
+	}
+	wp := e.route[e.leg]
+	if sim.HorizontalDistance(v.position, wp) < waypointArrival {
+		e.leg = (e.leg + 1) % len(e.route)
+		wp = e.route[e.leg]
+	}
+	return sim.BearingTo(v.position, wp)
+}
+
+// 自艦がいると思う位置へ魚雷を撃つ。発射音は自艦にも聞こえる (f.mu を保持した状態で呼ぶ)
+func (f *enemyFleet) launch(e *enemy, v *vessel, own sim.Point3D) {
+	e.torpedoes--
+	e.reloaded = f.now + enemyReload
+	start := v.hull()
+	target := e.datum
+	f.fish = append(f.fish, &torpedo{
+		position: start,
+		course:   sim.BearingTo(start, target),
+		speed:    torpedoSpeed,
+		run:      torpedoRunLength,
+		target:   &target,
+	})
+	f.events.add(cell.ColorRed, "[ALARM] Torpedo in the water! Bearing %03.0f.", sim.BearingTo(own, start))
+}
+
+// 魚雷を進める。当たれば船体を傷める。囮に向かい始めたらイベントログに出す (f.mu を保持した状態で呼ぶ)
+func (f *enemyFleet) runTorpedoes(p *Player, dt float64) {
+	decoys := f.decoys()
+	remaining := f.fish[:0]
+	for _, t := range f.fish {
+		wasDecoyed := t.decoyed
+		t.target, t.decoyed = seekTarget(f.env, t, p, decoys)
+		if t.decoyed && !wasDecoyed {
+			f.events.add(cell.ColorGreen, "[SONAR] Torpedo bearing %03.0f is going for the decoy.", sim.BearingTo(p.Position, t.position))
+		}
+		t.advance(dt)
+		switch {
+		case distance3D(p.Position, t.position) < torpedoHitRange && !t.decoyed:
+			hit := sim.CompartmentAt(sim.BearingTo(p.Position, t.position) - p.Direction)
+			p.TakeDamage(hit, torpedoDamage)
+			f.events.add(cell.ColorRed, "[ALARM] Torpedo hit in the %s! Hull integrity %.0f%%", hit, p.HullIntegrity)
+		case t.run <= 0:
+			f.events.add(cell.ColorGreen, "[SONAR] Torpedo bearing %03.0f has run out.", sim.BearingTo(p.Position, t.position))
+		default:
+			remaining = append(remaining, t)
+		}
+	}
+	f.fish = remaining
+}
+
+// 走っている魚雷の数
+func (f *enemyFleet) incoming() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.fish)
+}
+
+// 走っている魚雷の位置 (デブリーフィングの記録)。敵は囮を出さない
+func (f *enemyFleet) weapons() (torpedoes, decoys []sim.Point3D) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, t := range f.fish {
+		torpedoes = append(torpedoes, t.position)
+	}
+	return torpedoes, nil
+}
+
+// 船の id ごとの行動と性格 (教官席)。例: "search (elite)"
+func (f *enemyFleet) behaviors() map[int]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m := map[int]string{}
+	for _, e := range f.enemies {
+		m[e.id] = fmt.Sprintf("%s (%s)", e.behavior, e.personality.name)
+	}
+	return m
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/mum4k/termdash/align"
+	"github.com/mum4k/termdash/cell"
+	"github.com/mum4k/termdash/container"
+	"github.com/mum4k/termdash/keyboard"
+	"github.com/mum4k/termdash/linestyle"
+	"github.com/mum4k/termdash/widgetapi"
+	"github.com/mum4k/termdash/widgets/button"
+)
+
+// キーボードでのフォーカス
+//
+// Tab (focus-next) でボタンと一覧のパネルに順にフォーカスを移し、Enter で押す。フォーカスがある間は
+// 左右の矢印キーでもフォーカスを移し、上下の矢印キーは一覧の中を動く (一覧でなければフォーカスを移す)。
+// Esc でフォーカスを外すと矢印キーは操艦に戻る。フォーカスのあるボタンは色と ">...<" の印が、
+// パネルは枠の色と見出しの印が変わる。
+
+// フォーカスの色
+const focusColor = cell.ColorCyan
+
+type focusTarget struct {
+	// container の ID と、画面を組むときのオプション
+	id     string
+	layout []container.Option
+	// フォーカスを外したときと、フォーカスがあるときに container.Update に渡すオプション
+	normal, focused []container.Option
+	// Enter で押したとき。nil なら何もしない
+	activate func() error
+	// 上下の矢印キーで一覧の中を動く。nil なら上下でもフォーカスを移す
+	move func(dir int)
+}
+
+// 画面を組むときの container のオプション
+func (t *focusTarget) options() []container.Option {
+	return append([]container.Option{container.ID(t.id)}, t.layout...)
+}
+
+type focusRing struct {
+	mu      sync.Mutex
+	root    *container.Container
+	targets []*focusTarget
+	// フォーカスのある部品。-1 ならなし
+	current int
+}
+
+func newFocusRing() *focusRing {
+	return &focusRing{current: -1}
+}
+
+// ボタンを加える。普段のボタンと、フォーカスがあるときに差し替える色違いのボタンを作る
+func (f *focusRing) button(id, label string, fn func() error) (*focusTarget, error) {
+	normal, err := button.New(label, fn)
+	if err != nil {
+		return nil, err
+	}
+	focused, err := button.New(">"+label+"<", fn, button.FillColor(focusColor))
+	if err != nil {
+		return nil, err
+	}
+	t := &focusTarget{
+		id:       id,
+		layout:   []container.Option{container.PlaceWidget(normal), container.AlignHorizontal(align.HorizontalCenter)},
+		normal:   []container.Option{container.PlaceWidget(normal)},
+		focused:  []container.Option{container.PlaceWidget(focused)},
+		activate: fn,
+	}
+	f.add(t)
+	return t, nil
+}
+
+// 枠のあるパネルを加える
+func (f *focusRing) panel(id, title string, w widgetapi.Widget, activate func() error, move func(dir int)) *focusTarget {
+	t := &focusTarget{
+		id:       id,
+		layout:   []container.Option{container.Border(linestyle.Light), container.BorderTitle(title), container.PlaceWidget(w)},
+		normal:   []container.Option{container.BorderTitle(title), container.BorderColor(cell.ColorDefault)},
+		focused:  []container.Option{container.BorderTitle("> " + title + " <"), container.BorderColor(focusColor)},
+		activate: activate,
+		move:     move,
+	}
+	f.add(t)
+	return t
+}
+
+func (f *focusRing) add(t *focusTarget) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.targets = append(f.targets, t)
+}
+
+// 画面を組んだ後に呼ぶ
+func (f *focusRing) attach(root *container.Container) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.root = root
+}
+
+// フォーカスがあるか
+func (f *focusRing) active() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.current >= 0
+}
+
+// フォーカスを dir の向きに移す。フォーカスがなければ最初 (dir < 0 なら最後) の部品へ
+func (f *focusRing) next(dir int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.targets) == 0 {
+		return nil
+	}
+	next := cycleIndex(f.current, len(f.targets), dir)
+	if f.current < 0 {
+		next = 0
+		if dir < 0 {
+			next = len(f.targets) - 1
+		}
+	}
+	return f.set(next)
+}
+
+// フォーカスを外す
+func (f *focusRing) release() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.set(-1)
+}
+
+// フォーカスを i に移して表示を変える (f.mu を保持した状態で呼ぶ)
+func (f *focusRing) set(i int) error {
+	if f.root != nil && f.current >= 0 {
+		t := f.targets[f.current]
+		if err := f.root.Update(t.id, t.normal...); err != nil {
+			return err
+		}
+	}
+	f.current = i
+	if f.root != nil && i >= 0 {
+		t := f.targets[i]
+		if err := f.root.Update(t.id, t.focused...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// フォーカスがあるときのキー。処理したら true
+func (f *focusRing) handle(k keyboard.Key) (bool, error) {
+	f.mu.Lock()
+	if f.current < 0 {
+		f.mu.Unlock()
+		return false, nil
+	}
+	t := f.targets[f.current]
+	f.mu.Unlock()
+
+	switch k {
+	case keyboard.KeyEsc:
+		return true, f.release()
+	case keyboard.KeyArrowLeft:
+		return true, f.next(-1)
+	case keyboard.KeyArrowRight:
+		return true, f.next(1)
+	case keyboard.KeyArrowUp, keyboard.KeyArrowDown:
+		dir := 1
+		if k == keyboard.KeyArrowUp {
+			dir = -1
+		}
+		if t.move == nil {
+			return true, f.next(dir)
+		}
+		t.move(dir)
+		return true, nil
+	case keyboard.KeyEnter:
+		if t.activate == nil {
+			return true, nil
+		}
+		return true, t.activate()
+	}
+	return false, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Tab でボタンにフォーカスを移し、Enter で押す
+func TestFocus(t *testing.T) {
+	g := startGame(t)
+	g.advance(time.Second)
+	g.key("tab")
+	g.expect(">+ 10<")
+	g.key("enter")
+	g.expect("[ORDER] Turbine rpm 10")
+	// フォーカスがある間は矢印キーでフォーカスを移す (回転数は変わらない)
+	g.key("right")
+	g.expect(">- 10<")
+	g.expectNot("[ORDER] Turbine rpm 20")
+	g.key("enter")
+	g.expect("[ORDER] Turbine rpm 0")
+	// パネルでは上下の矢印キーで一覧の中を動く
+	g.key("tab")
+	g.key("tab")
+	g.key("tab")
+	g.key("tab")
+	g.expect("> Nav Map <")
+	// Esc でフォーカスを外すと矢印キーは操艦に戻る
+	g.key("esc")
+	g.expectNot("> Nav Map <")
+	g.key("up")
+	g.expect("[ORDER] Turbine rpm 10")
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 出港したばかりの船体はきれい
+func TestFouling(t *testing.T) {
+	g := startGame(t)
+	g.advance(2 * time.Second)
+	g.expect("Hull fouling 0%  Speed -0%")
+	g.key("u")
+	g.expect("Clean the hull refused: the hull is clean")
+}
+
+// 潜水員が汚れを落とし、支援艦に横付けしていればもっと早い
+func TestHullCleaning(t *testing.T) {
+	tender := sim.Point3D{X: 1000, Y: 1000}
+	tests := []struct {
+		name    string
+		at      sim.Point3D
+		minutes float64
+	}{
+		{"divers", sim.Point3D{}, 30 / diverCleanRate},
+		{"alongside the tender", tender, 30 / tenderCleanRate},
+	}
+	for _, tt := range tests {
+		events, logged := newTestEvents(t)
+		h := newHullCleaning(events, func() []sim.Point3D { return []sim.Point3D{tender} })
+		p := newTestPlayer()
+		p.Position = tt.at
+		p.Fouling = 30
+		if err := h.setActive(p, true); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		seconds := 0
+		for h.cleaning() && seconds < 3600 {
+			h.step(p, 1)
+			seconds++
+		}
+		// cleanHullFouling まで落とせば終わり
+		want := (30 - cleanHullFouling) / 30 * tt.minutes * 60
+		if math.Abs(float64(seconds)-want) > 1 || p.Fouling != 0 {
+			t.Errorf("%s: cleaned in %d s to %.2f%%, want %.0f s", tt.name, seconds, p.Fouling, want)
+		}
+		if !logged.contains("[HULL] Hull clean. Divers back aboard.") {
+			t.Errorf("%s: not logged: %q", tt.name, logged.lines)
+		}
+	}
+}
+
+// 行き足がつくと潜水員を呼び戻し、汚れは残る
+func TestHullCleaningRecalled(t *testing.T) {
+	events, logged := newTestEvents(t)
+	h := newHullCleaning(events, func() []sim.Point3D { return nil })
+	p := newTestPlayer()
+	p.Fouling = 30
+	if err := h.setActive(p, true); err != nil {
+		t.Fatal(err)
+	}
+	h.step(p, 60)
+	p.Velocity = 3
+	h.step(p, 60)
+	if h.cleaning() || p.Fouling != 30-diverCleanRate {
+		t.Errorf("fouling %.1f%% cleaning %v after making way", p.Fouling, h.cleaning())
+	}
+	if !logged.contains("[HULL] Divers recalled: the boat is making way. Fouling 25%.") {
+		t.Errorf("recall not logged: %q", logged.lines)
+	}
+
+	// 汚れた船体は行き足があると音を立てる
+	if got, want := p.foulingNoise(), 25*foulingNoisePerPercent; got != want {
+		t.Errorf("fouling noise %.1f dB, want %.1f dB", got, want)
+	}
+	p.Position.Z = -50
+	p.Velocity = 0
+	if err := h.setActive(p, true); err == nil || !strings.Contains(err.Error(), "too deep for divers") {
+		t.Errorf("cleaning at 50 m: %v", err)
+	}
+}
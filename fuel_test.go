@@ -0,0 +1,19 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 満載で出港し、タービンを回すまでは燃料を使わない
+func TestFuel(t *testing.T) {
+	g := startGame(t)
+	g.advance(2 * time.Second)
+	g.expect("Fuel: 102241 (100%)")
+	g.expect("Endurance --  Range --")
+	// 回すと燃料が減り、航続時間と航続距離が出る
+	g.key("up")
+	g.advance(30 * time.Second)
+	g.expectNot("Fuel: 102241 (")
+	g.expectNot("Endurance --")
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+	"unicode/utf8"
+)
+
+// 配られたシナリオやセーブデータが壊れていても、ゲームはパニックせずに書き間違いとして知らせなければならない。
+// 種は scenarios/*.json (FuzzSaveApply は新しい艦のセーブデータも)。go test はこの種だけを通し、
+// 壊した入力を試すときは go test -fuzz FuzzParseScenario のように 1 つずつ走らせる。
+// 任務のファイルは missions パッケージの FuzzMissionLoad が受け持つ。
+
+// scenarios/*.json を種にする
+func addScenarioSeeds(f *testing.F) {
+	paths, err := filepath.Glob(filepath.Join("scenarios", "*.json"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	if len(paths) == 0 {
+		f.Fatal("no scenarios to seed from")
+	}
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+}
+
+func FuzzParseScenario(f *testing.F) {
+	addScenarioSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		cfg, err := parseScenario(data)
+		if err != nil {
+			return
+		}
+		// 読めたシナリオは書き出して読み直しても読め、同じ内容になる
+		// (不正な UTF-8 は書き出すときに置き換わり、名前の重なりの判定が変わるので、読み直しの検査だけ)
+		out, err := json.Marshal(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, err := parseScenario(out)
+		if err != nil {
+			if utf8.Valid(data) {
+				t.Errorf("accepted scenario fails after a round trip: %v\n%s", err, out)
+			}
+			return
+		}
+		if out2, _ := json.Marshal(again); !bytes.Equal(out, out2) {
+			t.Errorf("round trip changed the scenario:\n%s\n%s", out, out2)
+		}
+	})
+}
+
+func FuzzValidateData(f *testing.F) {
+	addScenarioSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, problems, err := validateData("fuzz.json", data)
+		if err != nil && len(problems) > 0 {
+			t.Errorf("both an error (%v) and problems (%q)", err, problems)
+		}
+	})
+}
+
+func FuzzSaveApply(f *testing.F) {
+	addScenarioSeeds(f)
+	save, err := json.Marshal(newSaveData(newTestPlayer()))
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(save)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// 再開するときと同じくファイルから読む
+		path := filepath.Join(t.TempDir(), autosaveFileName)
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		s, err := readSave(path)
+		if err != nil {
+			return
+		}
+		// 再開するときと同じ順に、艦・海図・航路・魚雷・乗員に戻す
+		p := newTestPlayer()
+		s.apply(p)
+		// 範囲の外の値は範囲に収める
+		if p.Position.Z > 0 || p.HullIntegrity < 0 || p.HullIntegrity > 100 || math.Abs(p.Planes.Actual) > p.Planes.Max {
+			t.Errorf("restored out of range: z %v, hull %v, planes %v", p.Position.Z, p.HullIntegrity, p.Planes.Actual)
+		}
+		newChart(nil).restore(s.Chart)
+		newRoute(nil).restore(s.Waypoints)
+		room := newTorpedoRoom(nil)
+		room.restore(s.Tubes)
+		if s.Torpedoes != nil {
+			room.restoreMagazine(*s.Torpedoes)
+		}
+		crew := newCrewRoster(nil)
+		crew.restore(s.Crew)
+		crew.restoreCasualties(s.Casualties, s.CrewMorale)
+		crew.restoreSkills(s.CrewSkills)
+	})
+}
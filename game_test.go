@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mum4k/termdash/keyboard"
+	"github.com/mum4k/termdash/private/faketerm"
+	"github.com/mum4k/termdash/terminal/terminalapi"
+	"github.com/mum4k/termdash/widgets/text"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 画面の統合テスト
+//
+// ゲームを termdash の faketerm と偽の時計で起動し (gameOptions の term・clock・drive)、キー入力と
+// 時計の早送りを流し込んで画面の内容を確かめる。セーブデータやマクロは一時ディレクトリに置くので、ユーザーのデータには触れない。
+// ゲームはパッケージの変数 (時計、一時停止、時間の圧縮など) を使うので、テストは並べずに 1 つずつ動かす。
+
+// 乱数の種。乱数で変わる結果は確かめないが、失敗を同じ種で再現できるよう決めておく
+const testSeed = 1
+
+// faketerm に描かせ、描き終わった画面を覚えておく端末。入力はテストから送る
+type testTerminal struct {
+	*faketerm.Terminal
+
+	input chan terminalapi.Event
+
+	mu      sync.Mutex
+	flushed *sync.Cond
+	flushes int
+	// 最後に描き終わった画面
+	screen string
+}
+
+func newTestTerminal(t *testing.T) *testTerminal {
+	ft, err := faketerm.New(headlessSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tt := &testTerminal{Terminal: ft, input: make(chan terminalapi.Event)}
+	tt.flushed = sync.NewCond(&tt.mu)
+	return tt
+}
+
+// 描き終わるたびに termdash の描画のゴルーチンから呼ばれる
+func (tt *testTerminal) Flush() error {
+	if err := tt.Terminal.Flush(); err != nil {
+		return err
+	}
+	screen := tt.Terminal.String()
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	tt.screen = screen
+	tt.flushes++
+	tt.flushed.Broadcast()
+	return nil
+}
+
+func (tt *testTerminal) Event(ctx context.Context) terminalapi.Event {
+	select {
+	case ev := <-tt.input:
+		return ev
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// 次の描画が 2 回終わるまで待ち、その画面を返す (1 回目は入力の前に始まった描画かもしれない)
+func (tt *testTerminal) redrawn() string {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	target := tt.flushes + 2
+	for tt.flushes < target {
+		tt.flushed.Wait()
+	}
+	return tt.screen
+}
+
+// 動いているゲーム
+type testGame struct {
+	t     *testing.T
+	term  *testTerminal
+	clock *fakeClock
+	// ゲームの context。ゲームが終わっていれば入力を送らない
+	ctx  context.Context
+	stop chan struct{}
+}
+
+// ゲームを起動し、最初の画面が描かれるまで待つ。テストが終わるとゲームも終える
+func startGame(t *testing.T) *testGame {
+	t.Helper()
+	return startGameWith(t, gameOptions{})
+}
+
+// o に端末・時計・乱数の種を足して起動する
+func startGameWith(t *testing.T, o gameOptions) *testGame {
+	t.Helper()
+	// 前のテストが変えたものを戻す
+	simPause = &pauseSwitch{}
+	simRate = &timeCompression{}
+	render = renderMode{}
+	terrain = sim.GentleSeabed{}
+
+	g := &testGame{t: t, term: newTestTerminal(t), clock: newFakeClock(time.Now()), stop: make(chan struct{})}
+	started := make(chan context.Context)
+	done := make(chan int)
+	go func() {
+		o.seed = testSeed
+		o.backend = backendTermbox
+		o.term = g.term
+		o.clock = g.clock
+		o.drive = func(ctx context.Context) {
+			started <- ctx
+			select {
+			case <-g.stop:
+			case <-ctx.Done():
+			}
+		}
+		done <- run(o)
+	}()
+	select {
+	case g.ctx = <-started:
+	case code := <-done:
+		t.Fatalf("game exited with code %d before drawing the screen", code)
+	}
+	t.Cleanup(func() {
+		close(g.stop)
+		if code := <-done; code != 0 {
+			t.Errorf("game exited with code %d", code)
+		}
+	})
+	g.term.redrawn()
+	return g
+}
+
+func (g *testGame) send(ev terminalapi.Event) {
+	select {
+	case g.term.input <- ev:
+	case <-g.ctx.Done():
+		g.t.Fatal("game is not running")
+	}
+}
+
+// キーを 1 回押す。name は 1 文字か enter・esc・tab・space・backspace・up・down・left・right
+func (g *testGame) key(name string) {
+	g.t.Helper()
+	k, err := parseKey(name)
+	if err != nil {
+		g.t.Fatal(err)
+	}
+	g.send(&terminalapi.Keyboard{Key: k})
+}
+
+// 文字列を 1 文字ずつ入力する
+func (g *testGame) typeText(s string) {
+	for _, r := range s {
+		g.send(&terminalapi.Keyboard{Key: keyboard.Key(r)})
+	}
+}
+
+// ゲーム内の時計を進める
+func (g *testGame) advance(d time.Duration) {
+	g.clock.Advance(d)
+}
+
+// 画面のどこかに text が出ていること
+func (g *testGame) expect(text string) {
+	g.t.Helper()
+	if screen := g.term.redrawn(); !strings.Contains(screen, text) {
+		g.t.Errorf("%q not found on screen:\n%s", text, screen)
+	}
+}
+
+// 画面のどこにも text が出ていないこと
+func (g *testGame) expectNot(text string) {
+	g.t.Helper()
+	if screen := g.term.redrawn(); strings.Contains(screen, text) {
+		g.t.Errorf("%q unexpectedly found on screen:\n%s", text, screen)
+	}
+}
+
+// 単体テストのイベントログ。書かれた行を覚えておく
+type testEvents struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func newTestEvents(t *testing.T) (*eventLog, *testEvents) {
+	t.Helper()
+	w, err := text.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	te := &testEvents{}
+	return &eventLog{t: w, listeners: []func(string){te.add}}, te
+}
+
+func (te *testEvents) add(msg string) {
+	te.mu.Lock()
+	defer te.mu.Unlock()
+	te.lines = append(te.lines, msg)
+}
+
+// text を含む行が書かれたか
+func (te *testEvents) contains(text string) bool {
+	te.mu.Lock()
+	defer te.mu.Unlock()
+	for _, l := range te.lines {
+		if strings.Contains(l, text) {
+			return true
+		}
+	}
+	return false
+}
+
+// 単体テストの艦 (main と同じく作る)
+func newTestPlayer() *Player {
+	return &Player{Player: sim.NewPlayer(), oxygen: atmosphereO2, co2: atmosphereCO2}
+}
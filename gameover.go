@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 哨戒の終わりとスコア
+//
+// 次のどれかで哨戒は終わり、画面をまとめの画面に切り替える。
+//   - 圧壊: 圧壊深度より深く潜った
+//   - 撃沈: 船体の健全度が 0 になった
+//   - 燃料切れ: 燃料が尽きて行き足を失った
+//   - 総員退艦: 退艦した (救助されたかどうかで結末が分かれる)
+//   - 任務完了: 任務とシナリオの目標をすべて達成した
+//
+// Q を押したときも、すぐには終わらずにまとめの画面を出す (もう一度 Q で終了)。
+// 圧壊・撃沈・燃料切れなら、まとめの画面から最後のチェックポイント (checkpoint.go) に戻れる。
+// スコアは進んだ距離、哨戒の時間、達成した目標から求め、結末に応じた倍率を掛ける。
+
+// 哨戒の結末
+type endReason string
+
+const (
+	endQuit            endReason = "quit"
+	endMissionComplete endReason = "mission-complete"
+	endCrushed         endReason = "crushed"
+	endDestroyed       endReason = "destroyed"
+	endOutOfFuel       endReason = "out-of-fuel"
+	endRescued         endReason = outcomeRescued
+	endLost            endReason = outcomeLost
+)
+
+const (
+	// 燃料切れで行き足を失ったとみなす速力 (ノット)
+	deadInTheWaterVelocity = 0.1
+	// スコアの配点
+	scorePerNauticalMile = 10.0
+	scorePerMinute       = 2.0
+	scorePerObjective    = 500.0
+	missionCompleteBonus = 1000.0
+	// 1 海里 (m)
+	nauticalMile = 1852.0
+)
+
+// まとめの画面の見出し
+func (r endReason) String() string {
+	switch r {
+	case endMissionComplete:
+		return "MISSION COMPLETE"
+	case endCrushed:
+		return "LOST - the hull collapsed below crush depth"
+	case endDestroyed:
+		return "LOST - the boat was destroyed"
+	case endOutOfFuel:
+		return "STRANDED - out of fuel and dead in the water"
+	case endRescued:
+		return "SHIP ABANDONED - the crew was rescued"
+	case endLost:
+		return "SHIP ABANDONED - lost with all hands"
+	}
+	return "PATROL ENDED by the captain"
+}
+
+// 結末によるスコアの倍率
+func (r endReason) factor() float64 {
+	switch r {
+	case endOutOfFuel, endRescued:
+		return 0.75
+	case endCrushed, endDestroyed, endLost:
+		return 0.5
+	}
+	return 1
+}
+
+// チェックポイントからやり直せる結末か
+func (r endReason) retryable() bool {
+	switch r {
+	case endCrushed, endDestroyed, endOutOfFuel:
+		return true
+	}
+	return false
+}
+
+// 哨戒のまとめ
+type patrolSummary struct {
+	Reason endReason
+	// 哨戒の時間 (シミュレーション時間) と進んだ距離 (m)
+	Elapsed  time.Duration
+	Distance float64
+	// 達成した目標の数と目標の数 (任務とシナリオの合計)
+	ObjectivesDone  int
+	ObjectivesTotal int
+	// 戻れるチェックポイント。なければ空
+	Checkpoint string
+}
+
+// 配点ごとの点数と合計
+func (s patrolSummary) score() (distance, minutes, objectives, bonus float64, total int) {
+	distance = s.Distance / nauticalMile * scorePerNauticalMile
+	minutes = math.Floor(s.Elapsed.Minutes()) * scorePerMinute
+	objectives = float64(s.ObjectivesDone) * scorePerObjective
+	if s.Reason == endMissionComplete {
+		bonus = missionCompleteBonus
+	}
+	total = int(math.Round((distance + minutes + objectives + bonus) * s.Reason.factor()))
+	return
+}
+
+// まとめの画面の行
+func (s patrolSummary) lines() []string {
+	distance, minutes, objectives, bonus, total := s.score()
+	lines := []string{
+		s.Reason.String(),
+		"",
+		fmt.Sprintf("Patrol time        %s", formatMissionTime(s.Elapsed.Seconds())),
+		fmt.Sprintf("Distance traveled  %.1f nm", s.Distance/nauticalMile),
+		fmt.Sprintf("Objectives         %d / %d", s.ObjectivesDone, s.ObjectivesTotal),
+		"",
+		"Score",
+		fmt.Sprintf("  Distance    %6.1f nm x %.0f   %6.0f", s.Distance/nauticalMile, scorePerNauticalMile, distance),
+		fmt.Sprintf("  Time        %6.0f min x %.0f  %6.0f", math.Floor(s.Elapsed.Minutes()), scorePerMinute, minutes),
+		fmt.Sprintf("  Objectives  %6d x %.0f     %6.0f", s.ObjectivesDone, scorePerObjective, objectives),
+	}
+	if bonus > 0 {
+		lines = append(lines, fmt.Sprintf("  Mission complete bonus  %6.0f", bonus))
+	}
+	if f := s.Reason.factor(); f != 1 {
+		lines = append(lines, fmt.Sprintf("  Outcome                 x %.2f", f))
+	}
+	lines = append(lines, fmt.Sprintf("  Total                   %6d", total), "")
+	if s.Checkpoint != "" && s.Reason.retryable() {
+		lines = append(lines, fmt.Sprintf("PRESS R TO RESTART FROM CHECKPOINT (%s)", s.Checkpoint))
+	}
+	return append(lines, "PRESS Q TO EXIT")
+}
+
+type patrolEnd struct {
+	events *eventLog
+	// 達成した目標の数と目標の数
+	objectives func() (done, total int)
+	// 退艦の結果 (outcomeRescued か outcomeLost)。退艦していなければ空
+	abandoned func() string
+	// まとめの画面を出す
+	show func(lines []string)
+	// 戻れるチェックポイント (main で決める)
+	checkpoint func() string
+
+	mu       sync.Mutex
+	last     sim.Point3D
+	placed   bool
+	distance float64
+	elapsed  time.Duration
+	// 終わっていなければ空
+	reason endReason
+}
+
+func newPatrolEnd(events *eventLog, objectives func() (int, int), abandoned func() string, show func([]string)) *patrolEnd {
+	return &patrolEnd{events: events, objectives: objectives, abandoned: abandoned, show: show, checkpoint: func() string { return "" }}
+}
+
+// 進んだ距離を足し、哨戒が終わったかを調べる (ティックごとに呼ぶ)
+func (e *patrolEnd) step(p *Player, now time.Duration) {
+	e.mu.Lock()
+	if e.reason != "" {
+		e.mu.Unlock()
+		return
+	}
+	e.elapsed = now
+	if e.placed {
+		e.distance += sim.HorizontalDistance(e.last, p.Position)
+	}
+	e.last, e.placed = p.Position, true
+	done, total := e.objectives()
+	var reason endReason
+	switch {
+	case p.HullIntegrity <= 0:
+		reason = endDestroyed
+	case p.Depth() >= crushDepth:
+		reason = endCrushed
+	case p.abandoned:
+		reason = endReason(e.abandoned())
+	case p.OutOfFuel() && p.Velocity < deadInTheWaterVelocity:
+		reason = endOutOfFuel
+	case total > 0 && done == total:
+		reason = endMissionComplete
+	}
+	e.mu.Unlock()
+	if reason != "" {
+		p.gameOver = true
+		e.finish(reason)
+	}
+}
+
+// 艦長が哨戒を終える (Q の処理)
+func (e *patrolEnd) quit(p *Player) {
+	p.gameOver = true
+	e.finish(endQuit)
+}
+
+// まとめの画面を出しているか
+func (e *patrolEnd) over() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.reason != ""
+}
+
+// 哨戒の結末。終わっていなければ空
+func (e *patrolEnd) outcome() endReason {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.reason
+}
+
+// 哨戒で進んだ距離 (m)
+func (e *patrolEnd) traveled() float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.distance
+}
+
+// やり直せる結末なら、まとめの画面を閉じて哨戒を続ける。進んだ距離は distance に戻す
+func (e *patrolEnd) retry(p *Player, distance float64) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.reason.retryable() {
+		return false
+	}
+	e.reason = ""
+	e.distance, e.placed = distance, false
+	p.gameOver = false
+	return true
+}
+
+func (e *patrolEnd) finish(reason endReason) {
+	e.mu.Lock()
+	if e.reason != "" {
+		e.mu.Unlock()
+		return
+	}
+	e.reason = reason
+	done, total := e.objectives()
+	s := patrolSummary{Reason: reason, Elapsed: e.elapsed, Distance: e.distance, ObjectivesDone: done, ObjectivesTotal: total, Checkpoint: e.checkpoint()}
+	e.mu.Unlock()
+
+	_, _, _, _, score := s.score()
+	color := cell.ColorRed
+	if reason == endMissionComplete || reason == endQuit {
+		color = cell.ColorGreen
+	}
+	e.events.add(color, "[GAME OVER] %s. Score %d.", reason, score)
+	e.show(s.lines())
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Q を押してもすぐには終わらず、まとめの画面とスコアが出る
+func TestGameOver(t *testing.T) {
+	g := startGame(t)
+	g.advance(2 * time.Second)
+	g.key("q")
+	g.expect("PATROL ENDED by the captain")
+	g.expect("Distance traveled")
+	g.expect("Total")
+	g.expect("PRESS Q TO EXIT")
+	g.expectNot("Current Speed: (kt)")
+}
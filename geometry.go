@@ -0,0 +1,13 @@
+package main
+
+import (
+	"math"
+
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 方位を8方位の略号にする
+func compassPoint(deg float64) string {
+	points := []string{"N", "NE", "E", "SE", "S", "SW", "W", "NW"}
+	return points[int(math.Floor(sim.NormalizeBearing(deg)/45+0.5))%8]
+}
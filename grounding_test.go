@@ -0,0 +1,18 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 行き足を付けたまま潜航すると、海底が迫ったところで警報が出る
+func TestGrounding(t *testing.T) {
+	g := startGame(t)
+	g.key("d")
+	g.key("up")
+	g.advance(505 * time.Second)
+	g.expect("[ALARM] Shoaling ahead!")
+	// そのまま海底に突っ込むと座礁して船体が傷む
+	g.advance(time.Minute)
+	g.expect("[ALARM] Hard grounding on")
+}
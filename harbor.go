@@ -0,0 +1,407 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 港の防備
+//
+// シナリオの harbors に書いた港には、対潜網と防材 (ブーム)、哨戒艇、聴音所を置く。
+//   - 対潜網: 海面から depth (m) まで垂れた網。それより浅いまま横切ろうとすると網に掛かって止まり、
+//     網の検知線で港に警報が出る。網の下をくぐるか、網切りで隙間を開けて通る
+//   - 防材: 海面に浮かべた丸太や浮き。浅い深度でしか引っかからないが、切ることはできない
+//   - 哨戒艇: 港の航路を回る軍艦 (enemy.go)。港に警報が出ると自艦のいたところへ捜索に向かう
+//   - 聴音所: 海底に据えた聴音器。自艦の音を聞くと港に警報を出し、データムを作る
+//
+// 網切りは行き足を止めて網のすぐそばで行い、時間がかかるうえに大きな音を立てる (noise.go)。
+// 切り終えると、網のいちばん近いところに netGapWidth の隙間が開く。
+
+const (
+	// 深度を書かなかったときの網と防材の下端 (m)
+	netDefaultDepth  = 40.0
+	boomDefaultDepth = 8.0
+	// 網切りができる網からの距離 (m) と行き足 (ノット)
+	netCutRange       = 30.0
+	netCutMaxVelocity = 1.0
+	// 網切りにかかる時間 (シミュレーション時間)
+	netCutTime = 4 * time.Minute
+	// 網切りで開く隙間の幅 (m)
+	netGapWidth = 60.0
+	// 聴音所の聴音器は大きな固定アレイなので、艦のソーナーよりこれだけよく聞こえる (dB)
+	listeningPostGain = 10.0
+	// 聴音所が聴音する間隔 (シミュレーション時間)
+	listeningPostInterval = time.Second
+	// 警報を出してから次の警報をイベントログに出すまで
+	harborAlertTime = 10 * time.Minute
+	// 哨戒艇の艦種
+	patrolBoatClass = "Patrol Boat"
+)
+
+type harborPoint struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+func (p harborPoint) point() sim.Point3D {
+	return sim.Point3D{X: p.X, Y: p.Y}
+}
+
+// 対潜網と防材
+type barrierConfig struct {
+	Name  string      `json:"name"`
+	From  harborPoint `json:"from"`
+	To    harborPoint `json:"to"`
+	Depth float64     `json:"depth,omitempty"`
+}
+
+type patrolBoatConfig struct {
+	Name  string        `json:"name"`
+	Route []harborPoint `json:"route"`
+	// 艇長の性格 (personality.go)。省くと標準
+	Personality string `json:"personality,omitempty"`
+}
+
+type listeningPostConfig struct {
+	Name  string  `json:"name"`
+	X     float64 `json:"x"`
+	Y     float64 `json:"y"`
+	Depth float64 `json:"depth"`
+}
+
+type harborConfig struct {
+	Name           string                `json:"name"`
+	Nets           []barrierConfig       `json:"nets"`
+	Booms          []barrierConfig       `json:"booms"`
+	PatrolBoats    []patrolBoatConfig    `json:"patrolBoats"`
+	ListeningPosts []listeningPostConfig `json:"listeningPosts"`
+}
+
+// 書き間違いを report で挙げる (scenarioConfig.problems から呼ぶ)
+func (h harborConfig) problems(report func(format string, args ...interface{}), onMap func(x, y float64) bool) {
+	if h.Name == "" {
+		report("harbor: missing name")
+	}
+	barriers := func(kind string, list []barrierConfig) {
+		for _, b := range list {
+			if b.Name == "" {
+				report("harbor %q: %s needs a name", h.Name, kind)
+			}
+			if !onMap(b.From.X, b.From.Y) || !onMap(b.To.X, b.To.Y) {
+				report("harbor %q: %s %q runs off the map", h.Name, kind, b.Name)
+			}
+			if b.From == b.To {
+				report("harbor %q: %s %q has no length", h.Name, kind, b.Name)
+			}
+			if b.Depth < 0 {
+				report("harbor %q: %s %q has a negative depth", h.Name, kind, b.Name)
+			}
+		}
+	}
+	barriers("net", h.Nets)
+	barriers("boom", h.Booms)
+	for _, b := range h.PatrolBoats {
+		if b.Name == "" {
+			report("harbor %q: patrol boat needs a name", h.Name)
+		}
+		if len(b.Route) == 0 {
+			report("harbor %q: patrol boat %q has no route", h.Name, b.Name)
+		}
+		if _, ok := findPersonality(b.Personality); !ok {
+			report("harbor %q: patrol boat %q has unknown personality %q (%s)", h.Name, b.Name, b.Personality, personalityNames())
+		}
+		for _, p := range b.Route {
+			if !onMap(p.X, p.Y) {
+				report("harbor %q: patrol boat %q route point (%.0f, %.0f) is off the map", h.Name, b.Name, p.X, p.Y)
+			}
+		}
+	}
+	for _, l := range h.ListeningPosts {
+		if l.Name == "" {
+			report("harbor %q: listening post needs a name", h.Name)
+		}
+		if !onMap(l.X, l.Y) {
+			report("harbor %q: listening post %q (%.0f, %.0f) is off the map", h.Name, l.Name, l.X, l.Y)
+		}
+		if l.Depth < 0 {
+			report("harbor %q: listening post %q has a negative depth", h.Name, l.Name)
+		}
+	}
+}
+
+type harbor struct {
+	name string
+	// 哨戒艇の traffic の船の id
+	patrols []int
+	// 最後に警報を出した時刻
+	alerted   bool
+	alertedAt time.Duration
+}
+
+type barrier struct {
+	harbor *harbor
+	name   string
+	boom   bool
+	from   sim.Point3D
+	to     sim.Point3D
+	depth  float64
+	// 切り開いた隙間の中心 (from からの距離 m)
+	gaps []float64
+}
+
+func (b *barrier) length() float64 {
+	return sim.HorizontalDistance(b.from, b.to)
+}
+
+func (b *barrier) kind() string {
+	if b.boom {
+		return "boom"
+	}
+	return "net"
+}
+
+// pos に最も近い網の上の点の from からの距離 (m) と、pos からの距離 (m)
+func (b *barrier) closest(pos sim.Point3D) (along, dist float64) {
+	dx, dy := b.to.X-b.from.X, b.to.Y-b.from.Y
+	l := b.length()
+	u := ((pos.X-b.from.X)*dx + (pos.Y-b.from.Y)*dy) / (l * l)
+	u = math.Max(math.Min(u, 1), 0)
+	point := sim.Point3D{X: b.from.X + dx*u, Y: b.from.Y + dy*u}
+	return u * l, sim.HorizontalDistance(pos, point)
+}
+
+// from から to へ動いたときに網を横切る点の from からの距離 (m)。横切らなければ false
+func (b *barrier) crossing(from, to sim.Point3D) (float64, bool) {
+	rx, ry := to.X-from.X, to.Y-from.Y
+	sx, sy := b.to.X-b.from.X, b.to.Y-b.from.Y
+	denom := rx*sy - ry*sx
+	if denom == 0 {
+		return 0, false
+	}
+	qx, qy := b.from.X-from.X, b.from.Y-from.Y
+	t := (qx*sy - qy*sx) / denom
+	u := (qx*ry - qy*rx) / denom
+	if t < 0 || t > 1 || u < 0 || u > 1 {
+		return 0, false
+	}
+	return u * b.length(), true
+}
+
+// from からの距離 along が切り開いた隙間の中か
+func (b *barrier) open(along float64) bool {
+	for _, g := range b.gaps {
+		if math.Abs(along-g) <= netGapWidth/2 {
+			return true
+		}
+	}
+	return false
+}
+
+type listeningPost struct {
+	harbor   *harbor
+	name     string
+	position sim.Point3D
+}
+
+type harborDefenses struct {
+	events  *eventLog
+	env     *environment
+	traffic *traffic
+	fleet   *enemyFleet
+	datums  *datumPlot
+
+	mu       sync.Mutex
+	barriers []*barrier
+	posts    []*listeningPost
+	// シミュレーション上の時刻
+	now        time.Duration
+	lastListen time.Duration
+	// 前のティックの自艦の位置
+	last   sim.Point3D
+	placed bool
+	// 掛かっている網
+	fouled *barrier
+	// 切っている網と、網の上の位置、残りの秒数
+	cutting *barrier
+	cutAt   float64
+	cutLeft float64
+}
+
+// 港の防備を置く。網と防材は海図に書き込み、哨戒艇は敵の艦艇に加える
+func newHarborDefenses(harbors []harborConfig, events *eventLog, env *environment, tr *traffic, fleet *enemyFleet, dp *datumPlot, ch *chart) *harborDefenses {
+	d := &harborDefenses{events: events, env: env, traffic: tr, fleet: fleet, datums: dp}
+	for _, cfg := range harbors {
+		h := &harbor{name: cfg.Name}
+		add := func(b barrierConfig, boom bool, depth float64) {
+			if b.Depth > 0 {
+				depth = b.Depth
+			}
+			br := &barrier{harbor: h, name: b.Name, boom: boom, from: b.From.point(), to: b.To.point(), depth: depth}
+			d.barriers = append(d.barriers, br)
+			center := sim.Point3D{X: (br.from.X + br.to.X) / 2, Y: (br.from.Y + br.to.Y) / 2}
+			ch.add(chartMark{Kind: markBarrier, Name: b.Name, X: center.X, Y: center.Y, Radius: br.length() / 2, Scenario: true})
+		}
+		for _, b := range cfg.Nets {
+			add(b, false, netDefaultDepth)
+		}
+		for _, b := range cfg.Booms {
+			add(b, true, boomDefaultDepth)
+		}
+		for _, b := range cfg.PatrolBoats {
+			route := make([]sim.Point3D, len(b.Route))
+			for i, p := range b.Route {
+				route[i] = p.point()
+			}
+			personality, _ := findPersonality(b.Personality)
+			h.patrols = append(h.patrols, fleet.addPatrolBoat(b.Name, route, personality))
+		}
+		for _, l := range cfg.ListeningPosts {
+			d.posts = append(d.posts, &listeningPost{harbor: h, name: l.Name, position: sim.Point3D{X: l.X, Y: l.Y, Z: -l.Depth}})
+		}
+	}
+	return d
+}
+
+// 港に警報を出す。哨戒艇を pos へ捜索に向かわせ、データムを作る (d.mu を保持した状態で呼ぶ)
+func (d *harborDefenses) alert(h *harbor, pos sim.Point3D, reason string) {
+	if !h.alerted || d.now-h.alertedAt >= harborAlertTime {
+		d.events.add(cell.ColorRed, "[ALARM] %s: %s. The harbor defenses are alerted.", h.name, reason)
+	}
+	h.alerted, h.alertedAt = true, d.now
+	d.fleet.alert(h.patrols, pos)
+	d.datums.raise(pos, reason)
+}
+
+// 網切りの開始・中止 (orderCutNet の処理)
+func (d *harborDefenses) setCutting(p *Player, active bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !active {
+		if d.cutting != nil {
+			d.events.add(cell.ColorYellow, "[HARBOR] Net cutting stopped. The net %s is still intact.", d.cutting.name)
+		}
+		d.cutting = nil
+		return nil
+	}
+	var nearest *barrier
+	along, dist := 0.0, math.Inf(1)
+	for _, b := range d.barriers {
+		if a, r := b.closest(p.Position); r < dist {
+			nearest, along, dist = b, a, r
+		}
+	}
+	switch {
+	case nearest == nil || dist > netCutRange:
+		return orderRefusedError{"no net within reach"}
+	case nearest.boom:
+		return orderRefusedError{"the boom " + nearest.name + " cannot be cut, dive under it"}
+	case p.Depth() >= nearest.depth:
+		return orderRefusedError{"the boat is below the net, pass under it"}
+	case p.Velocity > netCutMaxVelocity:
+		return orderRefusedError{"the boat is making way"}
+	case p.MachinerySecured:
+		return orderRefusedError{"machinery is secured, no hydraulic power for the cutter"}
+	case nearest.open(along):
+		return orderRefusedError{"the net is already cut here"}
+	}
+	d.cutting, d.cutAt, d.cutLeft = nearest, along, netCutTime.Seconds()
+	d.events.add(cell.ColorYellow, "[HARBOR] Cutting the net %s. About %.0f minutes, and it will be heard.", nearest.name, netCutTime.Minutes())
+	return nil
+}
+
+func (d *harborDefenses) cuttingNet() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cutting != nil
+}
+
+// シミュレーション上の時刻 now まで dt 秒分だけ進める
+// 網を横切ろうとしていれば止め、網切りを進め、聴音所で自艦を聞く
+func (d *harborDefenses) step(p *Player, now time.Duration, dt float64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.now = now
+	if d.placed {
+		d.checkBarriers(p)
+	}
+	d.last, d.placed = p.Position, true
+	d.stepCutting(p, dt)
+	p.cuttingNet = d.cutting != nil
+	if now-d.lastListen >= listeningPostInterval {
+		d.lastListen = now
+		d.listen(p)
+	}
+}
+
+// 前のティックから網を横切っていれば、網の手前に戻して行き足を止める (d.mu を保持した状態で呼ぶ)
+func (d *harborDefenses) checkBarriers(p *Player) {
+	var fouled *barrier
+	for _, b := range d.barriers {
+		along, ok := b.crossing(d.last, p.Position)
+		if !ok || p.Depth() >= b.depth || b.open(along) {
+			continue
+		}
+		fouled = b
+		p.Position.X, p.Position.Y = d.last.X, d.last.Y
+		p.Velocity = 0
+		break
+	}
+	// 網から離れるまでは、何度ぶつかっても 1 度の接触とみなす
+	if d.fouled != nil {
+		if _, dist := d.fouled.closest(p.Position); dist > netCutRange {
+			d.fouled = nil
+		}
+	}
+	if fouled != nil && fouled != d.fouled {
+		d.fouled = fouled
+		d.events.add(cell.ColorRed, "[HARBOR] Fouled in the %s %s! All stop.", fouled.kind(), fouled.name)
+		d.alert(fouled.harbor, p.Position, "contact on the "+fouled.kind()+" "+fouled.name)
+	}
+}
+
+// 網切りを進める。行き足がつくか網から離れると中止する (d.mu を保持した状態で呼ぶ)
+func (d *harborDefenses) stepCutting(p *Player, dt float64) {
+	b := d.cutting
+	if b == nil {
+		return
+	}
+	_, dist := b.closest(p.Position)
+	reason := ""
+	switch {
+	case p.Velocity > netCutMaxVelocity:
+		reason = "the boat is making way"
+	case dist > netCutRange:
+		reason = "drifted off the net"
+	case p.Depth() >= b.depth:
+		reason = "the boat is below the net"
+	case p.MachinerySecured:
+		reason = "machinery is secured"
+	}
+	if reason != "" {
+		d.cutting = nil
+		d.events.add(cell.ColorYellow, "[HARBOR] Net cutting abandoned: %s.", reason)
+		return
+	}
+	d.cutLeft -= dt
+	if d.cutLeft > 0 {
+		return
+	}
+	b.gaps = append(b.gaps, d.cutAt)
+	d.cutting = nil
+	d.events.add(cell.ColorGreen, "[HARBOR] The net %s is cut. A %.0f m gap is open.", b.name, netGapWidth)
+}
+
+// 聴音所で自艦を聞く。聞こえれば港に警報を出す (d.mu を保持した状態で呼ぶ)
+func (d *harborDefenses) listen(p *Player) {
+	source := sonarTarget{sourceLevel: p.noiseLevel() + ownShipSourceOffset}
+	for _, l := range d.posts {
+		noise := d.traffic.noiseHeardBy(l.position, 0)
+		if passiveSonar.signalExcess(d.env, l.position, p.Position, source, noise)+listeningPostGain >= 0 {
+			d.alert(l.harbor, p.Position, "heard by the listening post "+l.name)
+		}
+	}
+}
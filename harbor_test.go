@@ -0,0 +1,140 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 港の防備のない海では、網切りを命じても切る網がない
+func TestHarbor(t *testing.T) {
+	g := startGame(t)
+	g.advance(time.Second)
+	g.key("\\")
+	g.expect("[ORDER] Cut the net refused: no net within reach")
+	g.expectNot("[HARBOR]")
+}
+
+// 東西に張った 1 km の網 (y = 100, 深さ 40 m) と、網の内側の浅い防材だけの港
+func newTestHarbor(t *testing.T) (*harborDefenses, *testEvents) {
+	t.Helper()
+	events, logged := newTestEvents(t)
+	env := defaultEnvironment()
+	rng := rand.New(rand.NewSource(testSeed))
+	tr := newTraffic(trafficConfig{}, nil, events, env, rng)
+	personality, _ := findPersonality("")
+	fleet := newEnemyFleet(events, env, tr, nil, nil, nil, personality, rng)
+	ch := newChart(events)
+	cfg := harborConfig{
+		Name:  "Test Harbor",
+		Nets:  []barrierConfig{{Name: "Outer", From: harborPoint{X: -500, Y: 100}, To: harborPoint{X: 500, Y: 100}}},
+		Booms: []barrierConfig{{Name: "Inner", From: harborPoint{X: -500, Y: 1000}, To: harborPoint{X: 500, Y: 1000}}},
+	}
+	return newHarborDefenses([]harborConfig{cfg}, events, env, tr, fleet, newDatumPlot(events, ch), ch), logged
+}
+
+// 網の手前 20 m、深度 10 m で止まっている艦
+func newNetCutter() *Player {
+	p := newTestPlayer()
+	p.Position = sim.Point3D{X: 0, Y: 80, Z: -10}
+	return p
+}
+
+// now から dt 秒ずつ seconds 秒だけ進める
+func runHarbor(d *harborDefenses, p *Player, now time.Duration, seconds float64) time.Duration {
+	const dt = 1.0
+	for s := 0.0; s < seconds; s += dt {
+		now += time.Second
+		d.step(p, now, dt)
+	}
+	return now
+}
+
+func TestNetCuttingOpensAGap(t *testing.T) {
+	d, logged := newTestHarbor(t)
+	p := newNetCutter()
+	if err := d.setCutting(p, true); err != nil {
+		t.Fatal(err)
+	}
+	now := runHarbor(d, p, 0, netCutTime.Seconds()-2)
+	if !d.cuttingNet() || !p.cuttingNet {
+		t.Fatal("net cutting stopped before netCutTime")
+	}
+	runHarbor(d, p, now, 2)
+	if d.cuttingNet() {
+		t.Fatal("net still being cut after netCutTime")
+	}
+	if !logged.contains("[HARBOR] The net Outer is cut. A 60 m gap is open.") {
+		t.Errorf("cut not logged: %q", logged.lines)
+	}
+
+	// 隙間からは網の深さより浅くても通れる
+	net := d.barriers[0]
+	if !net.open(500) || net.open(500+netGapWidth) {
+		t.Errorf("gaps %v, want one %v m gap at 500 m", net.gaps, netGapWidth)
+	}
+	p.Position.Y = 130
+	d.step(p, time.Hour, 1)
+	if p.Position.Y != 130 || logged.contains("Fouled") {
+		t.Errorf("boat stopped in the gap at %+v", p.Position)
+	}
+}
+
+func TestNetFoulsABoatOutsideTheGap(t *testing.T) {
+	d, logged := newTestHarbor(t)
+	p := newNetCutter()
+	p.Position.X = -300
+	p.Velocity = 4
+	d.step(p, time.Second, 1)
+	p.Position.Y = 130
+	d.step(p, 2*time.Second, 1)
+	if p.Position.Y != 80 || p.Velocity != 0 {
+		t.Errorf("boat crossed the net: position %+v velocity %v", p.Position, p.Velocity)
+	}
+	if !logged.contains("[HARBOR] Fouled in the net Outer! All stop.") || !logged.contains("[ALARM] Test Harbor: contact on the net Outer.") {
+		t.Errorf("fouling not logged: %q", logged.lines)
+	}
+}
+
+func TestNetCuttingRefused(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(p *Player)
+		want  string
+	}{
+		{"far from the net", func(p *Player) { p.Position.Y = -200 }, "no net within reach"},
+		{"at the boom", func(p *Player) { p.Position.Y = 990 }, "the boom Inner cannot be cut"},
+		{"below the net", func(p *Player) { p.Position.Z = -60 }, "the boat is below the net"},
+		{"making way", func(p *Player) { p.Velocity = 3 }, "the boat is making way"},
+		{"machinery secured", func(p *Player) { p.MachinerySecured = true }, "no hydraulic power"},
+	}
+	for _, tt := range tests {
+		d, _ := newTestHarbor(t)
+		p := newNetCutter()
+		tt.setup(p)
+		if err := d.setCutting(p, true); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+// 網切りの途中で行き足がつくと中止し、網は切れない
+func TestNetCuttingAbandoned(t *testing.T) {
+	d, logged := newTestHarbor(t)
+	p := newNetCutter()
+	if err := d.setCutting(p, true); err != nil {
+		t.Fatal(err)
+	}
+	now := runHarbor(d, p, 0, 60)
+	p.Velocity = 3
+	runHarbor(d, p, now, 1)
+	if d.cuttingNet() || len(d.barriers[0].gaps) != 0 {
+		t.Errorf("cutting %v gaps %v after making way", d.cuttingNet(), d.barriers[0].gaps)
+	}
+	if !logged.contains("[HARBOR] Net cutting abandoned: the boat is making way.") {
+		t.Errorf("abandon not logged: %q", logged.lines)
+	}
+}
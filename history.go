@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/mum4k/termdash/container"
+	"github.com/mum4k/termdash/linestyle"
+	"github.com/mum4k/termdash/widgets/linechart"
+	"github.com/mum4k/termdash/widgets/text"
+)
+
+// 速力と深度の推移
+//
+// 今の値だけでなく流れがわかるよう、直近 historyWindow の速力と深度を折れ線グラフで出す。
+// ゲームループのタイマーから historyInterval ごとに (シミュレーション時間で) リングバッファに書き、描画のパスで読む。
+// 深度のグラフは深いほど下に描き、軸の目盛りは深度計と同じ単位で正の値にする。
+// 低帯域モードでは点字のグラフの代わりに、今の値・上がったか下がったか・期間中の幅を文字で出す。
+
+const (
+	// グラフに出す期間
+	historyWindow = 10 * time.Minute
+	// 記録する間隔
+	historyInterval = 5 * time.Second
+	// 記録する数
+	historySamples = int(historyWindow / historyInterval)
+	// 横軸に目盛りを振る間隔
+	historyLabelInterval = time.Minute
+)
+
+type historySample struct {
+	// 速力 (ノット) と深度 (m)
+	speed, depth float64
+}
+
+// 速力と深度のリングバッファ
+type trackHistory struct {
+	mu      sync.Mutex
+	samples [historySamples]historySample
+	// 次に書く位置と、書いた数
+	next, count int
+	// 次に記録する時刻 (シミュレーション時間)
+	due time.Duration
+}
+
+func newTrackHistory() *trackHistory {
+	return &trackHistory{}
+}
+
+// 記録する番なら今の速力と深度を書く (ゲームループのタイマーから呼ぶ)
+func (h *trackHistory) record(p *Player, now time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if now < h.due {
+		return
+	}
+	h.due = now + historyInterval
+	h.samples[h.next] = historySample{speed: p.Velocity, depth: p.Depth()}
+	h.next = (h.next + 1) % historySamples
+	if h.count < historySamples {
+		h.count++
+	}
+}
+
+// 記録した速力と深度 (古い順)
+func (h *trackHistory) series() (speed, depth []float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	start := (h.next - h.count + historySamples) % historySamples
+	for i := 0; i < h.count; i++ {
+		s := h.samples[(start+i)%historySamples]
+		speed = append(speed, s.speed)
+		depth = append(depth, s.depth)
+	}
+	return speed, depth
+}
+
+// 横軸の目盛り。n 個の記録のうち、最新から historyLabelInterval ごとに "-3m" のように振る
+func historyLabels(n int) map[int]string {
+	labels := map[int]string{}
+	every := int(historyLabelInterval / historyInterval)
+	for ago := 0; ago < n; ago += every {
+		label := "now"
+		if ago > 0 {
+			label = fmt.Sprintf("-%dm", ago/every)
+		}
+		labels[n-1-ago] = label
+	}
+	return labels
+}
+
+// 速力と深度のグラフ。低帯域モードでは文字
+type historyPanel struct {
+	speed, depth *linechart.LineChart
+	text         *text.Text
+}
+
+func newHistoryPanel() (*historyPanel, error) {
+	hp := &historyPanel{}
+	var err error
+	if render.lowBandwidth {
+		hp.text, err = text.New()
+		if err != nil {
+			return nil, err
+		}
+		return hp, nil
+	}
+	if hp.speed, err = newHistoryChart(func(v float64) string { return fmt.Sprintf("%.0f", v) }); err != nil {
+		return nil, err
+	}
+	// 深度は負にして描くので、目盛りは符号を戻す
+	if hp.depth, err = newHistoryChart(func(v float64) string { return fmt.Sprintf("%.0f", math.Abs(v)) }); err != nil {
+		return nil, err
+	}
+	return hp, nil
+}
+
+func newHistoryChart(format func(float64) string) (*linechart.LineChart, error) {
+	return linechart.New(
+		linechart.AxesCellOpts(cell.FgColor(cell.ColorWhite)),
+		linechart.YLabelCellOpts(cell.FgColor(cell.ColorCyan)),
+		linechart.XLabelCellOpts(cell.FgColor(cell.ColorCyan)),
+		linechart.YAxisAdaptive(),
+		linechart.YAxisValueFormatter(format),
+	)
+}
+
+// 画面に置くときのオプション
+func (hp *historyPanel) options() []container.Option {
+	depthTitle := "Depth (m)"
+	if units == unitsImperial {
+		depthTitle = "Depth (ft)"
+	}
+	if hp.text != nil {
+		return []container.Option{
+			container.Border(linestyle.Light),
+			container.BorderTitle("Speed / Depth History"),
+			container.PlaceWidget(hp.text),
+		}
+	}
+	return []container.Option{
+		container.SplitVertical(
+			container.Left(
+				container.Border(linestyle.Light),
+				container.BorderTitle("Speed (kt)"),
+				container.PlaceWidget(hp.speed),
+			),
+			container.Right(
+				container.Border(linestyle.Light),
+				container.BorderTitle(depthTitle),
+				container.PlaceWidget(hp.depth),
+			),
+		),
+	}
+}
+
+// 写しに入れた推移を描く
+func (hp *historyPanel) draw(f *frame) error {
+	speed := f.speeds
+	if len(speed) == 0 {
+		return nil
+	}
+	unit, scale := "m", 1.0
+	if units == unitsImperial {
+		unit, scale = "ft", metersPerFoot
+	}
+	// 写しの値は書き換えない
+	depth := make([]float64, len(f.depths))
+	for i, d := range f.depths {
+		depth[i] = d / scale
+	}
+	if hp.text != nil {
+		hp.text.Reset()
+		if err := hp.text.Write(historyLine("Speed", "kt", speed, false, historyInterval), text.WriteCellOpts(cell.FgColor(cell.ColorYellow))); err != nil {
+			return err
+		}
+		return hp.text.Write(historyLine("Depth", unit, depth, true, historyInterval), text.WriteCellOpts(cell.FgColor(cell.ColorCyan)))
+	}
+	// 深いほど下に描く
+	for i := range depth {
+		depth[i] = -depth[i]
+	}
+	labels := linechart.SeriesXLabels(historyLabels(len(speed)))
+	if err := hp.speed.Series("speed", speed, linechart.SeriesCellOpts(cell.FgColor(cell.ColorYellow)), labels); err != nil {
+		return err
+	}
+	return hp.depth.Series("depth", depth, linechart.SeriesCellOpts(cell.FgColor(cell.ColorCyan)), labels)
+}
+
+// 低帯域モードの 1 行。今の値、期間の初めより上がったか (^) 下がったか (v)、期間中の幅
+// values は interval ごとの記録。down なら値が増えるのを下がったとみなす (深度)
+// 例: "Speed   12.3 kt ^   8.0-12.3 (10 min)"
+func historyLine(name, unit string, values []float64, down bool, interval time.Duration) string {
+	now, first := values[len(values)-1], values[0]
+	low, high := math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		low, high = math.Min(low, v), math.Max(high, v)
+	}
+	rise := now - first
+	if down {
+		rise = -rise
+	}
+	trend := "="
+	switch {
+	case rise >= 0.5:
+		trend = "^"
+	case rise <= -0.5:
+		trend = "v"
+	}
+	minutes := float64(len(values)-1) * interval.Minutes()
+	return fmt.Sprintf("%s %6.1f %s %s %5.1f-%.1f (%.0f min)\n", name, now, unit, trend, low, high, minutes)
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 速力と深度の推移のグラフ
+func TestHistory(t *testing.T) {
+	g := startGame(t)
+	g.advance(2 * time.Second)
+	g.expect("Speed (kt)")
+	g.advance(70 * time.Second)
+	g.expect("Speed (kt)")
+	// 機関の傾向のスパークライン。止まっていれば回転数は 0
+	g.expect("Engineering Trends")
+	g.expect("rpm 0")
+}
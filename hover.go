@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/mum4k/termdash/widgets/text"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 深度制御パネルの表示 (ホバリング・着底・雑音)
+func depthControlText(p *Player, t *text.Text) {
+	state := p.HoverState()
+	light := cell.ColorDefault
+	switch state {
+	case sim.HoverUnavailable:
+		light = cell.ColorYellow
+	case sim.HoverPumping:
+		light = cell.ColorCyan
+	case sim.HoverHolding:
+		light = cell.ColorGreen
+	}
+	t.Reset()
+	if err := t.Write(render.glyph("● ", "* "), text.WriteCellOpts(cell.FgColor(light))); err != nil {
+		panic(err)
+	}
+	if err := t.Write(fmt.Sprintf("HOVER %s\n", state)); err != nil {
+		panic(err)
+	}
+	// ホバリング中は目標深度 (ORD) と今の深度 (ACT) を並べる
+	depth := fmt.Sprintf("%.1f m", p.Depth())
+	if p.HoverEnabled {
+		depth = p.HoverDepth.Label("%.1f m")
+	}
+	if err := t.Write(fmt.Sprintf("Depth %s  Rate %+.2f m/s\nTrim %s  Planes %s\n",
+		depth, p.VerticalVelocity*60, p.Trim.Label("%+.1f"), p.Planes.Label("%+.1f°"))); err != nil {
+		panic(err)
+	}
+	ballastColor := cell.ColorDefault
+	if p.Blowing() {
+		ballastColor = cell.ColorYellow
+	}
+	if err := t.Write(fmt.Sprintf("Main ballast %s  Buoyancy %+.1f\n", p.Ballast.Label("%.0f%%"), p.NetBuoyancy()),
+		text.WriteCellOpts(cell.FgColor(ballastColor))); err != nil {
+		panic(err)
+	}
+
+	seabed, kind := terrain.SeabedAt(p.Position.X, p.Position.Y)
+	bottom := fmt.Sprintf("Bottom %.0f m (%s)", seabed, kind)
+	bottomColor := cell.ColorDefault
+	switch {
+	case p.LiftingOff:
+		bottom += "  LIFTING OFF"
+		bottomColor = cell.ColorCyan
+	case p.Bottomed:
+		bottom += "  BOTTOMED"
+		bottomColor = cell.ColorGreen
+	}
+	if err := t.Write(bottom+"\n", text.WriteCellOpts(cell.FgColor(bottomColor))); err != nil {
+		panic(err)
+	}
+	machinery, machineryColor := "RUNNING", cell.ColorDefault
+	if p.MachinerySecured {
+		machinery, machineryColor = "SECURED", cell.ColorGreen
+	}
+	if err := t.Write(fmt.Sprintf("Machinery %s  Noise %.0f dB  Hull %.0f%%\n", machinery, p.noiseLevel(), p.HullIntegrity),
+		text.WriteCellOpts(cell.FgColor(machineryColor))); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// H でホバリングを開始・解除できる
+func TestHover(t *testing.T) {
+	g := startGame(t)
+	g.expect("HOVER OFF")
+	g.key("h")
+	g.expect("[ORDER] Hover on")
+	g.advance(time.Second)
+	g.expect("HOVER HOLDING")
+	g.key("h")
+	g.expect("[ORDER] Hover off")
+	g.advance(time.Second)
+	g.expect("HOVER OFF")
+}
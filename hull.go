@@ -0,0 +1,131 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 船体の掃除
+//
+// 汚れは潜水員を出して落とす。潜水員が出られるのは、行き足を止めて海面近くにいるか、
+// ごく浅い海底に沈座しているときだけで、条件が崩れると潜水員を呼び戻す。
+// 集合地点で待つ支援艦に横付けしていれば、港と同じように手早く落とせる。
+
+const (
+	// 潜水員が出られる深度 (m)
+	diverMaxDepth = 10.0
+	// 行き足がこれより速いと潜水員は出られない (ノット)
+	diverMaxVelocity = 1.0
+	// 支援艦に横付けしているとみなす距離 (m)
+	tenderRange = 500.0
+	// これ以下なら掃除は終わり (%)
+	cleanHullFouling = 0.5
+	// 汚れ 1% あたりの流れの音の増加 (dB)。汚れ切ると 8 dB
+	foulingNoisePerPercent = 0.08
+)
+
+// フリート配信で上書きできる (broadcast.go)
+var (
+	// 潜水員が1分で落とせる汚れ (%)
+	diverCleanRate = 5.0
+	// 支援艦に横付けしているときに1分で落とせる汚れ (%)
+	tenderCleanRate = 20.0
+)
+
+// 汚れた船体が水を切る音 (dB)
+// 行き足がなければ音はしない
+func (p *Player) foulingNoise() float64 {
+	if p.Velocity < diverMaxVelocity {
+		return 0
+	}
+	return p.Fouling * foulingNoisePerPercent
+}
+
+type hullCleaning struct {
+	events *eventLog
+	// 支援艦がいる場所 (集合地点)
+	tenders func() []sim.Point3D
+
+	mu     sync.Mutex
+	active bool
+}
+
+func newHullCleaning(events *eventLog, tenders func() []sim.Point3D) *hullCleaning {
+	return &hullCleaning{events: events, tenders: tenders}
+}
+
+// 潜水員が出られない理由 (出られるなら空)
+func diverRestriction(p *Player) string {
+	switch {
+	case p.Depth() > diverMaxDepth:
+		return "too deep for divers"
+	case p.Velocity > diverMaxVelocity:
+		return "the boat is making way"
+	}
+	return ""
+}
+
+// 支援艦に横付けしているか
+func (h *hullCleaning) alongside(p *Player) bool {
+	for _, pos := range h.tenders() {
+		if sim.HorizontalDistance(p.Position, pos) <= tenderRange {
+			return true
+		}
+	}
+	return false
+}
+
+// 掃除の開始・中止 (orderCleanHull の処理)
+func (h *hullCleaning) setActive(p *Player, active bool) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !active {
+		h.active = false
+		return nil
+	}
+	if p.Fouling <= cleanHullFouling {
+		return orderRefusedError{"the hull is clean"}
+	}
+	if reason := diverRestriction(p); reason != "" {
+		return orderRefusedError{reason}
+	}
+	h.active = true
+	if h.alongside(p) {
+		h.events.add(cell.ColorCyan, "[HULL] Alongside the tender. Cleaning party over the side.")
+	} else {
+		h.events.add(cell.ColorCyan, "[HULL] Divers over the side.")
+	}
+	return nil
+}
+
+// dt 秒分だけ汚れを落とす (シミュレーション時間で進める)
+func (h *hullCleaning) step(p *Player, dt float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.active {
+		return
+	}
+	if reason := diverRestriction(p); reason != "" {
+		h.active = false
+		h.events.add(cell.ColorYellow, "[HULL] Divers recalled: %s. Fouling %.0f%%.", reason, p.Fouling)
+		return
+	}
+	rate := diverCleanRate
+	if h.alongside(p) {
+		rate = tenderCleanRate
+	}
+	p.Fouling -= rate * dt / 60
+	if p.Fouling <= cleanHullFouling {
+		p.Fouling = 0
+		h.active = false
+		h.events.add(cell.ColorGreen, "[HULL] Hull clean. Divers back aboard.")
+	}
+}
+
+func (h *hullCleaning) cleaning() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.active
+}
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 教官席
+//
+// -web で乗員と画面を共有しているときに、-instructor で鍵を決めると /instructor に教官席が開く。
+// 教官は乗員に見えない真の状況 (すべての船の位置と、乗員の航跡に対応する本当の船) を見ながら、
+// 故障を起こしたり、船を出したり、乗員の海図に書き込んだりできる。
+// 教官の操作はゲームループで反映する (シミュレーションと同じゴルーチンで動かす)。
+
+// 教官が起こせる故障
+const (
+	casualtyReactorScram = "reactor-scram"
+	casualtyHullDamage   = "hull-damage"
+	casualtyFuelLeak     = "fuel-leak"
+)
+
+const (
+	// 船体の損傷で下がる健全度 (%)
+	casualtyHullLoss = 20.0
+	// 燃料漏れで失う燃料の割合
+	casualtyFuelLoss = 0.1
+)
+
+// 教官席から見るゲームの中身
+type instructorGame struct {
+	// player を変えるのはゲームループの中 (step) だけ。状態を返すときは艦の状態の置き場 (store) の写しを読む
+	player  *Player
+	store   *playerState
+	traffic *traffic
+	tracks  *trackManager
+	chart   *chart
+	events  *eventLog
+	enemies *enemyFleet
+}
+
+type instructorStation struct {
+	key string
+
+	mu sync.Mutex
+	// ゲームが始まるまでは nil
+	game    *instructorGame
+	pending []func(g *instructorGame)
+}
+
+func newInstructorStation(key string) *instructorStation {
+	return &instructorStation{key: key}
+}
+
+// ゲームの中身をつなぐ
+// Web サーバーはゲームより先に起動するので、それまでの要求には 503 を返す
+func (s *instructorStation) connect(g instructorGame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.game = &g
+}
+
+func (s *instructorStation) connected() *instructorGame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.game
+}
+
+// 教官の操作を次のティックで反映するよう積む
+func (s *instructorStation) inject(fn func(g *instructorGame)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.game == nil {
+		return errors.New("the game has not started")
+	}
+	s.pending = append(s.pending, fn)
+	return nil
+}
+
+// 積まれた操作を反映する (ゲームループから呼ぶ)
+func (s *instructorStation) step() {
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = nil
+	g := s.game
+	s.mu.Unlock()
+	for _, fn := range pending {
+		fn(g)
+	}
+}
+
+// 故障を起こす
+// 乗員には本物の故障と同じように知らせる
+func (s *instructorStation) injectCasualty(kind string) error {
+	var fn func(g *instructorGame)
+	switch kind {
+	case casualtyReactorScram:
+		fn = func(g *instructorGame) {
+			if g.player.Reactor.Scrammed {
+				return
+			}
+			g.player.Reactor.Scram()
+			g.player.Turbine.Order(0)
+			g.events.add(cell.ColorRed, "[ALARM] Reactor SCRAM! Propulsion lost. Restart once the core cools below %.0f K.", sim.ReactorRestartTemp)
+		}
+	case casualtyHullDamage:
+		fn = func(g *instructorGame) {
+			g.player.HullIntegrity = math.Max(g.player.HullIntegrity-casualtyHullLoss, 0)
+			g.events.add(cell.ColorRed, "[ALARM] Hull damage! Hull integrity %.0f%%", g.player.HullIntegrity)
+		}
+	case casualtyFuelLeak:
+		fn = func(g *instructorGame) {
+			if g.player.OutOfFuel() {
+				return
+			}
+			// 最後の一滴は updateFuel が使い切って、燃料切れを知らせる
+			g.player.Fuel = math.Max(g.player.Fuel-sim.FuelCapacity*casualtyFuelLoss, 1)
+			g.events.add(cell.ColorRed, "[ALARM] Fuel leak! Fuel %.0f (%.0f%%)", g.player.Fuel, g.player.Fuel/sim.FuelCapacity*100)
+		}
+	default:
+		return fmt.Errorf("unknown casualty %q", kind)
+	}
+	return s.inject(fn)
+}
+
+// 船を出す
+// 乗員には知らせない。探知できるかどうかは乗員しだい
+func (s *instructorStation) injectContact(name, class string, pos sim.Point3D, course, speed float64) error {
+	switch {
+	case name == "":
+		return errors.New("the contact needs a name")
+	case !knownClass(class):
+		return fmt.Errorf("unknown class %q", class)
+	case math.Abs(pos.X) > mapExtent || math.Abs(pos.Y) > mapExtent:
+		return fmt.Errorf("(%.0f, %.0f) is off the map (±%.0f m)", pos.X, pos.Y, mapExtent)
+	case speed < 0:
+		return errors.New("speed is negative")
+	}
+	if class == "" {
+		class = "Merchant"
+	}
+	return s.inject(func(g *instructorGame) { g.traffic.spawnAt(name, class, "", pos, course, speed) })
+}
+
+// 乗員の海図に書き込む
+func (s *instructorStation) annotate(text string, x, y float64) error {
+	switch {
+	case text == "":
+		return errors.New("the note is empty")
+	case math.Abs(x) > mapExtent || math.Abs(y) > mapExtent:
+		return fmt.Errorf("(%.0f, %.0f) is off the map (±%.0f m)", x, y, mapExtent)
+	}
+	return s.inject(func(g *instructorGame) {
+		g.chart.add(chartMark{Kind: markNote, Name: text, X: x, Y: y, Radius: markRadius})
+		g.events.add(cell.ColorMagenta, "[CHART] Instructor note: %s", text)
+	})
+}
+
+// 教官席に送る真の状況
+type instructorState struct {
+	Own      instructorOwnShip   `json:"own"`
+	Contacts []instructorContact `json:"contacts"`
+	Tracks   []instructorTrack   `json:"tracks"`
+}
+
+type instructorOwnShip struct {
+	X           float64 `json:"x"`
+	Y           float64 `json:"y"`
+	Depth       float64 `json:"depth"`
+	Heading     float64 `json:"heading"`
+	Speed       float64 `json:"speed"`
+	Hull        float64 `json:"hull"`
+	Fuel        float64 `json:"fuel"`
+	ReactorTemp float64 `json:"reactorTemp"`
+	Scrammed    bool    `json:"scrammed"`
+}
+
+type instructorContact struct {
+	Name    string  `json:"name"`
+	Class   string  `json:"class"`
+	Flag    string  `json:"flag"`
+	X       float64 `json:"x"`
+	Y       float64 `json:"y"`
+	Course  float64 `json:"course"`
+	Speed   float64 `json:"speed"`
+	Bearing float64 `json:"bearing"`
+	Range   float64 `json:"range"`
+	Depth   float64 `json:"depth"`
+	// 敵の艦艇の行動 (patrol / search / attack)。商船などは空
+	Behavior string `json:"behavior,omitempty"`
+}
+
+// 乗員の航跡と、それに対応する本当の船
+type instructorTrack struct {
+	Designation string  `json:"designation"`
+	Bearing     float64 `json:"bearing"`
+	// 距離がわかっていなければ省く
+	Range   *float64 `json:"range,omitempty"`
+	Quality float64  `json:"quality"`
+	Lost    bool     `json:"lost"`
+	// 対応する船と、乗員の解の誤差 (方位は度、距離は m)
+	Truth        string   `json:"truth,omitempty"`
+	BearingError float64  `json:"bearingError"`
+	RangeError   *float64 `json:"rangeError,omitempty"`
+}
+
+// 航跡に対応する船
+// 距離がわかっていれば推定位置に一番近い船、わからなければ方位が一番近い船
+func matchTrack(own sim.Point3D, t track, vessels []vessel) (vessel, bool) {
+	best, found := vessel{}, false
+	bestScore := math.Inf(1)
+	for _, v := range vessels {
+		var score float64
+		if math.IsNaN(t.rng) {
+			score = math.Abs(sim.NormalizeRelative(sim.BearingTo(own, v.position) - t.bearing))
+		} else {
+			score = sim.HorizontalDistance(t.estimate, v.position)
+		}
+		if score < bestScore {
+			best, found, bestScore = v, true, score
+		}
+	}
+	return best, found
+}
+
+func (g *instructorGame) state() instructorState {
+	f := g.store.view()
+	p := &f.player
+	own := p.Position
+	st := instructorState{
+		Own: instructorOwnShip{
+			X:           own.X,
+			Y:           own.Y,
+			Depth:       p.Depth(),
+			Heading:     p.Direction,
+			Speed:       p.Velocity,
+			Hull:        p.HullIntegrity,
+			Fuel:        p.Fuel / sim.FuelCapacity * 100,
+			ReactorTemp: p.Reactor.CoreTemp,
+			Scrammed:    p.Reactor.Scrammed,
+		},
+		Contacts: []instructorContact{},
+		Tracks:   []instructorTrack{},
+	}
+	vessels := g.traffic.vessels()
+	behaviors := g.enemies.behaviors()
+	for _, v := range vessels {
+		st.Contacts = append(st.Contacts, instructorContact{
+			Name:     v.name,
+			Class:    v.class,
+			Flag:     v.flag,
+			X:        v.position.X,
+			Y:        v.position.Y,
+			Course:   v.course,
+			Speed:    v.speed / knot,
+			Bearing:  sim.BearingTo(own, v.position),
+			Range:    sim.HorizontalDistance(own, v.position),
+			Depth:    v.depth,
+			Behavior: behaviors[v.id],
+		})
+	}
+	now := clock.Now()
+	list, _ := g.tracks.snapshot(now)
+	for _, t := range list {
+		it := instructorTrack{
+			Designation: t.designation(),
+			Bearing:     t.bearing,
+			Quality:     t.currentQuality(now),
+			Lost:        t.lost,
+		}
+		if !math.IsNaN(t.rng) {
+			rng := t.rng
+			it.Range = &rng
+		}
+		if v, ok := matchTrack(own, t, vessels); ok {
+			it.Truth = v.name
+			it.BearingError = sim.NormalizeRelative(t.bearing - sim.BearingTo(own, v.position))
+			if it.Range != nil {
+				rangeError := *it.Range - sim.HorizontalDistance(own, v.position)
+				it.RangeError = &rangeError
+			}
+		}
+		st.Tracks = append(st.Tracks, it)
+	}
+	return st
+}
+
+// 教官席の HTTP ハンドラを登録する
+func (s *instructorStation) register(mux *http.ServeMux) {
+	mux.HandleFunc("/instructor", s.authorized(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(instructorHTML))
+	}))
+	mux.HandleFunc("/instructor/state", s.authorized(func(w http.ResponseWriter, r *http.Request) {
+		g := s.connected()
+		if g == nil {
+			http.Error(w, "the game has not started", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(g.state())
+	}))
+	mux.HandleFunc("/instructor/casualty", s.posted(func(r *http.Request) error {
+		return s.injectCasualty(r.FormValue("kind"))
+	}))
+	mux.HandleFunc("/instructor/contact", s.posted(func(r *http.Request) error {
+		x, y, err := formPosition(r)
+		if err != nil {
+			return err
+		}
+		course, err := formFloat(r, "course")
+		if err != nil {
+			return err
+		}
+		speed, err := formFloat(r, "speed")
+		if err != nil {
+			return err
+		}
+		return s.injectContact(r.FormValue("name"), r.FormValue("class"), sim.Point3D{X: x, Y: y}, course, speed)
+	}))
+	mux.HandleFunc("/instructor/note", s.posted(func(r *http.Request) error {
+		x, y, err := formPosition(r)
+		if err != nil {
+			return err
+		}
+		return s.annotate(r.FormValue("text"), x, y)
+	}))
+}
+
+// 鍵が合わなければ 403 を返す
+func (s *instructorStation) authorized(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.FormValue("key")), []byte(s.key)) != 1 {
+			http.Error(w, "instructor key required", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// POST で受ける教官の操作
+// 受け付けられなければ 400 で理由を返す
+func (s *instructorStation) posted(fn func(r *http.Request) error) http.HandlerFunc {
+	return s.authorized(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST required", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := fn(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func formFloat(r *http.Request, name string) (float64, error) {
+	v, err := strconv.ParseFloat(r.FormValue(name), 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %q is not a number", name, r.FormValue(name))
+	}
+	return v, nil
+}
+
+func formPosition(r *http.Request) (x, y float64, err error) {
+	if x, err = formFloat(r, "x"); err != nil {
+		return 0, 0, err
+	}
+	if y, err = formFloat(r, "y"); err != nil {
+		return 0, 0, err
+	}
+	return x, y, nil
+}
+
+const instructorHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>ExplorerGame - Instructor</title>
+<style>
+  body { margin: 0; padding: 8px; background: #000; color: #ccc; font: 13px monospace; }
+  h2 { color: #6cf; font-size: 14px; margin: 12px 0 4px; }
+  table { border-collapse: collapse; }
+  td, th { padding: 1px 8px; text-align: right; }
+  th { color: #888; }
+  #plot { border: 1px solid #333; float: right; }
+  form { margin: 4px 0; }
+  input { width: 6em; background: #111; color: #ccc; border: 1px solid #444; }
+  #status { color: #fc6; }
+</style>
+</head>
+<body>
+<canvas id="plot" width="480" height="480"></canvas>
+<h2>Own ship</h2><div id="own"></div>
+<h2>Contacts (ground truth)</h2><table id="contacts"></table>
+<h2>Crew tracks vs truth</h2><table id="tracks"></table>
+<h2>Casualties</h2>
+<button onclick="post('casualty', {kind: 'reactor-scram'})">Reactor SCRAM</button>
+<button onclick="post('casualty', {kind: 'hull-damage'})">Hull damage</button>
+<button onclick="post('casualty', {kind: 'fuel-leak'})">Fuel leak</button>
+<h2>Inject contact</h2>
+<form onsubmit="return submitForm('contact', this)">
+  name <input name="name"> class <input name="class" placeholder="Merchant">
+  x <input name="x"> y <input name="y"> course <input name="course" value="0"> speed <input name="speed" value="10">
+  <button>Spawn</button>
+</form>
+<h2>Annotate crew chart</h2>
+<form onsubmit="return submitForm('note', this)">
+  text <input name="text" style="width: 16em"> x <input name="x"> y <input name="y">
+  <button>Mark</button>
+</form>
+<div id="status"></div>
+<script>
+  var key = new URLSearchParams(location.search).get('key') || '';
+  function post(path, fields) {
+    var body = new URLSearchParams(fields);
+    body.set('key', key);
+    fetch('/instructor/' + path, { method: 'POST', body: body }).then(function (res) {
+      return res.text().then(function (text) {
+        document.getElementById('status').textContent = res.ok ? path + ': done' : path + ': ' + text;
+      });
+    });
+  }
+  function submitForm(path, form) {
+    var fields = {};
+    for (var i = 0; i < form.elements.length; i++) {
+      if (form.elements[i].name) { fields[form.elements[i].name] = form.elements[i].value; }
+    }
+    post(path, fields);
+    return false;
+  }
+  function esc(s) {
+    return String(s).replace(/[&<>"]/g, function (c) { return { '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;' }[c]; });
+  }
+  function row(cells, tag) {
+    return '<tr>' + cells.map(function (c) { return '<' + tag + '>' + esc(c) + '</' + tag + '>'; }).join('') + '</tr>';
+  }
+  function num(v, digits) { return v === undefined ? '--' : v.toFixed(digits); }
+  function draw(st) {
+    var c = document.getElementById('plot'), ctx = c.getContext('2d');
+    var scale = c.width / 20000;
+    function at(x, y) { return [c.width / 2 + (x - st.own.x) * scale, c.height / 2 - (y - st.own.y) * scale]; }
+    ctx.fillStyle = '#000'; ctx.fillRect(0, 0, c.width, c.height);
+    ctx.fillStyle = '#6f6'; ctx.fillRect(c.width / 2 - 3, c.height / 2 - 3, 6, 6);
+    st.contacts.forEach(function (v) {
+      var p = at(v.x, v.y);
+      ctx.fillStyle = '#f66'; ctx.fillRect(p[0] - 2, p[1] - 2, 4, 4);
+      ctx.fillText(v.name, p[0] + 4, p[1]);
+    });
+    st.tracks.forEach(function (t) {
+      if (t.lost) { return; }
+      ctx.strokeStyle = '#fc6'; ctx.beginPath();
+      var rad = t.bearing * Math.PI / 180, r = t.range === undefined ? 10000 : t.range;
+      ctx.moveTo(c.width / 2, c.height / 2);
+      ctx.lineTo(c.width / 2 + Math.sin(rad) * r * scale, c.height / 2 - Math.cos(rad) * r * scale);
+      ctx.stroke();
+    });
+  }
+  function refresh() {
+    fetch('/instructor/state?key=' + encodeURIComponent(key)).then(function (res) {
+      if (!res.ok) { return res.text().then(function (t) { document.getElementById('status').textContent = t; }); }
+      return res.json().then(function (st) {
+        var o = st.own;
+        document.getElementById('own').textContent = 'x ' + num(o.x, 0) + '  y ' + num(o.y, 0) + '  depth ' + num(o.depth, 0) +
+          ' m  heading ' + num(o.heading, 0) + '  speed ' + num(o.speed, 1) + ' kn  hull ' + num(o.hull, 0) + '%  fuel ' +
+          num(o.fuel, 0) + '%  reactor ' + num(o.reactorTemp, 0) + ' K' + (o.scrammed ? ' SCRAM' : '');
+        document.getElementById('contacts').innerHTML = row(['name', 'class', 'flag', 'brg', 'range', 'course', 'speed', 'depth', 'behavior'], 'th') +
+          st.contacts.map(function (v) {
+            return row([v.name, v.class, v.flag, num(v.bearing, 0), num(v.range, 0), num(v.course, 0), num(v.speed, 1), num(v.depth, 0), v.behavior || ''], 'td');
+          }).join('');
+        document.getElementById('tracks').innerHTML = row(['track', 'brg', 'range', 'quality', 'truth', 'brg err', 'range err'], 'th') +
+          st.tracks.map(function (t) {
+            return row([t.designation + (t.lost ? ' (lost)' : ''), num(t.bearing, 0), num(t.range, 0), num(t.quality, 0),
+              t.truth || '--', num(t.bearingError, 1), num(t.rangeError, 0)], 'td');
+          }).join('');
+        draw(st);
+      });
+    });
+  }
+  refresh();
+  setInterval(refresh, 1000);
+</script>
+</body>
+</html>
+`
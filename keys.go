@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mum4k/termdash/keyboard"
+)
+
+// キー割り当て
+//
+// キーはすべて操作の名前に割り当てられ、設定ディレクトリの keys.json で変えられる。
+// keys.json は操作の名前からキーの一覧への対応で、書いた操作だけが既定の割り当てを置き換える。
+//
+//	{"turbine-up": ["w", "up"], "turbine-down": ["z", "down"]}
+//
+// 英字は大文字・小文字のどちらでも同じ操作になる。マクロの再生 (1〜9) は変えられない。
+
+// キー割り当てを保存するファイル名
+const keyBindingsFileName = "keys.json"
+
+type keyAction string
+
+const (
+	actionQuit            keyAction = "quit"
+	actionTurbineUp       keyAction = "turbine-up"
+	actionTurbineDown     keyAction = "turbine-down"
+	actionRudderLeft      keyAction = "rudder-left"
+	actionRudderRight     keyAction = "rudder-right"
+	actionCourseLeft      keyAction = "course-left"
+	actionCourseRight     keyAction = "course-right"
+	actionFlood           keyAction = "flood"
+	actionBlow            keyAction = "blow"
+	actionTrimHeavy       keyAction = "trim-heavy"
+	actionTrimLight       keyAction = "trim-light"
+	actionPlanesRise      keyAction = "planes-rise"
+	actionPlanesDive      keyAction = "planes-dive"
+	actionHover           keyAction = "hover"
+	actionSecureMachinery keyAction = "secure-machinery"
+	actionLiftOff         keyAction = "lift-off"
+	actionDeployBeacon    keyAction = "deploy-beacon"
+	actionInterrogate     keyAction = "interrogate-beacons"
+	actionLaunchXBT       keyAction = "launch-xbt"
+	actionSurvey          keyAction = "survey"
+	actionCleanHull       keyAction = "clean-hull"
+	actionReactorRestart  keyAction = "reactor-restart"
+	actionPing            keyAction = "ping"
+	actionPingSector      keyAction = "ping-sector"
+	actionCountermeasure  keyAction = "countermeasure"
+	actionLaunchDecoy     keyAction = "launch-decoy"
+	actionReadiness       keyAction = "readiness"
+	actionAbandonShip     keyAction = "abandon-ship"
+	actionSelectTrack     keyAction = "select-track"
+	actionMarkHazard      keyAction = "mark-hazard"
+	actionEkelundLeg      keyAction = "ekelund-leg"
+	actionRevealChart     keyAction = "reveal-chart"
+	actionZoomIn          keyAction = "zoom-in"
+	actionZoomOut         keyAction = "zoom-out"
+	actionPresetTube      keyAction = "preset-tube"
+	actionPresetField     keyAction = "preset-field"
+	actionPresetDown      keyAction = "preset-down"
+	actionPresetUp        keyAction = "preset-up"
+	actionRecordMacro     keyAction = "record-macro"
+	actionDiscardMacro    keyAction = "discard-macro"
+	actionFocusNext       keyAction = "focus-next"
+	actionPrepareTube     keyAction = "prepare-tube"
+	actionLaunchTorpedo   keyAction = "launch-torpedo"
+	actionBilgePumps      keyAction = "bilge-pumps"
+	actionCrewStation     keyAction = "crew-station"
+	actionCrewAssign      keyAction = "crew-assign"
+	actionCutNet          keyAction = "cut-net"
+	actionCrewTreat       keyAction = "crew-treat"
+	actionSnorkel         keyAction = "snorkel"
+	actionBoard           keyAction = "board"
+	actionWithdraw        keyAction = "withdraw"
+	actionPause           keyAction = "pause"
+	actionTimeCompression keyAction = "time-compression"
+	actionHeadingHold     keyAction = "heading-hold"
+	actionSpeedHold       keyAction = "speed-hold"
+	actionWaypoint        keyAction = "waypoint"
+	actionFollowRoute     keyAction = "follow-route"
+	actionClearRoute      keyAction = "clear-route"
+	actionInspectWreck    keyAction = "inspect-wreck"
+	actionRiskMap         keyAction = "risk-map"
+	actionTrainingDrill   keyAction = "training-drill"
+	actionResupply        keyAction = "resupply"
+)
+
+// 既定の割り当て
+var defaultKeyBindings = map[keyAction][]string{
+	actionQuit:            {"q"},
+	actionTurbineUp:       {"up"},
+	actionTurbineDown:     {"down"},
+	actionRudderLeft:      {"left"},
+	actionRudderRight:     {"right"},
+	actionCourseLeft:      {"-"},
+	actionCourseRight:     {"="},
+	actionFlood:           {"d"},
+	actionBlow:            {"s"},
+	actionTrimHeavy:       {"["},
+	actionTrimLight:       {"]"},
+	actionPlanesRise:      {"pgup"},
+	actionPlanesDive:      {"pgdn"},
+	actionHover:           {"h"},
+	actionSecureMachinery: {"x"},
+	actionLiftOff:         {"l"},
+	actionDeployBeacon:    {"b"},
+	actionInterrogate:     {"i"},
+	actionLaunchXBT:       {"e"},
+	actionSurvey:          {"f"},
+	actionCleanHull:       {"u"},
+	actionReactorRestart:  {"r"},
+	actionPing:            {"n"},
+	actionPingSector:      {">"},
+	actionCountermeasure:  {"c"},
+	actionLaunchDecoy:     {"y"},
+	actionReadiness:       {"g"},
+	actionAbandonShip:     {"a"},
+	actionSelectTrack:     {"t"},
+	actionMarkHazard:      {"k"},
+	actionEkelundLeg:      {"<"},
+	actionRevealChart:     {"?"},
+	actionZoomIn:          {"z"},
+	actionZoomOut:         {"v"},
+	actionPresetTube:      {"p"},
+	actionPresetField:     {"o"},
+	actionPresetDown:      {","},
+	actionPresetUp:        {"."},
+	actionRecordMacro:     {"m"},
+	actionDiscardMacro:    {"esc"},
+	actionFocusNext:       {"tab"},
+	actionPrepareTube:     {"w"},
+	actionLaunchTorpedo:   {"j"},
+	actionBilgePumps:      {";"},
+	actionCrewStation:     {"'"},
+	actionCrewAssign:      {"/"},
+	actionCutNet:          {"\\"},
+	actionCrewTreat:       {"`"},
+	actionSnorkel:         {"^"},
+	actionBoard:           {"0"},
+	actionWithdraw:        {")"},
+	actionPause:           {"space"},
+	actionTimeCompression: {"+"},
+	actionHeadingHold:     {"{"},
+	actionSpeedHold:       {"}"},
+	actionWaypoint:        {"*"},
+	actionFollowRoute:     {"&"},
+	actionClearRoute:      {"~"},
+	actionInspectWreck:    {"@"},
+	actionRiskMap:         {"#"},
+	actionTrainingDrill:   {"!"},
+	actionResupply:        {"$"},
+}
+
+// 1文字で書けないキーの名前
+var keyNames = map[string]keyboard.Key{
+	"enter":     keyboard.KeyEnter,
+	"esc":       keyboard.KeyEsc,
+	"tab":       keyboard.KeyTab,
+	"space":     ' ',
+	"backspace": keyboard.KeyBackspace2,
+	"up":        keyboard.KeyArrowUp,
+	"down":      keyboard.KeyArrowDown,
+	"left":      keyboard.KeyArrowLeft,
+	"right":     keyboard.KeyArrowRight,
+	"pgup":      keyboard.KeyPgUp,
+	"pgdn":      keyboard.KeyPgDn,
+}
+
+// キーの名前か1文字をキーにする
+func parseKey(name string) (keyboard.Key, error) {
+	if k, ok := keyNames[strings.ToLower(name)]; ok {
+		return k, nil
+	}
+	runes := []rune(name)
+	if len(runes) != 1 {
+		return 0, fmt.Errorf("unknown key %q", name)
+	}
+	return keyboard.Key(runes[0]), nil
+}
+
+// キーから操作への対応
+type keyBindings map[keyboard.Key]keyAction
+
+// 既定の割り当てに configured (config.toml の [keys])、path の割り当ての順に重ねる。ファイルがなければ path は使わない
+func loadKeyBindings(path string, configured map[keyAction][]string) (keyBindings, error) {
+	actions := map[keyAction][]string{}
+	for a, keys := range defaultKeyBindings {
+		actions[a] = keys
+	}
+	for a, keys := range configured {
+		actions[a] = keys
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		var custom map[keyAction][]string
+		if err := json.Unmarshal(data, &custom); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		for a, keys := range custom {
+			if _, ok := defaultKeyBindings[a]; !ok {
+				return nil, fmt.Errorf("%s: unknown action %q", path, a)
+			}
+			actions[a] = keys
+		}
+	}
+
+	// 同じキーが2つの操作に割り当てられていたときのエラーが毎回同じになるよう、名前順に処理する
+	names := make([]string, 0, len(actions))
+	for a := range actions {
+		names = append(names, string(a))
+	}
+	sort.Strings(names)
+
+	b := keyBindings{}
+	for _, name := range names {
+		for _, s := range actions[keyAction(name)] {
+			k, err := parseKey(s)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %v", path, name, err)
+			}
+			keys := []keyboard.Key{k}
+			if r := rune(k); r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+				keys = []keyboard.Key{keyboard.Key(r | 0x20), keyboard.Key(r &^ 0x20)}
+			}
+			for _, k := range keys {
+				if r := rune(k); r >= '1' && r <= '9' {
+					return nil, fmt.Errorf("%s: %s: %q is reserved for macros", path, name, s)
+				}
+				if other, ok := b[k]; ok {
+					return nil, fmt.Errorf("%s: %q is bound to both %s and %s", path, s, other, name)
+				}
+				b[k] = keyAction(name)
+			}
+		}
+	}
+	return b, nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 矢印キーで回転数と舵を、PgUp / PgDn で潜舵を操作する
+func TestKeys(t *testing.T) {
+	g := startGame(t)
+	g.advance(time.Second)
+	g.key("up")
+	g.expect("[ORDER] Turbine rpm 10")
+	g.key("up")
+	g.expect("[ORDER] Turbine rpm 20")
+	g.key("down")
+	g.expect("[ORDER] Turbine rpm 10")
+	g.key("left")
+	g.expect("[ORDER] Rudder -2.5")
+	g.key("right")
+	g.key("right")
+	g.expect("[ORDER] Rudder +2.5")
+	g.key("pgdn")
+	g.key("pgdn")
+	g.expect("[ORDER] Planes -5.0")
+	g.key("pgup")
+	g.expect("[ORDER] Planes -2.5")
+	g.advance(time.Minute)
+	g.expect("Planes ORD -2.5°  ACT -2.5°")
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 遠くの配置の遅れの補い
+//
+// 200ms ほど遅れる回線でも計器が滑らかに動き、命令が届いたかわかるようにする。
+//   - サーバーは計器の値 (速力・針路・深度・回転数と、その変わる速さ) を instrumentInterval ごとに配置へ送る。
+//     配置は次の値が届くまで変わる速さから値を先読みし、新しい値には instrumentBlend かけて寄せる
+//   - 配置はキー入力に通し番号を付けて送り、サーバーは受け取るとすぐ ack を返す。
+//     配置は ack が届くまで送信中の数を出し、届いたら往復の時間を出す
+// 画面そのものはサーバーが描いたものなので、先読みするのは画面の下に配置が出す計器の行だけ。
+
+const (
+	// 計器の値を送る間隔
+	instrumentInterval = 250 * time.Millisecond
+	// 先読みする最長の時間。これより長く届かなければ値を止める
+	instrumentPredictLimit = time.Second
+	// 新しい値に寄せるのにかける時間
+	instrumentBlend = 250 * time.Millisecond
+	// 端末クライアントが計器の行を書き直す間隔
+	instrumentRedraw = 50 * time.Millisecond
+)
+
+// サーバーから配置への計器の値
+//
+//	{"type": "instruments", "speed": 12.1, "accel": 0.2, "heading": 45, "turn": -1.5, "depth": 120, "climb": 0.3, "rpm": 80}
+type instrumentReading struct {
+	Type    string  `json:"type"`
+	Speed   float64 `json:"speed"`   // ノット
+	Accel   float64 `json:"accel"`   // ノット毎秒
+	Heading float64 `json:"heading"` // 度
+	Turn    float64 `json:"turn"`    // 度毎秒 (右回りが正)
+	Depth   float64 `json:"depth"`   // m
+	Climb   float64 `json:"climb"`   // m 毎秒 (深くなる向きが正)
+	RPM     float64 `json:"rpm"`
+}
+
+// p の今の計器の値。変わる速さは付けない
+func readInstruments(p *Player) instrumentReading {
+	return instrumentReading{Speed: p.Velocity, Heading: p.Direction, Depth: p.Depth(), RPM: p.Turbine.Actual}
+}
+
+// 計器の値を instrumentInterval ごとに全配置へ送る
+// 変わる速さは前に送った値との差から求める
+func (m *mirrorTerminal) streamInstruments(ctx context.Context, read func() instrumentReading) {
+	ticker := clock.NewTicker(instrumentInterval)
+	defer ticker.Stop()
+
+	var prev instrumentReading
+	started := false
+	for {
+		select {
+		case <-ticker.C():
+			r := read()
+			r.Type = "instruments"
+			if started {
+				dt := instrumentInterval.Seconds()
+				r.Accel = (r.Speed - prev.Speed) / dt
+				r.Turn = sim.NormalizeRelative(r.Heading-prev.Heading) / dt
+				r.Climb = (r.Depth - prev.Depth) / dt
+			}
+			prev, started = r, true
+			data, _ := json.Marshal(r)
+			m.mu.Lock()
+			for c := range m.clients {
+				select {
+				case c.out <- wsFrame{wsOpText, data}:
+				default:
+				}
+			}
+			m.mu.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// 届いた計器の値から、今の表示の値を先読みする (端末クライアント用。ブラウザは同じことを JavaScript で行う)
+type instrumentPredictor struct {
+	mu sync.Mutex
+	// 最後に届いた値と届いた時刻
+	last instrumentReading
+	at   time.Time
+	// 届いたときに表示していた値。ここから先読みの値へ寄せる
+	from  instrumentReading
+	valid bool
+}
+
+func (p *instrumentPredictor) update(r instrumentReading, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.valid {
+		p.from = p.predictLocked(now)
+	} else {
+		p.from = r
+	}
+	p.last, p.at, p.valid = r, now, true
+}
+
+// now の時点の表示の値。まだ届いていなければ false
+func (p *instrumentPredictor) predict(now time.Time) (instrumentReading, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.valid {
+		return instrumentReading{}, false
+	}
+	return p.predictLocked(now), true
+}
+
+func (p *instrumentPredictor) predictLocked(now time.Time) instrumentReading {
+	age := now.Sub(p.at)
+	ahead := math.Min(age.Seconds(), instrumentPredictLimit.Seconds())
+	target := p.last
+	target.Speed += p.last.Accel * ahead
+	target.Heading = sim.NormalizeBearing(p.last.Heading + p.last.Turn*ahead)
+	target.Depth += p.last.Climb * ahead
+
+	w := math.Min(age.Seconds()/instrumentBlend.Seconds(), 1)
+	blend := func(from, to float64) float64 { return from + (to-from)*w }
+	shown := target
+	shown.Speed = blend(p.from.Speed, target.Speed)
+	shown.Heading = sim.NormalizeBearing(p.from.Heading + sim.NormalizeRelative(target.Heading-p.from.Heading)*w)
+	shown.Depth = blend(p.from.Depth, target.Depth)
+	shown.RPM = blend(p.from.RPM, target.RPM)
+	return shown
+}
+
+// 送ったキー入力の ack を待つ
+type orderAcks struct {
+	mu      sync.Mutex
+	next    int
+	pending map[int]time.Time
+	// 最後に ack が届いたときの往復の時間
+	rtt time.Duration
+}
+
+func newOrderAcks() *orderAcks {
+	return &orderAcks{pending: map[int]time.Time{}}
+}
+
+// 次の入力の通し番号を振る
+func (a *orderAcks) sent(now time.Time) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.next++
+	a.pending[a.next] = now
+	return a.next
+}
+
+func (a *orderAcks) acked(seq int, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if at, ok := a.pending[seq]; ok {
+		a.rtt = now.Sub(at)
+		delete(a.pending, seq)
+	}
+}
+
+// つなぎ直したときは、返ってこなかった入力を忘れる
+func (a *orderAcks) reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pending = map[int]time.Time{}
+}
+
+// 配置の画面の下に出す行。例: "SPD 12.3 kt  HDG 045  DEPTH 120 m  RPM  80 | link 212 ms | 1 order sending"
+func stationStatusLine(r instrumentReading, ok bool, a *orderAcks) string {
+	line := "SPD  --.- kt  HDG ---  DEPTH --- m  RPM ---"
+	if ok {
+		line = fmt.Sprintf("SPD %5.1f kt  HDG %03.0f  DEPTH %s  RPM %3.0f", r.Speed, r.Heading, units.depth(r.Depth, 0), r.RPM)
+	}
+	a.mu.Lock()
+	rtt, pending := a.rtt, len(a.pending)
+	a.mu.Unlock()
+	if rtt > 0 {
+		line += fmt.Sprintf(" | link %d ms", rtt/time.Millisecond)
+	}
+	switch {
+	case pending == 1:
+		line += " | 1 order sending"
+	case pending > 1:
+		line += fmt.Sprintf(" | %d orders sending", pending)
+	case rtt > 0:
+		line += " | orders acknowledged"
+	}
+	return line
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+)
+
+// ログ
+//
+// 画面を termdash が使っているので、ログは標準出力ではなく設定ディレクトリの explorergame.log に書く。
+// 行には時刻・重さ・仕組みの名前 (net, audio など) を付ける。ファイルが logMaxSize を超えたら
+// explorergame.log.1, .2 … と順に送り、logKeep 個より古いものは消す。
+// -log-level より軽い行は書かない。-debug では debug の行まで書き、書いた行をイベントログにも [DEBUG] で出す。
+//
+//	2026-10-16 21:04:05.123 INFO  [net] station-1a2b3c4d connected
+
+// ログのファイル名
+const logFileName = "explorergame.log"
+
+const (
+	// ファイルを送る大きさ (バイト)
+	logMaxSize = 1 << 20
+	// 残す古いファイルの数
+	logKeep = 3
+)
+
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+func (l logLevel) String() string {
+	switch l {
+	case levelDebug:
+		return "DEBUG"
+	case levelWarn:
+		return "WARN"
+	case levelError:
+		return "ERROR"
+	}
+	return "INFO"
+}
+
+func (l logLevel) color() cell.Color {
+	switch l {
+	case levelWarn:
+		return cell.ColorYellow
+	case levelError:
+		return cell.ColorRed
+	}
+	return cell.ColorDefault
+}
+
+// -log-level の値
+func parseLogLevel(s string) (logLevel, error) {
+	for l := levelDebug; l <= levelError; l++ {
+		if strings.EqualFold(s, l.String()) {
+			return l, nil
+		}
+	}
+	return levelInfo, fmt.Errorf("unknown log level %q (debug, info, warn, error)", s)
+}
+
+type logger struct {
+	mu    sync.Mutex
+	level logLevel
+	path  string
+	file  *os.File
+	size  int64
+	// 書いた行をイベントログにも出す (-debug)。nil なら出さない
+	mirror *eventLog
+}
+
+// ゲーム全体のログ。main で open するまではどこにも書かない
+var logs = &logger{level: levelInfo}
+
+// path に追記で開く。大きすぎれば先に送る
+func (l *logger) open(path string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.path = path
+	if info, err := os.Stat(path); err == nil && info.Size() >= logMaxSize {
+		l.rotate()
+	}
+	return l.reopen()
+}
+
+// l.mu を保持した状態で呼ぶ
+func (l *logger) reopen() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file, l.size = f, info.Size()
+	return nil
+}
+
+// ファイルを 1 つずつ後ろへ送る (l.mu を保持した状態で呼ぶ)
+func (l *logger) rotate() {
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+	os.Remove(fmt.Sprintf("%s.%d", l.path, logKeep))
+	for i := logKeep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+	}
+	os.Rename(l.path, l.path+".1")
+}
+
+func (l *logger) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+}
+
+// 書いた行をイベントログにも出す
+func (l *logger) setMirror(events *eventLog) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.mirror = events
+}
+
+func (l *logger) logf(level logLevel, tag, format string, args ...interface{}) {
+	l.mu.Lock()
+	if level < l.level {
+		l.mu.Unlock()
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	if l.file != nil {
+		line := fmt.Sprintf("%s %-5s [%s] %s\n", time.Now().Format("2006-01-02 15:04:05.000"), level, tag, msg)
+		n, _ := l.file.WriteString(line)
+		l.size += int64(n)
+		if l.size >= logMaxSize {
+			l.rotate()
+			l.reopen()
+		}
+	}
+	mirror := l.mirror
+	l.mu.Unlock()
+
+	// イベントログの書き込みはログの鍵の外で行う
+	if mirror != nil {
+		mirror.add(level.color(), "[DEBUG] %s %s: %s", level, tag, msg)
+	}
+}
+
+func (l *logger) debugf(tag, format string, args ...interface{}) {
+	l.logf(levelDebug, tag, format, args...)
+}
+
+func (l *logger) infof(tag, format string, args ...interface{}) {
+	l.logf(levelInfo, tag, format, args...)
+}
+
+func (l *logger) warnf(tag, format string, args ...interface{}) {
+	l.logf(levelWarn, tag, format, args...)
+}
+
+func (l *logger) errorf(tag, format string, args ...interface{}) {
+	l.logf(levelError, tag, format, args...)
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 補給港と補給
+//
+// 味方の補給港 (錨泊した補給艦) には魚雷、修理の部品、燃料の在庫があり、浮上して横付けし、止まって
+// resupply キーを押すと、足りない分を在庫のあるだけ積む。部品は 1 つで損傷した区画を portPartRepair だけ直す
+// (傷んだ区画から順に)。在庫は設定ディレクトリの ports.json に残し、哨戒をまたいで減ったままになる。
+//
+// portDeliveryInterval (シミュレーション時間) ごとに、補給の船団が港ごとに決まった量を運び、容量まで在庫を戻す。
+// 船団は港ごとの危険度の確率で敵に沈められ、港から portInterdictRange の中に敵の軍艦がいれば港が封鎖されて届かない。
+// 後方の港は安全だが小さく、前線に近い港は大きいが船団が届きにくい。いつどこで補給するかは艦長が決める。
+
+// 補給港の在庫を保存するファイル名
+const portsFileName = "ports.json"
+
+const (
+	// 補給できる港からの距離 (m) と、止まっているとみなす速さ (m/s)
+	portRange       = 500.0
+	portMaxVelocity = 0.5
+	// 部品 1 つで直す区画の状態 (%)
+	portPartRepair = 10.0
+	// 船団が着く間隔 (シミュレーション時間)
+	portDeliveryInterval = 2 * time.Hour
+	// この距離の中に敵の軍艦がいると港は封鎖される (m)
+	portInterdictRange = 10000.0
+)
+
+// 在庫の量
+type portStock struct {
+	Torpedoes int     `json:"torpedoes"`
+	Parts     int     `json:"parts"`
+	Fuel      float64 `json:"fuel"`
+}
+
+// 例: "T6 P10 F60k"
+func (s portStock) String() string {
+	return fmt.Sprintf("T%d P%d F%.0fk", s.Torpedoes, s.Parts, s.Fuel/1000)
+}
+
+// 補給港
+type supplyPort struct {
+	name     string
+	position sim.Point3D
+	// 在庫の容量と、1 回の船団で届く量
+	capacity, delivery portStock
+	// 船団が沈められる確率
+	risk float64
+}
+
+// 補給港の一覧。ALPHA は集合地点 (beacon.go) の補給艦
+var supplyPorts = []supplyPort{
+	{name: "ALPHA", position: sim.Point3D{X: 3000, Y: 4000}, capacity: portStock{6, 10, 60000}, delivery: portStock{1, 2, 10000}, risk: 0.05},
+	{name: "KESTREL", position: sim.Point3D{X: -18000, Y: 16000}, capacity: portStock{10, 20, 100000}, delivery: portStock{3, 5, 30000}, risk: 0.2},
+	{name: "OSPREY", position: sim.Point3D{X: 20000, Y: -14000}, capacity: portStock{16, 30, 150000}, delivery: portStock{6, 10, 60000}, risk: 0.4},
+}
+
+func findSupplyPort(name string) (supplyPort, bool) {
+	for _, port := range supplyPorts {
+		if port.name == name {
+			return port, true
+		}
+	}
+	return supplyPort{}, false
+}
+
+// ports.json の 1 件
+type portRecord struct {
+	Name string `json:"name"`
+	portStock
+}
+
+// ports.json の中身
+type portsFile struct {
+	Ports []portRecord `json:"ports"`
+}
+
+type logistics struct {
+	path   string
+	events *eventLog
+	// 港の近くの敵の軍艦を調べる船の一覧
+	vessels func() []vessel
+	// 船団が沈められるかを決める乱数。ほかの乱数の系列を変えないよう、main の最後で渡す
+	rng *rand.Rand
+
+	mu sync.Mutex
+	// supplyPorts と同じ順の在庫と、封鎖されているか
+	stocks    []portStock
+	blockaded []bool
+	// 次の船団が着く時刻 (シミュレーション時間)
+	due time.Duration
+}
+
+// ports.json から在庫を読む。ない港は容量いっぱいから始める
+func newLogistics(path string, events *eventLog, vessels func() []vessel) (*logistics, error) {
+	l := &logistics{path: path, events: events, vessels: vessels, due: portDeliveryInterval}
+	l.stocks = make([]portStock, len(supplyPorts))
+	l.blockaded = make([]bool, len(supplyPorts))
+	for i, port := range supplyPorts {
+		l.stocks[i] = port.capacity
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		var f portsFile
+		if err := json.Unmarshal(data, &f); err != nil {
+			return nil, err
+		}
+		for _, r := range f.Ports {
+			for i, port := range supplyPorts {
+				if r.Name == port.name {
+					l.stocks[i] = r.portStock.limit(port.capacity)
+				}
+			}
+		}
+	}
+	return l, nil
+}
+
+// 0 から容量 c までに収める
+func (s portStock) limit(c portStock) portStock {
+	s.Torpedoes = clampCount(s.Torpedoes, c.Torpedoes)
+	s.Parts = clampCount(s.Parts, c.Parts)
+	s.Fuel = math.Max(math.Min(s.Fuel, c.Fuel), 0)
+	return s
+}
+
+// 0 から max までに収める
+func clampCount(n, max int) int {
+	switch {
+	case n < 0:
+		return 0
+	case n > max:
+		return max
+	}
+	return n
+}
+
+func (l *logistics) save() error {
+	l.mu.Lock()
+	var f portsFile
+	for i, port := range supplyPorts {
+		f.Ports = append(f.Ports, portRecord{Name: port.name, portStock: l.stocks[i]})
+	}
+	l.mu.Unlock()
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(l.path, data, 0644)
+}
+
+// 船団を着かせる (ゲームループのタイマーから呼ぶ)
+func (l *logistics) step(now time.Duration) {
+	l.mu.Lock()
+	if now < l.due {
+		l.mu.Unlock()
+		return
+	}
+	l.due = now + portDeliveryInterval
+	vessels := l.vessels()
+	for i, port := range supplyPorts {
+		l.blockaded[i] = enemyWarshipNear(vessels, port.position)
+		switch {
+		case l.blockaded[i]:
+			l.events.add(cell.ColorYellow, "[LOGISTICS] %s is blockaded. No convoy this time.", port.name)
+		case l.rng.Float64() < port.risk:
+			l.events.add(cell.ColorYellow, "[LOGISTICS] Convoy for %s lost to enemy action.", port.name)
+		default:
+			s := l.stocks[i]
+			s.Torpedoes += port.delivery.Torpedoes
+			s.Parts += port.delivery.Parts
+			s.Fuel += port.delivery.Fuel
+			l.stocks[i] = s.limit(port.capacity)
+		}
+	}
+	l.mu.Unlock()
+	if err := l.save(); err != nil {
+		panic(err)
+	}
+}
+
+// pos から portInterdictRange の中に敵の軍艦がいるか
+func enemyWarshipNear(vessels []vessel, pos sim.Point3D) bool {
+	for _, v := range vessels {
+		if flagSide(v.flag) == sideHostile && warshipClasses[v.class] && sim.HorizontalDistance(v.position, pos) <= portInterdictRange {
+			return true
+		}
+	}
+	return false
+}
+
+// 横付けしている港の番号。なければ -1 と一番近い港
+func (l *logistics) alongside(p *Player) (int, supplyPort) {
+	nearest := 0
+	for i, port := range supplyPorts {
+		d := sim.HorizontalDistance(p.Position, port.position)
+		if d <= portRange {
+			return i, port
+		}
+		if d < sim.HorizontalDistance(p.Position, supplyPorts[nearest].position) {
+			nearest = i
+		}
+	}
+	return -1, supplyPorts[nearest]
+}
+
+// 横付けした港から積む (orderResupply の処理)
+func (l *logistics) resupply(p *Player, room *torpedoRoom) error {
+	i, port := l.alongside(p)
+	switch {
+	case i < 0:
+		return orderRefusedError{fmt.Sprintf("not alongside a supply port (%s %.1f km)", port.name, sim.HorizontalDistance(p.Position, port.position)/1000)}
+	case p.Depth() > surfacedDepth:
+		return orderRefusedError{"surface to take on stores"}
+	case p.Velocity > portMaxVelocity:
+		return orderRefusedError{"the boat is making way"}
+	}
+	l.mu.Lock()
+	stock := l.stocks[i]
+	var loaded portStock
+	loaded.Torpedoes = room.stow(stock.Torpedoes)
+	loaded.Fuel = math.Min(sim.FuelCapacity-p.Fuel, stock.Fuel)
+	p.Fuel += loaded.Fuel
+	loaded.Parts = p.repairWithParts(stock.Parts)
+	stock.Torpedoes -= loaded.Torpedoes
+	stock.Parts -= loaded.Parts
+	stock.Fuel -= loaded.Fuel
+	l.stocks[i] = stock
+	l.mu.Unlock()
+	if loaded == (portStock{}) {
+		return orderRefusedError{fmt.Sprintf("nothing to take on at %s (%s)", port.name, stock)}
+	}
+	l.events.add(cell.ColorGreen, "[LOGISTICS] Resupplied at %s: %d torpedoes, %d parts, %.0fk fuel. %s left.", port.name, loaded.Torpedoes, loaded.Parts, loaded.Fuel/1000, stock)
+	return l.save()
+}
+
+// 港ごとの在庫と封鎖 (海図のパネル用)
+func (l *logistics) snapshot() ([]portStock, []bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]portStock{}, l.stocks...), append([]bool{}, l.blockaded...)
+}
+
+// 発射管の外に最大 n 本まで積む。積んだ数
+func (r *torpedoRoom) stow(n int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	aboard := r.stowed
+	for _, st := range r.status {
+		if st.state != tubeEmpty && st.state != tubeDraining {
+			aboard++
+		}
+	}
+	n = clampCount(n, torpedoMagazine-aboard)
+	r.stowed += n
+	return n
+}
+
+// 部品を最大 n 個まで使い、傷んだ区画から portPartRepair ずつ直す。使った数
+func (p *Player) repairWithParts(n int) int {
+	used := 0
+	for ; used < n; used++ {
+		worst := 0
+		for c := range p.Compartments {
+			if p.Compartments[c] < p.Compartments[worst] {
+				worst = c
+			}
+		}
+		if p.Compartments[worst] >= 100 {
+			break
+		}
+		p.Compartments[worst] = math.Min(p.Compartments[worst]+portPartRepair, 100)
+	}
+	return used
+}
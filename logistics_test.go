@@ -0,0 +1,146 @@
+package main
+
+import (
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 補給港から離れていると補給できない
+func TestLogistics(t *testing.T) {
+	g := startGame(t)
+	g.advance(time.Second)
+	g.key("$")
+	g.advance(time.Second)
+	g.expect("Resupply refused: not alongside a supply port (ALPHA")
+}
+
+// 敵のいない海の補給港。在庫は一時ディレクトリに残す
+func newTestLogistics(t *testing.T, vessels ...vessel) (*logistics, string, *testEvents) {
+	t.Helper()
+	events, logged := newTestEvents(t)
+	path := filepath.Join(t.TempDir(), portsFileName)
+	l, err := newLogistics(path, events, func() []vessel { return vessels })
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.rng = rand.New(rand.NewSource(testSeed))
+	return l, path, logged
+}
+
+// ALPHA に横付けして止まっている艦
+func newAlongsidePlayer() *Player {
+	p := newTestPlayer()
+	p.Position = supplyPorts[0].position
+	return p
+}
+
+func TestResupplyRefillsStocks(t *testing.T) {
+	l, path, logged := newTestLogistics(t)
+	room := newTorpedoRoom(nil)
+	room.stowed = torpedoMagazine - 8
+	p := newAlongsidePlayer()
+	p.Fuel = sim.FuelCapacity - 20000
+	p.Compartments[0] = 75
+
+	if err := l.resupply(p, room); err != nil {
+		t.Fatal(err)
+	}
+	// 魚雷は港の在庫 (6 本) だけ、燃料は満タンまで、部品は区画が直るまで
+	if room.stowed != torpedoMagazine-2 {
+		t.Errorf("torpedoes stowed %d, want %d", room.stowed, torpedoMagazine-2)
+	}
+	if p.Fuel != sim.FuelCapacity {
+		t.Errorf("fuel %.0f, want %.0f", p.Fuel, sim.FuelCapacity)
+	}
+	if p.Compartments[0] != 100 {
+		t.Errorf("compartment repaired to %.0f%%, want 100%%", p.Compartments[0])
+	}
+	want := portStock{Torpedoes: 0, Parts: 7, Fuel: 40000}
+	if stocks, _ := l.snapshot(); stocks[0] != want {
+		t.Errorf("ALPHA stock %v, want %v", stocks[0], want)
+	}
+	if !logged.contains("[LOGISTICS] Resupplied at ALPHA: 6 torpedoes, 3 parts, 20k fuel.") {
+		t.Errorf("resupply not logged: %q", logged.lines)
+	}
+
+	// 減った在庫は次の哨戒にも残る
+	reloaded, err := newLogistics(path, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stocks, _ := reloaded.snapshot(); stocks[0] != want {
+		t.Errorf("reloaded ALPHA stock %v, want %v", stocks[0], want)
+	}
+
+	// 足りないものがなければ積むものもない
+	if err := l.resupply(p, room); err == nil || !strings.Contains(err.Error(), "nothing to take on at ALPHA") {
+		t.Errorf("second resupply: %v", err)
+	}
+}
+
+func TestResupplyRefused(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(p *Player)
+		want  string
+	}{
+		{"far from the port", func(p *Player) { p.Position = sim.Point3D{} }, "not alongside a supply port (ALPHA 5.0 km)"},
+		{"submerged", func(p *Player) { p.Position.Z = -30 }, "surface to take on stores"},
+		{"making way", func(p *Player) { p.Velocity = 3 }, "the boat is making way"},
+	}
+	for _, tt := range tests {
+		l, _, _ := newTestLogistics(t)
+		p := newAlongsidePlayer()
+		p.Fuel = 0
+		tt.setup(p)
+		err := l.resupply(p, newTorpedoRoom(nil))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.want)
+		}
+		if p.Fuel != 0 {
+			t.Errorf("%s: fuel loaded although refused", tt.name)
+		}
+	}
+}
+
+func TestConvoyDelivery(t *testing.T) {
+	l, _, _ := newTestLogistics(t)
+	l.stocks[0] = portStock{}
+	// testSeed の乱数では、どの港の船団も沈められない
+
+	l.step(portDeliveryInterval - time.Second)
+	if stocks, _ := l.snapshot(); stocks[0] != (portStock{}) {
+		t.Fatalf("convoy arrived early: %v", stocks[0])
+	}
+	l.step(portDeliveryInterval)
+	if stocks, _ := l.snapshot(); stocks[0] != supplyPorts[0].delivery {
+		t.Errorf("ALPHA stock after a convoy %v, want %v", stocks[0], supplyPorts[0].delivery)
+	}
+	// 容量より多くは届かない
+	if stocks, _ := l.snapshot(); stocks[2] != supplyPorts[2].capacity {
+		t.Errorf("OSPREY stock %v, want capacity %v", stocks[2], supplyPorts[2].capacity)
+	}
+}
+
+func TestBlockadedPortGetsNoConvoy(t *testing.T) {
+	enemy := vessel{name: "Raider", class: "Destroyer", flag: hostileFlag, position: sim.Point3D{X: supplyPorts[0].position.X + 5000, Y: supplyPorts[0].position.Y}}
+	l, _, logged := newTestLogistics(t, enemy)
+	l.stocks[0] = portStock{}
+
+	l.step(portDeliveryInterval)
+	stocks, blockaded := l.snapshot()
+	if !blockaded[0] || stocks[0] != (portStock{}) {
+		t.Errorf("ALPHA blockaded %v stock %v, want blockaded and empty", blockaded[0], stocks[0])
+	}
+	if blockaded[1] || blockaded[2] {
+		t.Errorf("ports far from the raider blockaded: %v", blockaded)
+	}
+	if !logged.contains("[LOGISTICS] ALPHA is blockaded.") {
+		t.Errorf("blockade not logged: %q", logged.lines)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/widgets/text"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// ゲームループと描画のパス
+//
+// 艦の状態 (Player) とサブシステムを進めるのは 1 つのゲームループだけにする。
+//   - ループは loopInterval ごとに物理を固定の刻み (sim.TickDuration) で進め (刻みに満たない分は World が持ち越す)、
+//     進んだシミュレーション時間だけ武器やセンサーのタイマー (simtimers.go) を進める。時間の圧縮は 1 回に進める時間を増やす
+//   - 商船の移動やセンサーの掃引のように決まった間隔で動く仕組みは every で登録し、ループの中で呼ぶ
+//   - 刻みは艦の状態の置き場 (state.go) の update の中で進める。キー入力・ボタン・マクロの命令も update で
+//     実行するので、刻みの途中には割り込まない
+//   - パネルは Player を直接読まず、描画のパス (renderPass) が renderInterval ごとに置き場の最新の写しを、
+//     更新の間隔が来たパネルに順に渡して描かせる
+//
+// どちらの間隔も 1 秒や 100 ミリ秒を割り切れる長さにして、テストの偽の時計でもちょうどの時刻に動くようにする。
+
+const (
+	// ゲームループの間隔
+	loopInterval = 25 * time.Millisecond
+	// 描画のパスの間隔。パネルはこれより細かくは更新しない
+	renderInterval = 50 * time.Millisecond
+)
+
+// 決まった間隔で呼ぶものの次の時刻
+type schedule struct {
+	interval time.Duration
+	next     time.Time
+}
+
+func newSchedule(interval time.Duration) schedule {
+	return schedule{interval: interval, next: clock.Now().Add(interval)}
+}
+
+// now に呼ぶ番か。呼ぶ番なら次の時刻に進める。遅れて溜まった分は 1 回にまとめる
+func (s *schedule) due(now time.Time) bool {
+	if now.Before(s.next) {
+		return false
+	}
+	for !now.Before(s.next) {
+		s.next = s.next.Add(s.interval)
+	}
+	return true
+}
+
+type loopSystem struct {
+	schedule
+	fn func(now time.Time)
+}
+
+type gameLoop struct {
+	world  *sim.World
+	timers *simTimers
+	state  *playerState
+	events *eventLog
+	chart  *chart
+	// リプレイの記録・再生 (replay.go)。nil なら使わない
+	replay replayHook
+	// タイトル画面 (demo.go)。true を返す間は哨戒の世界もタイマーも every の関数も進めない。nil なら出さない
+	title func(now time.Time) bool
+
+	mu      sync.Mutex
+	systems []*loopSystem
+	// 進めた刻みの数
+	ticks int64
+}
+
+func newGameLoop(world *sim.World, timers *simTimers, state *playerState, events *eventLog, ch *chart) *gameLoop {
+	return &gameLoop{world: world, timers: timers, state: state, events: events, chart: ch}
+}
+
+// interval ごとに fn をループの中で呼ぶ (壁時計で測る)
+// 一時停止中も呼ぶので、止めたいものは fn の中で simPause を見る
+func (l *gameLoop) every(interval time.Duration, fn func(now time.Time)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.systems = append(l.systems, &loopSystem{schedule: newSchedule(interval), fn: fn})
+}
+
+func (l *gameLoop) run(ctx context.Context) {
+	ticker := clock.NewTicker(loopInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C():
+			l.step(now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// 1 回分進める
+func (l *gameLoop) step(now time.Time) {
+	l.mu.Lock()
+	systems := append([]*loopSystem{}, l.systems...)
+	n := l.ticks
+	l.ticks++
+	l.mu.Unlock()
+
+	l.state.update(func(p *Player) {
+		if l.title != nil && l.title(now) {
+			return
+		}
+		if l.replay != nil {
+			l.replay.beginTick(n, p)
+			defer l.replay.endTick(n)
+		}
+		if !simPause.on() {
+			before := l.world.Elapsed()
+			// 時間の圧縮は 1 回に進める時間を増やす (ループの間隔は変えない)
+			reportSimEvents(l.events, l.chart, l.world.Step(loopInterval*time.Duration(simRate.factor())))
+			if dt := l.world.Elapsed() - before; dt > 0 {
+				l.timers.advance(l.world.Elapsed(), dt.Seconds())
+			}
+		}
+		for _, s := range systems {
+			if s.due(now) {
+				s.fn(now)
+			}
+		}
+	})
+}
+
+type panelDraw struct {
+	schedule
+	draw func(f *frame)
+}
+
+// パネルを描くただ 1 つのゴルーチン
+type renderPass struct {
+	state *playerState
+
+	mu     sync.Mutex
+	panels []*panelDraw
+}
+
+func newRenderPass(state *playerState) *renderPass {
+	return &renderPass{state: state}
+}
+
+// interval ごとに draw で描く
+func (r *renderPass) add(interval time.Duration, draw func(f *frame)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.panels = append(r.panels, &panelDraw{schedule: newSchedule(interval), draw: draw})
+}
+
+// interval ごとに compose で作った中身を t に書く
+// compose は艦の状態の置き場の鍵を持って呼ぶ (playerState.capture)。描くときは写しだけを読む
+func (r *renderPass) addText(interval time.Duration, t *text.Text, compose func(f *frame) panelText) {
+	id := r.state.capture(interval, compose)
+	r.add(interval, func(f *frame) { f.text(id).draw(t) })
+}
+
+func (r *renderPass) run(ctx context.Context) {
+	ticker := clock.NewTicker(renderInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C():
+			f := r.state.view()
+			r.mu.Lock()
+			panels := append([]*panelDraw{}, r.panels...)
+			r.mu.Unlock()
+			for _, p := range panels {
+				if p.due(now) {
+					p.draw(&f)
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/mum4k/termdash/widgetapi"
+	"github.com/mum4k/termdash/widgets/segmentdisplay"
+	"github.com/mum4k/termdash/widgets/text"
+)
+
+// 低帯域モード
+//
+// 遅い SSH 越しでも操艦できるよう、-low-bandwidth では端末へ送る書き換えを減らす。
+//   - 画面の再描画と、表示だけのパネルの更新の間隔を延ばす (シミュレーションの刻みは変えない)
+//   - 点字で細かく描く速力表示と回転計を文字の表示に、塗りつぶしの記号を ASCII に置き換える
+//   - 速力・回転数・舵角は小さな揺れでは書き換えず、決めた幅より動いたときだけ表示を変える
+
+const (
+	// 画面の再描画の間隔
+	normalRedrawInterval       = 16 * time.Millisecond
+	lowBandwidthRedrawInterval = 500 * time.Millisecond
+	// 低帯域モードでのパネルの最短の更新間隔
+	lowBandwidthPanelDelay = time.Second
+	// 低帯域モードで表示を変える幅
+	speedDeadband  = 0.5 // kt
+	rpmDeadband    = 5.0 // rpm
+	rudderDeadband = 1.0 // 舵角・艦首方位 (度)
+)
+
+type renderMode struct {
+	lowBandwidth bool
+	// config.toml の再描画の間隔とパネルの最短の更新間隔。0 なら既定のまま
+	redraw        time.Duration
+	minPanelDelay time.Duration
+}
+
+// 画面の描き方。main で -low-bandwidth と config.toml から決める
+var render renderMode
+
+func (m renderMode) redrawInterval() time.Duration {
+	interval := normalRedrawInterval
+	if m.redraw > 0 {
+		interval = m.redraw
+	}
+	if m.lowBandwidth && interval < lowBandwidthRedrawInterval {
+		return lowBandwidthRedrawInterval
+	}
+	return interval
+}
+
+// パネルの更新間隔。config.toml の最短の間隔と、低帯域モードでは lowBandwidthPanelDelay より短くしない
+func (m renderMode) panelDelay(d time.Duration) time.Duration {
+	if d < m.minPanelDelay {
+		d = m.minPanelDelay
+	}
+	if m.lowBandwidth && d < lowBandwidthPanelDelay {
+		return lowBandwidthPanelDelay
+	}
+	return d
+}
+
+// 表示の揺れを抑える幅。通常は 0 (毎回書き換える)
+func (m renderMode) deadband(band float64) float64 {
+	if m.lowBandwidth {
+		return band
+	}
+	return 0
+}
+
+// 記号。低帯域モードでは ASCII の coarse を使う
+func (m renderMode) glyph(dense, coarse string) string {
+	if m.lowBandwidth {
+		return coarse
+	}
+	return dense
+}
+
+// 揺れを抑えた表示値
+type steadyReading struct {
+	shown float64
+	set   bool
+}
+
+// 表示している値から band 以上動いたときだけ v に変える
+func (s *steadyReading) hold(v, band float64) float64 {
+	if !s.set || math.Abs(v-s.shown) >= band {
+		s.shown = v
+		s.set = true
+	}
+	return s.shown
+}
+
+// 速力の表示。通常は 7 セグメント表示、低帯域モードでは文字
+type speedReadout struct {
+	segments *segmentdisplay.SegmentDisplay
+	text     *text.Text
+
+	mu     sync.Mutex
+	steady steadyReading
+}
+
+func newSpeedReadout() (*speedReadout, error) {
+	r := &speedReadout{}
+	var err error
+	if render.lowBandwidth {
+		r.text, err = text.New()
+	} else {
+		r.segments, err = segmentdisplay.New()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// 画面に置くウィジェット
+func (r *speedReadout) widget() widgetapi.Widget {
+	if r.text != nil {
+		return r.text
+	}
+	return r.segments
+}
+
+// 速力 (kt) を出す
+func (r *speedReadout) show(knots float64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	shown := r.steady.hold(knots, render.deadband(speedDeadband))
+	if r.text != nil {
+		r.text.Reset()
+		return r.text.Write(fmt.Sprintf("%5.1f kt", shown), text.WriteCellOpts(cell.FgColor(cell.ColorYellow)))
+	}
+	return r.segments.Write([]*segmentdisplay.TextChunk{
+		segmentdisplay.NewChunk(fmt.Sprintf("%06.1f", shown)),
+	})
+}
+
+// 低帯域モードのタービン回転数 (rpmMeterDonut の代わり)
+// 描画のパスに渡す関数を返す
+func rpmMeterText(t *text.Text) func(p *Player) {
+	var steady steadyReading
+	return func(p *Player) {
+		rpm := steady.hold(math.Max(math.Min(float64(p.Turbine.Actual), 200.0), 0), rpmDeadband)
+		color := cell.ColorYellow
+		if rpm >= 140 {
+			color = cell.ColorRed
+		}
+		t.Reset()
+		if err := t.Write(fmt.Sprintf("%3.0f rpm\n", rpm), text.WriteCellOpts(cell.FgColor(color))); err != nil {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/mum4k/termdash/cell"
+)
+
+// 補機ごとの雑音
+//
+// 自艦の放射雑音 (noise.go) を音源ごとに分けて Machinery パネルに出す。
+// 補機は 1 つずつ止めて静かにできるが、止めているあいだは艦の働きが落ちる。
+//   - 冷却材ポンプ: 原子炉が自然循環になり、回転数を上げると炉心が熱くなる (70 rpm ほどで警報、90 rpm を越えるとスクラム)
+//   - CO2 スクラバー: 二酸化炭素が scrubberlessCO2Factor 倍の速さで溜まる
+//   - 換気ファン: 乗員が早く疲れる
+//   - トリムポンプ: トリムを変えられず、ホバリングもできない
+//   - 調理室: 乗員の士気が少しずつ下がる
+//
+// パネルにフォーカスして上下で補機を選び、Enter で止める・動かす。機関の停止 (MachinerySecured) はこれとは別に全部を止める。
+
+// 止められる補機
+type equipment int
+
+const (
+	equipCoolantPumps equipment = iota
+	equipScrubbers
+	equipVentilation
+	equipTrimPumps
+	equipGalley
+	equipmentCount
+)
+
+var equipmentNames = [equipmentCount]string{"Coolant pumps", "CO2 scrubbers", "Ventilation fans", "Trim pumps", "Galley"}
+
+// セーブデータに書く名前
+var equipmentKeys = [equipmentCount]string{"coolant-pumps", "scrubbers", "ventilation", "trim-pumps", "galley"}
+
+// 動いているときの雑音 (dB)。止められない補機の雑音 (hotelNoise) と合わせて補機類の定常雑音になる
+var equipmentNoise = [equipmentCount]float64{6, 4, 4, 3, 3}
+
+const (
+	// 止められない補機 (発電機・油圧・電子機器の冷却など) の雑音 (dB)
+	hotelNoise = 20.0
+	// スクラバーを止めているときの二酸化炭素の溜まる速さ (倍)
+	scrubberlessCO2Factor = 3.0
+	// 換気ファンを止めているときに 1 分で増える疲労 (%)
+	ventilationFatigueRate = 0.5
+	// 調理室を止めているときに 1 分で下がる士気
+	galleyMoraleLoss = 0.3
+)
+
+func (e equipment) String() string { return equipmentNames[e] }
+
+// 命令の値 (1 から) の補機
+func equipmentValue(v float64) (equipment, bool) {
+	e := equipment(v) - 1
+	return e, v == math.Trunc(v) && e >= 0 && e < equipmentCount
+}
+
+type engineering struct {
+	events *eventLog
+	crew   *crewRoster
+
+	mu sync.Mutex
+	// パネルで選んでいる補機
+	selected equipment
+}
+
+func newEngineering(events *eventLog, crew *crewRoster) *engineering {
+	return &engineering{events: events, crew: crew}
+}
+
+// 補機を止める・動かす (orderSecureEquipment と orderRestartEquipment の処理)
+func (g *engineering) secure(p *Player, o order) error {
+	e, ok := equipmentValue(o.Value)
+	if !ok {
+		return fmt.Errorf("unknown equipment %v", o.Value)
+	}
+	secure := o.Kind == orderSecureEquipment
+	if p.equipmentSecured[e] == secure {
+		if secure {
+			return orderRefusedError{"already secured"}
+		}
+		return orderRefusedError{"already running"}
+	}
+	p.setEquipment(e, secure)
+	if secure && e == equipTrimPumps && p.HoverEnabled {
+		p.HoverEnabled = false
+		g.events.add(cell.ColorYellow, "[ENGINEERING] Hover off: trim pumps secured.")
+	}
+	return nil
+}
+
+// 補機の状態を変える。冷却材ポンプは原子炉の流量に響く
+func (p *Player) setEquipment(e equipment, secured bool) {
+	p.equipmentSecured[e] = secured
+	if e == equipCoolantPumps {
+		p.NaturalCirculation = secured
+	}
+}
+
+// 止めている補機の働きの低下を dt 秒分だけ進める (シミュレーション時間で進める)
+func (g *engineering) step(p *Player, dt float64) {
+	if p.equipmentSecured[equipVentilation] {
+		p.crewFatigue = math.Min(p.crewFatigue+ventilationFatigueRate*dt/60, 100)
+	}
+	if p.equipmentSecured[equipGalley] {
+		g.crew.lowerMorale(galleyMoraleLoss * dt / 60)
+	}
+}
+
+// パネルの選択を動かす
+func (g *engineering) move(dir int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.selected = (g.selected + equipment(dir) + equipmentCount) % equipmentCount
+}
+
+// パネルで選んでいる補機を止める・動かす命令
+func (g *engineering) toggle(p *Player) order {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if p.equipmentSecured[g.selected] {
+		return order{Kind: orderRestartEquipment, Value: float64(g.selected + 1)}
+	}
+	return order{Kind: orderSecureEquipment, Value: float64(g.selected + 1)}
+}
+
+// 合計と補機でない音源の行に続けて、補機ごとの行を出す
+// 例:
+//
+//	Total 47 dB  prop 12 flow 2 hotel 20
+//	> Coolant pumps     6 dB
+//	  CO2 scrubbers     SECURED
+func machineryPanel(p *Player, g *engineering) panelText {
+	g.mu.Lock()
+	selected := g.selected
+	g.mu.Unlock()
+
+	var out panelText
+	write := func(line string, color cell.Color) {
+		out.write(line+"\n", color)
+	}
+	total := fmt.Sprintf("Total %.0f dB ", p.noiseLevel())
+	for _, s := range p.noiseSources() {
+		if s.equipment < 0 {
+			total += fmt.Sprintf(" %s %.0f", s.name, s.level)
+		}
+	}
+	write(total, cell.ColorCyan)
+	for e := equipment(0); e < equipmentCount; e++ {
+		mark := "  "
+		if e == selected {
+			mark = "> "
+		}
+		switch {
+		case p.MachinerySecured:
+			write(fmt.Sprintf("%s%-17s OFF", mark, e), cell.ColorGreen)
+		case p.equipmentSecured[e]:
+			write(fmt.Sprintf("%s%-17s SECURED", mark, e), cell.ColorYellow)
+		default:
+			write(fmt.Sprintf("%s%-17s %.0f dB", mark, e, equipmentNoise[e]), cell.ColorDefault)
+		}
+	}
+	return out
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 音源ごとの雑音が出る。停止していれば推進器と流体雑音はなく、補機だけが鳴る
+func TestMachinery(t *testing.T) {
+	g := startGame(t)
+	g.advance(2 * time.Second)
+	g.expect("Total 40 dB  prop 0 flow 0 hotel 20")
+	g.expect("> Coolant pumps     6 dB")
+	// パネルにフォーカスして Enter で選んだ補機を止めると静かになる
+	g.key("tab")
+	g.key("tab")
+	g.key("tab")
+	g.key("tab")
+	g.key("tab")
+	g.key("tab")
+	g.key("tab")
+	g.key("tab")
+	g.key("tab")
+	g.key("tab")
+	g.expect("> Machinery Noise <")
+	g.key("down")
+	g.key("enter")
+	g.advance(time.Second)
+	g.expect("[ORDER] Secure CO2 scrubbers")
+	g.expect("CO2 scrubbers     SECURED")
+	g.expect("Total 36 dB")
+	// もう一度 Enter で動かす
+	g.key("enter")
+	g.advance(time.Second)
+	g.expect("[ORDER] Restart CO2 scrubbers")
+	g.expect("Total 40 dB")
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+)
+
+// マクロを保存するファイル名
+const macrosFileName = "macros.json"
+
+// マクロ再生時の命令の間隔
+const macroOrderInterval = 1 * time.Second
+
+// 記録した命令の並び
+// 名前は macros.json を編集して付け直せる
+type macro struct {
+	Name   string  `json:"name"`
+	Key    string  `json:"key"`
+	Orders []order `json:"orders"`
+}
+
+type macroState int
+
+const (
+	macroIdle macroState = iota
+	// 命令を記録中
+	macroRecording
+	// 記録を終え、割り当てるキーを待っている
+	macroBinding
+)
+
+// 命令を記録してキーに割り当て、キーを押すと命令系統を通して再生する
+//
+//	M        記録開始 / 記録終了
+//	1 ~ 9    記録終了後は割り当て先、それ以外は再生
+//	Esc      記録を破棄
+type macroRecorder struct {
+	orders *orderSystem
+	events *eventLog
+	path   string
+
+	mu      sync.Mutex
+	state   macroState
+	current []order
+	macros  map[string]macro
+}
+
+func newMacroRecorder(orders *orderSystem, events *eventLog, path string) (*macroRecorder, error) {
+	m := &macroRecorder{
+		orders: orders,
+		events: events,
+		path:   path,
+		macros: map[string]macro{},
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		var list []macro
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, err
+		}
+		for _, mc := range list {
+			m.macros[mc.Key] = mc
+		}
+	}
+	orders.subscribe(m.record)
+	return m, nil
+}
+
+func (m *macroRecorder) record(o order) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.state == macroRecording {
+		m.current = append(m.current, o)
+	}
+}
+
+func (m *macroRecorder) toggleRecording() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch m.state {
+	case macroIdle:
+		m.state = macroRecording
+		m.current = nil
+		m.events.add(cell.ColorMagenta, "[MACRO] Recording. Press M again to finish.")
+	case macroRecording:
+		if len(m.current) == 0 {
+			m.state = macroIdle
+			m.events.add(cell.ColorMagenta, "[MACRO] Nothing recorded.")
+			return
+		}
+		m.state = macroBinding
+		m.events.add(cell.ColorMagenta, "[MACRO] %d orders recorded. Press 1-9 to bind, Esc to discard.", len(m.current))
+	}
+}
+
+func (m *macroRecorder) discard() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.state == macroIdle {
+		return
+	}
+	m.state = macroIdle
+	m.current = nil
+	m.events.add(cell.ColorMagenta, "[MACRO] Discarded.")
+}
+
+// 数字キーが押されたときの処理
+// 割り当て待ちなら記録したマクロを割り当て、そうでなければ再生する
+func (m *macroRecorder) press(ctx context.Context, guard *crashGuard, key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch m.state {
+	case macroBinding:
+		name := "Macro " + key
+		if old, ok := m.macros[key]; ok {
+			name = old.Name
+		}
+		m.macros[key] = macro{Name: name, Key: key, Orders: m.current}
+		m.state = macroIdle
+		m.current = nil
+		if err := m.save(); err != nil {
+			panic(err)
+		}
+		m.events.add(cell.ColorMagenta, "[MACRO] Bound %q to key %s.", name, key)
+	case macroIdle:
+		mc, ok := m.macros[key]
+		if !ok {
+			return
+		}
+		m.events.add(cell.ColorMagenta, "[MACRO] Executing %q.", mc.Name)
+		guard.goSafe(func() {
+			if err := m.orders.issueAll(mc.Orders, macroOrderInterval, ctx.Done()); err != nil {
+				panic(err)
+			}
+		})
+	}
+}
+
+// m.mu を保持した状態で呼ぶ
+func (m *macroRecorder) save() error {
+	list := make([]macro, 0, len(m.macros))
+	for _, mc := range m.macros {
+		list = append(list, mc)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(m.path, data, 0644)
+}
@@ -0,0 +1,16 @@
+package main
+
+import "testing"
+
+// 何も記録しなかったマクロは破棄される
+func TestMacro(t *testing.T) {
+	g := startGame(t)
+	g.key("m")
+	g.expect("[MACRO] Recording.")
+	g.key("m")
+	g.expect("[MACRO] Nothing recorded.")
+
+	// 割り当てのない数字キーは何もしない
+	g.key("5")
+	g.expectNot("Executing")
+}
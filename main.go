@@ -2,9 +2,13 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math"
-	"math/rand"
+	"os"
+	"path/filepath"
 	"strconv"
 	"time"
 
@@ -12,307 +16,737 @@ import (
 	"github.com/mum4k/termdash/align"
 	"github.com/mum4k/termdash/cell"
 	"github.com/mum4k/termdash/container"
+	"github.com/mum4k/termdash/keyboard"
 	"github.com/mum4k/termdash/linestyle"
-	"github.com/mum4k/termdash/terminal/termbox"
 	"github.com/mum4k/termdash/terminal/terminalapi"
-	"github.com/mum4k/termdash/widgets/button"
+	"github.com/mum4k/termdash/widgetapi"
 	"github.com/mum4k/termdash/widgets/donut"
 	"github.com/mum4k/termdash/widgets/gauge"
-	"github.com/mum4k/termdash/widgets/segmentdisplay"
 	"github.com/mum4k/termdash/widgets/text"
+	"github.com/rs0604/explorergame/missions"
+	"github.com/rs0604/explorergame/sim"
+	"github.com/rs0604/explorergame/world"
 )
 
-// 3次元の座標データ
-type Point3D struct {
-	x float64
-	y float64
-	z float64
-}
-
 // プレイヤーデータ
+// 艦の物理的な状態は sim.Player が持ち、ここには乗員や命令系統に関わる状態を置く
 type Player struct {
-	// 現在位置
-	position Point3D
+	sim.Player
 
-	// タービン回転数： 0 ~ 200
-	turbineRpmSettingValue float64
+	// 即応態勢と乗員の疲労: 0.0 ~ 100.0
+	readiness   readiness
+	crewFatigue float64
+	// 配置に就いている乗員。nil なら標準の腕前とみなす
+	crew *crewRoster
 
-	turbineRpmActualValue float64
+	// 総員退艦したか。以後は命令を受け付けない
+	abandoned bool
+	// 哨戒が終わったか (gameover.go)。以後は命令を受け付けない
+	gameOver bool
 
-	// 現在の速度
-	velocity float64
+	// 網切りをしているか (harbor.go)
+	cuttingNet bool
+	// 艦内の空気の酸素と二酸化炭素 (%)、シュノーケルを揚げているか (air.go)
+	oxygen  float64
+	co2     float64
+	snorkel bool
 
-	// 加速度
-	acceleration float64
+	// 針路 (sim.Player.Course) を命令したか
+	courseOrdered bool
+	// 自動操舵 (autopilot.go) の針路保持と速力保持。保つ速力は sim.Player.Speed
+	headingHold bool
+	speedHold   bool
+	// 航海図の変針点 (route.go) に沿って進んでいるか
+	followRoute bool
 
-	// 舵の角度 -35 ~ 35
-	rudderAngle float64
+	// 止めている補機 (machinery.go)
+	equipmentSecured [equipmentCount]bool
+}
 
-	// 船が向いている方角
-	direction float64
+func writeLines(p *Player, t *text.Text) {
+	var message = ""
+	if p.Velocity < 1.0 {
+		message = "Stopped." + strconv.FormatFloat(p.Velocity, 'f', 4, 64)
+	} else if p.Velocity < 10.0 {
+		message = "Nearly Stopped." + strconv.FormatFloat(p.Velocity, 'f', 4, 64)
+	} else if p.Velocity < 50.0 {
+		message = "Moving forward at low speed." + strconv.FormatFloat(p.Velocity, 'f', 4, 64)
+	} else if p.Velocity < 100.0 {
+		message = "Moving forward." + strconv.FormatFloat(p.Velocity, 'f', 4, 64)
+	} else if p.Velocity < 150.0 {
+		message = "Moving forward at high speed." + strconv.FormatFloat(p.Velocity, 'f', 4, 64)
+	} else {
+		message = "Full speed forward."
+	}
+	if err := t.Write(fmt.Sprintf("%s\n", message)); err != nil {
+		panic(err)
+	}
+}
 
-	// 転回の勢い
-	directionAcceleration float64
+// タービン回転数設定値ゲージ
+func rpmSettingGauge(p *Player, g *gauge.Gauge) {
+	displayValue := int(math.Max(math.Min(float64(p.Turbine.Ordered), 200.0), 0))
+	if err := g.Absolute(displayValue, 200, gauge.TextLabel(p.Turbine.Label("%.0f"))); err != nil {
+		panic(err)
+	}
+}
 
-	// 浮力： 0.0 ~ 100.0
-	buoyancy float64
+// タービン回転数ゲージ
+func rpmMeterDonut(p *Player, d *donut.Donut) {
+	displayValue := math.Max(math.Min(float64(p.Turbine.Actual), 200.0), 0)
 
-	// 浮力によって生じる加速度
-	buoyancyAcceleration float64
+	if displayValue < 140 {
+		if err := d.Absolute(int(displayValue), 200, donut.CellOpts(cell.FgColor(cell.ColorYellow))); err != nil {
+			panic(err)
+		}
+	} else {
+		if err := d.Absolute(int(displayValue), 200, donut.CellOpts(cell.FgColor(cell.ColorRed))); err != nil {
+			panic(err)
+		}
+	}
 }
 
-var debug bool = true
-
-func debugLog(message string) {
-	if debug {
-		fmt.Println(message)
+// 舵の角度
+// ゲージは実際の舵角 (左いっぱいが 0、右いっぱいが満タン) で、命令値と並べた数値と艦首方位をラベルに出す
+// 描画のパスに渡す関数を返す
+func rudderAngleGauge(g *gauge.Gauge) func(p *Player) {
+	// 低帯域モードでは舵角と艦首方位の小さな揺れを表示しない
+	var rudder, heading steadyReading
+	return func(p *Player) {
+		shown := p.Rudder
+		shown.Actual = rudder.hold(p.Rudder.Actual, render.deadband(rudderDeadband))
+		displayValue := int(math.Max(math.Min(float64(shown.Actual+35), 70.0), 0))
+		label := fmt.Sprintf("%s  HDG %03.0f", shown.Label("%+.1f°"), heading.hold(p.Direction, render.deadband(rudderDeadband)))
+		if err := g.Absolute(displayValue, 70, gauge.TextLabel(label)); err != nil {
+			panic(err)
+		}
 	}
 }
 
-func writeLines(ctx context.Context, p *Player, t *text.Text, delay time.Duration) {
-	var message = ""
-	if p.velocity < 1.0 {
-		message = "Stopped." + strconv.FormatFloat(p.velocity, 'f', 4, 64)
-	} else if p.velocity < 10.0 {
-		message = "Nearly Stopped." + strconv.FormatFloat(p.velocity, 'f', 4, 64)
-	} else if p.velocity < 50.0 {
-		message = "Moving forward at low speed." + strconv.FormatFloat(p.velocity, 'f', 4, 64)
-	} else if p.velocity < 100.0 {
-		message = "Moving forward." + strconv.FormatFloat(p.velocity, 'f', 4, 64)
-	} else if p.velocity < 150.0 {
-		message = "Moving forward at high speed." + strconv.FormatFloat(p.velocity, 'f', 4, 64)
-	} else {
-		message = "Full speed forward."
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate-scenario" {
+		os.Exit(runValidate(os.Args[2:], os.Stdout))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "broadcast-keygen" {
+		os.Exit(runBroadcastKeygen(os.Args[2:], os.Stdout))
 	}
-	ticker := time.NewTicker(delay)
-	defer ticker.Stop()
+	if len(os.Args) > 1 && os.Args[1] == "sign-broadcast" {
+		os.Exit(runSignBroadcast(os.Args[2:], os.Stdout))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "debrief" {
+		os.Exit(runDebrief(os.Args[2:], os.Stdout))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "journal" {
+		os.Exit(runJournal(os.Args[2:], os.Stdout))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "connect" {
+		os.Exit(runConnect(os.Args[2:], os.Stdout))
+	}
+	// server はヘッドレスで配置を待ち受ける。ほかのオプションはそのまま使う
+	serverMode := serverSubcommand()
 
-	for {
-		select {
-		case <-ticker.C:
-			if err := t.Write(fmt.Sprintf("%s\n", message)); err != nil {
-				panic(err)
-			}
-		case <-ctx.Done():
-			return
+	webAddr := flag.String("web", "", "serve a browser mirror of the screen on this address (e.g. :8080, bound to 127.0.0.1 unless -web-public)")
+	webPublic := flag.Bool("web-public", false, "let -web listen on all interfaces so other machines can connect")
+	headless := flag.Bool("headless", false, "run without a native terminal; the screen is only visible through -web")
+	instructorKey := flag.String("instructor", "", "open the instructor station at /instructor on the -web server, protected by this key")
+	recordPath := flag.String("record", "", "record the session to this file in asciinema v2 (.cast) format")
+	scenarioPath := flag.String("scenario", "", "load objectives and triggers from this scenario file (JSON)")
+	missionPath := flag.String("mission", "", "load mission objectives from this file (JSON) and show them in the Objectives panel")
+	drill := flag.Bool("drill", false, "run the torpedo evasion drill")
+	weaponsRangeMode := flag.Bool("range", false, "practice on the weapons test range with unlimited torpedoes and shot telemetry")
+	daily := flag.Bool("daily", false, "play today's featured scenario from the fleet broadcast")
+	seed := flag.Int64("seed", 0, "seed for the random number generators (0: use the current time)")
+	lowBandwidth := flag.Bool("low-bandwidth", false, "redraw less often with coarse characters, for slow remote terminals")
+	debugMode := flag.Bool("debug", false, "write debug messages to the log and show them in the event log")
+	logLevel := flag.String("log-level", "info", "least severe messages written to the log: debug, info, warn or error")
+	recordReplay := flag.String("record-replay", "", "record inputs and random draws of every tick to this replay file")
+	replayPath := flag.String("replay", "", "re-run the session recorded in this replay file")
+	soakDuration := flag.Duration("soak", 0, "run the simulation headlessly at maximum speed for this much simulated time with the demo bot at the helm and report invariant violations")
+	telemetryPath := flag.String("telemetry", "", "write speed, rpm, depth, heading, position and fuel of every tick to this file (CSV if it ends in .csv, otherwise JSON Lines)")
+	fullPhysics := flag.Bool("fullphysics", false, "also simulate speed lost in turns and hull compression at depth")
+	backend := flag.String("backend", backendTermbox, "terminal backend: termbox or tcell")
+	flag.Parse()
+	// リプレイは記録したときのオプションと乱数の種で起動し直す (replay.go)
+	var rp *replayPlayer
+	if *replayPath != "" {
+		var err error
+		if rp, err = loadReplay(*replayPath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
 		}
+		if err := flag.CommandLine.Parse(rp.header.Args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		*seed = rp.header.Seed
+	}
+	level, err := parseLogLevel(*logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *debugMode {
+		level = levelDebug
+	}
+	logs.level = level
+	code := run(gameOptions{
+		server:           serverMode,
+		webAddr:          *webAddr,
+		webPublic:        *webPublic,
+		headless:         *headless,
+		instructorKey:    *instructorKey,
+		recordPath:       *recordPath,
+		scenarioPath:     *scenarioPath,
+		missionPath:      *missionPath,
+		drill:            *drill,
+		weaponsRangeMode: *weaponsRangeMode,
+		daily:            *daily,
+		seed:             *seed,
+		lowBandwidth:     *lowBandwidth,
+		debugMode:        *debugMode,
+		recordReplay:     *recordReplay,
+		replay:           rp,
+		soakDuration:     *soakDuration,
+		telemetryPath:    *telemetryPath,
+		fullPhysics:      *fullPhysics,
+		backend:          *backend,
+		title:            true,
+	})
+	if code != 0 {
+		os.Exit(code)
 	}
 }
 
-func updateTick(ctx context.Context, p *Player, display *segmentdisplay.SegmentDisplay, delay time.Duration) {
-	ticker := time.NewTicker(delay)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ticker.C:
-
-			// 速度の更新 --------------------------------------------------------------------------------
-			// 回転数の計算
-			p.turbineRpmActualValue += (p.turbineRpmSettingValue - p.turbineRpmActualValue) / ((p.turbineRpmActualValue + 1) * 5)
-			p.turbineRpmActualValue *= 0.998
-			p.turbineRpmActualValue += p.turbineRpmActualValue * rand.Float64() * 0.004
-
-			// 加速度の計算
-			p.acceleration = float64(p.turbineRpmActualValue / 10.0)
-
-			// 速度の計算
-			p.velocity += p.acceleration / 10
-			p.velocity *= 0.99 + rand.Float64()*0.003 // 減速係数
-			if err := display.Write([]*segmentdisplay.TextChunk{
-				segmentdisplay.NewChunk(fmt.Sprintf("%06.1f", p.velocity)),
-			}); err != nil {
-				panic(err)
-			}
+// 起動オプション。main がフラグから作る
+// テスト (game_test.go) は term・clock・drive を渡し、画面と時計を差し替えて run を呼ぶ
+type gameOptions struct {
+	// explorergame server で起動した
+	server           bool
+	webAddr          string
+	webPublic        bool
+	headless         bool
+	instructorKey    string
+	recordPath       string
+	scenarioPath     string
+	missionPath      string
+	drill            bool
+	weaponsRangeMode bool
+	daily            bool
+	seed             int64
+	lowBandwidth     bool
+	debugMode        bool
+	recordReplay     string
+	// 再生するリプレイ (-replay)。nil なら再生しない
+	replay        *replayPlayer
+	soakDuration  time.Duration
+	telemetryPath string
+	fullPhysics   bool
+	backend       string
 
-		case <-ctx.Done():
-			return
+	// 哨戒の前にタイトル画面を出す (main だけが立てる)
+	title bool
+
+	// 画面と入力に使う端末と、手で進める時計。term を渡すと一時ディレクトリで動かし、ユーザーのデータに触れない
+	term  terminalapi.Terminal
+	clock *fakeClock
+	// 画面ができたあとゴルーチンで呼ぶ。返ったらゲームを終える
+	drive func(ctx context.Context)
+}
+
+// ゲームを動かし、終了コードを返す
+func run(o gameOptions) int {
+	rp := o.replay
+	if o.server {
+		o.headless = true
+		if o.webAddr == "" {
+			o.webAddr = defaultServerAddr
 		}
 	}
-}
+	render.lowBandwidth = o.lowBandwidth
 
-// タービン回転数設定値ゲージ
-func rpmSettingGauge(ctx context.Context, p *Player, g *gauge.Gauge, delay time.Duration) {
-	ticker := time.NewTicker(delay)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-ticker.C:
-			displayValue := int(math.Max(math.Min(float64(p.turbineRpmSettingValue), 200.0), 0))
-			if err := g.Absolute(displayValue, 200); err != nil {
-				panic(err)
-			}
-		case <-ctx.Done():
-			return
+	// プレイヤーの状態初期化
+
+	player := Player{Player: sim.NewPlayer(), oxygen: atmosphereO2, co2: atmosphereCO2}
+
+	// テスト (game_test.go) は端末と時計を渡して動かす
+	fc := o.clock
+	if fc != nil {
+		clock = fc
+	}
+	// 耐久試験 (soak.go)
+	var soak *soakTest
+	if o.soakDuration > 0 {
+		soak = newSoakTest(o.soakDuration)
+		fc = newFakeClock(time.Now())
+		clock = fc
+	}
+	// テストと耐久試験は端末を使わず、偽の時計で動かす
+	unattended := o.term != nil || soak != nil
+	// リプレイの記録と再生では時計を刻みで進める
+	var pump *fakeClock
+	if o.recordReplay != "" || rp != nil {
+		if unattended || o.recordReplay != "" && rp != nil {
+			fmt.Fprintln(os.Stderr, "-record-replay, -replay and -soak cannot be used together")
+			return 2
+		}
+		start := time.Now()
+		if rp != nil {
+			start = rp.header.Start
 		}
+		pump = newFakeClock(start)
+		clock = pump
 	}
-}
 
-// タービン回転数ゲージ
-func rpmMeterDonut(ctx context.Context, p *Player, d *donut.Donut, delay time.Duration) {
-	ticker := time.NewTicker(delay)
-	defer ticker.Stop()
+	// シナリオ
+	var scenarioCfg *scenarioConfig
+	if o.scenarioPath != "" {
+		cfg, err := loadScenario(o.scenarioPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		scenarioCfg = &cfg
+	}
+	// 任務
+	var mission *missions.Mission
+	if o.missionPath != "" {
+		m, err := missions.Load(o.missionPath, mapExtent)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		mission = &m
+	}
 
-	for {
-		select {
-		case <-ticker.C:
-			displayValue := math.Max(math.Min(float64(p.turbineRpmActualValue), 200.0), 0)
+	// 前回クラッシュしていればオートセーブからの再開を提案する
+	dir := dataDir()
+	if unattended {
+		// テストと耐久試験ではユーザーのセーブデータやマクロに触れない
+		tmp, err := ioutil.TempDir("", "explorergame-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(tmp)
+		dir = tmp
+	}
+	savePath := filepath.Join(dir, autosaveFileName)
+	if err := logs.open(filepath.Join(dir, logFileName)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer logs.close()
+	logs.debugf("main", "start %v", os.Args[1:])
 
-			if displayValue < 140 {
-				if err := d.Absolute(int(displayValue), 200, donut.CellOpts(cell.FgColor(cell.ColorYellow))); err != nil {
-					panic(err)
-				}
-			} else {
-				if err := d.Absolute(int(displayValue), 200, donut.CellOpts(cell.FgColor(cell.ColorRed))); err != nil {
-					panic(err)
-				}
-			}
+	// フリート配信。テストと耐久試験では取りに行かない
+	var broadcast *fleetBroadcast
+	var broadcastCached bool
+	var broadcastErr error
+	if !unattended {
+		broadcast, broadcastCached, broadcastErr = loadBroadcast(dir, time.Now())
+	}
+	if o.daily {
+		if broadcast == nil || broadcast.Scenario == nil {
+			fmt.Fprintln(os.Stderr, "-daily: no featured scenario in the fleet broadcast")
+			return 2
+		}
+		scenarioCfg = broadcast.Scenario
+	}
+	// 設定ファイル。難易度はフリート配信の上書きより先に反映する
+	config, err := loadGameConfig(filepath.Join(dir, configFileName))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	config.apply()
+	var rejectedOverrides []string
+	if broadcast != nil {
+		rejectedOverrides = broadcast.applyOverrides()
+	}
 
-		case <-ctx.Done():
-			return
+	// キー割り当て
+	bindings, err := loadKeyBindings(filepath.Join(dir, keyBindingsFileName), config.keys)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	// 警報の設定
+	alarmSettings, err := loadAlarmSettings(filepath.Join(dir, alarmsFileName))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	// 音の合図の設定
+	audioSettings, err := loadCueSettings(filepath.Join(dir, audioFileName))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err := checkDataFiles(dir); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	var resumed *saveData
+	if !unattended && pump == nil {
+		if resumed = offerResume(dir, savePath, os.Stdin, os.Stdout); resumed != nil {
+			resumed.apply(&player)
 		}
 	}
-}
 
-// 舵の角度
-func rudderAngleGauge(ctx context.Context, p *Player, g *gauge.Gauge, delay time.Duration) {
-	ticker := time.NewTicker(delay)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-ticker.C:
-			displayValue := int(math.Max(math.Min(float64(p.rudderAngle), 70.0), 0))
-			if err := g.Absolute(displayValue, 70); err != nil {
-				panic(err)
-			}
-		case <-ctx.Done():
-			return
+	if o.headless && o.webAddr == "" {
+		panic("-headless requires -web")
+	}
+	if o.instructorKey != "" && o.webAddr == "" {
+		panic("-instructor requires -web")
+	}
+	if o.webAddr != "" {
+		addr, err := webListenAddr(o.webAddr, o.webPublic)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
 		}
+		o.webAddr = addr
+	}
+	if o.instructorKey != "" && pump != nil {
+		panic("-instructor cannot be used with -record-replay or -replay")
+	}
+	if o.drill && o.weaponsRangeMode {
+		panic("-drill and -range cannot be used together")
+	}
+	if o.backend != backendTermbox && o.backend != backendTcell {
+		panic(fmt.Sprintf("-backend must be %s or %s", backendTermbox, backendTcell))
 	}
-}
 
-func main() {
-	debugLog("main(): start")
-	// プレイヤーの状態初期化
+	rngs := newSeededRand(o.seed)
+	// リプレイの記録・再生。乱数の源とゲームループに差し込む
+	var rec *replayRecorder
+	var replay replayHook
+	if o.recordReplay != "" {
+		rec, err = newReplayRecorder(o.recordReplay, replayHeader{Version: replayVersion, Seed: rngs.seed, Start: clock.Now(), Args: replayArgs()})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		replay = rec
+	} else if rp != nil {
+		replay = rp
+	}
+	rngs.replay = replay
+	// テレメトリの書き出し (telemetry.go)
+	var telemetry *telemetryWriter
+	if o.telemetryPath != "" {
+		telemetry, err = newTelemetryWriter(o.telemetryPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	// ここから先は Player を state を通して読み書きする (state.go)
+	state := newPlayerState(&player)
+	guard := newCrashGuard(cancel, state, rngs.seed)
 
-	player := Player{
-		position:               Point3D{x: 0.0, y: 0.0, z: 0.0},
-		turbineRpmSettingValue: 0,
-		turbineRpmActualValue:  0,
-		velocity:               0.0,
-		acceleration:           0.0,
-		rudderAngle:            35.0,
-		direction:              0.0,
-		directionAcceleration:  0.0,
-		buoyancy:               50.0,
-		buoyancyAcceleration:   0.0,
+	var t terminalapi.Terminal
+	if o.term != nil {
+		t = o.term
+	} else if soak != nil {
+		t = newHeadlessTerminal()
+	} else if !o.headless {
+		nt, err := newNativeTerminal(o.backend)
+		if err != nil {
+			panic(err)
+		}
+		t = nt
 	}
 
-	t, err := termbox.New()
-	if err != nil {
-		panic(err)
+	// セッション録画
+	if o.recordPath != "" {
+		recorder, err := newCastTerminal(t, o.recordPath)
+		if err != nil {
+			if t != nil {
+				t.Close()
+			}
+			panic(err)
+		}
+		t = recorder
+	}
+
+	// ブラウザミラーと教官席
+	var instructor *instructorStation
+	if o.instructorKey != "" {
+		instructor = newInstructorStation(o.instructorKey)
+	}
+	var mirror *mirrorTerminal
+	if o.webAddr != "" {
+		mirror = newMirrorTerminal(t)
+		if err := serveWeb(ctx, o.webAddr, o.webPublic, mirror, instructor); err != nil {
+			if t != nil {
+				t.Close()
+			}
+			panic(err)
+		}
+		t = mirror
 	}
 	defer t.Close()
 
-	ctx, cancel := context.WithCancel(context.Background())
+	// どこでパニックしても端末を元に戻してからクラッシュダンプを残す
+	defer guard.finish(t, dir)
 
-	// segment display
-	display, err := segmentdisplay.New()
+	// 速力の表示
+	speed, err := newSpeedReadout()
 	if err != nil {
 		panic(err)
 	}
 
-	if err := display.Write([]*segmentdisplay.TextChunk{
-		segmentdisplay.NewChunk(fmt.Sprintf("%06.1f", player.velocity)),
-	}); err != nil {
+	if err := speed.show(player.Velocity); err != nil {
+		panic(err)
+	}
+
+	// 艦の状態 (燃料など)
+	statusText, err := text.New(text.WrapAtRunes())
+	if err != nil {
 		panic(err)
 	}
 
-	// 左下メッセージ
-	wrapped, err := text.New(text.WrapAtRunes())
+	// rolled
+	rolled, err := text.New(text.RollContent(), text.WrapAtWords())
 	if err != nil {
 		panic(err)
 	}
-	if err := wrapped.Write("Internal Pressure: 1024 mpa\n", text.WriteCellOpts(cell.FgColor(cell.ColorRed))); err != nil {
+	if err := rolled.Write("<< Rolls the content upwards if RollContent() option is provided. >>\n"); err != nil {
+		panic(err)
+	}
+
+	// 命令系統
+	debrief := newMissionRecorder(rngs.seed)
+	// 音の合図。ベルはネイティブ端末があるときだけ鳴らす
+	var bell io.Writer
+	if !unattended && !o.headless {
+		bell = os.Stdout
+	}
+	cues := newAudioCues(audioSettings, bell)
+	events := &eventLog{t: rolled, record: debrief.logEvent, listeners: []func(string){cues.cue, simRate.watch}, colors: config.eventColors}
+	cues.events = events
+	simRate.events = events
+	if o.debugMode {
+		logs.setMirror(events)
+	}
+	if rp != nil {
+		rp.events = events
+	}
+	if mirror != nil {
+		mirror.sessions.setEventLog(events)
+	}
+	guard.goSafe(func() { cues.run(ctx) })
+	events.add(cell.ColorDefault, "[SIM] Random seed %d", rngs.seed)
+	if o.fullPhysics {
+		events.add(cell.ColorDefault, "[SIM] Full physics: turn drag and hull compression enabled.")
+	}
+	if broadcastErr != nil {
+		if broadcastCached {
+			events.add(cell.ColorYellow, "[FLEET] Broadcast unavailable: %v. Using the last broadcast.", broadcastErr)
+		} else {
+			events.add(cell.ColorYellow, "[FLEET] Broadcast unavailable: %v. Playing offline.", broadcastErr)
+		}
+	}
+	if broadcast != nil {
+		if broadcast.MOTD != "" {
+			events.add(cell.ColorCyan, "[FLEET] %s", broadcast.MOTD)
+		}
+		for _, r := range rejectedOverrides {
+			events.add(cell.ColorYellow, "[FLEET] Override ignored: %s", r)
+		}
+		if broadcast.Scenario != nil && !o.daily {
+			events.add(cell.ColorCyan, "[FLEET] Today's scenario: %s. Launch with -daily to play it.", broadcast.Scenario.Name)
+		}
+	}
+	orders := newOrderSystem(&player, events)
+	macros, err := newMacroRecorder(orders, events, filepath.Join(dir, macrosFileName))
+	if err != nil {
 		panic(err)
 	}
-	if err := wrapped.Write("External Pressure: 42821 mpa\n", text.WriteCellOpts(cell.FgColor(cell.ColorYellow))); err != nil {
+
+	// 海洋環境と音の伝わり方
+	env := defaultEnvironment()
+	env.features = generateOceanFeatures(rngs.next())
+	// 海底の地形と測量。測量した海があれば同じ海底に出て、測量を引き継ぐ
+	surveyData, err := newSurvey(events, filepath.Join(dir, surveyFileName))
+	if err != nil {
 		panic(err)
 	}
-	if err := wrapped.Write("\nReactor Temp: 3081 K\n", text.WriteCellOpts(cell.FgColor(cell.ColorRed))); err != nil {
+	floor := world.Generate(surveyData.terrainSeed(rngs.next().Int63()))
+	terrain = floor
+	env.features = append(env.features, seaCurrent{floor})
+	events.add(cell.ColorDefault, "[SIM] Terrain seed %d", floor.Seed())
+	propagationText, err := text.New()
+	if err != nil {
 		panic(err)
 	}
-	if err := wrapped.Write("Fuel: 102241\n", text.WriteCellOpts(cell.FgColor(cell.ColorBlue))); err != nil {
+	presetText, err := text.New()
+	if err != nil {
 		panic(err)
 	}
-	if err := wrapped.Write("Turbine rpm: 328\n", text.WriteCellOpts(cell.FgColor(cell.ColorBlue))); err != nil {
+	compassText, err := text.New()
+	if err != nil {
 		panic(err)
 	}
-
-	if err := wrapped.Write("\nCurrent Direction: 248° [SWW]\n", text.WriteCellOpts(cell.FgColor(cell.ColorCyan))); err != nil {
+	depthText, err := text.New()
+	if err != nil {
 		panic(err)
 	}
-	if err := wrapped.Write("Altitude: -12832 ft. \n", text.WriteCellOpts(cell.FgColor(cell.ColorCyan))); err != nil {
+	navText, err := text.New()
+	if err != nil {
 		panic(err)
 	}
 
-	if err := wrapped.Write("\nIrradiated rader strength: 0\n", text.WriteCellOpts(cell.FgColor(cell.ColorRed))); err != nil {
+	// 商船の航路。過去に攻撃した海域は避ける
+	attacks, err := newAttackHistory(filepath.Join(dir, attacksFileName))
+	if err != nil {
 		panic(err)
 	}
-	if err := wrapped.Write("Sonar ping Effectiveness: 76%\n", text.WriteCellOpts(cell.FgColor(cell.ColorRed))); err != nil {
-		panic(err)
+	// 試射場には商船を出さない
+	lanes := defaultTrafficConfig()
+	if o.weaponsRangeMode {
+		lanes = trafficConfig{}
 	}
-	if err := wrapped.Write("Threat Level: Green\n", text.WriteCellOpts(cell.FgColor(cell.ColorGreen))); err != nil {
+	shipping := newTraffic(lanes, attacks.zones(), events, env, rngs.next())
+	surfaceText, err := text.New()
+	if err != nil {
 		panic(err)
 	}
-	if err := wrapped.Write("\n[Weapon] Torpedo: 11\n", text.WriteCellOpts(cell.FgColor(cell.ColorRed))); err != nil {
+	passiveText, err := text.New()
+	if err != nil {
 		panic(err)
 	}
-	if err := wrapped.Write("[Weapon] Surface-t-air Missile: 11\n", text.WriteCellOpts(cell.FgColor(cell.ColorRed))); err != nil {
+
+	// 海図の書き込みと変針点
+	marks := newChart(events)
+	waypoints := newRoute(events)
+	// 魚雷の発射前設定
+	room := newTorpedoRoom(events)
+	// 乗員の配置
+	crew := newCrewRoster(events)
+	player.crew = crew
+	if resumed != nil {
+		marks.restore(resumed.Chart)
+		waypoints.restore(resumed.Waypoints)
+		room.restore(resumed.Tubes)
+		if resumed.Torpedoes != nil {
+			room.restoreMagazine(*resumed.Torpedoes)
+		}
+		crew.restore(resumed.Crew)
+		crew.restoreCasualties(resumed.Casualties, resumed.CrewMorale)
+		crew.restoreSkills(resumed.CrewSkills)
+	}
+	crewText, err := text.New()
+	if err != nil {
 		panic(err)
 	}
-	if err := wrapped.Write("[Weapon] UAV: 3\n", text.WriteCellOpts(cell.FgColor(cell.ColorRed))); err != nil {
+	orders.handle(orderMarkHazard, func(order) error { return marks.markHazard(&player) })
+	chartText, err := text.New()
+	if err != nil {
 		panic(err)
 	}
 
-	// rolled
-	rolled, err := text.New(text.RollContent(), text.WrapAtWords())
+	// 探知の統合と航跡
+	tracks := newTrackManager(events)
+	ekelund := newEkelundRanging(events)
+	trackText, err := text.New()
 	if err != nil {
 		panic(err)
 	}
-	if err := rolled.Write("<< Rolls the content upwards if RollContent() option is provided. >>\n"); err != nil {
+	tmaText, err := text.New()
+	if err != nil {
 		panic(err)
 	}
 
-	// 速度関連
-	buttonTurbinePlus, err := button.New("+ 10", func() error {
-		// process
-		player.turbineRpmSettingValue += 10
-		if player.turbineRpmSettingValue > 200 {
-			player.turbineRpmSettingValue = 200
-		}
-		return display.Write([]*segmentdisplay.TextChunk{
-			segmentdisplay.NewChunk(fmt.Sprintf("%06.1f", player.velocity)),
+	// 武器やセンサーのタイマー (ゲームループで進める)
+	timers := &simTimers{}
+	// ゲームループと描画のパス (loop.go)
+	simWorld := sim.NewWorld(&player.Player, rngs.next())
+	simWorld.Current = env.currentAt
+	simWorld.Seabed = floor
+	simWorld.FullPhysics = o.fullPhysics
+	loop := newGameLoop(simWorld, timers, state, events, marks)
+	loop.replay = replay
+	// 哨戒の前のタイトル画面。リプレイの記録・再生、耐久試験、訓練、画面のないサーバーでは出さず、すぐに哨戒を始める
+	demo := newDemoMode(events, o.title && !o.drill && !o.headless && pump == nil && soak == nil)
+	loop.title = demo.step
+	screen := newRenderPass(state)
+	// マクロが自分のゴルーチンから出す命令も置き場の鍵を持って出す
+	orders.exec = func(o order) (err error) {
+		state.update(func(*Player) {
+			if rec != nil {
+				rec.order(o)
+			}
+			err = orders.issue(o)
 		})
-	})
+		return err
+	}
 
-	buttonTurbineMinus, err := button.New("- 10", func() error {
-		// process
-		player.turbineRpmSettingValue -= 10
-		if player.turbineRpmSettingValue < 0 {
-			player.turbineRpmSettingValue = 0
-		}
-		return display.Write([]*segmentdisplay.TextChunk{
-			segmentdisplay.NewChunk(fmt.Sprintf("%06.1f", player.velocity)),
+	// 音響ビーコン
+	beacons := newBeaconNet(events, env, shipping.ambientNoiseAt, defaultBeacons())
+	timers.add(func(now time.Duration, _ float64) { beacons.step(now) })
+	orders.handle(orderDeployBeacon, func(order) error { return beacons.deploy(&player) })
+	orders.handle(orderInterrogateBeacons, func(order) error { return beacons.interrogate(&player) })
+	beaconText, err := text.New()
+	if err != nil {
+		panic(err)
+	}
+
+	// キーボードで操作できるボタンとパネル
+	focus := newFocusRing()
+	// ボタンの命令。リプレイでは名前から出し直す
+	buttonOrders := map[string]func(p *Player) order{
+		"turbine-plus":  func(p *Player) order { return order{Kind: orderTurbineRpm, Value: p.Turbine.Ordered + 10} },
+		"turbine-minus": func(p *Player) order { return order{Kind: orderTurbineRpm, Value: p.Turbine.Ordered - 10} },
+		"rudder-left":   func(p *Player) order { return order{Kind: orderRudder, Value: p.Rudder.Ordered - 2.5} },
+		"rudder-right":  func(p *Player) order { return order{Kind: orderRudder, Value: p.Rudder.Ordered + 2.5} },
+		"hover":         func(p *Player) order { return order{Kind: orderHover, Value: boolValue(!p.HoverEnabled)} },
+	}
+	pressButton := func(name string) func() error {
+		return guard.wrap(func() (err error) {
+			// 再生中は記録した入力だけを与える
+			if rp != nil {
+				return nil
+			}
+			state.update(func(p *Player) {
+				if rec != nil {
+					rec.button(name)
+				}
+				err = orders.issue(buttonOrders[name](p))
+			})
+			return err
 		})
-	})
+	}
 
-	rpmMeter, err := donut.New(
-		donut.CellOpts(cell.FgColor(cell.ColorYellow)),
-		donut.HolePercent(50),
-		donut.ShowTextProgress(),
-		donut.Label("turbine rpm", cell.FgColor(cell.ColorYellow)),
-	)
+	// 速度関連
+	buttonTurbinePlus, err := focus.button("turbine-plus", "+ 10", pressButton("turbine-plus"))
+	if err != nil {
+		panic(err)
+	}
+
+	buttonTurbineMinus, err := focus.button("turbine-minus", "- 10", pressButton("turbine-minus"))
+	if err != nil {
+		panic(err)
+	}
+
+	// 回転計。低帯域モードでは点字のドーナツの代わりに文字で出す
+	var rpmMeter *donut.Donut
+	var rpmText *text.Text
+	var rpmWidget widgetapi.Widget
+	if render.lowBandwidth {
+		rpmText, err = text.New()
+		rpmWidget = rpmText
+	} else {
+		rpmMeter, err = donut.New(
+			donut.CellOpts(cell.FgColor(cell.ColorYellow)),
+			donut.HolePercent(50),
+			donut.ShowTextProgress(),
+			donut.Label("turbine rpm", cell.FgColor(cell.ColorYellow)),
+		)
+		rpmWidget = rpmMeter
+	}
 	if err != nil {
 		panic(err)
 	}
@@ -335,100 +769,939 @@ func main() {
 		gauge.BorderTitleAlign(align.HorizontalCenter),
 		gauge.HideTextProgress(),
 	)
+	if err != nil {
+		panic(err)
+	}
 
-	rudderLeftButtonObj, err := button.New("L", func() error {
-		settingValue := player.rudderAngle - 2.5
+	rudderLeftButtonObj, err := focus.button("rudder-left", "L", pressButton("rudder-left"))
+	if err != nil {
+		panic(err)
+	}
+	rudderRightButtonObj, err := focus.button("rudder-right", "R", pressButton("rudder-right"))
+	if err != nil {
+		panic(err)
+	}
 
-		player.rudderAngle = math.Max(math.Min(player.rudderAngle-2.5, 70), 0)
-	})
+	// ホバリング
+	hoverText, err := text.New()
+	if err != nil {
+		panic(err)
+	}
+	hoverButton, err := focus.button("hover", "HOVER", pressButton("hover"))
+	if err != nil {
+		panic(err)
+	}
 
-	go rpmMeterDonut(ctx, &player, rpmMeter, 100*time.Millisecond)
-	go rpmSettingGauge(ctx, &player, rpmSettingMeter, 250*time.Millisecond)
-	go updateTick(ctx, &player, display, 16*time.Millisecond)
-	go rudderAngleGauge(ctx, &player, rudderAngleGaugeObj, 16*time.Millisecond)
+	if render.lowBandwidth {
+		drawRPM := rpmMeterText(rpmText)
+		screen.add(render.panelDelay(100*time.Millisecond), func(f *frame) { drawRPM(&f.player) })
+	} else {
+		screen.add(100*time.Millisecond, func(f *frame) { rpmMeterDonut(&f.player, rpmMeter) })
+	}
+	screen.add(render.panelDelay(renderInterval), func(f *frame) {
+		if err := speed.show(f.player.Velocity); err != nil {
+			panic(err)
+		}
+	})
+	screen.add(render.panelDelay(250*time.Millisecond), func(f *frame) { rpmSettingGauge(&f.player, rpmSettingMeter) })
+	screen.add(render.panelDelay(100*time.Millisecond), func(f *frame) { compassPanel(&f.player, compassText) })
+	screen.add(render.panelDelay(renderInterval), func(f *frame) { depthGauge(&f.player, depthText) })
+	guard.goSafe(func() { loop.run(ctx) })
+	guard.goSafe(func() { screen.run(ctx) })
+	if mirror != nil {
+		// 遠くの配置が先読みに使う計器の値
+		guard.goSafe(func() {
+			mirror.streamInstruments(ctx, func() instrumentReading {
+				f := state.view()
+				return readInstruments(&f.player)
+			})
+		})
+	}
+	drawRudder := rudderAngleGauge(rudderAngleGaugeObj)
+	screen.add(render.panelDelay(renderInterval), func(f *frame) { drawRudder(&f.player) })
+	screen.add(render.panelDelay(250*time.Millisecond), func(f *frame) { depthControlText(&f.player, hoverText) })
+	screen.addText(render.panelDelay(250*time.Millisecond), beaconText, func(f *frame) panelText { return beaconPanel(f, beacons) })
+	loop.every(100*time.Millisecond, func(time.Time) { trafficTick(&player, shipping, 100*time.Millisecond) })
+	screen.addText(render.panelDelay(500*time.Millisecond), surfaceText, func(f *frame) panelText { return surfacePicturePanel(&f.player, shipping) })
+	sweepRand := rngs.next()
+	passive := newPassiveSonarArray()
+	loop.every(time.Second, func(time.Time) { sensorSweep(&player, env, shipping, tracks, passive, sweepRand) })
+	screen.addText(render.panelDelay(250*time.Millisecond), passiveText, func(*frame) panelText { return passiveSonarPanel(passive) })
+	pinger := newSonarPinger(events, env, shipping.ambientNoiseAt, shipping.vessels, tracks, rngs.next())
+	orders.handle(orderPing, func(order) error { return pinger.ping(&player) })
+	orders.handle(orderPingSector, func(o order) error { return pinger.setWidth(o.Value) })
+	timers.add(func(now time.Duration, _ float64) { pinger.step(now) })
+	// 敵の艦艇。魚雷回避訓練と試射場の海には出さない
+	patrols := floor.Patrols()
+	if o.drill || o.weaponsRangeMode {
+		patrols = nil
+	}
+	// 囮
+	decoys := newCountermeasures(events)
+	orders.handle(orderCountermeasure, func(order) error { return decoys.launchNoisemaker(&player) })
+	orders.handle(orderLaunchDecoy, func(order) error { return decoys.launchDecoy(&player) })
+	timers.add(decoys.step)
+	debrief.trackWeapons(decoys.weapons)
+	personality, _ := findPersonality("")
+	if scenarioCfg != nil {
+		personality, _ = findPersonality(scenarioCfg.EnemyPersonality)
+	}
+	fleet := newEnemyFleet(events, env, shipping, pinger, decoys.active, patrols, personality, rngs.next())
+	timers.add(func(now time.Duration, dt float64) { fleet.step(&player, now, dt) })
+	debrief.trackWeapons(fleet.weapons)
+	// 自艦の魚雷
+	// 交戦規則。評判は戦歴から求める
+	roe, err := newRulesOfEngagement(events, filepath.Join(dir, campaignFileName))
+	if err != nil {
+		panic(err)
+	}
+	firing := newFireControl(events, shipping, room, tracks, attacks, roe)
+	if o.weaponsRangeMode {
+		testRange := newWeaponsRange(events, shipping, player.Position)
+		room.setUnlimited()
+		firing.practice = testRange.report
+		timers.add(func(now time.Duration, _ float64) { testRange.step(now) })
+	}
+	orders.handle(orderPrepareTube, func(o order) error { return room.prepare(int(o.Value) - 1) })
+	orders.handle(orderLaunchTorpedo, func(o order) error { return firing.launch(&player, int(o.Value)-1) })
+	timers.add(func(_ time.Duration, dt float64) {
+		// 装填の手順は即応態勢と兵装員の腕前で速さが変わる
+		room.step(dt / player.reloadFactor())
+		firing.step(&player, dt)
+	})
+	timers.add(func(time.Duration, float64) { crew.apply(&player) })
+	timers.add(func(now time.Duration, dt float64) { crew.step(&player, now, dt) })
+	debrief.trackCrew(crew.debrief)
+	debrief.trackWeapons(firing.weapons)
+	datums := newDatumPlot(events, marks)
+	timers.add(func(now time.Duration, _ float64) { datums.advance(now) })
+	loop.every(time.Second, func(time.Time) { counterDetectionSweep(&player, env, shipping, datums, pinger) })
+	// 天候。海況は少しずつ移り変わり、浮上中の速力も落とす
+	wx := newWeather(events, env)
+	simWorld.WaveSpeedLimit = wx.speedLimit
+	timers.add(wx.step)
+	screen.addText(render.panelDelay(time.Second), statusText, func(f *frame) panelText {
+		return shipStatusPanel(f, shipping, datums, fleet, roe, wx)
+	})
+	orders.handle(orderSurvey, func(o order) error { return surveyData.setActive(o.Value != 0) })
+	timers.add(func(time.Duration, float64) { surveyData.sweep(player.Position, player.Direction) })
+	ground := newGroundWatch(events)
+	timers.add(func(time.Duration, float64) { ground.step(&player) })
+	alarms := newAlarmMonitor(events, alarmSettings)
+	timers.add(func(time.Duration, float64) { alarms.step(&player) })
+	hull := newHullCleaning(events, beacons.rendezvousPoints)
+	orders.handle(orderCleanHull, func(o order) error { return hull.setActive(&player, o.Value != 0) })
+	timers.add(func(_ time.Duration, dt float64) { hull.step(&player, dt) })
+	// 補給港の在庫は哨戒をまたいで残る
+	depots, err := newLogistics(filepath.Join(dir, portsFileName), events, shipping.vessels)
+	if err != nil {
+		panic(err)
+	}
+	orders.handle(orderResupply, func(order) error { return depots.resupply(&player, room) })
+	// 航跡・海図・補給港のパネルは状態の写しだけを読む
+	state.watch(tracks, marks, depots)
+	timers.add(func(now time.Duration, _ float64) { depots.step(now) })
+	// 沈船の調査。持ち帰った記録は日誌に残る
+	logbook, err := newJournal(filepath.Join(dir, journalFileName))
+	if err != nil {
+		panic(err)
+	}
+	rov := newWreckSurvey(events, logbook)
+	orders.handle(orderInspectWreck, func(o order) error { return rov.setActive(&player, o.Value != 0) })
+	timers.add(func(_ time.Duration, dt float64) {
+		if err := rov.step(&player, dt); err != nil {
+			panic(err)
+		}
+	})
+	// 損傷対処の訓練。うまく対処すれば乗員の腕前が上がる
+	drills := newTraining(events, crew, func() bool {
+		active, _ := datums.status(player.Position)
+		return fleet.incoming() == 0 && active == 0
+	})
+	orders.handle(orderTrainingDrill, func(o order) error { return drills.call(&player, o.Value != 0) })
+	timers.add(func(now time.Duration, dt float64) { drills.step(&player, now, dt) })
+	air := newLifeSupport(events)
+	orders.handle(orderSnorkel, func(o order) error { return air.setSnorkel(&player, o.Value != 0) })
+	timers.add(func(_ time.Duration, dt float64) { air.step(&player, dt) })
+	var harbors []harborConfig
+	var suspects []inspectionConfig
+	complete := func(string) {}
+	scenarioProgress := func() (int, int) { return 0, 0 }
+	if scenarioCfg != nil {
+		sc := newScenario(*scenarioCfg, events, env, shipping, marks, surveyData)
+		sc.brief()
+		timers.add(func(now time.Duration, _ float64) { sc.update(&player, now) })
+		harbors = scenarioCfg.Harbors
+		suspects = scenarioCfg.Inspections
+		fleet.addScenarioEnemies(scenarioCfg.Enemies)
+		complete = sc.complete
+		scenarioProgress = sc.progress
+	}
+	defenses := newHarborDefenses(harbors, events, env, shipping, fleet, datums, marks)
+	orders.handle(orderCutNet, func(o order) error { return defenses.setCutting(&player, o.Value != 0) })
+	timers.add(func(now time.Duration, dt float64) { defenses.step(&player, now, dt) })
+	// 任務の目標
+	objectives := newMissionControl(mission, events, missionWorld{player: &player, survey: surveyData, traffic: shipping})
+	if objectives != nil {
+		timers.add(func(time.Duration, float64) { objectives.step() })
+	}
+	missionText, err := text.New()
+	if err != nil {
+		panic(err)
+	}
+	screen.addText(render.panelDelay(time.Second), missionText, func(*frame) panelText { return missionPanel(objectives) })
+	// 臨検
+	boarding := newBoardingParty(suspects, events, shipping, crew, complete)
+	orders.handle(orderBoard, func(order) error { return boarding.proceed(&player) })
+	orders.handle(orderWithdraw, func(order) error { return boarding.withdraw(&player) })
+	timers.add(func(_ time.Duration, dt float64) { boarding.step(&player, dt) })
+	screen.add(render.panelDelay(500*time.Millisecond), trackPanel(trackText))
+	loop.every(500*time.Millisecond, func(time.Time) { marks.check(player.Position) })
+	screen.add(render.panelDelay(500*time.Millisecond), func(f *frame) { chartPanel(f, chartText) })
+	nav := newNavMap()
+	nav.debug = o.debugMode
+	timers.add(func(now time.Duration, _ float64) { nav.record(now, player.Position) })
+	screen.addText(render.panelDelay(250*time.Millisecond), navText, func(f *frame) panelText {
+		return navMapPanel(f, env, nav, waypoints, surveyData, pinger, shipping)
+	})
+	xbt := newBathythermograph(events, env)
+	orders.handle(orderLaunchXBT, func(order) error { return xbt.launch(&player) })
+	timers.add(func(_ time.Duration, dt float64) { xbt.step(dt) })
+	if instructor != nil {
+		instructor.connect(instructorGame{player: &player, store: state, traffic: shipping, tracks: tracks, chart: marks, events: events, enemies: fleet})
+		timers.add(func(time.Duration, float64) { instructor.step() })
+	}
+	timers.add(func(now time.Duration, _ float64) { debrief.step(now, &player, shipping, tracks) })
+	if telemetry != nil {
+		timers.add(func(now time.Duration, _ float64) { telemetry.write(&player, now) })
+	}
+	screen.addText(render.panelDelay(time.Second), propagationText, func(f *frame) panelText { return propagationPanel(f, xbt, shipping) })
+	screen.addText(render.panelDelay(250*time.Millisecond), presetText, func(*frame) panelText { return torpedoPresetPanel(room) })
+	screen.addText(render.panelDelay(500*time.Millisecond), crewText, func(*frame) panelText { return crewPanel(crew, drills) })
+	// 補機ごとの雑音。補機を止めると静かになるが、艦の働きが落ちる
+	engineers := newEngineering(events, crew)
+	machineryText, err := text.New()
+	if err != nil {
+		panic(err)
+	}
+	orders.handle(orderSecureEquipment, func(o order) error { return engineers.secure(&player, o) })
+	orders.handle(orderRestartEquipment, func(o order) error { return engineers.secure(&player, o) })
+	timers.add(func(_ time.Duration, dt float64) { engineers.step(&player, dt) })
+	// 自動操舵。手で舵や回転数を命じると切れる
+	pilot := newAutopilot(events, waypoints)
+	orders.handle(orderHeadingHold, func(o order) error { return pilot.holdHeading(&player, o) })
+	orders.handle(orderSpeedHold, func(o order) error { return pilot.holdSpeed(&player, o) })
+	orders.handle(orderFollowRoute, func(o order) error { return pilot.followRoute(&player, o) })
+	orders.subscribe(func(o order) { pilot.override(&player, o) })
+	timers.add(func(time.Duration, float64) { waypoints.step(&player) })
+	timers.add(func(_ time.Duration, dt float64) { pilot.step(&player, dt) })
+	screen.addText(render.panelDelay(500*time.Millisecond), machineryText, func(f *frame) panelText { return machineryPanel(&f.player, engineers) })
+	screen.addText(render.panelDelay(500*time.Millisecond), tmaText, func(f *frame) panelText { return tmaPanel(f, room, ekelund) })
+	// 速力と深度の推移
+	history := newTrackHistory()
+	historyCharts, err := newHistoryPanel()
+	if err != nil {
+		panic(err)
+	}
+	timers.add(func(now time.Duration, _ float64) { history.record(&player, now) })
+	screen.add(render.panelDelay(time.Second), func(f *frame) {
+		if err := historyCharts.draw(f); err != nil {
+			panic(err)
+		}
+	})
+	// 機関の傾向 (実回転数・雑音・炉心の温度)
+	trends := newEngineTrends()
+	trendsStrip, err := newTrendsPanel()
+	if err != nil {
+		panic(err)
+	}
+	timers.add(func(now time.Duration, _ float64) { trends.record(&player, now) })
+	// 推移と傾向のパネルも状態の写しだけを読む
+	state.watchTrends(history, trends)
+	screen.add(render.panelDelay(time.Second), func(f *frame) {
+		if err := trendsStrip.draw(f); err != nil {
+			panic(err)
+		}
+	})
+	switch {
+	case o.drive != nil:
+		guard.goSafe(func() {
+			defer cancel()
+			o.drive(ctx)
+		})
+	case soak != nil:
+		// 漏れを調べる物。艦を失ったときのやり直しは哨戒の終わりを組み立ててから下で加える
+		soak.watch("merchant vessels", func() int { return len(shipping.vessels()) })
+		soak.watch("tracks", tracks.live)
+		soak.watch("weapons in water", countWeapons(decoys.weapons, fleet.weapons, firing.weapons))
+		soak.watch("chart marks", func() int { return len(marks.saved()) })
+	default:
+		loop.every(autosaveInterval, func(time.Time) { autosave(&player, marks, waypoints, room, crew, surveyData, attacks, savePath) })
+	}
 
 	// Layout ----------------------------------------------------------------------
-	go writeLines(ctx, &player, rolled, 1*time.Second)
-	c, err := container.New(
-		t,
+	navPanel := focus.panel("nav-map", "Nav Map", navText, nil, func(dir int) { nav.zoom(-dir) })
+	trackPanel := focus.panel("tracks", "Tracks", trackText, nil, func(dir int) {
+		if dir > 0 {
+			tracks.selectNext()
+		} else {
+			tracks.selectPrev()
+		}
+	})
+	presetPanel := focus.panel("presets", "Torpedo Presets", presetText, func() error {
+		room.nextField()
+		return nil
+	}, func(dir int) { room.adjust(-dir) })
+	crewFocus := focus.panel("crew", "Crew", crewText, func() error {
+		crew.nextStation()
+		return nil
+	}, func(dir int) { crew.cycle(-dir) })
+	machineryFocus := focus.panel("machinery", "Machinery Noise", machineryText, func() error {
+		f := state.view()
+		return orders.exec(engineers.toggle(&f.player))
+	}, func(dir int) { engineers.move(dir) })
+	screen.add(render.panelDelay(1*time.Second), func(f *frame) { writeLines(&f.player, rolled) })
+	// チェックポイントからやり直すときに戻せるよう、画面の配置は取っておく
+	rootLayout := []container.Option{
 		container.Border(linestyle.Light),
 		container.BorderTitle("PRESS Q TO QUIT"),
 		container.SplitVertical(
 			container.Left(
 				container.SplitHorizontal(
 					container.Top(
-						container.SplitHorizontal(
-							container.Top(
-								container.Border(linestyle.Light),
-								container.BorderTitle("Current Speed: (kt)"),
-								container.PlaceWidget(display),
-							),
-							container.Bottom(
-								container.Border(linestyle.Light),
-								container.BorderTitle("Turbine Control"),
+						container.SplitVertical(
+							container.Left(
 								container.SplitVertical(
 									container.Left(
 										container.SplitHorizontal(
 											container.Top(
-												container.PlaceWidget(rpmSettingMeter),
+												container.Border(linestyle.Light),
+												container.BorderTitle("Current Speed: (kt)"),
+												container.PlaceWidget(speed.widget()),
 											),
 											container.Bottom(
+												container.Border(linestyle.Light),
+												container.BorderTitle("Turbine Control"),
 												container.SplitVertical(
 													container.Left(
-														container.PlaceWidget(buttonTurbinePlus),
-														container.AlignHorizontal(align.HorizontalCenter),
+														container.SplitHorizontal(
+															container.Top(
+																container.PlaceWidget(rpmSettingMeter),
+															),
+															container.Bottom(
+																container.SplitVertical(
+																	container.Left(
+																		buttonTurbinePlus.options()...,
+																	),
+																	container.Right(
+																		buttonTurbineMinus.options()...,
+																	),
+																),
+															),
+														),
 													),
 													container.Right(
-														container.PlaceWidget(buttonTurbineMinus),
-														container.AlignHorizontal(align.HorizontalCenter),
+														container.Border(linestyle.Light),
+														container.BorderTitle("rpm"),
+														container.PlaceWidget(rpmWidget),
 													),
 												),
 											),
 										),
 									),
 									container.Right(
-										container.Border(linestyle.Light),
-										container.BorderTitle("rpm"),
-										container.PlaceWidget(rpmMeter),
+										navPanel.options()...,
 									),
+									container.SplitPercent(55),
 								),
 							),
+							container.Right(
+								container.Border(linestyle.Light),
+								container.BorderTitle("Depth (m)"),
+								container.PlaceWidget(depthText),
+							),
+							container.SplitPercent(80),
 						),
 					),
 					container.Bottom(
-						container.Border(linestyle.Light),
-						container.BorderTitle("Wraps lines at rune boundaries"),
-						container.PlaceWidget(wrapped),
+						container.SplitHorizontal(
+							container.Top(
+								container.SplitVertical(
+									container.Left(
+										container.Border(linestyle.Light),
+										container.BorderTitle("Ship Status"),
+										container.PlaceWidget(statusText),
+									),
+									container.Right(
+										container.Border(linestyle.Light),
+										container.BorderTitle("Compass"),
+										container.PlaceWidget(compassText),
+									),
+									container.SplitPercent(45),
+								),
+							),
+							container.Bottom(
+								container.SplitHorizontal(
+									container.Top(
+										container.SplitVertical(
+											container.Left(
+												trackPanel.options()...,
+											),
+											container.Right(
+												container.SplitVertical(
+													container.Left(
+														container.Border(linestyle.Light),
+														container.BorderTitle("TMA"),
+														container.PlaceWidget(tmaText),
+													),
+													container.Right(
+														container.SplitHorizontal(
+															container.Top(
+																container.Border(linestyle.Light),
+																container.BorderTitle("Chart Marks"),
+																container.PlaceWidget(chartText),
+															),
+															container.Bottom(
+																container.Border(linestyle.Light),
+																container.BorderTitle("Objectives"),
+																container.PlaceWidget(missionText),
+															),
+														),
+													),
+													container.SplitPercent(50),
+												),
+											),
+											container.SplitPercent(55),
+										),
+									),
+									container.Bottom(
+										container.SplitVertical(
+											container.Left(
+												container.Border(linestyle.Light),
+												container.BorderTitle("Sound Propagation"),
+												container.PlaceWidget(propagationText),
+											),
+											container.Right(
+												presetPanel.options()...,
+											),
+											container.SplitPercent(50),
+										),
+									),
+								),
+							),
+							container.SplitPercent(40),
+						),
 					),
 				),
 			),
 			container.Right(
 				container.SplitHorizontal(
 					container.Top(
-						container.PlaceWidget(rudderAngleGaugeObj),
+						container.SplitHorizontal(
+							container.Top(
+								container.SplitVertical(
+									container.Left(
+										rudderLeftButtonObj.options()...,
+									),
+									container.Right(
+										container.SplitVertical(
+											container.Left(
+												container.PlaceWidget(rudderAngleGaugeObj),
+											),
+											container.Right(
+												rudderRightButtonObj.options()...,
+											),
+											container.SplitPercent(88),
+										),
+									),
+									container.SplitPercent(10),
+								),
+							),
+							container.Bottom(
+								container.SplitHorizontal(
+									container.Top(
+										container.Border(linestyle.Light),
+										container.BorderTitle("Depth Control"),
+										container.SplitVertical(
+											container.Left(
+												container.PlaceWidget(hoverText),
+											),
+											container.Right(
+												hoverButton.options()...,
+											),
+											container.SplitPercent(70),
+										),
+									),
+									container.Bottom(
+										container.SplitVertical(
+											container.Left(
+												historyCharts.options()...,
+											),
+											container.Right(
+												trendsStrip.options()...,
+											),
+											container.SplitPercent(70),
+										),
+									),
+									container.SplitPercent(55),
+								),
+							),
+							container.SplitPercent(25),
+						),
 					),
 					container.Bottom(
-						container.Border(linestyle.Light),
-						container.BorderTitle("Rolls and scrolls content wrapped at words"),
-						container.PlaceWidget(rolled),
+						container.SplitHorizontal(
+							container.Top(
+								container.SplitVertical(
+									container.Left(
+										container.Border(linestyle.Light),
+										container.BorderTitle("Acoustic Beacons"),
+										container.PlaceWidget(beaconText),
+									),
+									container.Right(
+										container.SplitVertical(
+											container.Left(
+												container.Border(linestyle.Light),
+												container.BorderTitle("Surface Picture"),
+												container.PlaceWidget(surfaceText),
+											),
+											container.Right(
+												container.Border(linestyle.Light),
+												container.BorderTitle("Passive Sonar"),
+												container.PlaceWidget(passiveText),
+											),
+											container.SplitPercent(40),
+										),
+									),
+									container.SplitPercent(35),
+								),
+							),
+							container.Bottom(
+								container.SplitHorizontal(
+									container.Top(
+										container.SplitVertical(
+											container.Left(
+												crewFocus.options()...,
+											),
+											container.Right(
+												machineryFocus.options()...,
+											),
+											container.SplitPercent(55),
+										),
+									),
+									container.Bottom(
+										container.Border(linestyle.Light),
+										container.BorderTitle("Rolls and scrolls content wrapped at words"),
+										container.PlaceWidget(rolled),
+									),
+									container.SplitPercent(40),
+								),
+							),
+							container.SplitPercent(30),
+						),
 					),
 				),
 			),
 		),
-	)
+	}
+	c, err := container.New(t, append([]container.Option{container.ID("root")}, rootLayout...)...)
+	if err != nil {
+		panic(err)
+	}
+
+	focus.attach(c)
+
+	// 画面上部の表示と即応態勢
+	bar := newStatusBar(c)
+	bar.update()
+	loop.every(time.Second, func(time.Time) { readinessTick(&player, bar, time.Second) })
+
+	// タイトル画面のデモ。耐久試験では同じボットに艦を任せる
+	demoRand := rngs.next()
+	patrol := newPatrolBot(demoRand)
+	state.update(func(p *Player) {
+		demo.start(p, patrol, demoRand, func(active bool) {
+			title := "PRESS Q TO QUIT"
+			if active {
+				title = "DEMO - PRESS ANY KEY TO START PATROL"
+			}
+			bar.setMessage(title)
+		})
+	})
+	state.attractMode(demo.shown)
+	// 訓練をしなくても乱数は作り、以降の系列を -drill の有無で変えない
+	drillRand := rngs.next()
+	if o.drill {
+		// 訓練ではタイトル画面を出さず、タイトルに訓練の進み具合を出す
+		d := newTorpedoDrill(events, env, drillRand, bar.setMessage)
+		// 訓練ではノイズメーカーだけを訓練用の数で使う
+		orders.handle(orderCountermeasure, func(order) error { return d.launchNoisemaker(&player) })
+		orders.handle(orderLaunchDecoy, func(order) error { return orderRefusedError{"decoys are not used in the drill"} })
+		timers.add(func(now time.Duration, dt float64) { d.step(&player, now, dt) })
+		debrief.trackWeapons(d.weapons)
+	}
+
+	// 総員退艦。結果は戦歴に残す
+	abandon := newAbandonShip(events, env, beacons, rngs.next(), filepath.Join(dir, campaignFileName), bar.setMessage)
+	orders.handle(orderAbandonShip, func(order) error { return abandon.order(&player) })
+	// 自艦の魚雷のジャイロの故障。ほかの乱数の系列を変えないよう、ここで作る
+	firing.rng = rngs.next()
+	// 天候の移り変わり
+	wx.rng = rngs.next()
+	// 訓練の故障と定期の訓練の時刻
+	drills.rng = rngs.next()
+	// 補給の船団が沈められるか
+	depots.rng = rngs.next()
+	// 深い変温層があるかと、その深さ
+	env.generateLayers(rngs.next())
+
+	// 命令の復唱と遅れ (config.toml の order_delay)
+	if orderDelay {
+		orders.relay = newOrderRelay(rngs.next())
+		timers.add(func(_ time.Duration, dt float64) {
+			if err := orders.relayStep(dt); err != nil {
+				panic(err)
+			}
+		})
+	}
+
+	// 哨戒の終わり。Q を押したときもまとめの画面を出してから終える
+	summaryText, err := text.New(text.WrapAtWords())
 	if err != nil {
 		panic(err)
 	}
+	patrolObjectives := func() (int, int) {
+		missionDone, missionTotal := objectives.progress()
+		scenarioDone, scenarioTotal := scenarioProgress()
+		return missionDone + scenarioDone, missionTotal + scenarioTotal
+	}
+	end := newPatrolEnd(events, patrolObjectives, abandon.result, func(lines []string) {
+		summaryText.Reset()
+		for _, l := range lines {
+			if err := summaryText.Write(l + "\n"); err != nil {
+				panic(err)
+			}
+		}
+		if err := c.Update("root", container.PlaceWidget(summaryText)); err != nil {
+			panic(err)
+		}
+		bar.setMessage("PATROL OVER - PRESS Q TO EXIT")
+	})
+	timers.add(func(now time.Duration, _ float64) { end.step(&player, now) })
+
+	// 節目のチェックポイント。艦を失ったらまとめの画面の R で最後のチェックポイントに戻る
+	saves := newCheckpoints(events, func(p *Player) saveData {
+		s := newSaveData(p)
+		s.Tubes = room.saved()
+		torpedoes := room.aboard()
+		s.Torpedoes = &torpedoes
+		s.Crew = crew.saved()
+		s.Casualties, s.CrewMorale = crew.savedCasualties()
+		s.CrewSkills = crew.savedSkills()
+		return s
+	}, patrolObjectives, func() bool { return tracks.count() > 0 }, end.traveled)
+	end.checkpoint = saves.describe
+	timers.add(func(now time.Duration, _ float64) { saves.step(&player, now) })
+	retry := func(p *Player) {
+		cp, ok := saves.latest()
+		if !ok || !end.retry(p, cp.distance) {
+			return
+		}
+		cp.save.apply(p)
+		room.restore(cp.save.Tubes)
+		room.restoreMagazine(*cp.save.Torpedoes)
+		crew.restore(cp.save.Crew)
+		crew.restoreCasualties(cp.save.Casualties, cp.save.CrewMorale)
+		crew.restoreSkills(cp.save.CrewSkills)
+		if err := c.Update("root", rootLayout...); err != nil {
+			panic(err)
+		}
+		bar.setMessage("")
+		events.add(cell.ColorYellow, "[CHECKPOINT] Restarted from %s.", cp)
+	}
+
+	if soak != nil {
+		helm := &botHelm{orders: orders, bot: patrol}
+		// 艦を失ったら最後のチェックポイントからやり直して続ける
+		timers.add(func(now time.Duration, _ float64) {
+			if player.gameOver {
+				ended := end.outcome()
+				retry(&player)
+				soak.patrolEnded(now, ended, !player.gameOver)
+			}
+			helm.step(&player, now)
+			if soak.step(&player, now) {
+				cancel()
+			}
+		})
+		guard.goSafe(func() { soak.run(ctx, fc) })
+	}
+
+	// キー割り当ての操作をする (艦の状態の置き場の鍵を持って呼ぶ)
+	applyKey := func(p *Player, key keyboard.Key) {
+		// まとめの画面では R でチェックポイントに戻るだけ
+		if end.over() {
+			if key == 'r' || key == 'R' {
+				retry(p)
+			}
+			return
+		}
+		// 変針点のカーソルを出している間は矢印キー・Enter・Esc・Backspace をカーソルに回す
+		if waypoints.cursorKey(key, nav.cellSize()) {
+			return
+		}
+		var o order
+		switch bindings[key] {
+		case actionQuit:
+			end.quit(p)
+		case actionTurbineUp:
+			o = order{Kind: orderTurbineRpm, Value: p.Turbine.Ordered + 10}
+		case actionTurbineDown:
+			o = order{Kind: orderTurbineRpm, Value: p.Turbine.Ordered - 10}
+		case actionRudderLeft:
+			o = order{Kind: orderRudder, Value: p.Rudder.Ordered - 2.5}
+		case actionRudderRight:
+			o = order{Kind: orderRudder, Value: p.Rudder.Ordered + 2.5}
+		case actionCourseLeft:
+			o = order{Kind: orderCourse, Value: p.orderedCourse() - 5}
+		case actionCourseRight:
+			o = order{Kind: orderCourse, Value: p.orderedCourse() + 5}
+		case actionHover:
+			o = order{Kind: orderHover, Value: boolValue(!p.HoverEnabled)}
+		case actionHeadingHold:
+			o = order{Kind: orderHeadingHold, Value: boolValue(!p.headingHold)}
+		case actionSpeedHold:
+			o = order{Kind: orderSpeedHold, Value: boolValue(!p.speedHold)}
+		case actionWaypoint:
+			waypoints.waypointKey(p.Position)
+		case actionFollowRoute:
+			o = order{Kind: orderFollowRoute, Value: boolValue(!p.followRoute)}
+		case actionClearRoute:
+			waypoints.clear(p)
+		case actionBilgePumps:
+			o = order{Kind: orderBilgePumps, Value: boolValue(!p.BilgePumps)}
+		case actionTrimHeavy:
+			o = order{Kind: orderTrim, Value: p.Trim.Ordered - 1}
+		case actionTrimLight:
+			o = order{Kind: orderTrim, Value: p.Trim.Ordered + 1}
+		case actionPlanesRise:
+			o = order{Kind: orderPlanes, Value: p.Planes.Ordered + 2.5}
+		case actionPlanesDive:
+			o = order{Kind: orderPlanes, Value: p.Planes.Ordered - 2.5}
+		case actionFlood:
+			o = order{Kind: orderBallast, Value: 100}
+		case actionBlow:
+			o = order{Kind: orderBallast, Value: 0}
+		case actionSecureMachinery:
+			o = order{Kind: orderSecureMachinery, Value: boolValue(!p.MachinerySecured)}
+		case actionLiftOff:
+			o = order{Kind: orderLiftOff}
+		case actionDeployBeacon:
+			o = order{Kind: orderDeployBeacon}
+		case actionInterrogate:
+			o = order{Kind: orderInterrogateBeacons}
+		case actionLaunchXBT:
+			o = order{Kind: orderLaunchXBT}
+		case actionSurvey:
+			active, _, _ := surveyData.status()
+			o = order{Kind: orderSurvey, Value: boolValue(!active)}
+		case actionCleanHull:
+			o = order{Kind: orderCleanHull, Value: boolValue(!hull.cleaning())}
+		case actionSnorkel:
+			o = order{Kind: orderSnorkel, Value: boolValue(!p.snorkel)}
+		case actionInspectWreck:
+			o = order{Kind: orderInspectWreck, Value: boolValue(!rov.inspecting())}
+		case actionTrainingDrill:
+			o = order{Kind: orderTrainingDrill, Value: boolValue(!drills.drilling())}
+		case actionResupply:
+			o = order{Kind: orderResupply}
+		case actionCutNet:
+			o = order{Kind: orderCutNet, Value: boolValue(!defenses.cuttingNet())}
+		case actionBoard:
+			o = order{Kind: orderBoard}
+		case actionWithdraw:
+			o = order{Kind: orderWithdraw}
+		case actionReactorRestart:
+			o = order{Kind: orderReactorRestart}
+		case actionPing:
+			o = order{Kind: orderPing}
+		case actionPingSector:
+			o = order{Kind: orderPingSector, Value: pinger.nextWidth()}
+		case actionCountermeasure:
+			o = order{Kind: orderCountermeasure}
+		case actionLaunchDecoy:
+			o = order{Kind: orderLaunchDecoy}
+		case actionReadiness:
+			o = order{Kind: orderReadiness, Value: float64(p.readiness.next())}
+		case actionAbandonShip:
+			o = order{Kind: orderAbandonShip}
+		case actionSelectTrack:
+			tracks.selectNext()
+		case actionMarkHazard:
+			o = order{Kind: orderMarkHazard}
+		case actionEkelundLeg:
+			ekelund.mark(tracks, p.Velocity, p.Direction, clock.Now())
+		case actionRevealChart:
+			nav.toggleReveal(events)
+		case actionRiskMap:
+			nav.toggleRisk(events)
+		case actionZoomIn:
+			nav.zoom(1)
+		case actionZoomOut:
+			nav.zoom(-1)
+		case actionPresetTube:
+			room.nextTube()
+		case actionPresetField:
+			room.nextField()
+		case actionPresetDown:
+			room.adjust(-1)
+		case actionPresetUp:
+			room.adjust(1)
+		case actionCrewStation:
+			crew.nextStation()
+		case actionCrewAssign:
+			crew.cycle(1)
+		case actionCrewTreat:
+			crew.treat()
+		case actionPrepareTube:
+			o = order{Kind: orderPrepareTube, Value: float64(room.selected() + 1)}
+		case actionLaunchTorpedo:
+			o = order{Kind: orderLaunchTorpedo, Value: float64(room.selected() + 1)}
+		case actionRecordMacro:
+			macros.toggleRecording()
+		case actionDiscardMacro:
+			macros.discard()
+		case actionPause:
+			if simPause.toggle() {
+				events.add(cell.ColorWhite, "[SIM] Paused.")
+				bar.setPaused(true)
+			} else {
+				events.add(cell.ColorWhite, "[SIM] Resumed.")
+				bar.setPaused(false)
+			}
+		case actionTimeCompression:
+			scale := simRate.cycle()
+			events.add(cell.ColorWhite, "[SIM] Time compression x%d.", scale)
+			bar.setTimeScale(scale)
+		case actionFocusNext:
+			if err := focus.next(1); err != nil {
+				panic(err)
+			}
+		}
+		if o.Kind != "" {
+			if err := orders.issue(o); err != nil {
+				panic(err)
+			}
+		}
+	}
+	if rp != nil {
+		rp.apply = func(p *Player, e replayEntry) {
+			switch {
+			case e.Key != nil:
+				applyKey(p, *e.Key)
+			case e.Button != "":
+				if o, ok := buttonOrders[e.Button]; ok {
+					if err := orders.issue(o(p)); err != nil {
+						panic(err)
+					}
+				}
+			case e.Order != nil:
+				if err := orders.issue(*e.Order); err != nil {
+					panic(err)
+				}
+			}
+		}
+	}
 
-	quitter := func(k *terminalapi.Keyboard) {
-		if k.Key == 'q' || k.Key == 'Q' {
+	keyHandler := func(k *terminalapi.Keyboard) {
+		defer guard.catch()
+		// まとめの画面では Q で終えるか、R でチェックポイントに戻るだけ
+		if end.over() {
+			switch {
+			case bindings[k.Key] == actionQuit:
+				cancel()
+			case rp == nil && (k.Key == 'r' || k.Key == 'R'):
+				state.update(func(p *Player) {
+					if rec != nil {
+						rec.key(k.Key)
+					}
+					applyKey(p, k.Key)
+				})
+			}
+			return
+		}
+		// 再生中は Q で終えるだけ
+		if rp != nil {
+			if bindings[k.Key] == actionQuit {
+				cancel()
+			}
+			return
+		}
+		// タイトル画面では Q で終え、ほかのキーで哨戒を始める
+		if demo.on() && bindings[k.Key] == actionQuit {
 			cancel()
+			return
+		}
+		if demo.input() {
+			return
+		}
+		if k.Key >= '1' && k.Key <= '9' {
+			macros.press(ctx, guard, string(rune(k.Key)))
+			return
+		}
+		// フォーカスがある間は矢印キー・Enter・Esc をフォーカスに回す
+		if handled, err := focus.handle(k.Key); handled {
+			if err != nil {
+				panic(err)
+			}
+			return
 		}
+		// 命令は艦の状態の置き場の鍵を持って出す (state.go)
+		state.update(func(p *Player) {
+			if rec != nil {
+				rec.key(k.Key)
+			}
+			applyKey(p, k.Key)
+		})
 	}
 
-	if err := termdash.Run(ctx, t, c, termdash.KeyboardSubscriber(quitter), termdash.RedrawInterval(16*time.Millisecond)); err != nil {
+	if pump != nil {
+		guard.goSafe(func() { pumpClock(ctx, pump) })
+	}
+	if err := termdash.Run(ctx, t, c,
+		termdash.KeyboardSubscriber(keyHandler),
+		termdash.MouseSubscriber(func(*terminalapi.Mouse) { demo.input() }),
+		termdash.RedrawInterval(render.redrawInterval()),
+		termdash.ErrorHandler(guard.handleError),
+	); err != nil {
 		panic(err)
 	}
 
-	debugLog("main(): end")
+	if rec != nil {
+		if err := rec.close(); err != nil {
+			fmt.Fprintf(os.Stderr, "replay: %v\n", err)
+		}
+	}
+	if telemetry != nil {
+		if err := telemetry.close(); err != nil {
+			fmt.Fprintf(os.Stderr, "telemetry: %v\n", err)
+		}
+	}
+	// テストと耐久試験は一時ディレクトリなので残さない。タイトル画面のまま終えたときは哨戒していない
+	if !unattended && !guard.crashed() && !demo.on() {
+		if saved, err := debrief.save(filepath.Join(dir, debriefFileName)); err != nil {
+			fmt.Fprintf(os.Stderr, "debrief: %v\n", err)
+		} else if saved {
+			fmt.Println("Mission recorded. Replay it with: explorergame debrief")
+		}
+	}
+	if soak != nil && !guard.crashed() {
+		soak.report(os.Stdout)
+		if soak.failed() {
+			return 1
+		}
+	}
+
+	logs.debugf("main", "end")
+	return 0
 }
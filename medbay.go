@@ -0,0 +1,397 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 医務室と乗員の怪我
+//
+// 区画が傷むと、その区画の配置に就いている乗員が怪我をする (被雷なら重傷)。疲れた乗員は事故でも怪我をする。
+// 怪我をした乗員は腕前が落ち (軽傷で 4 分の 3、重傷で 4 分の 1)、治るまでの時間は居場所で変わる。
+//   - 医務室: 衛生員が手当てし、いちばん早く治る。寝台は medbayBeds しかない
+//   - 非番: 寝棚で休み、ふつうに治る。重傷の非番の乗員は寝台が空けば衛生員が医務室に入れる
+//   - 配置に就いたまま: 軽傷はゆっくり治り、重傷は治らない
+// 医務室に送るかどうかは艦長が決める (選んでいる配置の乗員を送り、交代要員を就ける)。
+// 手当てを受けていない重傷者がいると士気が下がり続け、士気が moraleStandard を下回ると全員の腕前が落ちる。
+// 怪我と士気はデブリーフィングにも残る。
+
+type injury int
+
+const (
+	injuryNone injury = iota
+	injuryLight
+	injurySerious
+)
+
+func (i injury) String() string {
+	switch i {
+	case injuryLight:
+		return "light"
+	case injurySerious:
+		return "serious"
+	}
+	return "fit"
+}
+
+// 怪我をしているときの腕前の割合
+func (i injury) factor() float64 {
+	switch i {
+	case injuryLight:
+		return 0.75
+	case injurySerious:
+		return 0.25
+	}
+	return 1
+}
+
+// ふつうに休んで治るまでの時間
+func (i injury) recoveryTime() time.Duration {
+	switch i {
+	case injuryLight:
+		return 20 * time.Minute
+	case injurySerious:
+		return 2 * time.Hour
+	}
+	return 0
+}
+
+const (
+	// 医務室の寝台の数
+	medbayBeds = 2
+	// 居場所ごとの治る速さ (休んでいるときを 1 とする)
+	medbayRecoveryRate   = 3.0
+	onWatchLightRecovery = 0.5
+	// 区画の耐久値がこれだけ下がるごとに 1 人怪我をする
+	injuryDamageThreshold = 15.0
+	// 1 度にこれだけ下がると重傷になる (被雷)
+	seriousInjuryDamage = 40.0
+	// 疲労 100% のときに事故が起きる間隔 (シミュレーション時間)
+	accidentInterval = 2 * time.Hour
+	// 士気の初期値 (最大)、下限、これを下回ると腕前が落ちる値
+	moraleStart    = 100.0
+	moraleMin      = 20.0
+	moraleStandard = 75.0
+	// 怪我をしたときに下がる士気
+	moraleLightInjury   = 5.0
+	moraleSeriousInjury = 10.0
+	// 手当てを受けていない重傷者がいるときに 1 分で下がる士気
+	moraleUntreatedLoss = 2.0
+	// 手当てを受けていない重傷者がいないときに 1 分で戻る士気
+	moraleRecoveryRate = 0.1
+	// 乗員が治ったときに上がる士気
+	moraleRecovered = 5.0
+)
+
+// 士気による腕前の割合。moraleStandard 以上で 1
+func moraleFactor(morale float64) float64 {
+	return math.Min(1, 0.5+morale/150)
+}
+
+// 配置のある区画
+func (s station) compartment() sim.Compartment {
+	switch s {
+	case stationWeapons:
+		return sim.CompartmentTorpedoRoom
+	case stationDamageControl:
+		return sim.CompartmentEngineRoom
+	}
+	return sim.CompartmentControlRoom
+}
+
+// 怪我の記録 (デブリーフィング用)
+type casualty struct {
+	name      string
+	injury    injury
+	cause     string
+	at        time.Duration
+	treated   bool
+	recovered bool
+}
+
+// セーブデータに残す怪我
+type savedCasualty struct {
+	Name     string  `json:"name"`
+	Injury   int     `json:"injury"`
+	Recovery float64 `json:"recovery"`
+	Medbay   bool    `json:"medbay,omitempty"`
+}
+
+// 乗員 i が配置に就いているか (r.mu を保持した状態で呼ぶ)
+func (r *crewRoster) onWatch(i int) bool {
+	for _, a := range r.assigned {
+		if a == i {
+			return true
+		}
+	}
+	return false
+}
+
+// 医務室の寝台を使っている数 (r.mu を保持した状態で呼ぶ)
+func (r *crewRoster) bedsInUse() int {
+	n := 0
+	for _, m := range r.members {
+		if m.medbay {
+			n++
+		}
+	}
+	return n
+}
+
+// 配置 s の交代要員として最も腕のよい非番の乗員。いなければ -1 (r.mu を保持した状態で呼ぶ)
+// 医務室にいる乗員と重傷の乗員は就けない
+func (r *crewRoster) relief(s station) int {
+	best := -1
+	for i, m := range r.members {
+		if r.onWatch(i) || m.medbay || m.injury == injurySerious {
+			continue
+		}
+		if best < 0 || m.skillAt(s) > r.members[best].skillAt(s) {
+			best = i
+		}
+	}
+	return best
+}
+
+// 乗員 i を怪我させる (r.mu を保持した状態で呼ぶ)
+// すでにもっと重い怪我をしていれば、治るまでの時間だけ延ばす
+func (r *crewRoster) injure(i int, severity injury, cause string) {
+	m := &r.members[i]
+	if severity > m.injury {
+		m.injury = severity
+	}
+	m.recovery = math.Max(m.recovery, severity.recoveryTime().Seconds())
+	loss := moraleLightInjury
+	if severity == injurySerious {
+		loss = moraleSeriousInjury
+	}
+	r.morale = math.Max(r.morale-loss, moraleMin)
+	r.casualties = append(r.casualties, casualty{name: m.name, injury: severity, cause: cause, at: r.now})
+	r.events.add(cell.ColorRed, "[MEDICAL] %s injured (%s): %s.", m.name, severity, cause)
+}
+
+// 区画 c の配置に就いている乗員のうち 1 人を怪我させる (r.mu を保持した状態で呼ぶ)
+// 同じ区画に配置が 2 つあれば交互に当たる
+func (r *crewRoster) injureIn(c sim.Compartment, severity injury) {
+	var candidates []int
+	for s, i := range r.assigned {
+		if station(s).compartment() == c {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 0 {
+		return
+	}
+	i := candidates[len(r.casualties)%len(candidates)]
+	r.injure(i, severity, "damage in the "+c.String())
+}
+
+// 怪我、手当て、士気を dt 秒分だけ進める (ティックごとに呼ぶ)
+func (r *crewRoster) step(p *Player, now time.Duration, dt float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.now = now
+
+	// 区画の損傷による怪我
+	if !r.hpKnown {
+		r.lastHP, r.hpKnown = p.Compartments, true
+	}
+	for c := range p.Compartments {
+		drop := r.lastHP[c] - p.Compartments[c]
+		if drop <= 0 {
+			continue
+		}
+		r.pending[c] += drop
+		if r.pending[c] >= injuryDamageThreshold {
+			r.pending[c] = 0
+			severity := injuryLight
+			if drop >= seriousInjuryDamage {
+				severity = injurySerious
+			}
+			r.injureIn(sim.Compartment(c), severity)
+		}
+	}
+	r.lastHP = p.Compartments
+
+	// 疲れによる事故。配置に就いている中で最も腕の落ちている乗員が怪我をする
+	r.accident += p.crewFatigue / 100 * dt / accidentInterval.Seconds()
+	if r.accident >= 1 {
+		r.accident = 0
+		worst := stationHelm
+		for s := station(0); s < stationCount; s++ {
+			if r.members[r.assigned[s]].skillAt(s) < r.members[r.assigned[worst]].skillAt(worst) {
+				worst = s
+			}
+		}
+		r.injure(r.assigned[worst], injuryLight, fmt.Sprintf("accident at the %s station", strings.ToLower(worst.String())))
+	}
+
+	untreated := false
+	for i := range r.members {
+		m := &r.members[i]
+		if m.injury == injuryNone {
+			continue
+		}
+		watch := r.onWatch(i)
+		// 非番の重傷者は、寝台が空けば衛生員が医務室に入れる
+		if !watch && !m.medbay && m.injury == injurySerious && r.bedsInUse() < medbayBeds {
+			r.admit(i)
+			r.events.add(cell.ColorCyan, "[MEDICAL] The corpsman admits %s to the medbay.", m.name)
+		}
+		rate := 1.0
+		switch {
+		case m.medbay:
+			rate = medbayRecoveryRate
+		case watch && m.injury == injurySerious:
+			rate = 0
+		case watch:
+			rate = onWatchLightRecovery
+		}
+		if m.injury == injurySerious && !m.medbay {
+			untreated = true
+		}
+		m.recovery -= rate * dt
+		if m.recovery > 0 {
+			continue
+		}
+		m.injury, m.recovery, m.medbay = injuryNone, 0, false
+		r.morale = math.Min(r.morale+moraleRecovered, moraleStart)
+		for j := len(r.casualties) - 1; j >= 0; j-- {
+			if r.casualties[j].name == m.name && !r.casualties[j].recovered {
+				r.casualties[j].recovered = true
+			}
+		}
+		r.events.add(cell.ColorGreen, "[MEDICAL] %s has recovered and is fit for duty.", m.name)
+	}
+
+	if untreated {
+		r.morale = math.Max(r.morale-moraleUntreatedLoss*dt/60, moraleMin)
+	} else {
+		r.morale = math.Min(r.morale+moraleRecoveryRate*dt/60, moraleStart)
+	}
+}
+
+// 士気を loss だけ下げる (調理室を止めているときなど)
+func (r *crewRoster) lowerMorale(loss float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.morale = math.Max(r.morale-loss, moraleMin)
+}
+
+// 乗員 i を医務室に入れ、怪我の記録に手当てを受けたと残す (r.mu を保持した状態で呼ぶ)
+func (r *crewRoster) admit(i int) {
+	r.members[i].medbay = true
+	for j := len(r.casualties) - 1; j >= 0; j-- {
+		if r.casualties[j].name == r.members[i].name && !r.casualties[j].recovered {
+			r.casualties[j].treated = true
+		}
+	}
+}
+
+// 選んでいる配置の乗員を医務室に送り、交代要員を就ける
+func (r *crewRoster) treat() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.selected
+	i := r.assigned[s]
+	m := r.members[i]
+	if m.injury == injuryNone {
+		r.events.add(cell.ColorYellow, "[MEDICAL] %s at the %s station is not injured.", m.name, strings.ToLower(s.String()))
+		return
+	}
+	if r.bedsInUse() >= medbayBeds {
+		r.events.add(cell.ColorYellow, "[MEDICAL] The medbay is full (%d beds). %s stays at the %s station.", medbayBeds, m.name, strings.ToLower(s.String()))
+		return
+	}
+	relief := r.relief(s)
+	if relief < 0 {
+		r.events.add(cell.ColorYellow, "[MEDICAL] No one can relieve %s at the %s station.", m.name, strings.ToLower(s.String()))
+		return
+	}
+	r.assigned[s] = relief
+	r.admit(i)
+	r.events.add(cell.ColorCyan, "[MEDICAL] %s sent to the medbay. %s takes the %s station (skill %.0f).",
+		m.name, r.members[relief].name, strings.ToLower(s.String()), r.skillOf(relief, s))
+}
+
+// 医務室の行
+// 例: "Medbay 1/2: SN Ruiz 1:42  Morale 85"
+func (r *crewRoster) medbayLine() (string, cell.Color) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var patients []string
+	for _, m := range r.members {
+		if m.medbay {
+			left := int(math.Ceil(m.recovery / medbayRecoveryRate / 60))
+			patients = append(patients, fmt.Sprintf("%s %d:%02d", m.name, left/60, left%60))
+		}
+	}
+	line := fmt.Sprintf("Medbay %d/%d", len(patients), medbayBeds)
+	if len(patients) > 0 {
+		line += ": " + strings.Join(patients, ", ")
+	}
+	line += fmt.Sprintf("  Morale %.0f", r.morale)
+	switch {
+	case r.morale < moraleStandard:
+		return line, cell.ColorRed
+	case len(patients) > 0 || r.morale < moraleStart:
+		return line, cell.ColorYellow
+	}
+	return line, cell.ColorGreen
+}
+
+// 怪我をしている乗員 (セーブデータ用)
+func (r *crewRoster) savedCasualties() ([]savedCasualty, float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var list []savedCasualty
+	for _, m := range r.members {
+		if m.injury != injuryNone {
+			list = append(list, savedCasualty{Name: m.name, Injury: int(m.injury), Recovery: m.recovery, Medbay: m.medbay})
+		}
+	}
+	return list, r.morale
+}
+
+// セーブデータから怪我と士気を戻す。配置に就いている乗員は医務室から出す
+// 士気を記録していない古いセーブデータは最大から始まる
+func (r *crewRoster) restoreCasualties(list []savedCasualty, morale float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range list {
+		for i := range r.members {
+			m := &r.members[i]
+			if m.name != c.Name || c.Injury <= int(injuryNone) || c.Injury > int(injurySerious) {
+				continue
+			}
+			m.injury, m.recovery = injury(c.Injury), c.Recovery
+			m.medbay = c.Medbay && !r.onWatch(i)
+		}
+	}
+	if morale > 0 {
+		r.morale = math.Max(math.Min(morale, moraleStart), moraleMin)
+	}
+}
+
+// 怪我の記録と今の士気 (デブリーフィング用)
+func (r *crewRoster) debrief() ([]debriefCasualty, float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var list []debriefCasualty
+	for _, c := range r.casualties {
+		list = append(list, debriefCasualty{
+			T:         c.at.Seconds(),
+			Name:      c.name,
+			Injury:    c.injury.String(),
+			Cause:     c.cause,
+			Treated:   c.treated,
+			Recovered: c.recovered,
+		})
+	}
+	return list, r.morale
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/missions"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 任務 (-mission で読み込む)
+//
+// 目標の達成は missions パッケージが調べ、ここではゲームの状態を渡してイベントログと Objectives パネルに出す。
+
+// missions.World の実装
+type missionWorld struct {
+	player  *Player
+	survey  *survey
+	traffic *traffic
+}
+
+func (w missionWorld) Position() sim.Point3D { return w.player.Position }
+
+func (w missionWorld) Surveyed(x, y, radius float64) float64 {
+	return w.survey.coverage(x, y, radius)
+}
+
+func (w missionWorld) Sunk(name string) bool { return w.traffic.wasSunk(name) }
+
+type missionControl struct {
+	events  *eventLog
+	tracker *missions.Tracker
+	world   missionWorld
+	// すべての目標を達成したことを知らせたか
+	announced bool
+}
+
+// 任務がなければ nil
+func newMissionControl(m *missions.Mission, events *eventLog, w missionWorld) *missionControl {
+	if m == nil {
+		return nil
+	}
+	if m.Briefing != "" {
+		events.add(cell.ColorMagenta, "[BRIEFING] %s: %s", m.Name, m.Briefing)
+	}
+	return &missionControl{events: events, tracker: missions.NewTracker(*m), world: w}
+}
+
+// 目標の達成を調べる (ティックごとに呼ぶ)
+func (mc *missionControl) step() {
+	for _, o := range mc.tracker.Update(mc.world) {
+		mc.events.add(cell.ColorGreen, "[MISSION] Objective complete: %s", o.Description)
+	}
+	if !mc.announced && mc.tracker.Complete() {
+		mc.announced = true
+		mc.events.add(cell.ColorGreen, "[MISSION] %s accomplished.", mc.tracker.Mission().Name)
+	}
+}
+
+// 達成した目標の数と目標の数。任務がなければ 0, 0
+func (mc *missionControl) progress() (done, total int) {
+	if mc == nil {
+		return 0, 0
+	}
+	for _, s := range mc.tracker.Statuses() {
+		if s.Done {
+			done++
+		}
+		total++
+	}
+	return done, total
+}
+
+// Objectives パネルの 1 目標の行
+// 例: "[ ] Survey the bank  42% of 80%  3.1 km"
+func objectiveLine(s missions.Status) (string, cell.Color) {
+	mark, color := "[ ]", cell.ColorDefault
+	if s.Done {
+		mark, color = "[x]", cell.ColorGreen
+	}
+	line := mark + " " + s.Description
+	if !s.Done {
+		if s.Kind == missions.KindSurvey {
+			line += fmt.Sprintf("  %.0f%% of %.0f%%", s.Coverage, s.Objective.Coverage)
+		}
+		if !math.IsNaN(s.Distance) {
+			line += fmt.Sprintf("  %.1f km", s.Distance/1000)
+		}
+		if s.Waiting {
+			line += "  (after the others)"
+			color = cell.ColorYellow
+		}
+	}
+	return line, color
+}
+
+func missionPanel(mc *missionControl) panelText {
+	var out panelText
+	if mc == nil {
+		out.write("No mission.  -mission FILE to load one\n", cell.ColorDefault)
+		return out
+	}
+	out.write(mc.tracker.Mission().Name+"\n", cell.ColorDefault)
+	for _, s := range mc.tracker.Statuses() {
+		line, color := objectiveLine(s)
+		out.write(line+"\n", color)
+	}
+	return out
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 任務を読み込まなければ Objectives パネルは空で、[MISSION] も出ない
+func TestMission(t *testing.T) {
+	g := startGame(t)
+	g.advance(2 * time.Second)
+	g.expect("No mission.")
+	g.expectNot("[MISSION]")
+}
@@ -0,0 +1,130 @@
+package missions
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 任務と目標
+//
+// 任務は JSON ファイルに書いた目標の一覧で、ゲームのティックごとに Update で達成したかを調べる。
+// 目標の種類は次のとおり。
+//   - reach: (X, Y) から Radius (m) 以内に入る
+//   - survey: (X, Y) から Radius (m) 以内の海底を Coverage (%) 以上測量する
+//   - sink: Target という名前の船を沈める
+//   - return: ほかの目標をすべて達成してから、港 (X, Y) の Radius (m) 以内に戻る
+//
+// 一度達成した目標は、あとで条件を満たさなくなっても達成のまま。
+
+// 目標の種類
+const (
+	KindReach  = "reach"
+	KindSurvey = "survey"
+	KindSink   = "sink"
+	KindReturn = "return"
+)
+
+type Objective struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+	Kind        string `json:"type"`
+	// 目指す位置と半径 (m)。reach, survey, return で使う
+	X      float64 `json:"x,omitempty"`
+	Y      float64 `json:"y,omitempty"`
+	Radius float64 `json:"radius,omitempty"`
+	// 測量する割合 (%)
+	Coverage float64 `json:"coverage,omitempty"`
+	// 沈める船の名前
+	Target string `json:"target,omitempty"`
+}
+
+func (o Objective) point() sim.Point3D {
+	return sim.Point3D{X: o.X, Y: o.Y}
+}
+
+type Mission struct {
+	Name       string      `json:"mission"`
+	Briefing   string      `json:"briefing"`
+	Objectives []Objective `json:"objectives"`
+}
+
+// 任務のファイルを読み込み、書き間違いがないか確かめる
+// 海域の広さは ±extent (m)
+func Load(path string, extent float64) (Mission, error) {
+	var m Mission
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("%s: %v", path, err)
+	}
+	switch problems := m.Problems(extent); len(problems) {
+	case 0:
+	case 1:
+		return m, fmt.Errorf("%s: %s", path, problems[0])
+	default:
+		return m, fmt.Errorf("%s: %s (and %d more problems; run validate-scenario for the full list)", path, problems[0], len(problems)-1)
+	}
+	return m, nil
+}
+
+// 書き間違いをすべて挙げる
+func (m Mission) Problems(extent float64) []string {
+	var problems []string
+	report := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	if m.Name == "" {
+		report("missing mission name")
+	}
+	if len(m.Objectives) == 0 {
+		report("mission %q has no objectives", m.Name)
+	}
+	ids := map[string]bool{}
+	for i, o := range m.Objectives {
+		name := fmt.Sprintf("%q", o.ID)
+		switch {
+		case o.ID == "":
+			name = fmt.Sprint(i + 1)
+			report("objective %s: missing id", name)
+		case ids[o.ID]:
+			report("objective %s: duplicate id", name)
+		}
+		ids[o.ID] = true
+		onMap := math.Abs(o.X) <= extent && math.Abs(o.Y) <= extent
+		switch o.Kind {
+		case KindReach, KindSurvey, KindReturn:
+			if !onMap {
+				report("objective %s: (%.0f, %.0f) is off the map (±%.0f m)", name, o.X, o.Y, extent)
+			}
+			if o.Radius <= 0 {
+				report("objective %s: %s needs a positive radius", name, o.Kind)
+			}
+			if o.Kind == KindSurvey && (o.Coverage <= 0 || o.Coverage > 100) {
+				report("objective %s: coverage %v is outside 1-100%%", name, o.Coverage)
+			}
+		case KindSink:
+			if o.Target == "" {
+				report("objective %s: sink needs a target", name)
+			}
+		default:
+			report("objective %s: unknown type %q", name, o.Kind)
+		}
+	}
+	return problems
+}
+
+// 目標の達成を調べるのに使うゲームの状態
+type World interface {
+	// 自艦の位置
+	Position() sim.Point3D
+	// (x, y) から radius (m) 以内の海底を測量した割合 (%)
+	Surveyed(x, y, radius float64) float64
+	// name という船が沈んだか
+	Sunk(name string) bool
+}
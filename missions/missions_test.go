@@ -0,0 +1,82 @@
+package missions
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// 海域の広さ (main の mapExtent と同じ)
+const testExtent = 30000.0
+
+// 任務のファイルは配られたものをそのまま読むので、壊れていてもパニックせず、書き間違いとして知らせなければならない。
+// 種は ../scenarios のうち任務のファイル (-mission で読むもの)。go test はこの種だけを通し、
+// 壊した入力を試すときは go test ./missions -fuzz FuzzMissionLoad で走らせる。
+func FuzzMissionLoad(f *testing.F) {
+	paths, err := filepath.Glob(filepath.Join("..", "scenarios", "*.json"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	seeded := 0
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		var top map[string]json.RawMessage
+		if json.Unmarshal(data, &top) != nil || top["mission"] == nil {
+			continue
+		}
+		f.Add(data)
+		seeded++
+	}
+	if seeded == 0 {
+		f.Fatal("no mission files to seed from")
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		path := filepath.Join(t.TempDir(), "mission.json")
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		m, err := Load(path, testExtent)
+
+		// 読めた任務には書き間違いがなく、読めなかったのは JSON でないか書き間違いがあるとき
+		var parsed Mission
+		jsonErr := json.Unmarshal(data, &parsed)
+		switch {
+		case err == nil && len(m.Problems(testExtent)) > 0:
+			t.Errorf("loaded a mission with problems %q", m.Problems(testExtent))
+		case err != nil && jsonErr == nil && len(parsed.Problems(testExtent)) == 0:
+			t.Errorf("refused a valid mission: %v", err)
+		}
+	})
+}
+
+func TestLoadReportsProblems(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		ok   bool
+	}{
+		{"valid", `{"mission": "M", "objectives": [{"id": "a", "type": "reach", "x": 1, "y": 2, "radius": 100}]}`, true},
+		{"no name", `{"objectives": [{"id": "a", "type": "sink", "target": "T"}]}`, false},
+		{"no objectives", `{"mission": "M"}`, false},
+		{"off the map", `{"mission": "M", "objectives": [{"id": "a", "type": "reach", "x": 40000, "radius": 100}]}`, false},
+		{"duplicate id", `{"mission": "M", "objectives": [{"id": "a", "type": "sink", "target": "T"}, {"id": "a", "type": "sink", "target": "U"}]}`, false},
+		{"coverage", `{"mission": "M", "objectives": [{"id": "a", "type": "survey", "radius": 100, "coverage": 120}]}`, false},
+		{"unknown type", `{"mission": "M", "objectives": [{"id": "a", "type": "loiter"}]}`, false},
+		{"not json", `mission: M`, false},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		path := filepath.Join(dir, "mission.json")
+		if err := ioutil.WriteFile(path, []byte(tt.json), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(path, testExtent); (err == nil) != tt.ok {
+			t.Errorf("%s: Load error %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
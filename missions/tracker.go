@@ -0,0 +1,113 @@
+package missions
+
+import (
+	"math"
+	"sync"
+
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 目標の今の状態
+type Status struct {
+	Objective
+	Done bool
+	// 目指す位置までの距離 (m)。位置のない目標は NaN
+	Distance float64
+	// 測量した割合 (%)。survey のときだけ
+	Coverage float64
+	// return の目標で、ほかの目標が残っていてまだ戻れない
+	Waiting bool
+}
+
+// 任務の進み具合
+// Update はゲームループから、Statuses は表示から呼ばれる
+type Tracker struct {
+	mission Mission
+
+	mu       sync.Mutex
+	statuses []Status
+}
+
+func NewTracker(m Mission) *Tracker {
+	t := &Tracker{mission: m}
+	for _, o := range m.Objectives {
+		t.statuses = append(t.statuses, Status{Objective: o, Distance: math.NaN()})
+	}
+	return t
+}
+
+func (t *Tracker) Mission() Mission {
+	return t.mission
+}
+
+// 目標の状態を調べ直し、新しく達成した目標を返す
+func (t *Tracker) Update(w World) []Objective {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pos := w.Position()
+	var completed []Objective
+	for i := range t.statuses {
+		s := &t.statuses[i]
+		if s.Kind == KindReach || s.Kind == KindSurvey || s.Kind == KindReturn {
+			s.Distance = sim.HorizontalDistance(pos, s.point())
+		}
+		if s.Done {
+			continue
+		}
+		switch s.Kind {
+		case KindReach:
+			s.Done = s.Distance <= s.Radius
+		case KindSurvey:
+			s.Coverage = w.Surveyed(s.X, s.Y, s.Radius)
+			s.Done = s.Coverage >= s.Objective.Coverage
+		case KindSink:
+			s.Done = w.Sunk(s.Target)
+		}
+		if s.Done {
+			completed = append(completed, s.Objective)
+		}
+	}
+	// 港に戻る目標は、同じ回のうちに達成したほかの目標も数えて調べる
+	others := t.othersDone()
+	for i := range t.statuses {
+		s := &t.statuses[i]
+		if s.Kind != KindReturn || s.Done {
+			continue
+		}
+		s.Waiting = !others
+		if others && s.Distance <= s.Radius {
+			s.Done = true
+			completed = append(completed, s.Objective)
+		}
+	}
+	return completed
+}
+
+// return 以外の目標をすべて達成したか (t.mu を保持した状態で呼ぶ)
+func (t *Tracker) othersDone() bool {
+	for _, s := range t.statuses {
+		if s.Kind != KindReturn && !s.Done {
+			return false
+		}
+	}
+	return true
+}
+
+// 目標の状態 (コピー)
+func (t *Tracker) Statuses() []Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Status{}, t.statuses...)
+}
+
+// すべての目標を達成したか
+func (t *Tracker) Complete() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, s := range t.statuses {
+		if !s.Done {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 航海図
+//
+// 自艦を中心にした平面図に、これまでの航跡 (.)、測量済みの海域の水深、アクティブソーナーの反射
+// (船は @、海底は ~)、海図の書き込みと変針点 (番号の下 1 桁、route.go) を出す。航跡一覧で選んだ航跡は推定位置に T を、
+// 距離がわからなければ方位の線 (o) を出す。自艦が動くと図も動く。
+// 北が上で、1文字の横幅が縮尺の距離になる (文字は縦長なので、縦は1行で横幅の2倍の距離)。
+//
+// 図に出るのは自艦が知っていることだけ (霧の中の海図)。
+//   - 海底は測量した升目だけ。測っていない海は空白のまま
+//   - 距離のわかっている航跡は推定位置に t (選んでいるものは T) を出す。失探した航跡は消えるまで暗く出す
+//   - ソーナーの反射は古くなると暗くなる
+//
+// -debug で起動したときは reveal-chart キーで本当の海底と船の位置 (v) を赤で重ねて出せる (試験用)。
+// risk-map キーで探知される見込みを背景の色で重ねる (riskmap.go)。
+
+const (
+	// 図の大きさ (文字)
+	navMapCols = 31
+	navMapRows = 15
+	// 航跡を記録する間隔 (シミュレーション時間) と、残す点の数
+	trailInterval = 5 * time.Second
+	trailLength   = 720
+	// 縦横の目盛り (+) の間隔 (文字)
+	navGridSpacing = 5
+)
+
+// 縮尺 (1文字あたりの m)
+var navMapScales = []float64{50, 100, 250, 500, 1000, 2500, 5000}
+
+// 古くなった航跡や反射の色
+var staleColor = cell.ColorNumber(244)
+
+type navMap struct {
+	mu      sync.Mutex
+	trail   []sim.Point3D
+	lastFix time.Duration
+	scale   int
+	// -debug で起動したか。本当の海底と船を出しているか
+	debug  bool
+	reveal bool
+	// 探知される見込みを重ねているか
+	risk bool
+}
+
+func newNavMap() *navMap {
+	return &navMap{scale: 3, lastFix: -trailInterval}
+}
+
+// trailInterval ごとに航跡を記録する (シミュレーション時間で進める)
+func (m *navMap) record(now time.Duration, pos sim.Point3D) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if now-m.lastFix < trailInterval {
+		return
+	}
+	m.lastFix = now
+	m.trail = append(m.trail, pos)
+	if len(m.trail) > trailLength {
+		m.trail = m.trail[len(m.trail)-trailLength:]
+	}
+}
+
+// dir が正なら拡大、負なら縮小する
+func (m *navMap) zoom(dir int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.scale = int(math.Max(math.Min(float64(m.scale-dir), float64(len(navMapScales)-1)), 0))
+}
+
+// 今の縮尺の 1 文字の横幅 (m)
+func (m *navMap) cellSize() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return navMapScales[m.scale]
+}
+
+// 本当の海底と船を出すかを切り替える
+func (m *navMap) toggleReveal(events *eventLog) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.debug {
+		events.add(cell.ColorYellow, "[CHART] Ground truth reveal needs -debug.")
+		return
+	}
+	m.reveal = !m.reveal
+	if m.reveal {
+		events.add(cell.ColorRed, "[CHART] Ground truth revealed (debug).")
+	} else {
+		events.add(cell.ColorWhite, "[CHART] Ground truth hidden.")
+	}
+}
+
+// 探知される見込みを重ねるかを切り替える
+func (m *navMap) toggleRisk(events *eventLog) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.risk = !m.risk
+	if m.risk {
+		events.add(cell.ColorCyan, "[CHART] Detection risk overlay on.")
+	} else {
+		events.add(cell.ColorCyan, "[CHART] Detection risk overlay off.")
+	}
+}
+
+// 海図の書き込みの記号
+func (k markKind) symbol() rune {
+	switch k {
+	case markHazard:
+		return '!'
+	case markExclusion:
+		return 'X'
+	case markGrounding:
+		return '#'
+	case markMine:
+		return '*'
+	case markDatum:
+		return 'D'
+	case markNote:
+		return 'N'
+	case markBarrier:
+		return '|'
+	}
+	return '?'
+}
+
+// 艦首方位の矢印
+func headingArrow(deg float64) rune {
+	return []rune("^/>\\v/<\\")[int(math.Floor(sim.NormalizeBearing(deg)/45+0.5))%8]
+}
+
+// 測量した升目の記号
+// 海藻の森と沈船はその記号、ほかは水深 (浅いほど濃い)
+func soundingSymbol(sample surveySample) rune {
+	switch depth := sample.depth; {
+	case sample.bottom == sim.BottomWreck:
+		return 'w'
+	case sample.bottom == sim.BottomKelp:
+		return '"'
+	case depth < 50:
+		return '%'
+	case depth < 150:
+		return '='
+	case depth < 300:
+		return '-'
+	}
+	return ':'
+}
+
+// 図の1文字。bg は背景の色 (重ねていなければ ColorDefault)
+type navCell struct {
+	r     rune
+	color cell.Color
+	bg    cell.Color
+}
+
+// 試験用に重ねる本当の海と船
+type navTruth struct {
+	vessels []vessel
+	seabed  sim.Seabed
+}
+
+// 図を文字の行にする
+// 測量済みの海域は水深や海底の記号で埋める
+// tracks は航跡の一覧、selected は選んでいる航跡の番号。truth は本当の海を出さなければ nil
+// waypoints は着いていない変針点 (最初が次に向かう点)、cursor は変針点のカーソルを出していなければ nil
+// risk は升目の中心で探知される見込み (0〜1)。重ねなければ nil
+func renderNavMap(own sim.Point3D, heading float64, trail []sim.Point3D, tracks []track, selected int, echoes []pingEcho, marks []chartMark, waypoints []waypoint, cursor *sim.Point3D, sounding func(x, y float64) (surveySample, bool), truth *navTruth, risk func(x, y float64) float64, scale float64) [][]navCell {
+	grid := make([][]navCell, navMapRows)
+	cx, cy := navMapCols/2, navMapRows/2
+	// 自艦のいる升目 (海域に固定した升目の番号)
+	ownCol := math.Floor(own.X / scale)
+	ownRow := math.Floor(own.Y / (2 * scale))
+	for j := range grid {
+		grid[j] = make([]navCell, navMapCols)
+		for i := range grid[j] {
+			grid[j][i] = navCell{' ', cell.ColorCyan, cell.ColorDefault}
+			col, row := int(ownCol)+i-cx, int(ownRow)+cy-j
+			x, y := (float64(col)+0.5)*scale, (float64(row)+0.5)*2*scale
+			if col%navGridSpacing == 0 && row%navGridSpacing == 0 {
+				grid[j][i].r = '+'
+			} else if sample, ok := sounding(x, y); ok {
+				grid[j][i].r = soundingSymbol(sample)
+			} else if truth != nil {
+				depth, bottom := truth.seabed.SeabedAt(x, y)
+				grid[j][i].r, grid[j][i].color = soundingSymbol(surveySample{depth: depth, bottom: bottom}), cell.ColorRed
+			}
+			if risk != nil {
+				grid[j][i].bg = riskColor(risk(x, y))
+			}
+		}
+	}
+	// 記号を置いても背景の色は残す
+	plot := func(pos sim.Point3D, r rune, color cell.Color) {
+		i := int(math.Floor(pos.X/scale)-ownCol) + cx
+		j := cy - int(math.Floor(pos.Y/(2*scale))-ownRow)
+		if i >= 0 && i < navMapCols && j >= 0 && j < navMapRows {
+			grid[j][i].r, grid[j][i].color = r, color
+		}
+	}
+	for _, pos := range trail {
+		plot(pos, '.', cell.ColorCyan)
+	}
+	if truth != nil {
+		for _, v := range truth.vessels {
+			plot(v.position, 'v', cell.ColorRed)
+		}
+	}
+	for _, t := range tracks {
+		color := cell.ColorYellow
+		if t.lost {
+			color = staleColor
+		}
+		switch {
+		case t.id == selected && math.IsNaN(t.rng):
+			// 図の端まで方位の線を引く
+			rad := t.bearing * math.Pi / 180
+			for d := 2 * scale; d < float64(navMapCols)*scale; d += scale {
+				plot(sim.Point3D{X: own.X + math.Sin(rad)*d, Y: own.Y + math.Cos(rad)*d}, 'o', color)
+			}
+		case t.id == selected:
+			plot(t.estimate, 'T', color)
+		case !math.IsNaN(t.rng):
+			plot(t.estimate, 't', color)
+		}
+	}
+	for _, e := range echoes {
+		color := cell.ColorCyan
+		if e.faded {
+			color = staleColor
+		}
+		plot(e.position, e.symbol(), color)
+	}
+	for _, m := range marks {
+		plot(sim.Point3D{X: m.X, Y: m.Y}, m.Kind.symbol(), cell.ColorCyan)
+	}
+	// 先の点から描き、次に向かう点を上に重ねる
+	for i := len(waypoints) - 1; i >= 0; i-- {
+		color := cell.ColorCyan
+		if i == 0 {
+			color = cell.ColorGreen
+		}
+		plot(waypoints[i].position(), rune('0'+waypoints[i].Number%10), color)
+	}
+	grid[cy][cx].r, grid[cy][cx].color = headingArrow(heading), cell.ColorCyan
+	if cursor != nil {
+		plot(*cursor, '×', cell.ColorWhite)
+	}
+	return grid
+}
+
+// 航海図パネルの表示
+func navMapPanel(f *frame, env *environment, m *navMap, rt *route, sv *survey, pinger *sonarPinger, tr *traffic) panelText {
+	p := &f.player
+	m.mu.Lock()
+	trail := append([]sim.Point3D{}, m.trail...)
+	scale := navMapScales[m.scale]
+	reveal := m.reveal
+	overlay := m.risk
+	m.mu.Unlock()
+	own := p.Position
+	tracks, id := f.tracks, f.selected
+	var selected *track
+	for i := range tracks {
+		if tracks[i].id == id {
+			selected = &tracks[i]
+		}
+	}
+	var truth *navTruth
+	if reveal {
+		truth = &navTruth{vessels: tr.vessels(), seabed: terrain}
+	}
+
+	var risk func(x, y float64) float64
+	var sensors []riskSensor
+	ownNoise := p.noiseLevel()
+	if overlay {
+		sensors = riskSensors(tracks, own)
+		risk = func(x, y float64) float64 { return detectionRisk(env, sensors, own, ownNoise, x, y) }
+	}
+
+	waypoints, cursor := rt.snapshot()
+
+	var out panelText
+	for _, row := range renderNavMap(own, p.Direction, trail, tracks, id, pinger.echoes(), nearestMarks(f.marks, own), waypoints, cursor, sv.sounding, truth, risk, scale) {
+		// 同じ色の続きはまとめて書く
+		for i := 0; i < len(row); {
+			j := i
+			var b strings.Builder
+			for ; j < len(row) && row[j].color == row[i].color && row[j].bg == row[i].bg; j++ {
+				b.WriteRune(row[j].r)
+			}
+			out.writeOn(b.String(), row[i].color, row[i].bg)
+			i = j
+		}
+		out.write("\n", cell.ColorDefault)
+	}
+	out.write(fmt.Sprintf("1 col = %.0f m  X %.0f Y %.0f\n", scale, own.X, own.Y), cell.ColorDefault)
+	if overlay {
+		here := detectionRisk(env, sensors, own, ownNoise, own.X, own.Y)
+		out.writeOn(riskLine(here, len(sensors))+"\n", cell.ColorWhite, riskColor(here))
+	}
+	if line := routeLine(p, rt); line != "" {
+		out.write(line+"\n", cell.ColorGreen)
+	}
+	if selected != nil {
+		rng := "---"
+		if !math.IsNaN(selected.rng) {
+			rng = fmt.Sprintf("%.1fkm", selected.rng/1000)
+		}
+		out.write(fmt.Sprintf("%s %03.0f° %s\n", selected.designation(), selected.bearing, rng), cell.ColorYellow)
+	}
+	// 海流に流される向きと速さ (偏流)
+	east, north := env.currentAt(own.X, own.Y)
+	set := sim.BearingTo(sim.Point3D{}, sim.Point3D{X: east, Y: north})
+	out.write(fmt.Sprintf("Set %03.0f  Drift %.1f kt\n", set, math.Hypot(east, north)/knot), cell.ColorDefault)
+	out.write(pinger.status(p.Direction)+"\n", cell.ColorDefault)
+	active, sideScan, cells := sv.status()
+	line, color := fmt.Sprintf("Survey OFF  %d cells\n", cells), cell.ColorDefault
+	switch {
+	case active && sideScan:
+		line, color = fmt.Sprintf("Survey ON (side-scan)  %d cells\n", cells), cell.ColorGreen
+	case active:
+		line, color = fmt.Sprintf("Survey ON (fathometer)  %d cells\n", cells), cell.ColorYellow
+	}
+	out.write(line, color)
+	return out
+}
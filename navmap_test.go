@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 既定の縮尺は 1 文字 500 m
+func TestNavMap(t *testing.T) {
+	g := startGame(t)
+	g.advance(time.Second)
+	g.expect("1 col = 500 m")
+	// 出発地点の周りには海流がない
+	g.expect("Drift 0.0 kt")
+	g.typeText("zz")
+	g.advance(time.Second)
+	g.expect("1 col = 100 m")
+	// 拡大の限界
+	g.typeText("zzz")
+	g.advance(time.Second)
+	g.expect("1 col = 50 m")
+	g.typeText("v")
+	g.advance(time.Second)
+	g.expect("1 col = 100 m")
+	// 本当の海と船は -debug で起動したときだけ出せる
+	g.key("?")
+	g.expect("Ground truth reveal needs -debug")
+	// 探知される危険の重ね図
+	g.key("#")
+	g.advance(time.Second)
+	g.expect("Detection risk overlay on.")
+	g.key("#")
+	g.advance(time.Second)
+	g.expect("Detection risk overlay off.")
+}
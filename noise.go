@@ -0,0 +1,81 @@
+package main
+
+import "math"
+
+const (
+	// メインバラストタンクのブロー中の雑音の増加 (dB)
+	ballastBlowNoise = 20.0
+	// ビルジポンプを回しているときの雑音の増加 (dB)
+	bilgePumpNoise = 10.0
+	// 網切りの雑音の増加 (dB)
+	netCuttingNoise = 20.0
+	// シュノーケルの吸気の雑音の増加 (dB)
+	snorkelNoise = 10.0
+)
+
+// 自艦の雑音の音源
+type noiseSource struct {
+	// Machinery パネルに出す名前
+	name  string
+	level float64
+	// 止められる補機 (machinery.go) なら、その補機。ほかは -1
+	equipment equipment
+}
+
+// 音源ごとの雑音 (dB)。足し合わせたものが自艦の放射雑音になる
+// 機関を停止していれば音源は 1 つだけ
+func (p *Player) noiseSources() []noiseSource {
+	if p.MachinerySecured {
+		return []noiseSource{{name: "secured", level: 5, equipment: -1}}
+	}
+	sources := []noiseSource{
+		{name: "prop", level: p.Turbine.Actual * 0.15, equipment: -1},
+		// 流体雑音
+		{name: "flow", level: p.Velocity*0.1 + p.foulingNoise(), equipment: -1},
+		{name: "hotel", level: hotelNoise, equipment: -1},
+	}
+	for e := equipment(0); e < equipmentCount; e++ {
+		if !p.equipmentSecured[e] {
+			sources = append(sources, noiseSource{name: e.String(), level: equipmentNoise[e], equipment: e})
+		}
+	}
+	add := func(name string, level float64) {
+		sources = append(sources, noiseSource{name: name, level: level, equipment: -1})
+	}
+	if p.Blowing() {
+		// 高圧空気の音
+		add("blow", ballastBlowNoise)
+	}
+	if p.PumpsRunning() {
+		add("bilge", bilgePumpNoise)
+	}
+	if p.snorkel {
+		add("snorkel", snorkelNoise)
+	}
+	if p.cuttingNet {
+		// 網を切る金属音
+		add("net", netCuttingNoise)
+	}
+	if p.readiness == readinessQuiet {
+		// 静粛航行では不要な補機を止め、物音を立てない
+		add("quiet", -5)
+	}
+	if p.Bottomed {
+		_, kind := terrain.SeabedAt(p.Position.X, p.Position.Y)
+		if kind.Hard() && p.Velocity > 0.5 {
+			// 岩や沈船を擦る音
+			add("scrape", 15)
+		}
+	}
+	return sources
+}
+
+// 自艦の放射雑音 (dB)
+// 機関を停止して沈座していればほぼ無音になる
+func (p *Player) noiseLevel() float64 {
+	noise := 0.0
+	for _, s := range p.noiseSources() {
+		noise += s.level
+	}
+	return math.Max(noise, 0)
+}
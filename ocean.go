@@ -0,0 +1,186 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/rs0604/explorergame/sim"
+	"github.com/rs0604/explorergame/world"
+)
+
+// 渦と河川水
+//
+// 海域には中規模渦 (直径 10 km 前後) と河川水の張り出しがあり、その付近だけ海流と水温が変わる。
+// 海域全体をゆっくり流れる海流 (world.Terrain.CurrentAt) も同じ海流に重ねる (seaCurrent)。
+// 海流は自艦を流し (sim.World.Current)、水温の変化は変温層の深さを変えるので音の伝わり方も変わる。
+// 暖水渦は時計回りに回って変温層を深くし、冷水渦は反時計回りに回って浅くする。
+// 河川水は冷たく軽い水が表層に薄く広がるので、変温層が浅くなる。
+// どこにあるかは画面に出ないので、XBT で水温を測るか、流され方から見つける。
+
+const (
+	// 海域にある渦の数
+	eddyCount = 4
+	// 渦の半径 (m) の範囲
+	eddyMinRadius = 3000.0
+	eddyMaxRadius = 8000.0
+	// 渦の最大流速 (m/s) の範囲
+	eddyMinSpeed = 0.4
+	eddyMaxSpeed = 1.2
+	// 渦の中心での水温と変温層の深さの変化 (度, m)
+	eddyTemperatureAnomaly = 2.5
+	eddyLayerAnomaly       = 40.0
+	// 河川水の張り出しの長さと幅 (m)、河口での流速 (m/s)
+	outflowLength = 15000.0
+	outflowWidth  = 4000.0
+	outflowSpeed  = 1.0
+	// 河口での水温と変温層の深さの変化 (度, m)
+	outflowTemperatureAnomaly = -3.0
+	outflowLayerAnomaly       = -50.0
+	// 変温層はこれより浅くならない (m)
+	minLayerDepth = 10.0
+)
+
+type oceanFeature interface {
+	// (x, y) での海流 (東向き, 北向き m/s)
+	current(x, y float64) (east, north float64)
+	// (x, y) での海面水温と変温層の深さの変化 (度, m)
+	anomaly(x, y float64) (temperature, layer float64)
+}
+
+// 中規模渦
+type eddy struct {
+	center sim.Point3D
+	radius float64
+	speed  float64
+	warm   bool
+}
+
+// 中心からの距離 r での強さ (中心と外側で 0、半径の半分あたりで最大 1)
+func (e eddy) profile(r float64) float64 {
+	rm := e.radius / 2
+	return r / rm * math.Exp(0.5*(1-(r/rm)*(r/rm)))
+}
+
+func (e eddy) current(x, y float64) (float64, float64) {
+	dx, dy := x-e.center.X, y-e.center.Y
+	r := math.Hypot(dx, dy)
+	if r == 0 || r > 2*e.radius {
+		return 0, 0
+	}
+	v := e.speed * e.profile(r)
+	// 時計回りなら中心から見た方位 +90° の向きに流れる
+	ux, uy := dy/r, -dx/r
+	if !e.warm {
+		ux, uy = -ux, -uy
+	}
+	return ux * v, uy * v
+}
+
+func (e eddy) anomaly(x, y float64) (float64, float64) {
+	r := sim.HorizontalDistance(sim.Point3D{X: x, Y: y}, e.center)
+	f := math.Exp(-(r / e.radius) * (r / e.radius))
+	if e.warm {
+		return eddyTemperatureAnomaly * f, eddyLayerAnomaly * f
+	}
+	return -eddyTemperatureAnomaly * f, -eddyLayerAnomaly * f
+}
+
+// 海域全体の海流。地形と一緒に作られ、水温は変えない
+type seaCurrent struct {
+	terrain *world.Terrain
+}
+
+func (s seaCurrent) current(x, y float64) (float64, float64) {
+	return s.terrain.CurrentAt(x, y)
+}
+
+func (s seaCurrent) anomaly(x, y float64) (float64, float64) {
+	return 0, 0
+}
+
+// 河川水の張り出し
+type outflow struct {
+	mouth   sim.Point3D
+	bearing float64
+}
+
+// 張り出しの中での強さ (河口の中心で 1)
+func (o outflow) strength(x, y float64) float64 {
+	rad := o.bearing * math.Pi / 180
+	dx, dy := x-o.mouth.X, y-o.mouth.Y
+	along := dx*math.Sin(rad) + dy*math.Cos(rad)
+	across := dx*math.Cos(rad) - dy*math.Sin(rad)
+	if along < 0 || along > outflowLength {
+		return 0
+	}
+	return (1 - along/outflowLength) * math.Exp(-math.Pow(across/(outflowWidth/2), 2))
+}
+
+func (o outflow) current(x, y float64) (float64, float64) {
+	v := outflowSpeed * o.strength(x, y)
+	rad := o.bearing * math.Pi / 180
+	return math.Sin(rad) * v, math.Cos(rad) * v
+}
+
+func (o outflow) anomaly(x, y float64) (float64, float64) {
+	f := o.strength(x, y)
+	return outflowTemperatureAnomaly * f, outflowLayerAnomaly * f
+}
+
+// 渦と河川水を海域に置く
+// 渦は出発地点 (原点) にかからない所に置き、河川水は海域の端のどこかから内側に向かって張り出す
+func generateOceanFeatures(rng *rand.Rand) []oceanFeature {
+	var features []oceanFeature
+	for len(features) < eddyCount {
+		e := eddy{
+			center: sim.Point3D{X: (rng.Float64()*2 - 1) * mapExtent * 0.8, Y: (rng.Float64()*2 - 1) * mapExtent * 0.8},
+			radius: eddyMinRadius + rng.Float64()*(eddyMaxRadius-eddyMinRadius),
+			speed:  eddyMinSpeed + rng.Float64()*(eddyMaxSpeed-eddyMinSpeed),
+			warm:   rng.Intn(2) == 0,
+		}
+		if sim.HorizontalDistance(e.center, sim.Point3D{}) < 2.5*e.radius {
+			continue
+		}
+		features = append(features, e)
+	}
+	side := rng.Intn(4)
+	along := (rng.Float64()*2 - 1) * mapExtent * 0.6
+	mouths := []outflow{
+		{mouth: sim.Point3D{X: along, Y: mapExtent}, bearing: 180},
+		{mouth: sim.Point3D{X: mapExtent, Y: along}, bearing: 270},
+		{mouth: sim.Point3D{X: along, Y: -mapExtent}, bearing: 0},
+		{mouth: sim.Point3D{X: -mapExtent, Y: along}, bearing: 90},
+	}
+	return append(features, mouths[side])
+}
+
+// (x, y) での海流 (東向き, 北向き m/s)
+func (e *environment) currentAt(x, y float64) (float64, float64) {
+	var east, north float64
+	for _, f := range e.features {
+		ce, cn := f.current(x, y)
+		east += ce
+		north += cn
+	}
+	return east, north
+}
+
+// (x, y) での海面水温 (度)
+func (e *environment) surfaceTemperatureAt(x, y float64) float64 {
+	t := e.surfaceTemperature
+	for _, f := range e.features {
+		dt, _ := f.anomaly(x, y)
+		t += dt
+	}
+	return t
+}
+
+// (x, y) での変温層の深さ (m)
+func (e *environment) layerDepthAt(x, y float64) float64 {
+	d := e.layerDepth
+	for _, f := range e.features {
+		_, dl := f.anomaly(x, y)
+		d += dl
+	}
+	return math.Max(d, minLayerDepth)
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/mum4k/termdash/widgets/text"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 命令の種類
+type orderKind string
+
+const (
+	// タービン回転数の設定値
+	orderTurbineRpm orderKind = "turbine-rpm"
+	// 舵角
+	orderRudder orderKind = "rudder"
+	// 針路 (度)。舵は取らないので、コンパスを見て針路に合わせる
+	orderCourse orderKind = "course"
+	// ホバリング (1: 開始, 0: 解除)
+	orderHover orderKind = "hover"
+	// 機関の停止 (1: 停止, 0: 再始動)
+	orderSecureMachinery orderKind = "secure-machinery"
+	// 離底
+	orderLiftOff orderKind = "lift-off"
+	// トリム (中立浮力からの差)
+	orderTrim orderKind = "trim"
+	// 潜舵の角度 (度、上げ舵が正)。行き足があるときだけ効く
+	orderPlanes orderKind = "planes"
+	// メインバラストタンクの注水率 (100: 注水して潜航, 0: ブローして浮上)
+	orderBallast orderKind = "ballast"
+	// 音響ビーコンの投下
+	orderDeployBeacon orderKind = "deploy-beacon"
+	// 音響ビーコンへの問い合わせ
+	orderInterrogateBeacons orderKind = "interrogate-beacons"
+	// ノイズメーカー (囮) の発射
+	orderCountermeasure orderKind = "countermeasure"
+	// 自走式デコイの発射
+	orderLaunchDecoy orderKind = "launch-decoy"
+	// 現在位置を危険として海図に書き込む
+	orderMarkHazard orderKind = "mark-hazard"
+	// 即応態勢 (0: 通常航海, 1: 静粛航行, 2: 戦闘配置)
+	orderReadiness orderKind = "readiness"
+	// 総員退艦
+	orderAbandonShip orderKind = "abandon-ship"
+	// 投下式水温計 (XBT) の発射
+	orderLaunchXBT orderKind = "launch-xbt"
+	// 海底の測量 (1: 開始, 0: 終了)
+	orderSurvey orderKind = "survey"
+	// 潜水員による船体の掃除 (1: 開始, 0: 中止)
+	orderCleanHull orderKind = "clean-hull"
+	// スクラムした原子炉の再起動
+	orderReactorRestart orderKind = "reactor-restart"
+	// アクティブソーナーの探信
+	orderPing orderKind = "ping"
+	// 探信の扇の幅 (度, 0 は全周)
+	orderPingSector orderKind = "ping-sector"
+	// ビルジポンプ (1: 運転, 0: 停止)
+	orderBilgePumps orderKind = "bilge-pumps"
+	// シュノーケル (1: 揚げる, 0: 下ろす)
+	orderSnorkel orderKind = "snorkel"
+	// 網切り (1: 開始, 0: 中止)
+	orderCutNet orderKind = "cut-net"
+	// 臨検を次の段階 (呼びかけ・乗艦班の派遣・拿捕) に進める
+	orderBoard orderKind = "board"
+	// 臨検の取りやめ (乗艦班を呼び戻す・船を放す・引き揚げる)
+	orderWithdraw orderKind = "withdraw"
+	// 発射管 (1 から) を次の手順 (装填・注水・前扉の開放) に進める
+	orderPrepareTube orderKind = "prepare-tube"
+	// 発射管 (1 から) の魚雷の発射
+	orderLaunchTorpedo orderKind = "launch-torpedo"
+	// 補機 (1 から) を止める・動かす
+	orderSecureEquipment  orderKind = "secure-equipment"
+	orderRestartEquipment orderKind = "restart-equipment"
+	// 自動操舵の針路保持・速力保持 (1: 入れる, 0: 切る)
+	orderHeadingHold orderKind = "heading-hold"
+	orderSpeedHold   orderKind = "speed-hold"
+	// 航海図の変針点に沿って進む (1: 入れる, 0: 切る)
+	orderFollowRoute orderKind = "follow-route"
+	// 沈船を調べる ROV (1: 出す, 0: 呼び戻す)
+	orderInspectWreck orderKind = "inspect-wreck"
+	// 損傷対処の訓練 (1: 号令をかける, 0: やめる)
+	orderTrainingDrill orderKind = "training-drill"
+	// 横付けした補給港からの補給
+	orderResupply orderKind = "resupply"
+)
+
+// 状況により実行できない命令
+// 命令系統はこれをエラーとして扱わず、理由をイベントログに残す
+type orderRefusedError struct {
+	reason string
+}
+
+func (e orderRefusedError) Error() string { return e.reason }
+
+// 真偽値を命令の値にする
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// 操艦命令
+// ボタンやキー入力、マクロはすべてこの形で命令を出す
+type order struct {
+	Kind  orderKind `json:"kind"`
+	Value float64   `json:"value"`
+}
+
+func (o order) String() string {
+	switch o.Kind {
+	case orderTurbineRpm:
+		return fmt.Sprintf("Turbine rpm %.0f", o.Value)
+	case orderRudder:
+		return fmt.Sprintf("Rudder %+.1f", o.Value)
+	case orderCourse:
+		return fmt.Sprintf("Course %03.0f", sim.NormalizeBearing(o.Value))
+	case orderHover:
+		if o.Value != 0 {
+			return "Hover on"
+		}
+		return "Hover off"
+	case orderSecureMachinery:
+		if o.Value != 0 {
+			return "Secure machinery"
+		}
+		return "Restart machinery"
+	case orderLiftOff:
+		return "Lift off the bottom"
+	case orderTrim:
+		return fmt.Sprintf("Trim %+.1f", o.Value)
+	case orderPlanes:
+		return fmt.Sprintf("Planes %+.1f", o.Value)
+	case orderBallast:
+		if o.Value == 0 {
+			return "Blow main ballast"
+		}
+		if o.Value >= 100 {
+			return "Flood main ballast"
+		}
+		return fmt.Sprintf("Main ballast %.0f%%", o.Value)
+	case orderDeployBeacon:
+		return "Deploy beacon"
+	case orderInterrogateBeacons:
+		return "Interrogate beacons"
+	case orderCountermeasure:
+		return "Launch noisemaker"
+	case orderLaunchDecoy:
+		return "Launch decoy"
+	case orderMarkHazard:
+		return "Mark hazard"
+	case orderReadiness:
+		return readiness(o.Value).String()
+	case orderAbandonShip:
+		return "Abandon ship"
+	case orderLaunchXBT:
+		return "Launch XBT"
+	case orderSurvey:
+		if o.Value != 0 {
+			return "Start survey"
+		}
+		return "Stop survey"
+	case orderCleanHull:
+		if o.Value != 0 {
+			return "Clean the hull"
+		}
+		return "Recall divers"
+	case orderReactorRestart:
+		return "Restart the reactor"
+	case orderPing:
+		return "Active sonar ping"
+	case orderPingSector:
+		return fmt.Sprintf("Ping sector %s", sectorWidthName(o.Value))
+	case orderBilgePumps:
+		if o.Value != 0 {
+			return "Start bilge pumps"
+		}
+		return "Stop bilge pumps"
+	case orderSnorkel:
+		if o.Value != 0 {
+			return "Raise the snorkel"
+		}
+		return "Lower the snorkel"
+	case orderCutNet:
+		if o.Value != 0 {
+			return "Cut the net"
+		}
+		return "Stop cutting the net"
+	case orderBoard:
+		return "Board"
+	case orderWithdraw:
+		return "Withdraw"
+	case orderPrepareTube:
+		return fmt.Sprintf("Make ready tube %.0f", o.Value)
+	case orderLaunchTorpedo:
+		return fmt.Sprintf("Fire tube %.0f", o.Value)
+	case orderSecureEquipment, orderRestartEquipment:
+		verb := "Secure"
+		if o.Kind == orderRestartEquipment {
+			verb = "Restart"
+		}
+		if e, ok := equipmentValue(o.Value); ok {
+			return fmt.Sprintf("%s %s", verb, e)
+		}
+		return fmt.Sprintf("%s equipment %v", verb, o.Value)
+	case orderHeadingHold:
+		if o.Value != 0 {
+			return "Heading hold on"
+		}
+		return "Heading hold off"
+	case orderSpeedHold:
+		if o.Value != 0 {
+			return "Speed hold on"
+		}
+		return "Speed hold off"
+	case orderFollowRoute:
+		if o.Value != 0 {
+			return "Follow route"
+		}
+		return "Leave route"
+	case orderInspectWreck:
+		if o.Value != 0 {
+			return "Inspect wreck"
+		}
+		return "Recall the ROV"
+	case orderTrainingDrill:
+		if o.Value != 0 {
+			return "Training drill"
+		}
+		return "Secure from drill"
+	case orderResupply:
+		return "Resupply"
+	}
+	return fmt.Sprintf("%s %v", o.Kind, o.Value)
+}
+
+// 命令をプレイヤーに反映する
+func (o order) apply(p *Player) error {
+	switch o.Kind {
+	case orderTurbineRpm:
+		if p.MachinerySecured {
+			return orderRefusedError{"machinery is secured"}
+		}
+		if p.OutOfFuel() {
+			return orderRefusedError{"out of fuel"}
+		}
+		if p.Reactor.Scrammed {
+			return orderRefusedError{"reactor is scrammed"}
+		}
+		p.Turbine.Order(o.Value)
+	case orderRudder:
+		p.Rudder.Order(o.Value)
+	case orderCourse:
+		p.Course.Order(o.Value)
+		p.courseOrdered = true
+	case orderBilgePumps:
+		p.BilgePumps = o.Value != 0
+	case orderHover:
+		if o.Value != 0 && p.equipmentSecured[equipTrimPumps] {
+			return orderRefusedError{"trim pumps are secured"}
+		}
+		p.HoverEnabled = o.Value != 0
+		// 命令を受けた時点の深度を保持する
+		p.HoverDepth.Order(p.Depth())
+	case orderSecureMachinery:
+		p.MachinerySecured = o.Value != 0
+		if p.MachinerySecured {
+			p.Turbine.Order(0)
+			p.HoverEnabled = false
+		}
+	case orderLiftOff:
+		if !p.Bottomed {
+			return orderRefusedError{"not on the bottom"}
+		}
+		// 離底の前に機関を再始動する
+		p.MachinerySecured = false
+		p.LiftingOff = true
+	case orderTrim:
+		if p.equipmentSecured[equipTrimPumps] {
+			return orderRefusedError{"trim pumps are secured"}
+		}
+		p.Trim.Order(o.Value)
+	case orderPlanes:
+		p.Planes.Order(o.Value)
+	case orderBallast:
+		p.Ballast.Order(o.Value)
+	case orderReadiness:
+		if o.Value < float64(readinessCruise) || o.Value > float64(readinessBattle) {
+			return fmt.Errorf("unknown readiness %v", o.Value)
+		}
+		p.readiness = readiness(o.Value)
+	case orderReactorRestart:
+		if !p.Reactor.Scrammed {
+			return orderRefusedError{"reactor is not scrammed"}
+		}
+		if !p.Reactor.Restart() {
+			return orderRefusedError{fmt.Sprintf("core too hot (%.0f K)", p.Reactor.CoreTemp)}
+		}
+	case orderAbandonShip:
+		// 退艦は abandonShip が処理する
+		return orderRefusedError{"abandon ship is not available"}
+	default:
+		return fmt.Errorf("unknown order %q", o.Kind)
+	}
+	return nil
+}
+
+// イベントログ (右下のスクロール表示)
+type eventLog struct {
+	t *text.Text
+	// 書いた行を渡す (デブリーフィングの記録)。nil なら渡さない
+	record func(msg string)
+	// 書いた行を渡す (音の合図や時間の圧縮)
+	listeners []func(msg string)
+	// 見出し ("[ALARM]" など) ごとの色 (config.toml)。書いていない見出しは呼び出し側の色
+	colors map[string]cell.Color
+}
+
+func (l *eventLog) add(color cell.Color, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if end := strings.Index(msg, "]"); strings.HasPrefix(msg, "[") && end > 0 {
+		if c, ok := l.colors[msg[:end+1]]; ok {
+			color = c
+		}
+	}
+	stamp := clock.Now().Format("15:04:05")
+	if err := l.t.Write(fmt.Sprintf("[%s] %s\n", stamp, msg), text.WriteCellOpts(cell.FgColor(color))); err != nil {
+		panic(err)
+	}
+	if l.record != nil {
+		l.record(msg)
+	}
+	for _, fn := range l.listeners {
+		fn(msg)
+	}
+}
+
+// 命令を受け付けてプレイヤーに反映する
+// Player 以外を操作する命令は、各サブシステムが handle で処理関数を登録する
+type orderSystem struct {
+	player *Player
+	events *eventLog
+
+	// issueAll が命令を出すときに通す。main で艦の状態の置き場の鍵を持って出すようにする
+	exec func(o order) error
+	// 命令の復唱と遅れ (relay.go)。nil なら命令はすぐに効く
+	relay *orderRelay
+
+	mu        sync.Mutex
+	listeners []func(order)
+	handlers  map[orderKind]func(order) error
+}
+
+func newOrderSystem(p *Player, events *eventLog) *orderSystem {
+	s := &orderSystem{
+		player:   p,
+		events:   events,
+		handlers: map[orderKind]func(order) error{},
+	}
+	s.exec = s.issue
+	return s
+}
+
+// kind の命令を fn で処理するようにする
+func (s *orderSystem) handle(kind orderKind, fn func(order) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[kind] = fn
+}
+
+// 命令が出されるたびに呼ばれる関数を登録する
+func (s *orderSystem) subscribe(fn func(order)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+func (s *orderSystem) issue(o order) error {
+	if s.relay != nil && relayStation(o.Kind) != "" && !s.player.abandoned && !s.player.gameOver {
+		// 配置が復唱するまでは効かない
+		s.relay.pass(o, s.player.crewFatigue)
+		s.events.add(cell.ColorCyan, "[ORDER] %s", o)
+		s.notify(o)
+		return nil
+	}
+	ok, err := s.execute(o)
+	if err != nil || !ok {
+		return err
+	}
+	s.events.add(cell.ColorCyan, "[ORDER] %s", o)
+	s.notify(o)
+	return nil
+}
+
+// 命令を処理する。断られたら理由をイベントログに残して false を返す
+func (s *orderSystem) execute(o order) (bool, error) {
+	s.mu.Lock()
+	handler, ok := s.handlers[o.Kind]
+	s.mu.Unlock()
+	if !ok {
+		handler = func(o order) error { return o.apply(s.player) }
+	}
+	if s.player.abandoned {
+		// 退艦したあとは誰も命令を受けない
+		handler = func(order) error { return orderRefusedError{"ship abandoned"} }
+	} else if s.player.gameOver {
+		handler = func(order) error { return orderRefusedError{"patrol over"} }
+	}
+
+	if err := handler(o); err != nil {
+		if refused, ok := err.(orderRefusedError); ok {
+			s.events.add(cell.ColorYellow, "[ORDER] %s refused: %s", o, refused.reason)
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// 出した命令を subscribe で登録した関数に渡す
+func (s *orderSystem) notify(o order) {
+	s.mu.Lock()
+	listeners := append([]func(order){}, s.listeners...)
+	s.mu.Unlock()
+	for _, fn := range listeners {
+		fn(o)
+	}
+}
+
+// 命令を順番に間隔を空けて実行する
+func (s *orderSystem) issueAll(orders []order, interval time.Duration, done <-chan struct{}) error {
+	if len(orders) == 0 {
+		return nil
+	}
+	if err := s.exec(orders[0]); err != nil {
+		return err
+	}
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+	for _, o := range orders[1:] {
+		select {
+		case <-ticker.C():
+			if err := s.exec(o); err != nil {
+				return err
+			}
+		case <-done:
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// パッシブソーナーの探知一覧
+//
+// 船の放射雑音を聞いて方位を出す。背景雑音に加えて、速く走るほど聴音器に当たる水の流れの雑音が
+// 大きくなり、遠くの船から聞こえなくなる。聞こえている船ごとに、受信レベルから距離を見積もり
+// (どの船も商船と同じ大きさの音を出していると仮定する)、聞き続けるほど類別の確信度が上がる。
+
+const (
+	// 流体雑音 (dB) = flowNoiseBase + flowNoiseSlope * log10(速力 kt)
+	// 5 kt で 58 dB、10 kt で 70 dB、20 kt で 82 dB
+	flowNoiseBase  = 30.0
+	flowNoiseSlope = 40.0
+	// 受信レベルの測定誤差 (dB, 標準偏差)
+	receivedLevelError = 2.0
+	// 距離を見積もる範囲 (m) と刻みの倍率
+	passiveRangeMin  = 100.0
+	passiveRangeMax  = 100000.0
+	passiveRangeStep = 1.05
+	// 類別の確信度 (%) が信号余裕 1 dB・1 秒あたりに上がる量と、聞こえないときに 1 秒あたり下がる量
+	classifyRate  = 0.1
+	classifyDecay = 1.0
+	// この確信度を超えたら艦種を出す (%)
+	classifyThreshold = 60.0
+	// 聞こえなくなってから一覧から消すまでの時間
+	passiveContactTimeout = time.Minute
+)
+
+// 速力 (kt) による流体雑音 (dB)
+func flowNoise(knots float64) float64 {
+	return flowNoiseBase + flowNoiseSlope*math.Log10(math.Max(knots, 1))
+}
+
+// 聴音器に届く雑音 (dB)。背景雑音と流体雑音を電力で足す
+func hydrophoneNoise(ambient, knots float64) float64 {
+	return 10 * math.Log10(math.Pow(10, ambient/10)+math.Pow(10, flowNoise(knots)/10))
+}
+
+// パッシブソーナーの探知
+type passiveContact struct {
+	// 商船の id と、一覧での番号
+	vessel int
+	number int
+	class  string
+	// 最後に聞こえたときの方位・見積もった距離 (m)・信号余裕 (dB)
+	bearing float64
+	rng     float64
+	excess  float64
+	// 類別の確信度 (%)
+	confidence float64
+	heard      time.Time
+	holding    bool
+}
+
+func (c *passiveContact) designation() string {
+	return fmt.Sprintf("S%02d", c.number)
+}
+
+// 確信度が足りなければ UNKNOWN
+func (c *passiveContact) classification() string {
+	if c.confidence < classifyThreshold {
+		return "UNKNOWN"
+	}
+	return strings.ToUpper(c.class)
+}
+
+type passiveSonarArray struct {
+	mu       sync.Mutex
+	contacts []*passiveContact
+	next     int
+	// 最後の聴音の雑音 (dB)
+	noise, flow float64
+	last        time.Time
+}
+
+func newPassiveSonarArray() *passiveSonarArray {
+	return &passiveSonarArray{}
+}
+
+// 受信レベル (dB) から距離を見積もる (m)
+// 船が assumed の音を出しているとして、その方位で伝搬損失が釣り合う距離を探す
+func estimatePassiveRange(env *environment, own sim.Point3D, bearing, received, assumed float64) float64 {
+	rad := bearing * math.Pi / 180
+	for r := passiveRangeMin; r <= passiveRangeMax; r *= passiveRangeStep {
+		pos := sim.Point3D{X: own.X + math.Sin(rad)*r, Y: own.Y + math.Cos(rad)*r, Z: -merchantDraft / 2}
+		if assumed-env.transmissionLoss(own, pos, passiveSonar.frequency) <= received {
+			return r
+		}
+	}
+	return passiveRangeMax
+}
+
+// 1 回の聴音の結果を反映する (sensorSweep から呼ぶ)
+func (a *passiveSonarArray) listen(own sim.Point3D, env *environment, ships []vessel, heard map[int]float64, noise, flow float64, rng *rand.Rand, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	dt := 0.0
+	if !a.last.IsZero() {
+		dt = now.Sub(a.last).Seconds()
+	}
+	a.last = now
+	a.noise, a.flow = noise, flow
+
+	byVessel := map[int]*passiveContact{}
+	for _, c := range a.contacts {
+		c.holding = false
+		byVessel[c.vessel] = c
+	}
+	for _, s := range ships {
+		excess, ok := heard[s.id]
+		if !ok {
+			continue
+		}
+		c := byVessel[s.id]
+		if c == nil {
+			a.next++
+			c = &passiveContact{vessel: s.id, number: a.next, class: s.class}
+			a.contacts = append(a.contacts, c)
+		}
+		received := s.noise - env.transmissionLoss(own, s.hull(), passiveSonar.frequency) + rng.NormFloat64()*receivedLevelError
+		c.bearing = sim.NormalizeBearing(sim.BearingTo(own, s.position) + rng.NormFloat64()*1.5)
+		c.rng = estimatePassiveRange(env, own, c.bearing, received, merchantNoise)
+		c.excess = excess
+		c.confidence = math.Min(c.confidence+math.Max(excess, 1)*classifyRate*dt, 100)
+		c.heard = now
+		c.holding = true
+	}
+
+	remaining := a.contacts[:0]
+	for _, c := range a.contacts {
+		if !c.holding {
+			c.confidence = math.Max(c.confidence-classifyDecay*dt, 0)
+		}
+		if now.Sub(c.heard) < passiveContactTimeout {
+			remaining = append(remaining, c)
+		}
+	}
+	a.contacts = remaining
+}
+
+// 船 id を類別できていればその艦種、できていなければ空
+func (a *passiveSonarArray) classified(id int) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, c := range a.contacts {
+		if c.vessel == id && c.confidence >= classifyThreshold {
+			return c.class
+		}
+	}
+	return ""
+}
+
+// 一覧 (コピー)。聞こえているものを信号余裕の大きい順に、そのあとに聞こえなくなったもの
+func (a *passiveSonarArray) snapshot() (list []passiveContact, noise, flow float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	list = make([]passiveContact, len(a.contacts))
+	for i, c := range a.contacts {
+		list[i] = *c
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].holding != list[j].holding {
+			return list[i].holding
+		}
+		return list[i].excess > list[j].excess
+	})
+	return list, a.noise, a.flow
+}
+
+// パッシブソーナーのパネル
+func passiveSonarPanel(a *passiveSonarArray) panelText {
+	contacts, noise, flow := a.snapshot()
+
+	var out panelText
+	noiseColor := cell.ColorDefault
+	if flow > noise-3 {
+		// 流体雑音で耳がふさがれている
+		noiseColor = cell.ColorYellow
+	}
+	out.write(fmt.Sprintf("Noise %.0f dB  Flow %.0f dB\n", noise, flow), noiseColor)
+	if len(contacts) == 0 {
+		out.write("No passive contacts.\n", cell.ColorYellow)
+	}
+	for _, c := range contacts {
+		color := cell.ColorGreen
+		status := fmt.Sprintf("SE%+3.0f", c.excess)
+		if !c.holding {
+			color = cell.ColorYellow
+			status = "FADE"
+		}
+		line := fmt.Sprintf("%s %03.0f° ~%5.1fkm %-9s %3.0f%% %s\n",
+			c.designation(), c.bearing, c.rng/1000, c.classification(), c.confidence, status)
+		out.write(line, color)
+	}
+	return out
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 止まっていれば流体雑音はほとんどない
+func TestPassiveSonar(t *testing.T) {
+	g := startGame(t)
+	g.advance(2 * time.Second)
+	g.expect("Flow 30 dB")
+	// 増速すると流体雑音で聴音器の雑音が上がる
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.advance(2 * time.Minute)
+	g.expectNot("Flow 30 dB")
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Space で一時停止すると、注水を命じても深度は変わらない
+func TestPause(t *testing.T) {
+	g := startGame(t)
+	g.advance(time.Second)
+	g.key("space")
+	g.expect("*** PAUSED ***")
+	g.expect("[SIM] Paused.")
+	g.key("d")
+	g.expect("[ORDER]")
+	g.advance(30 * time.Second)
+	g.expect("0.0 m")
+	// もう一度押すと再開し、潜航が始まる
+	g.key("space")
+	g.expect("[SIM] Resumed.")
+	g.expectNot("*** PAUSED ***")
+	g.advance(30 * time.Second)
+	g.expectNot("0.0 m")
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 敵の艦長の性格
+//
+// 敵の艦艇は 1 隻ごとに性格を持ち、捜索の仕方・攻撃のしつこさ・乗員の失敗の多さが変わる。
+//   - regular: 標準 (性格を書かなかったとき)
+//   - aggressive: 少し聞こえただけで攻撃に移り、聞こえなくなってもなかなか諦めない。データムのすぐ周りを回る
+//   - cautious: はっきり聞こえるまで攻撃せず、近づいてから撃つ。データムから離れて大きく回り、早く諦める
+//   - green: 未熟な乗員。聞こえても聞き逃すことがあり、聞いた位置の誤差が大きく、すぐ諦める
+//   - elite: 熟練の乗員。位置の誤差が小さく、自艦が逃げられる範囲に合わせて捜索の円を広げ、長く探し続ける
+//
+// シナリオでは enemyPersonality で地形の哨戒区域の敵の性格を、enemies で性格付きの敵を 1 隻ずつ置ける。
+// 港の哨戒艇 (patrolBoats) にも personality を書ける。
+//
+//	"enemyPersonality": "green",
+//	"enemies": [{"name": "Wolverine", "class": "Submarine", "personality": "elite", "route": [{"x": 4000, "y": 9000}, {"x": 9000, "y": 9000}]}]
+
+// 性格を書かなかったときの性格
+const defaultPersonality = "regular"
+
+type enemyPersonality struct {
+	name string
+	// 攻撃に移る信号余裕 (dB)
+	attackMargin float64
+	// 聞こえなくなってから攻撃をやめるまで
+	attackTimeout time.Duration
+	// 最後に聞いてから捜索を続ける時間
+	searchTime time.Duration
+	// 捜索で回る半径 (m)。expanding なら自艦が逃げられる範囲に合わせて広げる
+	searchRadius float64
+	expanding    bool
+	// 魚雷を撃つ距離 (enemyWeaponRange に掛ける)
+	fireRange float64
+	// 聞いた位置の誤差 (datumInitialRadius に掛ける)
+	datumError float64
+	// 聞こえたのに聞き逃す確率
+	missRate float64
+}
+
+var enemyPersonalities = map[string]*enemyPersonality{
+	"regular": {
+		name: "regular", attackMargin: enemyAttackMargin, attackTimeout: enemyAttackTimeout, searchTime: enemySearchTime,
+		searchRadius: enemySearchRadius, fireRange: 1, datumError: 1,
+	},
+	"aggressive": {
+		name: "aggressive", attackMargin: 3, attackTimeout: 3 * time.Minute, searchTime: 15 * time.Minute,
+		searchRadius: 1200, fireRange: 1, datumError: 1,
+	},
+	"cautious": {
+		name: "cautious", attackMargin: 10, attackTimeout: 30 * time.Second, searchTime: 6 * time.Minute,
+		searchRadius: 3500, fireRange: 0.6, datumError: 1,
+	},
+	"green": {
+		name: "green", attackMargin: enemyAttackMargin, attackTimeout: 45 * time.Second, searchTime: 5 * time.Minute,
+		searchRadius: enemySearchRadius, fireRange: 1, datumError: 2.5, missRate: 0.3,
+	},
+	"elite": {
+		name: "elite", attackMargin: 4, attackTimeout: 2 * time.Minute, searchTime: 20 * time.Minute,
+		searchRadius: 1500, expanding: true, fireRange: 0.8, datumError: 0.5,
+	},
+}
+
+// name の性格。空なら標準
+func findPersonality(name string) (*enemyPersonality, bool) {
+	if name == "" {
+		name = defaultPersonality
+	}
+	p, ok := enemyPersonalities[name]
+	return p, ok
+}
+
+// エラーの説明に使う性格の一覧
+func personalityNames() string {
+	names := make([]string, 0, len(enemyPersonalities))
+	for name := range enemyPersonalities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// 最後に聞いてから elapsed 経ったときに回る半径 (m)
+func (p *enemyPersonality) searchRadiusAfter(elapsed time.Duration) float64 {
+	if !p.expanding {
+		return p.searchRadius
+	}
+	return p.searchRadius + datumEvasionSpeed*knot*elapsed.Seconds()
+}
+
+// シナリオで置く敵
+type enemyConfig struct {
+	Name        string        `json:"name"`
+	Class       string        `json:"class"`
+	Personality string        `json:"personality,omitempty"`
+	Route       []harborPoint `json:"route"`
+}
+
+func (e enemyConfig) submarine() bool {
+	return e.Class == "Submarine"
+}
+
+func (e enemyConfig) problems(report func(format string, args ...interface{}), onMap func(x, y float64) bool) {
+	if e.Name == "" {
+		report("enemy: missing name")
+	}
+	if !e.submarine() && !warshipClasses[e.Class] {
+		report("enemy %q: unknown class %q", e.Name, e.Class)
+	}
+	if _, ok := findPersonality(e.Personality); !ok {
+		report("enemy %q: unknown personality %q (%s)", e.Name, e.Personality, personalityNames())
+	}
+	if len(e.Route) == 0 {
+		report("enemy %q has no route", e.Name)
+	}
+	for _, p := range e.Route {
+		if !onMap(p.X, p.Y) {
+			report("enemy %q: route point (%.0f, %.0f) is off the map", e.Name, p.X, p.Y)
+		}
+	}
+}
+
+// シナリオの敵を出す
+func (f *enemyFleet) addScenarioEnemies(enemies []enemyConfig) {
+	for _, c := range enemies {
+		route := make([]sim.Point3D, len(c.Route))
+		for i, p := range c.Route {
+			route[i] = p.point()
+		}
+		personality, _ := findPersonality(c.Personality)
+		f.add(c.Name, c.Class, c.submarine(), route, enemyTorpedoLoad, personality)
+	}
+}
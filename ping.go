@@ -0,0 +1,314 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// アクティブソーナー
+//
+// 探信音 (ピン) を打つと、届いた船と海底の斜面から反射が返る。反射は往復の伝搬時間が
+// 経ってから届き、船の反射は航跡に、どちらも航海図に出る。届くかどうかは伝搬モデルで決まる。
+// ピンは遠くまで聞こえるので、打ってからしばらくは軍艦に聞かれてデータムを作られやすい。
+//
+// 送信の扇 (セクター) を絞ると、扇の中の船と海底からしか反射が返らない代わりに、扇の外の軍艦には
+// 送信音が pingSideLobeLoss だけ小さく聞こえる。扇は海図で選んでいる航跡の方位 (選んでいなければ艦首) に向ける。
+
+const (
+	// 送信機の充電にかかる時間 (シミュレーション時間)
+	pingRecharge = 10 * time.Second
+	// 打ってからこの時間は、軍艦に送信音を聞かれるおそれがある
+	pingExposure = 2 * time.Minute
+	// 反射を航海図に残す時間と、暗く出すまでの時間
+	pingEchoLifetime = 2 * time.Minute
+	pingEchoFadeAge  = time.Minute
+	// 海底の反射を探す方位の間隔 (度) と距離の刻み・上限 (m)
+	pingBeamSpacing = 10.0
+	pingRangeStep   = 100.0
+	pingMaxRange    = 20000.0
+	// 海底がキールからこの高さより浅くなるところを障害として返す (m)
+	pingTerrainMargin = 20.0
+	// 船体と海底の斜面の反射強度 TS (dB)
+	vesselTargetStrength  = 20.0
+	terrainTargetStrength = 10.0
+	// 扇の外に漏れる送信音の小ささ (dB)
+	pingSideLobeLoss = 25.0
+)
+
+// 送信の扇の幅 (度)。0 は全周
+var pingSectorWidths = []float64{0, 120, 60, 30}
+
+// 送信の扇
+type pingSector struct {
+	center float64
+	// 幅 (度)。0 なら全周
+	width float64
+}
+
+func (s pingSector) covers(bearing float64) bool {
+	return s.width == 0 || math.Abs(sim.NormalizeRelative(bearing-s.center)) <= s.width/2
+}
+
+func (s pingSector) String() string {
+	if s.width == 0 {
+		return "omni"
+	}
+	return fmt.Sprintf("%.0f° on %03.0f", s.width, s.center)
+}
+
+// 扇の幅の表示
+func sectorWidthName(width float64) string {
+	if width == 0 {
+		return "omni"
+	}
+	return fmt.Sprintf("%.0f°", width)
+}
+
+// ピンの反射
+type pingEcho struct {
+	bearing float64
+	rng     float64
+	// 反射した位置 (ピンを打った位置から求めたもの)
+	position sim.Point3D
+	// 船からの反射か (false なら海底)
+	contact bool
+	// 届くシミュレーション上の時刻
+	due time.Duration
+	// 古くなったか (echoes で決める)
+	faded bool
+}
+
+type sonarPinger struct {
+	events *eventLog
+	env    *environment
+	// 背景雑音 (dB)
+	noise   func(sim.Point3D) float64
+	vessels func() []vessel
+	tracks  *trackManager
+	rng     *rand.Rand
+
+	mu sync.Mutex
+	// シミュレーション上の時刻
+	now      time.Duration
+	lastPing time.Duration
+	pinged   bool
+	// 打った位置と、最後の反射が戻りうる時刻
+	origin      sim.Point3D
+	listenUntil time.Duration
+	listening   bool
+	pending     []pingEcho
+	received    []pingEcho
+	// 次に打つ扇の幅と、最後に打った扇
+	width  float64
+	sector pingSector
+}
+
+func newSonarPinger(events *eventLog, env *environment, noise func(sim.Point3D) float64, vessels func() []vessel, tm *trackManager, rng *rand.Rand) *sonarPinger {
+	return &sonarPinger{events: events, env: env, noise: noise, vessels: vessels, tracks: tm, rng: rng}
+}
+
+// 往復の伝搬時間
+func echoDelay(rng float64) time.Duration {
+	return time.Duration(2 * rng / soundSpeed * float64(time.Second))
+}
+
+// 扇の幅を変える (orderPingSector の処理)
+func (s *sonarPinger) setWidth(width float64) error {
+	for _, w := range pingSectorWidths {
+		if w == width {
+			s.mu.Lock()
+			s.width = width
+			s.mu.Unlock()
+			return nil
+		}
+	}
+	return orderRefusedError{fmt.Sprintf("no %s sector", sectorWidthName(width))}
+}
+
+// 扇の幅の一覧で次の幅
+func (s *sonarPinger) nextWidth() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, w := range pingSectorWidths {
+		if w == s.width {
+			return pingSectorWidths[(i+1)%len(pingSectorWidths)]
+		}
+	}
+	return 0
+}
+
+// 今打てば向く扇。own は艦首方位
+func (s *sonarPinger) aim(own float64) pingSector {
+	s.mu.Lock()
+	width := s.width
+	s.mu.Unlock()
+	if width == 0 {
+		return pingSector{}
+	}
+	center := own
+	if tr, ok := s.tracks.selectedTrack(); ok {
+		center = tr.bearing
+	}
+	return pingSector{center: center, width: width}
+}
+
+// ピンを打つ (orderPing の処理)
+func (s *sonarPinger) ping(p *Player) error {
+	sector := s.aim(p.Direction)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pinged && s.now-s.lastPing < pingRecharge {
+		return orderRefusedError{"transmitter recharging"}
+	}
+	s.sector = sector
+	s.pinged = true
+	s.lastPing = s.now
+	s.origin = p.Position
+	s.listenUntil = s.now + echoDelay(pingMaxRange)
+	s.listening = true
+	s.received = nil
+	// 艦首のソーナーが傷んでいれば、その分だけ雑音に埋もれる
+	noise := s.noise(p.Position) + p.sonarLoss()
+
+	for _, v := range s.vessels() {
+		if !sector.covers(sim.BearingTo(p.Position, v.position)) {
+			continue
+		}
+		if activeSonar.signalExcess(s.env, p.Position, v.hull(), sonarTarget{strength: vesselTargetStrength}, noise) < 0 {
+			continue
+		}
+		rng := sim.HorizontalDistance(p.Position, v.position)
+		s.pending = append(s.pending, pingEcho{
+			bearing:  sim.BearingTo(p.Position, v.position),
+			rng:      rng,
+			position: v.position,
+			contact:  true,
+			due:      s.now + echoDelay(rng),
+		})
+	}
+	from, to := 0.0, 360.0
+	if sector.width > 0 {
+		from, to = sector.center-sector.width/2, sector.center+sector.width/2
+	}
+	for bearing := from; bearing < to; bearing += pingBeamSpacing {
+		if e, ok := s.terrainEcho(p, sim.NormalizeBearing(bearing), noise); ok {
+			s.pending = append(s.pending, e)
+		}
+	}
+	if sector.width > 0 {
+		s.events.add(cell.ColorYellow, "[SONAR] Sector ping away (%s). Counter-detection risk for %s, mostly inside the sector.", sector, pingExposure)
+		return nil
+	}
+	s.events.add(cell.ColorYellow, "[SONAR] Ping away. Counter-detection risk for %s.", pingExposure)
+	return nil
+}
+
+// bearing の方向で、キールの近くまで盛り上がった海底からの反射 (s.mu を保持した状態で呼ぶ)
+// 届くより遠い海底や、障害のない方向は false
+func (s *sonarPinger) terrainEcho(p *Player, bearing, noise float64) (pingEcho, bool) {
+	rad := bearing * math.Pi / 180
+	for r := pingRangeStep; r <= pingMaxRange; r += pingRangeStep {
+		pos := sim.Point3D{X: p.Position.X + math.Sin(rad)*r, Y: p.Position.Y + math.Cos(rad)*r}
+		seabed, _ := terrain.SeabedAt(pos.X, pos.Y)
+		pos.Z = -seabed
+		if activeSonar.signalExcess(s.env, p.Position, pos, sonarTarget{strength: terrainTargetStrength}, noise) < 0 {
+			return pingEcho{}, false
+		}
+		if seabed <= p.Depth()+pingTerrainMargin {
+			return pingEcho{bearing: bearing, rng: r, position: pos, due: s.now + echoDelay(r)}, true
+		}
+	}
+	return pingEcho{}, false
+}
+
+// シミュレーション上の時刻 now までに届いた反射を取り込む
+func (s *sonarPinger) step(now time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now = now
+	remaining := s.pending[:0]
+	for _, e := range s.pending {
+		if e.due > now {
+			remaining = append(remaining, e)
+			continue
+		}
+		s.received = append(s.received, e)
+		if e.contact {
+			s.tracks.report(s.origin, detection{
+				sensor:  sensorActive,
+				bearing: sim.NormalizeBearing(e.bearing + s.rng.NormFloat64()*sensorActive.bearingSigma()),
+				rng:     e.rng * (1 + s.rng.NormFloat64()*sensorActive.rangeSigma()),
+				at:      clock.Now(),
+			})
+			s.events.add(cell.ColorGreen, "[SONAR] Echo bearing %03.0f, range %.1f km", e.bearing, e.rng/1000)
+		}
+	}
+	s.pending = remaining
+
+	if s.listening && now >= s.listenUntil {
+		s.listening = false
+		contacts, terrainEchoes := 0, 0
+		for _, e := range s.received {
+			if e.contact {
+				contacts++
+			} else {
+				terrainEchoes++
+			}
+		}
+		s.events.add(cell.ColorCyan, "[SONAR] Ping complete: %d contact echoes, bottom on %d bearings.", contacts, terrainEchoes)
+	}
+}
+
+// 航海図に出す反射
+func (s *sonarPinger) echoes() []pingEcho {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var list []pingEcho
+	for _, e := range s.received {
+		if age := s.now - e.due; age < pingEchoLifetime {
+			e.faded = age > pingEchoFadeAge
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// 送信音を聞かれるおそれがあるか。あれば最後に打った扇も返す
+func (s *sonarPinger) exposed() (pingSector, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sector, s.pinged && s.now-s.lastPing < pingExposure
+}
+
+// 軍艦の聴音器が sector に打った送信音を聞けるか
+// ピンは自艦の雑音よりずっと大きいので、遠くからでも聞こえる。扇の外では pingSideLobeLoss だけ小さい
+func pingIntercepted(env *environment, own, sonar sim.Point3D, sector pingSector, noise float64) bool {
+	level := activeSonar.sourceLevel
+	if !sector.covers(sim.BearingTo(own, sonar)) {
+		level -= pingSideLobeLoss
+	}
+	return passiveSonar.signalExcess(env, sonar, own, sonarTarget{sourceLevel: level}, noise) >= 0
+}
+
+// 航海図に出す送信の扇の行
+func (s *sonarPinger) status(own float64) string {
+	sector := s.aim(own)
+	if sector.width == 0 {
+		return "Ping omni"
+	}
+	return fmt.Sprintf("Ping sector %s", sector)
+}
+
+// 航海図での反射の記号
+func (e pingEcho) symbol() rune {
+	if e.contact {
+		return '@'
+	}
+	return '~'
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// ピンを打つと送信機の充電が終わるまで次は打てない
+func TestPing(t *testing.T) {
+	g := startGame(t)
+	g.advance(2 * time.Second)
+	g.key("n")
+	g.expect("Active sonar ping")
+	g.expect("Ping away")
+	g.key("n")
+	g.expect("Active sonar ping refused: transmitter recharging")
+	// 最も遠い反射が戻るまで待つと、結果がまとめて出る
+	g.advance(30 * time.Second)
+	g.expect("Ping complete")
+	// 扇を絞ると、選んだ航跡がなければ艦首に向けて打つ
+	g.key(">")
+	g.expect("Ping sector 120°")
+	g.key("n")
+	g.expect("Sector ping away (120° on")
+	g.advance(30 * time.Second)
+	g.expect("Ping complete")
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 既定の設定
+func TestTorpedoPresets(t *testing.T) {
+	g := startGame(t)
+	g.advance(time.Second)
+	g.expect(">1 straight")
+	g.expect("ceil  20 floor 300")
+	// 2 番管の上限を下げ、速力を上げる
+	g.key("p")
+	g.key("o")
+	g.key(".")
+	g.key("o")
+	g.key("o")
+	g.key(".")
+	g.advance(time.Second)
+	g.expect(">2 straight")
+	g.expect("ceil  30 floor 300 fast")
+	// 下限は上限の 30 m 下までしか上げられない
+	g.key("o")
+	g.key("o")
+	g.key("o")
+	g.key("o")
+	g.typeText(",,,,,,,,,,,,,,,,,,,,,,,,,,,,,,")
+	g.advance(time.Second)
+	g.expect("ceil  30 floor  60 fast")
+}
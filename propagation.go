@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 音波の伝搬モデル
+//
+// 探知できるかどうかを単純な距離ではなく、ソーナー方程式で判定する。
+//
+//	パッシブ: SE = SL - TL - (NL - DI) - DT
+//	アクティブ: SE = SL - 2TL + TS - (NL - DI) - DT
+//
+// SE (信号余裕) が 0 以上なら探知できる。伝搬損失 TL は粗い近似で、
+// 拡散損失、海底反射の損失 (海底の種類による)、吸収、変温層、海面の荒れを考慮する。
+// アクティブで海底近くの標的を探すときは、海底の残響 (岩、海藻、沈船ほど強い) に紛れて探知しにくい。
+
+// 海洋環境
+type environment struct {
+	// 海況 (0: 平穏 ~ 9: 猛烈)
+	seaState int
+	// 変温層の深さ (m)
+	layerDepth float64
+	// 変温層をまたぐときの損失 (dB)
+	layerLoss float64
+	// 深い変温層の深さ (m, 0 ならない) と、またぐときの損失 (dB)。thermocline.go
+	deepLayerDepth float64
+	deepLayerLoss  float64
+	// 海面の水温 (度)
+	surfaceTemperature float64
+	// 渦と河川水 (ocean.go)。ここだけ海流、水温、変温層の深さが変わる
+	features []oceanFeature
+}
+
+func defaultEnvironment() *environment {
+	return &environment{
+		seaState:           3,
+		layerDepth:         80,
+		layerLoss:          10,
+		deepLayerLoss:      6,
+		surfaceTemperature: 18,
+	}
+}
+
+// 背景雑音 (dB)
+// 海が荒れるほど波の音で大きくなる
+func (e *environment) ambientNoise() float64 {
+	return seaAmbientNoise + 3*float64(e.seaState)
+}
+
+// 1回の海底反射で失われる音 (dB)
+func reflectionLoss(b sim.BottomType) float64 {
+	switch b {
+	case sim.BottomRock, sim.BottomWreck:
+		return 0.2
+	case sim.BottomSand:
+		return 0.5
+	case sim.BottomKelp:
+		// 海藻が音を吸う
+		return 1.5
+	}
+	return 1.0
+}
+
+// 海底からこの高さ (m) までの標的は、アクティブでは海底の残響に紛れる
+const reverberationHeight = 50.0
+
+// 海底の残響で上がる検出閾値 (dB)
+func reverberation(b sim.BottomType) float64 {
+	switch b {
+	case sim.BottomSand:
+		return 2
+	case sim.BottomRock:
+		return 6
+	case sim.BottomKelp:
+		return 8
+	case sim.BottomWreck:
+		return 10
+	}
+	return 0
+}
+
+// 吸収係数 (dB/km, Thorp の式, f は kHz)
+func absorption(f float64) float64 {
+	f2 := f * f
+	return 0.11*f2/(1+f2) + 44*f2/(4100+f2) + 2.75e-4*f2 + 0.003
+}
+
+// a と b の間の片道の伝搬損失 (dB)
+func (e *environment) transmissionLoss(a, b sim.Point3D, freqKHz float64) float64 {
+	r := math.Max(math.Sqrt(math.Pow(a.X-b.X, 2)+math.Pow(a.Y-b.Y, 2)+math.Pow(a.Z-b.Z, 2)), 1)
+	mid := sim.Point3D{X: (a.X + b.X) / 2, Y: (a.Y + b.Y) / 2}
+	waterDepth, bottom := terrain.SeabedAt(mid.X, mid.Y)
+
+	// 水深までは球面拡散、それより遠くは海面と海底に挟まれた円筒拡散
+	var loss float64
+	if r <= waterDepth {
+		loss = 20 * math.Log10(r)
+	} else {
+		loss = 20*math.Log10(waterDepth) + 10*math.Log10(r/waterDepth)
+		// 円筒拡散の領域では海底で何度も反射する
+		bounces := (r - waterDepth) / (2 * waterDepth)
+		loss += bounces * reflectionLoss(bottom)
+	}
+
+	loss += absorption(freqKHz) * r / 1000
+
+	// 変温層をまたぐと音が屈折して届きにくい
+	layer := e.layerDepthAt(mid.X, mid.Y)
+	if (-a.Z < layer) != (-b.Z < layer) {
+		loss += e.layerLoss
+	}
+	if d := e.deepLayerDepth; d > 0 && (-a.Z < d) != (-b.Z < d) {
+		loss += e.deepLayerLoss
+	}
+
+	// 海面近くの経路は荒れた海面で散乱する
+	if -a.Z < 30 || -b.Z < 30 {
+		loss += float64(e.seaState)
+	}
+	return loss
+}
+
+// ソーナーなどの音響センサー
+type sensor struct {
+	name string
+	// 使う周波数 (kHz)
+	frequency float64
+	// 指向性利得 DI (dB)
+	directivity float64
+	// 検出閾値 DT (dB)
+	threshold float64
+	// 送信レベル (dB)。0 ならパッシブ
+	sourceLevel float64
+}
+
+var (
+	// 広帯域で長く積分するので、パッシブの検出閾値は負になる
+	passiveSonar = sensor{name: "Passive sonar", frequency: 1, directivity: 20, threshold: -5}
+	activeSonar  = sensor{name: "Active sonar", frequency: 3.5, directivity: 20, threshold: 15, sourceLevel: 220}
+	// 機雷探知ソーナー。周波数が高いので遠くへは届かない
+	mineHuntingSonar = sensor{name: "Mine-hunting", frequency: 100, directivity: 30, threshold: 10, sourceLevel: 210}
+	// ビーコンの応答を受けるトランスポンダー
+	beaconLink = sensor{name: "Beacon link", frequency: 10, directivity: 10, threshold: 5}
+)
+
+// 音響ビーコンの送信レベル (dB)
+const beaconSourceLevel = 180.0
+
+// 予測に使う標的
+type sonarTarget struct {
+	name string
+	// 放射雑音 (パッシブ) または応答の送信レベル (dB)
+	sourceLevel float64
+	// 反射強度 TS (アクティブ, dB)
+	strength float64
+	// 深度 (m)。負の値なら受信側と同じ深度
+	depth float64
+	// 海底にある (機雷など)。depth の代わりにその場の水深を使う
+	onBottom bool
+}
+
+// 信号余裕 (dB)
+// パッシブセンサーなら標的の雑音を、アクティブなら自分の送信音の反射を聞く
+func (s sensor) signalExcess(e *environment, receiver, target sim.Point3D, t sonarTarget, noise float64) float64 {
+	tl := e.transmissionLoss(receiver, target, s.frequency)
+	if s.sourceLevel > 0 {
+		se := s.sourceLevel - 2*tl + t.strength - (noise - s.directivity) - s.threshold
+		if seabed, bottom := terrain.SeabedAt(target.X, target.Y); seabed+target.Z <= reverberationHeight {
+			se -= reverberation(bottom)
+		}
+		return se
+	}
+	return t.sourceLevel - tl - (noise - s.directivity) - s.threshold
+}
+
+// 現在の環境で標的を探知できる最大距離の予測 (m)
+// 受信位置から北へ距離を伸ばしていき、信号余裕が初めて負になる距離を返す
+func (s sensor) predictedRange(e *environment, receiver sim.Point3D, t sonarTarget, noise float64) float64 {
+	const step = 100.0
+	const limit = 100000.0
+	tz := -t.depth
+	if t.depth < 0 {
+		tz = receiver.Z
+	}
+	for r := step; r <= limit; r += step {
+		target := sim.Point3D{X: receiver.X, Y: receiver.Y + r, Z: tz}
+		if t.onBottom {
+			seabed, _ := terrain.SeabedAt(target.X, target.Y)
+			target.Z = -seabed
+		}
+		if s.signalExcess(e, receiver, target, t, noise) < 0 {
+			return r - step
+		}
+	}
+	return limit
+}
+
+// 予測探知距離のパネル
+// 予測には XBT で測った環境を使う
+func propagationPanel(f *frame, bt *bathythermograph, tr *traffic) panelText {
+	p := &f.player
+	predictions := []struct {
+		sensor sensor
+		target sonarTarget
+	}{
+		{passiveSonar, sonarTarget{name: "merchant", sourceLevel: merchantNoise, depth: 5}},
+		{activeSonar, sonarTarget{name: "submarine", strength: 15, depth: -1}},
+		{beaconLink, sonarTarget{name: "beacon", sourceLevel: beaconSourceLevel, depth: -1}},
+		{mineHuntingSonar, sonarTarget{name: "mine", strength: -20, onBottom: true}},
+	}
+
+	env := bt.estimate()
+	noise := hydrophoneNoise(tr.ambientNoiseAt(p.Position), p.Velocity)
+	_, bottom := terrain.SeabedAt(p.Position.X, p.Position.Y)
+	layer := "above"
+	switch {
+	case p.Depth() < env.layerDepth:
+	case env.deepLayerDepth > 0 && p.Depth() < env.deepLayerDepth:
+		layer = "between"
+	default:
+		layer = "below"
+	}
+	deep := ""
+	if env.deepLayerDepth > 0 {
+		deep = fmt.Sprintf("  Deep layer %.0f m", env.deepLayerDepth)
+	}
+
+	var out panelText
+	out.write(fmt.Sprintf("Sea state %d  Layer %.0f m (%s)%s  Bottom %s\n", env.seaState, env.layerDepth, layer, deep, bottom), cell.ColorDefault)
+	line, color := pingEffectivenessLine(env, p.Position, noise)
+	out.write(line+"\n", color)
+	out.write(bt.summary(f.now), cell.ColorDefault)
+	out.write("Predicted detection range:\n", cell.ColorDefault)
+	for _, pr := range predictions {
+		r := pr.sensor.predictedRange(env, p.Position, pr.target, noise)
+		color := cell.ColorGreen
+		if r < 5000 {
+			color = cell.ColorYellow
+		}
+		if r < 1000 {
+			color = cell.ColorRed
+		}
+		out.write(fmt.Sprintf("  %-13s vs %-9s %5.1f km\n", pr.sensor.name, pr.target.name, r/1000), color)
+	}
+	return out
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/rs0604/explorergame/sim"
+)
+
+// どこも同じ水深と底質の海底
+type flatSeabed struct {
+	depth  float64
+	bottom sim.BottomType
+}
+
+func (s flatSeabed) SeabedAt(x, y float64) (float64, sim.BottomType) {
+	return s.depth, s.bottom
+}
+
+// テストの間だけ海底の地形を s にする
+func useSeabed(t *testing.T, s sim.Seabed) {
+	t.Helper()
+	saved := terrain
+	t.Cleanup(func() { terrain = saved })
+	terrain = s
+}
+
+func TestAbsorption(t *testing.T) {
+	tests := []struct {
+		freqKHz float64
+		want    float64
+	}{
+		{0, 0.003},
+		{1, 0.0690},
+		{3.5, 0.2391},
+		{10, 1.1870},
+		{100, 34.0687},
+	}
+	for _, tt := range tests {
+		if got := absorption(tt.freqKHz); math.Abs(got-tt.want) > 1e-3 {
+			t.Errorf("absorption(%v) = %.4f dB/km, want %.4f", tt.freqKHz, got, tt.want)
+		}
+	}
+}
+
+func TestTransmissionLoss(t *testing.T) {
+	tests := []struct {
+		name   string
+		seabed flatSeabed
+		a, b   sim.Point3D
+		// 吸収を除いた損失 (dB)
+		want float64
+		env  func(e *environment)
+	}{
+		{"spherical", flatSeabed{200, sim.BottomMud}, sim.Point3D{Z: -50}, sim.Point3D{Y: 100, Z: -50}, 40, nil},
+		{"at least one metre", flatSeabed{200, sim.BottomMud}, sim.Point3D{Z: -50}, sim.Point3D{Z: -50}, 0, nil},
+		{"out to the water depth", flatSeabed{200, sim.BottomMud}, sim.Point3D{Z: -50}, sim.Point3D{X: 200, Z: -50}, 20 * math.Log10(200), nil},
+		// 200 m までは球面拡散、その先の 10 倍は円筒拡散で 10 dB、海底で 4.5 回反射する
+		{"cylindrical over mud", flatSeabed{200, sim.BottomMud}, sim.Point3D{Z: -50}, sim.Point3D{Y: 2000, Z: -50}, 20*math.Log10(200) + 10 + 4.5*1.0, nil},
+		{"cylindrical over rock", flatSeabed{200, sim.BottomRock}, sim.Point3D{Z: -50}, sim.Point3D{Y: 2000, Z: -50}, 20*math.Log10(200) + 10 + 4.5*0.2, nil},
+		{"cylindrical over kelp", flatSeabed{200, sim.BottomKelp}, sim.Point3D{Z: -50}, sim.Point3D{Y: 2000, Z: -50}, 20*math.Log10(200) + 10 + 4.5*1.5, nil},
+		{"deep water stays spherical", flatSeabed{4000, sim.BottomMud}, sim.Point3D{Z: -50}, sim.Point3D{Y: 2000, Z: -50}, 20 * math.Log10(2000), nil},
+		{"across the layer", flatSeabed{1000, sim.BottomMud}, sim.Point3D{Z: -50}, sim.Point3D{Z: -150}, 40 + 10, nil},
+		{"both under the layer", flatSeabed{1000, sim.BottomMud}, sim.Point3D{Z: -150}, sim.Point3D{Z: -250}, 40, nil},
+		{"across the deep layer", flatSeabed{1000, sim.BottomMud}, sim.Point3D{Z: -150}, sim.Point3D{Z: -350}, 20*math.Log10(200) + 6,
+			func(e *environment) { e.deepLayerDepth = 300 }},
+		{"near the surface", flatSeabed{200, sim.BottomMud}, sim.Point3D{Z: -10}, sim.Point3D{Y: 100, Z: -10}, 40 + 3, nil},
+		{"rough sea", flatSeabed{200, sim.BottomMud}, sim.Point3D{Z: -10}, sim.Point3D{Y: 100, Z: -50}, math.Log10(math.Hypot(100, 40))*20 + 7,
+			func(e *environment) { e.seaState = 7 }},
+		{"calm sea", flatSeabed{200, sim.BottomMud}, sim.Point3D{Z: -10}, sim.Point3D{Y: 100, Z: -10}, 40,
+			func(e *environment) { e.seaState = 0 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useSeabed(t, tt.seabed)
+			e := defaultEnvironment()
+			if tt.env != nil {
+				tt.env(e)
+			}
+			r := math.Max(math.Sqrt(math.Pow(tt.a.X-tt.b.X, 2)+math.Pow(tt.a.Y-tt.b.Y, 2)+math.Pow(tt.a.Z-tt.b.Z, 2)), 1)
+			want := tt.want + absorption(1)*r/1000
+			if got := e.transmissionLoss(tt.a, tt.b, 1); math.Abs(got-want) > 1e-9 {
+				t.Errorf("loss %.3f dB, want %.3f", got, want)
+			}
+			// 向きによらない
+			if got, back := e.transmissionLoss(tt.a, tt.b, 1), e.transmissionLoss(tt.b, tt.a, 1); got != back {
+				t.Errorf("loss %.3f dB one way, %.3f dB back", got, back)
+			}
+		})
+	}
+}
+
+func TestSignalExcess(t *testing.T) {
+	useSeabed(t, flatSeabed{1000, sim.BottomMud})
+	e := defaultEnvironment()
+	receiver := sim.Point3D{Z: -150}
+	target := sim.Point3D{Y: 100, Z: -150}
+	const noise = 70.0
+
+	// パッシブ: SE = SL - TL - (NL - DI) - DT
+	tl := 40 + absorption(passiveSonar.frequency)*0.1
+	if got, want := passiveSonar.signalExcess(e, receiver, target, sonarTarget{sourceLevel: 140}, noise), 140-tl-(noise-20)+5; math.Abs(got-want) > 1e-9 {
+		t.Errorf("passive SE %.3f, want %.3f", got, want)
+	}
+	// アクティブ: SE = SL - 2TL + TS - (NL - DI) - DT
+	tl = 40 + absorption(activeSonar.frequency)*0.1
+	if got, want := activeSonar.signalExcess(e, receiver, target, sonarTarget{strength: 10}, noise), 220-2*tl+10-(noise-20)-15; math.Abs(got-want) > 1e-9 {
+		t.Errorf("active SE %.3f, want %.3f", got, want)
+	}
+}
+
+// 海底近くの標的は、アクティブでは底質に応じた残響に紛れる
+func TestSignalExcessReverberation(t *testing.T) {
+	receiver := sim.Point3D{Z: -150}
+	nearBottom := sim.Point3D{Y: 100, Z: -190}
+	useSeabed(t, flatSeabed{200, sim.BottomMud})
+	clear := activeSonar.signalExcess(defaultEnvironment(), receiver, nearBottom, sonarTarget{strength: 10}, 70)
+	passive := passiveSonar.signalExcess(defaultEnvironment(), receiver, nearBottom, sonarTarget{sourceLevel: 140}, 70)
+	tests := []struct {
+		bottom sim.BottomType
+		want   float64
+	}{
+		{sim.BottomMud, 0},
+		{sim.BottomSand, 2},
+		{sim.BottomRock, 6},
+		{sim.BottomKelp, 8},
+		{sim.BottomWreck, 10},
+	}
+	for _, tt := range tests {
+		useSeabed(t, flatSeabed{200, tt.bottom})
+		e := defaultEnvironment()
+		if got := clear - activeSonar.signalExcess(e, receiver, nearBottom, sonarTarget{strength: 10}, 70); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: reverberation %.2f dB, want %.2f", tt.bottom, got, tt.want)
+		}
+		// パッシブでは残響は関係ない
+		if passiveSonar.signalExcess(e, receiver, nearBottom, sonarTarget{sourceLevel: 140}, 70) != passive {
+			t.Errorf("%s: passive SE depends on reverberation", tt.bottom)
+		}
+	}
+}
+
+// 予測距離は信号余裕が初めて負になる手前の距離で、標的が大きいほど、雑音が小さいほど遠い
+func TestPredictedRange(t *testing.T) {
+	useSeabed(t, flatSeabed{1000, sim.BottomMud})
+	e := defaultEnvironment()
+	receiver := sim.Point3D{Z: -150}
+	tests := []struct {
+		name   string
+		s      sensor
+		target sonarTarget
+		noise  float64
+	}{
+		{"passive", passiveSonar, sonarTarget{sourceLevel: 130, depth: -1}, 70},
+		{"passive, quiet sea", passiveSonar, sonarTarget{sourceLevel: 130, depth: -1}, 60},
+		{"passive, quiet target", passiveSonar, sonarTarget{sourceLevel: 115, depth: -1}, 70},
+		{"active", activeSonar, sonarTarget{strength: 10, depth: -1}, 70},
+		{"mine on the bottom", mineHuntingSonar, sonarTarget{strength: 20, onBottom: true}, 50},
+	}
+	ranges := map[string]float64{}
+	for _, tt := range tests {
+		r := tt.s.predictedRange(e, receiver, tt.target, tt.noise)
+		ranges[tt.name] = r
+		at := func(r float64) sim.Point3D {
+			if tt.target.onBottom {
+				return sim.Point3D{Y: r, Z: -1000}
+			}
+			return sim.Point3D{Y: r, Z: receiver.Z}
+		}
+		if r <= 0 || r >= 100000 {
+			t.Errorf("%s: predicted range %v m", tt.name, r)
+			continue
+		}
+		if se := tt.s.signalExcess(e, receiver, at(r), tt.target, tt.noise); se < 0 {
+			t.Errorf("%s: SE %.2f at the predicted range %v m", tt.name, se, r)
+		}
+		if se := tt.s.signalExcess(e, receiver, at(r+100), tt.target, tt.noise); se >= 0 {
+			t.Errorf("%s: SE %.2f beyond the predicted range %v m", tt.name, se, r)
+		}
+	}
+	if ranges["passive, quiet sea"] <= ranges["passive"] || ranges["passive, quiet target"] >= ranges["passive"] {
+		t.Errorf("predicted ranges %v", ranges)
+	}
+
+	// どこまでも聞こえる標的は上限で打ち切る
+	if r := passiveSonar.predictedRange(e, receiver, sonarTarget{sourceLevel: 300, depth: -1}, 70); r != 100000 {
+		t.Errorf("predicted range of a very loud target %v m, want 100000", r)
+	}
+}
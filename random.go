@@ -0,0 +1,39 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// 乱数の種
+//
+// 乱数を使うサブシステムには、それぞれ専用の *rand.Rand を渡す。どれも -seed の種から
+// 順番に作るので、同じ種と同じ操作で起動すれば同じ結果になる (テスト、リプレイ、不具合の再現用)。
+
+type seededRand struct {
+	seed   int64
+	master *rand.Rand
+	// リプレイの記録・再生 (replay.go)。nil なら包まない
+	replay replayHook
+	made   int
+}
+
+// seed が 0 なら現在時刻を種にする
+func newSeededRand(seed int64) *seededRand {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &seededRand{seed: seed, master: rand.New(rand.NewSource(seed))}
+}
+
+// サブシステム用の乱数を作る
+// 作る順番が変わると系列も変わるので、main では常に同じ順に呼ぶ
+func (s *seededRand) next() *rand.Rand {
+	src := rand.NewSource(s.master.Int63())
+	id := s.made
+	s.made++
+	if s.replay != nil {
+		return rand.New(s.replay.source(id, src.(rand.Source64)))
+	}
+	return rand.New(src)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 兵器の試射場 (-range)
+//
+// 商船も敵もいない海に、止まっている標的船と、曳かれて円を描く標的を出す。発射管の魚雷は何本撃っても減らず、
+// 沈めた標的はしばらくすると元の位置に戻る。撃った魚雷が止まるたびに、航走の記録 (1 km ごとの位置、
+// シーカーが標的を捉えた位置、最も近づいた距離) をイベントログに [RANGE] で出す。
+// 射撃の練習と、魚雷の動きを確かめるために使う。試射場では交戦規則を問わず、戦歴や攻撃の記録にも残さない。
+
+const (
+	// 標的の艦種
+	rangeTargetClass = "Target"
+	// 止まっている標的船の位置 (自艦の初めの位置から北へ, m)
+	rangeHulkDistance = 4000.0
+	// 曳かれる標的が回る円の中心 (自艦の初めの位置から東へ, m)、半径 (m)、速力 (ノット)
+	rangeTowDistance = 6000.0
+	rangeTowRadius   = 1500.0
+	rangeTowSpeed    = 8.0
+	// 曳かれる標的の放射雑音 (dB)。曳船の音
+	rangeTowNoise = 125.0
+	// 沈めた標的が戻るまでの時間
+	rangeRespawn = 30 * time.Second
+)
+
+// 試射場の標的
+type rangeTarget struct {
+	name string
+	// 止まっている標的は位置、曳かれる標的は円の中心
+	home   sim.Point3D
+	towed  bool
+	id     int
+	sunkAt time.Duration
+	afloat bool
+}
+
+type weaponsRange struct {
+	events  *eventLog
+	traffic *traffic
+
+	mu      sync.Mutex
+	targets []*rangeTarget
+	shots   int
+	hits    int
+}
+
+// origin は自艦の初めの位置
+func newWeaponsRange(events *eventLog, tr *traffic, origin sim.Point3D) *weaponsRange {
+	r := &weaponsRange{events: events, traffic: tr}
+	r.targets = []*rangeTarget{
+		{name: "RANGE HULK", home: sim.Point3D{X: origin.X, Y: origin.Y + rangeHulkDistance}},
+		{name: "TOWED TARGET", home: sim.Point3D{X: origin.X + rangeTowDistance, Y: origin.Y}, towed: true},
+	}
+	for _, t := range r.targets {
+		r.place(t)
+	}
+	events.add(cell.ColorCyan, "[RANGE] Weapons range open. Hulk %.1f km north, towed target circling %.1f km east. Unlimited reloads.",
+		rangeHulkDistance/1000, rangeTowDistance/1000)
+	return r
+}
+
+// 標的を位置に就ける (r.mu を保持した状態か、作るときに呼ぶ)
+func (r *weaponsRange) place(t *rangeTarget) {
+	pos, course, speed := t.home, 0.0, 0.0
+	if t.towed {
+		// 円の南の端から時計回りに回る
+		pos.Y -= rangeTowRadius
+		course, speed = 270, rangeTowSpeed
+	}
+	t.id = r.traffic.spawnAt(t.name, rangeTargetClass, hostileFlag, pos, course, speed)
+	t.afloat = true
+	if t.towed {
+		r.traffic.steer(t.id, course, speed, 0, rangeTowNoise)
+	}
+}
+
+// 標的を動かし、沈んだ標的を戻す (シミュレーション時間で進める)
+func (r *weaponsRange) step(now time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, t := range r.targets {
+		v, ok := r.traffic.vessel(t.id)
+		switch {
+		case !ok && t.afloat:
+			t.afloat, t.sunkAt = false, now
+		case !ok && now-t.sunkAt >= rangeRespawn:
+			r.place(t)
+			r.events.add(cell.ColorCyan, "[RANGE] %s back on station.", t.name)
+		case ok && t.towed:
+			r.traffic.steer(t.id, towCourse(t.home, v.position, rangeTowRadius), rangeTowSpeed, 0, rangeTowNoise)
+		}
+	}
+}
+
+// 中心 center・半径 radius の円を時計回りに回る針路
+// 円から外れた分だけ内側か外側へ向ける
+func towCourse(center, pos sim.Point3D, radius float64) float64 {
+	off := (sim.HorizontalDistance(center, pos) - radius) / radius
+	return sim.NormalizeBearing(sim.BearingTo(center, pos) + 90 + math.Max(math.Min(off*90, 45), -45))
+}
+
+// 1本分の航走の記録を出す (fireControl.practice に渡す)
+func (r *weaponsRange) report(s torpedoShot) {
+	r.mu.Lock()
+	r.shots++
+	if s.hit {
+		r.hits++
+	}
+	shots, hits := r.shots, r.hits
+	r.mu.Unlock()
+
+	color, result := cell.ColorYellow, "MISS"
+	if s.hit {
+		color, result = cell.ColorGreen, "HIT"
+	}
+	r.events.add(color, "[RANGE] Shot %d, tube %d at %s: %s. Ran %.1f km in %s. Hits %d of %d.",
+		shots, s.tube+1, s.aimed, result, s.distance/1000, formatMissionTime(s.distance/(s.speed*knot)), hits, shots)
+	if s.acquired != nil {
+		r.events.add(color, "[RANGE]   Acquired %s at %.0f m, %.1f km down the run (%s).",
+			s.acquiredName, s.acquiredRange, sim.HorizontalDistance(s.path[0], *s.acquired)/1000, rangeGrid(*s.acquired))
+	} else {
+		r.events.add(color, "[RANGE]   Seeker never acquired a target.")
+	}
+	if !math.IsInf(s.closest, 1) {
+		r.events.add(color, "[RANGE]   Miss distance %.0f m from %s.", s.closest, s.closestName)
+	}
+	path := make([]string, len(s.path))
+	for i, p := range s.path {
+		path[i] = rangeGrid(p)
+	}
+	r.events.add(color, "[RANGE]   Run path: %s", strings.Join(path, " > "))
+}
+
+// 位置の km 表示。例: "(1.2, -0.4)"
+func rangeGrid(p sim.Point3D) string {
+	return fmt.Sprintf("(%.1f, %.1f)", p.X/1000, p.Y/1000)
+}
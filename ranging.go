@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+)
+
+// 受動測距の道具
+//
+// 音しか聞こえない目標の距離と速力を艦長が自分で求める上級者向けの道具。config.toml の [game] で
+// ranging_tools = true にすると TMA パネルに出る。
+//   - エケルント測距: 自艦の針路か速力を変えた前後の 2 つのレグで方位変化率を測り、
+//     距離 = (レグ 2 の自艦の横の速さ - レグ 1 の横の速さ) / (レグ 1 の方位変化率 - レグ 2 の方位変化率) で求める。
+//     横の速さは視線に直角な向き (右回りが正) の成分。目標が針路・速力を変えないことを前提にしている。
+//     レグごとに針路と速力を保ち、方位変化率の履歴 (trackRateWindow) が新しいレグだけになってから ekelund-leg キーを押す
+//   - ターンカウント: 信号余裕が bladeRateMargin 以上あると、パッシブソーナーが目標の翼数周波数を聞き取る。
+//     類別がわかっていれば、その艦種の翼の数と 1 ノットあたりの回転数から速力を見積もる
+
+const (
+	// 翼数周波数を聞き取るのに要る信号余裕 (dB)
+	bladeRateMargin = 6.0
+	// 翼数周波数の測定誤差 (割合, 標準偏差)
+	bladeRateError = 0.02
+	// 2 つのレグの方位変化率がこれより近ければ距離を出さない (度/分)
+	ekelundMinRateDiff = 0.3
+	// レグ 1 を測ってからこれより経つと測り直す
+	ekelundLegTimeout = 15 * time.Minute
+)
+
+// 受動測距の道具を使うか。main で config.toml から決める
+var rangingTools = false
+
+// 艦種ごとのプロペラ
+type propeller struct {
+	blades int
+	// 1 ノットあたりの軸の回転数 (rpm)
+	turnsPerKnot float64
+}
+
+var propellers = map[string]propeller{
+	"Merchant":      {blades: 4, turnsPerKnot: 6},
+	"Supply":        {blades: 5, turnsPerKnot: 7},
+	"Frigate":       {blades: 5, turnsPerKnot: 9},
+	"Destroyer":     {blades: 5, turnsPerKnot: 8},
+	"Corvette":      {blades: 4, turnsPerKnot: 11},
+	"Submarine":     {blades: 7, turnsPerKnot: 7},
+	patrolBoatClass: {blades: 3, turnsPerKnot: 25},
+}
+
+// 表にない艦種の船のプロペラ
+var defaultPropeller = propeller{blades: 4, turnsPerKnot: 8}
+
+// class の船が speed (m/s) で走るときに聞こえる翼数周波数 (Hz)。測定誤差を含む
+func measureBladeRate(class string, speed float64, rng *rand.Rand) float64 {
+	p, ok := propellers[class]
+	if !ok {
+		p = defaultPropeller
+	}
+	rpm := speed / knot * p.turnsPerKnot
+	return rpm / 60 * float64(p.blades) * (1 + rng.NormFloat64()*bladeRateError)
+}
+
+// ターンカウントの行。例: "TURN CNT 4.50 Hz 5-blade 54 rpm -> SPD 6.0 kt"
+func turnCount(tr *track) string {
+	if tr.bladeRate <= 0 {
+		return "TURN CNT --- (no blade rate)"
+	}
+	p, ok := propellers[tr.class]
+	if !ok {
+		return fmt.Sprintf("TURN CNT %.2f Hz (needs class)", tr.bladeRate)
+	}
+	rpm := tr.bladeRate * 60 / float64(p.blades)
+	return fmt.Sprintf("TURN CNT %.2f Hz %d-blade %.0f rpm -> SPD %.1f kt", tr.bladeRate, p.blades, rpm, rpm/p.turnsPerKnot)
+}
+
+// エケルント測距の 1 つのレグ
+type ekelundLeg struct {
+	track int
+	// 方位変化率 (rad/s, 右回りが正) と自艦の横の速さ (m/s)
+	bearingRate float64
+	across      float64
+	at          time.Time
+}
+
+// 自艦の横の速さ (m/s)。knots と course は自艦の速力と針路、bearing は目標の方位
+func ownAcross(knots, course, bearing float64) float64 {
+	return knots * knot * math.Sin((course-bearing)*math.Pi/180)
+}
+
+// 2 つのレグから求めた距離 (m)。方位変化率が近すぎるか、距離が正にならなければ false
+func ekelundRange(leg1, leg2 ekelundLeg) (float64, bool) {
+	diff := leg1.bearingRate - leg2.bearingRate
+	if math.Abs(diff) < ekelundMinRateDiff*math.Pi/180/60 {
+		return 0, false
+	}
+	r := (leg2.across - leg1.across) / diff
+	return r, r > 0
+}
+
+type ekelundRanging struct {
+	events *eventLog
+
+	mu sync.Mutex
+	// 測ったレグ 1。なければ nil
+	leg1 *ekelundLeg
+	// 最後に求めた距離 (m) と、その航跡・時刻
+	result      float64
+	resultTrack int
+	resultAt    time.Time
+}
+
+func newEkelundRanging(events *eventLog) *ekelundRanging {
+	return &ekelundRanging{events: events}
+}
+
+// 選んでいる航跡のレグを測る。レグ 1 がなければレグ 1 に、あれば距離を求める
+// knots と course は自艦の速力と針路
+func (e *ekelundRanging) mark(tm *trackManager, knots, course float64, now time.Time) {
+	if !rangingTools {
+		e.events.add(cell.ColorYellow, "[TMA] Ranging tools are off (ranging_tools in config.toml).")
+		return
+	}
+	tr, ok := tm.selectedTrack()
+	if !ok {
+		e.events.add(cell.ColorYellow, "[TMA] No track selected.")
+		return
+	}
+	rate, _ := tr.rates()
+	if math.IsNaN(rate) {
+		e.events.add(cell.ColorYellow, "[TMA] %s: bearing rate not settled yet.", tr.designation())
+		return
+	}
+	leg := ekelundLeg{track: tr.id, bearingRate: rate * math.Pi / 180 / 60, across: ownAcross(knots, course, tr.bearing), at: now}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.leg1 == nil || e.leg1.track != tr.id || now.Sub(e.leg1.at) > ekelundLegTimeout {
+		e.leg1 = &leg
+		e.events.add(cell.ColorCyan, "[TMA] Ekelund leg 1 on %s: BRG RATE %+.1f°/min, own across %+.1f kt. Change course, then mark leg 2.",
+			tr.designation(), rate, leg.across/knot)
+		return
+	}
+	r, ok := ekelundRange(*e.leg1, leg)
+	e.leg1 = nil
+	if !ok {
+		e.events.add(cell.ColorYellow, "[TMA] Ekelund on %s: no solution, bearing rates too close. Mark leg 1 again.", tr.designation())
+		return
+	}
+	e.result, e.resultTrack, e.resultAt = r, tr.id, now
+	e.events.add(cell.ColorGreen, "[TMA] Ekelund range on %s: %.1f km", tr.designation(), r/1000)
+}
+
+// 航跡 id についての TMA パネルの行
+func (e *ekelundRanging) status(id int, now time.Time) string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	switch {
+	case e.leg1 != nil && e.leg1.track == id:
+		return fmt.Sprintf("EKELUND leg 1 %.0fs ago, mark leg 2", now.Sub(e.leg1.at).Seconds())
+	case e.resultTrack == id && !e.resultAt.IsZero():
+		return fmt.Sprintf("EKELUND RNG %.1f km (%.0fs ago)", e.result/1000, now.Sub(e.resultAt).Seconds())
+	}
+	return "EKELUND --- (mark leg 1)"
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 停泊中の炉心は冷却材と同じ温度
+func TestReactor(t *testing.T) {
+	g := startGame(t)
+	g.advance(2 * time.Second)
+	g.expect("Reactor Temp: 550 K")
+	g.expect("Rods 0%  Coolant 100%")
+	// 全速で回し続けると炉心が過熱し、やがてスクラムする
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.advance(100 * time.Second)
+	g.expect("Reactor overtemperature")
+	g.advance(60 * time.Second)
+	g.expect("Reactor SCRAM")
+	g.expect("SCRAM - RODS IN")
+	g.key("up")
+	g.expect("Turbine rpm 10 refused: reactor is scrammed")
+	// 炉心が冷えるまで再起動できない
+	g.key("r")
+	g.expect("Restart the reactor refused: core too hot")
+	g.advance(2 * time.Minute)
+	g.expect("Core temperature back to normal")
+	g.key("r")
+	g.expect("Rods 0%  Coolant 100%")
+	// 再起動すればまた回せる
+	g.key("up")
+	g.advance(5 * time.Second)
+	g.expect("Rods 5%  Coolant 100%")
+}
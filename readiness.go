@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/mum4k/termdash/container"
+)
+
+// 即応態勢
+//
+// 通常航海、静粛航行、戦闘配置を切り替える。戦闘配置では見張りが鋭くなり装填も速いが、
+// 乗員は疲れていき、疲労がたまると見張りが鈍る。静粛航行では自艦の雑音が下がる。
+// 通常航海に戻すと疲労は回復する。
+
+type readiness int
+
+const (
+	readinessCruise readiness = iota
+	readinessQuiet
+	readinessBattle
+)
+
+func (r readiness) String() string {
+	switch r {
+	case readinessQuiet:
+		return "QUIET ROUTINE"
+	case readinessBattle:
+		return "BATTLE STATIONS"
+	}
+	return "NORMAL CRUISE"
+}
+
+// 次の態勢 (G キーで順に切り替える)
+func (r readiness) next() readiness {
+	return (r + 1) % 3
+}
+
+// 画面の枠の色
+func (r readiness) color() cell.Color {
+	switch r {
+	case readinessQuiet:
+		return cell.ColorBlue
+	case readinessBattle:
+		return cell.ColorRed
+	}
+	return cell.ColorDefault
+}
+
+// 1分あたりの疲労の増え方 (負なら回復)
+func (r readiness) fatigueRate() float64 {
+	switch r {
+	case readinessQuiet:
+		return 0.3
+	case readinessBattle:
+		return 1.0
+	}
+	return -0.5
+}
+
+// 見張りの鋭さ (パッシブソーナーの信号余裕に足す dB)
+// 疲労 20% ごとに 1 dB 鈍り、ソーナー員の腕前で増減する
+func (p *Player) watchBonus() float64 {
+	bonus := 0.0
+	switch p.readiness {
+	case readinessQuiet:
+		bonus = 1
+	case readinessBattle:
+		bonus = 3
+	}
+	return bonus - p.crewFatigue/20 + p.crew.sonarBonus()
+}
+
+// 装填にかかる時間の倍率。兵装員の腕前の倍率も掛かる
+func (p *Player) reloadFactor() float64 {
+	factor := 1.0
+	switch p.readiness {
+	case readinessQuiet:
+		// 音を立てないよう慎重に扱う
+		factor = 1.3
+	case readinessBattle:
+		factor = 0.6
+	}
+	return factor * p.crew.reloadFactor()
+}
+
+// 乗員の疲労を dt 秒分進める
+func updateFatigue(p *Player, dt float64) {
+	p.crewFatigue = math.Max(math.Min(p.crewFatigue+p.readiness.fatigueRate()*dt/60, 100), 0)
+}
+
+// 画面上部 (ルートの枠) の表示
+// 操作案内やデモ・訓練の状況と、即応態勢をまとめて出す
+type statusBar struct {
+	c *container.Container
+
+	mu        sync.Mutex
+	message   string
+	readiness readiness
+	fatigue   float64
+	paused    bool
+	// 時間の圧縮の倍率
+	timeScale int
+}
+
+func newStatusBar(c *container.Container) *statusBar {
+	return &statusBar{c: c, message: "PRESS Q TO QUIT"}
+}
+
+func (b *statusBar) setMessage(msg string) {
+	b.mu.Lock()
+	b.message = msg
+	b.mu.Unlock()
+	b.update()
+}
+
+func (b *statusBar) setReadiness(r readiness, fatigue float64) {
+	b.mu.Lock()
+	changed := r != b.readiness || math.Floor(fatigue) != math.Floor(b.fatigue)
+	b.readiness, b.fatigue = r, fatigue
+	b.mu.Unlock()
+	if changed {
+		b.update()
+	}
+}
+
+// 一時停止中はタイトルの頭に PAUSED を出し、枠を白にする
+func (b *statusBar) setPaused(paused bool) {
+	b.mu.Lock()
+	b.paused = paused
+	b.mu.Unlock()
+	b.update()
+}
+
+// 倍率が 1 でなければタイトルに TIME x4 などと出す
+func (b *statusBar) setTimeScale(scale int) {
+	b.mu.Lock()
+	changed := scale != b.timeScale
+	b.timeScale = scale
+	b.mu.Unlock()
+	if changed {
+		b.update()
+	}
+}
+
+func (b *statusBar) update() {
+	b.mu.Lock()
+	title := fmt.Sprintf("%s - %s (fatigue %.0f%%)", b.message, b.readiness, b.fatigue)
+	color := b.readiness.color()
+	if b.timeScale > 1 {
+		title = fmt.Sprintf("TIME x%d - %s", b.timeScale, title)
+	}
+	if b.paused {
+		title, color = "*** PAUSED *** "+title, cell.ColorWhite
+	}
+	b.mu.Unlock()
+	if err := b.c.Update("root", container.BorderTitle(title), container.BorderColor(color)); err != nil {
+		panic(err)
+	}
+}
+
+// 乗員の疲労を進め、画面上部の表示を更新する (ゲームループから delay ごとに呼ぶ)
+func readinessTick(p *Player, bar *statusBar, delay time.Duration) {
+	if !simPause.on() {
+		updateFatigue(p, delay.Seconds()*float64(simRate.factor()))
+	}
+	bar.setTimeScale(simRate.factor())
+	bar.setReadiness(p.readiness, p.crewFatigue)
+}
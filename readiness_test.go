@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// G で即応態勢を通常航海 → 静粛航行 → 戦闘配置 → 通常航海と切り替える
+func TestReadiness(t *testing.T) {
+	g := startGame(t)
+	g.advance(time.Second)
+	g.expect("NORMAL CRUISE")
+	g.key("g")
+	g.expect("[ORDER] QUIET ROUTINE")
+	g.advance(time.Second)
+	g.expect("PRESS Q TO QUIT - QUIET ROUTINE")
+	g.key("g")
+	g.advance(time.Second)
+	g.expect("PRESS Q TO QUIT - BATTLE STATIONS")
+	// 戦闘配置を続けると疲労がたまる
+	g.advance(120 * time.Second)
+	g.expect("BATTLE STATIONS (fatigue 2%)")
+	g.key("g")
+	g.advance(time.Second)
+	g.expect("PRESS Q TO QUIT - NORMAL CRUISE")
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mum4k/termdash/terminal/terminalapi"
+)
+
+// asciinema v2 形式のヘッダー
+// https://docs.asciinema.org/manual/asciicast/v2/
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// 描画内容を asciinema の cast ファイルに書き出す端末
+type castTerminal struct {
+	bufferedTerminal
+
+	file  *os.File
+	w     *bufio.Writer
+	start time.Time
+}
+
+func newCastTerminal(base terminalapi.Terminal, path string) (*castTerminal, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &castTerminal{
+		bufferedTerminal: newBufferedTerminal(base, headlessSize),
+		file:             f,
+		w:                bufio.NewWriter(f),
+		start:            time.Now(),
+	}
+
+	header, err := json.Marshal(castHeader{
+		Version:   2,
+		Width:     c.screen.size.X,
+		Height:    c.screen.size.Y,
+		Timestamp: c.start.Unix(),
+		Title:     "ExplorerGame",
+		Env:       map[string]string{"TERM": "xterm-256color", "SHELL": os.Getenv("SHELL")},
+	})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := fmt.Fprintf(c.w, "%s\n", header); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *castTerminal) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	diff, resized, err := c.flushDiff()
+	if err != nil {
+		return err
+	}
+	elapsed := time.Since(c.start).Seconds()
+	if resized {
+		size := fmt.Sprintf("%dx%d", c.screen.size.X, c.screen.size.Y)
+		if err := c.writeEvent(elapsed, "r", size); err != nil {
+			return err
+		}
+	}
+	if len(diff) == 0 {
+		return nil
+	}
+	return c.writeEvent(elapsed, "o", string(diff))
+}
+
+// イベントを1行書き出す: [経過秒, 種別, データ]
+func (c *castTerminal) writeEvent(elapsed float64, kind, data string) error {
+	line, err := json.Marshal([]interface{}{elapsed, kind, data})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.w, "%s\n", line)
+	return err
+}
+
+func (c *castTerminal) Close() {
+	c.mu.Lock()
+	c.w.Flush()
+	c.file.Close()
+	c.mu.Unlock()
+	c.bufferedTerminal.Close()
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 命令の復唱と遅れ
+//
+// config.toml の [game] で order_delay = true にすると、操艦と機関への命令はすぐには効かない。
+//   - 艦長の命令はすぐに [ORDER] で出るが、配置の乗員が反応して復唱 ([ACK]) するまで効かない
+//   - 反応までの時間は relayDelay に乗員の疲労に応じた分を足し、ばらつかせたもの (シミュレーション時間)
+//   - 疲労が mishearFatigue を越えると命令を聞き違えることがあり、聞き違えた値を復唱してその通りに動かす。
+//     艦長は復唱を聞いて命じ直す
+//
+// 武器・センサー・臨検などの命令は今まで通りすぐに効く。
+
+// 命令を遅らせるか。main で config.toml から決める
+var orderDelay = false
+
+const (
+	// 休んだ乗員が命令に反応するまでの時間 (秒)
+	relayDelay = 1.5
+	// 疲労 100% で増える時間 (秒)
+	relayFatigueDelay = 2.5
+	// 反応までの時間のばらつき (割合)
+	relayJitter = 0.25
+	// 聞き違えが起きはじめる疲労 (%)
+	mishearFatigue = 50.0
+	// 疲労 100% での聞き違えの確率
+	mishearMaxChance = 0.15
+)
+
+// 命令を受けて復唱する配置。遅らせない命令なら空
+func relayStation(kind orderKind) string {
+	switch kind {
+	case orderRudder, orderCourse:
+		return "Helm"
+	case orderTrim, orderPlanes, orderBallast, orderHover:
+		return "Planes"
+	case orderTurbineRpm, orderSecureMachinery, orderLiftOff, orderReactorRestart:
+		return "Maneuvering"
+	}
+	return ""
+}
+
+// 聞き違えた命令。聞き違えようのない命令はそのまま返す
+// 舵とトリムと潜舵は左右・上下を取り違え、針路と回転数は 1 桁を取り違える
+func mishear(o order, rng *rand.Rand) order {
+	step := 10.0
+	if rng.Intn(2) == 0 {
+		step = -step
+	}
+	switch o.Kind {
+	case orderRudder, orderTrim, orderPlanes:
+		o.Value = -o.Value
+	case orderCourse:
+		o.Value = sim.NormalizeBearing(o.Value + step)
+	case orderTurbineRpm:
+		if o.Value+step < 0 {
+			step = -step
+		}
+		o.Value += step
+	}
+	return o
+}
+
+type relayedOrder struct {
+	// 配置の乗員が聞いた命令
+	heard order
+	// 復唱するまでの残り (秒)
+	remaining float64
+}
+
+type orderRelay struct {
+	rng *rand.Rand
+
+	mu      sync.Mutex
+	pending []relayedOrder
+}
+
+func newOrderRelay(rng *rand.Rand) *orderRelay {
+	return &orderRelay{rng: rng}
+}
+
+// 命令を配置に伝える。fatigue は乗員の疲労 (%)
+func (r *orderRelay) pass(o order, fatigue float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delay := (relayDelay + relayFatigueDelay*fatigue/100) * (1 + relayJitter*(2*r.rng.Float64()-1))
+	chance := math.Max(fatigue-mishearFatigue, 0) / (100 - mishearFatigue) * mishearMaxChance
+	if r.rng.Float64() < chance {
+		o = mishear(o, r.rng)
+	}
+	r.pending = append(r.pending, relayedOrder{heard: o, remaining: delay})
+}
+
+// dt 秒進め、復唱する番になった命令を出した順に返す
+func (r *orderRelay) due(dt float64) []order {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var due []order
+	kept := r.pending[:0]
+	for _, p := range r.pending {
+		p.remaining -= dt
+		if p.remaining <= 0 {
+			due = append(due, p.heard)
+		} else {
+			kept = append(kept, p)
+		}
+	}
+	r.pending = kept
+	return due
+}
+
+// 伝えた命令を復唱させて実行する (ゲームループのタイマーから呼ぶ)
+func (s *orderSystem) relayStep(dt float64) error {
+	if s.relay == nil {
+		return nil
+	}
+	for _, o := range s.relay.due(dt) {
+		ok, err := s.execute(o)
+		if err != nil {
+			return err
+		}
+		if ok {
+			s.events.add(cell.ColorCyan, "[ACK] %s: %s, aye.", relayStation(o.Kind), o)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/mum4k/termdash/keyboard"
+)
+
+// リプレイ
+//
+// -record-replay file で遊ぶと、ゲームループの刻み (loop.go) ごとに入力と乱数の値をファイルに書き出す。
+// -replay file で起動すると、記録したときのオプションと乱数の種でシミュレーションをやり直し、
+// 同じ刻みで同じ入力を与え、乱数も記録した値を返すので、同じ哨戒が画面に再現される。
+//   - 入力はキー (キー割り当ての操作)、ボタン、マクロの命令。それぞれ出した次の刻みの初めに与え直す
+//   - 記録と再生のあいだは時計を実時間ではなく刻みで進める (loopInterval ごとに偽の時計を進める)。
+//     処理が遅れても刻みの数で時刻が決まるので、壁時計で動く仕組みも同じように動く
+//   - 記録した乱数と違う刻みで乱数を引いたら、再現がずれたとしてイベントログに 1 度だけ出す
+//   - 再生中のキーは Q (終了) だけを受け付ける。デモモード、教官席、オートセーブからの再開は使わない
+//
+// ファイルは gzip で圧縮した JSON Lines。1 行目がヘッダー、以降が刻みごとの記録。
+//
+//	{"version":1,"seed":42,"start":"2026-10-16T21:04:05Z","args":["-scenario=harbor.json"]}
+//	{"tick":120,"key":110}
+//	{"tick":120,"rng":[[3,5577006791947779410],[3,8674665223082153551]]}
+//	{"tick":9000,"end":true}
+
+// リプレイファイルの版
+const replayVersion = 1
+
+// 記録したときのオプションのうち、再生で使わない表示や記録のためのもの
+var replayDisplayFlags = map[string]bool{
+	"record-replay": true,
+	"replay":        true,
+	"record":        true,
+	"web":           true,
+	"web-public":    true,
+	"headless":      true,
+	"low-bandwidth": true,
+	"backend":       true,
+	"debug":         true,
+	"log-level":     true,
+	"seed":          true,
+	"telemetry":     true,
+}
+
+type replayHeader struct {
+	Version int       `json:"version"`
+	Seed    int64     `json:"seed"`
+	Start   time.Time `json:"start"`
+	Args    []string  `json:"args"`
+}
+
+// 刻みごとの記録
+type replayEntry struct {
+	Tick   int64         `json:"tick"`
+	Key    *keyboard.Key `json:"key,omitempty"`
+	Button string        `json:"button,omitempty"`
+	Order  *order        `json:"order,omitempty"`
+	// 乱数の源の番号と引いた値
+	Rng [][2]int64 `json:"rng,omitempty"`
+	End bool       `json:"end,omitempty"`
+}
+
+// ゲームループと乱数の源からの呼び出し。記録と再生で中身が違う
+type replayHook interface {
+	// 刻み n の初めと終わりに、艦の状態の置き場の鍵を持って呼ぶ
+	beginTick(n int64, p *Player)
+	endTick(n int64)
+	// id 番目に作った乱数の源を包む
+	source(id int, src rand.Source64) rand.Source
+}
+
+// 再生で使うオプションを並べる (flag.Parse のあとに呼ぶ)
+func replayArgs() []string {
+	var args []string
+	flag.Visit(func(f *flag.Flag) {
+		if !replayDisplayFlags[f.Name] {
+			args = append(args, fmt.Sprintf("-%s=%s", f.Name, f.Value))
+		}
+	})
+	return args
+}
+
+// 記録
+type replayRecorder struct {
+	mu   sync.Mutex
+	file *os.File
+	gz   *gzip.Writer
+	enc  *json.Encoder
+	// 次の入力と乱数が属する刻みと、まだ書いていない乱数
+	tick  int64
+	draws []replayDraw
+}
+
+type replayDraw struct {
+	tick  int64
+	value [2]int64
+}
+
+func newReplayRecorder(path string, h replayHeader) (*replayRecorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(f)
+	r := &replayRecorder{file: f, gz: gz, enc: json.NewEncoder(gz)}
+	if err := r.enc.Encode(h); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// 入力を記録する。入力は次の刻みの初めに与え直す
+func (r *replayRecorder) write(e replayEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e.Tick = r.tick
+	r.enc.Encode(e)
+}
+
+func (r *replayRecorder) key(k keyboard.Key) {
+	r.write(replayEntry{Key: &k})
+}
+
+func (r *replayRecorder) button(name string) {
+	r.write(replayEntry{Button: name})
+}
+
+func (r *replayRecorder) order(o order) {
+	r.write(replayEntry{Order: &o})
+}
+
+func (r *replayRecorder) beginTick(int64, *Player) {}
+
+// 刻みまでに引いた乱数を書き出し、以降を次の刻みのものにする
+func (r *replayRecorder) endTick(n int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.flush()
+	r.tick = n + 1
+}
+
+// r.mu を保持した状態で呼ぶ
+func (r *replayRecorder) flush() {
+	for len(r.draws) > 0 {
+		e := replayEntry{Tick: r.draws[0].tick}
+		i := 0
+		for ; i < len(r.draws) && r.draws[i].tick == e.Tick; i++ {
+			e.Rng = append(e.Rng, r.draws[i].value)
+		}
+		r.enc.Encode(e)
+		r.draws = r.draws[i:]
+	}
+}
+
+func (r *replayRecorder) source(id int, src rand.Source64) rand.Source {
+	return &recordedSource{src: src, id: id, r: r}
+}
+
+func (r *replayRecorder) draw(id int, v int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.draws = append(r.draws, replayDraw{tick: r.tick, value: [2]int64{int64(id), v}})
+}
+
+// 終わりの印を書いて閉じる
+func (r *replayRecorder) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.flush()
+	r.enc.Encode(replayEntry{Tick: r.tick, End: true})
+	if err := r.gz.Close(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+// 引いた値を記録する乱数の源
+type recordedSource struct {
+	src rand.Source64
+	id  int
+	r   *replayRecorder
+}
+
+func (s *recordedSource) Int63() int64 {
+	v := s.src.Int63()
+	s.r.draw(s.id, v)
+	return v
+}
+
+func (s *recordedSource) Uint64() uint64 {
+	v := s.src.Uint64()
+	s.r.draw(s.id, int64(v))
+	return v
+}
+
+func (s *recordedSource) Seed(seed int64) { s.src.Seed(seed) }
+
+// 再生
+type replayPlayer struct {
+	header replayHeader
+	events *eventLog
+	// 入力を与える (main で決める)
+	apply func(p *Player, e replayEntry)
+
+	mu     sync.Mutex
+	tick   int64
+	inputs []replayEntry
+	// 乱数の源ごとの記録した値
+	draws map[int][]replayDraw
+	end   int64
+	// ずれと終わりを知らせたか
+	diverged bool
+	ended    bool
+}
+
+// path のリプレイを読む
+func loadReplay(path string) (*replayPlayer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	dec := json.NewDecoder(bufio.NewReader(gz))
+	rp := &replayPlayer{draws: map[int][]replayDraw{}, end: -1}
+	if err := dec.Decode(&rp.header); err != nil {
+		return nil, fmt.Errorf("%s: header: %v", path, err)
+	}
+	if rp.header.Version != replayVersion {
+		return nil, fmt.Errorf("%s: replay version %d, expected %d", path, rp.header.Version, replayVersion)
+	}
+	for {
+		var e replayEntry
+		if err := dec.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		switch {
+		case e.End:
+			rp.end = e.Tick
+		case len(e.Rng) > 0:
+			for _, d := range e.Rng {
+				id := int(d[0])
+				rp.draws[id] = append(rp.draws[id], replayDraw{tick: e.Tick, value: d})
+			}
+		default:
+			rp.inputs = append(rp.inputs, e)
+		}
+	}
+	if rp.end < 0 {
+		return nil, fmt.Errorf("%s: truncated replay (no end marker)", path)
+	}
+	return rp, nil
+}
+
+// 刻み n で与える入力を与える
+func (rp *replayPlayer) beginTick(n int64, p *Player) {
+	rp.mu.Lock()
+	rp.tick = n
+	var due []replayEntry
+	for len(rp.inputs) > 0 && rp.inputs[0].Tick <= n {
+		due = append(due, rp.inputs[0])
+		rp.inputs = rp.inputs[1:]
+	}
+	announce := n >= rp.end && !rp.ended
+	rp.ended = rp.ended || announce
+	rp.mu.Unlock()
+
+	for _, e := range due {
+		rp.apply(p, e)
+	}
+	if announce {
+		rp.events.add(cell.ColorMagenta, "[REPLAY] End of recording at tick %d. Press Q to quit.", n)
+	}
+}
+
+func (rp *replayPlayer) endTick(int64) {}
+
+func (rp *replayPlayer) source(id int, src rand.Source64) rand.Source {
+	return &replayedSource{src: src, id: id, rp: rp}
+}
+
+// id の源の次の値。記録が尽きていれば false
+func (rp *replayPlayer) next(id int) (int64, bool) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	queue := rp.draws[id]
+	if len(queue) == 0 {
+		return 0, false
+	}
+	d := queue[0]
+	rp.draws[id] = queue[1:]
+	if d.tick != rp.tick && !rp.diverged && rp.events != nil {
+		rp.diverged = true
+		rp.events.add(cell.ColorRed, "[REPLAY] Diverged at tick %d: random draw recorded at tick %d.", rp.tick, d.tick)
+	}
+	return d.value[1], true
+}
+
+// 記録した値を返す乱数の源。記録が尽きたら元の源から引く
+type replayedSource struct {
+	src rand.Source64
+	id  int
+	rp  *replayPlayer
+}
+
+func (s *replayedSource) Int63() int64 {
+	if v, ok := s.rp.next(s.id); ok {
+		return v
+	}
+	return s.src.Int63()
+}
+
+func (s *replayedSource) Uint64() uint64 {
+	if v, ok := s.rp.next(s.id); ok {
+		return uint64(v)
+	}
+	return s.src.Uint64()
+}
+
+func (s *replayedSource) Seed(seed int64) { s.src.Seed(seed) }
+
+// 記録と再生のあいだ、実時間の loopInterval ごとに偽の時計を loopInterval 進める
+func pumpClock(ctx context.Context, fc *fakeClock) {
+	ticker := time.NewTicker(loopInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			fc.Advance(loopInterval)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
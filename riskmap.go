@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 探知される危険の重ね図
+//
+// risk-map キーで、航海図の升目ごとに自艦がそこにいたら探知される見込みを背景の色で重ねる (緑 < riskLow ≤ 黄 < riskHigh ≤ 赤)。
+// 見込みは自艦が知っていることだけから出す。敵の聴音器とみなすのは、距離のわかっている失探していない航跡のうち、
+// 軍艦か類別のついていないもの。航跡の推定位置から、今の雑音と深度の自艦を聞いたときの信号余裕を伝搬モデル
+// (水深と海底はその場の地形) で求め、確率に直して聴音器ごとに重ねる。潜望鏡深度より浅ければ、水上の船から目視される範囲も赤にする。
+// 描くたびに求め直すので、航跡が増えたり動いたり、自艦が速力を上げたりするとすぐ図に表れる。航路を引くときの目安にする。
+
+const (
+	// 見込みの色の境目
+	riskLow  = 0.1
+	riskHigh = 0.5
+	// 信号余裕を確率に直すときの幅 (dB)。信号余裕 0 で 50%
+	riskSpread = 3.0
+	// 水上の船の聴音器の深さ (m) と、見込みに使う敵の速力 (ノット)
+	riskSonarDepth = 10.0
+	riskSonarSpeed = 10.0
+)
+
+// 背景の色
+var (
+	riskLowColor    = cell.ColorNumber(22)
+	riskMediumColor = cell.ColorNumber(58)
+	riskHighColor   = cell.ColorNumber(52)
+)
+
+// 見込みに使う敵の聴音器
+type riskSensor struct {
+	position sim.Point3D
+	// 目視されうるか (水上の船)
+	surface bool
+}
+
+// 航跡から敵の聴音器を拾う
+// own は自艦の位置。潜水艦は深さがわからないので、自艦と同じ深さにいるとみなす
+func riskSensors(tracks []track, own sim.Point3D) []riskSensor {
+	var sensors []riskSensor
+	for _, t := range tracks {
+		if t.lost || math.IsNaN(t.rng) || t.class != "" && !warshipClasses[t.class] {
+			continue
+		}
+		s := riskSensor{position: t.estimate, surface: t.class != "Submarine"}
+		s.position.Z = -riskSonarDepth
+		if !s.surface {
+			s.position.Z = own.Z
+		}
+		sensors = append(sensors, s)
+	}
+	return sensors
+}
+
+// 自艦が深度 own.Z のまま (x, y) にいたら探知される見込み (0〜1)
+func detectionRisk(env *environment, sensors []riskSensor, own sim.Point3D, ownNoise, x, y float64) float64 {
+	target := sim.Point3D{X: x, Y: y, Z: own.Z}
+	source := sonarTarget{sourceLevel: ownNoise + ownShipSourceOffset}
+	noise := hydrophoneNoise(env.ambientNoise(), riskSonarSpeed)
+	missed := 1.0
+	for _, s := range sensors {
+		if s.surface && -own.Z <= periscopeDepth && sim.HorizontalDistance(s.position, target) <= env.sightingRange() {
+			return 1
+		}
+		se := passiveSonar.signalExcess(env, s.position, target, source, noise)
+		missed *= 1 - 1/(1+math.Exp(-se/riskSpread))
+	}
+	return 1 - missed
+}
+
+// 見込みの背景の色
+func riskColor(risk float64) cell.Color {
+	switch {
+	case risk >= riskHigh:
+		return riskHighColor
+	case risk >= riskLow:
+		return riskMediumColor
+	}
+	return riskLowColor
+}
+
+// 航海図の下に出す今の位置での見込み。例: "Risk 35% here (2 sensors)"
+func riskLine(risk float64, sensors int) string {
+	if sensors == 0 {
+		return "Risk: no known sensors"
+	}
+	return fmt.Sprintf("Risk %.0f%% here (%d sensors)", risk*100, sensors)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+)
+
+// 交戦規則 (ROE)
+//
+// 船にはそれぞれ船籍があり、敵国、自国、中立国のどれかに属する。船籍は潜望鏡で目視したときに
+// 初めてわかり (旗と船体の標記)、航跡にはそれまで識別されていない (----) と出る。
+// 中立国や自国と識別した航跡には撃てず、識別していない目標に撃つには時間内にもう一度命じる。
+// 中立国や自国の船を沈めると評判が下がり、戦歴 (campaign.json) に記録されて哨戒をまたいで残る。
+// 評判が reputationTightROE を下回ると、司令部は識別していない目標への発射を認めなくなる。
+// 敵の船を沈めると評判は少しずつ戻る。
+
+// 敵国と自国の船籍
+const (
+	hostileFlag  = "Red"
+	friendlyFlag = "Blue"
+)
+
+// 航路を通る商船の船籍。どれも中立国
+var neutralFlags = []string{"Panama", "Liberia", "Marshall Islands", "Malta", "Norway", "Greece"}
+
+const (
+	// 評判の初期値 (最大)
+	reputationStart = 100.0
+	// 沈めた船の側による評判の増減
+	neutralSunkPenalty  = 25.0
+	friendlySunkPenalty = 50.0
+	hostileSunkCredit   = 10.0
+	// 評判がこれを下回ると、識別していない目標には撃てない
+	reputationTightROE = 50.0
+	// 識別していない目標への発射を確認するまでの時間
+	roeConfirmWindow = 5 * time.Second
+)
+
+// 戦歴に残す船を沈めた結果
+const (
+	outcomeHostileSunk  = "hostile-sunk"
+	outcomeNeutralSunk  = "neutral-sunk"
+	outcomeFriendlySunk = "friendly-sunk"
+)
+
+// 船籍の属する側
+type side int
+
+const (
+	sideUnknown side = iota
+	sideHostile
+	sideNeutral
+	sideFriendly
+)
+
+// 航跡一覧での略号
+func (s side) String() string {
+	switch s {
+	case sideHostile:
+		return "HOST"
+	case sideNeutral:
+		return "NEUT"
+	case sideFriendly:
+		return "FRND"
+	}
+	return "----"
+}
+
+func (s side) word() string {
+	switch s {
+	case sideHostile:
+		return "hostile"
+	case sideNeutral:
+		return "neutral"
+	case sideFriendly:
+		return "friendly"
+	}
+	return "unidentified"
+}
+
+// 船籍 flag の属する側。知らない船籍は sideUnknown
+func flagSide(flag string) side {
+	switch flag {
+	case hostileFlag:
+		return sideHostile
+	case friendlyFlag:
+		return sideFriendly
+	}
+	for _, f := range neutralFlags {
+		if f == flag {
+			return sideNeutral
+		}
+	}
+	return sideUnknown
+}
+
+// シナリオなどで書ける船籍 (空は艦種で決める)
+func knownFlag(flag string) bool {
+	return flag == "" || flagSide(flag) != sideUnknown
+}
+
+// 船籍を指定しなかったときの船籍。軍艦は敵国、補給艦は自国、ほかは中立国
+func defaultFlag(class string) string {
+	switch {
+	case warshipClasses[class]:
+		return hostileFlag
+	case class == "Supply":
+		return friendlyFlag
+	}
+	return neutralFlags[0]
+}
+
+// 航跡の側。目視で船籍を識別していなければ sideUnknown
+func (t *track) side() side {
+	return flagSide(t.flag)
+}
+
+type rulesOfEngagement struct {
+	events *eventLog
+	// 戦歴のファイル
+	path string
+
+	mu         sync.Mutex
+	reputation float64
+	// 識別していない目標への発射を確認中の航跡の番号 (艦首方向は 0) と期限
+	confirmTarget int
+	confirmUntil  time.Time
+}
+
+// 戦歴を読み、これまでの哨戒の結果から評判を求める
+func newRulesOfEngagement(events *eventLog, path string) (*rulesOfEngagement, error) {
+	list, err := readCampaign(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &rulesOfEngagement{events: events, path: path, reputation: reputationStart}
+	for _, o := range list {
+		r.reputation = clampReputation(r.reputation + o.Reputation)
+	}
+	return r, nil
+}
+
+func clampReputation(v float64) float64 {
+	return math.Max(math.Min(v, reputationStart), 0)
+}
+
+// 航跡 tr (nil なら艦首方向) に撃ってよいか (fireControl.launch から呼ぶ)
+func (r *rulesOfEngagement) clearance(tr *track) error {
+	target, id, unidentified := "no target", 0, "no target is identified"
+	if tr != nil {
+		target, id, unidentified = tr.designation(), tr.id, tr.designation()+" is not positively identified"
+		switch s := tr.side(); s {
+		case sideHostile:
+			return nil
+		case sideNeutral, sideFriendly:
+			return orderRefusedError{fmt.Sprintf("%s is %s (%s), the rules of engagement forbid firing", target, s.word(), tr.flag)}
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.reputation < reputationTightROE {
+		return orderRefusedError{unidentified + ", command requires positive identification"}
+	}
+	now := clock.Now()
+	if id != r.confirmTarget || !now.Before(r.confirmUntil) {
+		r.confirmTarget, r.confirmUntil = id, now.Add(roeConfirmWindow)
+		return orderRefusedError{unidentified + ", fire again to shoot anyway"}
+	}
+	r.confirmUntil = time.Time{}
+	r.events.add(cell.ColorYellow, "[ROE] Firing without positive identification (%s).", target)
+	return nil
+}
+
+// 船 v を沈めた結果を評判と戦歴に反映する
+func (r *rulesOfEngagement) sunk(v vessel) {
+	o := campaignOutcome{At: time.Now(), Target: v.name}
+	s := flagSide(v.flag)
+	switch s {
+	case sideHostile:
+		o.Outcome, o.Reputation = outcomeHostileSunk, hostileSunkCredit
+	case sideNeutral:
+		o.Outcome, o.Reputation = outcomeNeutralSunk, -neutralSunkPenalty
+	case sideFriendly:
+		o.Outcome, o.Reputation = outcomeFriendlySunk, -friendlySunkPenalty
+	default:
+		return
+	}
+
+	r.mu.Lock()
+	before := r.reputation
+	r.reputation = clampReputation(before + o.Reputation)
+	after := r.reputation
+	r.mu.Unlock()
+
+	if s == sideHostile {
+		r.events.add(cell.ColorGreen, "[COMMAND] %s was a hostile %s. Reputation %.0f.", v.name, v.class, after)
+	} else {
+		r.events.add(cell.ColorRed, "[ROE] %s was a %s %s under the flag of %s! Reputation %.0f (%+.0f).", v.name, s.word(), v.class, v.flag, after, o.Reputation)
+	}
+	switch {
+	case before >= reputationTightROE && after < reputationTightROE:
+		r.events.add(cell.ColorRed, "[COMMAND] Rules of engagement tightened: positive identification is required before firing.")
+	case before < reputationTightROE && after >= reputationTightROE:
+		r.events.add(cell.ColorGreen, "[COMMAND] Rules of engagement relaxed: unidentified targets may be engaged on the captain's authority.")
+	}
+	if err := recordOutcome(r.path, o); err != nil {
+		panic(err)
+	}
+}
+
+// Ship Status パネルの評判の行
+func (r *rulesOfEngagement) line() (string, cell.Color) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case r.reputation < reputationTightROE:
+		return fmt.Sprintf("Reputation %.0f  ROE: positive ID only", r.reputation), cell.ColorRed
+	case r.reputation < reputationStart:
+		return fmt.Sprintf("Reputation %.0f  ROE: captain's authority", r.reputation), cell.ColorYellow
+	}
+	return fmt.Sprintf("Reputation %.0f  ROE: captain's authority", r.reputation), cell.ColorGreen
+}
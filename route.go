@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/mum4k/termdash/keyboard"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 変針点と航路
+//
+// 航海図に変針点を置き、自動操舵 (autopilot.go) にその順にたどらせる。
+//   - waypoint キーで航海図にカーソル (×) を出す。カーソルは自艦の位置から始まり、矢印キーで図の 1 文字ずつ動く。
+//     Enter か waypoint キーでカーソルの位置に変針点を置き、Backspace で最後に置いた点を消し、Esc でカーソルを消す。
+//     カーソルを出しているあいだ、矢印キーは操艦に使わない
+//   - follow-route キーで航路に沿って進む。針路保持も入り、次の変針点への方位を針路にする
+//   - 変針点から waypointArrivalRadius に入るか、waypointPassRadius より近づいてから離れはじめたら着いたとみなし、次の点に向かう。
+//     回りきれずに変針点の周りを回り続けないよう、近くを通り過ぎても着いたことにする。最後の点に着いたら、その針路を保つ
+//   - 手で針路を命じると航路から外れ、舵を取ると針路保持ごと切れる
+//
+// 図には変針点を番号の下 1 桁で出し、次に向かう点は緑にする。次の点までの方位・距離・今の速力での到着予定も航海図に出す。
+// 着いたことはイベントログに出る。変針点はセーブデータに残る。
+
+const (
+	// 着いたとみなす距離 (m)
+	waypointArrivalRadius = 200.0
+	// この距離より近づいてから離れはじめたら、通り過ぎたとみなす (m)
+	waypointPassRadius = 1000.0
+	// 到着予定を出す最低の速力 (ノット)
+	waypointEtaMinimum = 0.5
+)
+
+type waypoint struct {
+	// 置いた順の番号 (1 から)
+	Number int     `json:"number"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+}
+
+func (w waypoint) String() string { return fmt.Sprintf("WP%d", w.Number) }
+
+func (w waypoint) position() sim.Point3D { return sim.Point3D{X: w.X, Y: w.Y} }
+
+type route struct {
+	events *eventLog
+
+	mu sync.Mutex
+	// まだ着いていない変針点 (最初が次に向かう点)
+	waypoints []waypoint
+	// 最後に置いた変針点の番号
+	placed int
+	// 次の変針点を目指しはじめたときの距離と、最も近づいた距離 (m)。まだ測っていなければ負
+	start, closest float64
+	// カーソルを出しているか、その位置
+	editing bool
+	cursor  sim.Point3D
+}
+
+func newRoute(events *eventLog) *route {
+	return &route{events: events, start: -1, closest: -1}
+}
+
+// 変針点の一覧 (コピー) と、カーソルを出していればその位置
+func (r *route) snapshot() ([]waypoint, *sim.Point3D) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := append([]waypoint{}, r.waypoints...)
+	if !r.editing {
+		return list, nil
+	}
+	cursor := r.cursor
+	return list, &cursor
+}
+
+// 次に向かう変針点
+func (r *route) next() (waypoint, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.waypoints) == 0 {
+		return waypoint{}, false
+	}
+	return r.waypoints[0], true
+}
+
+// waypoint キーの処理。カーソルがなければ own に出し、あればその位置に変針点を置く
+func (r *route) waypointKey(own sim.Point3D) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.editing {
+		r.editing, r.cursor = true, sim.Point3D{X: own.X, Y: own.Y}
+		r.events.add(cell.ColorCyan, "[NAV] Waypoint cursor: arrows to move, Enter to drop, Backspace to remove the last, Esc to close.")
+		return
+	}
+	r.drop()
+}
+
+// カーソルの位置に変針点を置く (r.mu を保持した状態で呼ぶ)
+func (r *route) drop() {
+	r.placed++
+	w := waypoint{Number: r.placed, X: math.Round(r.cursor.X), Y: math.Round(r.cursor.Y)}
+	r.waypoints = append(r.waypoints, w)
+	if len(r.waypoints) == 1 {
+		r.start, r.closest = -1, -1
+	}
+	r.events.add(cell.ColorCyan, "[NAV] %s dropped at X %.0f Y %.0f.", w, w.X, w.Y)
+}
+
+// カーソルを出しているあいだのキー。処理したら true
+// scale は航海図の 1 文字の横幅 (m)。縦は 1 行でその 2 倍
+func (r *route) cursorKey(k keyboard.Key, scale float64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.editing {
+		return false
+	}
+	switch k {
+	case keyboard.KeyArrowUp:
+		r.cursor.Y += 2 * scale
+	case keyboard.KeyArrowDown:
+		r.cursor.Y -= 2 * scale
+	case keyboard.KeyArrowLeft:
+		r.cursor.X -= scale
+	case keyboard.KeyArrowRight:
+		r.cursor.X += scale
+	case keyboard.KeyEnter:
+		r.drop()
+	case keyboard.KeyBackspace, keyboard.KeyBackspace2:
+		if len(r.waypoints) == 0 {
+			return true
+		}
+		last := r.waypoints[len(r.waypoints)-1]
+		r.waypoints = r.waypoints[:len(r.waypoints)-1]
+		r.events.add(cell.ColorCyan, "[NAV] %s removed.", last)
+	case keyboard.KeyEsc:
+		r.editing = false
+	default:
+		return false
+	}
+	return true
+}
+
+// 変針点をすべて消す (clear-route キーの処理)
+func (r *route) clear(p *Player) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.waypoints) == 0 {
+		return
+	}
+	r.waypoints = nil
+	p.followRoute = false
+	r.events.add(cell.ColorCyan, "[NAV] Route cleared.")
+}
+
+// 次の変針点に着いたかを調べ、着いたら次の点に進める (ゲームループのタイマーから呼ぶ)
+func (r *route) step(p *Player) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.waypoints) == 0 {
+		p.followRoute = false
+		return
+	}
+	w := r.waypoints[0]
+	d := sim.HorizontalDistance(p.Position, w.position())
+	if r.start < 0 {
+		r.start, r.closest = d, d
+	}
+	// 真後ろに置いた点を離れただけで通り過ぎたことにしないよう、一度は近づいていること
+	approached := r.closest < r.start-waypointArrivalRadius
+	passed := approached && r.closest < waypointPassRadius && d > r.closest
+	r.closest = math.Min(r.closest, d)
+	if d > waypointArrivalRadius && !passed {
+		return
+	}
+	r.waypoints = r.waypoints[1:]
+	r.start, r.closest = -1, -1
+	if len(r.waypoints) == 0 {
+		if p.followRoute {
+			p.followRoute = false
+			r.events.add(cell.ColorGreen, "[NAV] Arrived at %s, end of route. Holding course %03.0f.", w, p.orderedCourse())
+		} else {
+			r.events.add(cell.ColorGreen, "[NAV] Arrived at %s, end of route.", w)
+		}
+		return
+	}
+	next := r.waypoints[0]
+	r.events.add(cell.ColorGreen, "[NAV] Arrived at %s. Next %s bearing %03.0f, %.1f km.",
+		w, next, sim.BearingTo(p.Position, next.position()), sim.HorizontalDistance(p.Position, next.position())/1000)
+}
+
+// セーブデータに残す変針点
+func (r *route) saved() []waypoint {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]waypoint(nil), r.waypoints...)
+}
+
+// セーブデータの変針点に戻す
+func (r *route) restore(waypoints []waypoint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.waypoints = append([]waypoint(nil), waypoints...)
+	r.start, r.closest = -1, -1
+	for _, w := range waypoints {
+		if w.Number > r.placed {
+			r.placed = w.Number
+		}
+	}
+}
+
+// 航海図に出す次の変針点までの方位・距離・到着予定。例: "WP2 045° 2.4km ETA 0:12:30"
+// 変針点がなければ空
+func routeLine(p *Player, r *route) string {
+	w, ok := r.next()
+	if !ok {
+		return ""
+	}
+	d := sim.HorizontalDistance(p.Position, w.position())
+	eta := "--:--"
+	if p.Velocity >= waypointEtaMinimum {
+		eta = formatMissionTime(d / (p.Velocity * knot))
+	}
+	return fmt.Sprintf("%s %03.0f° %.1fkm ETA %s", w, sim.BearingTo(p.Position, w.position()), d/1000, eta)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 変針点がなければ航路には乗れない
+func TestRoute(t *testing.T) {
+	g := startGame(t)
+	g.advance(time.Second)
+	g.typeText("&")
+	g.expect("[ORDER] Follow route refused: no waypoints")
+	// カーソルを出して北へ 4 行 (縮尺 500 m で 4 km) 動かし、変針点を置く
+	g.typeText("*")
+	g.expect("[NAV] Waypoint cursor")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("enter")
+	g.expect("[NAV] WP1 dropped at X 0 Y 4000.")
+	// 東へ 4 文字動かして 2 つめを置き、カーソルを消す
+	g.key("right")
+	g.key("right")
+	g.key("right")
+	g.key("right")
+	g.typeText("*")
+	g.expect("[NAV] WP2 dropped at X 2000 Y 4000.")
+	g.key("esc")
+	// 増速して航路に乗ると、針路保持が次の変針点に向ける
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.key("up")
+	g.typeText("&")
+	g.expect("[ORDER] Follow route")
+	g.advance(time.Second)
+	g.expect("AP  RTE 000")
+	g.expect("WP1 000°")
+	g.expect("ETA")
+	// 着くと次の変針点に向かい、最後の点に着いたらその針路を保つ
+	g.advance(90 * time.Second)
+	g.expect("[NAV] Arrived at WP1. Next WP2")
+	g.advance(120 * time.Second)
+	g.expect("[NAV] Arrived at WP2, end of route. Holding course")
+	g.expect("AP  HDG")
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs0604/explorergame/sim"
+)
+
+// セーブデータのファイル名
+const autosaveFileName = "autosave.json"
+
+// オートセーブの間隔
+const autosaveInterval = 30 * time.Second
+
+// セーブデータの浮力は、中立浮力を 50 とした値で保存する
+const neutralBuoyancy = 50.0
+
+// セーブデータの形式が Player や sim.Player の作りに左右されないよう、保存用の構造体に写し替える
+type playerSave struct {
+	X                      float64 `json:"x"`
+	Y                      float64 `json:"y"`
+	Z                      float64 `json:"z"`
+	TurbineRpmSettingValue float64 `json:"turbineRpmSettingValue"`
+	TurbineRpmActualValue  float64 `json:"turbineRpmActualValue"`
+	Velocity               float64 `json:"velocity"`
+	Acceleration           float64 `json:"acceleration"`
+	RudderOrdered          float64 `json:"rudderOrdered"`
+	RudderAngle            float64 `json:"rudderAngle"`
+	Direction              float64 `json:"direction"`
+	DirectionAcceleration  float64 `json:"directionAcceleration"`
+	TrimOrdered            float64 `json:"trimOrdered"`
+	BallastOrdered         float64 `json:"ballastOrdered"`
+	Ballast                float64 `json:"ballast"`
+	Buoyancy               float64 `json:"buoyancy"`
+	BuoyancyAcceleration   float64 `json:"buoyancyAcceleration"`
+	VerticalVelocity       float64 `json:"verticalVelocity"`
+	HoverEnabled           bool    `json:"hoverEnabled"`
+	HoverTargetDepth       float64 `json:"hoverTargetDepth"`
+	Bottomed               bool    `json:"bottomed"`
+	LiftingOff             bool    `json:"liftingOff"`
+	MachinerySecured       bool    `json:"machinerySecured"`
+	HullIntegrity          float64 `json:"hullIntegrity"`
+	Readiness              int     `json:"readiness"`
+	CrewFatigue            float64 `json:"crewFatigue"`
+	// 使った燃料。燃料を記録していない古いセーブデータは満載から始まる
+	FuelUsed float64 `json:"fuelUsed"`
+	Fouling  float64 `json:"fouling"`
+	// 炉心の温度。記録していない古いセーブデータは冷えた炉心から始まる
+	ReactorTemp     float64 `json:"reactorTemp,omitempty"`
+	ReactorScrammed bool    `json:"reactorScrammed"`
+	// 区画ごとの耐久値。記録していない古いセーブデータは無傷から始まる
+	Compartments []float64 `json:"compartments,omitempty"`
+	// 区画ごとの浸水とビルジポンプ
+	Flooding   []float64 `json:"flooding,omitempty"`
+	BilgePumps bool      `json:"bilgePumps,omitempty"`
+	// 艦内の空気とシュノーケル。記録していない古いセーブデータは外気から始まる
+	Oxygen  float64 `json:"oxygen,omitempty"`
+	CO2     float64 `json:"co2,omitempty"`
+	Snorkel bool    `json:"snorkel,omitempty"`
+	// 止めている補機 (equipmentKeys の名前)
+	SecuredEquipment []string `json:"securedEquipment,omitempty"`
+	// 命令された針路と自動操舵。針路を命令していなければ Course は nil
+	Course      *float64 `json:"course,omitempty"`
+	HeadingHold bool     `json:"headingHold,omitempty"`
+	SpeedHold   bool     `json:"speedHold,omitempty"`
+	SpeedTarget float64  `json:"speedTarget,omitempty"`
+	FollowRoute bool     `json:"followRoute,omitempty"`
+	// 潜舵の命令値と実際の角度。記録していない古いセーブデータは中立から始まる
+	PlanesOrdered float64 `json:"planesOrdered,omitempty"`
+	Planes        float64 `json:"planes,omitempty"`
+}
+
+// セーブデータ全体
+type saveData struct {
+	SavedAt time.Time   `json:"savedAt"`
+	Player  playerSave  `json:"player"`
+	Chart   []chartMark `json:"chart,omitempty"`
+	// 航海図の変針点 (まだ着いていないもの)
+	Waypoints []waypoint `json:"waypoints,omitempty"`
+	// 発射管ごとの魚雷の設定
+	Tubes []torpedoPreset `json:"tubes,omitempty"`
+	// 積んでいる魚雷の数。記録していない古いセーブデータは満載から始まる
+	Torpedoes *int `json:"torpedoes,omitempty"`
+	// 配置ごとに就いている乗員の名前
+	Crew []string `json:"crew,omitempty"`
+	// 怪我をしている乗員と士気。士気を記録していない古いセーブデータは最大から始まる
+	Casualties []savedCasualty `json:"casualties,omitempty"`
+	CrewMorale float64         `json:"crewMorale,omitempty"`
+	// 乗員ごとの腕前。訓練で上がる (training.go)
+	CrewSkills map[string]float64 `json:"crewSkills,omitempty"`
+}
+
+func newSaveData(p *Player) saveData {
+	return saveData{
+		SavedAt: time.Now(),
+		Player: playerSave{
+			X:                      p.Position.X,
+			Y:                      p.Position.Y,
+			Z:                      p.Position.Z,
+			TurbineRpmSettingValue: p.Turbine.Ordered,
+			TurbineRpmActualValue:  p.Turbine.Actual,
+			Velocity:               p.Velocity,
+			Acceleration:           p.Acceleration,
+			RudderOrdered:          p.Rudder.Ordered,
+			RudderAngle:            p.Rudder.Actual,
+			Direction:              p.Direction,
+			DirectionAcceleration:  p.DirectionAcceleration,
+			TrimOrdered:            p.Trim.Ordered,
+			BallastOrdered:         p.Ballast.Ordered,
+			Ballast:                p.Ballast.Actual,
+			Buoyancy:               neutralBuoyancy + p.Trim.Actual,
+			BuoyancyAcceleration:   p.BuoyancyAcceleration,
+			VerticalVelocity:       p.VerticalVelocity,
+			HoverEnabled:           p.HoverEnabled,
+			HoverTargetDepth:       p.HoverDepth.Ordered,
+			Bottomed:               p.Bottomed,
+			LiftingOff:             p.LiftingOff,
+			MachinerySecured:       p.MachinerySecured,
+			HullIntegrity:          p.HullIntegrity,
+			Compartments:           append([]float64{}, p.Compartments[:]...),
+			Flooding:               append([]float64{}, p.Flooding[:]...),
+			BilgePumps:             p.BilgePumps,
+			FuelUsed:               sim.FuelCapacity - p.Fuel,
+			Fouling:                p.Fouling,
+			ReactorTemp:            p.Reactor.CoreTemp,
+			ReactorScrammed:        p.Reactor.Scrammed,
+			Readiness:              int(p.readiness),
+			CrewFatigue:            p.crewFatigue,
+			Oxygen:                 p.oxygen,
+			CO2:                    p.co2,
+			Snorkel:                p.snorkel,
+			SecuredEquipment:       securedEquipment(p),
+			Course:                 orderedCourse(p),
+			HeadingHold:            p.headingHold,
+			SpeedHold:              p.speedHold,
+			SpeedTarget:            p.Speed.Ordered,
+			FollowRoute:            p.followRoute,
+			PlanesOrdered:          p.Planes.Ordered,
+			Planes:                 p.Planes.Actual,
+		},
+	}
+}
+
+// 命令された針路。命令していなければ nil
+func orderedCourse(p *Player) *float64 {
+	if !p.courseOrdered {
+		return nil
+	}
+	course := p.Course.Ordered
+	return &course
+}
+
+// 止めている補機の名前
+func securedEquipment(p *Player) []string {
+	var names []string
+	for e := equipment(0); e < equipmentCount; e++ {
+		if p.equipmentSecured[e] {
+			names = append(names, equipmentKeys[e])
+		}
+	}
+	return names
+}
+
+// セーブデータの内容をプレイヤーに反映する
+func (s saveData) apply(p *Player) {
+	p.Position = sim.Point3D{X: s.Player.X, Y: s.Player.Y, Z: math.Min(s.Player.Z, 0)}
+	p.Turbine.Order(s.Player.TurbineRpmSettingValue)
+	p.Turbine.Actual = s.Player.TurbineRpmActualValue
+	p.Velocity = s.Player.Velocity
+	p.Acceleration = s.Player.Acceleration
+	p.Rudder.Order(s.Player.RudderOrdered)
+	p.Rudder.Actual = s.Player.RudderAngle
+	p.Direction = s.Player.Direction
+	p.DirectionAcceleration = s.Player.DirectionAcceleration
+	p.Trim.Order(s.Player.TrimOrdered)
+	p.Planes.Order(s.Player.PlanesOrdered)
+	p.Planes.Actual = math.Max(math.Min(s.Player.Planes, p.Planes.Max), p.Planes.Min)
+	p.Ballast.Order(s.Player.BallastOrdered)
+	p.Ballast.Actual = s.Player.Ballast
+	p.Trim.Actual = s.Player.Buoyancy - neutralBuoyancy
+	p.BuoyancyAcceleration = s.Player.BuoyancyAcceleration
+	p.VerticalVelocity = s.Player.VerticalVelocity
+	p.HoverEnabled = s.Player.HoverEnabled
+	p.HoverDepth.Order(s.Player.HoverTargetDepth)
+	p.Bottomed = s.Player.Bottomed
+	p.LiftingOff = s.Player.LiftingOff
+	p.MachinerySecured = s.Player.MachinerySecured
+	p.HullIntegrity = math.Max(math.Min(s.Player.HullIntegrity, 100), 0)
+	copy(p.Compartments[:], s.Player.Compartments)
+	copy(p.Flooding[:], s.Player.Flooding)
+	p.BilgePumps = s.Player.BilgePumps
+	p.Fuel = sim.FuelCapacity - s.Player.FuelUsed
+	p.Fouling = s.Player.Fouling
+	if s.Player.ReactorTemp != 0 {
+		p.Reactor.CoreTemp = s.Player.ReactorTemp
+	}
+	p.Reactor.Scrammed = s.Player.ReactorScrammed
+	// 制御棒は回っているタービンに見合うところまで引き抜いてある
+	if !p.Reactor.Scrammed {
+		p.Reactor.Rods.Actual = p.Turbine.Actual / p.Turbine.Max * 100
+	}
+	p.readiness = readinessCruise
+	if r := readiness(s.Player.Readiness); r >= readinessCruise && r <= readinessBattle {
+		p.readiness = r
+	}
+	p.crewFatigue = s.Player.CrewFatigue
+	if s.Player.Oxygen > 0 {
+		p.oxygen, p.co2 = s.Player.Oxygen, s.Player.CO2
+	}
+	p.snorkel = s.Player.Snorkel
+	for e := equipment(0); e < equipmentCount; e++ {
+		secured := false
+		for _, name := range s.Player.SecuredEquipment {
+			secured = secured || name == equipmentKeys[e]
+		}
+		p.setEquipment(e, secured)
+	}
+	p.courseOrdered = s.Player.Course != nil
+	if p.courseOrdered {
+		p.Course.Order(*s.Player.Course)
+	}
+	p.headingHold = s.Player.HeadingHold && p.courseOrdered
+	p.speedHold = s.Player.SpeedHold
+	p.Speed.Order(s.Player.SpeedTarget)
+	p.MeasureControls()
+	// 変針点がなければ次のティックで切れる
+	p.followRoute = s.Player.FollowRoute && p.headingHold
+}
+
+// セーブデータやクラッシュダンプを置くディレクトリ
+func dataDir() string {
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return "."
+	}
+	dir := filepath.Join(base, "explorergame")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "."
+	}
+	return dir
+}
+
+// 哨戒をまたいで読み込むファイル (マクロ、測量結果、攻撃の記録、日誌) が読めるか確かめる
+// 端末を開いたあとで読めないと分かってもクラッシュダンプを残して終わるしかないので、端末を開く前に呼ぶ
+func checkDataFiles(dir string) error {
+	files := []struct {
+		name string
+		v    interface{}
+	}{
+		{macrosFileName, &[]macro{}},
+		{surveyFileName, &surveyFile{}},
+		{attacksFileName, &attacksFile{}},
+		{portsFileName, &portsFile{}},
+		{journalFileName, &journalFile{}},
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, f.name)
+		data, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err == nil {
+			err = json.Unmarshal(data, f.v)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v (fix or delete the file)", path, err)
+		}
+	}
+	return nil
+}
+
+func writeSave(path string, s saveData) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	// 書き込み途中で落ちても壊れないよう、一時ファイルに書いてから置き換える
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func readSave(path string) (saveData, error) {
+	var s saveData
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, fmt.Errorf("%s: %v", path, err)
+	}
+	return s, nil
+}
+
+// 書き間違いをすべて挙げる (validate-scenario)
+// 戻すときは範囲の外の値を範囲に収め、知らない値は既定に戻すので、書き間違いがあっても遊べる
+func (s saveData) problems() []string {
+	var problems []string
+	report := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	offMap := func(x, y float64) bool {
+		return math.Abs(x) > mapExtent || math.Abs(y) > mapExtent
+	}
+
+	p := s.Player
+	if p.Z > 0 {
+		report("player is %.1f m above the surface", p.Z)
+	}
+	if p.HullIntegrity < 0 || p.HullIntegrity > 100 {
+		report("hull integrity %v is outside 0-100", p.HullIntegrity)
+	}
+	if p.Readiness < int(readinessCruise) || p.Readiness > int(readinessBattle) {
+		report("unknown readiness %d", p.Readiness)
+	}
+	for _, name := range p.SecuredEquipment {
+		known := false
+		for _, key := range equipmentKeys {
+			known = known || name == key
+		}
+		if !known {
+			report("unknown equipment %q", name)
+		}
+	}
+
+	for i, m := range s.Chart {
+		switch m.Kind {
+		case markHazard, markGrounding, markMine, markNote:
+		default:
+			report("chart mark %d (%s): unknown kind %q", i+1, m.Name, m.Kind)
+		}
+		if offMap(m.X, m.Y) {
+			report("chart mark %d (%s): (%.0f, %.0f) is off the map", i+1, m.Name, m.X, m.Y)
+		}
+		if m.Radius <= 0 {
+			report("chart mark %d (%s): needs a positive radius", i+1, m.Name)
+		}
+	}
+	for _, w := range s.Waypoints {
+		if w.Number < 1 {
+			report("waypoint %d: needs a positive number", w.Number)
+		}
+		if offMap(w.X, w.Y) {
+			report("%s: (%.0f, %.0f) is off the map", w, w.X, w.Y)
+		}
+	}
+	if len(s.Tubes) > torpedoTubes {
+		report("%d tube presets for %d tubes", len(s.Tubes), torpedoTubes)
+	}
+	for i, t := range s.Tubes {
+		t.problems(func(format string, args ...interface{}) {
+			report("tube %d: "+format, append([]interface{}{i + 1}, args...)...)
+		})
+	}
+	if s.Torpedoes != nil && (*s.Torpedoes < 0 || *s.Torpedoes > torpedoMagazine) {
+		report("%d torpedoes aboard is outside 0-%d", *s.Torpedoes, torpedoMagazine)
+	}
+	for _, c := range s.Casualties {
+		if c.Injury != int(injuryLight) && c.Injury != int(injurySerious) {
+			report("casualty %s: unknown injury %d", c.Name, c.Injury)
+		}
+		if c.Recovery < 0 {
+			report("casualty %s: negative recovery time", c.Name)
+		}
+	}
+	if s.CrewMorale < 0 || s.CrewMorale > moraleStart {
+		report("crew morale %v is outside 0-%.0f", s.CrewMorale, moraleStart)
+	}
+	for name, skill := range s.CrewSkills {
+		if skill < 0 || skill > 100 {
+			report("crew %s: skill %v is outside 0-100", name, skill)
+		}
+	}
+	return problems
+}
+
+// プレイヤーの状態と海図の書き込み、魚雷の設定、乗員の配置を保存する (ゲームループから autosaveInterval ごとに呼ぶ)
+// 測量の結果と攻撃の記録は哨戒をまたいで残すので、別のファイルに保存する
+func autosave(p *Player, ch *chart, rt *route, room *torpedoRoom, crew *crewRoster, sv *survey, attacks *attackHistory, path string) {
+	if err := sv.save(); err != nil {
+		panic(err)
+	}
+	if err := attacks.save(); err != nil {
+		panic(err)
+	}
+	if p.abandoned {
+		// 退艦したら哨戒は終わり。最後の保存から再開はさせない
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			panic(err)
+		}
+		return
+	}
+	s := newSaveData(p)
+	s.Chart = ch.saved()
+	s.Waypoints = rt.saved()
+	s.Tubes = room.saved()
+	torpedoes := room.aboard()
+	s.Torpedoes = &torpedoes
+	s.Crew = crew.saved()
+	s.Casualties, s.CrewMorale = crew.savedCasualties()
+	s.CrewSkills = crew.savedSkills()
+	if err := writeSave(path, s); err != nil {
+		panic(err)
+	}
+}
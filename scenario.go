@@ -0,0 +1,410 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// シナリオのトリガー
+//
+// 「ある海域に入ったら」「ある船が沈んだら」「開始から何秒経ったら」といった条件で、
+// 船を出す、メッセージを送る、天候を変える、目標を達成にする、などの動作を起こす。
+// シナリオは JSON ファイルに書き、-scenario で読み込む。
+
+// トリガーの条件の種類
+const (
+	// 自艦が (X, Y) から Radius (m) 以内に入った
+	conditionEnterArea = "enter-area"
+	// Contact という名前の船が沈んだ
+	conditionSunk = "sunk"
+	// Contact という名前の船が自艦から Radius (m) 以内に来た
+	conditionContactInRange = "contact-in-range"
+	// 開始から At 秒経った (シミュレーション時間。一時停止中は進まず、時間の圧縮で速くなる)
+	conditionTime = "time"
+	// (X, Y) から Radius (m) 以内の海底を Coverage (%) 以上測量した
+	conditionSurveyed = "surveyed"
+)
+
+// トリガーの動作の種類
+const (
+	// Name という船を (X, Y) に出す
+	actionSpawn = "spawn"
+	// Text をイベントログに出す
+	actionMessage = "message"
+	// 海況を SeaState にする
+	actionWeather = "weather"
+	// Objective の目標を達成にする
+	actionCompleteObjective = "complete-objective"
+)
+
+// トリガーの条件を調べる間隔 (シミュレーション時間)
+const scenarioCheckInterval = time.Second
+
+type triggerCondition struct {
+	Type     string  `json:"type"`
+	X        float64 `json:"x,omitempty"`
+	Y        float64 `json:"y,omitempty"`
+	Radius   float64 `json:"radius,omitempty"`
+	Contact  string  `json:"contact,omitempty"`
+	At       float64 `json:"at,omitempty"`
+	Coverage float64 `json:"coverage,omitempty"`
+}
+
+type triggerAction struct {
+	Type      string  `json:"type"`
+	Name      string  `json:"name,omitempty"`
+	Class     string  `json:"class,omitempty"`
+	Flag      string  `json:"flag,omitempty"`
+	X         float64 `json:"x,omitempty"`
+	Y         float64 `json:"y,omitempty"`
+	Course    float64 `json:"course,omitempty"`
+	Speed     float64 `json:"speed,omitempty"`
+	Text      string  `json:"text,omitempty"`
+	SeaState  int     `json:"seaState,omitempty"`
+	Objective string  `json:"objective,omitempty"`
+}
+
+type triggerConfig struct {
+	Name string           `json:"name"`
+	When triggerCondition `json:"when"`
+	Do   []triggerAction  `json:"do"`
+}
+
+type objectiveConfig struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+}
+
+// 進入禁止区域
+type zoneConfig struct {
+	Name   string  `json:"name"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Radius float64 `json:"radius"`
+}
+
+type scenarioConfig struct {
+	Name       string            `json:"name"`
+	Briefing   string            `json:"briefing"`
+	Objectives []objectiveConfig `json:"objectives"`
+	Triggers   []triggerConfig   `json:"triggers"`
+	Zones      []zoneConfig      `json:"zones"`
+	Harbors    []harborConfig    `json:"harbors"`
+	// 臨検する不審船 (boarding.go)
+	Inspections []inspectionConfig `json:"inspections"`
+	// 地形の哨戒区域の敵の性格と、シナリオで置く敵 (personality.go)
+	EnemyPersonality string        `json:"enemyPersonality,omitempty"`
+	Enemies          []enemyConfig `json:"enemies"`
+}
+
+// シナリオファイルを読み込み、書き間違いがないか確かめる
+func loadScenario(path string) (scenarioConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return scenarioConfig{}, err
+	}
+	cfg, err := parseScenario(data)
+	if err != nil {
+		return cfg, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+func parseScenario(data []byte) (scenarioConfig, error) {
+	var cfg scenarioConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.validate()
+}
+
+// 海域の広さ。位置は X, Y とも ±mapExtent (m) に収める
+const mapExtent = 30000.0
+
+// シナリオで出せる艦種
+func knownClass(class string) bool {
+	return class == "" || class == "Merchant" || class == "Supply" || warshipClasses[class]
+}
+
+func (cfg scenarioConfig) validate() error {
+	return problemsError(cfg.problems())
+}
+
+// 最初の書き間違いと残りの数のエラー。書き間違いがなければ nil
+func problemsError(problems []string) error {
+	switch len(problems) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("%s", problems[0])
+	}
+	return fmt.Errorf("%s (and %d more problems; run validate-scenario for the full list)", problems[0], len(problems)-1)
+}
+
+// 書き間違いをすべて挙げる
+func (cfg scenarioConfig) problems() []string {
+	var problems []string
+	report := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	onMap := func(x, y float64) bool {
+		return math.Abs(x) <= mapExtent && math.Abs(y) <= mapExtent
+	}
+
+	objectives := map[string]bool{}
+	for i, o := range cfg.Objectives {
+		switch {
+		case o.ID == "":
+			report("objective %d: missing id", i+1)
+		case objectives[o.ID]:
+			report("objective %q: duplicate id", o.ID)
+		}
+		objectives[o.ID] = true
+	}
+	// 条件に使える船の名前 (シナリオで出す船だけ。航路の商船は名前が決まらない)
+	contacts := map[string]bool{}
+	for _, c := range cfg.Inspections {
+		contacts[c.Name] = true
+	}
+	for _, e := range cfg.Enemies {
+		contacts[e.Name] = true
+	}
+	for _, t := range cfg.Triggers {
+		for _, a := range t.Do {
+			if a.Type == actionSpawn && a.Name != "" {
+				contacts[a.Name] = true
+			}
+		}
+	}
+
+	for _, t := range cfg.Triggers {
+		c := t.When
+		switch c.Type {
+		case conditionEnterArea:
+			if !onMap(c.X, c.Y) {
+				report("trigger %q: area (%.0f, %.0f) is off the map (±%.0f m)", t.Name, c.X, c.Y, mapExtent)
+			}
+			if c.Radius <= 0 {
+				report("trigger %q: enter-area needs a positive radius", t.Name)
+			}
+		case conditionSunk, conditionContactInRange:
+			if !contacts[c.Contact] {
+				report("trigger %q: contact %q is never spawned by this scenario", t.Name, c.Contact)
+			}
+			if c.Type == conditionContactInRange && c.Radius <= 0 {
+				report("trigger %q: contact-in-range needs a positive radius", t.Name)
+			}
+		case conditionTime:
+			if c.At < 0 {
+				report("trigger %q: time %v is negative", t.Name, c.At)
+			}
+		case conditionSurveyed:
+			if !onMap(c.X, c.Y) {
+				report("trigger %q: survey area (%.0f, %.0f) is off the map (±%.0f m)", t.Name, c.X, c.Y, mapExtent)
+			}
+			if c.Radius < surveyCellSize {
+				report("trigger %q: survey area radius must be at least %.0f m", t.Name, surveyCellSize)
+			}
+			if c.Coverage <= 0 || c.Coverage > 100 {
+				report("trigger %q: coverage %v is outside 1-100%%", t.Name, c.Coverage)
+			}
+		default:
+			report("trigger %q: unknown condition %q", t.Name, c.Type)
+		}
+		if len(t.Do) == 0 {
+			report("trigger %q: no actions", t.Name)
+		}
+		for _, a := range t.Do {
+			switch a.Type {
+			case actionSpawn:
+				if a.Name == "" {
+					report("trigger %q: spawn needs a name", t.Name)
+				}
+				if !knownClass(a.Class) {
+					report("trigger %q: unknown class %q for %q", t.Name, a.Class, a.Name)
+				}
+				if !knownFlag(a.Flag) {
+					report("trigger %q: unknown flag %q for %q", t.Name, a.Flag, a.Name)
+				}
+				if !onMap(a.X, a.Y) {
+					report("trigger %q: %q spawns off the map at (%.0f, %.0f)", t.Name, a.Name, a.X, a.Y)
+				}
+				if a.Speed < 0 {
+					report("trigger %q: %q has a negative speed", t.Name, a.Name)
+				}
+			case actionMessage:
+				if a.Text == "" {
+					report("trigger %q: message has no text", t.Name)
+				}
+			case actionWeather:
+				if a.SeaState < 0 || a.SeaState > 9 {
+					report("trigger %q: sea state %d is outside 0-9", t.Name, a.SeaState)
+				}
+			case actionCompleteObjective:
+				if !objectives[a.Objective] {
+					report("trigger %q: unknown objective %q", t.Name, a.Objective)
+				}
+			default:
+				report("trigger %q: unknown action %q", t.Name, a.Type)
+			}
+		}
+	}
+
+	for _, z := range cfg.Zones {
+		if !onMap(z.X, z.Y) {
+			report("zone %q: centre (%.0f, %.0f) is off the map", z.Name, z.X, z.Y)
+		}
+		if z.Radius <= 0 {
+			report("zone %q: needs a positive radius", z.Name)
+		}
+	}
+	for _, h := range cfg.Harbors {
+		h.problems(report, onMap)
+	}
+	for _, c := range cfg.Inspections {
+		c.problems(report, onMap, objectives)
+	}
+	if _, ok := findPersonality(cfg.EnemyPersonality); !ok {
+		report("unknown enemy personality %q (%s)", cfg.EnemyPersonality, personalityNames())
+	}
+	for _, e := range cfg.Enemies {
+		e.problems(report, onMap)
+	}
+	return problems
+}
+
+// 実行中のシナリオ
+type scenario struct {
+	cfg     scenarioConfig
+	events  *eventLog
+	env     *environment
+	traffic *traffic
+	survey  *survey
+
+	mu        sync.Mutex
+	fired     map[int]bool
+	completed map[string]bool
+	// 次に条件を調べる時刻 (シミュレーション時間)
+	next time.Duration
+}
+
+// 進入禁止区域は海図に書き込む
+func newScenario(cfg scenarioConfig, events *eventLog, env *environment, tr *traffic, ch *chart, sv *survey) *scenario {
+	for _, z := range cfg.Zones {
+		ch.add(chartMark{Kind: markExclusion, Name: z.Name, X: z.X, Y: z.Y, Radius: z.Radius, Scenario: true})
+	}
+	return &scenario{
+		cfg:       cfg,
+		events:    events,
+		env:       env,
+		traffic:   tr,
+		survey:    sv,
+		fired:     map[int]bool{},
+		completed: map[string]bool{},
+	}
+}
+
+// 条件を満たしたトリガーを1度だけ動かす (ゲームループのタイマーから呼ぶ)
+// now はシミュレーション上の時刻。条件は scenarioCheckInterval ごとに調べる
+func (s *scenario) update(p *Player, now time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now < s.next {
+		return
+	}
+	s.next = now + scenarioCheckInterval
+	for i, t := range s.cfg.Triggers {
+		if s.fired[i] || !s.satisfied(t.When, p, now) {
+			continue
+		}
+		s.fired[i] = true
+		for _, a := range t.Do {
+			s.run(a)
+		}
+	}
+}
+
+// s.mu を保持した状態で呼ぶ
+func (s *scenario) satisfied(c triggerCondition, p *Player, now time.Duration) bool {
+	switch c.Type {
+	case conditionEnterArea:
+		return sim.HorizontalDistance(p.Position, sim.Point3D{X: c.X, Y: c.Y}) <= c.Radius
+	case conditionSunk:
+		return s.traffic.wasSunk(c.Contact)
+	case conditionContactInRange:
+		for _, v := range s.traffic.vessels() {
+			if v.name == c.Contact && sim.HorizontalDistance(p.Position, v.position) <= c.Radius {
+				return true
+			}
+		}
+		return false
+	case conditionTime:
+		return now.Seconds() >= c.At
+	case conditionSurveyed:
+		return s.survey.coverage(c.X, c.Y, c.Radius) >= c.Coverage
+	}
+	return false
+}
+
+// s.mu を保持した状態で呼ぶ
+func (s *scenario) run(a triggerAction) {
+	switch a.Type {
+	case actionSpawn:
+		class := a.Class
+		if class == "" {
+			class = "Merchant"
+		}
+		s.traffic.spawnAt(a.Name, class, a.Flag, sim.Point3D{X: a.X, Y: a.Y}, a.Course, a.Speed)
+	case actionMessage:
+		s.events.add(cell.ColorMagenta, "[MESSAGE] %s", a.Text)
+	case actionWeather:
+		s.env.seaState = a.SeaState
+		s.events.add(cell.ColorCyan, "[WEATHER] Sea state %d", a.SeaState)
+	case actionCompleteObjective:
+		s.completeObjective(a.Objective)
+	}
+}
+
+// 目標 id を達成にする (臨検などシナリオの外から)
+func (s *scenario) complete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.completeObjective(id)
+}
+
+// 達成した目標の数と目標の数
+func (s *scenario) progress() (done, total int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.completed), len(s.cfg.Objectives)
+}
+
+// s.mu を保持した状態で呼ぶ
+func (s *scenario) completeObjective(id string) {
+	if s.completed[id] {
+		return
+	}
+	s.completed[id] = true
+	for _, o := range s.cfg.Objectives {
+		if o.ID == id {
+			s.events.add(cell.ColorGreen, "[OBJECTIVE] Complete: %s", o.Description)
+		}
+	}
+	if len(s.completed) == len(s.cfg.Objectives) {
+		s.events.add(cell.ColorGreen, "[SCENARIO] All objectives complete.")
+	}
+}
+
+// 説明を出す (開始時に 1 回)
+func (s *scenario) brief() {
+	if s.cfg.Briefing != "" {
+		s.events.add(cell.ColorMagenta, "[BRIEFING] %s: %s", s.cfg.Name, s.cfg.Briefing)
+	}
+}
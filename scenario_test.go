@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 商船のいない海で、triggers を持つシナリオを動かす
+func newTestScenario(t *testing.T, triggers ...triggerConfig) (*scenario, *traffic, *testEvents) {
+	t.Helper()
+	events, logged := newTestEvents(t)
+	tr := newTraffic(trafficConfig{}, nil, events, defaultEnvironment(), rand.New(rand.NewSource(testSeed)))
+	cfg := scenarioConfig{
+		Name:       "test",
+		Objectives: []objectiveConfig{{ID: "done", Description: "Trigger fired"}},
+		Triggers:   triggers,
+	}
+	return newScenario(cfg, events, defaultEnvironment(), tr, nil, nil), tr, logged
+}
+
+func TestScenarioSunkTrigger(t *testing.T) {
+	sc, tr, logged := newTestScenario(t, triggerConfig{
+		Name: "sunk",
+		When: triggerCondition{Type: conditionSunk, Contact: "Target"},
+		Do: []triggerAction{
+			{Type: actionMessage, Text: "Target down"},
+			{Type: actionCompleteObjective, Objective: "done"},
+		},
+	})
+	p := newTestPlayer()
+	id := tr.spawnAt("Target", "Merchant", "", sim.Point3D{X: 5000}, 0, 0)
+	tr.spawnAt("Bystander", "Merchant", "", sim.Point3D{X: -5000}, 0, 0)
+
+	sc.update(p, 0)
+	if logged.contains("Target down") {
+		t.Fatal("trigger fired before the contact was sunk")
+	}
+	if !tr.sink(id) {
+		t.Fatal("contact to sink not found")
+	}
+	sc.update(p, scenarioCheckInterval)
+	if !logged.contains("[MESSAGE] Target down") {
+		t.Error("sunk trigger did not send its message")
+	}
+	if done, total := sc.progress(); done != 1 || total != 1 {
+		t.Errorf("objectives %d/%d, want 1/1", done, total)
+	}
+}
+
+func TestScenarioTimeTrigger(t *testing.T) {
+	sc, _, logged := newTestScenario(t, triggerConfig{
+		Name: "time",
+		When: triggerCondition{Type: conditionTime, At: 30},
+		Do:   []triggerAction{{Type: actionMessage, Text: "Half a minute"}},
+	})
+	p := newTestPlayer()
+
+	// シミュレーション時間で測るので、呼び出しの間に壁時計がどれだけ進んでも関係ない
+	tests := []struct {
+		now  time.Duration
+		want bool
+	}{
+		{0, false},
+		{29 * time.Second, false},
+		// 前に調べてから scenarioCheckInterval 経っていないので調べない
+		{29*time.Second + scenarioCheckInterval/2, false},
+		{30 * time.Second, true},
+	}
+	for _, tt := range tests {
+		sc.update(p, tt.now)
+		if got := logged.contains("Half a minute"); got != tt.want {
+			t.Errorf("at %v: fired %v, want %v", tt.now, got, tt.want)
+		}
+	}
+
+	// 一度しか動かない
+	sc.update(p, time.Minute)
+	n := 0
+	for _, l := range logged.lines {
+		if l == "[MESSAGE] Half a minute" {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Errorf("trigger fired %d times, want 1", n)
+	}
+}
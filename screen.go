@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"sync"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/mum4k/termdash/terminal/terminalapi"
+)
+
+// 画面の1セル分の内容
+type screenCell struct {
+	r  rune
+	fg cell.Color
+	bg cell.Color
+}
+
+// 端末に描画された内容をメモリ上に保持するバッファ
+// ブラウザミラーなど、termdash の描画結果を端末以外へ流すために使う
+type screenBuffer struct {
+	size  image.Point
+	cells []screenCell
+}
+
+func newScreenBuffer(size image.Point) *screenBuffer {
+	b := &screenBuffer{
+		size:  size,
+		cells: make([]screenCell, size.X*size.Y),
+	}
+	b.clear()
+	return b
+}
+
+func (b *screenBuffer) clear() {
+	for i := range b.cells {
+		b.cells[i] = screenCell{r: ' '}
+	}
+}
+
+func (b *screenBuffer) set(p image.Point, r rune, opts ...cell.Option) error {
+	if p.X < 0 || p.Y < 0 || p.X >= b.size.X || p.Y >= b.size.Y {
+		return fmt.Errorf("point %v is outside of the screen %v", p, b.size)
+	}
+	o := cell.NewOptions(opts...)
+	b.cells[p.Y*b.size.X+p.X] = screenCell{r: r, fg: o.FgColor, bg: o.BgColor}
+	return nil
+}
+
+func (b *screenBuffer) clone() *screenBuffer {
+	c := &screenBuffer{
+		size:  b.size,
+		cells: make([]screenCell, len(b.cells)),
+	}
+	copy(c.cells, b.cells)
+	return c
+}
+
+// prev との差分を ANSI エスケープシーケンスとして返す
+// prev が nil またはサイズが違う場合は画面全体を描き直す
+func (b *screenBuffer) ansiDiff(prev *screenBuffer) []byte {
+	var out bytes.Buffer
+	full := prev == nil || prev.size != b.size
+	if full {
+		out.WriteString("\x1b[0m\x1b[2J")
+	}
+
+	var curFg, curBg cell.Color = -1, -1
+	cursor := image.Point{-1, -1}
+	for y := 0; y < b.size.Y; y++ {
+		for x := 0; x < b.size.X; x++ {
+			c := b.cells[y*b.size.X+x]
+			if !full && prev.cells[y*b.size.X+x] == c {
+				continue
+			}
+			if cursor.X != x || cursor.Y != y {
+				fmt.Fprintf(&out, "\x1b[%d;%dH", y+1, x+1)
+			}
+			if c.fg != curFg || c.bg != curBg {
+				out.WriteString(ansiColor(c.fg, c.bg))
+				curFg, curBg = c.fg, c.bg
+			}
+			r := c.r
+			if r == 0 {
+				r = ' '
+			}
+			out.WriteRune(r)
+			cursor = image.Point{x + 1, y}
+		}
+	}
+	if out.Len() > 0 {
+		out.WriteString("\x1b[0m")
+	}
+	return out.Bytes()
+}
+
+// termdash の色を SGR シーケンスに変換する
+// cell.ColorDefault 以外は ColorNumber(n) == n+1 という対応になっている
+func ansiColor(fg, bg cell.Color) string {
+	seq := "\x1b[0"
+	if fg == cell.ColorDefault {
+		seq += ";39"
+	} else {
+		seq += fmt.Sprintf(";38;5;%d", int(fg)-1)
+	}
+	if bg == cell.ColorDefault {
+		seq += ";49"
+	} else {
+		seq += fmt.Sprintf(";48;5;%d", int(bg)-1)
+	}
+	return seq + "m"
+}
+
+// 別の端末へ描画を中継しつつ、描画内容を screenBuffer にも残す端末
+// base が nil の場合は画面バッファだけを持つヘッドレス端末になる
+// ブラウザミラーやセッション録画はこれを埋め込んで Flush と Event を上書きする
+type bufferedTerminal struct {
+	base terminalapi.Terminal
+
+	mu     sync.Mutex
+	screen *screenBuffer
+	sent   *screenBuffer
+}
+
+func newBufferedTerminal(base terminalapi.Terminal, size image.Point) bufferedTerminal {
+	if base != nil {
+		size = base.Size()
+	}
+	return bufferedTerminal{
+		base:   base,
+		screen: newScreenBuffer(size),
+	}
+}
+
+func (b *bufferedTerminal) Size() image.Point {
+	if b.base != nil {
+		return b.base.Size()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.screen.size
+}
+
+func (b *bufferedTerminal) Clear(opts ...terminalapi.ClearOption) error {
+	if b.base != nil {
+		if err := b.base.Clear(opts...); err != nil {
+			return err
+		}
+	}
+	size := b.Size()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if size != b.screen.size {
+		b.screen = newScreenBuffer(size)
+	} else {
+		b.screen.clear()
+	}
+	return nil
+}
+
+func (b *bufferedTerminal) SetCursor(p image.Point) {
+	if b.base != nil {
+		b.base.SetCursor(p)
+	}
+}
+
+func (b *bufferedTerminal) HideCursor() {
+	if b.base != nil {
+		b.base.HideCursor()
+	}
+}
+
+func (b *bufferedTerminal) SetCell(p image.Point, r rune, opts ...cell.Option) error {
+	if b.base != nil {
+		if err := b.base.SetCell(p, r, opts...); err != nil {
+			return err
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.screen.set(p, r, opts...)
+}
+
+func (b *bufferedTerminal) Event(ctx context.Context) terminalapi.Event {
+	if b.base != nil {
+		return b.base.Event(ctx)
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func (b *bufferedTerminal) Close() {
+	if b.base != nil {
+		b.base.Close()
+	}
+}
+
+// 画面バッファだけを持ち、入力のないヘッドレス端末 (耐久試験で使う)
+type headlessTerminal struct {
+	bufferedTerminal
+}
+
+func newHeadlessTerminal() *headlessTerminal {
+	return &headlessTerminal{bufferedTerminal: newBufferedTerminal(nil, headlessSize)}
+}
+
+// 描いた画面は誰にも送らない
+func (h *headlessTerminal) Flush() error {
+	return nil
+}
+
+// base を Flush し、前回からの差分を返す
+// resized は前回の Flush から画面サイズが変わったかどうか
+// 呼び出し側で b.mu を保持した状態で呼ぶ
+func (b *bufferedTerminal) flushDiff() (diff []byte, resized bool, err error) {
+	if b.base != nil {
+		if err := b.base.Flush(); err != nil {
+			return nil, false, err
+		}
+	}
+	resized = b.sent != nil && b.sent.size != b.screen.size
+	diff = b.screen.ansiDiff(b.sent)
+	b.sent = b.screen.clone()
+	return diff, resized, nil
+}
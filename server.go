@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/keyboard"
+	"github.com/mum4k/termdash/terminal/termbox"
+	"github.com/mum4k/termdash/terminal/terminalapi"
+)
+
+// 専用サーバーと端末クライアント
+//
+// explorergame server はネイティブ端末を持たずにシミュレーションだけを動かし (-headless)、配置 (ブラウザや
+// 端末クライアント) を何人でもつなげる。-web を省くと defaultServerAddr で待ち受ける。ほかのオプションは通常の起動と同じ。
+// ほかの機械の配置を受け付けるには -web-public を付ける (付けなければ 127.0.0.1 だけで待ち受ける)。
+// シミュレーションを進めるのはサーバーだけで、配置には描いた画面を送り、配置からはキー入力だけを受け取る。
+//
+//	explorergame server -web :8080 -web-public -scenario scenarios/rendezvous.json
+//	explorergame connect localhost:8080
+//
+// explorergame connect HOST:PORT [STATION] は端末からサーバーにつなぐ配置で、ブラウザと同じ約束 (wsProtocolVersion) で話す。
+// 切れたら間隔を広げながら猶予 (stationGracePeriod) のあいだ再接続を試し、同じ配置として戻る。Ctrl-C で抜ける。
+// 画面の下には先読みした計器の値と回線の往復の時間、送信中の命令の数を出す (latency.go)。
+// 端末の大きさは headlessSize より 1 行以上大きくしておく。
+
+// server で -web を省いたときの待ち受けアドレス
+const defaultServerAddr = ":8080"
+
+// 再接続の間隔の初めと上限 (ブラウザと同じ)
+const (
+	reconnectDelay    = 500 * time.Millisecond
+	reconnectMaxDelay = 5 * time.Second
+)
+
+// 引数の先頭が server なら取り除いて true を返す (flag.Parse の前に呼ぶ)
+func serverSubcommand() bool {
+	if len(os.Args) > 1 && os.Args[1] == "server" {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+		return true
+	}
+	return false
+}
+
+// サーバーが版の違いで断ってきた
+type protocolError struct {
+	server  int
+	message string
+}
+
+func (e protocolError) Error() string {
+	return fmt.Sprintf("%s (server protocol %d, this client %d)", e.message, e.server, wsProtocolVersion)
+}
+
+// connect サブコマンド
+func runConnect(args []string, out io.Writer) int {
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Fprintln(out, "usage: explorergame connect HOST:PORT [STATION]")
+		return 2
+	}
+	c := &stationClient{addr: args[0], screen: os.Stdout, predictor: &instrumentPredictor{}, acks: newOrderAcks()}
+	if len(args) == 2 {
+		c.station = args[1]
+	}
+
+	// 入力と画面の大きさを知るためだけに使い、描くのはサーバーから届いた内容をそのまま書き出す
+	t, err := termbox.New()
+	if err != nil {
+		panic(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go c.readKeys(ctx, t, cancel)
+	go c.drawStatus(ctx)
+	err = c.run(ctx)
+	cancel()
+	t.Close()
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	return 0
+}
+
+// 端末からつなぐ配置
+type stationClient struct {
+	addr    string
+	station string
+
+	// 画面への書き出しは screenMu を保持して行う
+	screenMu sync.Mutex
+	screen   io.Writer
+
+	predictor *instrumentPredictor
+	acks      *orderAcks
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (c *stationClient) print(s string) {
+	c.screenMu.Lock()
+	defer c.screenMu.Unlock()
+	io.WriteString(c.screen, s)
+}
+
+// つなぎ、切れたら猶予のあいだつなぎ直す。ctx が終わるか、戻れなくなるまで返らない
+func (c *stationClient) run(ctx context.Context) error {
+	var lostAt time.Time
+	delay := reconnectDelay
+	for {
+		greeted, err := c.session(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if perr, ok := err.(protocolError); ok {
+			return perr
+		}
+		if greeted {
+			lostAt, delay = time.Time{}, reconnectDelay
+		}
+		if lostAt.IsZero() {
+			lostAt = time.Now()
+			c.print("\r\n\x1b[33m[connection lost, reconnecting...]\x1b[0m\r\n")
+		}
+		if time.Since(lostAt) > stationGracePeriod {
+			return fmt.Errorf("connection to %s closed: %v", c.addr, err)
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil
+		}
+		if delay *= 2; delay > reconnectMaxDelay {
+			delay = reconnectMaxDelay
+		}
+	}
+}
+
+// 1回分の接続。サーバーの hello を受け取れたかどうかと、切れた理由を返す
+func (c *stationClient) session(ctx context.Context) (bool, error) {
+	path := "/ws?protocol=" + strconv.Itoa(wsProtocolVersion) + "&station=" + url.QueryEscape(c.station)
+	conn, br, err := wsDial(c.addr, path)
+	if err != nil {
+		return false, err
+	}
+	c.mu.Lock()
+	c.conn = conn
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.conn = nil
+		c.mu.Unlock()
+		conn.Close()
+	}()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	return c.receive(br)
+}
+
+// サーバーからのフレームを画面に書き出す
+func (c *stationClient) receive(br *bufio.Reader) (bool, error) {
+	greeted := false
+	for {
+		opcode, payload, err := wsReadFrameLimit(br, wsMaxScreenPayload)
+		if err != nil {
+			return greeted, err
+		}
+		switch opcode {
+		case wsOpClose:
+			return greeted, io.EOF
+		case wsOpPing:
+			c.write(wsOpPong, payload)
+		case wsOpBinary:
+			c.print(string(payload))
+		case wsOpText:
+			var msg wsServerMessage
+			if err := json.Unmarshal(payload, &msg); err != nil {
+				continue
+			}
+			switch msg.Type {
+			case "error":
+				return greeted, protocolError{server: msg.Protocol, message: msg.Message}
+			case "hello":
+				// 続けて画面全体が届くので、前の表示を消しておく
+				greeted = true
+				c.station = msg.Station
+				c.acks.reset()
+				c.print("\x1b[0m\x1b[2J\x1b[H")
+			case "ack":
+				c.acks.acked(msg.Seq, time.Now())
+			case "instruments":
+				var r instrumentReading
+				if err := json.Unmarshal(payload, &r); err == nil {
+					c.predictor.update(r, time.Now())
+				}
+			}
+		}
+	}
+}
+
+// つながっていればフレームを送る。つながっていなければ捨てる
+func (c *stationClient) write(opcode byte, payload []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		wsWriteClientFrame(c.conn, opcode, payload)
+	}
+}
+
+func (c *stationClient) send(msg wsClientMessage) {
+	data, _ := json.Marshal(msg)
+	c.write(wsOpText, data)
+}
+
+// 端末のキー入力をサーバーへ送る。Ctrl-C で quit を呼ぶ
+func (c *stationClient) readKeys(ctx context.Context, t terminalapi.Terminal, quit func()) {
+	for {
+		switch ev := t.Event(ctx).(type) {
+		case nil:
+			if ctx.Err() != nil {
+				return
+			}
+		case *terminalapi.Keyboard:
+			if ev.Key == keyboard.KeyCtrlC {
+				quit()
+				return
+			}
+			if data := terminalInput(ev.Key); data != "" {
+				c.send(wsClientMessage{Type: "input", Data: data, Seq: c.acks.sent(time.Now())})
+			}
+		case *terminalapi.Resize:
+			// 大きさを変えると表示が崩れるので送り直してもらう
+			c.print("\x1b[0m\x1b[2J")
+			c.send(wsClientMessage{Type: "resync"})
+		}
+	}
+}
+
+// 画面の下の行に計器の値と回線の様子を書き直し続ける
+func (c *stationClient) drawStatus(ctx context.Context) {
+	ticker := time.NewTicker(instrumentRedraw)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			r, ok := c.predictor.predict(now)
+			// カーソルの位置を保存してから書き、元に戻す
+			c.print(fmt.Sprintf("\x1b7\x1b[%d;1H\x1b[0m\x1b[2K%s\x1b8", headlessSize.Y+1, stationStatusLine(r, ok, c.acks)))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// キーを xterm の入力の文字列に直す (parseBrowserInput の逆)
+func terminalInput(k keyboard.Key) string {
+	switch k {
+	case keyboard.KeyArrowUp:
+		return "\x1b[A"
+	case keyboard.KeyArrowDown:
+		return "\x1b[B"
+	case keyboard.KeyArrowRight:
+		return "\x1b[C"
+	case keyboard.KeyArrowLeft:
+		return "\x1b[D"
+	case keyboard.KeyPgUp:
+		return "\x1b[5~"
+	case keyboard.KeyPgDn:
+		return "\x1b[6~"
+	case keyboard.KeyEnter:
+		return "\r"
+	case keyboard.KeyTab:
+		return "\t"
+	case keyboard.KeyEsc:
+		return "\x1b"
+	case keyboard.KeyBackspace, keyboard.KeyBackspace2:
+		return "\x7f"
+	}
+	if k >= ' ' {
+		return string(rune(k))
+	}
+	return ""
+}
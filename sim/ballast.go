@@ -0,0 +1,30 @@
+package sim
+
+// メインバラストタンク
+//
+// 浮上中はタンクを空にして大きな予備浮力を持つ。ベントを開いて注水すると予備浮力がなくなり、
+// わずかに重くなって潜航する。高圧空気で排水 (ブロー) すると浮上する。
+// 細かな深度の調整はトリムタンクで行う。
+
+const (
+	// タンクが空のときの予備浮力 (中立浮力からの差)
+	ballastReserveBuoyancy = 20.0
+	// 満水のときの浮力 (中立浮力からの差)。少し重くして潜航できるようにする
+	ballastFloodedBuoyancy = -2.0
+)
+
+// メインバラストタンクによる浮力 (中立浮力からの差)
+func (p *Player) BallastBuoyancy() float64 {
+	flooded := p.Ballast.Actual / 100
+	return ballastReserveBuoyancy + (ballastFloodedBuoyancy-ballastReserveBuoyancy)*flooded
+}
+
+// 艦全体の浮力 (中立浮力からの差)。浸水した分だけ重くなる
+func (p *Player) NetBuoyancy() float64 {
+	return p.BallastBuoyancy() + p.Trim.Actual + p.FloodingBuoyancy()
+}
+
+// ブロー中か
+func (p *Player) Blowing() bool {
+	return p.Ballast.Ordered < p.Ballast.Actual && !p.Ballast.Settled()
+}
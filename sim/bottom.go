@@ -0,0 +1,178 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+)
+
+// 着底 (海底に沈座して静粛を保つ)
+//
+// 行き足を止めて浮力を負にすると海底に沈座できる。機関を停止すれば雑音はほぼなくなるが、
+// 岩の海底では船体を傷める。離底するときは機関を再始動してから排水し、海底の吸着を振り切る。
+
+// 海底の種類
+type BottomType int
+
+const (
+	BottomMud BottomType = iota
+	BottomSand
+	BottomRock
+	// 浅い岩場に茂る海藻の森
+	BottomKelp
+	// 沈船
+	BottomWreck
+)
+
+func (b BottomType) String() string {
+	switch b {
+	case BottomSand:
+		return "sand"
+	case BottomRock:
+		return "rock"
+	case BottomKelp:
+		return "kelp"
+	case BottomWreck:
+		return "wreck"
+	}
+	return "mud"
+}
+
+// String の逆
+func ParseBottomType(s string) (BottomType, error) {
+	for b := BottomMud; b <= BottomWreck; b++ {
+		if b.String() == s {
+			return b, nil
+		}
+	}
+	return BottomMud, fmt.Errorf("unknown bottom type %q", s)
+}
+
+// 触れると船体が傷む硬い海底か
+func (b BottomType) Hard() bool {
+	return b == BottomRock || b == BottomWreck
+}
+
+// 海底から離れるのに必要な余分な浮力 (泥ほど吸い付く)
+func (b BottomType) suction() float64 {
+	switch b {
+	case BottomMud:
+		return 3.0
+	case BottomKelp:
+		// 海藻が絡みつく
+		return 2.0
+	case BottomSand:
+		return 1.0
+	}
+	return 0.0
+}
+
+// 海底の地形
+type Seabed interface {
+	// (x, y) の水深 (m) と海底の種類
+	SeabedAt(x, y float64) (float64, BottomType)
+}
+
+// 緩やかに起伏し、ところどころ岩場になっている海底
+// 地形を渡さないときに使う。world パッケージの地形でも出発地点の周りはこれになる
+type GentleSeabed struct{}
+
+func (GentleSeabed) SeabedAt(x, y float64) (float64, BottomType) {
+	depth := 300 + 80*math.Sin(x/900)*math.Cos(y/700)
+	kind := BottomMud
+	switch v := math.Sin(x/400 + y/300); {
+	case v > 0.6:
+		kind = BottomRock
+	case v > 0.2:
+		kind = BottomSand
+	}
+	return depth, kind
+}
+
+const (
+	// これより速い状態で海底に触れると座礁とみなす
+	bottomMaxVelocity = 2.0
+	// これより速く沈んでいる状態で海底に触れると座礁とみなす (m/s)
+	bottomMaxSinkRate = 1.0
+	// 進む先の海底がこれより速くせり上がってくると、斜面に衝突したとみなす (m/s)
+	bottomMaxClimbRate = 1.0
+	// 離底後、トリムを戻すまでに海底から離れる距離 (m)
+	liftOffClearance = 5.0
+)
+
+// 1ティック分の着底・離底処理
+// before はこのティックで動く前の位置。上下方向の速度を積分する前に呼ぶ
+func updateBottom(p *Player, floor Seabed, before Point3D, events []Event) []Event {
+	seabed, kind := floor.SeabedAt(p.Position.X, p.Position.Y)
+	// 動いた先の海底が盛り上がっていれば、その速さで斜面に突っ込んでいる
+	previous, _ := floor.SeabedAt(before.X, before.Y)
+	climbRate := math.Max(previous-seabed, 0) / TickDuration.Seconds()
+
+	// 急な斜面に突っ込んだら、その手前で止まる
+	if p.Depth() >= seabed && climbRate > bottomMaxClimbRate {
+		// 斜面には艦首から当たる
+		p.TakeDamage(CompartmentTorpedoRoom, climbRate*3+p.Velocity*0.5)
+		p.Position.X, p.Position.Y = before.X, before.Y
+		p.Velocity = 0
+		return append(events, Event{Kind: EventCollision, Position: p.Position, Bottom: kind, Seabed: seabed, Hull: p.HullIntegrity})
+	}
+
+	if !p.Bottomed && p.Depth() >= seabed {
+		p.Position.Z = -seabed
+		sinkRate := -p.VerticalVelocity * 60
+		p.Bottomed = true
+		p.VerticalVelocity = 0
+		e := Event{Kind: EventSettled, Position: p.Position, Bottom: kind, Seabed: seabed}
+		// 沈む速さに、斜面がせり上がってくる速さを足して海底にぶつかる速さとする
+		if closing := sinkRate + climbRate; closing > bottomMaxSinkRate || p.Velocity >= bottomMaxVelocity {
+			// 海底には竜骨の中ほどから当たる
+			p.TakeDamage(CompartmentControlRoom, closing*2+p.Velocity*0.5)
+			e.Kind = EventHardGrounding
+		}
+		e.Hull = p.HullIntegrity
+		events = append(events, e)
+	}
+
+	if !p.Bottomed {
+		return events
+	}
+
+	// 離底作業: 吸着を振り切るまで排水する
+	if p.LiftingOff {
+		p.Trim.Order(kind.suction() + 2 - p.BallastBuoyancy())
+	}
+
+	// 海底の吸着力より浮力が勝たない限り沈座したまま
+	if p.NetBuoyancy() <= kind.suction() {
+		p.Position.Z = -seabed
+		p.VerticalVelocity = 0
+		p.BuoyancyAcceleration = 0
+	} else if p.Depth() < seabed-0.5 {
+		p.Bottomed = false
+		events = append(events, Event{Kind: EventClearOfBottom, Position: p.Position, Bottom: kind, Seabed: seabed, Hull: p.HullIntegrity})
+	}
+
+	// 海底を這うと抵抗が大きく、岩場や沈船の上では船体が削れる
+	p.Velocity *= 0.9
+	if kind.Hard() && p.Velocity > 0.5 {
+		before := p.HullIntegrity
+		p.HullIntegrity = math.Max(p.HullIntegrity-p.Velocity*0.002, 0)
+		if math.Floor(before/10) != math.Floor(p.HullIntegrity/10) {
+			events = append(events, Event{Kind: EventScraping, Position: p.Position, Bottom: kind, Seabed: seabed, Hull: p.HullIntegrity})
+		}
+	}
+	return events
+}
+
+// 離底後、十分離れたらトリムを中立に戻す
+func updateLiftOff(p *Player, floor Seabed, events []Event) []Event {
+	if !p.LiftingOff || p.Bottomed {
+		return events
+	}
+	seabed, kind := floor.SeabedAt(p.Position.X, p.Position.Y)
+	if p.Depth() <= seabed-liftOffClearance {
+		p.LiftingOff = false
+		p.Trim.Order(-p.BallastBuoyancy())
+		events = append(events, Event{Kind: EventLiftOffComplete, Position: p.Position, Bottom: kind, Seabed: seabed, Hull: p.HullIntegrity})
+	}
+	return events
+}
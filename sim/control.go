@@ -0,0 +1,121 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	// 針路と速力に追いついたとみなす幅 (度、ノット)
+	courseTolerance = 1.0
+	speedTolerance  = 0.5
+)
+
+// 命令値と実際の値
+//
+// 舵やタービン、トリムポンプは命令を受けてもすぐには動かず、機器の動作速度で
+// 命令値に近づいていく。表示はどれも命令値 (ORD) と実際の値 (ACT) を並べて出す。
+//
+// 針路・速力・深度も同じ形で持つが、実際の値は機器ではなく艦の動きで決まる。
+// ティックの終わりに艦首方位・速度・深度を Measure で写し、Rate は追いついたとみなす幅に使う。
+type Control struct {
+	// 命令値の範囲
+	Min, Max float64
+	// 1ティックで実際の値が動ける量 (Measure で写すものは、追いついたとみなす幅)
+	Rate float64
+	// 方位のように Min と Max がつながっているか。命令値は範囲に回し入れ、差は近いほうの向きで測る
+	Circular bool
+
+	Ordered float64
+	Actual  float64
+}
+
+// 命令値を範囲に収めて設定する
+func (c *Control) Order(v float64) {
+	if c.Circular {
+		c.Ordered = c.wrap(v)
+		return
+	}
+	c.Ordered = math.Max(math.Min(v, c.Max), c.Min)
+}
+
+// Circular のとき、v を Min 以上 Max 未満に回し入れる
+func (c *Control) wrap(v float64) float64 {
+	span := c.Max - c.Min
+	return c.Min + math.Mod(math.Mod(v-c.Min, span)+span, span)
+}
+
+// 命令値と実際の値の差 (命令値 - 実際の値)
+func (c *Control) Error() float64 {
+	d := c.Ordered - c.Actual
+	if c.Circular {
+		span := c.Max - c.Min
+		d = math.Mod(math.Mod(d, span)+span*1.5, span) - span/2
+	}
+	return d
+}
+
+// 1ティック分、実際の値を命令値に近づける
+func (c *Control) Slew() {
+	c.Actual += math.Max(math.Min(c.Error(), c.Rate), -c.Rate)
+	if c.Circular {
+		c.Actual = c.wrap(c.Actual)
+	}
+}
+
+// 実際の値を艦の動きから測った値にする (針路・速力・深度)
+func (c *Control) Measure(v float64) {
+	c.Actual = v
+}
+
+// 実際の値が命令値に追いついているか
+func (c *Control) Settled() bool {
+	return math.Abs(c.Error()) < c.Rate
+}
+
+// 命令値と実際の値の表示
+// format は1つの値を書式化するもの (例: "%+.1f")
+func (c *Control) Label(format string) string {
+	return fmt.Sprintf("ORD "+format+"  ACT "+format, c.Ordered, c.Actual)
+}
+
+func newTurbineControl() Control {
+	// 1秒あたり約 18 rpm
+	return Control{Min: 0, Max: 200, Rate: 0.3}
+}
+
+func newRudderControl() Control {
+	// 左が負、右が正。発令所が傷むと操舵機が遅くなる (updateDamage)
+	return Control{Min: -35, Max: 35, Rate: rudderRate}
+}
+
+func newTrimControl() Control {
+	return Control{Min: -hoverTrimLimit, Max: hoverTrimLimit, Rate: hoverPumpRate}
+}
+
+func newBallastControl() Control {
+	// 注水率 (%)。満水・空まで約 24 秒
+	return Control{Min: 0, Max: 100, Rate: 0.07}
+}
+
+func newCourseControl() Control {
+	// 度。1° 以内なら針路に乗っている
+	return Control{Min: 0, Max: 360, Rate: courseTolerance, Circular: true}
+}
+
+func newSpeedControl() Control {
+	// ノット
+	return Control{Min: 0, Max: 250, Rate: speedTolerance}
+}
+
+func newHoverDepthControl() Control {
+	// m。ホバリングは hoverBand の中に留まる
+	return Control{Min: 0, Max: math.Inf(1), Rate: hoverBand}
+}
+
+// 針路・速力・深度の実際の値を写す (ティックの終わりとセーブデータを読んだあとに呼ぶ)
+func (p *Player) MeasureControls() {
+	p.Course.Measure(p.Direction)
+	p.Speed.Measure(p.Velocity)
+	p.HoverDepth.Measure(p.Depth())
+}
@@ -0,0 +1,99 @@
+package sim
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestControlOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		c    Control
+		v    float64
+		want float64
+	}{
+		{"in range", newRudderControl(), 10, 10},
+		{"clamped high", newRudderControl(), 50, 35},
+		{"clamped low", newRudderControl(), -50, -35},
+		{"course wraps past north", newCourseControl(), 365, 5},
+		{"course wraps below north", newCourseControl(), -15, 345},
+		{"course 360 is north", newCourseControl(), 360, 0},
+		{"speed not negative", newSpeedControl(), -3, 0},
+	}
+	for _, tt := range tests {
+		tt.c.Order(tt.v)
+		if math.Abs(tt.c.Ordered-tt.want) > 1e-9 {
+			t.Errorf("%s: Order(%v) = %v, want %v", tt.name, tt.v, tt.c.Ordered, tt.want)
+		}
+	}
+}
+
+func TestControlError(t *testing.T) {
+	tests := []struct {
+		name            string
+		c               Control
+		ordered, actual float64
+		want            float64
+		settled         bool
+	}{
+		{"rudder", newRudderControl(), 10, 4, 6, false},
+		{"course right", newCourseControl(), 30, 10, 20, false},
+		// 北をまたぐときは近いほうへ
+		{"course right across north", newCourseControl(), 10, 350, 20, false},
+		{"course left across north", newCourseControl(), 350, 10, -20, false},
+		{"on course", newCourseControl(), 90, 89.5, 0.5, true},
+		{"on course across north", newCourseControl(), 0, 359.5, 0.5, true},
+		{"speed", newSpeedControl(), 12, 11.8, 0.2, true},
+		{"hover band", newHoverDepthControl(), 50, 52, -2, false},
+	}
+	for _, tt := range tests {
+		tt.c.Order(tt.ordered)
+		tt.c.Measure(tt.actual)
+		if got := tt.c.Error(); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: Error() = %v, want %v", tt.name, got, tt.want)
+		}
+		if got := tt.c.Settled(); got != tt.settled {
+			t.Errorf("%s: Settled() = %v, want %v", tt.name, got, tt.settled)
+		}
+	}
+}
+
+func TestControlSlew(t *testing.T) {
+	c := newRudderControl()
+	c.Order(35)
+	ticks := 0
+	for !c.Settled() {
+		c.Slew()
+		ticks++
+		if ticks > 10000 {
+			t.Fatal("rudder never reached the ordered angle")
+		}
+	}
+	if want := int(math.Ceil(35/rudderRate)) - 1; ticks < want {
+		t.Errorf("rudder hard over in %d ticks, faster than the steering gear (%d)", ticks, want)
+	}
+
+	// 方位は近いほうへ回り、範囲の中に留まる
+	course := Control{Min: 0, Max: 360, Rate: 5, Circular: true}
+	course.Actual = 355
+	course.Order(10)
+	course.Slew()
+	if course.Actual != 0 {
+		t.Errorf("circular slew from 355 toward 10 = %v, want 0", course.Actual)
+	}
+}
+
+// ティックのあとは針路・速力・深度の実際の値が艦の動きと同じ
+func TestMeasuredControlsFollowTheBoat(t *testing.T) {
+	p := NewPlayer()
+	w := NewWorld(&p, rand.New(rand.NewSource(trajectorySeed)))
+	p.Turbine.Order(100)
+	p.Rudder.Order(10)
+	p.Ballast.Order(100)
+	w.Step(TickDuration * 3000)
+	if p.Course.Actual != p.Direction || p.Speed.Actual != p.Velocity || p.HoverDepth.Actual != p.Depth() {
+		t.Errorf("course %v / heading %v, speed %v / velocity %v, depth %v / %v",
+			p.Course.Actual, p.Direction, p.Speed.Actual, p.Velocity, p.HoverDepth.Actual, p.Depth())
+	}
+}
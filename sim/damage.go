@@ -0,0 +1,91 @@
+package sim
+
+import "math"
+
+// 区画ごとの損傷
+//
+// 艦を船首から船尾へ 4 つの区画に分け、それぞれに耐久値 (0 ~ 100) を持たせる。衝突や被雷で船体の健全度が
+// 下がると、当たった区画の耐久値も下がり、その区画にある機器の性能が落ちる。
+//   - 発射管室 (船首): 艦首のソーナーの感度 (ソーナーは呼び出し側で扱う)
+//   - 発令所: 操舵機の動作速度 (操舵員の腕 HelmEfficiency も掛かる)
+//   - 原子炉区画と機械室: タービンの最大回転数
+// 耐久値が 0 になっても機器の性能は DamagedPerformance までしか落ちない (応急で動かし続ける)。
+
+type Compartment int
+
+const (
+	CompartmentTorpedoRoom Compartment = iota
+	CompartmentControlRoom
+	CompartmentReactor
+	CompartmentEngineRoom
+	CompartmentCount
+)
+
+const (
+	// 耐久値が 0 の区画の機器の性能 (割合)
+	DamagedPerformance = 0.25
+	// 船体の健全度 1% あたりに区画の耐久値が下がる量
+	compartmentDamageFactor = 1.5
+	// 操舵機の動作速度 (1ティックあたりの度)。1秒あたり約 2.4 度
+	rudderRate = 0.04
+)
+
+func (c Compartment) String() string {
+	switch c {
+	case CompartmentTorpedoRoom:
+		return "torpedo room"
+	case CompartmentControlRoom:
+		return "control room"
+	case CompartmentReactor:
+		return "reactor compartment"
+	case CompartmentEngineRoom:
+		return "engine room"
+	}
+	return "unknown"
+}
+
+// 区画の耐久値 (船首から船尾の順)
+type Compartments [CompartmentCount]float64
+
+func newCompartments() Compartments {
+	var c Compartments
+	for i := range c {
+		c[i] = 100
+	}
+	return c
+}
+
+// 艦首から見た相対方位 (度) の被害が当たる区画
+func CompartmentAt(relative float64) Compartment {
+	switch off := math.Abs(NormalizeRelative(relative)); {
+	case off < 45:
+		return CompartmentTorpedoRoom
+	case off < 90:
+		return CompartmentControlRoom
+	case off < 135:
+		return CompartmentReactor
+	}
+	return CompartmentEngineRoom
+}
+
+// 船体の健全度を amount (%) 下げ、区画 c を傷める
+func (p *Player) TakeDamage(c Compartment, amount float64) {
+	p.HullIntegrity = math.Max(p.HullIntegrity-amount, 0)
+	p.Compartments[c] = math.Max(p.Compartments[c]-amount*compartmentDamageFactor, 0)
+}
+
+// 区画 c にある機器の性能 (DamagedPerformance ~ 1)
+func (p *Player) Performance(c Compartment) float64 {
+	return DamagedPerformance + (1-DamagedPerformance)*p.Compartments[c]/100
+}
+
+// 損傷を考えたタービンの最大回転数
+func (p *Player) MaxTurbine() float64 {
+	return p.Turbine.Max * math.Min(p.Performance(CompartmentReactor), p.Performance(CompartmentEngineRoom))
+}
+
+// 1ティック分、損傷した機器の性能を反映する
+func updateDamage(p *Player) {
+	p.Turbine.Actual = math.Min(p.Turbine.Actual, p.MaxTurbine())
+	p.Rudder.Rate = rudderRate * p.Performance(CompartmentControlRoom) * p.HelmEfficiency
+}
@@ -0,0 +1,42 @@
+package sim
+
+type EventKind int
+
+const (
+	// 静かに着底した
+	EventSettled EventKind = iota
+	// 速すぎる状態で海底に触れ、船体を傷めた
+	EventHardGrounding
+	// 海底を離れた
+	EventClearOfBottom
+	// 岩場を這って船体が削れた (健全度が 10% 下がるごと)
+	EventScraping
+	// 離底が終わり、トリムを中立に戻した
+	EventLiftOffComplete
+	// 海底の急な斜面に突っ込み、船体を傷めて止まった
+	EventCollision
+	// 燃料が尽きてタービンが止まった
+	EventFuelExhausted
+	// 炉心が過熱してスクラムした
+	EventReactorScram
+	// 区画に浸水が始まった
+	EventFloodingStarted
+	// 区画が満水になった
+	EventCompartmentFlooded
+	// 区画の水を排水し終えた
+	EventCompartmentDry
+)
+
+// シミュレーション中に起きた出来事
+type Event struct {
+	Kind EventKind
+	// 起きた位置
+	Position Point3D
+	// その位置の海底の種類と深さ (m)
+	Bottom BottomType
+	Seabed float64
+	// 起きた後の船体の健全度
+	Hull float64
+	// 浸水の出来事が起きた区画
+	Compartment Compartment
+}
@@ -0,0 +1,79 @@
+package sim
+
+import "math"
+
+// 浸水とビルジポンプ
+//
+// 耐久値が BreachThreshold を下回った区画は破口が開き、海水が入ってくる。破口が大きいほど、
+// 深いほど (水圧が高いほど) 速く入る。入った水は艦を重くするので、浸水が進むとブローしても
+// 浮き上がれなくなる。ビルジポンプで排水できるが、深いほど水圧に負けて排水が遅くなり、
+// 機関を止めていると動かない。排水の速さには応急員の腕 DamageControlEfficiency も掛かる。
+
+const (
+	// 耐久値がこれを下回ると破口が開く
+	BreachThreshold = 70.0
+	// 破口が最も大きい (耐久値 0) ときに深度 100 m で入る水 (区画の容積の %/秒)
+	floodRate = 0.5
+	// ビルジポンプが 1 区画から出せる水 (区画の容積の %/秒)。pumpMaxDepth で 0 になる
+	pumpRate     = 0.2
+	pumpMaxDepth = 400.0
+	// 1 区画が満水になったときに失う浮力 (中立浮力からの差)
+	compartmentFloodedBuoyancy = 8.0
+)
+
+// 区画 c に破口が開いているか
+func (p *Player) Breached(c Compartment) bool {
+	return p.Compartments[c] < BreachThreshold
+}
+
+// 浸水で失っている浮力 (負の値)
+func (p *Player) FloodingBuoyancy() float64 {
+	total := 0.0
+	for _, f := range p.Flooding {
+		total += f / 100
+	}
+	return -compartmentFloodedBuoyancy * total
+}
+
+// ビルジポンプが動いているか。機関を止めているか原子炉がスクラムしていれば動かない
+func (p *Player) PumpsRunning() bool {
+	return p.BilgePumps && !p.MachinerySecured && !p.Reactor.Scrammed
+}
+
+// 浸水している区画があるか
+func (p *Player) Flooded() bool {
+	for _, f := range p.Flooding {
+		if f > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// dt 秒分だけ浸水と排水を進める
+func updateFlooding(p *Player, dt float64, events []Event) []Event {
+	pressure := math.Sqrt(math.Max(p.Depth(), 10) / 100)
+	pump := 0.0
+	if p.PumpsRunning() {
+		pump = pumpRate * p.DamageControlEfficiency * math.Max(1-p.Depth()/pumpMaxDepth, 0)
+	}
+	for i := range p.Flooding {
+		c := Compartment(i)
+		before := p.Flooding[i]
+		ingress := 0.0
+		if p.Breached(c) {
+			size := (BreachThreshold - p.Compartments[i]) / BreachThreshold
+			ingress = floodRate * size * pressure
+		}
+		p.Flooding[i] = math.Max(math.Min(before+(ingress-pump)*dt, 100), 0)
+		switch after := p.Flooding[i]; {
+		case before == 0 && after > 0:
+			events = append(events, Event{Kind: EventFloodingStarted, Position: p.Position, Compartment: c, Hull: p.HullIntegrity})
+		case before < 100 && after >= 100:
+			events = append(events, Event{Kind: EventCompartmentFlooded, Position: p.Position, Compartment: c, Hull: p.HullIntegrity})
+		case before > 0 && after == 0:
+			events = append(events, Event{Kind: EventCompartmentDry, Position: p.Position, Compartment: c, Hull: p.HullIntegrity})
+		}
+	}
+	return events
+}
@@ -0,0 +1,37 @@
+package sim
+
+// 船体の汚れ (フジツボや海藻の付着)
+//
+// 長い哨戒のあいだに船体が汚れていき、水の抵抗が増えて速力が落ち、流れの音も大きくなる。
+// 止まっているときほど早く付く。落とすのは潜水員か港の仕事で、ここでは付くだけを扱う。
+
+const (
+	// 1時間で付く汚れ (%)
+	foulingRateUnderway = 2.0
+	foulingRateIdle     = 4.0
+	// これより遅いと止まっているとみなす (ノット)
+	foulingIdleVelocity = 2.0
+	// 汚れ 1% あたり、1ティックで余分に落ちる速度の割合
+	// 汚れ切ると最高速力が約 2 割落ちる
+	foulingDrag = 0.000021
+	// 汚れのない船体での、1ティックで落ちる速度の割合 (減速係数の平均)
+	cleanHullDrag = 0.0085
+)
+
+// 汚れで落ちている速力の割合 (0 ~ 1)
+func (p *Player) FoulingSpeedLoss() float64 {
+	extra := p.Fouling * foulingDrag
+	return extra / (cleanHullDrag + extra)
+}
+
+// dt 秒分だけ汚れを付ける
+func updateFouling(p *Player, dt float64) {
+	rate := foulingRateUnderway
+	if p.Velocity < foulingIdleVelocity {
+		rate = foulingRateIdle
+	}
+	p.Fouling += rate * dt / 3600
+	if p.Fouling > 100 {
+		p.Fouling = 100
+	}
+}
@@ -0,0 +1,80 @@
+package sim
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// 船体は時間とともに汚れ、止まっているほうが早く汚れる
+func TestFoulingBuildsUpOverTime(t *testing.T) {
+	tests := []struct {
+		name    string
+		turbine float64
+		hours   int
+		want    float64
+	}{
+		{"idle", 0, 1, foulingRateIdle},
+		{"underway", 150, 1, foulingRateUnderway},
+		{"idle for a day", 0, 24, 24 * foulingRateIdle},
+	}
+	for _, tt := range tests {
+		p := NewPlayer()
+		w := NewWorld(&p, rand.New(rand.NewSource(trajectorySeed)))
+		p.Turbine.Order(tt.turbine)
+		p.Turbine.Actual = tt.turbine
+		// 航行中は速力が上がりきってから測る
+		w.Step(10 * time.Minute)
+		p.Fouling = 0
+		for h := 0; h < tt.hours; h++ {
+			w.Step(time.Hour)
+		}
+		if math.Abs(p.Fouling-tt.want) > 0.01 {
+			t.Errorf("%s: fouling %.3f%% after %d h, want %.3f%%", tt.name, p.Fouling, tt.hours, tt.want)
+		}
+	}
+}
+
+func TestFoulingIsCapped(t *testing.T) {
+	p := NewPlayer()
+	p.Fouling = 99.9
+	updateFouling(&p, 3600)
+	if p.Fouling != 100 {
+		t.Errorf("fouling %v, want 100", p.Fouling)
+	}
+}
+
+// 汚れるほど速力が落ち、汚れ切ると約 2 割落ちる
+func TestFoulingSpeedLoss(t *testing.T) {
+	tests := []struct {
+		fouling  float64
+		min, max float64
+	}{
+		{0, 0, 0},
+		{10, 0.02, 0.03},
+		{50, 0.10, 0.12},
+		{100, 0.19, 0.21},
+	}
+	for _, tt := range tests {
+		p := NewPlayer()
+		p.Fouling = tt.fouling
+		if got := p.FoulingSpeedLoss(); got < tt.min || got > tt.max {
+			t.Errorf("FoulingSpeedLoss at %v%% = %.3f, want %.2f-%.2f", tt.fouling, got, tt.min, tt.max)
+		}
+	}
+
+	// 汚れた船体は同じ回転数でも遅い
+	speed := func(fouling float64) float64 {
+		p := NewPlayer()
+		p.Fouling = fouling
+		w := NewWorld(&p, rand.New(rand.NewSource(trajectorySeed)))
+		p.Turbine.Order(150)
+		w.Step(10 * time.Minute)
+		return p.Velocity
+	}
+	clean, fouled := speed(0), speed(100)
+	if fouled > clean*0.85 {
+		t.Errorf("top speed %.1f kt fouled, %.1f kt clean", fouled, clean)
+	}
+}
@@ -0,0 +1,53 @@
+package sim
+
+import (
+	"math"
+	"time"
+)
+
+// 燃料
+//
+// タービンは実際の回転数に比例して燃料を使う。燃料が尽きるとタービンは止まり、
+// 艦は行き足を失って海流に流されるだけになる (浮力の操作はできる)。
+
+const (
+	// 満載の燃料
+	FuelCapacity = 102241.0
+	// タービン 1 rpm あたり 1 秒に使う燃料 (100 rpm で約 5.7 時間)
+	fuelPerRpmSecond = 0.05
+)
+
+// 今の回転数で1秒に使う燃料
+func (p *Player) FuelBurnRate() float64 {
+	return math.Max(p.Turbine.Actual, 0) * fuelPerRpmSecond
+}
+
+// 今の回転数のままで燃料が持つ時間
+// 燃料を使っていなければ false
+func (p *Player) Endurance() (time.Duration, bool) {
+	rate := p.FuelBurnRate()
+	if rate <= 0 {
+		return 0, false
+	}
+	return time.Duration(p.Fuel / rate * float64(time.Second)), true
+}
+
+// 燃料が尽きたか
+func (p *Player) OutOfFuel() bool {
+	return p.Fuel <= 0
+}
+
+// dt 秒分の燃料を使う
+func updateFuel(p *Player, dt float64, events []Event) []Event {
+	if p.OutOfFuel() {
+		p.Turbine.Order(0)
+		return events
+	}
+	p.Fuel -= p.FuelBurnRate() * dt
+	if p.OutOfFuel() {
+		p.Fuel = 0
+		p.Turbine.Order(0)
+		events = append(events, Event{Kind: EventFuelExhausted, Position: p.Position, Hull: p.HullIntegrity})
+	}
+	return events
+}
@@ -0,0 +1,41 @@
+package sim
+
+import "math"
+
+// 座標系: X は東、Y は北、Z は上 (海面が 0 で、潜ると負になる)
+// 方位は北を 0° として時計回りに測る
+
+// 3次元の座標データ
+type Point3D struct {
+	X float64
+	Y float64
+	Z float64
+}
+
+// 角度を 0 ~ 360 の範囲にする
+func NormalizeBearing(deg float64) float64 {
+	deg = math.Mod(deg, 360)
+	if deg < 0 {
+		deg += 360
+	}
+	return deg
+}
+
+// 角度を -180 ~ 180 の範囲にする
+func NormalizeRelative(deg float64) float64 {
+	deg = NormalizeBearing(deg)
+	if deg > 180 {
+		deg -= 360
+	}
+	return deg
+}
+
+// from から見た to の方位
+func BearingTo(from, to Point3D) float64 {
+	return NormalizeBearing(math.Atan2(to.X-from.X, to.Y-from.Y) * 180 / math.Pi)
+}
+
+// 水平距離 (m)
+func HorizontalDistance(a, b Point3D) float64 {
+	return math.Hypot(b.X-a.X, b.Y-a.Y)
+}
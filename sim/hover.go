@@ -0,0 +1,67 @@
+package sim
+
+import "math"
+
+// ホバリング (行き足のない状態での深度保持)
+//
+// 速力がほぼ 0 のときはダイブプレーンが効かないので、トリムタンクに注排水して
+// 浮力を調整し、目標深度の前後 hoverBand の範囲に留まる。
+
+const (
+	// これより速いと行き足があるとみなし、ホバリングしない
+	hoverMaxVelocity = 1.0
+	// 深度を保持しているとみなす範囲 (m)
+	hoverBand = 1.0
+	// トリムタンクのポンプが1ティックで動かせる浮力
+	hoverPumpRate = 0.02
+	// ホバリング中に使う浮力の範囲 (中立 ±)
+	hoverTrimLimit = 10.0
+)
+
+type HoverState int
+
+const (
+	HoverOff HoverState = iota
+	// 行き足があるため待機中
+	HoverUnavailable
+	// 目標深度に向けて注排水中
+	HoverPumping
+	// 目標深度を保持中
+	HoverHolding
+)
+
+func (s HoverState) String() string {
+	switch s {
+	case HoverUnavailable:
+		return "STANDBY (way on)"
+	case HoverPumping:
+		return "PUMPING"
+	case HoverHolding:
+		return "HOLDING"
+	}
+	return "OFF"
+}
+
+func (p *Player) HoverState() HoverState {
+	if !p.HoverEnabled {
+		return HoverOff
+	}
+	if p.Velocity >= hoverMaxVelocity {
+		return HoverUnavailable
+	}
+	if math.Abs(p.Depth()-p.HoverDepth.Ordered) <= hoverBand && math.Abs(p.VerticalVelocity) < 0.01 {
+		return HoverHolding
+	}
+	return HoverPumping
+}
+
+// 1ティック分、トリムタンクへの注排水を命じる
+func updateHover(p *Player) {
+	if p.Bottomed || p.HoverState() < HoverPumping {
+		return
+	}
+	// 深すぎる・沈んでいるときは排水して浮力を増やす
+	// メインバラストタンクの浮力はトリムで打ち消す
+	errorDepth := p.Depth() - p.HoverDepth.Ordered
+	p.Trim.Order(errorDepth*0.8 + p.VerticalVelocity*-200 - p.BallastBuoyancy())
+}
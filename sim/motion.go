@@ -0,0 +1,76 @@
+package sim
+
+import "math"
+
+// 1ティック分の運動
+//
+// World.tick の回転数・速度・向き・位置・上下の計算を、今の値を受けて dt 秒後の値を返す関数に分けたもの。
+// Player を直接触らず、乱数 (タービンの回転むらと水の抵抗のゆらぎ) も 0 ~ 1 の値として受けるので、
+// 同じ引数なら必ず同じ結果になる。係数はどれも 1 ティック (TickDuration) あたりの量で、dt がティックの何倍かで効かせる。
+// 手触りを調整したら決まった操艦の航跡が変わっていないかを go test で確かめる (motion_test.go)。
+
+// dt 秒が何ティック分か
+func ticks(dt float64) float64 {
+	return dt / TickDuration.Seconds()
+}
+
+// 回転むら jitter を受けて、dt 秒後のタービンの実際の回転数
+func nextTurbineRpm(actual, jitter, dt float64) float64 {
+	k := ticks(dt)
+	actual *= math.Pow(0.998, k)
+	return actual + actual*jitter*0.004*k
+}
+
+// dt 秒後の速度 (ノット) と加速度。jitter は水の抵抗のゆらぎ、fouling は船体の汚れ
+func nextVelocity(velocity, turbineRpm, fouling, jitter, dt float64) (float64, float64) {
+	k := ticks(dt)
+	acceleration := turbineRpm / 10.0
+	velocity += acceleration / 10 * k
+	velocity *= math.Pow(0.99+jitter*0.003, k) // 減速係数
+	velocity *= math.Pow(1-fouling*foulingDrag, k)
+	return velocity, acceleration
+}
+
+// dt 秒後の艦首方位と転回の勢い (1ティックあたりの度)
+// 舵は速度が出ているほどよく効く。転回の勢いは目標の回頭率に徐々に近づく
+func nextHeading(direction, yaw, rudder, velocity, dt float64) (float64, float64) {
+	k := ticks(dt)
+	yawRate := rudder * velocity * rudderYawFactor
+	yaw += (yawRate - yaw) * math.Min(0.05*k, 1)
+	return NormalizeBearing(direction + yaw*k), yaw
+}
+
+// 回頭による速度の損失 (World.FullPhysics のとき)
+func turnDrag(velocity, yaw float64) float64 {
+	return velocity * (1 - math.Abs(yaw)*turnDragFactor)
+}
+
+// 波による速度の損失。出せる速力 limit を超えた分だけ少しずつ落ちる
+func waveDrag(velocity, limit float64) float64 {
+	if velocity <= limit {
+		return velocity
+	}
+	return velocity - (velocity-limit)*waveDragFactor
+}
+
+// 艦首方位 direction に velocity ノットで dt 秒進んだ位置 (深さは変えない)
+func nextPosition(pos Point3D, direction, velocity, dt float64) Point3D {
+	rad := direction * math.Pi / 180
+	pos.X += math.Sin(rad) * velocity * Knot * dt
+	pos.Y += math.Cos(rad) * velocity * Knot * dt
+	return pos
+}
+
+// 浮力による加速度を受けて、dt 秒後の深さ (Z、水面が 0 で下が負) と上下の速度 (1ティックあたりの m)
+// 水の抵抗で上下の速度は落ち、水面より上には出ない
+func nextVertical(z, verticalVelocity, buoyancyAcceleration, dt float64) (float64, float64) {
+	k := ticks(dt)
+	verticalVelocity += buoyancyAcceleration * k
+	verticalVelocity *= math.Pow(0.98, k)
+	z += verticalVelocity * k
+	if z > 0 {
+		z = 0
+		verticalVelocity = math.Min(verticalVelocity, 0)
+	}
+	return z, verticalVelocity
+}
@@ -0,0 +1,254 @@
+package sim
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 決まった操艦の航跡
+//
+// 海面で停止した艦に決まった命令を出し、World で進めた航跡を決まった間隔で書き出して、
+// 記録しておいた航跡 (testdata/trajectories.golden) と比べる。乱数の種も決めてあるので、物理を変えなければ航跡は毎回同じになる。
+// 速度や舵の効きを意図して変えたときは go test ./sim -update で記録し直し、差分をレビューで見てもらう。
+//
+// 記録は操艦ごとに "# 名前" の行と、trajectorySample の行が続く。
+// 数値は trajectoryTolerance までの違いを許す (浮動小数点の計算の違いで末尾の桁が揺れるため)。
+
+var update = flag.Bool("update", false, "rewrite testdata/trajectories.golden")
+
+const (
+	// 航跡を記録する乱数の種
+	trajectorySeed = 1
+	// 航跡の値の違いとして許す幅
+	trajectoryTolerance = 0.05
+)
+
+var trajectoryGolden = filepath.Join("testdata", "trajectories.golden")
+
+// 決まった操艦
+type maneuver struct {
+	name string
+	// 航跡を記録する長さと間隔
+	duration time.Duration
+	interval time.Duration
+	// 経過時間 t のティックの前に命令を出す
+	orders func(p *Player, t time.Duration)
+}
+
+// 航跡の 1 点。深さは水面で -0 と書かないよう 0 以上にしてある
+type trajectorySample struct {
+	at       time.Duration
+	turbine  float64
+	velocity float64
+	heading  float64
+	x, y     float64
+	depth    float64
+}
+
+// 記録する行。経過秒、回転数、速度 (ノット)、艦首方位、x、y、深さ (m)
+func (s trajectorySample) String() string {
+	return fmt.Sprintf("%.0f %.1f %.3f %.3f %.2f %.2f %.2f",
+		s.at.Seconds(), s.turbine, s.velocity, s.heading, s.x, s.y, s.depth)
+}
+
+// 航跡を確かめる操艦
+var goldenManeuvers = []maneuver{
+	{
+		name: "ahead-standard", duration: 5 * time.Minute, interval: 30 * time.Second,
+		orders: func(p *Player, t time.Duration) {
+			if t == 0 {
+				p.Turbine.Order(100)
+			}
+		},
+	},
+	{
+		name: "turn-starboard", duration: 5 * time.Minute, interval: 30 * time.Second,
+		orders: func(p *Player, t time.Duration) {
+			switch t {
+			case 0:
+				p.Turbine.Order(100)
+			case time.Minute:
+				p.Rudder.Order(20)
+			}
+		},
+	},
+	{
+		name: "stop-from-full", duration: 5 * time.Minute, interval: 30 * time.Second,
+		orders: func(p *Player, t time.Duration) {
+			switch t {
+			case 0:
+				p.Turbine.Order(150)
+			case 2 * time.Minute:
+				p.Turbine.Order(0)
+			}
+		},
+	},
+	{
+		name: "dive", duration: 5 * time.Minute, interval: 30 * time.Second,
+		orders: func(p *Player, t time.Duration) {
+			if t == 0 {
+				p.Turbine.Order(50)
+				p.Ballast.Order(100)
+			}
+		},
+	},
+}
+
+// m の航跡。初めの点は命令を出す前の状態
+func trajectory(m maneuver) []trajectorySample {
+	p := NewPlayer()
+	w := NewWorld(&p, rand.New(rand.NewSource(trajectorySeed)))
+	sample := func() trajectorySample {
+		return trajectorySample{at: w.Elapsed(), turbine: p.Turbine.Actual, velocity: p.Velocity, heading: p.Direction,
+			x: p.Position.X, y: p.Position.Y, depth: math.Max(p.Depth(), 0)}
+	}
+	samples := []trajectorySample{sample()}
+	next := m.interval
+	for w.Elapsed() < m.duration {
+		m.orders(&p, w.Elapsed())
+		w.Step(TickDuration)
+		if w.Elapsed() >= next {
+			samples = append(samples, sample())
+			next += m.interval
+		}
+	}
+	return samples
+}
+
+// 全部の操艦の航跡を記録の形式で書く
+func writeTrajectories(w io.Writer) {
+	for _, m := range goldenManeuvers {
+		fmt.Fprintf(w, "# %s\n", m.name)
+		for _, s := range trajectory(m) {
+			fmt.Fprintln(w, s)
+		}
+	}
+}
+
+// 記録の形式を操艦の名前ごとの行に分ける
+func parseTrajectories(r io.Reader) (map[string][]string, error) {
+	got := map[string][]string{}
+	name := ""
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#"):
+			name = strings.TrimSpace(line[1:])
+			got[name] = nil
+		case name == "":
+			return nil, fmt.Errorf("line %d: sample before maneuver name", n)
+		default:
+			got[name] = append(got[name], line)
+		}
+	}
+	return got, scanner.Err()
+}
+
+// 2 つの行の値が trajectoryTolerance に収まっているか
+func samplesMatch(want, got string) bool {
+	w, g := strings.Fields(want), strings.Fields(got)
+	if len(w) != len(g) {
+		return false
+	}
+	for i := range w {
+		a, err := strconv.ParseFloat(w[i], 64)
+		if err != nil {
+			return false
+		}
+		b, err := strconv.ParseFloat(g[i], 64)
+		if err != nil || math.Abs(a-b) > trajectoryTolerance {
+			return false
+		}
+	}
+	return true
+}
+
+func TestGoldenTrajectories(t *testing.T) {
+	var b strings.Builder
+	writeTrajectories(&b)
+	if *update {
+		if err := ioutil.WriteFile(trajectoryGolden, []byte(b.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	f, err := os.Open(trajectoryGolden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	want, err := parseTrajectories(f)
+	if err != nil {
+		t.Fatalf("%s: %v", trajectoryGolden, err)
+	}
+	got, err := parseTrajectories(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, m := range goldenManeuvers {
+		t.Run(m.name, func(t *testing.T) {
+			w, ok := want[m.name]
+			if !ok {
+				t.Fatal("not recorded (run with -update)")
+			}
+			g := got[m.name]
+			for i := 0; i < len(w) || i < len(g); i++ {
+				var wl, gl string
+				if i < len(w) {
+					wl = w[i]
+				}
+				if i < len(g) {
+					gl = g[i]
+				}
+				if !samplesMatch(wl, gl) {
+					t.Errorf("sample %d:\n  want %s\n  got  %s", i, wl, gl)
+				}
+			}
+		})
+	}
+}
+
+// 2 ティック分の dt で 1 回進めても、1 ティックずつ 2 回進めたのとほぼ同じになる
+func TestMotionScalesWithDt(t *testing.T) {
+	tick := TickDuration.Seconds()
+	near := func(name string, once, twice float64) {
+		t.Helper()
+		if math.Abs(once-twice) > 1e-3*math.Max(math.Abs(twice), 1) {
+			t.Errorf("%s: one step of 2 ticks %v, two steps of 1 tick %v", name, once, twice)
+		}
+	}
+
+	near("turbine", nextTurbineRpm(100, 0.5, 2*tick), nextTurbineRpm(nextTurbineRpm(100, 0.5, tick), 0.5, tick))
+
+	once, _ := nextVelocity(80, 100, 0.1, 0.5, 2*tick)
+	twice, _ := nextVelocity(80, 100, 0.1, 0.5, tick)
+	twice, _ = nextVelocity(twice, 100, 0.1, 0.5, tick)
+	near("velocity", once, twice)
+
+	heading, yaw := nextHeading(10, 0.02, 20, 100, 2*tick)
+	heading2, yaw2 := nextHeading(10, 0.02, 20, 100, tick)
+	heading2, yaw2 = nextHeading(heading2, yaw2, 20, 100, tick)
+	near("heading", heading, heading2)
+	near("yaw", yaw, yaw2)
+
+	z, vz := nextVertical(-50, -0.01, -0.0005, 2*tick)
+	z2, vz2 := nextVertical(-50, -0.01, -0.0005, tick)
+	z2, vz2 = nextVertical(z2, vz2, -0.0005, tick)
+	near("depth", z, z2)
+	near("vertical velocity", vz, vz2)
+}
@@ -0,0 +1,26 @@
+package sim
+
+// 潜舵 (ダイブプレーン)
+//
+// 行き足があるときは潜舵の角度で上下の力が生まれ、バラストやトリムを動かさずに深度を変えられる。
+// 力は角度と速度に比例するので、止まっているときは効かない (そのときはホバリングでトリムを使う)。
+// 上げ舵 (浮上) が正。
+
+const (
+	// 潜舵の最大角度 (度)
+	planesMaxAngle = 20.0
+	// 1ティックで動く角度 (度)。いっぱいまで約 6 秒
+	planesRate = 0.05
+	// 角度 1° と速度 1 ノットあたりの力 (浮力と同じ単位)
+	// 速度 100 で上げ舵いっぱいなら、トリムを +2 にしたのと同じくらい
+	planesLift = 0.001
+)
+
+func newPlanesControl() Control {
+	return Control{Min: -planesMaxAngle, Max: planesMaxAngle, Rate: planesRate}
+}
+
+// 潜舵が生む上向きの力 (浮力と同じ単位)
+func (p *Player) PlanesLift() float64 {
+	return p.Planes.Actual * p.Velocity * planesLift
+}
@@ -0,0 +1,47 @@
+package sim
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// 行き足があれば下げ舵で沈み、上げ舵で浮く。止まっていれば潜舵は効かない
+func TestPlanesChangeDepthOnlyWithWayOn(t *testing.T) {
+	run := func(turbine, planes float64) float64 {
+		p := NewPlayer()
+		p.Position.Z = -100
+		w := NewWorld(&p, rand.New(rand.NewSource(trajectorySeed)))
+		// 浮きも沈みもしないよう、メインバラストの浮力をトリムで打ち消しておく
+		p.Ballast.Order(50)
+		p.Ballast.Actual = 50
+		p.Trim.Order(-p.BallastBuoyancy())
+		p.Trim.Actual = p.Trim.Ordered
+		p.Turbine.Order(turbine)
+		w.Step(2 * time.Minute)
+		p.Planes.Order(planes)
+		w.Step(time.Minute)
+		return p.Depth()
+	}
+
+	tests := []struct {
+		name           string
+		turbine        float64
+		planes         float64
+		deeper, higher bool
+	}{
+		{"dive with way on", 100, -planesMaxAngle, true, false},
+		{"rise with way on", 100, planesMaxAngle, false, true},
+		{"stopped", 0, -planesMaxAngle, false, false},
+	}
+	for _, tt := range tests {
+		level := run(tt.turbine, 0)
+		got := run(tt.turbine, tt.planes)
+		if deeper := got > level+1; deeper != tt.deeper {
+			t.Errorf("%s: depth %.1f m with planes, %.1f m level", tt.name, got, level)
+		}
+		if higher := got < level-1; higher != tt.higher {
+			t.Errorf("%s: depth %.1f m with planes, %.1f m level", tt.name, got, level)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package sim
+
+// 自艦の物理的な状態
+type Player struct {
+	// 現在位置
+	Position Point3D
+
+	// タービン回転数： 0 ~ 200
+	Turbine Control
+
+	// 現在の速度
+	Velocity float64
+
+	// 加速度
+	Acceleration float64
+
+	// 舵の角度 -35 ~ 35
+	Rudder Control
+
+	// 船が向いている方角
+	Direction float64
+
+	// 命令された針路 (度) と保つ速力 (ノット)。命令値は操艦 (針路の命令と自動操舵) が決め、実際の値は艦首方位と速度
+	Course Control
+	Speed  Control
+
+	// 転回の勢い
+	DirectionAcceleration float64
+
+	// トリム (中立浮力からの差)： -10.0 ~ 10.0
+	Trim Control
+
+	// メインバラストタンクの注水率： 0 ~ 100
+	Ballast Control
+
+	// 潜舵の角度 (度、上げ舵が正)： -20 ~ 20
+	Planes Control
+
+	// 浮力によって生じる加速度
+	BuoyancyAcceleration float64
+
+	// 上下方向の速度 (上向きが正、1ティックあたりの m)
+	VerticalVelocity float64
+
+	// ホバリング中かどうかと、その目標深度 (命令値) と今の深度 (実際の値)
+	HoverEnabled bool
+	HoverDepth   Control
+
+	// 海底に沈座しているか、離底作業中か
+	Bottomed   bool
+	LiftingOff bool
+
+	// 機関を停止して静粛にしているか
+	MachinerySecured bool
+	// 冷却材ポンプを止めて、原子炉を自然循環で冷やしているか
+	NaturalCirculation bool
+
+	// 船体の健全度: 0.0 ~ 100.0
+	HullIntegrity float64
+
+	// 区画ごとの耐久値: 0.0 ~ 100.0
+	Compartments Compartments
+
+	// 区画ごとの浸水 (容積の %): 0.0 ~ 100.0 と、ビルジポンプを回しているか
+	Flooding   [CompartmentCount]float64
+	BilgePumps bool
+
+	// 残りの燃料: 0 ~ FuelCapacity
+	Fuel float64
+
+	// 船体の汚れ: 0.0 ~ 100.0
+	Fouling float64
+
+	// 原子炉
+	Reactor Reactor
+
+	// 配置に就いている乗員の腕による操舵と応急 (ビルジポンプ) の効率。1 が標準
+	HelmEfficiency          float64
+	DamageControlEfficiency float64
+}
+
+// 海面で停止している新しい艦
+func NewPlayer() Player {
+	return Player{
+		Turbine:       newTurbineControl(),
+		Rudder:        newRudderControl(),
+		Trim:          newTrimControl(),
+		Ballast:       newBallastControl(),
+		Planes:        newPlanesControl(),
+		Course:        newCourseControl(),
+		Speed:         newSpeedControl(),
+		HoverDepth:    newHoverDepthControl(),
+		HullIntegrity: 100.0,
+		Compartments:  newCompartments(),
+		Fuel:          FuelCapacity,
+		Reactor:       newReactor(),
+
+		HelmEfficiency:          1,
+		DamageControlEfficiency: 1,
+	}
+}
+
+// 現在の深度 (m)
+func (p *Player) Depth() float64 {
+	return -p.Position.Z
+}
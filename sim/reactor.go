@@ -0,0 +1,112 @@
+package sim
+
+import "math"
+
+// 原子炉
+//
+// タービンを回す蒸気は原子炉の熱で作る。制御棒はタービンの命令値に合わせて引き抜かれ、
+// 出力が上がるほど炉心の温度が上がる。一次冷却材のポンプが熱を運び去るので、
+// 温度は出力と冷却材の流量で決まる値に1分ほどかけて近づいていく。
+// 炉心が ReactorScramTemp を超えると制御棒が一斉に挿入され (スクラム)、
+// 再起動するまでタービンは回せない。
+
+const (
+	// 炉心に戻ってくる冷却材の温度 (K)。出力がなければ炉心はこの温度になる
+	ReactorInletTemp = 550.0
+	// 過熱の警報の既定の温度 (K)
+	ReactorWarningTemp = 620.0
+	// これを超えるとスクラムする (K)
+	ReactorScramTemp = 640.0
+	// これより冷えていないと再起動できない (K)
+	ReactorRestartTemp = 580.0
+
+	// 全出力・全流量のときの炉心の温度の上がり幅 (K)
+	// タービン 140 rpm あたりで既定の警報が出て、180 rpm を超えて回し続けるとスクラムする
+	reactorHeatRise = 100.0
+	// 全流量のときに温度が落ち着くまでの時定数 (秒)
+	reactorTimeConstant = 60.0
+	// 機関を停止しているときの冷却材ポンプの流量 (%)。低速運転で静かにする
+	securedCoolantFlow = 30.0
+	// 冷却材ポンプを止めた自然循環の流量 (%)
+	// タービン 70 rpm あたりで既定の警報が出て、90 rpm を超えて回し続けるとスクラムする
+	naturalCirculationFlow = 50.0
+)
+
+type Reactor struct {
+	// 炉心の温度 (K)
+	CoreTemp float64
+	// 制御棒の引き抜き量 (%)。出力はこれに比例する
+	Rods Control
+	// 一次冷却材の流量 (%)
+	Coolant Control
+	// スクラムして止まっているか
+	Scrammed bool
+}
+
+func newReactor() Reactor {
+	return Reactor{
+		CoreTemp: ReactorInletTemp,
+		// タービンと同じ速さで出力を追える
+		Rods:    Control{Min: 0, Max: 100, Rate: 0.15},
+		Coolant: Control{Min: 0, Max: 100, Rate: 0.05, Ordered: 100, Actual: 100},
+	}
+}
+
+// 出力 (0 ~ 1)
+func (r *Reactor) Power() float64 {
+	return r.Rods.Actual / 100
+}
+
+// スクラムする。制御棒は重力で一瞬に落ちる
+func (r *Reactor) Scram() {
+	r.Scrammed = true
+	r.Rods.Order(0)
+	r.Rods.Actual = 0
+}
+
+// 再起動する
+// 炉心が冷えていなければ false
+func (r *Reactor) Restart() bool {
+	if r.CoreTemp > ReactorRestartTemp {
+		return false
+	}
+	r.Scrammed = false
+	return true
+}
+
+// dt 秒分だけ原子炉を動かす
+// 制御棒はタービンの命令値に合わせ、スクラムしていれば挿入したままにする
+func updateReactor(p *Player, dt float64, events []Event) []Event {
+	r := &p.Reactor
+	if r.Scrammed {
+		r.Rods.Order(0)
+		p.Turbine.Order(0)
+	} else {
+		r.Rods.Order(p.Turbine.Ordered / p.Turbine.Max * 100)
+	}
+	r.Rods.Slew()
+	switch {
+	case p.MachinerySecured:
+		r.Coolant.Order(securedCoolantFlow)
+	case p.NaturalCirculation:
+		r.Coolant.Order(naturalCirculationFlow)
+	default:
+		r.Coolant.Order(100)
+	}
+	r.Coolant.Slew()
+
+	flow := r.Coolant.Actual / 100
+	r.CoreTemp += (r.Power()*reactorHeatRise - flow*(r.CoreTemp-ReactorInletTemp)) * dt / reactorTimeConstant
+
+	if !r.Scrammed && r.CoreTemp >= ReactorScramTemp {
+		r.Scram()
+		p.Turbine.Order(0)
+		events = append(events, Event{Kind: EventReactorScram, Position: p.Position, Hull: p.HullIntegrity})
+	}
+	return events
+}
+
+// 原子炉の出力で回せる回転数に抑える
+func limitTurbine(p *Player) {
+	p.Turbine.Actual = math.Min(p.Turbine.Actual, p.Reactor.Power()*p.Turbine.Max)
+}
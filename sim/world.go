@@ -0,0 +1,139 @@
+// Package sim は自艦の物理シミュレーション (速度、回頭、浮力、着底) を行う。
+//
+// 画面には依存しない。World.Step で時間を進め、起きた出来事は Event として返す。
+package sim
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// 1ティックの長さ
+// 速度や操舵機の動作速度などは、どれも1ティックあたりの量で決めてある
+const TickDuration = 16 * time.Millisecond
+
+// 舵1度・速度1あたりの回頭率 (1ティックあたりの度)
+// 舵いっぱい (35°) で速度 100 のとき、1秒に約 3° 回頭する
+const rudderYawFactor = 1.5e-5
+
+// 1ノットあたりの m/s
+const Knot = 0.514444
+
+// 詳しい物理 (World.FullPhysics) で加える効果
+const (
+	// 回頭率 1 (1ティックあたりの度) あたりの速度の損失 (1ティックあたりの割合)
+	// 舵いっぱいで回り続けると速度が 4 分の 1 ほど落ちる
+	turnDragFactor = 0.06
+	// 深度 1 m あたりの浮力の減り。水圧で船体が縮んで排水量が減り、深いほど重くなる
+	hullCompressibility = 0.002
+)
+
+const (
+	// これより浅ければ波を受ける (m)
+	waveDepth = 2.0
+	// 波で出せる速力を超えた分のうち、1ティックで失う割合
+	waveDragFactor = 0.002
+)
+
+type World struct {
+	Player *Player
+	// (x, y) での海流 (東向き, 北向き m/s)。nil なら海流はない
+	Current func(x, y float64) (east, north float64)
+	// 浮上しているときに波の中で出せる速力 (ノット)。nil なら波で速力は落ちない
+	WaveSpeedLimit func() float64
+	// 海底の地形。nil なら GentleSeabed
+	Seabed Seabed
+	// 回頭による速度の損失と、水圧による船体の圧縮も計算するか
+	FullPhysics bool
+
+	rng *rand.Rand
+	// まだ進めていない時間 (1ティックに満たない分)
+	pending time.Duration
+	// 開始から進めた時間
+	elapsed time.Duration
+}
+
+// rng はタービンの回転むらや水の抵抗のゆらぎに使う
+func NewWorld(p *Player, rng *rand.Rand) *World {
+	return &World{Player: p, rng: rng}
+}
+
+// dt だけ時間を進め、その間に起きた出来事を返す
+// 1ティックに満たない端数は次の呼び出しに持ち越す
+func (w *World) Step(dt time.Duration) []Event {
+	w.pending += dt
+	var events []Event
+	for w.pending >= TickDuration {
+		w.pending -= TickDuration
+		w.elapsed += TickDuration
+		events = w.tick(events)
+	}
+	return events
+}
+
+// シミュレーション上の時刻 (開始からの経過時間)
+// 一時停止や時間の圧縮をしても、武器やセンサーの時間はこれで測る
+func (w *World) Elapsed() time.Duration {
+	return w.elapsed
+}
+
+func (w *World) tick(events []Event) []Event {
+	p := w.Player
+
+	// 速度の更新 --------------------------------------------------------------------------------
+	// 回転数の計算。燃料が尽きていればタービンは止まっていく
+	// 原子炉の出力を超えては回らない。機関の区画が傷んでいれば最大回転数も落ちる
+	dt := TickDuration.Seconds()
+	events = updateFuel(p, dt, events)
+	events = updateReactor(p, dt, events)
+	p.Turbine.Slew()
+	p.Turbine.Actual = nextTurbineRpm(p.Turbine.Actual, w.rng.Float64(), dt)
+	limitTurbine(p)
+	updateDamage(p)
+
+	// 加速度と速度の計算
+	p.Velocity, p.Acceleration = nextVelocity(p.Velocity, p.Turbine.Actual, p.Fouling, w.rng.Float64(), dt)
+	if w.WaveSpeedLimit != nil && p.Depth() <= waveDepth {
+		p.Velocity = waveDrag(p.Velocity, w.WaveSpeedLimit())
+	}
+	updateFouling(p, dt)
+
+	// 向きの更新 --------------------------------------------------------------------------------
+	p.Rudder.Slew()
+	p.Direction, p.DirectionAcceleration = nextHeading(p.Direction, p.DirectionAcceleration, p.Rudder.Actual, p.Velocity, dt)
+	if w.FullPhysics {
+		p.Velocity = turnDrag(p.Velocity, p.DirectionAcceleration)
+	}
+
+	// 位置の更新 --------------------------------------------------------------------------------
+	// 速度はノット。着底していなければ海流にも流される
+	before := p.Position
+	p.Position = nextPosition(p.Position, p.Direction, p.Velocity, dt)
+	if w.Current != nil && !p.Bottomed {
+		east, north := w.Current(p.Position.X, p.Position.Y)
+		p.Position.X += east * dt
+		p.Position.Y += north * dt
+	}
+
+	// 深さの更新 --------------------------------------------------------------------------------
+	events = updateFlooding(p, dt, events)
+	updateHover(p)
+	p.Trim.Slew()
+	p.Ballast.Slew()
+	p.Planes.Slew()
+	buoyancy := p.NetBuoyancy() + p.PlanesLift()
+	if w.FullPhysics {
+		buoyancy -= math.Max(p.Depth(), 0) * hullCompressibility
+	}
+	p.BuoyancyAcceleration = buoyancy * 0.0001
+	floor := w.Seabed
+	if floor == nil {
+		floor = GentleSeabed{}
+	}
+	events = updateBottom(p, floor, before, events)
+	events = updateLiftOff(p, floor, events)
+	p.Position.Z, p.VerticalVelocity = nextVertical(p.Position.Z, p.VerticalVelocity, p.BuoyancyAcceleration, dt)
+	p.MeasureControls()
+	return events
+}
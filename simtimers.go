@@ -0,0 +1,36 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// シミュレーション時間で動くタイマー
+//
+// XBT の沈下、魚雷や囮の航走、発射機の再装填、ビーコンの応答待ちなどは壁時計のティッカーではなく、
+// ゲームループが World.Step のあとに進める。ゲームループを止めればこれらも止まり、
+// 時間を圧縮すれば物理と同じ割合で速くなる。
+
+// now はシミュレーション上の時刻、dt は前回から進んだ秒数
+type simTimer func(now time.Duration, dt float64)
+
+type simTimers struct {
+	mu     sync.Mutex
+	timers []simTimer
+}
+
+func (t *simTimers) add(fn simTimer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.timers = append(t.timers, fn)
+}
+
+// ゲームループから呼ぶ
+func (t *simTimers) advance(now time.Duration, dt float64) {
+	t.mu.Lock()
+	timers := append([]simTimer{}, t.timers...)
+	t.mu.Unlock()
+	for _, fn := range timers {
+		fn(now, dt)
+	}
+}
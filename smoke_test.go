@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 起動して画面の枠が出ていることを確かめる
+func TestSmoke(t *testing.T) {
+	g := startGame(t)
+	g.expect("PRESS Q TO QUIT")
+	g.expect("Current Speed: (kt)")
+	g.expect("Turbine Control")
+
+	// 停止したまま時間を進めても止まったまま
+	g.advance(2 * time.Second)
+	g.expect("Stopped.")
+	g.expectNot("Full speed forward.")
+
+	g.key("q")
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 耐久試験
+//
+// -soak 72h で起動すると、画面を出さずにデモ哨戒のボット (demo.go) に操艦させ、商船・敵・警報などを含めた
+// シミュレーション全体を偽の時計でできるだけ速く (時間の圧縮は常に最大で) 指定したシミュレーション時間だけ進める。
+// 物理を書き直したあとに、長く動かしたときだけ出る壊れ方を探すためのもの。
+//   - ティックごとに不変条件 (checkInvariants) を調べる
+//   - soakCountInterval ごとに商船・航跡・魚雷と囮・海図の書き込みを数え、soakLeakSamples 回続けて増えたものを漏れとみなす
+//   - 艦を失ったら最後のチェックポイントからやり直して続ける。戻れなければそこで止める
+//
+// 終わると見つけた違反を最初に見つけた時刻とともに書き出し、違反があれば終了コード 1 で終える。
+// 画面の統合テストと同じく一時ディレクトリで動かし、ユーザーのセーブデータには触れない。
+
+const (
+	// 物の数を数える間隔 (シミュレーション時間)
+	soakCountInterval = time.Hour
+	// 続けてこの回数だけ増えたら漏れとみなす
+	soakLeakSamples = 12
+)
+
+// 数える物
+type soakCounter struct {
+	name  string
+	count func() int
+
+	first, last, max int
+	// 直近に数えた値 (古い順に soakLeakSamples+1 個まで)
+	recent []int
+}
+
+// 数えた値を加え、直近 soakLeakSamples 回続けて増えていれば true を返す
+func (c *soakCounter) sample(n int) bool {
+	if len(c.recent) == 0 {
+		c.first, c.max = n, n
+	}
+	c.last = n
+	if n > c.max {
+		c.max = n
+	}
+	c.recent = append(c.recent, n)
+	if len(c.recent) > soakLeakSamples+1 {
+		c.recent = c.recent[1:]
+	}
+	if len(c.recent) <= soakLeakSamples {
+		return false
+	}
+	for i := 1; i < len(c.recent); i++ {
+		if c.recent[i] <= c.recent[i-1] {
+			return false
+		}
+	}
+	return true
+}
+
+// 時刻つきの出来事 (違反と哨戒の終わり)
+type soakEntry struct {
+	at  time.Duration
+	msg string
+}
+
+type soakTest struct {
+	duration time.Duration
+	// 始めた時刻 (壁時計)。かかった時間を出す
+	started time.Time
+
+	mu       sync.Mutex
+	counters []*soakCounter
+	// 見つけた違反。同じものは最初の 1 回だけ
+	violations []soakEntry
+	reported   map[string]bool
+	// 哨戒が終わった時刻と結末
+	endings   []soakEntry
+	nextCount time.Duration
+	elapsed   time.Duration
+	// 哨戒をやり直せずに止めた
+	stopped bool
+}
+
+func newSoakTest(duration time.Duration) *soakTest {
+	return &soakTest{duration: duration, started: time.Now(), reported: map[string]bool{}}
+}
+
+// 漏れを調べる物を加える (main で組み立てるときに呼ぶ)
+func (s *soakTest) watch(name string, count func() int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters = append(s.counters, &soakCounter{name: name, count: count})
+}
+
+// 違反を記録する。key が同じ違反は 2 回目から記録しない (s.mu を保持した状態で呼ぶ)
+func (s *soakTest) violate(now time.Duration, key, msg string) {
+	if s.reported[key] {
+		return
+	}
+	s.reported[key] = true
+	s.violations = append(s.violations, soakEntry{at: now, msg: msg})
+}
+
+// 哨戒が終わったことを記録する。ended は結末、restarted はチェックポイントからやり直せたか
+func (s *soakTest) patrolEnded(now time.Duration, ended endReason, restarted bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.endings = append(s.endings, soakEntry{at: now, msg: ended.String()})
+	if !restarted {
+		s.stopped = true
+	}
+}
+
+// 不変条件を調べ、数える番なら数える。試験を終えるときに true を返す (ゲームループのタイマーから呼ぶ)
+func (s *soakTest) step(p *Player, now time.Duration) bool {
+	// 警報や新しい航跡で 1 倍に戻っても、次のティックで最大に戻す
+	simRate.maximize()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.elapsed = now
+	for _, v := range checkInvariants(p) {
+		s.violate(now, v, v)
+	}
+	if now >= s.nextCount {
+		s.nextCount = now + soakCountInterval
+		for _, c := range s.counters {
+			if c.sample(c.count()) {
+				s.violate(now, c.name, fmt.Sprintf("%s kept growing for %v (now %d, started at %d)", c.name, soakCountInterval*soakLeakSamples, c.last, c.first))
+			}
+		}
+	}
+	return s.stopped || now >= s.duration
+}
+
+// 偽の時計を待たずに進め続ける (ゴルーチンで呼ぶ)
+func (s *soakTest) run(ctx context.Context, fc *fakeClock) {
+	for ctx.Err() == nil {
+		fc.Advance(loopInterval)
+	}
+}
+
+func (s *soakTest) failed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.violations) > 0
+}
+
+// 結果をまとめて書き出す
+// 例:
+//
+//	soak: 72:00:00 simulated in 6m12s
+//	     merchant vessels      8 ->   8 (max  9)
+//	     tracks                0 ->   3 (max  7)
+//	2:14:05 patrol ended: LOST - the boat was destroyed
+//	31:02:40 FAIL velocity is not a finite number
+//	FAIL soak (1 violations)
+func (s *soakTest) report(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(w, "soak: %s simulated in %v\n", formatMissionTime(s.elapsed.Seconds()), time.Since(s.started).Round(time.Second))
+	for _, c := range s.counters {
+		fmt.Fprintf(w, "     %-20s %4d -> %4d (max %4d)\n", c.name, c.first, c.last, c.max)
+	}
+	for _, r := range s.endings {
+		fmt.Fprintf(w, "%s patrol ended: %s\n", formatMissionTime(r.at.Seconds()), r.msg)
+	}
+	if s.stopped {
+		fmt.Fprintf(w, "stopped early: the patrol could not be restarted\n")
+	}
+	for _, v := range s.violations {
+		fmt.Fprintf(w, "%s FAIL %s\n", formatMissionTime(v.at.Seconds()), v.msg)
+	}
+	if len(s.violations) > 0 {
+		fmt.Fprintf(w, "FAIL soak (%d violations)\n", len(s.violations))
+	} else {
+		fmt.Fprintf(w, "PASS soak\n")
+	}
+}
+
+// デブリーフィングの記録と同じ関数から、走っている魚雷と囮の数を数える
+func countWeapons(fns ...func() (torpedoes, decoys []sim.Point3D)) func() int {
+	return func() int {
+		n := 0
+		for _, fn := range fns {
+			torpedoes, decoys := fn()
+			n += len(torpedoes) + len(decoys)
+		}
+		return n
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/mum4k/termdash/container"
+	"github.com/mum4k/termdash/linestyle"
+	"github.com/mum4k/termdash/widgets/sparkline"
+	"github.com/mum4k/termdash/widgets/text"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 機関の傾向
+//
+// タービンの実回転数・自艦の雑音・炉心の温度を小さなスパークラインで並べ、機関の異常が一目でわかるようにする。
+// ゲームループのタイマーから sparkInterval ごとに (シミュレーション時間で) 記録し、描画のパスで読む。
+// スパークラインは見えている値の最大を高さいっぱいに描くので、雑音は sparkNoiseFloor を、温度は炉の入口温度を引いた分を描く。
+// 低帯域モードでは塗りつぶしの棒の代わりに、今の値と上がったか下がったかを文字で出す。
+
+const (
+	// 記録する間隔と数
+	sparkInterval = 2 * time.Second
+	sparkSamples  = 90
+	// 雑音のスパークラインの底 (dB)
+	sparkNoiseFloor = 20.0
+)
+
+type sparkSample struct {
+	// 実回転数 (rpm)、雑音 (dB)、炉心の温度 (K)
+	rpm, noise, coreTemp float64
+}
+
+// 機関の値のリングバッファ
+type engineTrends struct {
+	mu      sync.Mutex
+	samples [sparkSamples]sparkSample
+	// 次に書く位置と、書いた数
+	next, count int
+	// 次に記録する時刻 (シミュレーション時間)
+	due time.Duration
+}
+
+func newEngineTrends() *engineTrends {
+	return &engineTrends{}
+}
+
+// 記録する番なら今の値を書く (ゲームループのタイマーから呼ぶ)
+func (e *engineTrends) record(p *Player, now time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if now < e.due {
+		return
+	}
+	e.due = now + sparkInterval
+	e.samples[e.next] = sparkSample{rpm: float64(p.Turbine.Actual), noise: p.noiseLevel(), coreTemp: p.Reactor.CoreTemp}
+	e.next = (e.next + 1) % sparkSamples
+	if e.count < sparkSamples {
+		e.count++
+	}
+}
+
+// 記録した値 (古い順)
+func (e *engineTrends) series() (rpm, noise, coreTemp []float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	start := (e.next - e.count + sparkSamples) % sparkSamples
+	for i := 0; i < e.count; i++ {
+		s := e.samples[(start+i)%sparkSamples]
+		rpm = append(rpm, s.rpm)
+		noise = append(noise, s.noise)
+		coreTemp = append(coreTemp, s.coreTemp)
+	}
+	return rpm, noise, coreTemp
+}
+
+// スパークラインに渡す値。floor を引き、負にはしない
+func sparkData(values []float64, floor float64) []int {
+	data := make([]int, len(values))
+	for i, v := range values {
+		data[i] = int(math.Round(math.Max(v-floor, 0)))
+	}
+	return data
+}
+
+// 機関の傾向のパネル。低帯域モードでは文字
+type trendsPanel struct {
+	rpm, noise, coreTemp *sparkline.SparkLine
+	text                 *text.Text
+}
+
+func newTrendsPanel() (*trendsPanel, error) {
+	tp := &trendsPanel{}
+	var err error
+	if render.lowBandwidth {
+		tp.text, err = text.New()
+		if err != nil {
+			return nil, err
+		}
+		return tp, nil
+	}
+	for _, s := range []struct {
+		line  **sparkline.SparkLine
+		color cell.Color
+	}{
+		{&tp.rpm, cell.ColorYellow},
+		{&tp.noise, cell.ColorCyan},
+		{&tp.coreTemp, cell.ColorRed},
+	} {
+		if *s.line, err = sparkline.New(sparkline.Color(s.color), sparkline.Height(1)); err != nil {
+			return nil, err
+		}
+	}
+	return tp, nil
+}
+
+// 画面に置くときのオプション
+func (tp *trendsPanel) options() []container.Option {
+	if tp.text != nil {
+		return []container.Option{
+			container.Border(linestyle.Light),
+			container.BorderTitle("Engineering Trends"),
+			container.PlaceWidget(tp.text),
+		}
+	}
+	return []container.Option{
+		container.Border(linestyle.Light),
+		container.BorderTitle("Engineering Trends"),
+		container.SplitHorizontal(
+			container.Top(container.PlaceWidget(tp.rpm)),
+			container.Bottom(
+				container.SplitHorizontal(
+					container.Top(container.PlaceWidget(tp.noise)),
+					container.Bottom(container.PlaceWidget(tp.coreTemp)),
+				),
+			),
+			container.SplitPercent(33),
+		),
+	}
+}
+
+// 写しに入れた傾向を描く
+func (tp *trendsPanel) draw(f *frame) error {
+	rpm, noise, coreTemp := f.rpms, f.noises, f.coreTemps
+	if len(rpm) == 0 {
+		return nil
+	}
+	if tp.text != nil {
+		tp.text.Reset()
+		for _, l := range []struct {
+			line  string
+			color cell.Color
+		}{
+			{historyLine("Turbine", "rpm", rpm, false, sparkInterval), cell.ColorYellow},
+			{historyLine("Noise", "dB", noise, false, sparkInterval), cell.ColorCyan},
+			{historyLine("Core", "K", coreTemp, false, sparkInterval), cell.ColorRed},
+		} {
+			if err := tp.text.Write(l.line, text.WriteCellOpts(cell.FgColor(l.color))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	last := len(rpm) - 1
+	for _, s := range []struct {
+		line  *sparkline.SparkLine
+		data  []int
+		label string
+	}{
+		{tp.rpm, sparkData(rpm, 0), fmt.Sprintf("rpm %.0f", rpm[last])},
+		{tp.noise, sparkData(noise, sparkNoiseFloor), fmt.Sprintf("noise %.0f dB", noise[last])},
+		{tp.coreTemp, sparkData(coreTemp, sim.ReactorInletTemp), fmt.Sprintf("core %.0f K", coreTemp[last])},
+	} {
+		s.line.Clear()
+		if err := s.line.Add(s.data, sparkline.Label(s.label)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
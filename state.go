@@ -0,0 +1,211 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/mum4k/termdash/widgets/text"
+)
+
+// 艦の状態の置き場
+//
+// Player を読み書きするのはこの置き場を通してだけにする。
+//   - 変えるときは update に関数を渡す。鍵を持って呼ぶので、ゲームループの刻み (loop.go)、キーやボタンの命令、
+//     マクロの命令が同時に Player を触ることはない
+//   - 読むだけのとき (パネル・教官席・遠くの配置・クラッシュダンプ) は view で写しを受け取る。写しは update のたびに作り直す
+//   - 航跡、海図の書き込み、補給港の在庫、速力と深度の推移、機関の傾向も、watch・watchTrends で渡しておけば同じ写しに入れる。
+//     パネルはこれらを frame からだけ読み、ゲームループが書き換えている管理の構造体には触らない
+//   - ほかの管理の構造体 (ビーコン、商船、乗員など) を見る文字のパネルは、出す文字そのものを写しに入れる。
+//     capture で渡した関数を update の中でパネルの間隔ごとに呼び、できた panelText を描画のパスが書き写すだけにする
+//   - タイトル画面のデモ中は、写しの艦をデモの練習艦にする (attractMode)
+//
+// update の中から update を呼ぶと止まってしまう。ゲームループのタイマーや every の関数は、すでに鍵を持って呼ばれている。
+
+// Player の写し
+type frame struct {
+	player Player
+	// 写しを作った時刻
+	now time.Time
+	// 一覧の順に並べた航跡と、選択中の航跡の番号
+	tracks   []track
+	selected int
+	// 海図の書き込み (データムを含む)
+	marks []chartMark
+	// 補給港の在庫と封鎖されているか (supplyPorts と同じ順)
+	ports     []portStock
+	blockaded []bool
+	// 速力と深度の推移、機関の回転数・雑音・炉心の温度の傾向 (古い順)
+	speeds, depths          []float64
+	rpms, noises, coreTemps []float64
+	// capture で作った文字のパネルの中身 (capture が返した番号の順)
+	texts []panelText
+}
+
+// capture が返した番号のパネルの中身。まだ作っていなければ空
+func (f *frame) text(id int) panelText {
+	if id < 0 || id >= len(f.texts) {
+		return nil
+	}
+	return f.texts[id]
+}
+
+// パネルに出す文字。色の付いた文字の並びで、描画のパスが text.Text に書き写す
+type panelText []textRun
+
+type textRun struct {
+	text   string
+	fg, bg cell.Color
+}
+
+// s を fg の色で足す
+func (pt *panelText) write(s string, fg cell.Color) {
+	pt.writeOn(s, fg, cell.ColorDefault)
+}
+
+// s を背景の色も付けて足す
+func (pt *panelText) writeOn(s string, fg, bg cell.Color) {
+	if s != "" {
+		*pt = append(*pt, textRun{text: s, fg: fg, bg: bg})
+	}
+}
+
+// t を消して書く
+func (pt panelText) draw(t *text.Text) {
+	t.Reset()
+	for _, r := range pt {
+		if err := t.Write(r.text, text.WriteCellOpts(cell.FgColor(r.fg), cell.BgColor(r.bg))); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// 文字のパネルの中身を作る関数と、次に作る時刻
+type textCapture struct {
+	schedule
+	compose func(f *frame) panelText
+}
+
+// 選択中の航跡。選択していなければ false
+func (f *frame) selectedTrack() (track, bool) {
+	for _, t := range f.tracks {
+		if t.id == f.selected {
+			return t, true
+		}
+	}
+	return track{}, false
+}
+
+type playerState struct {
+	mu     sync.Mutex
+	player *Player
+	// 写しに入れる航跡、海図、補給港 (watch で渡す)
+	tracks *trackManager
+	chart  *chart
+	depots *logistics
+	// 写しに入れる推移と傾向 (watchTrends で渡す)
+	history *trackHistory
+	trends  *engineTrends
+	// デモ中の練習艦。デモ中でなければ nil を返す (attractMode で渡す)
+	demo func() *Player
+	// 文字のパネルの中身を作る関数と、最後に作った中身 (capture で足す)
+	captures []*textCapture
+	texts    []panelText
+
+	frameMu sync.Mutex
+	frame   frame
+}
+
+func newPlayerState(p *Player) *playerState {
+	s := &playerState{player: p}
+	s.publish()
+	return s
+}
+
+// 鍵を持って fn に Player を渡し、写しを作り直す
+func (s *playerState) update(fn func(p *Player)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s.player)
+	s.publish()
+}
+
+// 航跡、海図、補給港も写しに入れる
+func (s *playerState) watch(tm *trackManager, ch *chart, depots *logistics) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tracks, s.chart, s.depots = tm, ch, depots
+	s.publish()
+}
+
+// 速力と深度の推移、機関の傾向も写しに入れる
+func (s *playerState) watchTrends(h *trackHistory, e *engineTrends) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.history, s.trends = h, e
+	s.publish()
+}
+
+// interval ごとに update の中で compose を呼び、できた中身を写しに入れる。返した番号で frame.text から読む
+// compose は鍵を持って呼ぶので管理の構造体を読んでよいが、update を呼んではいけない。
+// 描画のパスが同じ間隔で描くときに新しい中身があるよう、ゲームループの 1 回分だけ先に作る
+func (s *playerState) capture(interval time.Duration, compose func(f *frame) panelText) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.captures = append(s.captures, &textCapture{
+		schedule: schedule{interval: interval, next: clock.Now().Add(interval - loopInterval)},
+		compose:  compose,
+	})
+	s.texts = append(s.texts, nil)
+	return len(s.captures) - 1
+}
+
+// デモ中は写しの艦を shown が返す練習艦にする
+func (s *playerState) attractMode(shown func() *Player) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.demo = shown
+	s.publish()
+}
+
+// s.mu を保持した状態で呼ぶ
+func (s *playerState) publish() {
+	f := frame{player: *s.player, now: clock.Now()}
+	if s.demo != nil {
+		if boat := s.demo(); boat != nil {
+			f.player = *boat
+		}
+	}
+	if s.tracks != nil {
+		f.tracks, f.selected = s.tracks.snapshot(f.now)
+	}
+	if s.chart != nil {
+		f.marks = s.chart.all()
+	}
+	if s.depots != nil {
+		f.ports, f.blockaded = s.depots.snapshot()
+	}
+	if s.history != nil {
+		f.speeds, f.depths = s.history.series()
+	}
+	if s.trends != nil {
+		f.rpms, f.noises, f.coreTemps = s.trends.series()
+	}
+	for i, c := range s.captures {
+		if c.due(f.now) {
+			s.texts[i] = c.compose(&f)
+		}
+	}
+	// 次の publish で作り直しても、渡した写しの中身は変わらない
+	f.texts = append([]panelText(nil), s.texts...)
+	s.frameMu.Lock()
+	s.frame = f
+	s.frameMu.Unlock()
+}
+
+// 最新の写し
+func (s *playerState) view() frame {
+	s.frameMu.Lock()
+	defer s.frameMu.Unlock()
+	return s.frame
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+)
+
+// 文字のパネルの中身は update の中でパネルの間隔ごとに作り、渡した写しはあとから変わらない
+func TestCaptureComposesInUpdate(t *testing.T) {
+	saved := clock
+	t.Cleanup(func() { clock = saved })
+	fc := newFakeClock(time.Now())
+	clock = fc
+
+	p := newTestPlayer()
+	s := newPlayerState(p)
+	calls := 0
+	id := s.capture(time.Second, func(f *frame) panelText {
+		calls++
+		var out panelText
+		out.write(fmt.Sprintf("compose %d at %.0f m\n", calls, f.player.Depth()), cell.ColorDefault)
+		return out
+	})
+
+	s.update(func(*Player) {})
+	if f := s.view(); f.text(id) != nil || calls != 0 {
+		t.Fatalf("composed %d times before the interval, text %v", calls, f.text(id))
+	}
+
+	// 描画のパスより 1 回分先に作る
+	fc.Advance(time.Second - loopInterval)
+	s.update(func(p *Player) { p.Position.Z = -20 })
+	first := s.view()
+	if got := first.text(id); len(got) != 1 || got[0].text != "compose 1 at 20 m\n" {
+		t.Fatalf("text after the interval = %v", got)
+	}
+
+	// 間隔が来るまでは作り直さない
+	s.update(func(p *Player) { p.Position.Z = -40 })
+	if calls != 1 {
+		t.Errorf("composed %d times within one interval, want 1", calls)
+	}
+	fc.Advance(time.Second)
+	s.update(func(*Player) {})
+	if got := s.view().text(id); len(got) != 1 || got[0].text != "compose 2 at 40 m\n" {
+		t.Errorf("text after the next interval = %v", got)
+	}
+	if got := first.text(id); got[0].text != "compose 1 at 20 m\n" {
+		t.Errorf("earlier frame changed to %v", got)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+)
+
+// ブラウザの接続の版と再接続
+//
+// ブラウザ (配置) は /ws?protocol=3&station=ID で接続する。版が合わなければエラーを送って切り、ページを読み直してもらう。
+// 接続するたびに画面全体を送り直すので、切れても再接続すれば表示は元どおりになる (状態の再同期)。
+// ブラウザは切れると間隔を広げながら自動で再接続し、猶予 (stationGracePeriod) を過ぎるまで試し続ける。
+// サーバーは切れた配置を猶予のあいだ覚えておき、戻ってくれば再接続としてイベントログに出す。
+// -headless では画面を見ている配置が 1 つもなくなると、猶予のあいだシミュレーションを止めて待つ。
+
+// ブラウザとの約束の版。ブラウザ側 (webIndexHTML) の PROTOCOL と合わせる
+const wsProtocolVersion = 3
+
+// 切れた配置の戻りを待つ時間
+const stationGracePeriod = 60 * time.Second
+
+// 配置の ID に使える最大の長さ
+const stationIDMaxLength = 32
+
+type stationSessions struct {
+	mu        sync.Mutex
+	connected map[string]int
+	// 切れて戻りを待っている配置と、猶予が切れたときのタイマー
+	away map[string]*time.Timer
+	// 切断や再接続を出す。nil なら出さない
+	events *eventLog
+	// 見ている配置がいなくなったときにシミュレーションを止めるか (-headless)
+	hold bool
+	held bool
+}
+
+func newStationSessions(hold bool) *stationSessions {
+	return &stationSessions{connected: map[string]int{}, away: map[string]*time.Timer{}, hold: hold}
+}
+
+// イベントログができてから渡す
+func (s *stationSessions) setEventLog(events *eventLog) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = events
+}
+
+// s.mu を保持した状態で呼ぶ
+func (s *stationSessions) log(color cell.Color, format string, args ...interface{}) {
+	if s.events != nil {
+		s.events.add(color, format, args...)
+	}
+}
+
+// ブラウザが送ってきた ID。使えなければ新しく振る
+func stationID(requested string) string {
+	if requested != "" && len(requested) <= stationIDMaxLength {
+		ok := true
+		for _, r := range requested {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return requested
+		}
+	}
+	b := make([]byte, 4)
+	rand.Read(b)
+	return "station-" + hex.EncodeToString(b)
+}
+
+// 配置 id がつながった
+func (s *stationSessions) connect(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected[id]++
+	logs.infof("net", "%s connected (%d connections)", id, s.connected[id])
+	if t, ok := s.away[id]; ok {
+		t.Stop()
+		delete(s.away, id)
+		s.log(cell.ColorGreen, "[NET] %s reconnected. Screen resynchronized.", id)
+	} else if s.connected[id] == 1 {
+		s.log(cell.ColorCyan, "[NET] %s connected.", id)
+	}
+	if s.held {
+		s.held = false
+		if simPause.on() {
+			simPause.toggle()
+		}
+		s.log(cell.ColorGreen, "[NET] Simulation resumed.")
+	}
+}
+
+// 配置 id が切れた。猶予のあいだ戻りを待つ
+func (s *stationSessions) disconnect(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected[id]--
+	if s.connected[id] > 0 {
+		return
+	}
+	delete(s.connected, id)
+	logs.infof("net", "%s disconnected", id)
+	s.away[id] = time.AfterFunc(stationGracePeriod, func() { s.expire(id) })
+	s.log(cell.ColorYellow, "[NET] %s disconnected. Holding the station for %s.", id, stationGracePeriod)
+	if s.hold && len(s.connected) == 0 && !simPause.on() {
+		simPause.toggle()
+		s.held = true
+		s.log(cell.ColorYellow, "[NET] No stations connected. Simulation held until one returns.")
+	}
+}
+
+func (s *stationSessions) expire(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.away[id]; !ok {
+		return
+	}
+	delete(s.away, id)
+	logs.warnf("net", "%s did not return within %s", id, stationGracePeriod)
+	s.log(cell.ColorRed, "[NET] %s did not return within %s.", id, stationGracePeriod)
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 艦の状態 (左下のパネル)
+//
+// 1秒ごとにシミュレーションの値を読み直して出す。行ごとに閾値で色を変え、
+// 余裕があれば緑か青、気をつけるところで黄、危ないところで赤にする。
+//
+//	海水の圧力と試験深度に対する割合、船体の健全度
+//	原子炉の炉心の温度と制御棒・冷却材
+//	燃料と、今の回転数のままで燃料が持つ時間 (航続時間) と進める距離 (航続距離)
+//	タービン回転数、船体の汚れで落ちている速力と増えている雑音
+//	深度と真下の海底までの高さ、艦内の空気
+//	ESM (逆探) が捉えているレーダー、自艦の雑音と背景雑音、脅威の度合い
+//	天候と海況、見える距離、浮上して出せる速力 (weather.go)
+
+const (
+	// 海面の気圧 (MPa)
+	surfacePressure = 0.101325
+	// 水深 1 m あたりに増える水圧 (MPa)。海水の密度 1025 kg/m³
+	pressurePerMeter = 1025 * 9.81 / 1e6
+	// これより速く回すと炉心が過熱の警報の温度に近づく (rpm)
+	turbineHighRpm = 140.0
+	// 海底までの高さがこれより少ないと黄・赤 (m)
+	keelClearanceCaution = 30.0
+	keelClearanceDanger  = 10.0
+)
+
+// 深度 depth (m) の海水の圧力 (MPa)
+func seaPressure(depth float64) float64 {
+	return surfacePressure + math.Max(depth, 0)*pressurePerMeter
+}
+
+// 圧力の行の色
+// 試験深度を超えると黄、圧壊深度の 9 割を超えると赤
+func pressureColor(depth float64) cell.Color {
+	switch {
+	case depth >= crushDepth*0.9:
+		return cell.ColorRed
+	case depth > testDepth:
+		return cell.ColorYellow
+	}
+	return cell.ColorBlue
+}
+
+// 船体の健全度の行の色
+// 退艦を命じられるところまで落ちると赤
+func hullColor(hull float64) cell.Color {
+	switch {
+	case hull <= abandonHullThreshold:
+		return cell.ColorRed
+	case hull < 75:
+		return cell.ColorYellow
+	}
+	return cell.ColorGreen
+}
+
+// タービン回転数の行の色
+// 推進力を失っていれば赤、炉心が過熱するほど回していれば黄
+func turbineColor(p *Player) cell.Color {
+	switch {
+	case p.Reactor.Scrammed || p.OutOfFuel():
+		return cell.ColorRed
+	case p.Turbine.Actual >= turbineHighRpm:
+		return cell.ColorYellow
+	}
+	return cell.ColorBlue
+}
+
+// 深度と海底までの高さの行
+func keelLine(p *Player) (string, cell.Color) {
+	seabed, kind := terrain.SeabedAt(p.Position.X, p.Position.Y)
+	if p.Bottomed {
+		return fmt.Sprintf("Depth %s  ON THE BOTTOM (%s)", units.depth(p.Depth(), 0), kind), cell.ColorYellow
+	}
+	clearance := seabed - p.Depth()
+	color := cell.ColorCyan
+	switch {
+	case clearance < keelClearanceDanger:
+		color = cell.ColorRed
+	case clearance < keelClearanceCaution:
+		color = cell.ColorYellow
+	}
+	return fmt.Sprintf("Depth %s  Below keel %s", units.depth(p.Depth(), 0), units.depth(clearance, 0)), color
+}
+
+// ESM の行
+// 潜望鏡深度より深ければマストを下ろしていて何も捉えない。軍艦のレーダーを捉えていれば赤
+func esmLine(p *Player, tr *traffic) (string, cell.Color) {
+	if p.Depth() > periscopeDepth {
+		return "ESM: mast down", cell.ColorDefault
+	}
+	emitters, warships := 0, 0
+	nearest := math.Inf(1)
+	for _, s := range tr.vessels() {
+		dist := sim.HorizontalDistance(p.Position, s.position)
+		if dist > esmRange || s.submerged() {
+			continue
+		}
+		emitters++
+		if warshipClasses[s.class] {
+			warships++
+		}
+		nearest = math.Min(nearest, dist)
+	}
+	switch {
+	case emitters == 0:
+		return "ESM: no emitters", cell.ColorGreen
+	case warships > 0:
+		return fmt.Sprintf("ESM: %d emitters (%d military), nearest %.1f km", emitters, warships, nearest/1000), cell.ColorRed
+	}
+	return fmt.Sprintf("ESM: %d emitters, nearest %.1f km", emitters, nearest/1000), cell.ColorYellow
+}
+
+// 自艦の雑音と背景雑音の行
+// 自艦の雑音が背景雑音より大きいと、自分の音でソナーが聞こえにくい
+func sonarNoiseLine(p *Player, tr *traffic) (string, cell.Color) {
+	self, ambient := p.noiseLevel(), tr.ambientNoiseAt(p.Position)
+	color := cell.ColorGreen
+	if self > ambient {
+		color = cell.ColorYellow
+	}
+	return fmt.Sprintf("Self noise %.0f dB  Ambient %.0f dB", self, ambient), color
+}
+
+// 脅威の度合いの行
+// 魚雷が走っているかデータムの円の中にいれば赤、捜索が続いているデータムか衝突のおそれのある航跡があれば黄
+func threatLine(p *Player, tracks []track, dp *datumPlot, fleet *enemyFleet) (string, cell.Color) {
+	if n := fleet.incoming(); n > 0 {
+		return fmt.Sprintf("Threat Level: Red (torpedoes in the water: %d)", n), cell.ColorRed
+	}
+	active, inside := dp.status(p.Position)
+	if inside != "" {
+		return "Threat Level: Red (inside " + inside + ")", cell.ColorRed
+	}
+	if active > 0 {
+		return fmt.Sprintf("Threat Level: Yellow (datum search, %d active)", active), cell.ColorYellow
+	}
+	for _, tr := range tracks {
+		if !tr.lost && tr.collisionRisk() {
+			return "Threat Level: Yellow (collision risk " + tr.designation() + ")", cell.ColorYellow
+		}
+	}
+	return "Threat Level: Green", cell.ColorGreen
+}
+
+// 炉心の温度の行の色
+// 警報の温度を超えると黄、スクラムしていれば赤
+func reactorColor(r *sim.Reactor) cell.Color {
+	switch {
+	case r.Scrammed:
+		return cell.ColorRed
+	case r.CoreTemp >= sim.ReactorWarningTemp:
+		return cell.ColorYellow
+	}
+	return cell.ColorGreen
+}
+
+// 制御棒と冷却材の行
+func reactorLine(r *sim.Reactor) string {
+	if r.Scrammed {
+		return "SCRAM - RODS IN"
+	}
+	return fmt.Sprintf("Rods %.0f%%  Coolant %.0f%%", r.Rods.Actual, r.Coolant.Actual)
+}
+
+// 燃料の行の色
+// 4 分の 1 を切ると黄、1 割を切ると赤
+func fuelColor(fuel float64) cell.Color {
+	switch {
+	case fuel < sim.FuelCapacity/10:
+		return cell.ColorRed
+	case fuel < sim.FuelCapacity/4:
+		return cell.ColorYellow
+	}
+	return cell.ColorBlue
+}
+
+// 船体の汚れの行の色
+func foulingColor(fouling float64) cell.Color {
+	switch {
+	case fouling >= 50:
+		return cell.ColorRed
+	case fouling >= 20:
+		return cell.ColorYellow
+	}
+	return cell.ColorGreen
+}
+
+// 航続時間と航続距離の行
+func enduranceLine(p *Player) string {
+	if p.OutOfFuel() {
+		return "OUT OF FUEL - DRIFTING"
+	}
+	endurance, ok := p.Endurance()
+	if !ok {
+		return "Endurance --  Range --"
+	}
+	// 速度はノットなので、時間をかければ海里になる
+	return fmt.Sprintf("Endurance %.1f h  Range %.0f nm", endurance.Hours(), endurance.Hours()*p.Velocity)
+}
+
+func shipStatusPanel(f *frame, tr *traffic, dp *datumPlot, fleet *enemyFleet, roe *rulesOfEngagement, wx *weather) panelText {
+	p := &f.player
+	type line struct {
+		text  string
+		color cell.Color
+	}
+	keel, keelColor := keelLine(p)
+	esm, esmColor := esmLine(p, tr)
+	noise, noiseColor := sonarNoiseLine(p, tr)
+	threat, threatColor := threatLine(p, f.tracks, dp, fleet)
+	reputation, reputationColor := roe.line()
+	air, airColor := airLine(p)
+	conditions, conditionsColor := wx.line()
+	lines := []line{
+		{fmt.Sprintf("Sea Pressure: %.2f MPa (%.0f%% test depth)\n", seaPressure(p.Depth()), math.Max(p.Depth(), 0)/testDepth*100), pressureColor(p.Depth())},
+		{fmt.Sprintf("Hull integrity: %.0f%%\n", p.HullIntegrity), hullColor(p.HullIntegrity)},
+	}
+	// 区画ごとの耐久値と浸水計
+	for c := sim.Compartment(0); c < sim.CompartmentCount; c++ {
+		gauge, color := compartmentGauge(p, c)
+		lines = append(lines, line{gauge + "\n", color})
+	}
+	pumps, pumpColor := pumpLine(p)
+	lines = append(lines, []line{
+		{pumps + "\n", pumpColor},
+		{damageEffectLine(p) + "\n", compartmentColor(p)},
+		{fmt.Sprintf("\nReactor Temp: %.0f K\n", p.Reactor.CoreTemp), reactorColor(&p.Reactor)},
+		{reactorLine(&p.Reactor) + "\n", reactorColor(&p.Reactor)},
+		{fmt.Sprintf("Fuel: %.0f (%.0f%%)\n", p.Fuel, p.Fuel/sim.FuelCapacity*100), fuelColor(p.Fuel)},
+		{enduranceLine(p) + "\n", fuelColor(p.Fuel)},
+		{fmt.Sprintf("Turbine rpm: %.0f (ordered %.0f)\n", p.Turbine.Actual, p.Turbine.Ordered), turbineColor(p)},
+		{fmt.Sprintf("Hull fouling %.0f%%  Speed -%.0f%%  Noise +%.1f dB\n", p.Fouling, p.FoulingSpeedLoss()*100, p.Fouling*foulingNoisePerPercent), foulingColor(p.Fouling)},
+		{"\n" + keel + "\n", keelColor},
+		{air + "\n", airColor},
+		{esm + "\n", esmColor},
+		{noise + "\n", noiseColor},
+		{threat + "\n", threatColor},
+		{reputation + "\n", reputationColor},
+		{conditions + "\n", conditionsColor},
+	}...)
+	var out panelText
+	for _, l := range lines {
+		out.write(l.text, l.color)
+	}
+	return out
+}
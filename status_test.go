@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 海面で停止しているときの艦の状態
+func TestShipStatus(t *testing.T) {
+	g := startGame(t)
+	g.advance(2 * time.Second)
+	g.expect("Sea Pressure: 0.10 MPa (0% test depth)")
+	g.expect("Hull integrity: 100%")
+	g.expect("TORP 100 hp")
+	g.expect("ENG  100 hp")
+	g.expect("Bilge pumps OFF")
+	g.expect("Max rpm 200  Rudder 100%  Sonar -0 dB")
+	g.expect("Turbine rpm: 0 (ordered 0)")
+	g.expect("Below keel")
+	g.expect("Threat Level: Green")
+	g.expect("Reputation 100  ROE: captain's authority")
+	// タービンの命令値と実際の値
+	g.key("up")
+	g.advance(5 * time.Second)
+	g.expect("Turbine rpm: 10 (ordered 10)")
+	// 潜航すると水圧が上がり、ESM のマストは下りる
+	g.key("d")
+	g.advance(2 * time.Minute)
+	g.expect("ESM: mast down")
+	g.expectNot("Sea Pressure: 0.10 MPa")
+	// ビルジポンプは浸水していなくても回せる
+	g.key(";")
+	g.advance(2 * time.Second)
+	g.expect("[ORDER] Start bilge pumps")
+	g.expect("Bilge pumps ON")
+	// 天候は既定の海況から始まる
+	g.expect("Weather: Slight, sea state 3")
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 海底の測量
+//
+// 測量をしている間は、音響測深機が真下の海底を、海底から近ければサイドスキャンソナーが左右の帯を測り、
+// 測った升目が水深と海底の種類 (海藻の森や沈船があればそれ) とともに海図 (航海図) に埋まっていく。測量の結果は設定ディレクトリの survey.json に
+// 地形の種とともに残り、次の哨戒では同じ海底の海に出て、測量を引き継ぐ。
+// シナリオでは、ある範囲をどれだけ測ったかを目標の条件にできる。
+
+const (
+	// 測量結果を保存するファイル名
+	surveyFileName = "survey.json"
+	// 升目の大きさ (m)
+	surveyCellSize = 250.0
+	// サイドスキャンが使える海底からの高さ (m)
+	sideScanMaxAltitude = 150.0
+	// サイドスキャンの片側の幅 (m)。海底から高いほど広く見えるが、この幅まで
+	sideScanMaxSwath = 400.0
+)
+
+type surveyCell struct {
+	X, Y int
+}
+
+func surveyCellAt(x, y float64) surveyCell {
+	return surveyCell{X: int(math.Floor(x / surveyCellSize)), Y: int(math.Floor(y / surveyCellSize))}
+}
+
+// 1升目の測量結果
+type surveySample struct {
+	depth  float64
+	bottom sim.BottomType
+}
+
+// 保存する1升目
+type surveySounding struct {
+	X      int     `json:"x"`
+	Y      int     `json:"y"`
+	Depth  float64 `json:"depth"`
+	Bottom string  `json:"bottom"`
+}
+
+// survey.json の中身
+type surveyFile struct {
+	// 測量した海の地形の種
+	TerrainSeed int64            `json:"terrainSeed"`
+	Soundings   []surveySounding `json:"soundings"`
+}
+
+type survey struct {
+	events *eventLog
+	path   string
+
+	mu       sync.Mutex
+	active   bool
+	sideScan bool
+	seed     int64
+	// 升目ごとの測量結果
+	soundings map[surveyCell]surveySample
+}
+
+// path に前回までの測量結果があれば読み込む
+func newSurvey(events *eventLog, path string) (*survey, error) {
+	s := &survey{events: events, path: path, soundings: map[surveyCell]surveySample{}}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		var f surveyFile
+		if err := json.Unmarshal(data, &f); err != nil {
+			return nil, err
+		}
+		s.seed = f.TerrainSeed
+		for _, c := range f.Soundings {
+			// 海底の種類を記録していない古い結果は泥とみなす
+			bottom, _ := sim.ParseBottomType(c.Bottom)
+			s.soundings[surveyCell{X: c.X, Y: c.Y}] = surveySample{depth: c.Depth, bottom: bottom}
+		}
+	}
+	return s, nil
+}
+
+// 使う地形の種
+// 前回までに測量した海があればその種を、なければ seed を使う
+func (s *survey) terrainSeed(seed int64) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.seed == 0 {
+		s.seed = seed
+	}
+	return s.seed
+}
+
+// 測量の開始・終了 (orderSurvey の処理)
+func (s *survey) setActive(active bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active = active
+	s.sideScan = false
+	return nil
+}
+
+// 自艦の位置と艦首方位で測る (シミュレーション時間で進める)
+func (s *survey) sweep(pos sim.Point3D, heading float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.active {
+		return
+	}
+	seabed, _ := terrain.SeabedAt(pos.X, pos.Y)
+	s.measure(pos.X, pos.Y)
+
+	altitude := seabed + pos.Z
+	sideScan := altitude <= sideScanMaxAltitude
+	if sideScan != s.sideScan {
+		s.sideScan = sideScan
+		if sideScan {
+			s.events.add(cell.ColorGreen, "[SURVEY] Side-scan in range of the bottom.")
+		} else {
+			s.events.add(cell.ColorYellow, "[SURVEY] Side-scan lost the bottom. Go below %.0f m above the seabed.", sideScanMaxAltitude)
+		}
+	}
+	if !sideScan {
+		return
+	}
+	// 艦首方位に直角な帯を測る
+	swath := math.Min(sideScanMaxSwath, math.Max(altitude, 0)*3)
+	rad := (heading + 90) * math.Pi / 180
+	for d := surveyCellSize / 2; d <= swath; d += surveyCellSize / 2 {
+		s.measure(pos.X+math.Sin(rad)*d, pos.Y+math.Cos(rad)*d)
+		s.measure(pos.X-math.Sin(rad)*d, pos.Y-math.Cos(rad)*d)
+	}
+}
+
+// s.mu を保持した状態で呼ぶ
+func (s *survey) measure(x, y float64) {
+	c := surveyCellAt(x, y)
+	if _, ok := s.soundings[c]; ok {
+		return
+	}
+	// 升目の中心の水深を記録する
+	// 海底の種類は中心と四隅寄りの5点で見て、いちばんソーナーを乱すものを記録する
+	cx, cy := (float64(c.X)+0.5)*surveyCellSize, (float64(c.Y)+0.5)*surveyCellSize
+	depth, bottom := terrain.SeabedAt(cx, cy)
+	for _, d := range [][2]float64{{-1, -1}, {-1, 1}, {1, -1}, {1, 1}} {
+		_, b := terrain.SeabedAt(cx+d[0]*surveyCellSize/4, cy+d[1]*surveyCellSize/4)
+		if reverberation(b) > reverberation(bottom) {
+			bottom = b
+		}
+	}
+	s.soundings[c] = surveySample{depth: depth, bottom: bottom}
+	if bottom == sim.BottomWreck {
+		s.events.add(cell.ColorCyan, "[SURVEY] Wreck on the bottom at %.0f m.", depth)
+	}
+}
+
+// (x, y) の升目の測量結果
+func (s *survey) sounding(x, y float64) (surveySample, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sample, ok := s.soundings[surveyCellAt(x, y)]
+	return sample, ok
+}
+
+// (x, y) から radius 以内の升目のうち、測ったものの割合 (%)
+func (s *survey) coverage(x, y, radius float64) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lo, hi := surveyCellAt(x-radius, y-radius), surveyCellAt(x+radius, y+radius)
+	total, done := 0, 0
+	for cx := lo.X; cx <= hi.X; cx++ {
+		for cy := lo.Y; cy <= hi.Y; cy++ {
+			centre := sim.Point3D{X: (float64(cx) + 0.5) * surveyCellSize, Y: (float64(cy) + 0.5) * surveyCellSize}
+			if sim.HorizontalDistance(centre, sim.Point3D{X: x, Y: y}) > radius {
+				continue
+			}
+			total++
+			if _, ok := s.soundings[surveyCell{X: cx, Y: cy}]; ok {
+				done++
+			}
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(done) / float64(total) * 100
+}
+
+// パネルに出す状況
+func (s *survey) status() (active, sideScan bool, cells int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active, s.sideScan, len(s.soundings)
+}
+
+func (s *survey) save() error {
+	s.mu.Lock()
+	seed := s.seed
+	list := make([]surveySounding, 0, len(s.soundings))
+	for c, sample := range s.soundings {
+		list = append(list, surveySounding{X: c.X, Y: c.Y, Depth: sample.depth, Bottom: sample.bottom.String()})
+	}
+	s.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Y != list[j].Y {
+			return list[i].Y < list[j].Y
+		}
+		return list[i].X < list[j].X
+	})
+	data, err := json.MarshalIndent(surveyFile{TerrainSeed: seed, Soundings: list}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path, data, 0644)
+}
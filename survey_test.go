@@ -0,0 +1,22 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 測量していないとき
+func TestSurvey(t *testing.T) {
+	g := startGame(t)
+	g.advance(time.Second)
+	g.expect("Survey OFF")
+	// 海面からは測深機だけが使える
+	g.key("f")
+	g.expect("[ORDER] Start survey")
+	g.advance(time.Second)
+	g.expect("Survey ON (fathometer)")
+	g.key("f")
+	g.expect("[ORDER] Stop survey")
+	g.advance(time.Second)
+	g.expect("Survey OFF")
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// テレメトリ
+//
+// -telemetry file で遊ぶと、ゲームループの刻みごとに艦の状態を 1 行ずつ書き出す。ゲームの外で表やグラフにするためのもの。
+// 拡張子が .csv なら CSV (1 行目が見出し)、それ以外なら JSON Lines。物理が進まない刻み (一時停止中) は書かない。
+//
+//	time,x,y,depth,heading,speed,rpm,rpm_ordered,fuel
+//	12.350,104.2,-33.8,25.1,87.5,6.42,80,80,102180.4
+//
+//	{"time":12.35,"x":104.2,"y":-33.8,"depth":25.1,"heading":87.5,"speed":6.42,"rpm":80,"rpm_ordered":80,"fuel":102180.4}
+
+// 1 刻みの記録。time は哨戒の時間 (秒)、speed はノット、fuel は残りの燃料
+type telemetrySample struct {
+	Time       float64 `json:"time"`
+	X          float64 `json:"x"`
+	Y          float64 `json:"y"`
+	Depth      float64 `json:"depth"`
+	Heading    float64 `json:"heading"`
+	Speed      float64 `json:"speed"`
+	Rpm        float64 `json:"rpm"`
+	RpmOrdered float64 `json:"rpm_ordered"`
+	Fuel       float64 `json:"fuel"`
+}
+
+var telemetryColumns = []string{"time", "x", "y", "depth", "heading", "speed", "rpm", "rpm_ordered", "fuel"}
+
+func (s telemetrySample) record() []string {
+	f := func(v float64, prec int) string { return strconv.FormatFloat(v, 'f', prec, 64) }
+	return []string{f(s.Time, 3), f(s.X, 1), f(s.Y, 1), f(s.Depth, 1), f(s.Heading, 1), f(s.Speed, 2), f(s.Rpm, 0), f(s.RpmOrdered, 0), f(s.Fuel, 1)}
+}
+
+type telemetryWriter struct {
+	mu   sync.Mutex
+	file *os.File
+	w    *bufio.Writer
+	// CSV でなければ nil
+	csv *csv.Writer
+	enc *json.Encoder
+	// 最初に失敗した書き込み。失敗したら以降は書かない
+	err error
+}
+
+// path にテレメトリを書き出す。形式は拡張子で決める
+func newTelemetryWriter(path string) (*telemetryWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	t := &telemetryWriter{file: f, w: bufio.NewWriter(f)}
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		t.csv = csv.NewWriter(t.w)
+		if err := t.csv.Write(telemetryColumns); err != nil {
+			f.Close()
+			return nil, err
+		}
+	} else {
+		t.enc = json.NewEncoder(t.w)
+	}
+	return t, nil
+}
+
+// 刻みの終わりの艦の状態を書く (ゲームループのタイマーから呼ぶ)
+func (t *telemetryWriter) write(p *Player, now time.Duration) {
+	s := telemetrySample{
+		Time:       now.Seconds(),
+		X:          p.Position.X,
+		Y:          p.Position.Y,
+		Depth:      p.Depth(),
+		Heading:    p.Direction,
+		Speed:      p.Velocity,
+		Rpm:        p.Turbine.Actual,
+		RpmOrdered: p.Turbine.Ordered,
+		Fuel:       p.Fuel,
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return
+	}
+	if t.csv != nil {
+		t.err = t.csv.Write(s.record())
+	} else {
+		t.err = t.enc.Encode(s)
+	}
+}
+
+// 書き残しを書いて閉じる。途中で書き込みに失敗していればそのエラーを返す
+func (t *telemetryWriter) close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.csv != nil {
+		t.csv.Flush()
+		if t.err == nil {
+			t.err = t.csv.Error()
+		}
+	}
+	if err := t.w.Flush(); t.err == nil {
+		t.err = err
+	}
+	if err := t.file.Close(); t.err == nil {
+		t.err = err
+	}
+	return t.err
+}
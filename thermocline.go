@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 深い変温層とピンの効き目
+//
+// 海面近くの季節の変温層 (environment.layerDepth) に加えて、海域によってはもっと深いところに主水温躍層がある。
+// あるかどうかと深さ (deepLayerMin〜deepLayerMax) は海域ごとに乱数で決まる。
+// 2 つの層があるとき、水温は季節の変温層の下で seasonalDrop だけ下がってしばらく一定になり、
+// 深い変温層から深層の水温に向かって下がっていく。どちらの層も、またぐ音は屈折して届きにくく (伝搬損失が増える)、
+// 聞く側と聞かれる側のどちらから見ても同じだけ効く。
+//
+// Sound Propagation パネルのピンの効き目は、層がないとしたときのアクティブソーナーの予測探知距離に対する、
+// 今の予測探知距離の割合を、pingEffectivenessDepths の深さの潜水艦で平均したもの。予測と同じく XBT で測った環境で求める。
+
+const (
+	// 深い変温層がある確率と、深さ (m) の範囲
+	deepLayerChance = 0.6
+	deepLayerMin    = 200.0
+	deepLayerMax    = 400.0
+	// 季節の変温層の下で下がる水温 (度) と、下がりきるまでの深さの尺度 (m)
+	seasonalDrop  = 3.0
+	seasonalScale = 15.0
+	// XBT の記録で、水温が一定の層の下でこれだけ下がりはじめれば深い変温層とみなす (度)
+	xbtDeepLayerGradient = 0.3
+	// ピンの効き目に使う潜水艦の反射強度 (dB)
+	pingEffectivenessStrength = 15.0
+)
+
+// ピンの効き目を平均する潜水艦の深さ (m)
+var pingEffectivenessDepths = []float64{50, 150, 300}
+
+// 深い変温層を決める (main の最後で乱数を渡す)
+func (e *environment) generateLayers(rng *rand.Rand) {
+	if rng.Float64() >= deepLayerChance {
+		return
+	}
+	e.deepLayerDepth = math.Round((deepLayerMin+rng.Float64()*(deepLayerMax-deepLayerMin))/10) * 10
+}
+
+// 変温層をいくつ持つか
+func (e *environment) layerCount() int {
+	if e.deepLayerDepth > 0 {
+		return 2
+	}
+	return 1
+}
+
+// ピンの効き目 (0〜1)。自艦の下の水深より浅い標的の深さがなければ false
+func pingEffectiveness(env *environment, own sim.Point3D, noise float64) (float64, bool) {
+	waterDepth, _ := terrain.SeabedAt(own.X, own.Y)
+	clear := *env
+	clear.layerLoss, clear.deepLayerLoss = 0, 0
+	var layered, open float64
+	for _, depth := range pingEffectivenessDepths {
+		if depth >= waterDepth {
+			continue
+		}
+		target := sonarTarget{strength: pingEffectivenessStrength, depth: depth}
+		layered += activeSonar.predictedRange(env, own, target, noise)
+		open += activeSonar.predictedRange(&clear, own, target, noise)
+	}
+	if open == 0 {
+		return 0, false
+	}
+	return layered / open, true
+}
+
+// Sound Propagation パネルのピンの効き目の行。例: "Sonar ping effectiveness 45% (2 layers)"
+func pingEffectivenessLine(env *environment, own sim.Point3D, noise float64) (string, cell.Color) {
+	ratio, ok := pingEffectiveness(env, own, noise)
+	if !ok {
+		return "Sonar ping effectiveness: water too shallow", cell.ColorDefault
+	}
+	color := cell.ColorGreen
+	switch {
+	case ratio < 0.4:
+		color = cell.ColorRed
+	case ratio < 0.7:
+		color = cell.ColorYellow
+	}
+	layers := "1 layer"
+	if env.layerCount() == 2 {
+		layers = "2 layers"
+	}
+	return fmt.Sprintf("Sonar ping effectiveness %.0f%% (%s)", ratio*100, layers), color
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/mum4k/termdash/cell"
+)
+
+// 時間の圧縮
+//
+// 長い移動を早回しするために、ゲームループの 1 回で進めるシミュレーション時間を 4 倍、16 倍にする。
+// 描画やパネルの更新の間隔は変えない。新しい航跡や警報、魚雷が出ると 1 倍に戻す。
+
+// 選べる倍率
+var timeScales = []int{1, 4, 16}
+
+// 1 倍に戻すイベントの見出し
+var timeScaleBreaks = []string{"[ALARM]", "[TRACK] New track", "[DRILL] Torpedo in the water"}
+
+type timeCompression struct {
+	mu    sync.Mutex
+	index int
+	// 1 倍に戻したことを出す。nil なら出さない
+	events *eventLog
+}
+
+// シミュレーション全体の時間の圧縮
+var simRate = &timeCompression{}
+
+// 今の倍率
+func (c *timeCompression) factor() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return timeScales[c.index]
+}
+
+// 次の倍率にし (16 倍の次は 1 倍)、その倍率を返す
+func (c *timeCompression) cycle() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.index = cycleIndex(c.index, len(timeScales), 1)
+	return timeScales[c.index]
+}
+
+// 最大の倍率にする (耐久試験)
+func (c *timeCompression) maximize() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.index = len(timeScales) - 1
+}
+
+// イベントログの行 msg を見て、新しい航跡や警報なら 1 倍に戻す (eventLog.listeners に渡す)
+func (c *timeCompression) watch(msg string) {
+	for _, prefix := range timeScaleBreaks {
+		if !strings.HasPrefix(msg, prefix) {
+			continue
+		}
+		c.mu.Lock()
+		was := timeScales[c.index]
+		c.index = 0
+		events := c.events
+		c.mu.Unlock()
+		if was > 1 && events != nil {
+			events.add(cell.ColorYellow, "[SIM] Time compression x%d cancelled: %s", was, strings.TrimSpace(strings.TrimPrefix(msg, prefix)))
+		}
+		return
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// + で時間の圧縮を 1 倍 → 4 倍 → 16 倍 → 1 倍と切り替える
+func TestTimeCompression(t *testing.T) {
+	g := startGame(t)
+	g.advance(time.Second)
+	g.expectNot("TIME x")
+	g.key("+")
+	g.expect("[SIM] Time compression x4.")
+	g.expect("TIME x4")
+	g.key("+")
+	g.expect("TIME x16")
+	g.key("+")
+	g.expect("[SIM] Time compression x1.")
+	g.expectNot("TIME x")
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/mum4k/termdash/cell"
+)
+
+// 目標運動解析 (TMA)
+//
+// 航跡一覧で選んだ航跡について、方位・距離とその変化率、推定位置の履歴から求めた目標の針路・速力
+// (解) を出す。射撃管制は同じ航跡を目標にし、解があれば未来位置に向けて魚雷を撃つ。
+// 受動測距の道具を使うときは、エケルント測距とターンカウントの行も出す (ranging.go)。
+
+// TMA パネルの表示
+// 選んでいる発射管の魚雷の速力で撃つときの針路も出す
+func tmaPanel(f *frame, room *torpedoRoom, ek *ekelundRanging) panelText {
+	p := &f.player
+	tr, ok := f.selectedTrack()
+
+	var out panelText
+	if !ok {
+		out.write("No track selected.\n", cell.ColorYellow)
+		return out
+	}
+	rng := "---"
+	if !math.IsNaN(tr.rng) {
+		rng = fmt.Sprintf("%.1f km", tr.rng/1000)
+	}
+	out.write(fmt.Sprintf("%s %s  BRG %03.0f  RNG %s\n", tr.designation(), tr.classification(), tr.bearing, rng), cell.ColorDefault)
+	writeTrackRates(&out, &tr)
+	course, speed, solved := tr.solution()
+	if !solved {
+		out.write("SOLN --- (needs range)\n", cell.ColorYellow)
+	} else {
+		out.write(fmt.Sprintf("SOLN CSE %03.0f SPD %.1f kt\n", course, speed/knot), cell.ColorGreen)
+	}
+	if rangingTools {
+		out.write(ek.status(tr.id, f.now)+"\n"+turnCount(&tr)+"\n", cell.ColorMagenta)
+	}
+	tube := room.selected()
+	knots, _ := room.preset(tube).Speed.performance()
+	out.write(fmt.Sprintf("FIRE tube %d BRG %03.0f\n", tube+1, firingBearing(p.Position, &tr, knots)), cell.ColorRed)
+	return out
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 空の発射管からは撃てない
+func TestTorpedo(t *testing.T) {
+	g := startGame(t)
+	g.advance(time.Second)
+	g.expect("Torpedoes stowed: 11")
+	g.key("j")
+	g.expect("Fire tube 1 refused: tube 1 is empty, not ready to fire")
+	// 装填・注水・前扉の開放
+	g.key("w")
+	g.advance(31 * time.Second)
+	g.expect("[WEAPONS] Tube 1 loaded.")
+	g.expect("Torpedoes stowed: 10")
+	g.key("w")
+	g.advance(16 * time.Second)
+	g.expect("[WEAPONS] Tube 1 flooded.")
+	g.key("w")
+	g.advance(6 * time.Second)
+	g.expect("[WEAPONS] Tube 1 open.")
+	// 目標を識別していないので、もう一度命じて撃つ。撃つと排水して空に戻る
+	g.key("j")
+	g.expect("Fire tube 1 refused: no target is identified, fire again to shoot anyway")
+	g.key("j")
+	g.expect("[ROE] Firing without positive identification (no target).")
+	g.expect("[WEAPONS] Tube 1 fired at bow.")
+	g.expect("draining")
+	g.advance(21 * time.Second)
+	g.expectNot("draining")
+	// 500 m 走ると安全装置が外れて起爆できるようになる
+	g.advance(5 * time.Second)
+	g.expect("[WEAPONS] Tube 1 torpedo armed.")
+}
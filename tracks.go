@@ -0,0 +1,787 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/mum4k/termdash/widgets/text"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 探知情報の統合と航跡管理
+//
+// 各センサーの探知は方位 (と、わかれば距離) だけの断片なので、近い方位の探知を
+// 同じ目標とみなして1本の航跡にまとめる。航跡は更新が途絶えると品質が下がり、
+// やがて失探 (LOST) になって消える。海図や目標運動解析、射撃管制はこの航跡を使う。
+//
+// 同じ目標とみなす範囲は、センサーの誤差 (bearingSigma, rangeSigma) と航跡の推定の誤差から決める。
+// 航跡は変化率で今の方位と距離を予測し、更新がない間は誤差を広げていく。1 回の掃引で 1 隻の船を捉えた探知は
+// まとめて 1 本の航跡に結びつけ、1 本の航跡に結びつくのは 1 隻の探知だけにする。
+// それでも同じ目標に 2 本できた航跡は、推定が重なれば 1 本にまとめる。
+
+type sensorKind int
+
+const (
+	sensorPassive sensorKind = iota
+	sensorActive
+	sensorRadar
+	sensorESM
+	sensorVisual
+)
+
+// 航跡一覧での略号
+func (k sensorKind) String() string {
+	switch k {
+	case sensorActive:
+		return "A"
+	case sensorRadar:
+		return "R"
+	case sensorESM:
+		return "E"
+	case sensorVisual:
+		return "V"
+	}
+	return "P"
+}
+
+// 1回の探知で航跡の品質が上がる量
+func (k sensorKind) weight() float64 {
+	switch k {
+	case sensorActive, sensorVisual:
+		return 30
+	case sensorRadar:
+		return 40
+	case sensorESM:
+		return 15
+	}
+	return 10
+}
+
+// 方位の誤差 (度, 標準偏差)
+func (k sensorKind) bearingSigma() float64 {
+	switch k {
+	case sensorActive, sensorRadar:
+		return 0.5
+	case sensorESM:
+		return 3
+	case sensorVisual:
+		return 1
+	}
+	return 1.5
+}
+
+// 距離の誤差 (距離に対する割合, 標準偏差)。距離を測れないセンサーは 0
+func (k sensorKind) rangeSigma() float64 {
+	switch k {
+	case sensorActive, sensorRadar:
+		return 0.01
+	case sensorVisual:
+		return 0.1
+	}
+	return 0
+}
+
+// 潜望鏡で視認できる距離 (m)。フリート配信で上書きできる (broadcast.go)
+var visualRange = 10000.0
+
+const (
+	// これより浅ければ潜望鏡・レーダー・ESM を使える (m)
+	periscopeDepth = 18.0
+	// レーダーで水上艦を捉えられる距離 (水平線まで, m)
+	radarRange = 22000.0
+	// 商船の航海レーダーの電波を ESM で捉えられる距離 (m)
+	esmRange = 35000.0
+
+	// 予測との差が誤差のこの倍までなら同じ目標とみなす
+	trackGateSigmas = 3.0
+	// 2 本の航跡の推定の差が誤差のこの倍までならまとめる
+	trackMergeSigmas = 2.0
+	// 更新がない間に航跡の誤差が広がる速さ (方位は度/秒, 距離は割合/秒)
+	trackBearingDrift = 0.1
+	trackRangeDrift   = 0.005
+	// 更新がないと1秒あたりこれだけ品質が下がる
+	trackQualityDecay = 2.0
+	// 更新がこの時間ないと失探、さらにこの時間経つと消える
+	trackLostAge = 60 * time.Second
+	trackDropAge = 5 * time.Minute
+
+	// 変化率の計算に使う履歴の長さと、計算に必要な最短の長さ
+	trackRateWindow  = 60 * time.Second
+	trackRateMinSpan = 10 * time.Second
+	// 方位変化がこれより小さく距離が縮まっていれば衝突のおそれ (度/分)
+	collisionBearingRate = 0.5
+
+	// 艦種まではわからない水上の船の類別
+	surfaceClass = "surface"
+	// 航跡一覧に一度に出す行数
+	contactListRows = 6
+)
+
+// センサーからの1回の探知
+type detection struct {
+	sensor  sensorKind
+	bearing float64
+	// 距離がわからない探知では NaN
+	rng float64
+	// 類別できた艦種 (わからなければ空)
+	class string
+	// 識別できた船籍 (目視のときだけ)
+	flag string
+	// 聞き取れた翼数周波数 (Hz, パッシブソーナーのときだけ)。聞き取れなければ 0
+	bladeRate float64
+	at        time.Time
+}
+
+// 統合された航跡
+type track struct {
+	id      int
+	bearing float64
+	// 距離がわかっていなければ NaN
+	rng float64
+	// 方位 (度²) と距離 (割合の 2 乗) の推定の分散
+	bearingVar, rangeVar float64
+	// 距離がわかっているときの推定位置
+	estimate sim.Point3D
+	// 類別と船籍 (わからなければ空)
+	class   string
+	flag    string
+	sources map[sensorKind]time.Time
+	quality float64
+	first   time.Time
+	updated time.Time
+	lost    bool
+	// 変化率を求めるための方位・距離の履歴
+	history []trackSample
+	// 翼数周波数 (Hz)。聞き取れていなければ 0 (ranging.go)
+	bladeRate float64
+}
+
+type trackSample struct {
+	at      time.Time
+	bearing float64
+	rng     float64
+	// 距離がわかっているときの推定位置
+	estimate sim.Point3D
+}
+
+func (t *track) designation() string {
+	return fmt.Sprintf("T%02d", t.id)
+}
+
+// 類別。わからなければ UNKNOWN
+func (t *track) classification() string {
+	if t.class == "" {
+		return "UNKNOWN"
+	}
+	return strings.ToUpper(t.class)
+}
+
+// 最後の更新からの時間
+func (t *track) age(now time.Time) time.Duration {
+	return now.Sub(t.updated)
+}
+
+// 時間による劣化を反映した品質 (0 ~ 100)
+func (t *track) currentQuality(now time.Time) float64 {
+	return math.Max(t.quality-t.age(now).Seconds()*trackQualityDecay, 0)
+}
+
+// 方位変化率 (度/分, 右回りが +) と距離変化率 (m/s, 開いていくのが +)
+// 履歴に直線を当てはめて求める。求められないものは NaN
+func (t *track) rates() (bearingRate, rangeRate float64) {
+	bearingRate, rangeRate = math.NaN(), math.NaN()
+	if len(t.history) < 2 {
+		return
+	}
+	base := t.history[0]
+	var ts, bs, rts, rs []float64
+	for _, s := range t.history {
+		sec := s.at.Sub(base.at).Seconds()
+		ts = append(ts, sec)
+		// 0° をまたいでも連続になるよう、最初の方位からの差にする
+		bs = append(bs, sim.NormalizeRelative(s.bearing-base.bearing))
+		if !math.IsNaN(s.rng) {
+			rts = append(rts, sec)
+			rs = append(rs, s.rng)
+		}
+	}
+	if span := t.history[len(t.history)-1].at.Sub(base.at); span < trackRateMinSpan {
+		return
+	}
+	bearingRate = slope(ts, bs) * 60
+	if len(rts) >= 2 && rts[len(rts)-1]-rts[0] >= trackRateMinSpan.Seconds() {
+		rangeRate = slope(rts, rs)
+	}
+	return
+}
+
+// 目標運動解析 (TMA) の解。推定位置の履歴に直線を当てはめた目標の針路 (度) と速力 (m/s)
+// 距離のわかる履歴が足りなければ false
+func (t *track) solution() (course, speed float64, ok bool) {
+	var ts, xs, ys []float64
+	for _, s := range t.history {
+		if math.IsNaN(s.rng) {
+			continue
+		}
+		ts = append(ts, s.at.Sub(t.history[0].at).Seconds())
+		xs = append(xs, s.estimate.X)
+		ys = append(ys, s.estimate.Y)
+	}
+	if len(ts) < 2 || ts[len(ts)-1]-ts[0] < trackRateMinSpan.Seconds() {
+		return 0, 0, false
+	}
+	vx, vy := slope(ts, xs), slope(ts, ys)
+	return sim.BearingTo(sim.Point3D{}, sim.Point3D{X: vx, Y: vy}), math.Hypot(vx, vy), true
+}
+
+// 最小二乗法による傾き
+func slope(xs, ys []float64) float64 {
+	n := float64(len(xs))
+	var sx, sy, sxx, sxy float64
+	for i := range xs {
+		sx += xs[i]
+		sy += ys[i]
+		sxx += xs[i] * xs[i]
+		sxy += xs[i] * ys[i]
+	}
+	d := n*sxx - sx*sx
+	if d == 0 {
+		return 0
+	}
+	return (n*sxy - sx*sy) / d
+}
+
+// 方位がほとんど変わらず距離が縮まっている (衝突のおそれ)
+func (t *track) collisionRisk() bool {
+	bearingRate, rangeRate := t.rates()
+	return math.Abs(bearingRate) < collisionBearingRate && rangeRate < 0
+}
+
+// 最近この航跡を更新したセンサーの略号 (例: "PRE")
+func (t *track) sourceCodes(now time.Time) string {
+	var b strings.Builder
+	for k := sensorPassive; k <= sensorVisual; k++ {
+		if at, ok := t.sources[k]; ok && now.Sub(at) < trackLostAge {
+			b.WriteString(k.String())
+		} else {
+			b.WriteString("-")
+		}
+	}
+	return b.String()
+}
+
+// 航跡の管理
+type trackManager struct {
+	events *eventLog
+
+	mu     sync.Mutex
+	tracks []*track
+	nextID int
+	// 選択中の航跡 (0 なら選択なし)
+	selected int
+}
+
+func newTrackManager(events *eventLog) *trackManager {
+	return &trackManager{events: events}
+}
+
+// これまでにできた航跡の数
+func (tm *trackManager) count() int {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	return tm.nextID
+}
+
+// 今ある航跡の数 (失探したものも含む)
+func (tm *trackManager) live() int {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	return len(tm.tracks)
+}
+
+// 探知を既存の航跡に結びつけるか、新しい航跡を作る
+// own は探知したときの自艦の位置
+func (tm *trackManager) report(own sim.Point3D, d detection) {
+	tm.reportAll(own, []detection{d})
+}
+
+// 1 隻の船を 1 回の掃引で捉えた探知 (同じ時刻) をまとめて 1 本の航跡に結びつける
+// 結びつける航跡は、予測した方位と距離との差がセンサーと航跡の誤差の trackGateSigmas 倍に収まるもののうち、
+// 差が誤差に比べて一番小さいもの。同じ掃引で別の船の探知を結びつけた航跡 (更新の時刻が同じ) には結びつけない
+func (tm *trackManager) reportAll(own sim.Point3D, ds []detection) {
+	if len(ds) == 0 {
+		return
+	}
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	var best *track
+	bestScore := math.Inf(1)
+	for _, t := range tm.tracks {
+		if t.updated.Equal(ds[0].at) {
+			continue
+		}
+		if score, ok := t.gate(ds); ok && score < bestScore {
+			best, bestScore = t, score
+		}
+	}
+
+	if best == nil {
+		tm.nextID++
+		best = &track{
+			id:         tm.nextID,
+			bearing:    ds[0].bearing,
+			bearingVar: math.Pow(ds[0].sensor.bearingSigma(), 2),
+			rng:        math.NaN(),
+			sources:    map[sensorKind]time.Time{},
+			first:      ds[0].at,
+			updated:    ds[0].at,
+		}
+		tm.tracks = append(tm.tracks, best)
+		tm.events.add(cell.ColorCyan, "[TRACK] New track %s on %s, bearing %03.0f", best.designation(), ds[0].sensor, ds[0].bearing)
+	} else if best.lost {
+		tm.events.add(cell.ColorCyan, "[TRACK] %s regained, bearing %03.0f", best.designation(), ds[0].bearing)
+	}
+	for _, d := range ds {
+		best.update(own, d)
+	}
+}
+
+// 時刻 at での方位と距離の予測と、それぞれの分散 (度², 距離は割合の 2 乗)
+// 履歴から変化率がわかれば先に進め、最後の更新から経った時間だけ誤差を広げる
+func (t *track) predict(at time.Time) (bearing, rng, bearingVar, rangeVar float64) {
+	bearing, rng, bearingVar, rangeVar = t.bearing, t.rng, t.bearingVar, t.rangeVar
+	dt := at.Sub(t.updated).Seconds()
+	if dt <= 0 {
+		return
+	}
+	bearingRate, rangeRate := t.rates()
+	if !math.IsNaN(bearingRate) {
+		bearing = sim.NormalizeBearing(bearing + bearingRate/60*dt)
+	}
+	if !math.IsNaN(rangeRate) && !math.IsNaN(rng) {
+		rng = math.Max(rng+rangeRate*dt, 1)
+	}
+	bearingVar += math.Pow(trackBearingDrift*dt, 2)
+	rangeVar += math.Pow(trackRangeDrift*dt, 2)
+	return
+}
+
+// 探知 ds が航跡の誤差の範囲に入るか。入れば、差を誤差で割った大きさの 2 乗の平均 (小さいほど近い)
+func (t *track) gate(ds []detection) (float64, bool) {
+	bearing, rng, bearingVar, rangeVar := t.predict(ds[0].at)
+	score, n := 0.0, 0
+	for _, d := range ds {
+		sigma := math.Sqrt(math.Pow(d.sensor.bearingSigma(), 2) + bearingVar)
+		z := math.Abs(sim.NormalizeRelative(d.bearing-bearing)) / sigma
+		if z > trackGateSigmas {
+			return 0, false
+		}
+		score += z * z
+		n++
+		if math.IsNaN(d.rng) || math.IsNaN(rng) {
+			continue
+		}
+		sigma = math.Sqrt(math.Pow(d.sensor.rangeSigma(), 2)+rangeVar) * rng
+		z = math.Abs(d.rng-rng) / sigma
+		if z > trackGateSigmas {
+			return 0, false
+		}
+		score += z * z
+		n++
+	}
+	return score / float64(n), true
+}
+
+// 探知 d で航跡を更新する
+// 予測した方位と距離を、航跡とセンサーの誤差の比で探知の方へ寄せる (誤差の小さいセンサーほど強く効く)
+func (t *track) update(own sim.Point3D, d detection) {
+	bearing, rng, bearingVar, rangeVar := t.predict(d.at)
+	k := bearingVar / (bearingVar + math.Pow(d.sensor.bearingSigma(), 2))
+	t.bearing = sim.NormalizeBearing(bearing + sim.NormalizeRelative(d.bearing-bearing)*k)
+	t.bearingVar = (1 - k) * bearingVar
+	t.rng, t.rangeVar = rng, rangeVar
+	if !math.IsNaN(d.rng) {
+		if math.IsNaN(t.rng) {
+			t.rng, t.rangeVar = d.rng, math.Pow(d.sensor.rangeSigma(), 2)
+		} else {
+			k = rangeVar / (rangeVar + math.Pow(d.sensor.rangeSigma(), 2))
+			t.rng += (d.rng - t.rng) * k
+			t.rangeVar = (1 - k) * rangeVar
+		}
+	}
+
+	t.quality = math.Min(t.currentQuality(d.at)+d.sensor.weight(), 100)
+	t.sources[d.sensor] = d.at
+	t.updated = d.at
+	// 水上の船とまでしかわかっていなければ、艦種がわかったときに置き換える。目視の類別は何より優先する
+	if d.class != "" && (t.class == "" || t.class == surfaceClass || d.sensor == sensorVisual) {
+		t.class = d.class
+	}
+	if d.flag != "" {
+		t.flag = d.flag
+	}
+	if d.bladeRate > 0 {
+		if t.bladeRate == 0 {
+			t.bladeRate = d.bladeRate
+		} else {
+			t.bladeRate += (d.bladeRate - t.bladeRate) * 0.5
+		}
+	}
+	t.lost = false
+	if !math.IsNaN(t.rng) {
+		rad := t.bearing * math.Pi / 180
+		t.estimate = sim.Point3D{X: own.X + math.Sin(rad)*t.rng, Y: own.Y + math.Cos(rad)*t.rng}
+	}
+
+	// 同じ時刻の探知 (別センサー) はまとめて1つの履歴にする
+	sample := trackSample{at: d.at, bearing: t.bearing, rng: t.rng, estimate: t.estimate}
+	if n := len(t.history); n > 0 && t.history[n-1].at.Equal(d.at) {
+		t.history[n-1] = sample
+	} else {
+		t.history = append(t.history, sample)
+	}
+	for len(t.history) > 0 && d.at.Sub(t.history[0].at) > trackRateWindow {
+		t.history = t.history[1:]
+	}
+}
+
+// 同じ目標を追っている航跡をまとめる (tm.mu を保持した状態で呼ぶ)
+// 最後の更新が違う時刻で (同じ掃引で別々の船に結びついたものはまとめない)、now での予測が
+// 誤差の trackMergeSigmas 倍に収まる 2 本を、古い方の番号に新しい方の推定を入れて 1 本にする
+func (tm *trackManager) merge(now time.Time) {
+	for i := 0; i < len(tm.tracks); i++ {
+		for j := i + 1; j < len(tm.tracks); j++ {
+			a, b := tm.tracks[i], tm.tracks[j]
+			if a.updated.Equal(b.updated) || !converged(a, b, now) {
+				continue
+			}
+			keep, gone := a, b
+			if b.id < a.id {
+				keep, gone = b, a
+			}
+			keep.absorb(gone)
+			if tm.selected == gone.id {
+				tm.selected = keep.id
+			}
+			tm.events.add(cell.ColorCyan, "[TRACK] %s merged into %s", gone.designation(), keep.designation())
+			if gone == a {
+				tm.tracks[i] = keep
+			}
+			tm.tracks = append(tm.tracks[:j], tm.tracks[j+1:]...)
+			j--
+		}
+	}
+}
+
+// 2 本の航跡の now での予測が同じ目標とみなせるほど近いか
+func converged(a, b *track, now time.Time) bool {
+	ab, ar, abv, arv := a.predict(now)
+	bb, br, bbv, brv := b.predict(now)
+	if math.Abs(sim.NormalizeRelative(ab-bb)) > trackMergeSigmas*math.Sqrt(abv+bbv) {
+		return false
+	}
+	if math.IsNaN(ar) || math.IsNaN(br) {
+		return true
+	}
+	return math.Abs(ar-br) <= trackMergeSigmas*math.Sqrt(arv+brv)*math.Max(ar, br)
+}
+
+// 同じ目標の航跡 o の情報を取り込む。推定は新しく更新された方を使う
+func (t *track) absorb(o *track) {
+	if o.updated.After(t.updated) {
+		t.bearing, t.bearingVar, t.rng, t.rangeVar = o.bearing, o.bearingVar, o.rng, o.rangeVar
+		t.estimate, t.updated, t.lost = o.estimate, o.updated, o.lost
+		t.history = append([]trackSample{}, o.history...)
+	}
+	if o.first.Before(t.first) {
+		t.first = o.first
+	}
+	t.quality = math.Max(t.quality, o.quality)
+	for k, at := range o.sources {
+		if at.After(t.sources[k]) {
+			t.sources[k] = at
+		}
+	}
+	if t.class == "" || t.class == surfaceClass && o.class != "" {
+		t.class = o.class
+	}
+	if t.flag == "" {
+		t.flag = o.flag
+	}
+	if t.bladeRate == 0 {
+		t.bladeRate = o.bladeRate
+	}
+}
+
+// 一覧の並びで次の航跡を選択する (失探したものは飛ばす)
+// 最後の航跡の次は選択なしに戻る
+func (tm *trackManager) selectNext() {
+	tm.selectStep(1)
+}
+
+// 一覧の並びで前の航跡を選択する (失探したものは飛ばす)
+// 選択なしからは最後の航跡に、最初の航跡からは選択なしに戻る
+func (tm *trackManager) selectPrev() {
+	tm.selectStep(-1)
+}
+
+// 選択なしを含めて一巡するように dir だけ選択を動かす
+func (tm *trackManager) selectStep(dir int) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	var ids []int
+	for _, t := range tm.sorted(clock.Now()) {
+		if !t.lost {
+			ids = append(ids, t.id)
+		}
+	}
+	// 選択なしは並びの最後の次とみなす
+	cur := len(ids)
+	for i, id := range ids {
+		if id == tm.selected {
+			cur = i
+		}
+	}
+	next := cycleIndex(cur, len(ids)+1, dir)
+	tm.selected = 0
+	if next < len(ids) {
+		tm.selected = ids[next]
+	}
+}
+
+// 古い航跡を失探にし、さらに古いものを消す
+func (tm *trackManager) age(now time.Time) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	remaining := tm.tracks[:0]
+	for _, t := range tm.tracks {
+		age := t.age(now)
+		if age > trackDropAge {
+			if t.id == tm.selected {
+				tm.selected = 0
+			}
+			continue
+		}
+		if age > trackLostAge && !t.lost {
+			t.lost = true
+			tm.events.add(cell.ColorYellow, "[TRACK] %s lost", t.designation())
+		}
+		remaining = append(remaining, t)
+	}
+	tm.tracks = remaining
+	tm.merge(now)
+}
+
+// 一覧の並び。失探していないものを品質の高い順に並べ、失探したものを後ろにつける (tm.mu を保持した状態で呼ぶ)
+func (tm *trackManager) sorted(now time.Time) []*track {
+	list := append([]*track{}, tm.tracks...)
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].lost != list[j].lost {
+			return !list[i].lost
+		}
+		return list[i].currentQuality(now) > list[j].currentQuality(now)
+	})
+	return list
+}
+
+// 描画のゴルーチンに渡すコピー。report が書き換える履歴とセンサーの記録も別に持たせる (tm.mu を保持した状態で呼ぶ)
+func (t *track) clone() track {
+	c := *t
+	c.history = append([]trackSample{}, t.history...)
+	c.sources = make(map[sensorKind]time.Time, len(t.sources))
+	for k, at := range t.sources {
+		c.sources[k] = at
+	}
+	return c
+}
+
+// 航跡の一覧 (コピー) と選択中の航跡の番号
+func (tm *trackManager) snapshot(now time.Time) ([]track, int) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	sorted := tm.sorted(now)
+	list := make([]track, len(sorted))
+	for i, t := range sorted {
+		list[i] = t.clone()
+	}
+	return list, tm.selected
+}
+
+// 選択中の航跡 (コピー)。選択していなければ false
+func (tm *trackManager) selectedTrack() (track, bool) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	for _, t := range tm.tracks {
+		if t.id == tm.selected {
+			return t.clone(), true
+		}
+	}
+	return track{}, false
+}
+
+// 各センサーで周囲の船を探し、探知を航跡管理に渡す
+// 潜望鏡深度より浅いときだけレーダー・ESM・目視が使える
+func sensorSweep(p *Player, env *environment, tr *traffic, tm *trackManager, passive *passiveSonarArray, rng *rand.Rand) {
+	if simPause.on() {
+		return
+	}
+	now := clock.Now()
+	own := p.Position
+	// 速く走るほど流体雑音で聞こえにくい
+	flow := flowNoise(p.Velocity)
+	noise := hydrophoneNoise(tr.ambientNoiseAt(own), p.Velocity)
+	shallow := p.Depth() <= periscopeDepth
+	ships := tr.vessels()
+	heard := map[int]float64{}
+	for _, s := range ships {
+		bearing := sim.BearingTo(own, s.position)
+		dist := sim.HorizontalDistance(own, s.position)
+		target := s.hull()
+		// 翼数周波数はパッシブソーナーでしか聞き取れない
+		blade := 0.0
+		// この船の探知は掃引の終わりにまとめて航跡に結びつける
+		var found []detection
+		detect := func(kind sensorKind, class, flag string) {
+			d := detection{
+				sensor:  kind,
+				bearing: sim.NormalizeBearing(bearing + rng.NormFloat64()*kind.bearingSigma()),
+				rng:     math.NaN(),
+				class:   class,
+				flag:    flag,
+				at:      now,
+			}
+			if kind.rangeSigma() > 0 {
+				d.rng = dist * (1 + rng.NormFloat64()*kind.rangeSigma())
+			}
+			if kind == sensorPassive {
+				d.bladeRate = blade
+			}
+			found = append(found, d)
+		}
+
+		if se := passiveSonar.signalExcess(env, own, target, sonarTarget{sourceLevel: s.noise}, noise) + p.watchBonus() - p.sonarLoss(); se >= 0 {
+			if se >= bladeRateMargin {
+				blade = measureBladeRate(s.class, s.speed, rng)
+			}
+			// 聞き続けて類別できていれば艦種がわかる
+			detect(sensorPassive, passive.classified(s.id), "")
+			heard[s.id] = se
+		}
+		// 潜航している潜水艦はレーダーも電波も目視も捉えない
+		if shallow && !s.submerged() {
+			// レーダーと ESM では水上の船とまでしかわからない。船籍は目視で旗を見て識別する
+			if dist <= radarRange {
+				detect(sensorRadar, surfaceClass, "")
+			}
+			if dist <= esmRange {
+				detect(sensorESM, surfaceClass, "")
+			}
+			if dist <= env.sightingRange() {
+				detect(sensorVisual, s.class, s.flag)
+			}
+		}
+		tm.reportAll(own, found)
+	}
+	passive.listen(own, env, ships, heard, noise, flow, rng, now)
+	tm.age(now)
+}
+
+// 航跡一覧 (コンタクトリスト) の表示
+// 選択中の航跡が見えるよう、contactListRows 行ずつスクロールする。描画のパスに渡す関数を返す
+func trackPanel(t *text.Text) func(f *frame) {
+	// 一覧の先頭に出している行
+	top := 0
+	return func(f *frame) {
+		now := f.now
+		tracks, selected := f.tracks, f.selected
+
+		t.Reset()
+		if len(tracks) == 0 {
+			if err := t.Write("No contacts.\n", text.WriteCellOpts(cell.FgColor(cell.ColorYellow))); err != nil {
+				panic(err)
+			}
+			return
+		}
+		for i, tr := range tracks {
+			if tr.id != selected {
+				continue
+			}
+			if i < top {
+				top = i
+			}
+			if i >= top+contactListRows {
+				top = i - contactListRows + 1
+			}
+		}
+		top = int(math.Max(math.Min(float64(top), float64(len(tracks)-contactListRows)), 0))
+
+		if err := t.Write(" ID  CLASS     SIDE SRC   BRG    RNG     Q   AGE\n"); err != nil {
+			panic(err)
+		}
+		if top > 0 {
+			if err := t.Write(fmt.Sprintf(" ^ %d more\n", top)); err != nil {
+				panic(err)
+			}
+		}
+		end := int(math.Min(float64(top+contactListRows), float64(len(tracks))))
+		for _, tr := range tracks[top:end] {
+			rng := "  ---  "
+			if !math.IsNaN(tr.rng) {
+				rng = fmt.Sprintf("%5.1fkm", tr.rng/1000)
+			}
+			color := cell.ColorGreen
+			status := fmt.Sprintf("Q%3.0f", tr.currentQuality(now))
+			if tr.lost {
+				color = cell.ColorRed
+				status = "LOST"
+			} else if tr.currentQuality(now) < 30 {
+				color = cell.ColorYellow
+			}
+			marker := " "
+			opts := []cell.Option{cell.FgColor(color)}
+			if tr.id == selected {
+				marker = ">"
+				opts = append(opts, cell.BgColor(cell.ColorBlue))
+			}
+			line := fmt.Sprintf("%s%s %-9.9s %s %s %03.0f° %s %s %3.0fs\n",
+				marker, tr.designation(), tr.classification(), tr.side(), tr.sourceCodes(now), tr.bearing, rng, status, tr.age(now).Seconds())
+			if err := t.Write(line, text.WriteCellOpts(opts...)); err != nil {
+				panic(err)
+			}
+		}
+		if end < len(tracks) {
+			if err := t.Write(fmt.Sprintf(" v %d more\n", len(tracks)-end)); err != nil {
+				panic(err)
+			}
+		}
+	}
+}
+
+// 選択中の航跡の方位変化率・距離変化率
+// 目標運動解析や衝突のおそれの判断に使う
+func writeTrackRates(out *panelText, tr *track) {
+	bearingRate, rangeRate := tr.rates()
+	brg := "  ---  "
+	if !math.IsNaN(bearingRate) {
+		brg = fmt.Sprintf("%+.1f°/min", bearingRate)
+	}
+	rng := "---"
+	if !math.IsNaN(rangeRate) {
+		trend := "opening"
+		if rangeRate < 0 {
+			trend = "closing"
+		}
+		rng = fmt.Sprintf("%+.1f kt %s", rangeRate/knot, trend)
+	}
+	out.write(fmt.Sprintf("%s BRG RATE %s  RNG RATE %s\n", tr.designation(), brg, rng), cell.ColorCyan)
+	if tr.collisionRisk() {
+		out.write("CBDR - RISK OF COLLISION\n", cell.ColorRed)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+var testTrackTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// at に方位 bearing (分散 1 度²)、距離 rng (NaN ならわからない, 分散は 1% の 2 乗) で更新された航跡
+func newTestTrack(id int, bearing, rng float64, at time.Time) *track {
+	return &track{
+		id:         id,
+		bearing:    bearing,
+		bearingVar: 1,
+		rng:        rng,
+		rangeVar:   1e-4,
+		sources:    map[sensorKind]time.Time{sensorPassive: at},
+		first:      at,
+		updated:    at,
+		quality:    50,
+	}
+}
+
+// 予測との差がセンサーと航跡の誤差の 3 倍に収まる探知だけを結びつける
+func TestTrackGate(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		name      string
+		bearing   float64
+		rng       float64
+		d         detection
+		wantOK    bool
+		wantScore float64
+	}{
+		// パッシブの誤差 1.5 度と航跡の 1 度を合わせて 1.80 度。3 倍は 5.4 度
+		{"on the prediction", 90, nan, detection{sensor: sensorPassive, bearing: 90, rng: nan}, true, 0},
+		{"inside the gate", 90, nan, detection{sensor: sensorPassive, bearing: 95, rng: nan}, true, 25 / 3.25},
+		{"outside the gate", 90, nan, detection{sensor: sensorPassive, bearing: 96, rng: nan}, false, 0},
+		{"left of the prediction", 90, nan, detection{sensor: sensorPassive, bearing: 85, rng: nan}, true, 25 / 3.25},
+		{"across north", 359, nan, detection{sensor: sensorPassive, bearing: 2, rng: nan}, true, 9 / 3.25},
+		// アクティブの方位の誤差は 0.5 度なので狭い
+		{"narrow sensor", 90, nan, detection{sensor: sensorActive, bearing: 94, rng: nan}, false, 0},
+		// 距離の誤差は 1% と 1% を合わせて 141 m。3 倍は 424 m
+		{"range inside", 90, 10000, detection{sensor: sensorActive, bearing: 90, rng: 10400}, true, 4},
+		{"range outside", 90, 10000, detection{sensor: sensorActive, bearing: 90, rng: 10500}, false, 0},
+		{"no range on a ranged track", 90, 10000, detection{sensor: sensorPassive, bearing: 90, rng: nan}, true, 0},
+		{"range on an unranged track", 90, nan, detection{sensor: sensorActive, bearing: 90, rng: 3000}, true, 0},
+	}
+	for _, tt := range tests {
+		tr := newTestTrack(1, tt.bearing, tt.rng, testTrackTime)
+		tt.d.at = testTrackTime
+		score, ok := tr.gate([]detection{tt.d})
+		if ok != tt.wantOK {
+			t.Errorf("%s: in gate %v, want %v", tt.name, ok, tt.wantOK)
+			continue
+		}
+		if ok && math.Abs(score-tt.wantScore) > 1e-9 {
+			t.Errorf("%s: score %v, want %v", tt.name, score, tt.wantScore)
+		}
+	}
+}
+
+// 更新がない間は誤差が広がり、外れていた探知も同じ目標とみなす
+func TestTrackGateWidensWithAge(t *testing.T) {
+	tr := newTestTrack(1, 90, math.NaN(), testTrackTime)
+	for _, tt := range []struct {
+		after  time.Duration
+		wantOK bool
+	}{
+		{0, false},
+		{10 * time.Second, false},
+		// 30 秒で 3 度広がる
+		{30 * time.Second, true},
+	} {
+		d := detection{sensor: sensorPassive, bearing: 97, rng: math.NaN(), at: testTrackTime.Add(tt.after)}
+		if _, ok := tr.gate([]detection{d}); ok != tt.wantOK {
+			t.Errorf("after %v: in gate %v, want %v", tt.after, ok, tt.wantOK)
+		}
+	}
+}
+
+// 変化率がわかれば、予測を進めた先で結びつける
+func TestTrackPredictFollowsBearingRate(t *testing.T) {
+	tr := newTestTrack(1, 90, math.NaN(), testTrackTime)
+	// 1 分で 6 度右へ回る
+	for i := 0; i <= 6; i++ {
+		at := testTrackTime.Add(time.Duration(i) * 10 * time.Second)
+		tr.history = append(tr.history, trackSample{at: at, bearing: 90 + float64(i), rng: math.NaN()})
+		tr.bearing, tr.updated = 90+float64(i), at
+	}
+	bearing, _, _, _ := tr.predict(tr.updated.Add(30 * time.Second))
+	if math.Abs(bearing-99) > 1e-6 {
+		t.Errorf("predicted bearing %v, want 99", bearing)
+	}
+	d := detection{sensor: sensorPassive, bearing: 99, rng: math.NaN(), at: tr.updated.Add(30 * time.Second)}
+	if score, ok := tr.gate([]detection{d}); !ok || score > 1e-6 {
+		t.Errorf("detection on the predicted bearing: score %v, in gate %v", score, ok)
+	}
+}
+
+// 一番近い航跡に結びつけ、同じ掃引で別の船を捉えた航跡には結びつけない
+func TestTrackManagerAssociation(t *testing.T) {
+	events, te := newTestEvents(t)
+	tm := newTrackManager(events)
+	own := newTestPlayer().Position
+	nan := math.NaN()
+
+	// 同じ掃引で近い方位の 2 隻を捉えると 2 本になる
+	at := testTrackTime
+	tm.report(own, detection{sensor: sensorPassive, bearing: 90, rng: nan, at: at})
+	tm.report(own, detection{sensor: sensorPassive, bearing: 92, rng: nan, at: at})
+	if tm.count() != 2 {
+		t.Fatalf("%d tracks after two ships in one sweep, want 2", tm.count())
+	}
+
+	// 次の掃引では近い方に結びつく
+	at = at.Add(5 * time.Second)
+	tm.report(own, detection{sensor: sensorPassive, bearing: 92.5, rng: nan, at: at})
+	tm.report(own, detection{sensor: sensorPassive, bearing: 89.5, rng: nan, at: at})
+	// 離れた方位は新しい航跡
+	tm.report(own, detection{sensor: sensorPassive, bearing: 200, rng: nan, at: at})
+	if tm.count() != 3 {
+		t.Fatalf("%d tracks, want 3", tm.count())
+	}
+	list, _ := tm.snapshot(at)
+	for _, tr := range list {
+		want := map[int]float64{1: 89.5, 2: 92.5, 3: 200}[tr.id]
+		if math.Abs(tr.bearing-want) > 1 {
+			t.Errorf("%s bearing %.1f, want about %.1f", tr.designation(), tr.bearing, want)
+		}
+		if tr.id != 3 && len(tr.history) != 2 {
+			t.Errorf("%s has %d samples, want 2", tr.designation(), len(tr.history))
+		}
+	}
+	if !te.contains("[TRACK] New track T03 on P, bearing 200") {
+		t.Errorf("new track not reported: %q", te.lines)
+	}
+}
+
+// 同じ時刻の別センサーの探知はまとめて 1 本の航跡に結びつける
+func TestTrackManagerReportAll(t *testing.T) {
+	events, _ := newTestEvents(t)
+	tm := newTrackManager(events)
+	own := newTestPlayer().Position
+	tm.reportAll(own, []detection{
+		{sensor: sensorPassive, bearing: 45, rng: math.NaN(), at: testTrackTime},
+		{sensor: sensorActive, bearing: 45.2, rng: 8000, class: "trawler", at: testTrackTime},
+	})
+	if tm.count() != 1 {
+		t.Fatalf("%d tracks, want 1", tm.count())
+	}
+	list, _ := tm.snapshot(testTrackTime)
+	tr := list[0]
+	if tr.rng != 8000 || tr.class != "trawler" || tr.sourceCodes(testTrackTime) != "PA---" || len(tr.history) != 1 {
+		t.Errorf("track %+v", tr)
+	}
+	// 距離がわかれば推定位置も出る
+	if math.Abs(tr.estimate.X-own.X-math.Sin(tr.bearing*math.Pi/180)*8000) > 1e-6 {
+		t.Errorf("estimate %+v for bearing %v", tr.estimate, tr.bearing)
+	}
+}
+
+// 推定が重なった 2 本は古い番号にまとめ、新しく更新された方の推定を使う
+func TestTrackMerge(t *testing.T) {
+	nan := math.NaN()
+	later := testTrackTime.Add(time.Second)
+	tests := []struct {
+		name   string
+		a, b   *track
+		merged bool
+	}{
+		{"close bearings", newTestTrack(1, 90, nan, testTrackTime), newTestTrack(2, 91, nan, later), true},
+		{"across north", newTestTrack(1, 359.5, nan, testTrackTime), newTestTrack(2, 0.5, nan, later), true},
+		{"apart", newTestTrack(1, 90, nan, testTrackTime), newTestTrack(2, 100, nan, later), false},
+		{"same sweep", newTestTrack(1, 90, nan, later), newTestTrack(2, 90.5, nan, later), false},
+		{"one without range", newTestTrack(1, 90, 10000, testTrackTime), newTestTrack(2, 90.5, nan, later), true},
+		{"close ranges", newTestTrack(1, 90, 10000, testTrackTime), newTestTrack(2, 90.5, 10100, later), true},
+		{"apart in range", newTestTrack(1, 90, 10000, testTrackTime), newTestTrack(2, 90.5, 12000, later), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, te := newTestEvents(t)
+			tm := newTrackManager(events)
+			tt.b.sources = map[sensorKind]time.Time{sensorActive: later}
+			tt.b.class = "tanker"
+			tm.tracks, tm.nextID, tm.selected = []*track{tt.b, tt.a}, 2, 2
+			wantBearing, wantRange := tt.b.bearing, tt.b.rng
+
+			tm.merge(later)
+			if tt.merged != (len(tm.tracks) == 1) {
+				t.Fatalf("%d tracks left, merged %v", len(tm.tracks), tt.merged)
+			}
+			if !tt.merged {
+				return
+			}
+			kept := tm.tracks[0]
+			if kept.id != 1 || tm.selected != 1 {
+				t.Errorf("kept %s, selected %d; want T01 selected", kept.designation(), tm.selected)
+			}
+			if kept.bearing != wantBearing || kept.class != "tanker" || kept.first != testTrackTime || kept.sourceCodes(later) != "PA---" {
+				t.Errorf("merged track %+v", kept)
+			}
+			if !math.IsNaN(wantRange) && kept.rng != wantRange {
+				t.Errorf("merged range %v, want %v", kept.rng, wantRange)
+			}
+			if !te.contains("[TRACK] T02 merged into T01") {
+				t.Errorf("merge not reported: %q", te.lines)
+			}
+		})
+	}
+}
+
+// 更新が途絶えると失探になり、さらに経つと消える。また探知すれば同じ番号に戻る
+func TestTrackAging(t *testing.T) {
+	events, te := newTestEvents(t)
+	tm := newTrackManager(events)
+	own := newTestPlayer().Position
+	tm.report(own, detection{sensor: sensorActive, bearing: 270, rng: math.NaN(), at: testTrackTime})
+
+	tm.age(testTrackTime.Add(trackLostAge + time.Second))
+	list, _ := tm.snapshot(testTrackTime.Add(trackLostAge + time.Second))
+	if len(list) != 1 || !list[0].lost || list[0].currentQuality(testTrackTime.Add(trackLostAge)) != 0 {
+		t.Fatalf("after %v: %+v", trackLostAge, list)
+	}
+	if !te.contains("[TRACK] T01 lost") {
+		t.Errorf("loss not reported: %q", te.lines)
+	}
+
+	// 誤差が広がっているので少し外れた方位でも戻る
+	regained := testTrackTime.Add(trackLostAge + 2*time.Second)
+	tm.report(own, detection{sensor: sensorPassive, bearing: 280, rng: math.NaN(), at: regained})
+	if list, _ = tm.snapshot(regained); len(list) != 1 || list[0].lost || tm.count() != 1 {
+		t.Errorf("after the new detection: %d tracks made, %+v", tm.count(), list)
+	}
+	if !te.contains("[TRACK] T01 regained, bearing 280") {
+		t.Errorf("regain not reported: %q", te.lines)
+	}
+
+	tm.age(regained.Add(trackDropAge + time.Second))
+	if tm.live() != 0 {
+		t.Errorf("%d tracks after %v without an update", tm.live(), trackDropAge)
+	}
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 民間船の航路と商船
+//
+// 航路に沿って商船が定期的に行き来する。商船の雑音は周囲の背景雑音を押し上げるので、
+// 聴音の妨げになる一方で、自艦の雑音を紛れさせる隠れ蓑にもなる。
+// 航路は過去に自艦が船を沈めた海域を避けて引き直される (attacks.go)。
+// 浅い深度で商船の真下に入ると衝突する。
+
+// 海域に出現する船
+type vessel struct {
+	id       int
+	name     string
+	class    string
+	position sim.Point3D
+	// 針路 (度) と速力 (m/s)
+	course float64
+	speed  float64
+	// 放射雑音 (dB)
+	noise float64
+	// 喫水 (m)
+	draft float64
+	// 潜航している潜水艦の深度 (m)。水上の船は 0
+	depth float64
+	// シナリオで出した船は航路の範囲を出ても消さない
+	scripted bool
+	// 船籍 (交戦規則を参照)
+	flag string
+}
+
+// 潜航しているか
+func (v *vessel) submerged() bool {
+	return v.depth > 0
+}
+
+// 音を出し、ピンを反射する位置。水上の船は喫水の半分の深さ
+func (v *vessel) hull() sim.Point3D {
+	pos := v.position
+	if v.submerged() {
+		pos.Z = -v.depth
+	} else {
+		pos.Z = -v.draft / 2
+	}
+	return pos
+}
+
+// 聴音器の位置。水上の船は船底
+func (v *vessel) sonar() sim.Point3D {
+	pos := v.position
+	if v.submerged() {
+		pos.Z = -v.depth
+	} else {
+		pos.Z = -v.draft
+	}
+	return pos
+}
+
+// 深度 depth (m) の自艦とぶつかりうるか
+func (v *vessel) collides(depth float64) bool {
+	if v.submerged() {
+		return math.Abs(depth-v.depth) < 15
+	}
+	return depth < v.draft+5
+}
+
+// 1ノットあたりの m/s
+const knot = sim.Knot
+
+const (
+	// 海況 0 のときの背景雑音 (dB)
+	seaAmbientNoise = 50.0
+	// 商船の放射雑音 (dB)
+	merchantNoise = 140.0
+	// 商船の喫水 (m)
+	merchantDraft = 12.0
+	// この距離・深度まで近づいたら警報を出す
+	trafficWarningRange = 1500.0
+	trafficWarningDepth = 40.0
+	// この距離まで近づき、喫水より浅ければ (潜水艦なら深度が近ければ) 衝突する
+	trafficCollisionRange = 60.0
+)
+
+// 航路の設定
+// シナリオごとに航路や通航量を変えられるよう、設定ファイルに書ける形にしてある
+type laneConfig struct {
+	Name string `json:"name"`
+	// 始点と終点 (x, y)
+	From [2]float64 `json:"from"`
+	To   [2]float64 `json:"to"`
+	// 航路の幅 (m)
+	Width float64 `json:"width"`
+	// 商船が出現する平均間隔 (秒)
+	Interval float64 `json:"interval"`
+	// 商船の速力 (ノット)
+	Speed float64 `json:"speed"`
+}
+
+type trafficConfig struct {
+	Lanes []laneConfig `json:"lanes"`
+}
+
+func defaultTrafficConfig() trafficConfig {
+	return trafficConfig{
+		Lanes: []laneConfig{
+			{Name: "Northbound", From: [2]float64{-2000, -30000}, To: [2]float64{-2000, 30000}, Width: 1500, Interval: 240, Speed: 14},
+			{Name: "Southbound", From: [2]float64{1500, 30000}, To: [2]float64{1500, -30000}, Width: 1500, Interval: 300, Speed: 12},
+		},
+	}
+}
+
+var merchantNames = []string{
+	"Pacific Star", "Nordic Trader", "Hanjin Ace", "Maersk Kobe", "Ocean Harmony",
+	"Golden Gate", "Sea Breeze", "Arctic Dawn", "Blue Horizon", "Iron Duke",
+}
+
+type trafficLane struct {
+	// 今の航路 (避ける範囲に合わせてずらしたもの) と、設定された元の航路
+	laneConfig
+	base laneConfig
+	// 次の商船が出現するまでの秒数
+	untilNext float64
+}
+
+// 海域の商船交通
+type traffic struct {
+	events *eventLog
+	env    *environment
+	rng    *rand.Rand
+
+	mu       sync.Mutex
+	lanes    []*trafficLane
+	ships    []*vessel
+	nextID   int
+	warned   map[int]bool
+	collided map[int]bool
+	// 沈んだ船の名前
+	sunk map[string]bool
+}
+
+// avoid は商船が避ける範囲 (過去の攻撃地点)
+func newTraffic(cfg trafficConfig, avoid []avoidZone, events *eventLog, env *environment, rng *rand.Rand) *traffic {
+	tr := &traffic{
+		events:   events,
+		env:      env,
+		rng:      rng,
+		warned:   map[int]bool{},
+		collided: map[int]bool{},
+		sunk:     map[string]bool{},
+	}
+	for _, l := range cfg.Lanes {
+		tr.lanes = append(tr.lanes, &trafficLane{laneConfig: l, base: l})
+	}
+	tr.reroute(avoid)
+	for _, lane := range tr.lanes {
+		l := lane.laneConfig
+		// 最初から航路上に何隻かいる状態にしておく
+		length := math.Hypot(l.To[0]-l.From[0], l.To[1]-l.From[1])
+		spacing := l.Speed * knot * l.Interval
+		for d := spacing * rng.Float64(); d < length; d += spacing {
+			tr.spawn(lane, d)
+		}
+		lane.untilNext = l.Interval * (0.5 + rng.Float64())
+	}
+	return tr
+}
+
+// 航路の始点から along (m) の位置に商船を出す (tr.mu を保持した状態で呼ぶ)
+func (tr *traffic) spawn(lane *trafficLane, along float64) {
+	dx, dy := lane.To[0]-lane.From[0], lane.To[1]-lane.From[1]
+	length := math.Hypot(dx, dy)
+	ux, uy := dx/length, dy/length
+	offset := (tr.rng.Float64() - 0.5) * lane.Width
+	tr.nextID++
+	// 船籍は船ごとに決まっている
+	i := tr.rng.Intn(len(merchantNames))
+	tr.ships = append(tr.ships, &vessel{
+		id:    tr.nextID,
+		name:  merchantNames[i],
+		class: "Merchant",
+		flag:  neutralFlags[i%len(neutralFlags)],
+		position: sim.Point3D{
+			X: lane.From[0] + ux*along - uy*offset,
+			Y: lane.From[1] + uy*along + ux*offset,
+		},
+		course: sim.BearingTo(sim.Point3D{}, sim.Point3D{X: dx, Y: dy}),
+		speed:  lane.Speed * knot * (0.9 + tr.rng.Float64()*0.2),
+		noise:  merchantNoise + tr.rng.Float64()*10 - 5,
+		draft:  merchantDraft,
+	})
+}
+
+// 航路とは関係なく、指定した位置に船を出す (シナリオのトリガーや敵の艦艇が使う)
+// 船籍 flag が空なら艦種で決める
+func (tr *traffic) spawnAt(name, class, flag string, pos sim.Point3D, course, speedKnots float64) int {
+	if flag == "" {
+		flag = defaultFlag(class)
+	}
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.nextID++
+	tr.ships = append(tr.ships, &vessel{
+		id:       tr.nextID,
+		name:     name,
+		class:    class,
+		flag:     flag,
+		position: pos,
+		course:   sim.NormalizeBearing(course),
+		speed:    speedKnots * knot,
+		noise:    merchantNoise,
+		draft:    merchantDraft,
+		scripted: true,
+	})
+	return tr.nextID
+}
+
+// 船の針路・速力・深度・放射雑音を変える (敵の艦艇が使う)。もういなければ false
+func (tr *traffic) steer(id int, course, speedKnots, depth, noise float64) bool {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	for _, s := range tr.ships {
+		if s.id == id {
+			s.course = sim.NormalizeBearing(course)
+			s.speed = speedKnots * knot
+			s.depth = depth
+			s.noise = noise
+			return true
+		}
+	}
+	return false
+}
+
+// 船籍を変える (臨検で拿捕した船や、正体を現した船)。もういなければ false
+func (tr *traffic) setFlag(id int, flag string) bool {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	for _, s := range tr.ships {
+		if s.id == id {
+			s.flag = flag
+			return true
+		}
+	}
+	return false
+}
+
+// id の船を沈める (海域から消す)。もういなければ false
+func (tr *traffic) sink(id int) bool {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	for i, s := range tr.ships {
+		if s.id == id {
+			tr.ships = append(tr.ships[:i], tr.ships[i+1:]...)
+			delete(tr.warned, id)
+			delete(tr.collided, id)
+			tr.sunk[s.name] = true
+			return true
+		}
+	}
+	return false
+}
+
+// name という船が沈んだか
+func (tr *traffic) wasSunk(name string) bool {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	return tr.sunk[name]
+}
+
+// id の船 (コピー)
+func (tr *traffic) vessel(id int) (vessel, bool) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	for _, s := range tr.ships {
+		if s.id == id {
+			return *s, true
+		}
+	}
+	return vessel{}, false
+}
+
+// dt 秒分だけ商船を動かし、出現・消滅と衝突を処理する
+func (tr *traffic) step(p *Player, dt float64) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	for _, lane := range tr.lanes {
+		lane.untilNext -= dt
+		if lane.untilNext <= 0 {
+			tr.spawn(lane, 0)
+			lane.untilNext = lane.Interval * (0.5 + tr.rng.Float64())
+		}
+	}
+
+	remaining := tr.ships[:0]
+	for _, s := range tr.ships {
+		rad := s.course * math.Pi / 180
+		s.position.X += math.Sin(rad) * s.speed * dt
+		s.position.Y += math.Cos(rad) * s.speed * dt
+		if !s.scripted && !tr.inArea(s) {
+			delete(tr.warned, s.id)
+			delete(tr.collided, s.id)
+			continue
+		}
+		remaining = append(remaining, s)
+
+		rng := sim.HorizontalDistance(p.Position, s.position)
+		switch {
+		case rng < trafficCollisionRange && s.collides(p.Depth()) && !tr.collided[s.id]:
+			tr.collided[s.id] = true
+			hit := sim.CompartmentAt(sim.BearingTo(p.Position, s.position) - p.Direction)
+			p.TakeDamage(hit, 30)
+			tr.events.add(cell.ColorRed, "[ALARM] Collision with %s! Damage in the %s. Hull integrity %.0f%%", s.name, hit, p.HullIntegrity)
+		case rng < trafficWarningRange && !s.submerged() && p.Depth() < trafficWarningDepth && !tr.warned[s.id]:
+			tr.warned[s.id] = true
+			tr.events.add(cell.ColorYellow, "[TRAFFIC] %s close aboard at %.0f m, bearing %03.0f. Go deep!", s.name, rng, sim.BearingTo(p.Position, s.position))
+		}
+	}
+	tr.ships = remaining
+}
+
+// 航路の範囲に収まっているか (tr.mu を保持した状態で呼ぶ)
+// 航路を引き直す前に出た商船が消えないよう、元の航路の範囲も含める
+func (tr *traffic) inArea(s *vessel) bool {
+	for _, lane := range tr.lanes {
+		for _, l := range []laneConfig{lane.laneConfig, lane.base} {
+			minX := math.Min(l.From[0], l.To[0]) - l.Width
+			maxX := math.Max(l.From[0], l.To[0]) + l.Width
+			minY := math.Min(l.From[1], l.To[1]) - l.Width
+			maxY := math.Max(l.From[1], l.To[1]) + l.Width
+			if s.position.X >= minX && s.position.X <= maxX && s.position.Y >= minY && s.position.Y <= maxY {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 指定した位置での背景雑音 (dB)
+// 商船の雑音を伝搬モデルで減衰させ、海の背景雑音と電力で足し合わせる
+func (tr *traffic) ambientNoiseAt(pos sim.Point3D) float64 {
+	return tr.noiseHeardBy(pos, 0)
+}
+
+// id の船の聴音器に届く背景雑音 (dB)。その船自身の音は含めない
+func (tr *traffic) noiseHeardBy(pos sim.Point3D, id int) float64 {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	power := math.Pow(10, tr.env.ambientNoise()/10)
+	for _, s := range tr.ships {
+		if s.id == id {
+			continue
+		}
+		level := s.noise - tr.env.transmissionLoss(pos, s.hull(), passiveSonar.frequency)
+		power += math.Pow(10, level/10)
+	}
+	return 10 * math.Log10(power)
+}
+
+// 商船の一覧 (コピー)
+func (tr *traffic) vessels() []vessel {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	list := make([]vessel, len(tr.ships))
+	for i, s := range tr.ships {
+		list[i] = *s
+	}
+	return list
+}
+
+// 商船を進める (ゲームループから delay ごとに呼ぶ)
+func trafficTick(p *Player, tr *traffic, delay time.Duration) {
+	if simPause.on() {
+		return
+	}
+	tr.step(p, delay.Seconds()*float64(simRate.factor()))
+}
+
+// 水上の状況の表示
+func surfacePicturePanel(p *Player, tr *traffic) panelText {
+	ships := tr.vessels()
+	ambient := tr.ambientNoiseAt(p.Position)
+	nearest := -1
+	nearestRange := math.Inf(1)
+	for i, s := range ships {
+		if r := sim.HorizontalDistance(p.Position, s.position); r < nearestRange {
+			nearest, nearestRange = i, r
+		}
+	}
+
+	var out panelText
+	ambientColor := cell.ColorDefault
+	if ambient > p.noiseLevel() {
+		// 自艦の雑音が背景雑音に紛れている
+		ambientColor = cell.ColorGreen
+	}
+	out.write(fmt.Sprintf("Ambient %.0f dB  Own %.0f dB\n", ambient, p.noiseLevel()), ambientColor)
+	out.write(fmt.Sprintf("Merchants in area: %d\n", len(ships)), cell.ColorDefault)
+	if nearest >= 0 {
+		s := ships[nearest]
+		color := cell.ColorDefault
+		if nearestRange < trafficWarningRange && p.Depth() < trafficWarningDepth {
+			color = cell.ColorRed
+		}
+		out.write(fmt.Sprintf("Nearest %s %03.0f° %.1f km\n", s.name, sim.BearingTo(p.Position, s.position), nearestRange/1000), color)
+	}
+	return out
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 損傷対処の訓練
+//
+// 艦長が号令をかけるか (training-drill キー)、脅威のないときに trainingIntervalMin〜trainingIntervalMax ごと
+// (config.toml の training_drills) に、模擬の火災・浸水・潜舵の故障を起こす。本当の損傷は起きず、
+// 正しい対処をするまでの時間で採点する。
+//   - 火災: 換気ファンを止めて火に空気を送らない (Machinery パネル)
+//   - 浸水: ビルジポンプを回す
+//   - 潜舵の故障 (下げ舵のまま動かない): 回転数を trainingPlanesRpm 以下に落として行き足を止める
+//
+// trainingQuick 以内に対処すれば 100 点、trainingTimeLimit で 0 点になる。始めたときに対処が済んでいる故障は起こさない。
+// trainingPassScore 以上なら、その配置 (火災と浸水は応急、潜舵は操舵) に就いている乗員の腕前が点数に応じて上がる
+// (最大 trainingSkillGain、trainingSkillCap まで)。上がった腕前はセーブデータに残る。
+
+const (
+	// 定期の訓練の間隔 (シミュレーション時間)
+	trainingIntervalMin = 45 * time.Minute
+	trainingIntervalMax = 90 * time.Minute
+	// 満点になる時間と、打ち切る時間 (秒)
+	trainingQuick     = 30.0
+	trainingTimeLimit = 180.0
+	// 腕前が上がる点数と、1 回で上がる最大の腕前、訓練で上げられる腕前の上限
+	trainingPassScore = 50
+	trainingSkillGain = 2.0
+	trainingSkillCap  = 95.0
+	// 潜舵の故障で行き足を止めたとみなす回転数の命令値 (rpm)
+	trainingPlanesRpm = 20.0
+)
+
+// 定期の訓練をするか (config.toml の training_drills)
+var trainingDrills = true
+
+type trainingKind int
+
+const (
+	trainingFire trainingKind = iota
+	trainingFlooding
+	trainingJammedPlanes
+	trainingKindCount
+)
+
+func (k trainingKind) String() string {
+	switch k {
+	case trainingFlooding:
+		return "Flooding"
+	case trainingJammedPlanes:
+		return "Jammed planes"
+	}
+	return "Fire"
+}
+
+// 採点した乗員の配置
+func (k trainingKind) station() station {
+	if k == trainingJammedPlanes {
+		return stationHelm
+	}
+	return stationDamageControl
+}
+
+// 対処が済んでいるか
+func (k trainingKind) handled(p *Player) bool {
+	switch k {
+	case trainingFlooding:
+		return p.BilgePumps
+	case trainingJammedPlanes:
+		return p.Turbine.Ordered <= trainingPlanesRpm
+	}
+	return p.equipmentSecured[equipVentilation]
+}
+
+// 号令の文
+func (k trainingKind) alarm(c sim.Compartment) string {
+	switch k {
+	case trainingFlooding:
+		return fmt.Sprintf("Flooding in the %s! Start the bilge pumps.", c)
+	case trainingJammedPlanes:
+		return fmt.Sprintf("Stern planes jammed on dive! Slow to %.0f rpm or less.", trainingPlanesRpm)
+	}
+	return fmt.Sprintf("Fire in the %s! Secure the ventilation fans.", c)
+}
+
+// 対処までの時間 (秒) の点数
+func trainingScore(elapsed float64) int {
+	if elapsed <= trainingQuick {
+		return 100
+	}
+	return int(math.Max(100*(trainingTimeLimit-elapsed)/(trainingTimeLimit-trainingQuick), 0))
+}
+
+type training struct {
+	events *eventLog
+	crew   *crewRoster
+	// 定期の訓練を始めてよいか (脅威がないか)
+	quiet func() bool
+	// 故障と区画を選ぶ乱数。ほかの乱数の系列を変えないよう、main の最後で渡す
+	rng *rand.Rand
+
+	mu     sync.Mutex
+	active bool
+	kind   trainingKind
+	// 模擬の火災と浸水の区画
+	compartment sim.Compartment
+	// 号令からの経過 (秒)
+	elapsed float64
+	// 次の定期の訓練 (シミュレーション時間)。まだ決めていなければ scheduled が false
+	due       time.Duration
+	scheduled bool
+	// これまでの訓練の数と点数の合計
+	held, total int
+}
+
+func newTraining(events *eventLog, crew *crewRoster, quiet func() bool) *training {
+	return &training{events: events, crew: crew, quiet: quiet}
+}
+
+// 号令をかける・訓練をやめる (orderTrainingDrill の処理)
+func (t *training) call(p *Player, start bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !start {
+		if t.active {
+			t.active = false
+			t.events.add(cell.ColorCyan, "[TRAINING] Secure from drill. Not scored.")
+		}
+		return nil
+	}
+	if t.active {
+		return orderRefusedError{"a drill is already under way"}
+	}
+	if !t.quiet() {
+		return orderRefusedError{"not while the boat is threatened"}
+	}
+	if !t.begin(p) {
+		return orderRefusedError{"every casualty is already being handled"}
+	}
+	return nil
+}
+
+// 対処の済んでいない故障を選んで訓練を始める。選べなければ false (t.mu を保持した状態で呼ぶ)
+func (t *training) begin(p *Player) bool {
+	var kinds []trainingKind
+	for k := trainingKind(0); k < trainingKindCount; k++ {
+		if !k.handled(p) {
+			kinds = append(kinds, k)
+		}
+	}
+	if len(kinds) == 0 {
+		return false
+	}
+	t.active, t.elapsed = true, 0
+	t.kind = kinds[t.rng.Intn(len(kinds))]
+	t.compartment = sim.Compartment(t.rng.Intn(int(sim.CompartmentCount)))
+	t.events.add(cell.ColorRed, "[TRAINING] DRILL, DRILL, DRILL. %s", t.kind.alarm(t.compartment))
+	return true
+}
+
+// 訓練を dt 秒分だけ進め、定期の訓練の時刻が来れば始める (ゲームループのタイマーから呼ぶ)
+func (t *training) step(p *Player, now time.Duration, dt float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.scheduled {
+		t.scheduleAfter(now)
+	}
+	if !t.active {
+		if trainingDrills && now >= t.due {
+			// 脅威があれば、なくなるまで待つ
+			if t.quiet() {
+				t.begin(p)
+				t.scheduleAfter(now)
+			}
+		}
+		return
+	}
+	t.elapsed += dt
+	switch {
+	case t.kind.handled(p):
+		t.score(trainingScore(t.elapsed))
+	case t.elapsed >= trainingTimeLimit:
+		t.score(0)
+	}
+}
+
+// 次の定期の訓練の時刻を決める (t.mu を保持した状態で呼ぶ)
+func (t *training) scheduleAfter(now time.Duration) {
+	t.due = now + trainingIntervalMin + time.Duration(t.rng.Int63n(int64(trainingIntervalMax-trainingIntervalMin)))
+	t.scheduled = true
+}
+
+// 訓練を終えて採点し、腕前を上げる (t.mu を保持した状態で呼ぶ)
+func (t *training) score(points int) {
+	t.active = false
+	t.held++
+	t.total += points
+	if points == 0 {
+		t.events.add(cell.ColorYellow, "[TRAINING] %s drill not handled in time. Score 0.", t.kind)
+		return
+	}
+	t.events.add(cell.ColorGreen, "[TRAINING] %s drill handled in %.0f s. Score %d.", t.kind, t.elapsed, points)
+	if points < trainingPassScore {
+		return
+	}
+	name, before, after := t.crew.train(t.kind.station(), trainingSkillGain*float64(points)/100)
+	if after > before {
+		t.events.add(cell.ColorGreen, "[CREW] %s (%s) skill %.1f -> %.1f.", name, strings.ToLower(t.kind.station().String()), before, after)
+	}
+}
+
+// Crew パネルの訓練の行。例: "Drills 3, average 78" / "DRILL: Fire 0:42"
+func (t *training) line() (string, cell.Color) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.active {
+		return fmt.Sprintf("DRILL: %s %s", t.kind, formatMissionTime(t.elapsed)), cell.ColorRed
+	}
+	if t.held == 0 {
+		return "Drills: none yet", cell.ColorDefault
+	}
+	return fmt.Sprintf("Drills %d, average %d", t.held, t.total/t.held), cell.ColorDefault
+}
+
+func (t *training) drilling() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.active
+}
+
+// 配置 s に就いている乗員の腕前を gain だけ上げる (trainingSkillCap まで)。乗員の名前と上げる前と後の腕前
+func (r *crewRoster) train(s station, gain float64) (string, float64, float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m := &r.members[r.assigned[s]]
+	before := m.skill
+	if m.skill < trainingSkillCap {
+		m.skill = math.Min(m.skill+gain, trainingSkillCap)
+	}
+	return m.name, before, m.skill
+}
+
+// 乗員ごとの腕前 (セーブデータ用)
+func (r *crewRoster) savedSkills() map[string]float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	skills := map[string]float64{}
+	for _, m := range r.members {
+		skills[m.name] = m.skill
+	}
+	return skills
+}
+
+// セーブデータから腕前を戻す。知らない名前と範囲外の値は使わない
+func (r *crewRoster) restoreSkills(skills map[string]float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.members {
+		if skill, ok := skills[r.members[i].name]; ok && skill >= 0 && skill <= 100 {
+			r.members[i].skill = skill
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 訓練の号令をかけると模擬の故障が起きる
+func TestTraining(t *testing.T) {
+	g := startGame(t)
+	g.advance(time.Second)
+	g.key("!")
+	g.advance(time.Second)
+	g.expect("[TRAINING] DRILL, DRILL, DRILL.")
+	// 訓練中にもう一度号令をかけると、採点せずにやめる
+	g.key("!")
+	g.advance(time.Second)
+	g.expect("[TRAINING] Secure from drill. Not scored.")
+}
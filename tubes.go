@@ -0,0 +1,484 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/mum4k/termdash/cell"
+)
+
+// 魚雷の発射前設定 (プリセット) と発射管
+//
+// 発射管ごとに、捜索パターン、捜索する深度の範囲 (上限・下限)、速力、誘導線が切れたときの
+// 動作を設定しておく。設定は Torpedo Presets パネルで変え、オートセーブに残る。
+//
+// 発射管は 装填 → 注水 → 前扉の開放 の順に準備してから撃つ。撃った後は前扉を閉めて排水し、空に戻る。
+// どの手順も時間がかかり、その間は次の手順に進めない。
+
+// 発射管の数
+const torpedoTubes = 4
+
+const (
+	// 積んでいる魚雷の数 (発射管の中のものも含む)
+	torpedoMagazine = 11
+	// 手順ごとにかかる時間 (秒)
+	tubeLoadTime  = 30.0
+	tubeFloodTime = 15.0
+	tubeOpenTime  = 5.0
+	tubeDrainTime = 20.0
+)
+
+// 発射管の状態
+type tubeState string
+
+const (
+	tubeEmpty    tubeState = "empty"
+	tubeLoading  tubeState = "loading"
+	tubeLoaded   tubeState = "loaded"
+	tubeFlooding tubeState = "flooding"
+	tubeFlooded  tubeState = "flooded"
+	tubeOpening  tubeState = "opening"
+	tubeOpen     tubeState = "open"
+	tubeDraining tubeState = "draining"
+)
+
+// 準備の手順。いまの状態から始める手順、その間の状態、終わった後の状態、かかる時間
+var tubeSteps = map[tubeState]struct {
+	during, after tubeState
+	seconds       float64
+}{
+	tubeEmpty:   {tubeLoading, tubeLoaded, tubeLoadTime},
+	tubeLoaded:  {tubeFlooding, tubeFlooded, tubeFloodTime},
+	tubeFlooded: {tubeOpening, tubeOpen, tubeOpenTime},
+}
+
+type tubeStatus struct {
+	state tubeState
+	// 手順が終わるまでの秒数と、終わった後の状態
+	remaining float64
+	after     tubeState
+}
+
+// 手順の途中か
+func (t *tubeStatus) busy() bool {
+	return t.remaining > 0
+}
+
+const (
+	// 深度の設定を変える単位 (m)
+	presetDepthStep = 10.0
+	// 上限と下限の間に最低限空ける深さ (m)
+	presetMinBand = 30.0
+	// 下限に設定できる最大深度 (m)
+	presetMaxFloor = 600.0
+)
+
+// 捜索パターン
+type searchPattern string
+
+const (
+	// 直進して最初に捉えたものを追う
+	patternStraight searchPattern = "straight"
+	// 左右に蛇行して幅広く探す
+	patternSnake searchPattern = "snake"
+	// 目標を見失ったら円を描いて探し直す
+	patternCircle searchPattern = "circle"
+)
+
+var searchPatterns = []searchPattern{patternStraight, patternSnake, patternCircle}
+
+// 速力の設定
+type speedSetting string
+
+const (
+	speedSlow   speedSetting = "slow"
+	speedMedium speedSetting = "medium"
+	speedFast   speedSetting = "fast"
+)
+
+var speedSettings = []speedSetting{speedSlow, speedMedium, speedFast}
+
+// 速力 (ノット) と航走距離 (km)
+// 速いほど燃料を食うので遠くまで届かない
+func (s speedSetting) performance() (knots, rangeKm float64) {
+	switch s {
+	case speedSlow:
+		return 28, 25
+	case speedFast:
+		return 55, 10
+	}
+	return 40, 18
+}
+
+// 誘導線が切れたときの動作
+type wireLossDoctrine string
+
+const (
+	// 最後の指示のまま自律で捜索を続ける
+	wireLossContinue wireLossDoctrine = "continue"
+	// すぐにシーカーを働かせて近くの目標に向かう
+	wireLossHome wireLossDoctrine = "home"
+	// 航走をやめて沈む (味方を撃たないため)
+	wireLossShutdown wireLossDoctrine = "shutdown"
+)
+
+var wireLossDoctrines = []wireLossDoctrine{wireLossContinue, wireLossHome, wireLossShutdown}
+
+type torpedoPreset struct {
+	Pattern  searchPattern    `json:"pattern"`
+	Ceiling  float64          `json:"ceiling"`
+	Floor    float64          `json:"floor"`
+	Speed    speedSetting     `json:"speed"`
+	WireLoss wireLossDoctrine `json:"wireLoss"`
+}
+
+func defaultTorpedoPreset() torpedoPreset {
+	return torpedoPreset{
+		Pattern:  patternStraight,
+		Ceiling:  20,
+		Floor:    300,
+		Speed:    speedMedium,
+		WireLoss: wireLossContinue,
+	}
+}
+
+// パネルで選んで変える項目
+type presetField int
+
+const (
+	fieldPattern presetField = iota
+	fieldCeiling
+	fieldFloor
+	fieldSpeed
+	fieldWireLoss
+	presetFieldCount
+)
+
+// n 個の選択肢の i 番目から dir だけ進めた位置 (一巡する)
+func cycleIndex(i, n, dir int) int {
+	return ((i+dir)%n + n) % n
+}
+
+func (p searchPattern) next(dir int) searchPattern {
+	for i, v := range searchPatterns {
+		if v == p {
+			return searchPatterns[cycleIndex(i, len(searchPatterns), dir)]
+		}
+	}
+	return searchPatterns[0]
+}
+
+func (s speedSetting) next(dir int) speedSetting {
+	for i, v := range speedSettings {
+		if v == s {
+			return speedSettings[cycleIndex(i, len(speedSettings), dir)]
+		}
+	}
+	return speedSettings[0]
+}
+
+func (d wireLossDoctrine) next(dir int) wireLossDoctrine {
+	for i, v := range wireLossDoctrines {
+		if v == d {
+			return wireLossDoctrines[cycleIndex(i, len(wireLossDoctrines), dir)]
+		}
+	}
+	return wireLossDoctrines[0]
+}
+
+// セーブデータの設定の書き間違いを挙げる
+func (pr torpedoPreset) problems(report func(format string, args ...interface{})) {
+	switch pr.Pattern {
+	case patternStraight, patternSnake, patternCircle:
+	default:
+		report("unknown pattern %q", pr.Pattern)
+	}
+	switch pr.Speed {
+	case speedSlow, speedMedium, speedFast:
+	default:
+		report("unknown speed %q", pr.Speed)
+	}
+	switch pr.WireLoss {
+	case wireLossContinue, wireLossHome, wireLossShutdown:
+	default:
+		report("unknown wire loss doctrine %q", pr.WireLoss)
+	}
+	if pr.Ceiling < 0 || pr.Floor > presetMaxFloor || pr.Floor-pr.Ceiling < presetMinBand {
+		report("depth band %.0f-%.0f m must lie within 0-%.0f m and be at least %.0f m deep", pr.Ceiling, pr.Floor, presetMaxFloor, presetMinBand)
+	}
+}
+
+// 知らない値を既定に戻し、深度の幅を設定できる範囲に収めた設定
+func (pr torpedoPreset) sanitized() torpedoPreset {
+	def := defaultTorpedoPreset()
+	// next(0) は知っている値ならその値を、知らない値なら一覧の先頭を返す
+	if pr.Pattern.next(0) != pr.Pattern {
+		pr.Pattern = def.Pattern
+	}
+	if pr.Speed.next(0) != pr.Speed {
+		pr.Speed = def.Speed
+	}
+	if pr.WireLoss.next(0) != pr.WireLoss {
+		pr.WireLoss = def.WireLoss
+	}
+	pr.Floor = math.Max(math.Min(pr.Floor, presetMaxFloor), presetMinBand)
+	pr.Ceiling = math.Max(math.Min(pr.Ceiling, pr.Floor-presetMinBand), 0)
+	return pr
+}
+
+// field を dir の向きに1段変える
+func (pr *torpedoPreset) adjust(field presetField, dir int) {
+	switch field {
+	case fieldPattern:
+		pr.Pattern = pr.Pattern.next(dir)
+	case fieldCeiling:
+		pr.Ceiling = math.Max(math.Min(pr.Ceiling+float64(dir)*presetDepthStep, pr.Floor-presetMinBand), 0)
+	case fieldFloor:
+		pr.Floor = math.Min(math.Max(pr.Floor+float64(dir)*presetDepthStep, pr.Ceiling+presetMinBand), presetMaxFloor)
+	case fieldSpeed:
+		pr.Speed = pr.Speed.next(dir)
+	case fieldWireLoss:
+		pr.WireLoss = pr.WireLoss.next(dir)
+	}
+}
+
+// 発射管室
+type torpedoRoom struct {
+	events *eventLog
+
+	mu     sync.Mutex
+	tubes  [torpedoTubes]torpedoPreset
+	status [torpedoTubes]tubeStatus
+	// 発射管の外に積んでいる魚雷の数
+	stowed int
+	// 試射場では魚雷が減らない
+	unlimited bool
+	tube      int
+	field     presetField
+}
+
+func newTorpedoRoom(events *eventLog) *torpedoRoom {
+	r := &torpedoRoom{events: events, stowed: torpedoMagazine}
+	for i := range r.tubes {
+		r.tubes[i] = defaultTorpedoPreset()
+		r.status[i].state = tubeEmpty
+	}
+	return r
+}
+
+// 選んでいる発射管 (0 から)
+func (r *torpedoRoom) selected() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.tube
+}
+
+// 発射管 tube (0 から) を次の手順に進める (orderPrepareTube の処理)
+func (r *torpedoRoom) prepare(tube int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if tube < 0 || tube >= torpedoTubes {
+		return orderRefusedError{fmt.Sprintf("no tube %d", tube+1)}
+	}
+	st := &r.status[tube]
+	if st.busy() {
+		return orderRefusedError{fmt.Sprintf("tube %d is %s", tube+1, st.state)}
+	}
+	step, ok := tubeSteps[st.state]
+	if !ok {
+		return orderRefusedError{fmt.Sprintf("tube %d is ready to fire", tube+1)}
+	}
+	if st.state == tubeEmpty && !r.unlimited {
+		if r.stowed == 0 {
+			return orderRefusedError{"no torpedoes left to load"}
+		}
+		r.stowed--
+	}
+	st.state, st.after, st.remaining = step.during, step.after, step.seconds
+	return nil
+}
+
+// 発射管 tube (0 から) から撃てるか。撃てなければその理由
+func (r *torpedoRoom) ready(tube int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.checkReady(tube)
+}
+
+// r.mu を保持した状態で呼ぶ
+func (r *torpedoRoom) checkReady(tube int) error {
+	if tube < 0 || tube >= torpedoTubes {
+		return orderRefusedError{fmt.Sprintf("no tube %d", tube+1)}
+	}
+	if st := r.status[tube]; st.state != tubeOpen {
+		return orderRefusedError{fmt.Sprintf("tube %d is %s, not ready to fire", tube+1, st.state)}
+	}
+	return nil
+}
+
+// 発射管 tube (0 から) の魚雷を撃つ。撃てたらその発射管の設定を返す
+func (r *torpedoRoom) fire(tube int) (torpedoPreset, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.checkReady(tube); err != nil {
+		return torpedoPreset{}, err
+	}
+	st := &r.status[tube]
+	st.state, st.after, st.remaining = tubeDraining, tubeEmpty, tubeDrainTime
+	return r.tubes[tube], nil
+}
+
+// dt 秒分だけ手順を進める (シミュレーション時間で進める)
+func (r *torpedoRoom) step(dt float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.status {
+		st := &r.status[i]
+		if !st.busy() {
+			continue
+		}
+		st.remaining -= dt
+		if st.remaining > 0 {
+			continue
+		}
+		st.remaining = 0
+		st.state = st.after
+		if st.state != tubeEmpty {
+			r.events.add(cell.ColorCyan, "[WEAPONS] Tube %d %s.", i+1, st.state)
+		}
+	}
+}
+
+// 何本撃っても魚雷が減らないようにする (試射場)
+func (r *torpedoRoom) setUnlimited() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.unlimited = true
+}
+
+// 積んでいる魚雷の数 (発射管の中のものも含む)
+func (r *torpedoRoom) aboard() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := r.stowed
+	for _, st := range r.status {
+		if st.state != tubeEmpty && st.state != tubeDraining {
+			n++
+		}
+	}
+	return n
+}
+
+// セーブデータから魚雷の数を戻す。発射管に入っていたものも棚に戻す
+func (r *torpedoRoom) restoreMagazine(aboard int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case aboard < 0:
+		aboard = 0
+	case aboard > torpedoMagazine:
+		aboard = torpedoMagazine
+	}
+	r.stowed = aboard
+	for i := range r.status {
+		r.status[i] = tubeStatus{state: tubeEmpty}
+	}
+}
+
+// 設定する発射管を次に移す
+func (r *torpedoRoom) nextTube() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tube = cycleIndex(r.tube, torpedoTubes, 1)
+}
+
+// 設定する項目を次に移す
+func (r *torpedoRoom) nextField() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.field = presetField(cycleIndex(int(r.field), int(presetFieldCount), 1))
+}
+
+// 選んでいる発射管の選んでいる項目を変える
+func (r *torpedoRoom) adjust(dir int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tubes[r.tube].adjust(r.field, dir)
+}
+
+// 発射管 tube (0 から) の設定
+func (r *torpedoRoom) preset(tube int) torpedoPreset {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.tubes[tube]
+}
+
+// 保存する設定
+func (r *torpedoRoom) saved() []torpedoPreset {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]torpedoPreset{}, r.tubes[:]...)
+}
+
+// セーブデータから設定を戻す
+func (r *torpedoRoom) restore(presets []torpedoPreset) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := 0; i < len(presets) && i < torpedoTubes; i++ {
+		r.tubes[i] = presets[i].sanitized()
+	}
+}
+
+// 発射管ごとの状態と設定の表示
+// 選んでいる発射管に > を付け、選んでいる項目を黄色にする
+func torpedoPresetPanel(r *torpedoRoom) panelText {
+	r.mu.Lock()
+	tubes, status, stowed, selectedTube, selectedField := r.tubes, r.status, r.stowed, r.tube, r.field
+	unlimited := r.unlimited
+	r.mu.Unlock()
+
+	var out panelText
+	magazine := fmt.Sprintf("Torpedoes stowed: %d\n", stowed)
+	if unlimited {
+		magazine = "Torpedoes stowed: unlimited (range)\n"
+	}
+	out.write(magazine, cell.ColorDefault)
+	for i, pr := range tubes {
+		knots, rangeKm := pr.Speed.performance()
+		cursor := " "
+		if i == selectedTube {
+			cursor = ">"
+		}
+		fields := []string{
+			fmt.Sprintf("%-8s", pr.Pattern),
+			fmt.Sprintf("ceil %3.0f", pr.Ceiling),
+			fmt.Sprintf("floor %3.0f", pr.Floor),
+			fmt.Sprintf("%-6s %2.0fkt/%2.0fkm", pr.Speed, knots, rangeKm),
+			fmt.Sprintf("wire %s", pr.WireLoss),
+		}
+		out.write(fmt.Sprintf("%s%d ", cursor, i+1), cell.ColorDefault)
+		for f, s := range fields {
+			color := cell.ColorDefault
+			if i == selectedTube && presetField(f) == selectedField {
+				color = cell.ColorYellow
+			}
+			out.write(s+" ", color)
+		}
+		st := status[i]
+		state := string(st.state)
+		if st.busy() {
+			state = fmt.Sprintf("%s %2.0fs", st.state, math.Ceil(st.remaining))
+		}
+		stateColor := cell.ColorDefault
+		switch {
+		case st.state == tubeOpen:
+			stateColor = cell.ColorRed
+		case st.busy():
+			stateColor = cell.ColorCyan
+		}
+		out.write(state, stateColor)
+		out.write("\n", cell.ColorDefault)
+	}
+	return out
+}
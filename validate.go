@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs0604/explorergame/missions"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// validate-scenario サブコマンド
+//
+// シナリオ、任務、オートセーブ (海図の書き込み)、戦歴 (campaign.json)、測量結果 (survey.json)、攻撃の記録 (attacks.json)、日誌 (journal.json) を読み込み、
+// 書き間違いを見つかっただけ報告する。遊んでいる途中で止まる前に作者が気付けるようにするためのもの。
+//
+//	explorergame validate-scenario scenarios/rendezvous.json
+
+// ファイルの種類ごとの検査
+// problems は書き間違い、err は読み込めなかったことを表す
+func validateFile(path string) (summary string, problems []string, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	return validateData(path, data)
+}
+
+// 読み込んだ data の種類を、path の拡張子と中身から決めて検査する
+func validateData(path string, data []byte) (summary string, problems []string, err error) {
+	trimmed := bytes.TrimSpace(data)
+	if ext := strings.ToLower(filepath.Ext(path)); (ext == ".yaml" || ext == ".yml") && !json.Valid(trimmed) {
+		return "", nil, fmt.Errorf("YAML is not supported; write the file as JSON")
+	}
+	if bytes.HasPrefix(trimmed, []byte("[")) {
+		return validateCampaign(trimmed)
+	}
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(trimmed, &top); err != nil {
+		return "", nil, err
+	}
+	if _, ok := top["player"]; ok {
+		return validateSave(trimmed)
+	}
+	if _, ok := top["soundings"]; ok {
+		return validateSurvey(trimmed)
+	}
+	if _, ok := top["attacks"]; ok {
+		return validateAttacks(trimmed)
+	}
+	if _, ok := top["ports"]; ok {
+		return validatePorts(trimmed)
+	}
+	if _, ok := top["documents"]; ok {
+		return validateJournal(trimmed)
+	}
+	if _, ok := top["mission"]; ok {
+		return validateMission(trimmed)
+	}
+	return validateScenarioFile(trimmed)
+}
+
+func validateScenarioFile(data []byte) (string, []string, error) {
+	var cfg scenarioConfig
+	// キーの綴りの間違いもここで見つける
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return "", nil, err
+	}
+	summary := fmt.Sprintf("scenario %q: %d objectives, %d triggers, %d zones, %d harbors, %d inspections", cfg.Name, len(cfg.Objectives), len(cfg.Triggers), len(cfg.Zones), len(cfg.Harbors), len(cfg.Inspections))
+	return summary, cfg.problems(), nil
+}
+
+func validateMission(data []byte) (string, []string, error) {
+	var m missions.Mission
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&m); err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf("mission %q: %d objectives", m.Name, len(m.Objectives)), m.Problems(mapExtent), nil
+}
+
+func validateSave(data []byte) (string, []string, error) {
+	var s saveData
+	if err := json.Unmarshal(data, &s); err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf("autosave of %s: %d chart marks", s.SavedAt.Format("2006-01-02 15:04:05"), len(s.Chart)), s.problems(), nil
+}
+
+func validateCampaign(data []byte) (string, []string, error) {
+	var list []campaignOutcome
+	if err := json.Unmarshal(data, &list); err != nil {
+		return "", nil, err
+	}
+	var problems []string
+	for i, o := range list {
+		switch o.Outcome {
+		case outcomeRescued, outcomeLost:
+		case outcomeHostileSunk, outcomeNeutralSunk, outcomeFriendlySunk:
+			if o.Target == "" {
+				problems = append(problems, fmt.Sprintf("entry %d: missing target", i+1))
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("entry %d: unknown outcome %q", i+1, o.Outcome))
+		}
+		if o.Odds < 0 || o.Odds > 1 {
+			problems = append(problems, fmt.Sprintf("entry %d: odds %v are outside 0-1", i+1, o.Odds))
+		}
+		if o.At.IsZero() {
+			problems = append(problems, fmt.Sprintf("entry %d: missing time", i+1))
+		}
+	}
+	return fmt.Sprintf("campaign log: %d entries", len(list)), problems, nil
+}
+
+func validateSurvey(data []byte) (string, []string, error) {
+	var f surveyFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return "", nil, err
+	}
+	var problems []string
+	if f.TerrainSeed == 0 {
+		problems = append(problems, "missing terrainSeed")
+	}
+	seen := map[surveyCell]bool{}
+	for i, c := range f.Soundings {
+		cell := surveyCell{X: c.X, Y: c.Y}
+		if seen[cell] {
+			problems = append(problems, fmt.Sprintf("sounding %d: cell (%d, %d) appears twice", i+1, c.X, c.Y))
+		}
+		seen[cell] = true
+		if c.Depth <= 0 {
+			problems = append(problems, fmt.Sprintf("sounding %d: depth %v is not positive", i+1, c.Depth))
+		}
+		if _, err := sim.ParseBottomType(c.Bottom); c.Bottom != "" && err != nil {
+			problems = append(problems, fmt.Sprintf("sounding %d: %v", i+1, err))
+		}
+	}
+	return fmt.Sprintf("survey of terrain %d: %d soundings", f.TerrainSeed, len(f.Soundings)), problems, nil
+}
+
+func validateAttacks(data []byte) (string, []string, error) {
+	var f attacksFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return "", nil, err
+	}
+	var problems []string
+	for i, a := range f.Attacks {
+		if a.At.IsZero() {
+			problems = append(problems, fmt.Sprintf("attack %d: missing time", i+1))
+		}
+		if a.Target == "" {
+			problems = append(problems, fmt.Sprintf("attack %d: missing target", i+1))
+		}
+	}
+	return fmt.Sprintf("attack log: %d attacks", len(f.Attacks)), problems, nil
+}
+
+func validatePorts(data []byte) (string, []string, error) {
+	var f portsFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return "", nil, err
+	}
+	var problems []string
+	for i, r := range f.Ports {
+		port, ok := findSupplyPort(r.Name)
+		if !ok {
+			problems = append(problems, fmt.Sprintf("port %d: unknown port %q", i+1, r.Name))
+			continue
+		}
+		if r.portStock != r.portStock.limit(port.capacity) {
+			problems = append(problems, fmt.Sprintf("port %s: stock %s is outside 0-%s", r.Name, r.portStock, port.capacity))
+		}
+	}
+	return fmt.Sprintf("supply ports: %d ports", len(f.Ports)), problems, nil
+}
+
+func validateJournal(data []byte) (string, []string, error) {
+	var f journalFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return "", nil, err
+	}
+	var problems []string
+	for i, e := range f.Documents {
+		if e.Document != i+1 {
+			problems = append(problems, fmt.Sprintf("entry %d: document %d is out of order", i+1, e.Document))
+		}
+		if e.Document < 1 || e.Document > len(storyDocuments) {
+			problems = append(problems, fmt.Sprintf("entry %d: no document %d (1-%d)", i+1, e.Document, len(storyDocuments)))
+		}
+		if e.At.IsZero() {
+			problems = append(problems, fmt.Sprintf("entry %d: missing time", i+1))
+		}
+	}
+	return fmt.Sprintf("journal: %d of %d documents", len(f.Documents), len(storyDocuments)), problems, nil
+}
+
+// 終了コードを返す (0: 問題なし, 1: 書き間違いあり, 2: 読み込めない)
+func runValidate(paths []string, out io.Writer) int {
+	if len(paths) == 0 {
+		fmt.Fprintln(out, "usage: explorergame validate-scenario FILE...")
+		return 2
+	}
+	code := 0
+	for _, path := range paths {
+		summary, problems, err := validateFile(path)
+		if err != nil {
+			fmt.Fprintf(out, "%s: %v\n", path, err)
+			code = 2
+			continue
+		}
+		if len(problems) == 0 {
+			fmt.Fprintf(out, "%s: ok (%s)\n", path, summary)
+			continue
+		}
+		for _, p := range problems {
+			fmt.Fprintf(out, "%s: %s\n", path, p)
+		}
+		if code == 0 {
+			code = 1
+		}
+	}
+	return code
+}
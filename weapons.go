@@ -0,0 +1,349 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 自艦の魚雷
+//
+// 航跡一覧で選んだ航跡に向けて発射管から撃ち、設定の速力で直進させる。TMA の解があれば目標の
+// 未来位置 (会合点) へ、距離だけわかれば推定位置へ、方位しかわからなければその方位へ撃つ。
+// 航跡を選んでいなければ艦首方向へ撃つ。撃つ前に交戦規則を確かめる (roe.go)。深度は設定の上限 (ceiling) まで変え、
+// そのまま航走距離を走り切るか、船の近くを通れば命中して沈める。
+// 捜索パターンと誘導線が切れたときの動作の設定はまだ使わない (直進のみ)。
+// 走っている間は航走の記録を取り、試射場 (range.go) ではそれを撃つたびに出す。
+//
+// 魚雷には安全装置がある (config.toml の torpedo_interlocks)。
+//   - 起爆の安全距離: torpedoArmingRun だけ走り、設定の深度の上限と下限の間に入るまで起爆しない。それまでに船に当たっても沈めない
+//   - 深度の帯: 海底から torpedoBottomClearance を残して上限の深度を保てない浅い海では撃たず、走っている途中でそうなれば止める
+//   - 円走の防止: ジャイロの故障 (torpedoGyroFaultChance) で回りはじめ、発射したときの針路から torpedoCircularTurn より
+//     向きが変わったら警報を出して止める
+//
+// 安全装置を外すと撃ってすぐに起爆でき、浅い海でも撃てるが、海底に突っ込んだり、円走して戻ってきた魚雷が自艦に当たったりする。
+
+// 安全装置を使うか。main で config.toml から決める
+var torpedoInterlocks = true
+
+const (
+	// 起爆するまでに走る距離 (m)
+	torpedoArmingRun = 500.0
+	// 深度の帯の上限から海底までに残す深さ (m)
+	torpedoBottomClearance = 10.0
+	// 1本ごとのジャイロの故障の確率
+	torpedoGyroFaultChance = 0.02
+	// ジャイロが故障した魚雷の回る速さ (度/秒)
+	torpedoGyroFaultTurn = 3.0
+	// 発射したときの針路からこれより向きが変われば円走とみなす (度)
+	torpedoCircularTurn = 135.0
+)
+
+type ownTorpedo struct {
+	torpedo
+	tube int
+	// 発射したときの針路と、設定の深度の上限・下限 (m)
+	launchCourse   float64
+	ceiling, floor float64
+	// 起爆できるか
+	armed bool
+	// ジャイロの故障で回り続ける速さ (度/秒)。故障していなければ 0
+	gyroFault float64
+	// 航走の記録 (試射場で使う)
+	shot torpedoShot
+}
+
+// 1本の魚雷の航走の記録
+type torpedoShot struct {
+	tube  int
+	aimed string
+	// 速力 (ノット)、航走距離と走った距離 (m)
+	speed    float64
+	length   float64
+	distance float64
+	// 発射した位置から torpedoPathSpacing ごとの位置
+	path []sim.Point3D
+	// シーカーが初めて船を捉えた位置と、その船の名前・距離 (捉えなければ nil)
+	acquired      *sim.Point3D
+	acquiredName  string
+	acquiredRange float64
+	// 最も近づいた船の名前と距離 (m)
+	closestName string
+	closest     float64
+	hit         bool
+}
+
+// 航走の記録に位置を残す間隔 (m)
+const torpedoPathSpacing = 1000.0
+
+// 魚雷 t の今の位置で記録を更新する
+func (s *torpedoShot) observe(t *torpedo, ships []vessel) {
+	s.distance = s.length - math.Max(t.run, 0)
+	if s.distance >= float64(len(s.path))*torpedoPathSpacing {
+		s.path = append(s.path, t.position)
+	}
+	for _, v := range ships {
+		r := distance3D(t.position, v.hull())
+		if r < s.closest {
+			s.closest, s.closestName = r, v.name
+		}
+		off := math.Abs(sim.NormalizeRelative(sim.BearingTo(t.position, v.hull()) - t.course))
+		if s.acquired == nil && r <= torpedoSeekerRange && off <= torpedoSeekerCone {
+			pos := t.position
+			s.acquired, s.acquiredName, s.acquiredRange = &pos, v.name, r
+		}
+	}
+}
+
+type fireControl struct {
+	events  *eventLog
+	traffic *traffic
+	room    *torpedoRoom
+	tracks  *trackManager
+	attacks *attackHistory
+	roe     *rulesOfEngagement
+	// ジャイロの故障
+	rng *rand.Rand
+
+	// 試射場では撃つたびに航走の記録を渡す。nil でなければ交戦規則を問わず、沈めても戦歴や攻撃の記録に残さない
+	practice func(torpedoShot)
+
+	mu   sync.Mutex
+	fish []*ownTorpedo
+}
+
+func newFireControl(events *eventLog, tr *traffic, room *torpedoRoom, tm *trackManager, attacks *attackHistory, roe *rulesOfEngagement) *fireControl {
+	return &fireControl{events: events, traffic: tr, room: room, tracks: tm, attacks: attacks, roe: roe}
+}
+
+// 発射管 tube (0 から) の魚雷を撃つ (orderLaunchTorpedo の処理)
+func (fc *fireControl) launch(p *Player, tube int) error {
+	if err := fc.room.ready(tube); err != nil {
+		return err
+	}
+	tr, selected := fc.tracks.selectedTrack()
+	aimed := &tr
+	if !selected {
+		aimed = nil
+	}
+	if fc.practice == nil {
+		if err := fc.roe.clearance(aimed); err != nil {
+			return err
+		}
+	}
+	// 安全装置は深度の帯を保てない浅い海では撃たせない
+	if seabed, _ := terrain.SeabedAt(p.Position.X, p.Position.Y); torpedoInterlocks && seabed-torpedoBottomClearance < fc.room.preset(tube).Ceiling {
+		return orderRefusedError{"water too shallow for the depth band"}
+	}
+	preset, err := fc.room.fire(tube)
+	if err != nil {
+		return err
+	}
+	knots, rangeKm := preset.Speed.performance()
+	run := rangeKm * 1000
+	bearing, target := p.Direction, "bow"
+	if selected {
+		bearing, target = firingBearing(p.Position, &tr, knots), tr.designation()
+	}
+	rad := bearing * math.Pi / 180
+	// 直進させるため、射線の先の航走距離の位置を設定の深度で目指させる
+	aim := sim.Point3D{
+		X: p.Position.X + math.Sin(rad)*run,
+		Y: p.Position.Y + math.Cos(rad)*run,
+		Z: -preset.Ceiling,
+	}
+	gyroFault := 0.0
+	if fc.rng.Float64() < torpedoGyroFaultChance {
+		gyroFault = torpedoGyroFaultTurn
+		if fc.rng.Intn(2) == 0 {
+			gyroFault = -gyroFault
+		}
+	}
+
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.fish = append(fc.fish, &ownTorpedo{
+		torpedo: torpedo{
+			position: p.Position,
+			course:   bearing,
+			speed:    knots,
+			run:      run,
+			target:   &aim,
+		},
+		tube:         tube,
+		launchCourse: bearing,
+		ceiling:      preset.Ceiling,
+		floor:        preset.Floor,
+		armed:        !torpedoInterlocks,
+		gyroFault:    gyroFault,
+		shot: torpedoShot{
+			tube:    tube,
+			aimed:   target,
+			speed:   knots,
+			length:  run,
+			path:    []sim.Point3D{p.Position},
+			closest: math.Inf(1),
+		},
+	})
+	fc.events.add(cell.ColorYellow, "[WEAPONS] Tube %d fired at %s. Bearing %03.0f, %.0f kt, depth %.0f m.", tube+1, target, bearing, knots, preset.Ceiling)
+	return nil
+}
+
+// dt 秒分だけ魚雷を進め、安全装置と命中を調べる (シミュレーション時間で進める)
+func (fc *fireControl) step(p *Player, dt float64) {
+	ships := fc.traffic.vessels()
+
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	remaining := fc.fish[:0]
+	for _, t := range fc.fish {
+		if t.gyroFault != 0 {
+			t.circle(dt)
+		}
+		t.advance(dt)
+		t.shot.observe(&t.torpedo, ships)
+		if fc.safety(p, t) {
+			continue
+		}
+		if s, ok := torpedoHit(&t.torpedo, ships); ok {
+			if !t.armed {
+				fc.events.add(cell.ColorYellow, "[WEAPONS] Tube %d torpedo struck %s (%s) before arming. No detonation.", t.tube+1, s.name, s.class)
+				if fc.practice != nil {
+					fc.report(t)
+				}
+				continue
+			}
+			fc.traffic.sink(s.id)
+			fc.events.add(cell.ColorGreen, "[WEAPONS] Tube %d torpedo hit %s (%s)! Target sinking.", t.tube+1, s.name, s.class)
+			if fc.practice != nil {
+				t.shot.hit = true
+				fc.report(t)
+				continue
+			}
+			fc.roe.sunk(s)
+			// 商船はこれからこの海域を避ける
+			fc.attacks.record(s)
+			fc.traffic.reroute(fc.attacks.zones())
+			continue
+		}
+		if t.run <= 0 {
+			fc.events.add(cell.ColorYellow, "[WEAPONS] Tube %d torpedo ran out without a hit.", t.tube+1)
+			if fc.practice != nil {
+				fc.report(t)
+			}
+			continue
+		}
+		remaining = append(remaining, t)
+	}
+	fc.fish = remaining
+}
+
+// ジャイロが故障した魚雷を dt 秒分だけ回す。深度は上限に保つ
+func (t *ownTorpedo) circle(dt float64) {
+	t.course = sim.NormalizeBearing(t.course + t.gyroFault*dt)
+	rad := t.course * math.Pi / 180
+	ahead := sim.Point3D{X: t.position.X + math.Sin(rad)*1000, Y: t.position.Y + math.Cos(rad)*1000, Z: -t.ceiling}
+	t.target = &ahead
+}
+
+// 安全装置と海底を調べ、起爆できるようになったら安全装置を外す。魚雷がなくなったら true を返す (fc.mu を保持した状態で呼ぶ)
+// 安全装置を外していれば、円走して戻ってきた魚雷が自艦に当たる
+func (fc *fireControl) safety(p *Player, t *ownTorpedo) bool {
+	depth := -t.position.Z
+	seabed, _ := terrain.SeabedAt(t.position.X, t.position.Y)
+	turned := math.Abs(sim.NormalizeRelative(t.course - t.launchCourse))
+	switch {
+	case torpedoInterlocks && turned > torpedoCircularTurn:
+		fc.events.add(cell.ColorRed, "[ALARM] Circular run on tube %d torpedo! Torpedo shut down.", t.tube+1)
+	case torpedoInterlocks && seabed-torpedoBottomClearance < t.ceiling:
+		fc.events.add(cell.ColorYellow, "[WEAPONS] Tube %d torpedo shut down: water too shallow (%.0f m) for its depth band.", t.tube+1, seabed)
+	case depth >= seabed:
+		fc.events.add(cell.ColorYellow, "[WEAPONS] Tube %d torpedo ran into the seabed.", t.tube+1)
+	case t.armed && turned > 90 && distance3D(t.position, p.Position) < torpedoHitRange:
+		hit := sim.CompartmentAt(sim.BearingTo(p.Position, t.position) - p.Direction)
+		p.TakeDamage(hit, torpedoDamage)
+		fc.events.add(cell.ColorRed, "[ALARM] Own torpedo hit in the %s! Hull integrity %.0f%%", hit, p.HullIntegrity)
+	default:
+		if !t.armed && t.shot.length-t.run >= torpedoArmingRun && depth >= t.ceiling && depth <= t.floor {
+			t.armed = true
+			fc.events.add(cell.ColorCyan, "[WEAPONS] Tube %d torpedo armed.", t.tube+1)
+		}
+		return false
+	}
+	if fc.practice != nil {
+		fc.report(t)
+	}
+	return true
+}
+
+// 航走の記録を試射場に渡す (fc.mu を保持した状態で呼ぶ)
+func (fc *fireControl) report(t *ownTorpedo) {
+	if last := t.shot.path[len(t.shot.path)-1]; last != t.position {
+		t.shot.path = append(t.shot.path, t.position)
+	}
+	fc.practice(t.shot)
+}
+
+// 航跡 tr に速力 torpedoKnots (kt) の魚雷を撃つ方位
+func firingBearing(own sim.Point3D, tr *track, torpedoKnots float64) float64 {
+	if math.IsNaN(tr.rng) {
+		return tr.bearing
+	}
+	course, speed, ok := tr.solution()
+	if !ok {
+		return sim.BearingTo(own, tr.estimate)
+	}
+	return interceptBearing(own, tr.estimate, course, speed, torpedoKnots*knot)
+}
+
+// 針路 course (度)・速力 speed (m/s) で進む目標に、速さ torpedoSpeed (m/s) で直進して会う方位
+// 目標の位置を P、速度を V、会うまでの時間を t とすると |P + Vt| = st から
+// (V·V - s²)t² + 2P·V t + P·P = 0 の正の最小の解を使う。追いつけなければ目標の今の方位
+func interceptBearing(own, target sim.Point3D, course, speed, torpedoSpeed float64) float64 {
+	px, py := target.X-own.X, target.Y-own.Y
+	rad := course * math.Pi / 180
+	vx, vy := math.Sin(rad)*speed, math.Cos(rad)*speed
+	a := vx*vx + vy*vy - torpedoSpeed*torpedoSpeed
+	b := 2 * (px*vx + py*vy)
+	c := px*px + py*py
+
+	t := -1.0
+	if math.Abs(a) < 1e-9 {
+		if b < 0 {
+			t = -c / b
+		}
+	} else if d := b*b - 4*a*c; d >= 0 {
+		for _, r := range []float64{(-b - math.Sqrt(d)) / (2 * a), (-b + math.Sqrt(d)) / (2 * a)} {
+			if r > 0 && (t < 0 || r < t) {
+				t = r
+			}
+		}
+	}
+	if t < 0 {
+		return sim.BearingTo(own, target)
+	}
+	return sim.BearingTo(sim.Point3D{}, sim.Point3D{X: px + vx*t, Y: py + vy*t})
+}
+
+// 魚雷の近くにいる船
+func torpedoHit(t *torpedo, ships []vessel) (vessel, bool) {
+	for _, s := range ships {
+		if distance3D(t.position, s.hull()) < torpedoHitRange {
+			return s, true
+		}
+	}
+	return vessel{}, false
+}
+
+// 走っている魚雷の位置 (デブリーフィングの記録)
+func (fc *fireControl) weapons() (torpedoes, decoys []sim.Point3D) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	for _, t := range fc.fish {
+		torpedoes = append(torpedoes, t.position)
+	}
+	return torpedoes, nil
+}
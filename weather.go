@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+)
+
+// 天候と海況
+//
+// 海況は平穏 (0) から暴風 (9) まで、ゲームの時間 (シミュレーション時間) で少しずつ移り変わる。
+// weatherSpellMin〜weatherSpellMax ごとに次に向かう海況を決め、1 時間に weatherRate ずつ近づく。
+// 海況が 1 つ変わるたびにイベントログに出し、海洋環境 (propagation.go) の seaState を変える。
+//   - ソーナー: 背景雑音と海面近くの伝搬損失が海況で増える (propagation.go)
+//   - 浮上航行: 海況 weatherRoughSeas 以上では、浮上中に出せる速力が波で落ちる (surfacedSpeedLimit)
+//   - 潜望鏡: 波しぶきと雨で見える距離が縮む (sightingRange)。敵の見張りが自艦を見つける距離も同じだけ縮む
+//
+// シナリオの weather で海況を変えたときは、その海況から weatherSpellMin のあいだは変わらない。
+
+const (
+	// 次に向かう海況を決め直す間隔
+	weatherSpellMin = 45 * time.Minute
+	weatherSpellMax = 3 * time.Hour
+	// 海況が変わる速さ (1 時間あたり)
+	weatherRate = 1.5
+	// 向かう海況の平均とばらつき
+	weatherMeanSeas   = 3.0
+	weatherSeasSpread = 2.0
+	// 浮上中の速力が落ちはじめる海況
+	weatherRoughSeas = 4
+)
+
+// 海況ごとの呼び名
+var weatherNames = [...]string{"Calm", "Calm", "Smooth", "Slight", "Moderate", "Rough", "Very rough", "Gale", "Storm", "Storm"}
+
+// 海況 weatherRoughSeas からの、浮上して出せる速力 (ノット)
+var surfacedSpeeds = []float64{20, 15, 11, 8, 6, 4}
+
+// 海況ごとの見える距離の割合
+var weatherVisibility = [...]float64{1, 1, 1, 0.9, 0.8, 0.65, 0.5, 0.35, 0.25, 0.15}
+
+// 浮上して出せる速力 (ノット)。穏やかなら制限しない
+func surfacedSpeedLimit(seaState int) float64 {
+	if seaState < weatherRoughSeas {
+		return math.Inf(1)
+	}
+	return surfacedSpeeds[seaState-weatherRoughSeas]
+}
+
+// 潜望鏡や見張りで船が見える距離 (m)
+func (e *environment) sightingRange() float64 {
+	return visualRange * weatherVisibility[e.seaState]
+}
+
+type weather struct {
+	events *eventLog
+	env    *environment
+	// 向かう海況を決める乱数。ほかの乱数の系列を変えないよう、main の最後で渡す
+	rng *rand.Rand
+
+	mu sync.Mutex
+	// 今の海況 (連続な値) と、向かっている海況
+	level, target float64
+	// 次に向かう海況を決める時刻 (シミュレーション時間)
+	due time.Duration
+}
+
+func newWeather(events *eventLog, env *environment) *weather {
+	level := float64(env.seaState)
+	return &weather{events: events, env: env, level: level, target: level}
+}
+
+// 海況を dt 秒分だけ進める (ゲームループのタイマーから呼ぶ)
+func (w *weather) step(now time.Duration, dt float64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// シナリオが海況を変えていれば、そこからしばらく保つ
+	if w.env.seaState != int(math.Round(w.level)) {
+		w.level = float64(w.env.seaState)
+		w.target, w.due = w.level, now+weatherSpellMin
+		return
+	}
+	if now >= w.due {
+		w.target = math.Max(math.Min(math.Round(weatherMeanSeas+w.rng.NormFloat64()*weatherSeasSpread), 9), 0)
+		w.due = now + weatherSpellMin + time.Duration(w.rng.Int63n(int64(weatherSpellMax-weatherSpellMin)))
+	}
+	change := weatherRate * dt / 3600
+	if w.level < w.target {
+		w.level = math.Min(w.level+change, w.target)
+	} else {
+		w.level = math.Max(w.level-change, w.target)
+	}
+	state := int(math.Round(w.level))
+	if state == w.env.seaState {
+		return
+	}
+	color := cell.ColorCyan
+	if state >= weatherRoughSeas {
+		color = cell.ColorYellow
+	}
+	w.env.seaState = state
+	w.events.add(color, "[WEATHER] Sea state %d (%s), %s.", state, weatherNames[state], w.trend())
+}
+
+// 海況が上がっているか下がっているか (w.mu を保持した状態で呼ぶ)
+func (w *weather) trend() string {
+	switch {
+	case w.target > w.level:
+		return "rising"
+	case w.target < w.level:
+		return "easing"
+	}
+	return "steady"
+}
+
+// 浮上して出せる速力 (ノット)。sim.World.WaveSpeedLimit に渡す
+func (w *weather) speedLimit() float64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return surfacedSpeedLimit(int(math.Round(w.level)))
+}
+
+// 艦の状態のパネルの天候の行
+// 例: "Weather: Rough, sea state 5 rising  Visibility 6.5 km"
+func (w *weather) line() (string, cell.Color) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	state := int(math.Round(w.level))
+	color := cell.ColorGreen
+	switch {
+	case state >= weatherRoughSeas+3:
+		color = cell.ColorRed
+	case state >= weatherRoughSeas:
+		color = cell.ColorYellow
+	}
+	line := fmt.Sprintf("Weather: %s, sea state %d %s  Visibility %.1f km", weatherNames[state], state, w.trend(), visualRange*weatherVisibility[state]/1000)
+	if limit := surfacedSpeedLimit(state); !math.IsInf(limit, 1) {
+		line += fmt.Sprintf("  Surfaced max %.0f kt", limit)
+	}
+	return line, color
+}
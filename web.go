@@ -0,0 +1,528 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mum4k/termdash/keyboard"
+	"github.com/mum4k/termdash/terminal/terminalapi"
+)
+
+// ヘッドレスで動かすときの画面サイズ
+var headlessSize = image.Point{X: 160, Y: 48}
+
+// クライアントへ送るフレーム
+type wsFrame struct {
+	opcode  byte
+	payload []byte
+}
+
+// ブラウザ1枚分の接続
+type mirrorClient struct {
+	conn    net.Conn
+	out     chan wsFrame
+	station string
+}
+
+// サーバーからブラウザへのテキストフレーム
+type wsServerMessage struct {
+	Type     string `json:"type"`
+	Protocol int    `json:"protocol,omitempty"`
+	Station  string `json:"station,omitempty"`
+	Cols     int    `json:"cols,omitempty"`
+	Rows     int    `json:"rows,omitempty"`
+	Message  string `json:"message,omitempty"`
+	Seq      int    `json:"seq,omitempty"`
+}
+
+// ブラウザからサーバーへのフレーム
+//
+//	{"type": "input", "data": "...", "seq": 7}  キー入力 (xterm.js の onData)。seq があれば {"type": "ack", "seq": 7} を返す
+//	{"type": "resync"}                          画面全体を送り直してもらう
+type wsClientMessage struct {
+	Type string `json:"type"`
+	Data string `json:"data"`
+	Seq  int    `json:"seq,omitempty"`
+}
+
+// 描画内容をブラウザへ複製する端末
+// base が nil の場合はネイティブ端末を持たず、ブラウザだけが画面になる
+type mirrorTerminal struct {
+	bufferedTerminal
+
+	clients  map[*mirrorClient]bool
+	sessions *stationSessions
+
+	input    chan terminalapi.Event
+	pumpOnce sync.Once
+}
+
+func newMirrorTerminal(base terminalapi.Terminal) *mirrorTerminal {
+	return &mirrorTerminal{
+		bufferedTerminal: newBufferedTerminal(base, headlessSize),
+		clients:          map[*mirrorClient]bool{},
+		// ネイティブ端末がなければ、見ている配置がいなくなったときにシミュレーションを止める
+		sessions: newStationSessions(base == nil),
+		input:    make(chan terminalapi.Event, 64),
+	}
+}
+
+func (m *mirrorTerminal) Flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	diff, resized, err := m.flushDiff()
+	if err != nil {
+		return err
+	}
+	if resized {
+		m.broadcastSize()
+	}
+	if len(diff) > 0 {
+		m.broadcast(diff)
+	}
+	return nil
+}
+
+// ネイティブ端末とブラウザ両方からの入力をまとめて返す
+func (m *mirrorTerminal) Event(ctx context.Context) terminalapi.Event {
+	if m.base != nil {
+		m.pumpOnce.Do(func() { go m.pumpBaseEvents(ctx) })
+	}
+	select {
+	case ev := <-m.input:
+		return ev
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+func (m *mirrorTerminal) pumpBaseEvents(ctx context.Context) {
+	for {
+		ev := m.base.Event(ctx)
+		if ev == nil {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		select {
+		case m.input <- ev:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (m *mirrorTerminal) Close() {
+	m.mu.Lock()
+	for c := range m.clients {
+		m.dropClient(c)
+	}
+	m.mu.Unlock()
+	m.bufferedTerminal.Close()
+}
+
+// 全クライアントへ端末出力を送る (m.mu を保持した状態で呼ぶ)
+// 送信が追いつかないクライアントは切断する
+func (m *mirrorTerminal) broadcast(data []byte) {
+	for c := range m.clients {
+		select {
+		case c.out <- wsFrame{wsOpBinary, data}:
+		default:
+			m.dropClient(c)
+		}
+	}
+}
+
+// 画面サイズをテキストフレームで通知する (m.mu を保持した状態で呼ぶ)
+func (m *mirrorTerminal) broadcastSize() {
+	for c := range m.clients {
+		m.sendSize(c)
+	}
+}
+
+func (m *mirrorTerminal) sendSize(c *mirrorClient) {
+	sendMessage(c, wsServerMessage{Type: "size", Cols: m.screen.size.X, Rows: m.screen.size.Y})
+}
+
+func sendMessage(c *mirrorClient, msg wsServerMessage) {
+	data, _ := json.Marshal(msg)
+	select {
+	case c.out <- wsFrame{wsOpText, data}:
+	default:
+	}
+}
+
+// 画面全体を送り直す (m.mu を保持した状態で呼ぶ)
+func (m *mirrorTerminal) resync(c *mirrorClient) {
+	if m.sent == nil {
+		return
+	}
+	select {
+	case c.out <- wsFrame{wsOpBinary, m.sent.ansiDiff(nil)}:
+	default:
+	}
+}
+
+func (m *mirrorTerminal) addClient(conn net.Conn, station string) *mirrorClient {
+	c := &mirrorClient{conn: conn, out: make(chan wsFrame, 256), station: station}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clients[c] = true
+	sendMessage(c, wsServerMessage{Type: "hello", Protocol: wsProtocolVersion, Station: station, Cols: m.screen.size.X, Rows: m.screen.size.Y})
+	m.resync(c)
+	return c
+}
+
+func (m *mirrorTerminal) removeClient(c *mirrorClient) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dropClient(c)
+}
+
+// クライアントを切断する (m.mu を保持した状態で呼ぶ)
+func (m *mirrorTerminal) dropClient(c *mirrorClient) {
+	if !m.clients[c] {
+		return
+	}
+	delete(m.clients, c)
+	c.conn.Close()
+	close(c.out)
+}
+
+// 接続中のクライアントにだけフレームを送る
+func (m *mirrorTerminal) send(c *mirrorClient, f wsFrame) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.clients[c] {
+		return
+	}
+	select {
+	case c.out <- f:
+	default:
+	}
+}
+
+// -web のアドレスを実際に待ち受けるアドレスにする
+// ホストを省いたアドレス (:8080) はこの機械からしかつなげない 127.0.0.1 にする。
+// ほかのインターフェースで待ち受けるのは public (-web-public) のときだけで、そうでなければエラーにする
+func webListenAddr(addr string, public bool) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if public {
+		return addr, nil
+	}
+	if host == "" {
+		return net.JoinHostPort("127.0.0.1", port), nil
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return "", fmt.Errorf("-web %s would accept connections from other machines; add -web-public to allow it", addr)
+	}
+	return addr, nil
+}
+
+// ループバックだけで待ち受けるとき、Host ヘッダーがこの機械の名前と待ち受けたポートでないリクエストを断る
+// DNS の再束縛で自分の名前を 127.0.0.1 に向けたページは Origin も Host もその名前になり、sameOrigin では防げないため
+func loopbackOnly(next http.Handler, port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !loopbackHost(r.Host, port) {
+			logs.warnf("net", "refused a request for host %s", r.Host)
+			http.Error(w, "host not allowed", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Host ヘッダー host が localhost・127.0.0.1・[::1] と port か (80 ならポートは省かれることがある)
+func loopbackHost(host, port string) bool {
+	name, p, err := net.SplitHostPort(host)
+	if err != nil {
+		name, p = strings.Trim(host, "[]"), "80"
+	}
+	if p != port {
+		return false
+	}
+	switch strings.ToLower(name) {
+	case "localhost", "127.0.0.1", "::1":
+		return true
+	}
+	return false
+}
+
+// ブラウザ用の HTTP サーバーを起動する
+// 待ち受けに失敗した場合はすぐにエラーを返し、以降の処理はバックグラウンドで行う
+// instructor が nil でなければ教官席も開く。public (-web-public) でなければ loopbackOnly を通す
+func serveWeb(ctx context.Context, addr string, public bool, m *mirrorTerminal, instructor *instructorStation) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(webIndexHTML))
+	})
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, br, err := wsUpgrade(w, r)
+		if err != nil {
+			return
+		}
+		if v, _ := strconv.Atoi(r.URL.Query().Get("protocol")); v != wsProtocolVersion {
+			// 古いページのままのブラウザには読み直してもらう
+			logs.warnf("net", "rejected a client speaking protocol %d (want %d)", v, wsProtocolVersion)
+			data, _ := json.Marshal(wsServerMessage{Type: "error", Protocol: wsProtocolVersion, Message: "protocol version mismatch, reload the page"})
+			wsWriteFrame(conn, wsOpText, data)
+			wsWriteFrame(conn, wsOpClose, nil)
+			conn.Close()
+			return
+		}
+		m.serveClient(ctx, conn, br, stationID(r.URL.Query().Get("station")))
+	})
+	if instructor != nil {
+		instructor.register(mux)
+	}
+
+	var handler http.Handler = mux
+	if !public {
+		_, port, _ := net.SplitHostPort(ln.Addr().String())
+		handler = loopbackOnly(mux, port)
+	}
+	srv := &http.Server{Handler: handler}
+	go srv.Serve(ln)
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	return nil
+}
+
+func (m *mirrorTerminal) serveClient(ctx context.Context, conn net.Conn, br *bufio.Reader, station string) {
+	c := m.addClient(conn, station)
+	m.sessions.connect(station)
+	defer m.sessions.disconnect(station)
+	defer m.removeClient(c)
+
+	// 書き込み側
+	go func() {
+		for f := range c.out {
+			if err := wsWriteFrame(conn, f.opcode, f.payload); err != nil {
+				m.removeClient(c)
+				return
+			}
+		}
+	}()
+
+	// 読み込み側: ブラウザのキー入力を termdash のイベントに変換する
+	for {
+		opcode, payload, err := wsReadFrame(br)
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case wsOpClose:
+			return
+		case wsOpPing:
+			m.send(c, wsFrame{wsOpPong, payload})
+		case wsOpText, wsOpBinary:
+			var msg wsClientMessage
+			if err := json.Unmarshal(payload, &msg); err != nil {
+				continue
+			}
+			switch msg.Type {
+			case "resync":
+				m.mu.Lock()
+				m.resync(c)
+				m.mu.Unlock()
+			case "input":
+				for _, ev := range parseBrowserInput(msg.Data) {
+					select {
+					case m.input <- ev:
+					case <-ctx.Done():
+						return
+					}
+				}
+				if msg.Seq > 0 {
+					data, _ := json.Marshal(wsServerMessage{Type: "ack", Seq: msg.Seq})
+					m.send(c, wsFrame{wsOpText, data})
+				}
+			}
+		}
+	}
+}
+
+// xterm.js が送ってくる文字列をキーボードイベントに変換する
+func parseBrowserInput(data string) []terminalapi.Event {
+	sequences := map[string]keyboard.Key{
+		"\x1b[A":  keyboard.KeyArrowUp,
+		"\x1b[B":  keyboard.KeyArrowDown,
+		"\x1b[C":  keyboard.KeyArrowRight,
+		"\x1b[D":  keyboard.KeyArrowLeft,
+		"\x1b[5~": keyboard.KeyPgUp,
+		"\x1b[6~": keyboard.KeyPgDn,
+	}
+	if k, ok := sequences[data]; ok {
+		return []terminalapi.Event{&terminalapi.Keyboard{Key: k}}
+	}
+
+	var events []terminalapi.Event
+	for _, r := range data {
+		var k keyboard.Key
+		switch r {
+		case '\r', '\n':
+			k = keyboard.KeyEnter
+		case '\t':
+			k = keyboard.KeyTab
+		case 0x1b:
+			k = keyboard.KeyEsc
+		case 0x7f:
+			k = keyboard.KeyBackspace2
+		default:
+			k = keyboard.Key(r)
+		}
+		events = append(events, &terminalapi.Keyboard{Key: k})
+	}
+	return events
+}
+
+const webIndexHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>ExplorerGame</title>
+<link rel="stylesheet" href="https://unpkg.com/xterm@4.19.0/css/xterm.css">
+<script src="https://unpkg.com/xterm@4.19.0/lib/xterm.js"></script>
+<style>
+  html, body { margin: 0; height: 100%; background: #000; }
+  #terminal { padding: 8px; }
+  #status { padding: 0 8px; color: #0c0; font: 14px monospace; white-space: pre; }
+</style>
+</head>
+<body>
+<div id="terminal"></div>
+<div id="status"></div>
+<script>
+  var PROTOCOL = 3;
+  // 再接続を試し続ける時間 (ms)。サーバーの stationGracePeriod と合わせる
+  var GRACE = 60000;
+  var term = new Terminal({ cols: 160, rows: 48, cursorBlink: false });
+  term.open(document.getElementById('terminal'));
+  var proto = location.protocol === 'https:' ? 'wss://' : 'ws://';
+  var station = sessionStorage.getItem('station') || '';
+  var ws = null, lostAt = 0, delay = 500, fatal = false;
+  function connect() {
+    ws = new WebSocket(proto + location.host + '/ws?protocol=' + PROTOCOL + '&station=' + encodeURIComponent(station));
+    ws.binaryType = 'arraybuffer';
+    ws.onmessage = function (ev) {
+      if (typeof ev.data !== 'string') {
+        term.write(new Uint8Array(ev.data));
+        return;
+      }
+      var msg = JSON.parse(ev.data);
+      if (msg.type === 'error') {
+        fatal = true;
+        term.write('\r\n\x1b[31m[' + msg.message + ' (server protocol ' + msg.protocol + ')]\x1b[0m\r\n');
+        return;
+      }
+      if (msg.type === 'instruments') {
+        instruments(msg);
+        return;
+      }
+      if (msg.type === 'ack') {
+        if (pending[msg.seq]) {
+          rtt = Date.now() - pending[msg.seq];
+          delete pending[msg.seq];
+        }
+        return;
+      }
+      if (msg.type === 'hello') {
+        station = msg.station;
+        sessionStorage.setItem('station', station);
+        lostAt = 0;
+        delay = 500;
+        pending = {};
+        term.reset();
+      }
+      term.resize(msg.cols, msg.rows);
+    };
+    ws.onclose = function () {
+      if (fatal) { return; }
+      if (!lostAt) {
+        lostAt = Date.now();
+        term.write('\r\n\x1b[33m[connection lost, reconnecting...]\x1b[0m\r\n');
+      }
+      if (Date.now() - lostAt > GRACE) {
+        term.write('\r\n\x1b[31m[connection closed]\x1b[0m\r\n');
+        return;
+      }
+      setTimeout(connect, delay);
+      delay = Math.min(delay * 2, 5000);
+    };
+  }
+  connect();
+  // 遅れの補い (latency.go と同じ)。計器の値を変わる速さから先読みし、新しい値へは BLEND ms かけて寄せる
+  var PREDICT_LIMIT = 1000, BLEND = 250;
+  var last = null, at = 0, from = null, seq = 0, pending = {}, rtt = 0;
+  function norm(b) { return ((b % 360) + 360) % 360; }
+  function rel(b) { b = norm(b); return b > 180 ? b - 360 : b; }
+  function predict(now) {
+    var age = now - at, ahead = Math.min(age, PREDICT_LIMIT) / 1000, w = Math.min(age / BLEND, 1);
+    var speed = last.speed + last.accel * ahead, heading = norm(last.heading + last.turn * ahead), depth = last.depth + last.climb * ahead;
+    return {
+      speed: from.speed + (speed - from.speed) * w,
+      heading: norm(from.heading + rel(heading - from.heading) * w),
+      depth: from.depth + (depth - from.depth) * w,
+      rpm: from.rpm + (last.rpm - from.rpm) * w
+    };
+  }
+  function instruments(msg) {
+    var now = Date.now();
+    from = last ? predict(now) : msg;
+    last = msg;
+    at = now;
+  }
+  function pad(s, n) { s = String(s); while (s.length < n) { s = ' ' + s; } return s; }
+  function draw() {
+    var line = 'SPD  --.- kt  HDG ---  DEPTH --- m  RPM ---';
+    if (last) {
+      var r = predict(Date.now());
+      line = 'SPD ' + pad(r.speed.toFixed(1), 5) + ' kt  HDG ' + ('00' + Math.round(r.heading) % 360).slice(-3) +
+        '  DEPTH ' + Math.round(r.depth) + ' m  RPM ' + pad(Math.round(r.rpm), 3);
+    }
+    var n = Object.keys(pending).length;
+    if (rtt) { line += ' | link ' + rtt + ' ms'; }
+    if (n === 1) { line += ' | 1 order sending'; } else if (n > 1) { line += ' | ' + n + ' orders sending'; } else if (rtt) { line += ' | orders acknowledged'; }
+    document.getElementById('status').textContent = line;
+    requestAnimationFrame(draw);
+  }
+  requestAnimationFrame(draw);
+  term.onData(function (data) {
+    if (ws.readyState === WebSocket.OPEN) {
+      seq++;
+      pending[seq] = Date.now();
+      ws.send(JSON.stringify({ type: 'input', data: data, seq: seq }));
+    }
+  });
+  document.addEventListener('visibilitychange', function () {
+    if (!document.hidden && ws.readyState === WebSocket.OPEN) { ws.send(JSON.stringify({ type: 'resync' })); }
+  });
+  term.focus();
+</script>
+</body>
+</html>
+`
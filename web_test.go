@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebListenAddr(t *testing.T) {
+	tests := []struct {
+		addr    string
+		public  bool
+		want    string
+		wantErr bool
+	}{
+		{":8080", false, "127.0.0.1:8080", false},
+		{"127.0.0.1:8080", false, "127.0.0.1:8080", false},
+		{"localhost:8080", false, "localhost:8080", false},
+		{"[::1]:8080", false, "[::1]:8080", false},
+		{"0.0.0.0:8080", false, "", true},
+		{"192.168.1.10:8080", false, "", true},
+		{":8080", true, ":8080", false},
+		{"0.0.0.0:8080", true, "0.0.0.0:8080", false},
+		{"8080", false, "", true},
+	}
+	for _, tt := range tests {
+		got, err := webListenAddr(tt.addr, tt.public)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("webListenAddr(%q, %v) = %q, %v; want %q, error %v", tt.addr, tt.public, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestLoopbackHost(t *testing.T) {
+	tests := []struct {
+		host, port string
+		want       bool
+	}{
+		{"localhost:8080", "8080", true},
+		{"LOCALHOST:8080", "8080", true},
+		{"127.0.0.1:8080", "8080", true},
+		{"[::1]:8080", "8080", true},
+		{"localhost:9090", "8080", false},
+		// DNS の再束縛: 攻撃者の名前が 127.0.0.1 を指している
+		{"attacker.example:8080", "8080", false},
+		{"127.0.0.1.attacker.example:8080", "8080", false},
+		{"localhost", "80", true},
+		{"localhost", "8080", false},
+		{"", "8080", false},
+	}
+	for _, tt := range tests {
+		if got := loopbackHost(tt.host, tt.port); got != tt.want {
+			t.Errorf("loopbackHost(%q, %q) = %v, want %v", tt.host, tt.port, got, tt.want)
+		}
+	}
+}
+
+func TestLoopbackOnlyRefusesOtherHosts(t *testing.T) {
+	h := loopbackOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "8080")
+	for host, want := range map[string]int{
+		"localhost:8080":        http.StatusOK,
+		"attacker.example:8080": http.StatusForbidden,
+	} {
+		r := httptest.NewRequest("GET", "/ws", nil)
+		r.Host = host
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("host %s: status %d, want %d", host, w.Code, want)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RFC 6455 で定められた WebSocket の最小限の実装
+// ブラウザミラーと端末クライアント (server.go) でしか使わないので、断片化フレームや拡張には対応しない
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	wsOpText   byte = 0x1
+	wsOpBinary byte = 0x2
+	wsOpClose  byte = 0x8
+	wsOpPing   byte = 0x9
+	wsOpPong   byte = 0xA
+)
+
+// 受け付けるフレームの最大サイズ (サーバーにはキー入力しか来ないので小さくてよい)
+const wsMaxPayload = 64 * 1024
+
+// 端末クライアントが受け付けるフレームの最大サイズ (画面全体の送り直しが入る大きさ)
+const wsMaxScreenPayload = 4 << 20
+
+// HTTP リクエストを WebSocket 接続にアップグレードする
+// ほかのサイトのページから開かれた接続 (Origin が Host と違う) は断る。Origin を送らない端末クライアントは受け付ける
+func wsUpgrade(w http.ResponseWriter, r *http.Request) (net.Conn, *bufio.Reader, error) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+		return nil, nil, errors.New("websocket: missing upgrade header")
+	}
+	if origin := r.Header.Get("Origin"); origin != "" && !sameOrigin(origin, r.Host) {
+		http.Error(w, "cross-origin websocket refused", http.StatusForbidden)
+		return nil, nil, fmt.Errorf("websocket: origin %s does not match host %s", origin, r.Host)
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, nil, errors.New("websocket: missing key")
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return nil, nil, errors.New("websocket: response writer cannot hijack")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	accept := base64.StdEncoding.EncodeToString(sum[:])
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	rw.WriteString("Upgrade: websocket\r\n")
+	rw.WriteString("Connection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + accept + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, rw.Reader, nil
+}
+
+// Origin ヘッダー origin のホスト (ポートを含む) が host と同じか
+func sameOrigin(origin, host string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return strings.EqualFold(u.Host, host)
+}
+
+// つなぐまでの時間の上限
+const wsDialTimeout = 5 * time.Second
+
+// addr のサーバーの path に WebSocket でつなぐ (クライアント側)
+func wsDial(addr, path string) (net.Conn, *bufio.Reader, error) {
+	conn, err := net.DialTimeout("tcp", addr, wsDialTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req := fmt.Sprintf("GET %s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", path, addr, key)
+	if _, err := io.WriteString(conn, req); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	resp.Body.Close()
+	sum := sha1.Sum([]byte(key + wsGUID))
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(sum[:]) {
+		conn.Close()
+		return nil, nil, fmt.Errorf("websocket: %s did not accept the upgrade (%s)", addr, resp.Status)
+	}
+	return conn, br, nil
+}
+
+// サーバーからクライアントへフレームを送る (サーバー側はマスクしない)
+func wsWriteFrame(w io.Writer, opcode byte, payload []byte) error {
+	return wsWrite(w, opcode, payload, nil)
+}
+
+// クライアントからサーバーへフレームを送る (クライアント側は必ずマスクする)
+func wsWriteClientFrame(w io.Writer, opcode byte, payload []byte) error {
+	mask := make([]byte, 4)
+	rand.Read(mask)
+	return wsWrite(w, opcode, payload, mask)
+}
+
+func wsWrite(w io.Writer, opcode byte, payload []byte, mask []byte) error {
+	header := []byte{0x80 | opcode}
+	var maskBit byte
+	if mask != nil {
+		maskBit = 0x80
+	}
+	n := len(payload)
+	switch {
+	case n < 126:
+		header = append(header, maskBit|byte(n))
+	case n <= 0xFFFF:
+		header = append(header, maskBit|126, byte(n>>8), byte(n))
+	default:
+		header = append(header, maskBit|127)
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(n))
+		header = append(header, ext[:]...)
+	}
+	if mask != nil {
+		header = append(header, mask...)
+		masked := make([]byte, n)
+		for i := range payload {
+			masked[i] = payload[i] ^ mask[i%4]
+		}
+		payload = masked
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// 相手からのフレームを1つ読む (マスクされていれば外す)
+func wsReadFrame(r *bufio.Reader) (byte, []byte, error) {
+	return wsReadFrameLimit(r, wsMaxPayload)
+}
+
+// limit バイトまでのフレームを1つ読む
+func wsReadFrameLimit(r *bufio.Reader, limit uint64) (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := head[0] & 0x0F
+	masked := head[1]&0x80 != 0
+	length := uint64(head[1] & 0x7F)
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > limit {
+		return 0, nil, errors.New("websocket: frame too large")
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return opcode, payload, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+// 書いたフレームを読み戻すと同じ中身になり、長さに応じた形で書かれる
+func TestWebSocketFrameRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		opcode byte
+		n      int
+		client bool
+		// 書かれたフレームの長さ (ヘッダーを含む)
+		size int
+	}{
+		{"empty", wsOpPing, 0, false, 2},
+		{"short", wsOpText, 125, false, 2 + 125},
+		{"16-bit length", wsOpBinary, 126, false, 4 + 126},
+		{"16-bit max", wsOpBinary, 0xFFFF, false, 4 + 0xFFFF},
+		{"64-bit length", wsOpBinary, 0x10000, false, 10 + 0x10000},
+		{"masked short", wsOpText, 5, true, 2 + 4 + 5},
+		{"masked 16-bit", wsOpText, 300, true, 4 + 4 + 300},
+		{"close", wsOpClose, 2, true, 2 + 4 + 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := make([]byte, tt.n)
+			for i := range payload {
+				payload[i] = byte(i * 7)
+			}
+			var buf bytes.Buffer
+			write := wsWriteFrame
+			if tt.client {
+				write = wsWriteClientFrame
+			}
+			if err := write(&buf, tt.opcode, payload); err != nil {
+				t.Fatal(err)
+			}
+			if buf.Len() != tt.size {
+				t.Errorf("frame is %d bytes, want %d", buf.Len(), tt.size)
+			}
+			raw := buf.Bytes()
+			if raw[0] != 0x80|tt.opcode {
+				t.Errorf("first byte %#x, want FIN and opcode %#x", raw[0], tt.opcode)
+			}
+			if masked := raw[1]&0x80 != 0; masked != tt.client {
+				t.Errorf("mask bit %v, want %v", masked, tt.client)
+			}
+
+			op, got, err := wsReadFrameLimit(bufio.NewReader(&buf), wsMaxScreenPayload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if op != tt.opcode || !bytes.Equal(got, payload) {
+				t.Errorf("read opcode %#x, %d bytes; want %#x, %d bytes", op, len(got), tt.opcode, len(payload))
+			}
+		})
+	}
+}
+
+// 手で組んだフレーム (RFC 6455 5.7 の例) も読める
+func TestWebSocketReadFrame(t *testing.T) {
+	tests := []struct {
+		name   string
+		frame  []byte
+		opcode byte
+		want   string
+	}{
+		{"unmasked text", []byte{0x81, 0x05, 'H', 'e', 'l', 'l', 'o'}, wsOpText, "Hello"},
+		{"masked text", []byte{0x81, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58}, wsOpText, "Hello"},
+		{"unmasked ping", []byte{0x89, 0x05, 'H', 'e', 'l', 'l', 'o'}, wsOpPing, "Hello"},
+	}
+	for _, tt := range tests {
+		op, got, err := wsReadFrame(bufio.NewReader(bytes.NewReader(tt.frame)))
+		if err != nil || op != tt.opcode || string(got) != tt.want {
+			t.Errorf("%s: read %#x %q, %v; want %#x %q", tt.name, op, got, err, tt.opcode, tt.want)
+		}
+	}
+}
+
+// 上限を超えるフレームや途中で切れたフレームは読まない
+func TestWebSocketReadFrameErrors(t *testing.T) {
+	var big bytes.Buffer
+	wsWriteFrame(&big, wsOpBinary, make([]byte, wsMaxPayload+1))
+	tests := []struct {
+		name  string
+		frame []byte
+	}{
+		{"too large", big.Bytes()},
+		{"64-bit length over the limit", []byte{0x82, 0x7f, 0x80, 0, 0, 0, 0, 0, 0, 0}},
+		{"truncated header", []byte{0x81}},
+		{"truncated length", []byte{0x82, 0x7e, 0x01}},
+		{"truncated mask", []byte{0x81, 0x85, 0x37, 0xfa}},
+		{"truncated payload", []byte{0x81, 0x05, 'H', 'e'}},
+	}
+	for _, tt := range tests {
+		if op, got, err := wsReadFrame(bufio.NewReader(bytes.NewReader(tt.frame))); err == nil {
+			t.Errorf("%s: read %#x %q without error", tt.name, op, got)
+		}
+	}
+}
+
+func TestSameOrigin(t *testing.T) {
+	tests := []struct {
+		origin, host string
+		want         bool
+	}{
+		{"http://localhost:8080", "localhost:8080", true},
+		{"http://LOCALHOST:8080", "localhost:8080", true},
+		{"https://127.0.0.1:8080", "127.0.0.1:8080", true},
+		{"http://[::1]:8080", "[::1]:8080", true},
+		{"http://localhost:9090", "localhost:8080", false},
+		{"http://localhost", "localhost:8080", false},
+		{"http://attacker.example", "localhost:8080", false},
+		{"http://localhost:8080.attacker.example", "localhost:8080", false},
+		{"null", "localhost:8080", false},
+		{"localhost:8080", "localhost:8080", false},
+		{"", "localhost:8080", false},
+		{"http://%zz", "localhost:8080", false},
+	}
+	for _, tt := range tests {
+		if got := sameOrigin(tt.origin, tt.host); got != tt.want {
+			t.Errorf("sameOrigin(%q, %q) = %v, want %v", tt.origin, tt.host, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package world
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 海底の散乱物 (海藻の森と沈船)
+//
+// 海域を ChunkSize 四方の区画に分け、区画ごとに海藻が茂るかどうかと沈船の有無を決める。
+// 海藻は茂る区画のうち浅いところにだけ生え、沈船はその周りだけ少し浅い硬い海底になる。
+
+const (
+	// 区画の大きさ (m)
+	ChunkSize = 1000.0
+	// 海藻が茂る区画の割合
+	kelpChance = 0.5
+	// 海藻が生える最大の水深 (m)
+	kelpMaxDepth = 60.0
+	// 沈船がある区画の割合
+	wreckChance = 0.02
+)
+
+// 沈船
+type wreck struct {
+	center sim.Point3D
+	// 散らばった残骸の範囲 (m)
+	radius float64
+	// 海底から突き出た高さ (m)
+	height float64
+}
+
+// 区画
+type chunk struct {
+	kelp   bool
+	wrecks []wreck
+}
+
+// 海域の区画の数 (一辺)
+const chunkCount = int(2 * Extent / ChunkSize)
+
+// 区画を作る
+func (t *Terrain) generateChunks(rng *rand.Rand) {
+	t.chunks = make([]chunk, chunkCount*chunkCount)
+	for i := range t.chunks {
+		c := &t.chunks[i]
+		c.kelp = rng.Float64() < kelpChance
+		if rng.Float64() < wreckChance {
+			x0 := float64(i%chunkCount)*ChunkSize - Extent
+			y0 := float64(i/chunkCount)*ChunkSize - Extent
+			c.wrecks = append(c.wrecks, wreck{
+				center: sim.Point3D{X: x0 + rng.Float64()*ChunkSize, Y: y0 + rng.Float64()*ChunkSize},
+				radius: 40 + rng.Float64()*80,
+				height: 5 + rng.Float64()*10,
+			})
+		}
+	}
+}
+
+// (x, y) を含む区画 (海域の外なら nil)
+func (t *Terrain) chunkAt(x, y float64) *chunk {
+	ix := int(math.Floor((x + Extent) / ChunkSize))
+	iy := int(math.Floor((y + Extent) / ChunkSize))
+	if ix < 0 || iy < 0 || ix >= chunkCount || iy >= chunkCount {
+		return nil
+	}
+	return &t.chunks[iy*chunkCount+ix]
+}
+
+// 散乱物を水深と海底の種類に重ねる
+func (t *Terrain) clutter(x, y, depth float64, kind sim.BottomType) (float64, sim.BottomType) {
+	c := t.chunkAt(x, y)
+	if c == nil {
+		return depth, kind
+	}
+	p := sim.Point3D{X: x, Y: y}
+	// 沈船は区画の端からはみ出すことがあるので、隣の区画も見る
+	for dx := -1.0; dx <= 1; dx++ {
+		for dy := -1.0; dy <= 1; dy++ {
+			n := t.chunkAt(x+dx*ChunkSize, y+dy*ChunkSize)
+			if n == nil {
+				continue
+			}
+			for _, w := range n.wrecks {
+				if d := sim.HorizontalDistance(p, w.center); d <= w.radius {
+					return math.Max(depth-w.height*(1-d/w.radius), minDepth), sim.BottomWreck
+				}
+			}
+		}
+	}
+	if c.kelp && depth <= kelpMaxDepth {
+		return depth, sim.BottomKelp
+	}
+	return depth, kind
+}
@@ -0,0 +1,58 @@
+package world
+
+import (
+	"math"
+	"math/rand"
+)
+
+// 海流
+//
+// 海域全体をゆっくり流れる海流を、地形と一緒に決める。向きと速さが海域ごとに決まった流れに、
+// 流線関数のノイズから求めた蛇行を重ねる (流線関数から求めるので、流れが湧き出したり消えたりしない)。
+// 出発地点の周りは地形と同じく穏やかで、流れは homeInner から homeOuter の間で強まる。
+// 地形と哨戒区域を作った後の乱数で作るので、海流を足しても同じ種から同じ地形と哨戒区域ができる。
+
+const (
+	// 海域全体の流れの速さ (m/s) の範囲
+	currentMinSpeed = 0.15
+	currentMaxSpeed = 0.5
+	// 蛇行の強さ (m/s) と大きさの尺度 (m)
+	currentMeander = 0.3
+	currentScale   = 12000.0
+	// 流線関数を微分するときの刻み (m)
+	currentStep = 100.0
+)
+
+type currentField struct {
+	// 海域全体の流れ (東向き, 北向き m/s)
+	east, north float64
+	meander     *valueNoise
+}
+
+// 海流を作る
+func (t *Terrain) generateCurrent(rng *rand.Rand) {
+	rad := rng.Float64() * 2 * math.Pi
+	speed := currentMinSpeed + rng.Float64()*(currentMaxSpeed-currentMinSpeed)
+	t.current = currentField{east: math.Sin(rad) * speed, north: math.Cos(rad) * speed, meander: newValueNoise(rng)}
+}
+
+// 蛇行の流線関数 (m²/s)
+func (c currentField) stream(x, y float64) float64 {
+	return currentMeander * currentScale * c.meander.fbm(x/currentScale, y/currentScale, 2)
+}
+
+// (x, y) での海流 (東向き, 北向き m/s)。sim.World.Current に渡せる
+func (t *Terrain) CurrentAt(x, y float64) (east, north float64) {
+	r := math.Hypot(x, y)
+	if r <= homeInner {
+		return 0, 0
+	}
+	c := t.current
+	east = c.east + (c.stream(x, y+currentStep)-c.stream(x, y-currentStep))/(2*currentStep)
+	north = c.north - (c.stream(x+currentStep, y)-c.stream(x-currentStep, y))/(2*currentStep)
+	if r < homeOuter {
+		w := smooth((r - homeInner) / (homeOuter - homeInner))
+		east, north = east*w, north*w
+	}
+	return east, north
+}
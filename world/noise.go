@@ -0,0 +1,61 @@
+package world
+
+import (
+	"math"
+	"math/rand"
+)
+
+// 格子点に乱数を置いて滑らかにつなぐ2次元のノイズ (value noise)
+type valueNoise struct {
+	perm   [512]int
+	values [256]float64
+}
+
+func newValueNoise(rng *rand.Rand) *valueNoise {
+	n := &valueNoise{}
+	for i, p := range rng.Perm(256) {
+		n.perm[i] = p
+		n.perm[i+256] = p
+	}
+	for i := range n.values {
+		n.values[i] = rng.Float64()*2 - 1
+	}
+	return n
+}
+
+// 格子点 (ix, iy) の値 (-1 ~ 1)
+func (n *valueNoise) lattice(ix, iy int) float64 {
+	return n.values[n.perm[n.perm[ix&255]+iy&255]]
+}
+
+// 0 から 1 へ滑らかに変わる補間の重み
+func smooth(t float64) float64 {
+	return t * t * (3 - 2*t)
+}
+
+func lerp(a, b, t float64) float64 {
+	return a + (b-a)*t
+}
+
+// (x, y) の値 (-1 ~ 1)。格子の間隔が 1
+func (n *valueNoise) at(x, y float64) float64 {
+	fx, fy := math.Floor(x), math.Floor(y)
+	ix, iy := int(fx), int(fy)
+	tx, ty := smooth(x-fx), smooth(y-fy)
+	top := lerp(n.lattice(ix, iy), n.lattice(ix+1, iy), tx)
+	bottom := lerp(n.lattice(ix, iy+1), n.lattice(ix+1, iy+1), tx)
+	return lerp(top, bottom, ty)
+}
+
+// 細かさの違うノイズを重ねたもの (fBm, -1 ~ 1)
+// 大きな起伏の上に小さな起伏が乗る
+func (n *valueNoise) fbm(x, y float64, octaves int) float64 {
+	sum, amplitude, total := 0.0, 1.0, 0.0
+	for i := 0; i < octaves; i++ {
+		sum += n.at(x, y) * amplitude
+		total += amplitude
+		x, y = x*2, y*2
+		amplitude /= 2
+	}
+	return sum / total
+}
@@ -0,0 +1,65 @@
+package world
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 敵の哨戒区域
+//
+// 出発地点から離れたところに、水上艦と潜水艦が回る航路を置く。地形を作った後の乱数で作るので、
+// 同じ種からは同じ地形と同じ哨戒区域ができ、哨戒区域を足しても地形は変わらない。
+
+const (
+	surfacePatrols   = 2
+	submarinePatrols = 1
+	// 哨戒区域の半径 (m) と航路の点の数
+	patrolRadius    = 5000.0
+	patrolWaypoints = 4
+	// 潜水艦の航路はこれより深い海に置く (m)
+	submarineMinDepth = 100.0
+)
+
+type Patrol struct {
+	// 潜水艦の哨戒区域か (でなければ水上艦)
+	Submarine bool
+	// 順に回る航路
+	Route []sim.Point3D
+}
+
+// 哨戒区域を作る
+func (t *Terrain) generatePatrols(rng *rand.Rand) {
+	for i := 0; i < surfacePatrols+submarinePatrols; i++ {
+		submarine := i >= surfacePatrols
+		// 出発地点の穏やかな海の外に置く
+		var center sim.Point3D
+		for {
+			center = sim.Point3D{X: (rng.Float64()*2 - 1) * (Extent - patrolRadius), Y: (rng.Float64()*2 - 1) * (Extent - patrolRadius)}
+			if math.Hypot(center.X, center.Y) >= homeOuter+patrolRadius {
+				break
+			}
+		}
+		p := Patrol{Submarine: submarine}
+		for j := 0; j < patrolWaypoints; j++ {
+			rad := (float64(j) + rng.Float64()*0.5) / patrolWaypoints * 2 * math.Pi
+			r := patrolRadius * (0.5 + rng.Float64()*0.5)
+			wp := sim.Point3D{X: center.X + math.Sin(rad)*r, Y: center.Y + math.Cos(rad)*r}
+			if depth, _ := t.SeabedAt(wp.X, wp.Y); submarine && depth < submarineMinDepth {
+				continue
+			}
+			p.Route = append(p.Route, wp)
+		}
+		if len(p.Route) == 0 {
+			// 浅すぎて置けなければ中心だけにする
+			p.Route = []sim.Point3D{center}
+		}
+		t.patrols = append(t.patrols, p)
+	}
+}
+
+// 哨戒区域の一覧
+func (t *Terrain) Patrols() []Patrol {
+	return t.patrols
+}
@@ -0,0 +1,156 @@
+// Package world は海域の地形 (海底の起伏) を乱数の種から作る。
+//
+// 起伏のある海盆に、海嶺 (細長い高まり)、海溝 (細長い深み)、浅瀬・海山 (丸い高まり) を置き、
+// 区画ごとに海藻の森と沈船を散らす (clutter.go)。同じ種からは同じ地形ができる。出発地点 (原点) の周りは sim.GentleSeabed の穏やかな海底につながる。
+// 敵の哨戒区域と海域全体の海流も地形と一緒に決める (patrol.go, current.go)。
+package world
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/rs0604/explorergame/sim"
+)
+
+const (
+	// 地形を作る範囲。座標は X, Y とも ±Extent (m)
+	Extent = 30000.0
+	// 海盆の平均の水深と起伏 (m)
+	basinDepth  = 330.0
+	basinRelief = 130.0
+	// 起伏の大きさの尺度 (m)
+	basinScale = 7000.0
+	// 出発地点の周りの穏やかな海底の範囲 (m)。この間で生成した地形に移る
+	homeInner = 6000.0
+	homeOuter = 12000.0
+	// これより浅くはならない (m)
+	minDepth = 8.0
+)
+
+// 海嶺と海溝
+type ridge struct {
+	a, b sim.Point3D
+	// 幅 (m)
+	width float64
+	// 水深の変化 (m)。海嶺は負 (浅くなる)、海溝は正
+	height float64
+}
+
+// 浅瀬と海山
+type shoal struct {
+	center sim.Point3D
+	radius float64
+	// 頂上の水深 (m)
+	top float64
+}
+
+type Terrain struct {
+	seed    int64
+	noise   *valueNoise
+	detail  *valueNoise
+	ridges  []ridge
+	shoals  []shoal
+	chunks  []chunk
+	patrols []Patrol
+	current currentField
+	gentle  sim.GentleSeabed
+}
+
+// seed から地形を作る
+func Generate(seed int64) *Terrain {
+	rng := rand.New(rand.NewSource(seed))
+	t := &Terrain{seed: seed, noise: newValueNoise(rng), detail: newValueNoise(rng)}
+	point := func() sim.Point3D {
+		return sim.Point3D{X: (rng.Float64()*2 - 1) * Extent, Y: (rng.Float64()*2 - 1) * Extent}
+	}
+	segment := func(minLength, maxLength float64) (sim.Point3D, sim.Point3D) {
+		a := point()
+		length := minLength + rng.Float64()*(maxLength-minLength)
+		rad := rng.Float64() * 2 * math.Pi
+		return a, sim.Point3D{X: a.X + math.Sin(rad)*length, Y: a.Y + math.Cos(rad)*length}
+	}
+	// 海嶺
+	for i := 0; i < 3; i++ {
+		a, b := segment(10000, 25000)
+		t.ridges = append(t.ridges, ridge{a: a, b: b, width: 800 + rng.Float64()*700, height: -(120 + rng.Float64()*100)})
+	}
+	// 海溝
+	for i := 0; i < 2; i++ {
+		a, b := segment(15000, 30000)
+		t.ridges = append(t.ridges, ridge{a: a, b: b, width: 1000 + rng.Float64()*1000, height: 300 + rng.Float64()*300})
+	}
+	// 浅瀬と海山
+	for i := 0; i < 5; i++ {
+		t.shoals = append(t.shoals, shoal{center: point(), radius: 1000 + rng.Float64()*2500, top: 15 + rng.Float64()*80})
+	}
+	t.generateChunks(rng)
+	t.generatePatrols(rng)
+	t.generateCurrent(rng)
+	return t
+}
+
+func (t *Terrain) Seed() int64 {
+	return t.seed
+}
+
+// 線分 ab から p までの水平距離
+func distanceToSegment(p, a, b sim.Point3D) float64 {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	length2 := dx*dx + dy*dy
+	if length2 == 0 {
+		return sim.HorizontalDistance(p, a)
+	}
+	u := math.Max(0, math.Min(1, ((p.X-a.X)*dx+(p.Y-a.Y)*dy)/length2))
+	return sim.HorizontalDistance(p, sim.Point3D{X: a.X + u*dx, Y: a.Y + u*dy})
+}
+
+// 生成した地形の水深と種類
+func (t *Terrain) generated(x, y float64) (float64, sim.BottomType) {
+	p := sim.Point3D{X: x, Y: y}
+	depth := basinDepth + basinRelief*t.noise.fbm(x/basinScale, y/basinScale, 4)
+	// 高まりの上は流れで泥が流され、岩や砂が出る
+	exposed := 0.0
+	for _, r := range t.ridges {
+		d := distanceToSegment(p, r.a, r.b) / r.width
+		f := math.Exp(-d * d)
+		depth += r.height * f
+		if r.height < 0 {
+			exposed = math.Max(exposed, f)
+		}
+	}
+	for _, s := range t.shoals {
+		d := sim.HorizontalDistance(p, s.center) / s.radius
+		f := math.Exp(-d * d)
+		depth = lerp(depth, s.top, f)
+		exposed = math.Max(exposed, f)
+	}
+	depth = math.Max(depth, minDepth)
+
+	grain := t.detail.at(x/600, y/600)
+	kind := sim.BottomMud
+	switch {
+	case exposed > 0.6 || grain > 0.75:
+		kind = sim.BottomRock
+	case exposed > 0.3 || depth < 150 || grain > 0.4:
+		kind = sim.BottomSand
+	}
+	return t.clutter(x, y, depth, kind)
+}
+
+// (x, y) の水深 (m) と海底の種類 (sim.Seabed)
+func (t *Terrain) SeabedAt(x, y float64) (float64, sim.BottomType) {
+	r := math.Hypot(x, y)
+	if r <= homeInner {
+		return t.gentle.SeabedAt(x, y)
+	}
+	depth, kind := t.generated(x, y)
+	if r >= homeOuter {
+		return depth, kind
+	}
+	w := smooth((r - homeInner) / (homeOuter - homeInner))
+	gentleDepth, gentleKind := t.gentle.SeabedAt(x, y)
+	if w < 0.5 {
+		kind = gentleKind
+	}
+	return lerp(gentleDepth, depth, w), kind
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+	"github.com/rs0604/explorergame/world"
+)
+
+// 沈船の調査と日誌
+//
+// 沈船の近くで行き足を止めて ROV を出すと、沈船を調べて書類や航海データ記録装置を持ち帰る。
+// 持ち帰った記録は見つけた順に日誌に加わり、この海で何があったのかを少しずつ明かす (storyDocuments)。
+//   - ROV が出られるのは行き足が rovMaxVelocity 以下で、海底まで rovTether 以内、rovReach の中に沈船があるとき
+//   - 調べ終わるまで rovInspectTime かかる (シミュレーション時間)。途中で条件が崩れると ROV を呼び戻す
+//   - 1 隻の沈船から出る記録は 1 つまで。沈船は海域の区画 (world.ChunkSize) で見分ける
+//
+// 日誌は設定ディレクトリの journal.json に残り、哨戒をまたいで続きが見つかる。見つけた記録はイベントログに全文が出て、
+// explorergame journal でいつでも読み返せる。
+
+// 日誌を保存するファイル名
+const journalFileName = "journal.json"
+
+const (
+	// ROV を出せる行き足 (ノット)
+	rovMaxVelocity = 1.0
+	// ROV のケーブルが届く海底までの高さ (m)
+	rovTether = 150.0
+	// 沈船を探す水平の範囲 (m) と、その刻み
+	rovReach     = 200.0
+	rovScanSpace = 10.0
+	// 沈船を調べるのにかかる時間 (秒)
+	rovInspectTime = 90.0
+)
+
+// 日誌の記録
+type storyDocument struct {
+	// 航海データ記録装置か (でなければ書類)
+	recorder bool
+	title    string
+	text     string
+}
+
+// 見つける順の記録
+var storyDocuments = []storyDocument{
+	{false, "Deck log, MV Corran Star",
+		"14 March. Ordered off the shipping lane by a grey warship flying no colours. The master protested and they fired across our bow. Altered course north-east, into the basin."},
+	{true, "Voyage data recorder, MV Corran Star",
+		"Bridge audio, final minutes: \"...not a torpedo, it came up from below, from the seabed itself...\" Hull breach alarm. The recording ends."},
+	{false, "Cargo manifest, RV Halcyon",
+		"40 km of armoured cable. Hydrophone modules stencilled LANTERN. Twelve crates of survey equipment, not to be opened at sea. Consignee: the Ministry of the Interior, not the Institute."},
+	{false, "Letter, Dr. I. Morrow to her sister",
+		"They have us laying listening cable across the whole basin. Every ship that passes over it and every boat beneath the surface, LANTERN hears them all. We are told it is for counting whales."},
+	{true, "Data recorder, RV Halcyon",
+		"Last fix inside the basin. The engine room reports an explosion at the cable winch. The master: \"That was no accident. Someone did not want us to finish.\""},
+	{false, "Standing orders, patrol boat HARRIER",
+		"Any vessel loitering over the LANTERN nodes is to be turned away. If it does not turn it is to be sunk, and reported lost to weather."},
+	{false, "Logbook, submarine SEA WRAITH",
+		"Our predecessor on this station. \"They always know where we are. Datum after datum, dead on us. It is as if the sea itself were listening.\""},
+	{true, "Data recorder, SEA WRAITH",
+		"Final entry, Commander Alder: \"Found the LANTERN trunk cable where the basin is deepest. Whoever reads this: cut it, and they go deaf.\""},
+}
+
+// 沈船のある区画
+type wreckSite struct {
+	X, Y int
+}
+
+func wreckSiteAt(x, y float64) wreckSite {
+	return wreckSite{X: int(math.Floor(x / world.ChunkSize)), Y: int(math.Floor(y / world.ChunkSize))}
+}
+
+// 日誌の 1 件
+type journalEntry struct {
+	// storyDocuments の番号 (1 から)
+	Document int       `json:"document"`
+	At       time.Time `json:"at"`
+	// 見つけた沈船の区画
+	SiteX int `json:"siteX"`
+	SiteY int `json:"siteY"`
+}
+
+// journal.json の中身
+type journalFile struct {
+	Documents []journalEntry `json:"documents"`
+}
+
+type journal struct {
+	path string
+
+	mu      sync.Mutex
+	entries []journalEntry
+}
+
+func newJournal(path string) (*journal, error) {
+	j := &journal{path: path}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		var f journalFile
+		if err := json.Unmarshal(data, &f); err != nil {
+			return nil, err
+		}
+		j.entries = f.Documents
+	}
+	return j, nil
+}
+
+// 沈船 site から記録を持ち帰ったか
+func (j *journal) searched(site wreckSite) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, e := range j.entries {
+		if e.SiteX == site.X && e.SiteY == site.Y {
+			return true
+		}
+	}
+	return false
+}
+
+// 沈船 site で見つけた次の記録を日誌に加えて保存する。記録をすべて見つけていれば false
+func (j *journal) unlock(site wreckSite) (storyDocument, int, bool, error) {
+	j.mu.Lock()
+	n := len(j.entries) + 1
+	if n > len(storyDocuments) {
+		j.mu.Unlock()
+		return storyDocument{}, 0, false, nil
+	}
+	j.entries = append(j.entries, journalEntry{Document: n, At: time.Now(), SiteX: site.X, SiteY: site.Y})
+	data, err := json.MarshalIndent(journalFile{Documents: j.entries}, "", "  ")
+	j.mu.Unlock()
+	if err != nil {
+		return storyDocument{}, 0, false, err
+	}
+	return storyDocuments[n-1], n, true, ioutil.WriteFile(j.path, data, 0644)
+}
+
+// ROV
+type wreckSurvey struct {
+	events  *eventLog
+	journal *journal
+
+	mu     sync.Mutex
+	active bool
+	// 調べている沈船と、終わるまでの残り (秒)
+	site      wreckSite
+	remaining float64
+}
+
+func newWreckSurvey(events *eventLog, j *journal) *wreckSurvey {
+	return &wreckSurvey{events: events, journal: j}
+}
+
+// ROV が出られない理由 (出られるなら空)
+func rovRestriction(p *Player) string {
+	seabed, _ := terrain.SeabedAt(p.Position.X, p.Position.Y)
+	switch {
+	case p.Velocity > rovMaxVelocity:
+		return "the boat is making way"
+	case seabed-p.Depth() > rovTether:
+		return "the bottom is beyond the ROV's tether"
+	}
+	return ""
+}
+
+// ROV が届く範囲で最も近い沈船の区画
+func nearestWreck(pos sim.Point3D) (wreckSite, bool) {
+	best := math.Inf(1)
+	var site wreckSite
+	for dx := -rovReach; dx <= rovReach; dx += rovScanSpace {
+		for dy := -rovReach; dy <= rovReach; dy += rovScanSpace {
+			d := math.Hypot(dx, dy)
+			if d > rovReach || d >= best {
+				continue
+			}
+			if _, kind := terrain.SeabedAt(pos.X+dx, pos.Y+dy); kind == sim.BottomWreck {
+				best, site = d, wreckSiteAt(pos.X+dx, pos.Y+dy)
+			}
+		}
+	}
+	return site, !math.IsInf(best, 1)
+}
+
+// ROV を出す・呼び戻す (orderInspectWreck の処理)
+func (w *wreckSurvey) setActive(p *Player, active bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !active {
+		if w.active {
+			w.active = false
+			w.events.add(cell.ColorCyan, "[ROV] ROV recalled.")
+		}
+		return nil
+	}
+	if w.active {
+		return orderRefusedError{"the ROV is already out"}
+	}
+	if reason := rovRestriction(p); reason != "" {
+		return orderRefusedError{reason}
+	}
+	site, ok := nearestWreck(p.Position)
+	if !ok {
+		return orderRefusedError{"no wreck within reach of the ROV"}
+	}
+	if w.journal.searched(site) {
+		return orderRefusedError{"this wreck has already been searched"}
+	}
+	w.active, w.site, w.remaining = true, site, rovInspectTime
+	w.events.add(cell.ColorCyan, "[ROV] ROV away. Inspecting the wreck.")
+	return nil
+}
+
+// dt 秒分だけ調べる (シミュレーション時間で進める)
+func (w *wreckSurvey) step(p *Player, dt float64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.active {
+		return nil
+	}
+	if reason := rovRestriction(p); reason != "" {
+		w.active = false
+		w.events.add(cell.ColorYellow, "[ROV] ROV recalled: %s.", reason)
+		return nil
+	}
+	w.remaining -= dt
+	if w.remaining > 0 {
+		return nil
+	}
+	w.active = false
+	doc, n, ok, err := w.journal.unlock(w.site)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		w.events.add(cell.ColorCyan, "[ROV] Wreck searched. Nothing new. ROV back aboard.")
+		return nil
+	}
+	found := "Papers"
+	if doc.recorder {
+		found = "A data recorder"
+	}
+	w.events.add(cell.ColorGreen, "[ROV] %s recovered from the wreck. Journal entry %d of %d: %s.", found, n, len(storyDocuments), doc.title)
+	w.events.add(cell.ColorDefault, "[JOURNAL] %s", doc.text)
+	return nil
+}
+
+func (w *wreckSurvey) inspecting() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.active
+}
+
+// journal サブコマンド。見つけた記録を見つけた順に書き出す
+//
+//	explorergame journal
+func runJournal(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("journal", flag.ContinueOnError)
+	fs.SetOutput(out)
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		fmt.Fprintln(out, "usage: explorergame journal")
+		return 2
+	}
+	j, err := newJournal(filepath.Join(dataDir(), journalFileName))
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 2
+	}
+	if len(j.entries) == 0 {
+		fmt.Fprintln(out, "The journal is empty. Inspect wrecks with the ROV to find documents.")
+		return 0
+	}
+	for _, e := range j.entries {
+		if e.Document < 1 || e.Document > len(storyDocuments) {
+			continue
+		}
+		doc := storyDocuments[e.Document-1]
+		fmt.Fprintf(out, "%d/%d  %s  (%s)\n%s\n\n", e.Document, len(storyDocuments), doc.title, e.At.Format("2006-01-02"), doc.text)
+	}
+	return 0
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs0604/explorergame/sim"
+	"github.com/rs0604/explorergame/world"
+)
+
+// 海面で止まっていても、海底が遠く沈船もなければ ROV は出せない
+func TestWrecks(t *testing.T) {
+	g := startGame(t)
+	g.advance(time.Second)
+	g.typeText("@")
+	g.expect("Inspect wreck refused:")
+	g.expectNot("ROV away")
+}
+
+// 平らな海底に沈船を置いた地形
+type wreckSeabed struct {
+	flatSeabed
+	wrecks []sim.Point3D
+}
+
+func (s wreckSeabed) SeabedAt(x, y float64) (float64, sim.BottomType) {
+	for _, w := range s.wrecks {
+		if math.Hypot(x-w.X, y-w.Y) <= 20 {
+			return s.depth - 10, sim.BottomWreck
+		}
+	}
+	return s.flatSeabed.SeabedAt(x, y)
+}
+
+// 深さ 200 m の泥の海底に、別の区画の沈船を 2 隻置く
+func newTestWreckSurvey(t *testing.T) (*wreckSurvey, *journal, *testEvents) {
+	t.Helper()
+	useSeabed(t, wreckSeabed{flatSeabed{200, sim.BottomMud}, []sim.Point3D{{X: 100, Y: 100}, {X: 100 + world.ChunkSize, Y: 100}}})
+
+	j, err := newJournal(filepath.Join(t.TempDir(), journalFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, te := newTestEvents(t)
+	return newWreckSurvey(events, j), j, te
+}
+
+// (x, y) の深さ 100 m で止まっている艦
+func newROVPlayer(x, y float64) *Player {
+	p := newTestPlayer()
+	p.Position = sim.Point3D{X: x, Y: y, Z: -100}
+	p.Velocity = 0
+	return p
+}
+
+// 調べ終わると記録を 1 つ持ち帰って日誌に残し、同じ沈船はもう調べない
+func TestWreckInspectionRecoversDocuments(t *testing.T) {
+	w, j, te := newTestWreckSurvey(t)
+	p := newROVPlayer(0, 0)
+	if err := w.setActive(p, true); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < rovInspectTime-1; i++ {
+		if err := w.step(p, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !w.inspecting() || len(j.entries) != 0 {
+		t.Fatalf("finished before %v s: inspecting %v, %d entries", rovInspectTime, w.inspecting(), len(j.entries))
+	}
+	if err := w.step(p, 1); err != nil {
+		t.Fatal(err)
+	}
+	if w.inspecting() {
+		t.Error("ROV still out after the inspection")
+	}
+	if !te.contains("Journal entry 1 of 8: Deck log, MV Corran Star.") || !te.contains("[JOURNAL] 14 March.") {
+		t.Errorf("first document not reported: %q", te.lines)
+	}
+
+	// 日誌はファイルに残る
+	saved, err := newJournal(j.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.entries) != 1 || saved.entries[0].Document != 1 || !saved.searched(wreckSiteAt(100, 100)) {
+		t.Errorf("saved journal = %+v", saved.entries)
+	}
+
+	err = w.setActive(p, true)
+	if _, ok := err.(orderRefusedError); !ok || err.Error() != "this wreck has already been searched" {
+		t.Errorf("searching the same wreck again: %v", err)
+	}
+
+	// 別の沈船からは次の記録が出る
+	p = newROVPlayer(world.ChunkSize, 0)
+	if err := w.setActive(p, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.step(p, rovInspectTime); err != nil {
+		t.Fatal(err)
+	}
+	if !te.contains("A data recorder recovered from the wreck. Journal entry 2 of 8: Voyage data recorder, MV Corran Star.") {
+		t.Errorf("second document not reported: %q", te.lines)
+	}
+}
+
+// 行き足がある・海底が遠い・沈船がないときは ROV を出さない
+func TestWreckInspectionRefused(t *testing.T) {
+	tests := []struct {
+		name     string
+		x, depth float64
+		velocity float64
+		reason   string
+	}{
+		{"making way", 0, 100, 2, "the boat is making way"},
+		{"beyond the tether", 0, 20, 0, "the bottom is beyond the ROV's tether"},
+		{"no wreck", 3 * rovReach, 100, 0, "no wreck within reach of the ROV"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, _, te := newTestWreckSurvey(t)
+			p := newROVPlayer(tt.x, 0)
+			p.Position.Z = -tt.depth
+			p.Velocity = tt.velocity
+			err := w.setActive(p, true)
+			if _, ok := err.(orderRefusedError); !ok || err.Error() != tt.reason {
+				t.Errorf("setActive = %v, want %q", err, tt.reason)
+			}
+			if w.inspecting() || te.contains("ROV away") {
+				t.Error("ROV sent out")
+			}
+		})
+	}
+}
+
+// 途中で行き足がつくと ROV を呼び戻し、記録は持ち帰らない
+func TestWreckInspectionRecalled(t *testing.T) {
+	w, j, te := newTestWreckSurvey(t)
+	p := newROVPlayer(0, 0)
+	if err := w.setActive(p, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.step(p, rovInspectTime/2); err != nil {
+		t.Fatal(err)
+	}
+	p.Velocity = 3
+	if err := w.step(p, rovInspectTime); err != nil {
+		t.Fatal(err)
+	}
+	if w.inspecting() || len(j.entries) != 0 {
+		t.Errorf("inspecting %v, %d entries after the boat got under way", w.inspecting(), len(j.entries))
+	}
+	if !te.contains("[ROV] ROV recalled: the boat is making way.") {
+		t.Errorf("recall not reported: %q", te.lines)
+	}
+}
+
+// 記録をすべて見つけたあとの沈船からは何も出ない
+func TestWreckInspectionJournalComplete(t *testing.T) {
+	w, j, te := newTestWreckSurvey(t)
+	for i := range storyDocuments {
+		j.entries = append(j.entries, journalEntry{Document: i + 1, SiteX: -1 - i, SiteY: -1})
+	}
+	p := newROVPlayer(0, 0)
+	if err := w.setActive(p, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.step(p, rovInspectTime); err != nil {
+		t.Fatal(err)
+	}
+	if !te.contains("[ROV] Wreck searched. Nothing new. ROV back aboard.") || len(j.entries) != len(storyDocuments) {
+		t.Errorf("%d entries, events %q", len(j.entries), te.lines)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/rs0604/explorergame/sim"
+)
+
+// 投下式水温計 (XBT)
+//
+// 変温層の深さは測ってみるまでわからない。予測探知距離は、XBT で測るまで季節の平均
+// (climatologyLayerDepth) を変温層の深さとして計算する。XBT はいったん海面に浮いてから
+// 沈みながら水温を測り、海底か最大深度に着くと音速の分布と変温層の深さを報告する。
+// 深い変温層 (thermocline.go) まで沈めば、その深さも報告する。探知そのものは常に本当の環境で決まる。
+
+const (
+	// 積んでいる XBT の数
+	xbtCount = 8
+	// 沈む速さ (m/s)
+	xbtFallRate = 6.1
+	// 測れる最大深度 (m)
+	xbtMaxDepth = 760.0
+	// 水温を記録する間隔 (m)
+	xbtSampleInterval = 10.0
+	// 海面の水温からこれだけ下がったところを変温層とみなす (度)
+	xbtLayerThreshold = 0.5
+	// 測るまで予測に使う変温層の深さ (m)
+	climatologyLayerDepth = 50.0
+	// 海面の水温が平年とこれだけ違えば知らせる (度)
+	xbtAnomalyThreshold = 0.5
+)
+
+const (
+	// 深層の水温 (度)
+	deepTemperature = 4.0
+	// 変温層より下で水温が下がっていく深さの尺度 (m)
+	thermoclineScale = 150.0
+)
+
+// (x, y) の深度 depth (m) の水温 (度)
+// 変温層までは海面と同じで、その下は深層の水温に近づいていく
+// 深い変温層 (thermocline.go) があれば、その上では seasonalDrop だけ下がった水温でほぼ一定になる
+func (e *environment) temperatureAt(x, y, depth float64) float64 {
+	surface, layer := e.surfaceTemperatureAt(x, y), e.layerDepthAt(x, y)
+	if depth <= layer {
+		return surface
+	}
+	deep := e.deepLayerDepth
+	if deep <= layer {
+		return deepTemperature + (surface-deepTemperature)*math.Exp(-(depth-layer)/thermoclineScale)
+	}
+	mixed := surface - seasonalDrop*(1-math.Exp(-(math.Min(depth, deep)-layer)/seasonalScale))
+	if depth <= deep {
+		return mixed
+	}
+	return deepTemperature + (mixed-deepTemperature)*math.Exp(-(depth-deep)/thermoclineScale)
+}
+
+// 水温 t (度)、深度 depth (m) での音速 (m/s)。塩分 35 の Medwin の式
+func soundVelocity(t, depth float64) float64 {
+	return 1449.2 + 4.6*t - 0.055*t*t + 0.00029*t*t*t + 0.016*depth
+}
+
+type xbtSample struct {
+	depth       float64
+	temperature float64
+	soundSpeed  float64
+}
+
+type xbtProbe struct {
+	position sim.Point3D
+	// 着底する深さ
+	floor   float64
+	samples []xbtSample
+}
+
+type bathythermograph struct {
+	events *eventLog
+	env    *environment
+
+	mu        sync.Mutex
+	remaining int
+	probe     *xbtProbe
+	// 最後に測った音速の分布
+	profile []xbtSample
+	// 測った変温層の深さ (測っていなければ NaN)
+	layerDepth float64
+	// 測った深い変温層の深さ (見つかっていなければ 0)
+	deepLayerDepth float64
+	measured       time.Time
+}
+
+func newBathythermograph(events *eventLog, env *environment) *bathythermograph {
+	return &bathythermograph{events: events, env: env, remaining: xbtCount, layerDepth: math.NaN()}
+}
+
+// XBT を出す (orderLaunchXBT の処理)
+func (b *bathythermograph) launch(p *Player) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.remaining == 0 {
+		return orderRefusedError{"no XBTs left"}
+	}
+	if b.probe != nil {
+		return orderRefusedError{"XBT already in the water"}
+	}
+	b.remaining--
+	waterDepth, _ := terrain.SeabedAt(p.Position.X, p.Position.Y)
+	b.probe = &xbtProbe{
+		position: sim.Point3D{X: p.Position.X, Y: p.Position.Y},
+		floor:    math.Min(waterDepth, xbtMaxDepth),
+	}
+	b.events.add(cell.ColorCyan, "[XBT] Probe away. %d left.", b.remaining)
+	return nil
+}
+
+// dt 秒分だけ XBT を沈める (シミュレーション時間で進める)
+func (b *bathythermograph) step(dt float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	probe := b.probe
+	if probe == nil {
+		return
+	}
+	depth := math.Min(-probe.position.Z+xbtFallRate*dt, probe.floor)
+	probe.position.Z = -depth
+	// 前の記録から xbtSampleInterval 沈むごとに記録する
+	for next := float64(len(probe.samples)) * xbtSampleInterval; next <= depth; next += xbtSampleInterval {
+		t := b.env.temperatureAt(probe.position.X, probe.position.Y, next)
+		probe.samples = append(probe.samples, xbtSample{depth: next, temperature: t, soundSpeed: soundVelocity(t, next)})
+	}
+	if depth < probe.floor {
+		return
+	}
+
+	b.probe = nil
+	b.profile = probe.samples
+	b.measured = clock.Now()
+	b.layerDepth = probe.floor
+	b.deepLayerDepth = 0
+	// 渦や河川水の中なら海面の水温が平年と違う
+	if diff := probe.samples[0].temperature - b.env.surfaceTemperature; math.Abs(diff) >= xbtAnomalyThreshold {
+		b.events.add(cell.ColorCyan, "[XBT] Surface %.1f°C, %+.1f°C from normal.", probe.samples[0].temperature, diff)
+	}
+	for i, s := range probe.samples {
+		if i > 0 && s.temperature < probe.samples[0].temperature-xbtLayerThreshold {
+			b.layerDepth = probe.samples[i-1].depth
+			b.events.add(cell.ColorGreen, "[XBT] Layer at %.0f m. Sound speed %.0f m/s above, %.0f m/s at %.0f m.",
+				b.layerDepth, probe.samples[0].soundSpeed, s.soundSpeed, s.depth)
+			b.findDeepLayer(probe.samples[i:])
+			return
+		}
+	}
+	// 変温層がなければ、測れたところまでは変温層より上として予測する
+	b.events.add(cell.ColorGreen, "[XBT] No layer down to %.0f m. Sound speed %.0f m/s.", probe.floor, probe.samples[0].soundSpeed)
+}
+
+// 季節の変温層より下の記録から深い変温層を探す (b.mu を保持した状態で呼ぶ)
+// 水温がほぼ一定の層の下で、また xbtDeepLayerGradient 以上ずつ下がりはじめたところ
+func (b *bathythermograph) findDeepLayer(samples []xbtSample) {
+	flat := false
+	for i := 1; i < len(samples); i++ {
+		drop := samples[i-1].temperature - samples[i].temperature
+		switch {
+		case drop < xbtDeepLayerGradient:
+			flat = true
+		case flat:
+			b.deepLayerDepth = samples[i-1].depth
+			b.events.add(cell.ColorGreen, "[XBT] Deep layer at %.0f m. Sound speed %.0f m/s.", b.deepLayerDepth, samples[i].soundSpeed)
+			return
+		}
+	}
+}
+
+// 予測に使う環境
+// 変温層の深さだけは測った値 (測っていなければ季節の平均) を使う。深い変温層は XBT で見つけるまでないものとする
+func (b *bathythermograph) estimate() *environment {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e := *b.env
+	// 渦や河川水は測った場所の値にしか表れない
+	e.features = nil
+	e.layerDepth = climatologyLayerDepth
+	if !math.IsNaN(b.layerDepth) {
+		e.layerDepth = b.layerDepth
+	}
+	e.deepLayerDepth = b.deepLayerDepth
+	return &e
+}
+
+// 予測パネルに出す XBT の状況
+func (b *bathythermograph) summary(now time.Time) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var sb strings.Builder
+	switch {
+	case b.probe != nil:
+		fmt.Fprintf(&sb, "XBT %d left, probe at %.0f m\n", b.remaining, -b.probe.position.Z)
+	case math.IsNaN(b.layerDepth):
+		fmt.Fprintf(&sb, "XBT %d left, layer not measured (climatology %.0f m)\n", b.remaining, climatologyLayerDepth)
+	default:
+		fmt.Fprintf(&sb, "XBT %d left, measured %s ago\n", b.remaining, now.Sub(b.measured).Truncate(time.Second))
+	}
+	if len(b.profile) > 0 {
+		// 深くまで測れたときは間引いて1行に収める
+		interval := 50.0
+		if b.profile[len(b.profile)-1].depth > 350 {
+			interval = 100
+		}
+		sb.WriteString("SVP")
+		for _, s := range b.profile {
+			if math.Mod(s.depth, interval) == 0 {
+				fmt.Fprintf(&sb, " %.0f:%.0f", s.depth, s.soundSpeed)
+			}
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
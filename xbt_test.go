@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 測るまでは季節の平均で予測する
+func TestXBT(t *testing.T) {
+	g := startGame(t)
+	g.advance(time.Second)
+	g.expect("layer not measured (climatology 50 m)")
+	g.expect("Sonar ping effectiveness")
+	g.key("e")
+	g.expect("[XBT] Probe away. 7 left.")
+	// 沈んでいる間は次を出せない
+	g.key("e")
+	g.expect("Launch XBT refused: XBT already in the water")
+	// 海底 (300 m) に着くと変温層の深さがわかる
+	g.advance(60 * time.Second)
+	g.expect("[XBT] Layer at 80 m.")
+	g.expect("Layer 80 m")
+	g.expect("SVP 0:")
+}